@@ -0,0 +1,293 @@
+// Copyright 2022 The casbin-neo Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/casbin/casbin-mesh/proto/command"
+	"google.golang.org/grpc"
+)
+
+// ReadPolicy decides which node serves a read request.
+type ReadPolicy = string
+
+const (
+	// LeaderOnly sends every read to the leader. This is the default.
+	LeaderOnly ReadPolicy = "LeaderOnly"
+	// RoundRobin spreads reads evenly across all configured nodes.
+	RoundRobin ReadPolicy = "RoundRobin"
+	// Nearest sends reads to the node with the lowest observed latency.
+	Nearest ReadPolicy = "Nearest"
+)
+
+var (
+	ErrNoEndpoints        = errors.New("no endpoints available")
+	ErrNoHealthyEndpoints = errors.New("no healthy endpoints available")
+)
+
+// latencyDecay is the weight given to the newest sample in the latency moving average.
+const latencyDecay = 0.2
+
+// endpoint is a single node the client is connected to.
+type endpoint struct {
+	target string
+	conn   *grpc.ClientConn
+	client command.CasbinMeshClient
+
+	breaker *breaker
+
+	// latency is the moving average of observed round-trips, in nanoseconds.
+	latency int64
+	// zone is the zone the node registered in its metadata, learned from
+	// its stats.
+	zone atomic.Value
+}
+
+// setZone updates the zone of the endpoint from the stats payload of its node.
+func (e *endpoint) setZone(payload []byte) {
+	var stats struct {
+		NodeID   string                       `json:"node_id"`
+		Metadata map[string]map[string]string `json:"metadata"`
+	}
+	if err := json.Unmarshal(payload, &stats); err != nil {
+		return
+	}
+	e.zone.Store(stats.Metadata[stats.NodeID]["zone"])
+}
+
+// inZone reports whether the node of the endpoint is known to be in zone.
+func (e *endpoint) inZone(zone string) bool {
+	z, _ := e.zone.Load().(string)
+	return z == zone
+}
+
+// observe records the round-trip time of a request against the endpoint.
+func (e *endpoint) observe(d time.Duration) {
+	for {
+		old := atomic.LoadInt64(&e.latency)
+		next := int64(d)
+		if old != 0 {
+			next = int64(float64(old)*(1-latencyDecay) + float64(d)*latencyDecay)
+		}
+		if atomic.CompareAndSwapInt64(&e.latency, old, next) {
+			return
+		}
+	}
+}
+
+// available reports whether requests may be sent to the endpoint.
+func (e *endpoint) available() bool {
+	return e.breaker == nil || e.breaker.available()
+}
+
+// balancer picks endpoints for reads according to a ReadPolicy and keeps
+// track of the current leader for writes.
+type balancer struct {
+	policy    ReadPolicy
+	endpoints []*endpoint
+	next      uint32
+	// zone, when set, makes reads prefer the endpoints in that zone.
+	zone string
+
+	mu     sync.RWMutex
+	leader *endpoint
+	dial   func(target string, opts ...grpc.DialOption) (*grpc.ClientConn, error)
+
+	// failureThreshold and cooldown configure the circuit breakers of new endpoints.
+	failureThreshold int
+	cooldown         time.Duration
+	stop             chan struct{}
+}
+
+func newBalancer(policy ReadPolicy, endpoints []*endpoint, dial func(target string, opts ...grpc.DialOption) (*grpc.ClientConn, error)) *balancer {
+	if policy == "" {
+		policy = LeaderOnly
+	}
+	return &balancer{policy: policy, endpoints: endpoints, dial: dial}
+}
+
+// connect dials target and returns it as an endpoint guarded by a circuit breaker.
+func (b *balancer) connect(target string) (*endpoint, error) {
+	e := &endpoint{target: target, breaker: newBreaker(b.failureThreshold, b.cooldown)}
+	conn, err := b.dial(target, grpc.WithChainUnaryInterceptor(e.breaker.unaryInterceptor))
+	if err != nil {
+		return nil, err
+	}
+	e.conn = conn
+	e.client = command.NewCasbinMeshClient(conn)
+	return e, nil
+}
+
+// readEndpoints returns the endpoints a read may be spread across: the
+// healthy ones in the zone of the client if there are any, all of them
+// otherwise.
+func (b *balancer) readEndpoints() []*endpoint {
+	if b.zone == "" {
+		return b.endpoints
+	}
+	var local []*endpoint
+	for _, e := range b.endpoints {
+		if e.inZone(b.zone) && e.available() {
+			local = append(local, e)
+		}
+	}
+	if len(local) == 0 {
+		return b.endpoints
+	}
+	return local
+}
+
+// pickRead returns the endpoint that should serve a read.
+func (b *balancer) pickRead(ctx context.Context) (*endpoint, error) {
+	if len(b.endpoints) == 0 {
+		return nil, ErrNoEndpoints
+	}
+	switch b.policy {
+	case RoundRobin:
+		endpoints := b.readEndpoints()
+		for range endpoints {
+			n := atomic.AddUint32(&b.next, 1)
+			if e := endpoints[(n-1)%uint32(len(endpoints))]; e.available() {
+				return e, nil
+			}
+		}
+		return nil, ErrNoHealthyEndpoints
+	case Nearest:
+		var best *endpoint
+		for _, e := range b.readEndpoints() {
+			if !e.available() {
+				continue
+			}
+			l := atomic.LoadInt64(&e.latency)
+			// Endpoints without samples yet are tried first so they get measured.
+			if l == 0 && best != nil {
+				return e, nil
+			}
+			if best == nil || l < atomic.LoadInt64(&best.latency) {
+				best = e
+			}
+		}
+		if best == nil {
+			return nil, ErrNoHealthyEndpoints
+		}
+		return best, nil
+	default:
+		return b.pickLeader(ctx)
+	}
+}
+
+// pickLeader returns the leader endpoint, discovering it if necessary.
+func (b *balancer) pickLeader(ctx context.Context) (*endpoint, error) {
+	b.mu.RLock()
+	leader := b.leader
+	b.mu.RUnlock()
+	if leader != nil && leader.available() {
+		return leader, nil
+	}
+	return b.refreshLeader(ctx)
+}
+
+// resetLeader forgets the cached leader, forcing rediscovery on the next write.
+func (b *balancer) resetLeader() {
+	b.mu.Lock()
+	b.leader = nil
+	b.mu.Unlock()
+}
+
+// refreshLeader asks the configured nodes who the leader is. If the leader is
+// not one of the configured endpoints, a connection to it is opened.
+func (b *balancer) refreshLeader(ctx context.Context) (*endpoint, error) {
+	if len(b.endpoints) == 0 {
+		return nil, ErrNoEndpoints
+	}
+	var addr string
+	for _, e := range b.endpoints {
+		if !e.available() {
+			continue
+		}
+		resp, err := e.client.ShowStats(ctx, &command.StatsRequest{})
+		if err != nil {
+			continue
+		}
+		var stats struct {
+			Leader struct {
+				Addr string `json:"addr"`
+			} `json:"leader"`
+		}
+		if err := json.Unmarshal(resp.Payload, &stats); err != nil {
+			continue
+		}
+		if stats.Leader.Addr != "" {
+			addr = stats.Leader.Addr
+			break
+		}
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	// Without a known leader fall back to the first healthy endpoint, the
+	// server will report an error if it cannot serve the request.
+	if addr == "" {
+		for _, e := range b.endpoints {
+			if e.available() {
+				return e, nil
+			}
+		}
+		return nil, ErrNoHealthyEndpoints
+	}
+	for _, e := range b.endpoints {
+		if e.target == addr {
+			b.leader = e
+			return e, nil
+		}
+	}
+	if b.leader != nil && b.leader.target == addr {
+		return b.leader, nil
+	}
+	e, err := b.connect(addr)
+	if err != nil {
+		return nil, err
+	}
+	b.leader = e
+	return b.leader, nil
+}
+
+// close closes the connections of all endpoints.
+func (b *balancer) close() error {
+	var err error
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.stop != nil {
+		close(b.stop)
+		b.stop = nil
+	}
+	closed := make(map[*grpc.ClientConn]bool)
+	for _, e := range append(b.endpoints, b.leader) {
+		if e == nil || e.conn == nil || closed[e.conn] {
+			continue
+		}
+		closed[e.conn] = true
+		if cerr := e.conn.Close(); cerr != nil {
+			err = cerr
+		}
+	}
+	return err
+}
@@ -0,0 +1,97 @@
+// Copyright 2022 The casbin-neo Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestBalancer_RoundRobin(t *testing.T) {
+	endpoints := []*endpoint{{target: "a"}, {target: "b"}, {target: "c"}}
+	b := newBalancer(RoundRobin, endpoints, nil)
+	for i := 0; i < 6; i++ {
+		e, err := b.pickRead(context.TODO())
+		if err != nil {
+			t.Fatalf("failed to pick endpoint: %s", err.Error())
+		}
+		if exp := endpoints[i%3].target; e.target != exp {
+			t.Fatalf("wrong endpoint picked, exp %s, got %s", exp, e.target)
+		}
+	}
+}
+
+func TestBalancer_Nearest(t *testing.T) {
+	endpoints := []*endpoint{{target: "a"}, {target: "b"}, {target: "c"}}
+	endpoints[0].observe(30 * time.Millisecond)
+	endpoints[1].observe(10 * time.Millisecond)
+	endpoints[2].observe(20 * time.Millisecond)
+	b := newBalancer(Nearest, endpoints, nil)
+	e, err := b.pickRead(context.TODO())
+	if err != nil {
+		t.Fatalf("failed to pick endpoint: %s", err.Error())
+	}
+	if e.target != "b" {
+		t.Fatalf("wrong endpoint picked, exp b, got %s", e.target)
+	}
+
+	// A fresh endpoint without samples is tried before the others.
+	b.endpoints = append(b.endpoints, &endpoint{target: "d"})
+	if e, _ = b.pickRead(context.TODO()); e.target != "d" {
+		t.Fatalf("unmeasured endpoint not picked, got %s", e.target)
+	}
+}
+
+func TestBalancer_NoEndpoints(t *testing.T) {
+	b := newBalancer(RoundRobin, nil, nil)
+	if _, err := b.pickRead(context.TODO()); err != ErrNoEndpoints {
+		t.Fatalf("expected ErrNoEndpoints, got %v", err)
+	}
+}
+
+func TestBalancer_PreferZone(t *testing.T) {
+	endpoints := []*endpoint{{target: "a"}, {target: "b"}, {target: "c"}}
+	endpoints[0].setZone([]byte(`{"node_id":"a","metadata":{"a":{"zone":"us-east-1a"}}}`))
+	endpoints[1].setZone([]byte(`{"node_id":"b","metadata":{"b":{"zone":"us-east-1b"}}}`))
+	endpoints[2].setZone([]byte(`{"node_id":"c","metadata":{"c":{"zone":"us-east-1b"}}}`))
+	endpoints[0].observe(10 * time.Millisecond)
+	endpoints[1].observe(30 * time.Millisecond)
+	endpoints[2].observe(20 * time.Millisecond)
+
+	b := newBalancer(Nearest, endpoints, nil)
+	b.zone = "us-east-1b"
+	if e, _ := b.pickRead(context.TODO()); e.target != "c" {
+		t.Fatalf("nearest endpoint in zone not picked, got %s", e.target)
+	}
+
+	b = newBalancer(RoundRobin, endpoints, nil)
+	b.zone = "us-east-1b"
+	for i := 0; i < 4; i++ {
+		e, err := b.pickRead(context.TODO())
+		if err != nil {
+			t.Fatalf("failed to pick endpoint: %s", err.Error())
+		}
+		if e.target == "a" {
+			t.Fatalf("endpoint outside of zone picked")
+		}
+	}
+
+	// Without endpoints in its zone the client reads from any of them.
+	b.zone = "eu-west-1a"
+	if _, err := b.pickRead(context.TODO()); err != nil {
+		t.Fatalf("failed to pick endpoint: %s", err.Error())
+	}
+}
@@ -27,15 +27,56 @@ import (
 )
 
 type Client struct {
-	grpcClient command.CasbinMeshClient
+	balancer *balancer
 }
 
 var (
 	MarshalFailed = errors.New("marshal failed")
 )
 
+// errNotLeader is the error text returned by a node that is not the leader.
+const errNotLeader = "not leader"
+
+// leader returns a client for the current leader.
+func (c Client) leader(ctx context.Context) (command.CasbinMeshClient, error) {
+	e, err := c.balancer.pickLeader(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return e.client, nil
+}
+
+// request sends a write command to the leader. If the node turns out not to
+// be the leader anymore, the leader is rediscovered and the command retried once.
+func (c Client) request(ctx context.Context, cmd *command.Command) (*command.Response, error) {
+	var resp *command.Response
+	for i := 0; i < 2; i++ {
+		lc, err := c.leader(ctx)
+		if err != nil {
+			return nil, err
+		}
+		resp, err = lc.Request(ctx, cmd)
+		if err != nil {
+			return nil, err
+		}
+		if resp.Error != errNotLeader {
+			break
+		}
+		c.balancer.resetLeader()
+	}
+	return resp, nil
+}
+
+// Close closes all connections held by the client.
+func (c Client) Close() error {
+	return c.balancer.close()
+}
+
 func (c Client) ShowStats(ctx context.Context) ([]byte, error) {
-	resp, err := c.grpcClient.ShowStats(ctx, &command.StatsRequest{})
+	if len(c.balancer.endpoints) == 0 {
+		return nil, ErrNoEndpoints
+	}
+	resp, err := c.balancer.endpoints[0].client.ShowStats(ctx, &command.StatsRequest{})
 	if err != nil {
 		return nil, err
 	}
@@ -43,12 +84,36 @@ func (c Client) ShowStats(ctx context.Context) ([]byte, error) {
 }
 
 func (c Client) AddPolicies(ctx context.Context, namespace, sec, ptype string, rules [][]string) ([][]string, error) {
-	payload := command.AddPoliciesPayload{
+	return c.addPolicies(ctx, namespace, &command.AddPoliciesPayload{
 		Sec:   sec,
 		PType: ptype,
 		Rules: command.NewStringArray(rules),
-	}
-	p, err := proto.Marshal(&payload)
+	})
+}
+
+// AddExpiringPolicies adds rules the server removes at expireAt. Adding an
+// existing rule sets its expiry.
+func (c Client) AddExpiringPolicies(ctx context.Context, namespace, sec, ptype string, rules [][]string, expireAt time.Time) ([][]string, error) {
+	return c.addPolicies(ctx, namespace, &command.AddPoliciesPayload{
+		Sec:      sec,
+		PType:    ptype,
+		Rules:    command.NewStringArray(rules),
+		ExpireAt: expireAt.Unix(),
+	})
+}
+
+// AddScheduledPolicies adds rules the server only enforces within schedule.
+func (c Client) AddScheduledPolicies(ctx context.Context, namespace, sec, ptype string, rules [][]string, schedule *command.Schedule) ([][]string, error) {
+	return c.addPolicies(ctx, namespace, &command.AddPoliciesPayload{
+		Sec:      sec,
+		PType:    ptype,
+		Rules:    command.NewStringArray(rules),
+		Schedule: schedule,
+	})
+}
+
+func (c Client) addPolicies(ctx context.Context, namespace string, payload *command.AddPoliciesPayload) ([][]string, error) {
+	p, err := proto.Marshal(payload)
 	if err != nil {
 		return nil, MarshalFailed
 	}
@@ -57,7 +122,7 @@ func (c Client) AddPolicies(ctx context.Context, namespace, sec, ptype string, r
 		Namespace: namespace,
 		Payload:   p,
 	}
-	resp, err := c.grpcClient.Request(ctx, &cmd)
+	resp, err := c.request(ctx, &cmd)
 	if err != nil {
 		return nil, err
 	}
@@ -82,7 +147,35 @@ func (c Client) RemovePolicies(ctx context.Context, namespace, sec, ptype string
 		Namespace: namespace,
 		Payload:   p,
 	}
-	resp, err := c.grpcClient.Request(ctx, &cmd)
+	resp, err := c.request(ctx, &cmd)
+	if err != nil {
+		return nil, err
+	}
+	if resp.Error != "" {
+		return nil, errors.New(resp.Error)
+	}
+	return command.ToStringArray(resp.EffectedRules), nil
+}
+
+// AnnotatePolicies replaces the annotation of existing rules, a nil
+// annotation removes it. It returns the annotated rules.
+func (c Client) AnnotatePolicies(ctx context.Context, namespace, sec, ptype string, rules [][]string, annotation *command.Annotation) ([][]string, error) {
+	payload := command.AnnotatePoliciesPayload{
+		Sec:        sec,
+		PType:      ptype,
+		Rules:      command.NewStringArray(rules),
+		Annotation: annotation,
+	}
+	p, err := proto.Marshal(&payload)
+	if err != nil {
+		return nil, MarshalFailed
+	}
+	cmd := command.Command{
+		Type:      command.Type_COMMAND_TYPE_ANNOTATE_POLICIES,
+		Namespace: namespace,
+		Payload:   p,
+	}
+	resp, err := c.request(ctx, &cmd)
 	if err != nil {
 		return nil, err
 	}
@@ -109,7 +202,7 @@ func (c Client) UpdatePolicies(ctx context.Context, namespace, sec, ptype string
 		Namespace: namespace,
 		Payload:   p,
 	}
-	resp, err := c.grpcClient.Request(ctx, &cmd)
+	resp, err := c.request(ctx, &cmd)
 	if err != nil {
 		return false, err
 	}
@@ -119,8 +212,47 @@ func (c Client) UpdatePolicies(ctx context.Context, namespace, sec, ptype string
 	return resp.Effected, nil
 }
 
+func (c Client) CreateNamespace(ctx context.Context, namespace string) error {
+	cmd := command.Command{
+		Type:      command.Type_COMMAND_TYPE_CREATE_NAMESPACE,
+		Namespace: namespace,
+	}
+	resp, err := c.request(ctx, &cmd)
+	if err != nil {
+		return err
+	}
+	if resp.Error != "" {
+		return errors.New(resp.Error)
+	}
+	return nil
+}
+
+func (c Client) SetModelFromString(ctx context.Context, namespace, text string) error {
+	p, err := proto.Marshal(&command.SetModelFromString{Text: text})
+	if err != nil {
+		return MarshalFailed
+	}
+	cmd := command.Command{
+		Type:      command.Type_COMMAND_TYPE_SET_MODEL,
+		Namespace: namespace,
+		Payload:   p,
+	}
+	resp, err := c.request(ctx, &cmd)
+	if err != nil {
+		return err
+	}
+	if resp.Error != "" {
+		return errors.New(resp.Error)
+	}
+	return nil
+}
+
 func (c Client) ListNamespaces(ctx context.Context) ([]string, error) {
-	resp, err := c.grpcClient.ListNamespaces(ctx, &command.ListNamespacesRequest{})
+	lc, err := c.leader(ctx)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := lc.ListNamespaces(ctx, &command.ListNamespacesRequest{})
 	if err != nil {
 		return nil, err
 	}
@@ -131,14 +263,37 @@ func (c Client) ListNamespaces(ctx context.Context) ([]string, error) {
 }
 
 func (c Client) ListPolicies(ctx context.Context, namespace string) ([][]string, error) {
-	resp, err := c.grpcClient.ListPolicies(ctx, &command.ListPoliciesRequest{Namespace: namespace})
+	lc, err := c.leader(ctx)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := lc.ListPolicies(ctx, &command.ListPoliciesRequest{Namespace: namespace})
 	if err != nil {
 		return nil, err
 	}
 	return command.ToStringArray(resp.Policies), nil
 }
 
+// ListAnnotations returns the annotations of the rules of a namespace.
+func (c Client) ListAnnotations(ctx context.Context, namespace string) ([]*command.PolicyAnnotation, error) {
+	lc, err := c.leader(ctx)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := lc.ListPolicies(ctx, &command.ListPoliciesRequest{Namespace: namespace})
+	if err != nil {
+		return nil, err
+	}
+	return resp.Annotations, nil
+}
+
 func (c Client) Enforce(ctx context.Context, namespace string, level command.EnforcePayload_Level, freshness int64, params ...interface{}) (bool, error) {
+	return c.EnforceWithContext(ctx, namespace, level, freshness, nil, params...)
+}
+
+// EnforceWithContext enforces with the request, policy, effect and matcher
+// definitions selected by ec, e.g. r2/p2/e2/m2. A nil ec uses r/p/e/m.
+func (c Client) EnforceWithContext(ctx context.Context, namespace string, level command.EnforcePayload_Level, freshness int64, ec *command.EnforceContext, params ...interface{}) (bool, error) {
 	var B [][]byte
 	for _, p := range params {
 		b, err := json.Marshal(p)
@@ -152,15 +307,29 @@ func (c Client) Enforce(ctx context.Context, namespace string, level command.Enf
 		B:         B,
 		Level:     level,
 		Freshness: freshness,
+		Context:   ec,
 	}
 	cmd := &command.EnforceRequest{
 		Namespace: namespace,
 		Payload:   payload,
 	}
-	result, err := c.grpcClient.Enforce(ctx, cmd)
+	// Only unconstrained reads may be served by followers.
+	var e *endpoint
+	var err error
+	if level == command.EnforcePayload_QUERY_REQUEST_LEVEL_NONE {
+		e, err = c.balancer.pickRead(ctx)
+	} else {
+		e, err = c.balancer.pickLeader(ctx)
+	}
+	if err != nil {
+		return false, err
+	}
+	start := time.Now()
+	result, err := e.client.Enforce(ctx, cmd)
 	if err != nil {
 		return false, err
 	}
+	e.observe(time.Since(start))
 	if result.Error != "" {
 		return false, errors.New(result.Error)
 	}
@@ -168,7 +337,11 @@ func (c Client) Enforce(ctx context.Context, namespace string, level command.Enf
 }
 
 func (c Client) PrintModel(ctx context.Context, namespace string) (string, error) {
-	resp, err := c.grpcClient.PrintModel(ctx, &command.PrintModelRequest{Namespace: namespace})
+	lc, err := c.leader(ctx)
+	if err != nil {
+		return "", err
+	}
+	resp, err := lc.PrintModel(ctx, &command.PrintModelRequest{Namespace: namespace})
 	if err != nil {
 		return "", err
 	}
@@ -178,31 +351,84 @@ func (c Client) PrintModel(ctx context.Context, namespace string) (string, error
 	return resp.Model, nil
 }
 
+// Snapshot returns the model and policies of a namespace, along with the
+// Raft index they were read at.
+func (c Client) Snapshot(ctx context.Context, namespace string) (*command.SnapshotResponse, error) {
+	lc, err := c.leader(ctx)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := lc.Snapshot(ctx, &command.SnapshotRequest{Namespace: namespace})
+	if err != nil {
+		return nil, err
+	}
+	if resp.Error != "" {
+		return nil, errors.New(resp.Error)
+	}
+	return resp, nil
+}
+
 type Options struct {
 	Target   string
 	AuthType AuthType
 	Username string
 	Password string
+
+	// Endpoints are additional nodes of the cluster. Reads may be spread
+	// across Target and Endpoints according to ReadPolicy, while writes are
+	// always sent to the leader.
+	Endpoints  []string
+	ReadPolicy ReadPolicy
+	// Zone is the zone the client runs in. When set, RoundRobin and Nearest
+	// reads prefer the healthy endpoints whose node registered the same zone
+	// metadata, to save cross-zone traffic.
+	Zone string
+
+	// HealthCheckInterval is how often every endpoint is probed, 5s by default.
+	// It is also how long an endpoint is avoided after it started failing.
+	HealthCheckInterval time.Duration
+	// FailureThreshold is the number of consecutive failed requests after
+	// which an endpoint is avoided until it recovers, 3 by default.
+	FailureThreshold int
 }
 
 func NewClient(op Options) *Client {
 	var opts []grpc.DialOption
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
 	opts = append(opts, grpc.WithInsecure())
 	opts = append(opts, grpc.WithBlock())
 
 	switch op.AuthType {
 	case Basic:
 		opts = append(opts, grpc.WithUnaryInterceptor(grpc_middleware.ChainUnaryClient(BasicAuthor(op.Username, op.Password))))
+		opts = append(opts, grpc.WithStreamInterceptor(grpc_middleware.ChainStreamClient(BasicAuthorStream(op.Username, op.Password))))
 	}
 
-	conn, err := grpc.DialContext(ctx, op.Target, opts...)
-	if err != nil {
-		log.Fatalf("fail to dial: %v", err)
+	dial := func(target string, extra ...grpc.DialOption) (*grpc.ClientConn, error) {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		return grpc.DialContext(ctx, target, append(opts, extra...)...)
+	}
+
+	interval := op.HealthCheckInterval
+	if interval <= 0 {
+		interval = defaultHealthCheckInterval
+	}
+	b := newBalancer(op.ReadPolicy, nil, dial)
+	b.failureThreshold = op.FailureThreshold
+	b.cooldown = interval
+	b.zone = op.Zone
+	for _, target := range append([]string{op.Target}, op.Endpoints...) {
+		e, err := b.connect(target)
+		if err != nil {
+			log.Fatalf("fail to dial: %v", err)
+		}
+		if b.zone != "" {
+			e.probe(interval)
+		}
+		b.endpoints = append(b.endpoints, e)
 	}
+	b.stop = make(chan struct{})
+	go b.healthCheck(interval, b.stop)
 	log.Println("login success!")
-	//defer conn.Close()
-	c := command.NewCasbinMeshClient(conn)
-	return &Client{grpcClient: c}
+	return &Client{balancer: b}
 }
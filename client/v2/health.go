@@ -0,0 +1,171 @@
+// Copyright 2022 The casbin-neo Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/casbin/casbin-mesh/proto/command"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	defaultHealthCheckInterval = 5 * time.Second
+	defaultFailureThreshold    = 3
+)
+
+var (
+	// ErrCircuitOpen is returned for requests to an endpoint that has been
+	// failing and is not used until it recovers.
+	ErrCircuitOpen = errors.New("circuit breaker is open")
+)
+
+type breakerState int
+
+const (
+	breakerClosed breakerState = iota
+	breakerOpen
+	breakerHalfOpen
+)
+
+// breaker is a circuit breaker guarding a single endpoint. After threshold
+// consecutive failures it opens and rejects requests; once cooldown has
+// passed a single trial request is let through, closing the breaker again if
+// it succeeds.
+type breaker struct {
+	threshold int
+	cooldown  time.Duration
+
+	mu       sync.Mutex
+	state    breakerState
+	failures int
+	openedAt time.Time
+}
+
+func newBreaker(threshold int, cooldown time.Duration) *breaker {
+	if threshold <= 0 {
+		threshold = defaultFailureThreshold
+	}
+	if cooldown <= 0 {
+		cooldown = defaultHealthCheckInterval
+	}
+	return &breaker{threshold: threshold, cooldown: cooldown}
+}
+
+// allow reports whether a request may be sent, taking the trial slot if the
+// breaker is due for one.
+func (b *breaker) allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case breakerOpen:
+		if time.Since(b.openedAt) < b.cooldown {
+			return false
+		}
+		b.state = breakerHalfOpen
+		return true
+	case breakerHalfOpen:
+		return false
+	}
+	return true
+}
+
+// available reports whether requests may be sent, without taking the trial slot.
+func (b *breaker) available() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state == breakerClosed || b.state == breakerOpen && time.Since(b.openedAt) >= b.cooldown
+}
+
+func (b *breaker) success() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.state = breakerClosed
+	b.failures = 0
+}
+
+func (b *breaker) failure() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures++
+	if b.state == breakerHalfOpen || b.failures >= b.threshold {
+		b.state = breakerOpen
+		b.openedAt = time.Now()
+	}
+}
+
+// record updates the breaker with the outcome of a request. Only errors that
+// indicate the node itself is unreachable or unresponsive count as failures.
+func (b *breaker) record(err error) {
+	switch status.Code(err) {
+	case codes.OK, codes.Canceled:
+		b.success()
+	case codes.Unavailable, codes.DeadlineExceeded:
+		b.failure()
+	default:
+		b.success()
+	}
+}
+
+type probeKey struct{}
+
+// unaryInterceptor rejects requests while the breaker is open and records the
+// outcome of the others. Health probes always go through.
+func (b *breaker) unaryInterceptor(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+	if ctx.Value(probeKey{}) == nil && !b.allow() {
+		return status.Error(codes.Unavailable, ErrCircuitOpen.Error())
+	}
+	err := invoker(ctx, method, req, reply, cc, opts...)
+	b.record(err)
+	return err
+}
+
+// probe checks that the endpoint answers within timeout, updating its zone
+// from the stats it answers with.
+func (e *endpoint) probe(timeout time.Duration) {
+	ctx, cancel := context.WithTimeout(context.WithValue(context.Background(), probeKey{}, true), timeout)
+	defer cancel()
+	resp, err := e.client.ShowStats(ctx, &command.StatsRequest{})
+	if err == nil {
+		e.setZone(resp.Payload)
+	}
+}
+
+// healthCheck probes all endpoints every interval until stop is closed.
+func (b *balancer) healthCheck(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+		var wg sync.WaitGroup
+		for _, e := range b.endpoints {
+			wg.Add(1)
+			go func(e *endpoint) {
+				defer wg.Done()
+				e.probe(interval)
+			}(e)
+		}
+		wg.Wait()
+	}
+}
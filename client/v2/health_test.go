@@ -0,0 +1,84 @@
+// Copyright 2022 The casbin-neo Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestBreaker_OpenAndRecover(t *testing.T) {
+	b := newBreaker(2, 10*time.Millisecond)
+	unavailable := status.Error(codes.Unavailable, "connection refused")
+
+	b.record(unavailable)
+	if !b.allow() {
+		t.Fatalf("breaker opened before reaching the threshold")
+	}
+	b.record(unavailable)
+	if b.allow() {
+		t.Fatalf("breaker not opened after reaching the threshold")
+	}
+
+	time.Sleep(20 * time.Millisecond)
+	if !b.allow() {
+		t.Fatalf("trial request not allowed after cooldown")
+	}
+	if b.allow() {
+		t.Fatalf("more than one trial request allowed")
+	}
+	b.record(nil)
+	if !b.allow() {
+		t.Fatalf("breaker not closed after successful trial")
+	}
+}
+
+func TestBreaker_ApplicationErrors(t *testing.T) {
+	b := newBreaker(1, time.Minute)
+	b.record(status.Error(codes.Unknown, "namespace not exist"))
+	if !b.allow() {
+		t.Fatalf("application errors must not open the breaker")
+	}
+}
+
+func TestBalancer_SkipOpenEndpoints(t *testing.T) {
+	endpoints := []*endpoint{
+		{target: "a", breaker: newBreaker(1, time.Minute)},
+		{target: "b", breaker: newBreaker(1, time.Minute)},
+	}
+	endpoints[0].breaker.failure()
+	for _, policy := range []ReadPolicy{RoundRobin, Nearest} {
+		b := newBalancer(policy, endpoints, nil)
+		for i := 0; i < 3; i++ {
+			e, err := b.pickRead(context.TODO())
+			if err != nil {
+				t.Fatalf("failed to pick endpoint: %s", err.Error())
+			}
+			if e.target != "b" {
+				t.Fatalf("open endpoint picked with policy %s", policy)
+			}
+		}
+	}
+
+	endpoints[1].breaker.failure()
+	b := newBalancer(RoundRobin, endpoints, nil)
+	if _, err := b.pickRead(context.TODO()); err != ErrNoHealthyEndpoints {
+		t.Fatalf("expected ErrNoHealthyEndpoints, got %v", err)
+	}
+}
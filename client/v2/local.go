@@ -0,0 +1,189 @@
+// Copyright 2022 The casbin-neo Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/casbin/casbin-mesh/proto/command"
+	"github.com/casbin/casbin/v2"
+	"github.com/casbin/casbin/v2/model"
+)
+
+// errResync is returned when a change can't be applied incrementally and the
+// namespace has to be reloaded.
+var errResync = errors.New("resync required")
+
+// LocalEnforcer evaluates Enforce against a local copy of a namespace, which
+// is loaded from a snapshot and kept up to date through the watch stream.
+// Decisions are only as fresh as the last applied change, writes still go to
+// the cluster through the Client and are visible locally once applied.
+type LocalEnforcer struct {
+	client    *Client
+	namespace string
+
+	mu       sync.RWMutex
+	enforcer *casbin.DistributedEnforcer
+	index    uint64
+
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// NewLocalEnforcer loads namespace and starts following its changes in the
+// background, until Close is called.
+func (c *Client) NewLocalEnforcer(ctx context.Context, namespace string) (*LocalEnforcer, error) {
+	l := &LocalEnforcer{
+		client:    c,
+		namespace: namespace,
+		done:      make(chan struct{}),
+	}
+	if err := l.load(ctx); err != nil {
+		return nil, err
+	}
+	ctx, l.cancel = context.WithCancel(context.Background())
+	go l.run(ctx)
+	return l, nil
+}
+
+// Enforce decides whether a "subject" can access an "object" with the
+// operation "action", using the local copy of the namespace.
+func (l *LocalEnforcer) Enforce(params ...interface{}) (bool, error) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.enforcer.Enforce(params...)
+}
+
+// enforceAt decides like Enforce, and returns the index of the last change
+// applied when deciding.
+func (l *LocalEnforcer) enforceAt(params ...interface{}) (bool, uint64, error) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	ok, err := l.enforcer.Enforce(params...)
+	return ok, l.index, err
+}
+
+// Index returns the Raft index of the last change applied locally.
+func (l *LocalEnforcer) Index() uint64 {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.index
+}
+
+// Close stops following the namespace.
+func (l *LocalEnforcer) Close() {
+	l.cancel()
+	<-l.done
+}
+
+func (l *LocalEnforcer) run(ctx context.Context) {
+	defer close(l.done)
+	for {
+		err := l.follow(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		log.Printf("reloading namespace %s: %v", l.namespace, err)
+		backoff := watchRetryMin
+		for {
+			if err = l.load(ctx); err == nil {
+				break
+			}
+			log.Printf("failed to reload namespace %s: %v, retrying in %s", l.namespace, err, backoff)
+			select {
+			case <-time.After(backoff):
+			case <-ctx.Done():
+				return
+			}
+			if backoff *= 2; backoff > watchRetryMax {
+				backoff = watchRetryMax
+			}
+		}
+	}
+}
+
+// follow applies the changes after the loaded snapshot until a change requires
+// the namespace to be reloaded.
+func (l *LocalEnforcer) follow(ctx context.Context) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	events, err := l.client.WatchFrom(ctx, l.namespace, l.Index())
+	if err != nil {
+		return err
+	}
+	for ev := range events {
+		if ev.Reset {
+			return errResync
+		}
+		if err := l.apply(ev); err != nil {
+			return err
+		}
+	}
+	return ctx.Err()
+}
+
+func (l *LocalEnforcer) load(ctx context.Context) error {
+	snapshot, err := l.client.Snapshot(ctx, l.namespace)
+	if err != nil {
+		return err
+	}
+	m, err := model.NewModelFromString(snapshot.Model)
+	if err != nil {
+		return err
+	}
+	e, err := casbin.NewDistributedEnforcer(m)
+	if err != nil {
+		return err
+	}
+	for _, p := range snapshot.Policies {
+		if _, err = e.AddPoliciesSelf(nil, p.Sec, p.PType, command.ToStringArray(p.Rules)); err != nil {
+			return err
+		}
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.enforcer = e
+	l.index = snapshot.Index
+	return nil
+}
+
+func (l *LocalEnforcer) apply(ev WatchEvent) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if ev.Index <= l.index {
+		return nil
+	}
+	var err error
+	switch ev.Type {
+	case command.Type_COMMAND_TYPE_ADD_POLICIES:
+		_, err = l.enforcer.AddPoliciesSelf(nil, ev.Sec, ev.PType, ev.Rules)
+	case command.Type_COMMAND_TYPE_REMOVE_POLICIES, command.Type_COMMAND_TYPE_REMOVE_FILTERED_POLICY:
+		// Events carry the rules that were actually removed.
+		_, err = l.enforcer.RemovePoliciesSelf(nil, ev.Sec, ev.PType, ev.Rules)
+	case command.Type_COMMAND_TYPE_UPDATE_POLICIES:
+		_, err = l.enforcer.UpdatePoliciesSelf(nil, ev.Sec, ev.PType, ev.OldRules, ev.Rules)
+	case command.Type_COMMAND_TYPE_SET_MODEL, command.Type_COMMAND_TYPE_CLEAR_POLICY, command.Type_COMMAND_TYPE_ROLLBACK_POLICIES:
+		return errResync
+	}
+	if err != nil {
+		return err
+	}
+	l.index = ev.Index
+	return nil
+}
@@ -0,0 +1,103 @@
+// Copyright 2022 The casbin-neo Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"testing"
+
+	"github.com/casbin/casbin-mesh/proto/command"
+	"github.com/casbin/casbin/v2"
+	"github.com/casbin/casbin/v2/model"
+)
+
+const rbacModel = `
+[request_definition]
+r = sub, obj, act
+
+[policy_definition]
+p = sub, obj, act
+
+[role_definition]
+g = _, _
+
+[policy_effect]
+e = some(where (p.eft == allow))
+
+[matchers]
+m = g(r.sub, p.sub) && r.obj == p.obj && r.act == p.act
+`
+
+func newTestLocalEnforcer(t *testing.T, index uint64) *LocalEnforcer {
+	m, err := model.NewModelFromString(rbacModel)
+	if err != nil {
+		t.Fatalf("failed to load model: %s", err.Error())
+	}
+	e, err := casbin.NewDistributedEnforcer(m)
+	if err != nil {
+		t.Fatalf("failed to create enforcer: %s", err.Error())
+	}
+	return &LocalEnforcer{enforcer: e, index: index}
+}
+
+func mustEnforce(t *testing.T, l *LocalEnforcer, exp bool, params ...interface{}) {
+	ok, err := l.Enforce(params...)
+	if err != nil {
+		t.Fatalf("failed to enforce: %s", err.Error())
+	}
+	if ok != exp {
+		t.Fatalf("wrong decision for %v, exp %v, got %v", params, exp, ok)
+	}
+}
+
+func TestLocalEnforcer_Apply(t *testing.T) {
+	l := newTestLocalEnforcer(t, 10)
+	events := []WatchEvent{
+		{Index: 11, Type: command.Type_COMMAND_TYPE_ADD_POLICIES, Sec: "p", PType: "p", Rules: [][]string{{"admin", "data", "write"}}},
+		{Index: 12, Type: command.Type_COMMAND_TYPE_ADD_POLICIES, Sec: "g", PType: "g", Rules: [][]string{{"alice", "admin"}}},
+	}
+	for _, ev := range events {
+		if err := l.apply(ev); err != nil {
+			t.Fatalf("failed to apply event: %s", err.Error())
+		}
+	}
+	mustEnforce(t, l, true, "alice", "data", "write")
+
+	err := l.apply(WatchEvent{Index: 13, Type: command.Type_COMMAND_TYPE_UPDATE_POLICIES, Sec: "p", PType: "p",
+		OldRules: [][]string{{"admin", "data", "write"}}, Rules: [][]string{{"admin", "data", "read"}}})
+	if err != nil {
+		t.Fatalf("failed to apply event: %s", err.Error())
+	}
+	mustEnforce(t, l, false, "alice", "data", "write")
+	mustEnforce(t, l, true, "alice", "data", "read")
+
+	err = l.apply(WatchEvent{Index: 14, Type: command.Type_COMMAND_TYPE_REMOVE_POLICIES, Sec: "g", PType: "g", Rules: [][]string{{"alice", "admin"}}})
+	if err != nil {
+		t.Fatalf("failed to apply event: %s", err.Error())
+	}
+	mustEnforce(t, l, false, "alice", "data", "read")
+	if l.Index() != 14 {
+		t.Fatalf("wrong index, exp 14, got %d", l.Index())
+	}
+
+	// Changes already covered by the snapshot are skipped.
+	if err = l.apply(events[1]); err != nil {
+		t.Fatalf("failed to apply event: %s", err.Error())
+	}
+	mustEnforce(t, l, false, "alice", "data", "read")
+
+	if err = l.apply(WatchEvent{Index: 15, Type: command.Type_COMMAND_TYPE_SET_MODEL}); err != errResync {
+		t.Fatalf("expected errResync, got %v", err)
+	}
+}
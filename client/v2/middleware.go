@@ -35,3 +35,16 @@ func BasicAuthor(username, password string) grpc.UnaryClientInterceptor {
 		return invoker(ctx, method, req, reply, cc, opts...)
 	}
 }
+
+func BasicAuthorStream(username, password string) grpc.StreamClientInterceptor {
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		ctx = metadata.AppendToOutgoingContext(ctx, "Authorization", "Basic "+basicAuth(username, password))
+		return streamer(ctx, desc, cc, method, opts...)
+	}
+}
+
+// WithIdempotencyKey returns a copy of ctx whose writes the server applies
+// at most once per key, so they can be retried safely after a timeout.
+func WithIdempotencyKey(ctx context.Context, key string) context.Context {
+	return metadata.AppendToOutgoingContext(ctx, "idempotency-key", key)
+}
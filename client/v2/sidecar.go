@@ -0,0 +1,331 @@
+// Copyright 2022 The casbin-neo Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"container/list"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"sort"
+	"sync"
+)
+
+// DefaultSidecarCacheSize is the number of decisions memoized per namespace
+// by default.
+const DefaultSidecarCacheSize = 10000
+
+// SidecarOptions configures a Sidecar.
+type SidecarOptions struct {
+	// Namespaces are loaded by Start, others are loaded on first use.
+	Namespaces []string
+	// CacheSize is the number of decisions memoized per namespace,
+	// DefaultSidecarCacheSize if zero. A negative size disables the cache.
+	CacheSize int
+}
+
+// Sidecar serves Enforce to the applications of its host from local copies
+// of the namespaces, kept up to date by LocalEnforcers, and memoizes the
+// decisions. A memoized decision is only reused until the namespace changes.
+type Sidecar struct {
+	client *Client
+	opts   SidecarOptions
+
+	mu         sync.Mutex
+	namespaces map[string]*sidecarNamespace
+	server     *http.Server
+}
+
+type sidecarNamespace struct {
+	// ready is closed once local or err is set.
+	ready chan struct{}
+	local *LocalEnforcer
+	err   error
+	cache *decisionCache
+}
+
+// NewSidecar returns a Sidecar following the namespaces of the cluster c is
+// connected to.
+func (c *Client) NewSidecar(opts SidecarOptions) *Sidecar {
+	if opts.CacheSize == 0 {
+		opts.CacheSize = DefaultSidecarCacheSize
+	}
+	return &Sidecar{client: c, opts: opts, namespaces: make(map[string]*sidecarNamespace)}
+}
+
+// Start loads the namespaces of the options.
+func (s *Sidecar) Start(ctx context.Context) error {
+	for _, ns := range s.opts.Namespaces {
+		if _, err := s.namespace(ctx, ns); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Enforce decides a request with the local copy of namespace, loading it
+// first if needed.
+func (s *Sidecar) Enforce(ctx context.Context, namespace string, params ...interface{}) (bool, error) {
+	n, err := s.namespace(ctx, namespace)
+	if err != nil {
+		return false, err
+	}
+	if n.cache == nil {
+		return n.local.Enforce(params...)
+	}
+	key, err := json.Marshal(params)
+	if err != nil {
+		return false, err
+	}
+	if ok, hit := n.cache.get(string(key), n.local.Index()); hit {
+		return ok, nil
+	}
+	ok, index, err := n.local.enforceAt(params...)
+	if err != nil {
+		return false, err
+	}
+	n.cache.put(string(key), index, ok)
+	return ok, nil
+}
+
+// namespace returns the local copy of ns, loading it once for concurrent
+// callers. A copy failing to load is loaded again by the next caller.
+func (s *Sidecar) namespace(ctx context.Context, ns string) (*sidecarNamespace, error) {
+	s.mu.Lock()
+	n, ok := s.namespaces[ns]
+	if !ok {
+		n = &sidecarNamespace{ready: make(chan struct{})}
+		if s.opts.CacheSize > 0 {
+			n.cache = newDecisionCache(s.opts.CacheSize)
+		}
+		s.namespaces[ns] = n
+	}
+	s.mu.Unlock()
+	if !ok {
+		n.local, n.err = s.client.NewLocalEnforcer(ctx, ns)
+		if n.err != nil {
+			s.mu.Lock()
+			delete(s.namespaces, ns)
+			s.mu.Unlock()
+		}
+		close(n.ready)
+	}
+	select {
+	case <-n.ready:
+		return n, n.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// Indexes returns the Raft index each loaded namespace is up to date with.
+func (s *Sidecar) Indexes() map[string]uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := make(map[string]uint64, len(s.namespaces))
+	for ns, n := range s.namespaces {
+		select {
+		case <-n.ready:
+			if n.err == nil {
+				out[ns] = n.local.Index()
+			}
+		default:
+		}
+	}
+	return out
+}
+
+type sidecarEnforceRequest struct {
+	NS     string        `json:"ns"`
+	Params []interface{} `json:"params"`
+}
+
+type sidecarEnforceReply struct {
+	Ok    bool   `json:"ok"`
+	Error string `json:"error,omitempty"`
+}
+
+type sidecarStatus struct {
+	Namespaces []sidecarNamespaceStatus `json:"namespaces"`
+}
+
+type sidecarNamespaceStatus struct {
+	NS    string `json:"ns"`
+	Index uint64 `json:"index"`
+}
+
+// Serve serves, on l, POST /enforce with the body and the reply of the
+// /enforce endpoint of the cluster, and GET /status with the index of every
+// loaded namespace. It returns once l fails or Close is called.
+func (s *Sidecar) Serve(l net.Listener) error {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/enforce", s.handleEnforce)
+	mux.HandleFunc("/status", s.handleStatus)
+	s.mu.Lock()
+	s.server = &http.Server{Handler: mux}
+	server := s.server
+	s.mu.Unlock()
+	if err := server.Serve(l); err != http.ErrServerClosed {
+		return err
+	}
+	return nil
+}
+
+// ListenAndServeUnix serves on the Unix socket at path, see Serve. A socket
+// left at path by a previous run is removed.
+func (s *Sidecar) ListenAndServeUnix(path string) error {
+	if err := removeStaleSocket(path); err != nil {
+		return err
+	}
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return err
+	}
+	return s.Serve(l)
+}
+
+// removeStaleSocket removes the socket at path, unless a server still
+// listens on it.
+func removeStaleSocket(path string) error {
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return nil
+	}
+	if conn, err := net.Dial("unix", path); err == nil {
+		conn.Close()
+		return fmt.Errorf("%s is in use", path)
+	}
+	return os.Remove(path)
+}
+
+// Close stops serving and following the namespaces.
+func (s *Sidecar) Close() error {
+	s.mu.Lock()
+	server := s.server
+	var locals []*LocalEnforcer
+	for _, n := range s.namespaces {
+		select {
+		case <-n.ready:
+			if n.err == nil {
+				locals = append(locals, n.local)
+			}
+		default:
+		}
+	}
+	s.mu.Unlock()
+	var err error
+	if server != nil {
+		err = server.Close()
+	}
+	for _, l := range locals {
+		l.Close()
+	}
+	return err
+}
+
+func (s *Sidecar) handleEnforce(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeSidecarJSON(w, http.StatusMethodNotAllowed, sidecarEnforceReply{Error: "method " + r.Method + " not allowed"})
+		return
+	}
+	var request sidecarEnforceRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		writeSidecarJSON(w, http.StatusBadRequest, sidecarEnforceReply{Error: err.Error()})
+		return
+	}
+	if request.NS == "" {
+		writeSidecarJSON(w, http.StatusBadRequest, sidecarEnforceReply{Error: "ns required"})
+		return
+	}
+	ok, err := s.Enforce(r.Context(), request.NS, request.Params...)
+	if err != nil {
+		writeSidecarJSON(w, http.StatusInternalServerError, sidecarEnforceReply{Error: err.Error()})
+		return
+	}
+	writeSidecarJSON(w, http.StatusOK, sidecarEnforceReply{Ok: ok})
+}
+
+func (s *Sidecar) handleStatus(w http.ResponseWriter, r *http.Request) {
+	out := sidecarStatus{Namespaces: []sidecarNamespaceStatus{}}
+	for ns, index := range s.Indexes() {
+		out.Namespaces = append(out.Namespaces, sidecarNamespaceStatus{NS: ns, Index: index})
+	}
+	sort.Slice(out.Namespaces, func(i, j int) bool { return out.Namespaces[i].NS < out.Namespaces[j].NS })
+	writeSidecarJSON(w, http.StatusOK, out)
+}
+
+func writeSidecarJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+// decisionCache memoizes the latest decisions of a namespace, evicting the
+// least recently used ones. Decisions are tagged with the index of the
+// namespace they were made at, and are stale once it moved.
+type decisionCache struct {
+	mu      sync.Mutex
+	size    int
+	order   *list.List
+	entries map[string]*list.Element
+}
+
+type cachedDecision struct {
+	key   string
+	index uint64
+	ok    bool
+}
+
+func newDecisionCache(size int) *decisionCache {
+	return &decisionCache{size: size, order: list.New(), entries: make(map[string]*list.Element)}
+}
+
+// get returns the decision of key made at index, if memoized.
+func (c *decisionCache) get(key string, index uint64) (ok, hit bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, found := c.entries[key]
+	if !found {
+		return false, false
+	}
+	d := e.Value.(*cachedDecision)
+	if d.index != index {
+		return false, false
+	}
+	c.order.MoveToFront(e)
+	return d.ok, true
+}
+
+func (c *decisionCache) put(key string, index uint64, ok bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, found := c.entries[key]; found {
+		d := e.Value.(*cachedDecision)
+		// a decision made at an older index does not replace a newer one
+		if d.index <= index {
+			d.index, d.ok = index, ok
+		}
+		c.order.MoveToFront(e)
+		return
+	}
+	c.entries[key] = c.order.PushFront(&cachedDecision{key: key, index: index, ok: ok})
+	if c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*cachedDecision).key)
+	}
+}
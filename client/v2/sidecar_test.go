@@ -0,0 +1,91 @@
+// Copyright 2022 The casbin-neo Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/casbin/casbin-mesh/proto/command"
+)
+
+func newTestSidecar(t *testing.T, cacheSize int) (*Sidecar, *LocalEnforcer) {
+	l := newTestLocalEnforcer(t, 10)
+	s := (&Client{}).NewSidecar(SidecarOptions{CacheSize: cacheSize})
+	n := &sidecarNamespace{ready: make(chan struct{}), local: l, cache: newDecisionCache(s.opts.CacheSize)}
+	close(n.ready)
+	s.namespaces["test"] = n
+	return s, l
+}
+
+func TestSidecar_Enforce(t *testing.T) {
+	s, l := newTestSidecar(t, 2)
+	ctx := context.Background()
+	if err := l.apply(WatchEvent{Index: 11, Type: command.Type_COMMAND_TYPE_ADD_POLICIES, Sec: "p", PType: "p", Rules: [][]string{{"alice", "data", "read"}}}); err != nil {
+		t.Fatalf("failed to apply event: %s", err.Error())
+	}
+	for i := 0; i < 2; i++ {
+		if ok, err := s.Enforce(ctx, "test", "alice", "data", "read"); err != nil || !ok {
+			t.Fatalf("wrong decision, exp true, got %v, %v", ok, err)
+		}
+	}
+	n := s.namespaces["test"]
+	if ok, hit := n.cache.get(`["alice","data","read"]`, 11); !hit || !ok {
+		t.Fatalf("decision not memoized")
+	}
+
+	// Memoized decisions are not reused once the namespace changed.
+	if err := l.apply(WatchEvent{Index: 12, Type: command.Type_COMMAND_TYPE_REMOVE_POLICIES, Sec: "p", PType: "p", Rules: [][]string{{"alice", "data", "read"}}}); err != nil {
+		t.Fatalf("failed to apply event: %s", err.Error())
+	}
+	if ok, err := s.Enforce(ctx, "test", "alice", "data", "read"); err != nil || ok {
+		t.Fatalf("wrong decision, exp false, got %v, %v", ok, err)
+	}
+
+	// The least recently used decisions are evicted.
+	s.Enforce(ctx, "test", "bob", "data", "read")
+	s.Enforce(ctx, "test", "carol", "data", "read")
+	if _, hit := n.cache.get(`["alice","data","read"]`, 12); hit {
+		t.Fatalf("decision not evicted")
+	}
+	if n.cache.order.Len() != 2 {
+		t.Fatalf("wrong cache size, exp 2, got %d", n.cache.order.Len())
+	}
+}
+
+func TestSidecar_HandleEnforce(t *testing.T) {
+	s, l := newTestSidecar(t, DefaultSidecarCacheSize)
+	if err := l.apply(WatchEvent{Index: 11, Type: command.Type_COMMAND_TYPE_ADD_POLICIES, Sec: "p", PType: "p", Rules: [][]string{{"alice", "data", "read"}}}); err != nil {
+		t.Fatalf("failed to apply event: %s", err.Error())
+	}
+	for _, tt := range []struct {
+		body string
+		code int
+		exp  string
+	}{
+		{`{"ns":"test","params":["alice","data","read"]}`, http.StatusOK, `{"ok":true}`},
+		{`{"ns":"test","params":["bob","data","read"]}`, http.StatusOK, `{"ok":false}`},
+		{`{"params":["alice","data","read"]}`, http.StatusBadRequest, `{"ok":false,"error":"ns required"}`},
+	} {
+		w := httptest.NewRecorder()
+		s.handleEnforce(w, httptest.NewRequest(http.MethodPost, "/enforce", strings.NewReader(tt.body)))
+		if w.Code != tt.code || strings.TrimSpace(w.Body.String()) != tt.exp {
+			t.Fatalf("wrong reply to %s, exp %d %s, got %d %s", tt.body, tt.code, tt.exp, w.Code, w.Body.String())
+		}
+	}
+}
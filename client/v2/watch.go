@@ -0,0 +1,133 @@
+// Copyright 2022 The casbin-neo Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/casbin/casbin-mesh/proto/command"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	watchRetryMin = 100 * time.Millisecond
+	watchRetryMax = 5 * time.Second
+	watchChanSize = 64
+)
+
+// WatchEvent is a change applied to a namespace.
+type WatchEvent struct {
+	Index     uint64
+	Namespace string
+	Type      command.Type
+	Sec       string
+	PType     string
+	Rules     [][]string
+	OldRules  [][]string
+	Model     string
+
+	// Reset is set when changes have been missed, e.g. because the watcher was
+	// disconnected for too long. State built from earlier events must be reloaded.
+	Reset bool
+}
+
+func newWatchEvent(ev *command.WatchEvent) WatchEvent {
+	return WatchEvent{
+		Index:     ev.GetIndex(),
+		Namespace: ev.GetNamespace(),
+		Type:      ev.GetType(),
+		Sec:       ev.GetSec(),
+		PType:     ev.GetPType(),
+		Rules:     command.ToStringArray(ev.GetRules()),
+		OldRules:  command.ToStringArray(ev.GetOldRules()),
+		Model:     ev.GetModel(),
+	}
+}
+
+// Watch streams the changes applied to namespace, or to all namespaces if
+// namespace is empty. The channel is closed once ctx is done.
+func (c Client) Watch(ctx context.Context, namespace string) (<-chan WatchEvent, error) {
+	return c.WatchFrom(ctx, namespace, 0)
+}
+
+// WatchFrom is like Watch, but first replays the changes applied after index.
+// Broken streams are reopened automatically, resuming after the last
+// received event, so no changes are lost across reconnects.
+func (c Client) WatchFrom(ctx context.Context, namespace string, index uint64) (<-chan WatchEvent, error) {
+	if len(c.balancer.endpoints) == 0 {
+		return nil, ErrNoEndpoints
+	}
+	out := make(chan WatchEvent, watchChanSize)
+	go c.watch(ctx, namespace, index, out)
+	return out, nil
+}
+
+func (c Client) watch(ctx context.Context, namespace string, index uint64, out chan<- WatchEvent) {
+	defer close(out)
+	backoff := watchRetryMin
+	for {
+		err := c.watchOnce(ctx, namespace, &index, out, func() { backoff = watchRetryMin })
+		if ctx.Err() != nil {
+			return
+		}
+		if status.Code(err) == codes.OutOfRange {
+			// The server no longer has the changes after index.
+			index = 0
+			select {
+			case out <- WatchEvent{Namespace: namespace, Reset: true}:
+			case <-ctx.Done():
+				return
+			}
+			continue
+		}
+		log.Printf("watch stream broken: %v, reconnecting in %s", err, backoff)
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return
+		}
+		if backoff *= 2; backoff > watchRetryMax {
+			backoff = watchRetryMax
+		}
+	}
+}
+
+// watchOnce opens a single watch stream and forwards its events until it breaks.
+func (c Client) watchOnce(ctx context.Context, namespace string, index *uint64, out chan<- WatchEvent, connected func()) error {
+	e, err := c.balancer.pickRead(ctx)
+	if err != nil {
+		return err
+	}
+	stream, err := e.client.Watch(ctx, &command.WatchRequest{Namespace: namespace, Index: *index})
+	if err != nil {
+		return err
+	}
+	for {
+		ev, err := stream.Recv()
+		if err != nil {
+			return err
+		}
+		connected()
+		*index = ev.GetIndex()
+		select {
+		case out <- newWatchEvent(ev):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
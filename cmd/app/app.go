@@ -15,35 +15,67 @@
 package main
 
 import (
-	"bufio"
 	"context"
 	"crypto/tls"
 	"crypto/x509"
+	"errors"
 	"fmt"
+	"github.com/casbin/casbin-mesh/pkg/accesslog"
 	"github.com/casbin/casbin-mesh/pkg/auth"
 	"github.com/casbin/casbin-mesh/pkg/cluster"
 	"github.com/casbin/casbin-mesh/pkg/core"
+	"github.com/casbin/casbin-mesh/pkg/errreport"
+	"github.com/casbin/casbin-mesh/pkg/events"
+	"github.com/casbin/casbin-mesh/pkg/expiry"
+	"github.com/casbin/casbin-mesh/pkg/extauthz"
+	handler "github.com/casbin/casbin-mesh/pkg/handler/http"
+	"github.com/casbin/casbin-mesh/pkg/ldapsync"
+	"github.com/casbin/casbin-mesh/pkg/limit"
+	"github.com/casbin/casbin-mesh/pkg/logsink"
+	"github.com/casbin/casbin-mesh/pkg/scim"
+	"github.com/casbin/casbin-mesh/pkg/standby"
 	"github.com/casbin/casbin-mesh/pkg/store"
 	"github.com/casbin/casbin-mesh/pkg/transport/tcp"
+	"github.com/casbin/casbin-mesh/pkg/ui"
+	"github.com/casbin/casbin-mesh/pkg/webhook"
 	"github.com/rs/cors"
 	"github.com/soheilhy/cmux"
-	"io"
 	"io/ioutil"
 	"log"
 	"net"
 	"net/http"
 	"os"
+	"path"
 	"path/filepath"
 	"runtime"
 	"runtime/pprof"
+	"strconv"
 	"strings"
 	"time"
 )
 
-func New(cfg *Config) (close func() error) {
+// scimPath is the path the SCIM service is served under.
+const scimPath = "/scim/v2"
+
+// uiPath is the path the admin dashboard is served under.
+const uiPath = "/ui/"
+
+// shedInterval is how often the load shedding signals are sampled.
+const shedInterval = 100 * time.Millisecond
+
+func New(cfg *Config) (close func() error, reload func() error) {
 	// Configure logging and pump out initial message.
 	log.SetFlags(log.LstdFlags)
-	log.SetOutput(os.Stderr)
+	logOutput, err := logsink.Open(logsink.Config{
+		Output:     cfg.logOutput,
+		SyslogAddr: cfg.logSyslogAddr,
+		TLS:        &tls.Config{InsecureSkipVerify: cfg.noVerify},
+		Tag:        cfg.logTag,
+	})
+	if err != nil {
+		log.Fatalf("failed to open log output %s: %s", cfg.logOutput, err.Error())
+	}
+	log.SetOutput(logOutput)
 	log.SetPrefix(fmt.Sprintf("[%s] ", name))
 	log.Printf("%s, target architecture is %s, operating system target is %s", runtime.Version(), runtime.GOARCH, runtime.GOOS)
 	log.Printf("launch command: %s", strings.Join(os.Args, " "))
@@ -51,6 +83,13 @@ func New(cfg *Config) (close func() error) {
 	// Start requested profiling.
 	startProfile(cfg.cpuProfile, cfg.memProfile)
 
+	if err := applyDiscovery(cfg); err != nil {
+		log.Fatalf("failed to discover peers: %s", err.Error())
+	}
+	if err := applyAdvertise(cfg, cluster.NewAdvertiseDetector()); err != nil {
+		log.Fatalf("fatal: %s", err.Error())
+	}
+
 	listenerAddresses := strings.Split(cfg.raftAddr, ",")
 	if len(listenerAddresses) == 0 {
 		log.Fatal("fatal: raft-address cannot empty")
@@ -61,55 +100,104 @@ func New(cfg *Config) (close func() error) {
 		advAddr = cfg.raftAdv
 	}
 
+	if cfg.tlsAuto {
+		if err := applyAutoCerts(cfg, append(listenerAddresses, advAddr)); err != nil {
+			log.Fatalf("failed to generate certificates: %s", err.Error())
+		}
+	}
+	var rootCAs *x509.CertPool
+	if cfg.x509CACert != "" {
+		pem, err := ioutil.ReadFile(cfg.x509CACert)
+		if err != nil {
+			log.Fatalf("ioutil.ReadFile failed: %s", err.Error())
+		}
+		rootCAs = x509.NewCertPool()
+		if !rootCAs.AppendCertsFromPEM(pem) {
+			log.Fatalf("failed to parse root CA certificate(s) in %q", cfg.x509CACert)
+		}
+	}
+
 	// Create peer communication network layer.
 	var lns []net.Listener
+	var certs, apiCerts *tcp.CertReloader
+	var raftTLS, apiTLS *tls.Config
+	if cfg.encrypt {
+		log.Printf("enabling encryption with cert: %s, key: %s", cfg.x509Cert, cfg.x509Key)
+		if certs, err = tcp.NewCertReloader(cfg.x509Cert, cfg.x509Key); err != nil {
+			log.Fatalf("failed to create tls config: %s", err.Error())
+		}
+		raftTLS = certs.TLSConfig()
+		apiTLS = raftTLS
+	}
+	if cfg.apiCert != "" {
+		log.Printf("enabling API encryption with cert: %s, key: %s", cfg.apiCert, cfg.apiKey)
+		if apiCerts, err = tcp.NewCertReloader(cfg.apiCert, cfg.apiKey); err != nil {
+			log.Fatalf("failed to create API tls config: %s", err.Error())
+		}
+		apiTLS = apiCerts.TLSConfig()
+	}
+	var acme *tcp.ACME
+	if cfg.apiACMEDomains != "" {
+		if cfg.apiCert != "" {
+			log.Fatal("fatal: an API certificate can't be used with ACME")
+		}
+		log.Printf("enabling API encryption with ACME certificates for %s", cfg.apiACMEDomains)
+		acme = tcp.NewACME(strings.Split(cfg.apiACMEDomains, ","), cfg.apiACMEEmail, cfg.apiACMEDirectory, filepath.Join(cfg.dataPath, "acme"))
+		apiTLS = acme.TLSConfig()
+	} else if cfg.apiACMEHTTPAddr != "" {
+		log.Fatal("fatal: answering ACME challenges needs ACME domains")
+	}
+	if apiTLS, err = withClientCA(apiTLS, cfg); err != nil {
+		log.Fatalf("failed to create API tls config: %s", err.Error())
+	}
+	allowList, err := cluster.ParseAllowList(cfg.allowedPeers)
+	if err != nil {
+		log.Fatalf("failed to parse allowed peers %s: %s", cfg.allowedPeers, err.Error())
+	}
+	allowList.SetLoopback(cfg.allowLoopbackPeers)
 	for _, address := range listenerAddresses {
-		if cfg.encrypt {
-			log.Printf("enabling encryption with cert: %s, key: %s", cfg.x509Cert, cfg.x509Key)
-			cfg, err := tcp.CreateTLSConfig(cfg.x509Cert, cfg.x509Key)
-			if err != nil {
-				log.Fatalf("failed to create tls config: %s", err.Error())
-			}
-			ln, err := tls.Listen("tcp", address, cfg)
-			if err != nil {
-				log.Fatalf("failed to open internode network layer: %s", err.Error())
-			}
-			lns = append(lns, ln)
-		} else {
-			ln, err := net.Listen("tcp", cfg.raftAddr)
-			if err != nil {
-				log.Fatalf("failed to open internode network layer: %s", err.Error())
-			}
-			lns = append(lns, ln)
+		ln, err := net.Listen("tcp", address)
+		if err != nil {
+			log.Fatalf("failed to open internode network layer: %s", err.Error())
 		}
+		lns = append(lns, allowList.Listener(ln))
 	}
 
-	ln, err := cluster.NewListener(lns, advAddr)
+	cln, err := cluster.NewListener(lns, advAddr)
 	if err != nil {
 		log.Fatalf("failed to create cluster listener: %s", err.Error())
 	}
+	// Raft and the API are told apart by the ALPN protocol of TLS connections.
+	ln := tcp.NewListener(cln, raftTLS, apiTLS)
 
 	// ---------------------------------------------- Setup listeners ----------------------------------------------
 	mux := cmux.New(ln)
 
 	// ----------------------------------------- Peer communication layer ------------------------------------------
-	// MATCH 1st bytes in { 0 1 2 3 }
-	raftLnBase := mux.Match(RaftRPCMatcher())
+	// MATCH 1st byte of the Raft frame magic, or in { 0 1 2 3 } for older nodes
+	raftLnBase := ln.Raft(mux.Match(tcp.RaftRPCMatcher()))
 	// ----------------------------------------- Peer communication layer ------------------------------------------
 
 	// ----------------------------------------------- Endpoint layer ----------------------------------------------
 	// MATCH ClientPreface = "PRI * HTTP/2.0\r\n\r\nSM\r\n\r\n"
-	grpcLn := mux.MatchWithWriters(cmux.HTTP2MatchHeaderFieldSendSettings("content-type", "application/grpc"))
-	// MATCH {METHOD} {URL} HTTP/1.1
-	httpLn := mux.Match(cmux.HTTP1Fast())
+	grpcLn := ln.API(mux.MatchWithWriters(cmux.HTTP2MatchHeaderFieldSendSettings("content-type", "application/grpc")))
+	// MATCH {METHOD} {URL} HTTP/1.1, PATCH is used by SCIM
+	httpLn := ln.API(mux.Match(cmux.HTTP1Fast(http.MethodPatch)))
 	// ----------------------------------------------- Endpoint layer ----------------------------------------------
 
 	go mux.Serve()
-	var raftLn *tcp.Transport
-	if cfg.encrypt {
-		raftLn = tcp.NewTransportFromListener(raftLnBase, true, cfg.noVerify, advAddr)
-	} else {
-		raftLn = tcp.NewTransportFromListener(raftLnBase, false, false, advAddr)
+	raftLn := tcp.NewTransportFromListener(raftLnBase, cfg.encrypt, cfg.noVerify, advAddr)
+	raftLn.SetRootCAs(rootCAs)
+	if err := raftLn.SetMaxMessageSize(cfg.raftMaxMessageSize); err != nil {
+		log.Fatalf("invalid Raft max message size: %s", err.Error())
+	}
+	var faults *tcp.Faults
+	if cfg.raftFaults != "" {
+		if faults, err = tcp.ParseFaults(cfg.raftFaults); err != nil {
+			log.Fatalf("failed to parse Raft faults %s: %s", cfg.raftFaults, err.Error())
+		}
+		log.Printf("WARNING: injecting faults into Raft connections: %s", faults)
+		raftLn.SetFaults(faults)
 	}
 
 	// Create and open the store.
@@ -135,12 +223,50 @@ func New(cfg *Config) (close func() error) {
 		ID:               idOrRaftAddr(cfg),
 		AuthType:         authType,
 		CredentialsStore: credentialsStore,
+		Logger:           log.New(logOutput, "[store] ", log.LstdFlags),
+		RaftLog:          logOutput,
 	})
+	raftLn.SetIdentity(tcp.Identity{NodeID: str.ID(), ClusterID: str.ClusterID})
+	// Nodes older than the framing are let in until every member frames.
+	raftLn.SetUnframed(func() bool { return !str.ClusterFramesRaft() })
+
+	var reporter *errreport.Reporter
+	var report func(error)
+	if cfg.errorReportDSN != "" {
+		reporter, err = errreport.New(errreport.Config{
+			DSN:         cfg.errorReportDSN,
+			Environment: cfg.errorReportEnvironment,
+			Release:     store.Version,
+			Tags:        map[string]string{"node_id": idOrRaftAddr(cfg)},
+		})
+		if err != nil {
+			log.Fatalf("failed to configure error reporting: %s", err.Error())
+		}
+		report = reporter.Report
+		log.Println("reporting panics and critical errors")
+	}
 
 	// Set optional parameters on store.
+	str.ReportError = report
+	if reporter != nil {
+		str.FlushReports = reporter.Flush
+	}
 	str.RaftLogLevel = cfg.raftLogLevel
 	str.ShutdownOnRemove = cfg.raftShutdownOnRemove
 	str.SnapshotThreshold = cfg.raftSnapThreshold
+	str.SnapshotBandwidth = cfg.raftSnapBandwidth
+	str.AutoPromote = cfg.raftAutoPromote
+	str.JoinToken = cfg.joinToken
+	str.RequireJoinToken = cfg.requireJoinToken
+	str.PromoteMaxLag = cfg.raftPromoteMaxLag
+	str.MinQuorum = cfg.raftMinQuorum
+	str.MinFreeDisk = cfg.diskMinFree
+	str.AutoMigrate = cfg.autoMigrate
+	str.MaxRoleDepth = cfg.maxRoleDepth
+	str.MembershipStabilization, err = time.ParseDuration(cfg.raftStabilization)
+	if err != nil {
+		log.Fatalf("failed to parse membership stabilization %s: %s", cfg.raftStabilization, err.Error())
+	}
 	str.SnapshotInterval, err = time.ParseDuration(cfg.raftSnapInterval)
 	if err != nil {
 		log.Fatalf("failed to parse Raft Snapsnot interval %s: %s", cfg.raftSnapInterval, err.Error())
@@ -157,15 +283,73 @@ func New(cfg *Config) (close func() error) {
 	if err != nil {
 		log.Fatalf("failed to parse Raft election timeout %s: %s", cfg.raftElectionTimeout, err.Error())
 	}
-	str.ApplyTimeout, err = time.ParseDuration(cfg.raftApplyTimeout)
+	str.ApplyStallTimeout, err = time.ParseDuration(cfg.applyStallTimeout)
+	if err != nil {
+		log.Fatalf("failed to parse apply stall timeout %s: %s", cfg.applyStallTimeout, err.Error())
+	}
+	switch cfg.applyStallAction {
+	case "report":
+	case "exit":
+		str.OnApplyStall = func(status store.ApplyStatus) {
+			if reporter != nil {
+				ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+				reporter.Close(ctx)
+				cancel()
+			}
+			log.Fatalf("exiting for the stalled FSM to be restarted, applied index %d", status.Applied)
+		}
+	default:
+		log.Fatalf("unknown apply stall action %q, expected report or exit", cfg.applyStallAction)
+	}
+	str.MaxClockSkew, err = time.ParseDuration(cfg.maxClockSkew)
 	if err != nil {
-		log.Fatalf("failed to parse Raft apply timeout %s: %s", cfg.raftApplyTimeout, err.Error())
+		log.Fatalf("failed to parse max clock skew %s: %s", cfg.maxClockSkew, err.Error())
 	}
+	str.DecisionTTL, err = time.ParseDuration(cfg.decisionTTL)
+	if err != nil {
+		log.Fatalf("failed to parse decision TTL %s: %s", cfg.decisionTTL, err.Error())
+	}
+	timeouts, err := parseTimeouts(cfg)
+	if err != nil {
+		log.Fatalf("failed to parse timeouts: %s", err.Error())
+	}
+	str.ApplyTimeout = timeouts.Apply.Default
+	limits, err := parseLimits(cfg, str)
+	if err != nil {
+		log.Fatalf("failed to parse request limits: %s", err.Error())
+	}
+	str.StepDownCooldown, err = time.ParseDuration(cfg.stepDownCooldown)
+	if err != nil {
+		log.Fatalf("failed to parse leader step-down cooldown %s: %s", cfg.stepDownCooldown, err.Error())
+	}
+
+	// A data directory of a newer version must not be opened, nor
+	// quarantined.
+	if _, err := store.PlanMigration(cfg.dataPath); err != nil {
+		log.Fatalf("failed to check the data directory format: %s", err.Error())
+	}
+
+	// Is the preexisting node state sound?
+	if err := store.CheckIntegrity(cfg.dataPath); err != nil {
+		if !cfg.repairOnCorruption {
+			log.Fatalf("integrity check failed: %s. Restart with -repair-on-corruption to quarantine the node state and get it again from the leader", err.Error())
+		}
+		dir, err2 := store.Quarantine(cfg.dataPath)
+		if err2 != nil {
+			log.Fatalf("integrity check failed: %s, and quarantining the node state failed: %s", err.Error(), err2.Error())
+		}
+		log.Printf("integrity check failed: %s. Node state quarantined in %s, it will be got again from the leader", err.Error(), dir)
+	}
+	repairing := store.RepairPending(cfg.dataPath)
 
 	// Any prexisting node state?
 	var enableBootstrap bool
 	isNew := store.IsNewNode(cfg.dataPath)
-	if isNew {
+	if repairing {
+		// The node is still a member of its cluster, bootstrapping would
+		// start another one.
+		log.Println("node state repaired, node is not bootstrapping")
+	} else if isNew {
 		log.Printf("no preexisting node state detected in %s, node may be bootstrapping", cfg.dataPath)
 		enableBootstrap = true // New node, so we may be bootstrapping
 	} else {
@@ -188,9 +372,57 @@ func New(cfg *Config) (close func() error) {
 		log.Println("no join addresses set")
 	}
 
-	// Join address supplied, but we don't need them!
+	// Join address supplied, but we only use them to announce the metadata
+	// of the node, which records the version it now runs.
 	if !isNew && len(joins) > 0 {
-		log.Println("node is already member of cluster, ignoring join addresses")
+		log.Println("node is already member of cluster, join addresses only used to update its metadata")
+	}
+
+	tlsConfig := tls.Config{InsecureSkipVerify: cfg.noVerify, RootCAs: rootCAs}
+	if certs != nil {
+		// Nodes present their certificate to APIs verifying clients.
+		tlsConfig.GetClientCertificate = certs.GetClientCertificate
+	}
+	authConfig := auth.AuthConfig{AuthType: authType, Username: cfg.rootUsername, Password: cfg.rootPassword}
+
+	// Followers publish their metadata, like whether they are low on disk
+	// space, by joining the leader again.
+	str.PublishMetadata = func(md map[string]string) error {
+		id, err := str.LeaderID()
+		if err != nil {
+			return err
+		}
+		addr := str.Metadata(id, "api_addr")
+		if addr == "" {
+			return fmt.Errorf("API address of leader %s unknown", id)
+		}
+		if proto := str.Metadata(id, "api_proto"); proto != "" {
+			addr = proto + "://" + addr
+		}
+		_, err = cluster.Join(cfg.joinSrcIP, []string{addr}, str.ID(), advAddr, !cfg.raftNonVoter, md, cfg.joinToken, 1, 0, &tlsConfig, authConfig)
+		return err
+	}
+
+	// A repaired node lost its log, it must not vote until it caught up
+	// again: have the leader remove it and add it back as a non-voter
+	// before it opens.
+	if repairing {
+		if len(joins) == 0 {
+			log.Fatal("node state repaired, but no join addresses to rejoin its cluster through, set -join")
+		}
+		joinDur, err := time.ParseDuration(cfg.joinInterval)
+		if err != nil {
+			log.Fatalf("failed to parse Join interval %s: %s", cfg.joinInterval, err.Error())
+		}
+		md := map[string]string{store.RepairKey: "true"}
+		if _, err := cluster.Join(cfg.joinSrcIP, joins, str.ID(), advAddr, !cfg.raftNonVoter, md, cfg.presentedJoinToken(),
+			cfg.joinAttempts, joinDur, &tlsConfig, authConfig); err != nil {
+			log.Fatalf("node state repaired, but failed to rejoin its cluster at %s: %s", joins, err.Error())
+		}
+		if err := store.FinishRepair(cfg.dataPath); err != nil {
+			log.Fatalf("failed to record the repair of the node state: %s", err.Error())
+		}
+		log.Println("node rejoined its cluster as a non-voter, it votes again once it caught up")
 	}
 
 	// Now, open store.
@@ -199,45 +431,37 @@ func New(cfg *Config) (close func() error) {
 	}
 
 	// Prepare metadata for join command.
-	apiAdv := cfg.raftAddr
-	apiProto := "http"
-	if cfg.x509Cert != "" {
-		apiProto = "https"
+	apiAdv := advAddr
+	apiProto := cfg.apiScheme()
+	meta, err := parseNodeMetadata(cfg.nodeMetadata)
+	if err != nil {
+		log.Fatalf("failed to parse node metadata %s: %s", cfg.nodeMetadata, err.Error())
 	}
-	meta := map[string]string{
-		"api_addr":  apiAdv,
-		"api_proto": apiProto,
+	meta["api_addr"] = apiAdv
+	meta["api_proto"] = apiProto
+	meta[store.VersionKey] = store.Version
+	meta[store.FSMVersionKey] = strconv.Itoa(store.FSMVersion)
+	meta[store.RaftFramingKey] = "true"
+	if id := str.ClusterID(); id != "" {
+		meta[store.ClusterIDKey] = id
 	}
 
-	// Execute any requested join operation.
-	if len(joins) > 0 && isNew {
+	// Execute any requested join operation. Nodes already members only have
+	// their metadata updated.
+	if len(joins) > 0 {
 		log.Println("join addresses are:", joins)
-		advAddr := cfg.raftAddr
-		if cfg.raftAdv != "" {
-			advAddr = cfg.raftAdv
-		}
 
 		joinDur, err := time.ParseDuration(cfg.joinInterval)
 		if err != nil {
 			log.Fatalf("failed to parse Join interval %s: %s", cfg.joinInterval, err.Error())
 		}
 
-		tlsConfig := tls.Config{InsecureSkipVerify: cfg.noVerify}
-		if cfg.x509CACert != "" {
-			asn1Data, err := ioutil.ReadFile(cfg.x509CACert)
-			if err != nil {
-				log.Fatalf("ioutil.ReadFile failed: %s", err.Error())
+		if j, err := cluster.Join(cfg.joinSrcIP, joins, str.ID(), advAddr, !cfg.raftNonVoter, meta, cfg.presentedJoinToken(),
+			cfg.joinAttempts, joinDur, &tlsConfig, authConfig); err != nil {
+			if isNew {
+				log.Fatalf("failed to join cluster at %s: %s", joins, err.Error())
 			}
-			tlsConfig.RootCAs = x509.NewCertPool()
-			ok := tlsConfig.RootCAs.AppendCertsFromPEM([]byte(asn1Data))
-			if !ok {
-				log.Fatalf("failed to parse root CA certificate(s) in %q", cfg.x509CACert)
-			}
-		}
-
-		if j, err := cluster.Join(cfg.joinSrcIP, joins, str.ID(), advAddr, !cfg.raftNonVoter, meta,
-			cfg.joinAttempts, joinDur, &tlsConfig, auth.AuthConfig{AuthType: authType, Username: cfg.rootUsername, Password: cfg.rootPassword}); err != nil {
-			log.Fatalf("failed to join cluster at %s: %s", joins, err.Error())
+			log.Printf("failed to update metadata through %s: %s", joins, err.Error())
 		} else {
 			log.Println("successfully joined cluster at", j)
 		}
@@ -261,46 +485,194 @@ func New(cfg *Config) (close func() error) {
 		log.Fatalf("failed to set store metadata: %s", err.Error())
 	}
 
+	r := &reloader{cfg: *cfg, str: str, certs: certs, apiCerts: apiCerts, faults: faults, allowList: allowList}
 	c := core.New(str)
+	if cfg.dev {
+		if err := initDevNamespace(c, cfg); err != nil {
+			log.Fatalf("failed to create the dev namespace: %s", err.Error())
+		}
+	}
+	var publisher *events.Publisher
+	if cfg.eventSink != "" {
+		if publisher, err = startEvents(c, cfg); err != nil {
+			log.Fatalf("failed to start event sink: %s", err.Error())
+		}
+		if cfg.eventDecisionTopic != "" {
+			c = core.ObserveDecisions(c, publisher.Decision)
+		}
+	}
 	//Start the HTTP API server.
-	if err = startHTTPService(c, httpLn); err != nil {
+	authorizer, err := newAuthorizer(c, cfg)
+	if err != nil {
+		log.Fatalf("failed to configure external authorization: %s", err.Error())
+	}
+	var envoyAuthorizer, forwardAuthorizer *extauthz.Authorizer
+	if cfg.extAuthz {
+		envoyAuthorizer = authorizer
+	}
+	if cfg.forwardAuth {
+		forwardAuthorizer = authorizer
+	}
+	var httpCloser, grpcCloser func(ctx context.Context)
+	var opaMapping extauthz.OPAMapping
+	if cfg.opaShim {
+		if opaMapping, err = extauthz.ParseOPAMapping(cfg.opaInputMapping); err != nil {
+			log.Fatalf("failed to configure OPA shim: %s", err.Error())
+		}
+	}
+	var scimServer *scim.Server
+	if cfg.scimNamespace != "" {
+		scimServer = scim.New(scim.Config{
+			BasePath:    scimPath,
+			Namespace:   cfg.scimNamespace,
+			PType:       "g",
+			GroupPrefix: cfg.scimGroupPrefix,
+			UsersRole:   cfg.scimUsersRole,
+			GroupsRole:  cfg.scimGroupsRole,
+			Token:       cfg.scimToken,
+		}, c)
+	}
+	certAuth, err := newCertAuth(cfg)
+	if err != nil {
+		log.Fatalf("failed to configure client certificate authentication: %s", err.Error())
+	}
+	var standbyAgent *standby.Agent
+	if cfg.standbyOf != "" {
+		if standbyAgent, err = newStandbyAgent(c, cfg, &tls.Config{InsecureSkipVerify: cfg.noVerify, RootCAs: rootCAs}); err != nil {
+			log.Fatalf("failed to configure standby replication: %s", err.Error())
+		}
+	}
+	var wsOrigins []string
+	for _, origin := range strings.Split(cfg.enforceWSOrigins, ",") {
+		if origin = strings.TrimSpace(origin); origin != "" {
+			wsOrigins = append(wsOrigins, origin)
+		}
+	}
+	var accessLog *accesslog.Logger
+	if cfg.accessLog {
+		accessLog, err = accesslog.New(accesslog.Config{
+			Format:     cfg.accessLogFormat,
+			Output:     cfg.accessLogOutput,
+			SampleRate: cfg.accessLogSample,
+		})
+		if err != nil {
+			log.Fatalf("failed to open access log: %s", err.Error())
+		}
+	}
+	if httpCloser, err = startHTTPService(c, httpLn, timeouts, limits, r.reload, forwardAuthorizer, opaMapping, scimServer, certAuth, standbyAgent, cfg.ui, wsOrigins, accessLog, report); err != nil {
 		log.Fatalf("failed to start HTTP server: %s", err.Error())
 	}
-	var grpcCloser func()
-	if grpcCloser, err = startGrpcService(c, grpcLn); err != nil {
+	if grpcCloser, err = startGrpcService(c, grpcLn, timeouts, limits, envoyAuthorizer, certAuth, report); err != nil {
 		log.Fatalf("failed to start grpc server: %s", err.Error())
 	}
 
+	closers := []func(ctx context.Context){httpCloser, grpcCloser}
+	clockProbe, err := time.ParseDuration(cfg.clockProbeInterval)
+	if err != nil {
+		log.Fatalf("failed to parse clock probe interval %s: %s", cfg.clockProbeInterval, err.Error())
+	}
+	if clockProbe > 0 {
+		closers = append(closers, startClockProbe(str, clockProbe, &tlsConfig, authConfig))
+	}
+	if acme != nil && cfg.apiACMEHTTPAddr != "" {
+		acmeCloser, err := startACMEChallenges(cfg.apiACMEHTTPAddr, acme)
+		if err != nil {
+			log.Fatalf("failed to start ACME HTTP-01 challenges: %s", err.Error())
+		}
+		closers = append(closers, acmeCloser)
+	}
+	expiryInterval, err := time.ParseDuration(cfg.expiryInterval)
+	if err != nil {
+		log.Fatalf("failed to parse policy expiry interval %s: %s", cfg.expiryInterval, err.Error())
+	}
+	janitor := expiry.New(c, expiryInterval)
+	janitor.Start()
+	closers = append(closers, janitor.Close)
+	if cfg.webhookConfig != "" {
+		webhooks, err := startWebhooks(c, cfg.webhookConfig)
+		if err != nil {
+			log.Fatalf("failed to start webhooks: %s", err.Error())
+		}
+		closers = append(closers, webhooks)
+	}
+	if cfg.ldapSyncConfig != "" {
+		ldapSync, err := startLDAPSync(c, cfg.ldapSyncConfig)
+		if err != nil {
+			log.Fatalf("failed to start LDAP sync: %s", err.Error())
+		}
+		closers = append(closers, ldapSync)
+	}
+	if standbyAgent != nil {
+		standbyAgent.Start()
+		log.Printf("replicating the primary cluster at %s", cfg.standbyOf)
+		closers = append(closers, standbyAgent.Close)
+	}
+	if publisher != nil {
+		closers = append(closers, publisher.Close)
+	}
+	if accessLog != nil {
+		closers = append(closers, func(ctx context.Context) {
+			if err := accessLog.Close(); err != nil {
+				log.Printf("failed to close access log: %s", err.Error())
+			}
+		})
+	}
+	if reporter != nil {
+		closers = append(closers, reporter.Close)
+	}
+
+	shutdownTimeout, err := time.ParseDuration(cfg.shutdownTimeout)
+	if err != nil {
+		log.Fatalf("failed to parse shutdown timeout %s: %s", cfg.shutdownTimeout, err.Error())
+	}
+
 	log.Println("node is ready")
 
 	close = func() error {
-		if err := str.Close(true); err != nil {
-			log.Printf("failed to close store: %s", err.Error())
+		log.Printf("shutting down, waiting up to %s", shutdownTimeout)
+		ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		done := make(chan error, 1)
+		go func() {
+			done <- shutdown(ctx, str, closers...)
+		}()
+		select {
+		case err = <-done:
+		case <-ctx.Done():
+			err = fmt.Errorf("shutdown did not complete within %s", shutdownTimeout)
+			log.Println(err.Error())
 		}
 		mux.Close()
-		grpcCloser()
 		stopProfile()
 		log.Println("casbin-mesh server stopped")
 
 		return err
 	}
-	return close
+	return close, r.reload
 }
 
-func RaftRPCMatcher() cmux.Matcher {
-	return func(r io.Reader) bool {
-		br := bufio.NewReader(&io.LimitedReader{R: r, N: 1})
-		byt, err := br.ReadByte()
-		if err != nil {
-			log.Printf("Raft RPC Unmatched incoming: %s\n", err)
-			return false
-		}
-		switch byt {
-		case 0, 1, 2, 3:
-			return true
+// shutdown stops the node in order: API traffic is no longer accepted and
+// in-flight requests are drained, leadership is handed over so that the
+// cluster doesn't wait for an election timeout, and the store is closed.
+func shutdown(ctx context.Context, str *store.Store, closers ...func(ctx context.Context)) error {
+	str.CloseWatches()
+	for _, closer := range closers {
+		closer(ctx)
+	}
+	log.Println("API services stopped")
+
+	if str.IsLeader() {
+		if err := str.TransferLeadership(""); err != nil {
+			log.Printf("failed to transfer leadership: %s", err.Error())
 		}
-		return false
 	}
+
+	if err := str.Close(true); err != nil {
+		log.Printf("failed to close store: %s", err.Error())
+		return err
+	}
+	log.Println("store closed")
+	return nil
 }
 
 func determineJoinAddresses(cfg *Config) ([]string, error) {
@@ -339,33 +711,410 @@ func waitForConsensus(str *store.Store, cfg *Config) error {
 	return nil
 }
 
-func startHTTPService(c core.Core, ln net.Listener) error {
-	httpd := core.NewHttpService(c)
-	handler := cors.AllowAll().Handler(httpd)
+func startHTTPService(c core.Core, ln net.Listener, timeouts *core.Timeouts, limits *core.Limits, reload func() error, authorizer *extauthz.Authorizer, opaMapping extauthz.OPAMapping, scimServer *scim.Server, certAuth *auth.CertAuth, standbyAgent *standby.Agent, enableUI bool, wsOrigins []string, accessLog *accesslog.Logger, report func(error)) (close func(ctx context.Context), err error) {
+	httpd := core.NewHttpService(c, timeouts)
+	httpd.EnableReload(reload)
+	if report != nil {
+		httpd.EnableErrorReport(report)
+	}
+	if limits != nil {
+		httpd.EnableLimits(limits)
+	}
+	if authorizer != nil {
+		httpd.EnableForwardAuth(authorizer)
+	}
+	if opaMapping != nil {
+		httpd.EnableOPA(opaMapping)
+	}
+	if scimServer != nil {
+		httpd.EnableSCIM(scimPath, scimServer)
+	}
+	if standbyAgent != nil {
+		httpd.EnableStandby(standbyAgent)
+	}
+	if enableUI {
+		httpd.EnableUI(uiPath, ui.Handler(uiPath))
+	}
+	if len(wsOrigins) > 0 {
+		httpd.EnableEnforceWSOrigins(wsOrigins)
+	}
+	var h http.Handler = cors.AllowAll().Handler(httpd)
+	if accessLog != nil {
+		h = accessLog.Handler(h)
+	}
+	srv := &http.Server{Handler: h}
+	if certAuth != nil {
+		httpd.EnableCertAuth(certAuth)
+		srv.ConnContext = handler.CertPrincipal(certAuth.Mapping)
+	}
 	go func() {
-		err := http.Serve(ln, handler)
-		if err != nil {
+		err := srv.Serve(ln)
+		if err != nil && err != http.ErrServerClosed {
 			log.Println("HTTP service Serve() returned:", err.Error())
 		}
 	}()
+	close = func(ctx context.Context) {
+		if err := srv.Shutdown(ctx); err != nil {
+			log.Printf("failed to drain HTTP requests: %s", err.Error())
+			srv.Close()
+		}
+	}
+	return close, nil
+}
 
+// startACMEChallenges answers the ACME HTTP-01 challenges on addr.
+func startACMEChallenges(addr string, acme *tcp.ACME) (close func(ctx context.Context), err error) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	srv := &http.Server{Handler: acme.HTTPHandler()}
+	go func() {
+		err := srv.Serve(ln)
+		if err != nil && err != http.ErrServerClosed {
+			log.Println("ACME challenge service Serve() returned:", err.Error())
+		}
+	}()
+	close = func(ctx context.Context) {
+		if err := srv.Shutdown(ctx); err != nil {
+			srv.Close()
+		}
+	}
+	return close, nil
+}
+
+// applyAutoCerts enables encryption with the certificates generated in the
+// data directory, valid for the hosts of addrs. Certificates set explicitly
+// take precedence.
+func applyAutoCerts(cfg *Config, addrs []string) error {
+	hosts := []string{"localhost", "127.0.0.1", "::1"}
+	if name, err := os.Hostname(); err == nil && name != "localhost" {
+		hosts = append(hosts, name)
+	}
+	seen := make(map[string]bool)
+	for _, h := range hosts {
+		seen[h] = true
+	}
+	for _, addr := range addrs {
+		host, _, err := net.SplitHostPort(addr)
+		if err != nil {
+			return err
+		}
+		if ip := net.ParseIP(host); host == "" || ip != nil && ip.IsUnspecified() {
+			continue
+		}
+		if !seen[host] {
+			seen[host] = true
+			hosts = append(hosts, host)
+		}
+	}
+	a, err := tcp.EnsureAutoCerts(filepath.Join(cfg.dataPath, "tls"), hosts)
+	if err != nil {
+		return err
+	}
+	log.Printf("using certificates generated in %s, copy ca.pem and ca-key.pem to the data directory of other nodes before they first start", filepath.Dir(a.CAFile))
+	cfg.encrypt = true
+	if cfg.x509Cert == "" {
+		cfg.x509Cert, cfg.x509Key = a.CertFile, a.KeyFile
+	}
+	if cfg.x509CACert == "" {
+		cfg.x509CACert = a.CAFile
+	}
 	return nil
 }
 
-func startGrpcService(c core.Core, ln net.Listener) (close func(), err error) {
-	grpcd := core.NewGrpcService(c)
+// withClientCA returns the API TLS config c verifying client certificates
+// with the API client CA, if one is set.
+func withClientCA(c *tls.Config, cfg *Config) (*tls.Config, error) {
+	if cfg.apiClientCA == "" {
+		if cfg.apiRequireClientCert {
+			return nil, errors.New("requiring client certificates needs an API client CA")
+		}
+		return c, nil
+	}
+	if c == nil {
+		return nil, errors.New("an API client CA needs API encryption")
+	}
+	pem, err := ioutil.ReadFile(cfg.apiClientCA)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("failed to parse CA certificate(s) in %q", cfg.apiClientCA)
+	}
+	c = c.Clone()
+	c.ClientCAs = pool
+	c.ClientAuth = tls.VerifyClientCertIfGiven
+	if cfg.apiRequireClientCert {
+		c.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return c, nil
+}
+
+// newCertAuth returns the authentication of API clients by their
+// certificate, or nil if the API doesn't verify client certificates.
+func newCertAuth(cfg *Config) (*auth.CertAuth, error) {
+	if cfg.apiClientCA == "" {
+		if cfg.apiPrincipalScopes != "" {
+			return nil, errors.New("principal scopes need an API client CA")
+		}
+		return nil, nil
+	}
+	mapping, err := auth.ParsePrincipalMapping(cfg.apiPrincipalMapping)
+	if err != nil {
+		return nil, err
+	}
+	ca := &auth.CertAuth{Mapping: mapping}
+	if cfg.apiPrincipalScopes != "" {
+		if ca.Scopes, err = auth.ParseScopes(cfg.apiPrincipalScopes); err != nil {
+			return nil, err
+		}
+	}
+	return ca, nil
+}
+
+// parseNodeMetadata parses the key=value pairs of spec. The keys set by the
+// node itself, describing its API and version, are reserved.
+func parseNodeMetadata(spec string) (map[string]string, error) {
+	meta := make(map[string]string)
+	if spec == "" {
+		return meta, nil
+	}
+	for _, item := range strings.Split(spec, ",") {
+		kv := strings.SplitN(strings.TrimSpace(item), "=", 2)
+		if len(kv) != 2 || kv[0] == "" {
+			return nil, fmt.Errorf("invalid pair %q, expected key=value", item)
+		}
+		switch kv[0] {
+		case "api_addr", "api_proto", store.PromotionKey, store.VersionKey, store.FSMVersionKey, store.RaftFramingKey, store.DiskLowKey, store.ClusterIDKey, store.RepairKey:
+			return nil, fmt.Errorf("key %s is reserved", kv[0])
+		}
+		meta[kv[0]] = kv[1]
+	}
+	return meta, nil
+}
+
+// parseTimeouts parses the request, Raft apply and leader forwarding
+// timeouts.
+func parseTimeouts(cfg *Config) (*core.Timeouts, error) {
+	var t core.Timeouts
+	var err error
+	if t.Request, err = core.ParseTimeoutRules(cfg.requestTimeout); err != nil {
+		return nil, fmt.Errorf("request timeout %s: %s", cfg.requestTimeout, err.Error())
+	}
+	if t.Apply, err = core.ParseTimeoutRules(cfg.raftApplyTimeout); err != nil {
+		return nil, fmt.Errorf("Raft apply timeout %s: %s", cfg.raftApplyTimeout, err.Error())
+	}
+	if t.Forward, err = core.ParseTimeoutRules(cfg.forwardTimeout); err != nil {
+		return nil, fmt.Errorf("forward timeout %s: %s", cfg.forwardTimeout, err.Error())
+	}
+	return &t, nil
+}
+
+// parseLimits returns the limits of the requests served at once, their
+// priority classes and the load shedding thresholds, nil if no limit is set.
+func parseLimits(cfg *Config, str *store.Store) (*core.Limits, error) {
+	wait, err := time.ParseDuration(cfg.requestQueueWait)
+	if err != nil {
+		return nil, fmt.Errorf("request queue wait %s: %s", cfg.requestQueueWait, err.Error())
+	}
+	applyLatency, err := time.ParseDuration(cfg.shedApplyLatency)
+	if err != nil {
+		return nil, fmt.Errorf("shed apply latency %s: %s", cfg.shedApplyLatency, err.Error())
+	}
+	fsmWait, err := time.ParseDuration(cfg.shedFSMWait)
+	if err != nil {
+		return nil, fmt.Errorf("shed FSM wait %s: %s", cfg.shedFSMWait, err.Error())
+	}
+	priorities, err := core.ParsePriorityRules(cfg.priorityClasses)
+	if err != nil {
+		return nil, fmt.Errorf("priority classes %s: %s", cfg.priorityClasses, err.Error())
+	}
+	if cfg.maxEnforceRequests == 0 && cfg.maxWriteRequests == 0 && cfg.maxRequests == 0 && applyLatency == 0 && fsmWait == 0 {
+		return nil, nil
+	}
+	l := core.Limits{Priorities: priorities}
+	if cfg.maxEnforceRequests > 0 {
+		l.Enforce = limit.New(limit.Config{Concurrency: cfg.maxEnforceRequests, Queue: cfg.requestQueueSize, MaxWait: wait})
+	}
+	if cfg.maxWriteRequests > 0 {
+		l.Write = limit.New(limit.Config{Concurrency: cfg.maxWriteRequests, Queue: cfg.requestQueueSize, MaxWait: wait})
+	}
+	if cfg.maxRequests > 0 {
+		l.Shared = limit.New(limit.Config{Concurrency: cfg.maxRequests, Queue: cfg.requestQueueSize, MaxWait: wait})
+	}
+	if applyLatency > 0 || fsmWait > 0 {
+		l.Shed = limit.NewShedder(shedInterval,
+			limit.Threshold{Name: "apply_latency", Signal: str.ApplyLatency, Max: applyLatency},
+			limit.Threshold{Name: "fsm_wait", Signal: str.FSMWait, Max: fsmWait})
+	}
+	return &l, nil
+}
+
+// newAuthorizer returns the authorizer shared by the Envoy external
+// authorization service and the forward-auth endpoint, or nil if both are
+// disabled.
+func newAuthorizer(c core.Core, cfg *Config) (*extauthz.Authorizer, error) {
+	if !cfg.extAuthz && !cfg.forwardAuth {
+		return nil, nil
+	}
+	mapping, err := extauthz.ParseMapping(cfg.extAuthzMapping)
+	if err != nil {
+		return nil, err
+	}
+	return &extauthz.Authorizer{Enforcer: c, Namespace: cfg.extAuthzNamespace, Mapping: mapping}, nil
+}
+
+func startGrpcService(c core.Core, ln net.Listener, timeouts *core.Timeouts, limits *core.Limits, authorizer *extauthz.Authorizer, certAuth *auth.CertAuth, report func(error)) (close func(ctx context.Context), err error) {
+	grpcd := core.NewGrpcService(c, timeouts, certAuth, limits, report)
+	if authorizer != nil {
+		extauthz.Register(grpcd, authorizer)
+	}
+	shutdownHealth := core.RegisterHealthServer(grpcd, c)
 	go func() {
 		err := grpcd.Serve(ln)
 		if err != nil {
 			log.Println("Grpc service Serve() returned:", err.Error())
 		}
 	}()
-	close = func() {
-		grpcd.Stop()
+	close = func(ctx context.Context) {
+		shutdownHealth()
+		stopped := make(chan struct{}, 1)
+		go func() {
+			grpcd.GracefulStop()
+			stopped <- struct{}{}
+		}()
+		select {
+		case <-stopped:
+		case <-ctx.Done():
+			log.Println("failed to drain grpc requests:", ctx.Err().Error())
+			grpcd.Stop()
+		}
 	}
 	return close, nil
 }
 
+func startWebhooks(c core.Core, path string) (close func(ctx context.Context), err error) {
+	whCfg, err := webhook.LoadConfig(path)
+	if err != nil {
+		return nil, err
+	}
+	d, err := webhook.New(whCfg, c)
+	if err != nil {
+		return nil, err
+	}
+	d.Start()
+	log.Printf("notifying %d webhooks of changes", len(whCfg.Hooks))
+	return d.Close, nil
+}
+
+// startClockProbe compares the clock of the node with the clocks of the
+// other nodes every interval, besides the comparisons made over the requests
+// forwarded to the leader.
+func startClockProbe(str *store.Store, interval time.Duration, tlsConfig *tls.Config, authConfig auth.AuthConfig) func(ctx context.Context) {
+	done := make(chan struct{})
+	go func() {
+		t := time.NewTicker(interval)
+		defer t.Stop()
+		for {
+			select {
+			case <-t.C:
+			case <-done:
+				return
+			}
+			nodes, err := str.Nodes()
+			if err != nil {
+				continue
+			}
+			for _, n := range nodes {
+				addr := n.Metadata["api_addr"]
+				if n.ID == str.ID() || addr == "" {
+					continue
+				}
+				if proto := n.Metadata["api_proto"]; proto != "" {
+					addr = proto + "://" + addr
+				}
+				// unreachable nodes are reported by Raft already
+				if sample, err := cluster.ReadClock(addr, tlsConfig, authConfig); err == nil {
+					str.ObserveClock(sample.NodeID, sample.Sent, sample.Received, sample.Remote)
+				}
+			}
+		}
+	}()
+	return func(ctx context.Context) {
+		close(done)
+	}
+}
+
+func startLDAPSync(c core.Core, path string) (close func(ctx context.Context), err error) {
+	syncCfg, err := ldapsync.LoadConfig(path)
+	if err != nil {
+		return nil, err
+	}
+	s, err := ldapsync.New(syncCfg, ldapsync.NewLDAPDirectory(syncCfg), c)
+	if err != nil {
+		return nil, err
+	}
+	s.Start()
+	log.Printf("syncing LDAP groups of %s into %s every %s", syncCfg.URL, syncCfg.Namespace, syncCfg.Interval)
+	return s.Close, nil
+}
+
+func newStandbyAgent(c core.Core, cfg *Config, tlsConfig *tls.Config) (*standby.Agent, error) {
+	wait, err := time.ParseDuration(cfg.standbyWait)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse standby wait %s: %s", cfg.standbyWait, err.Error())
+	}
+	var primary, namespaces []string
+	for _, addr := range strings.Split(cfg.standbyOf, ",") {
+		if addr = strings.TrimSpace(addr); addr != "" {
+			primary = append(primary, addr)
+		}
+	}
+	for _, ns := range strings.Split(cfg.standbyNamespaces, ",") {
+		if ns = strings.TrimSpace(ns); ns == "" {
+			continue
+		}
+		if _, err := path.Match(ns, ""); err != nil {
+			return nil, fmt.Errorf("invalid namespace pattern %q", ns)
+		}
+		namespaces = append(namespaces, ns)
+	}
+	return standby.New(standby.Config{
+		Primary:    primary,
+		Username:   cfg.standbyUsername,
+		Password:   cfg.standbyPassword,
+		TLS:        tlsConfig,
+		Wait:       wait,
+		Namespaces: namespaces,
+	}, c)
+}
+
+func startEvents(c core.Core, cfg *Config) (*events.Publisher, error) {
+	if cfg.eventDecisionSample < 0 || cfg.eventDecisionSample > 1 {
+		return nil, fmt.Errorf("decision sample %v is not between 0 and 1", cfg.eventDecisionSample)
+	}
+	var addrs []string
+	if cfg.eventSinkAddr != "" {
+		addrs = strings.Split(cfg.eventSinkAddr, ",")
+	}
+	p, err := events.New(events.Config{
+		Sink:          cfg.eventSink,
+		Addrs:         addrs,
+		ChangeTopic:   cfg.eventChangeTopic,
+		DecisionTopic: cfg.eventDecisionTopic,
+		SampleRate:    cfg.eventDecisionSample,
+		NodeID:        idOrRaftAddr(cfg),
+	}, c)
+	if err != nil {
+		return nil, err
+	}
+	p.Start()
+	log.Printf("publishing events to %s", cfg.eventSink)
+	return p, nil
+}
+
 func idOrRaftAddr(cfg *Config) string {
 	if cfg.nodeID != "" {
 		return cfg.nodeID
@@ -0,0 +1,74 @@
+// Copyright 2022 The casbin-mesh Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/casbin/casbin-mesh/pkg/testkit"
+	"github.com/casbin/casbin-mesh/proto/command"
+)
+
+func Test_ShutdownOrder(t *testing.T) {
+	c := testkit.NewCluster(t, 2)
+	leader := c.Leader()
+	var follower *testkit.Node
+	for _, n := range c.Nodes {
+		if n != leader {
+			follower = n
+		}
+	}
+
+	watched := make(chan error, 1)
+	go func() {
+		watched <- leader.Store.Watch(context.Background(), "", 0, func(*command.WatchEvent) error { return nil })
+	}()
+	// let the watch subscribe
+	time.Sleep(100 * time.Millisecond)
+
+	var order []string
+	closer := func(name string) func(ctx context.Context) {
+		return func(ctx context.Context) {
+			// watches are closed first, the store serves in-flight
+			// requests until every API is stopped
+			if len(order) == 0 {
+				select {
+				case <-watched:
+				case <-time.After(time.Second):
+					t.Errorf("%s: watch still open", name)
+				}
+			}
+			if !leader.Store.IsLeader() {
+				t.Errorf("%s: leadership transferred before the APIs stopped", name)
+			}
+			order = append(order, name)
+		}
+	}
+	if err := shutdown(context.Background(), leader.Store, closer("http"), closer("grpc")); err != nil {
+		t.Fatalf("failed to shut down: %s", err.Error())
+	}
+	if len(order) != 2 || order[0] != "http" || order[1] != "grpc" {
+		t.Fatalf("closers ran in order %v", order)
+	}
+	// leadership was handed over rather than lost on an election timeout
+	if !follower.Store.IsLeader() {
+		t.Fatalf("expected %s to lead once %s shut down", follower.ID, leader.ID)
+	}
+	if leader.Store.IsLeader() {
+		t.Fatalf("expected the store of %s closed", leader.ID)
+	}
+}
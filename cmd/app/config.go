@@ -0,0 +1,174 @@
+// Copyright 2022 The casbin-mesh Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"github.com/casbin/casbin-mesh/pkg/cluster"
+	"gopkg.in/yaml.v3"
+)
+
+// envPrefix is the prefix of the environment variables overriding flags, a
+// flag such as -raft-address is read from CASBIN_MESH_RAFT_ADDRESS.
+const envPrefix = "CASBIN_MESH_"
+
+// dataDirKey is the config key of the data directory, which is otherwise
+// given as the positional argument.
+const dataDirKey = "data-dir"
+
+// containerRaftAddr is the default Raft address in a container.
+const containerRaftAddr = "0.0.0.0:4002"
+
+// secretFlags are masked by -print-config.
+var secretFlags = map[string]bool{
+	"root-password":    true,
+	"standby-password": true,
+	"scim-token":       true,
+}
+
+func envName(flagName string) string {
+	return envPrefix + strings.ToUpper(strings.ReplaceAll(flagName, "-", "_"))
+}
+
+// readConfigFile reads a flat YAML or TOML file whose keys are flag names.
+func readConfigFile(path string) (map[string]interface{}, error) {
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	values := make(map[string]interface{})
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".toml":
+		err = toml.Unmarshal(buf, &values)
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(buf, &values)
+	default:
+		return nil, fmt.Errorf("unsupported config file %s, must be .yaml, .yml or .toml", path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse config file %s: %s", path, err.Error())
+	}
+	return values, nil
+}
+
+func configValue(v interface{}) string {
+	if list, ok := v.([]interface{}); ok {
+		s := make([]string, len(list))
+		for i := range list {
+			s[i] = fmt.Sprint(list[i])
+		}
+		return strings.Join(s, ",")
+	}
+	return fmt.Sprint(v)
+}
+
+// applyConfig sets the flags not given on the command line from the config
+// file at path, if any, and then from the environment, so that flags take
+// precedence over the environment, which takes precedence over the file.
+func applyConfig(fs *flag.FlagSet, path string, dataPath *string) error {
+	explicit := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) {
+		explicit[f.Name] = true
+	})
+
+	if path == "" {
+		path = os.Getenv(envName("config"))
+	}
+	if path != "" {
+		values, err := readConfigFile(path)
+		if err != nil {
+			return err
+		}
+		for k, v := range values {
+			if k == dataDirKey {
+				if *dataPath == "" {
+					*dataPath = configValue(v)
+				}
+				continue
+			}
+			if fs.Lookup(k) == nil {
+				return fmt.Errorf("unknown key %q in config file %s", k, path)
+			}
+			if explicit[k] {
+				continue
+			}
+			if err = fs.Set(k, configValue(v)); err != nil {
+				return fmt.Errorf("invalid value for %q in config file %s: %s", k, path, err.Error())
+			}
+		}
+	}
+
+	var err error
+	fs.VisitAll(func(f *flag.Flag) {
+		v, ok := os.LookupEnv(envName(f.Name))
+		if !ok || explicit[f.Name] || err != nil {
+			return
+		}
+		if e := fs.Set(f.Name, v); e != nil {
+			err = fmt.Errorf("invalid value for %s: %s", envName(f.Name), e.Error())
+		}
+	})
+	if v, ok := os.LookupEnv(envName(dataDirKey)); ok && *dataPath == "" {
+		*dataPath = v
+	}
+	if err != nil {
+		return err
+	}
+	return applyContainerDefaults(fs)
+}
+
+// applyContainerDefaults binds Raft and the API to all interfaces when
+// running in a container, from outside of which the default localhost
+// address can't be reached, unless the address was configured.
+func applyContainerDefaults(fs *flag.FlagSet) error {
+	if !cluster.InContainer() {
+		return nil
+	}
+	set := false
+	fs.Visit(func(f *flag.Flag) {
+		set = set || f.Name == "raft-address"
+	})
+	if set {
+		return nil
+	}
+	return fs.Set("raft-address", containerRaftAddr)
+}
+
+// printConfig writes the effective configuration in the config file format.
+func printConfig(fs *flag.FlagSet, dataPath string) {
+	var lines []string
+	fs.VisitAll(func(f *flag.Flag) {
+		switch f.Name {
+		case "config", "print-config", "version":
+			return
+		}
+		v := f.Value.String()
+		if secretFlags[f.Name] && v != "" {
+			v = "********"
+		}
+		lines = append(lines, fmt.Sprintf("%s: %q", f.Name, v))
+	})
+	lines = append(lines, fmt.Sprintf("%s: %q", dataDirKey, dataPath))
+	sort.Strings(lines)
+	fmt.Println(strings.Join(lines, "\n"))
+}
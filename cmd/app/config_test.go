@@ -0,0 +1,106 @@
+// Copyright 2022 The casbin-mesh Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func Test_ConfigPrecedence(t *testing.T) {
+	dir, err := ioutil.TempDir("", "casbin-mesh-config-")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %s", err.Error())
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "config.yaml")
+	file := "node-id: file\nraft-address: file:4002\njoin-attempts: 7\njoin: [a:4001, b:4001]\ndata-dir: /data/file\n"
+	if err := ioutil.WriteFile(path, []byte(file), 0644); err != nil {
+		t.Fatalf("failed to write config file: %s", err.Error())
+	}
+	os.Setenv(envName("node-id"), "env")
+	os.Setenv(envName("raft-address"), "env:4002")
+	defer os.Unsetenv(envName("node-id"))
+	defer os.Unsetenv(envName("raft-address"))
+
+	cfg, err := loadConfig([]string{"-config", path, "-node-id", "flag"})
+	if err != nil {
+		t.Fatalf("failed to load config: %s", err.Error())
+	}
+	// flags over the environment, over the file
+	for _, c := range []struct{ got, exp string }{
+		{cfg.nodeID, "flag"},
+		{cfg.raftAddr, "env:4002"},
+		{cfg.dataPath, "/data/file"},
+		{cfg.joinAddr, "a:4001,b:4001"},
+	} {
+		if c.got != c.exp {
+			t.Fatalf("got %q, expected %q", c.got, c.exp)
+		}
+	}
+	if cfg.joinAttempts != 7 {
+		t.Fatalf("got %d join attempts, expected 7 from the file", cfg.joinAttempts)
+	}
+
+	// the positional data directory wins over the file
+	cfg, err = loadConfig([]string{"-config", path, "/data/arg"})
+	if err != nil {
+		t.Fatalf("failed to load config: %s", err.Error())
+	}
+	if cfg.dataPath != "/data/arg" || cfg.nodeID != "env" {
+		t.Fatalf("got data directory %q and node ID %q", cfg.dataPath, cfg.nodeID)
+	}
+
+	// the config file may be given by the environment too
+	os.Setenv(envName("config"), path)
+	defer os.Unsetenv(envName("config"))
+	os.Unsetenv(envName("node-id"))
+	if cfg, err = loadConfig(nil); err != nil {
+		t.Fatalf("failed to load config: %s", err.Error())
+	}
+	if cfg.nodeID != "file" {
+		t.Fatalf("got node ID %q, expected %q", cfg.nodeID, "file")
+	}
+}
+
+func Test_ConfigErrors(t *testing.T) {
+	dir, err := ioutil.TempDir("", "casbin-mesh-config-")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %s", err.Error())
+	}
+	defer os.RemoveAll(dir)
+	for name, content := range map[string]string{
+		"unknown.toml": "no-such-flag = 1\n",
+		"invalid.yaml": "join-attempts: many\n",
+		"config.json":  "{}",
+	} {
+		path := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("failed to write config file: %s", err.Error())
+		}
+		if _, err := loadConfig([]string{"-config", path}); err == nil || !strings.Contains(err.Error(), path) {
+			t.Fatalf("%s: expected an error naming the file, got %v", name, err)
+		}
+	}
+
+	os.Setenv(envName("join-attempts"), "many")
+	defer os.Unsetenv(envName("join-attempts"))
+	if _, err := loadConfig(nil); err == nil || !strings.Contains(err.Error(), envName("join-attempts")) {
+		t.Fatalf("expected an error naming the variable, got %v", err)
+	}
+}
@@ -0,0 +1,97 @@
+// Copyright 2022 The casbin-mesh Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+
+	"github.com/casbin/casbin-mesh/pkg/core"
+	"github.com/casbin/casbin-mesh/pkg/preset"
+)
+
+// devCommand starts a node in dev mode, see parseDevFlags.
+const devCommand = "dev"
+
+// devNamespace is the namespace created in dev mode, with the RBAC model.
+const devNamespace = "default"
+
+// loadDevConfig parses the flags of dev mode, whose defaults differ from
+// those of a regular node: auth is disabled and Raft logs everything. The
+// config file and the environment are ignored.
+func loadDevConfig(args []string) (cfg Config, err error) {
+	fs := flag.NewFlagSet(name+" "+devCommand, flag.ContinueOnError)
+	fs.SetOutput(ioutil.Discard)
+	defineFlags(fs, &cfg)
+	if err = fs.Parse(args); err != nil {
+		return
+	}
+	if fs.NArg() > 0 {
+		return cfg, fmt.Errorf("dev mode takes no data directory")
+	}
+	given := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { given[f.Name] = true })
+	if !given["raft-log-level"] {
+		cfg.raftLogLevel = "DEBUG"
+	}
+	cfg.enableAuth = false
+	cfg.joinAddr = ""
+	cfg.discoveryMode = ""
+	cfg.standbyOf = ""
+	cfg.dev = true
+	return
+}
+
+// parseDevFlags returns the configuration of `casmesh dev`: a single node
+// whose state lives in a temporary directory, removed on exit.
+func parseDevFlags(args []string) Config {
+	cfg, err := loadDevConfig(args)
+	if err == flag.ErrHelp {
+		fmt.Fprintf(os.Stderr, "Usage: %s %s [flags]\n\n", name, devCommand)
+		fmt.Fprintf(os.Stderr, "Starts a single node with auth disabled, verbose logging and a %q\n"+
+			"namespace with the RBAC model. State is discarded on exit. Flags are those\n"+
+			"of %s, run %s -h to list them.\n", devNamespace, name, name)
+		os.Exit(0)
+	}
+	if err != nil {
+		errorExit(1, err.Error())
+	}
+	if cfg.dataPath, err = ioutil.TempDir("", "casmesh-dev-"); err != nil {
+		errorExit(1, err.Error())
+	}
+	return cfg
+}
+
+// initDevNamespace creates the namespace of dev mode.
+func initDevNamespace(c core.Core, cfg *Config) error {
+	ctx := context.Background()
+	rbac, err := preset.Get("rbac")
+	if err != nil {
+		return err
+	}
+	if err := c.CreateNamespace(ctx, devNamespace); err != nil {
+		return err
+	}
+	if err := c.SetModelFromString(ctx, devNamespace, rbac.Text); err != nil {
+		return err
+	}
+	log.Printf("dev mode: namespace %q with the RBAC model is ready at http://%s, auth is disabled and state is discarded on exit",
+		devNamespace, cfg.raftAddr)
+	return nil
+}
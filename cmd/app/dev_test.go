@@ -0,0 +1,68 @@
+// Copyright 2022 The casbin-mesh Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/casbin/casbin-mesh/pkg/testkit"
+)
+
+func Test_DevConfig(t *testing.T) {
+	os.Setenv(envName("node-id"), "env")
+	defer os.Unsetenv(envName("node-id"))
+
+	cfg, err := loadDevConfig([]string{"-enable-basic", "-join", "a:4001"})
+	if err != nil {
+		t.Fatalf("failed to load dev config: %s", err.Error())
+	}
+	if !cfg.dev || cfg.enableAuth || cfg.joinAddr != "" {
+		t.Fatalf("expected a single node without auth, got dev %v, auth %v, join %q", cfg.dev, cfg.enableAuth, cfg.joinAddr)
+	}
+	if cfg.raftLogLevel != "DEBUG" {
+		t.Fatalf("got raft log level %q, expected DEBUG", cfg.raftLogLevel)
+	}
+	// the environment is ignored
+	if cfg.nodeID == "env" {
+		t.Fatalf("expected the node ID not read from the environment")
+	}
+
+	if cfg, err = loadDevConfig([]string{"-raft-log-level", "WARN"}); err != nil || cfg.raftLogLevel != "WARN" {
+		t.Fatalf("expected the given raft log level, got %q, %v", cfg.raftLogLevel, err)
+	}
+	if _, err := loadDevConfig([]string{"/data"}); err == nil {
+		t.Fatalf("expected a data directory refused")
+	}
+}
+
+func Test_DevNamespace(t *testing.T) {
+	node := testkit.NewCluster(t, 1).Leader()
+	if err := initDevNamespace(node.Core, &Config{raftAddr: "localhost:4002"}); err != nil {
+		t.Fatalf("failed to create the dev namespace: %s", err.Error())
+	}
+	ctx := context.TODO()
+	if _, err := node.Core.AddPolicies(ctx, devNamespace, "p", "p", [][]string{{"admin", "data1", "read"}}); err != nil {
+		t.Fatalf("failed to add policies: %s", err.Error())
+	}
+	if _, err := node.Core.AddPolicies(ctx, devNamespace, "g", "g", [][]string{{"alice", "admin"}}); err != nil {
+		t.Fatalf("failed to add roles: %s", err.Error())
+	}
+	ok, err := node.Core.Enforce(ctx, devNamespace, 0, 0, "alice", "data1", "read")
+	if err != nil || !ok {
+		t.Fatalf("expected the RBAC model to grant alice through admin, got %v, %v", ok, err)
+	}
+}
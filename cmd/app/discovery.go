@@ -0,0 +1,171 @@
+// Copyright 2022 The casbin-mesh Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/casbin/casbin-mesh/pkg/cluster"
+	"github.com/casbin/casbin-mesh/pkg/store"
+)
+
+const (
+	discoveryKubernetes = "kubernetes"
+
+	// discoveryInterval is how often new nodes retry their peers until
+	// one answers.
+	discoveryInterval    = 5 * time.Second
+	discoveryDialTimeout = 2 * time.Second
+)
+
+// applyDiscovery fills in the node ID, advertised address and join addresses
+// from the discovery mode, leaving explicitly configured values untouched.
+func applyDiscovery(cfg *Config) error {
+	switch cfg.discoveryMode {
+	case "":
+		return nil
+	case discoveryKubernetes:
+	default:
+		return fmt.Errorf("unsupported discovery mode %q", cfg.discoveryMode)
+	}
+
+	_, port, err := net.SplitHostPort(strings.Split(cfg.raftAddr, ",")[0])
+	if err != nil {
+		return err
+	}
+	d, err := cluster.NewKubernetesDiscovery(cfg.k8sService, cfg.k8sLabelSelector, cfg.k8sReplicas, port)
+	if err != nil {
+		return err
+	}
+	bootstrap, err := d.Bootstrap()
+	if err != nil {
+		return err
+	}
+	if cfg.nodeID == "" {
+		cfg.nodeID = d.PodName
+	}
+	if cfg.raftAdv == "" {
+		cfg.raftAdv = d.AdvertiseAddr()
+	}
+	if cfg.joinAddr != "" {
+		return nil
+	}
+	return discoverJoins(cfg, d, bootstrap, discoveryInterval)
+}
+
+// peerLister lists the addresses of the peers of a node.
+type peerLister interface {
+	Peers(ctx context.Context) ([]string, error)
+}
+
+// discoverJoins sets the join addresses of the node to the peers listed by
+// d. New nodes only join peers which answer, as the others aren't part of
+// the cluster yet. Apart from the node bootstrapping the cluster, they
+// never bootstrap, and wait for their peers to answer instead, so that
+// each doesn't start a cluster of its own. The bootstrapping node first
+// tries its peers too, which form the cluster already if it lost its state.
+func discoverJoins(cfg *Config, d peerLister, bootstrap bool, interval time.Duration) error {
+	isNew := store.IsNewNode(cfg.dataPath)
+	if bootstrap && !isNew {
+		return nil
+	}
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		peers, err := d.Peers(ctx)
+		cancel()
+		if err != nil {
+			return err
+		}
+		if isNew {
+			peers = answeringPeers(peers)
+		}
+		if len(peers) > 0 {
+			scheme := cfg.apiScheme()
+			joins := make([]string, len(peers))
+			for i, p := range peers {
+				joins[i] = scheme + "://" + p
+			}
+			cfg.joinAddr = strings.Join(joins, ",")
+			log.Printf("kubernetes discovery: node %s joins through %s", cfg.nodeID, cfg.joinAddr)
+			return nil
+		}
+		if bootstrap {
+			log.Printf("kubernetes discovery: no peer of node %s answers, it bootstraps the cluster", cfg.nodeID)
+			return nil
+		}
+		log.Printf("kubernetes discovery: no peer of node %s answers yet, retrying in %s", cfg.nodeID, interval)
+		time.Sleep(interval)
+	}
+}
+
+// answeringPeers returns the peers accepting connections.
+func answeringPeers(peers []string) []string {
+	var answering []string
+	for _, p := range peers {
+		conn, err := net.DialTimeout("tcp", p, discoveryDialTimeout)
+		if err != nil {
+			continue
+		}
+		conn.Close()
+		answering = append(answering, p)
+	}
+	return answering
+}
+
+// applyAdvertise detects, through d, the advertised address of a node bound
+// to an unspecified host, and fails fast if the other nodes couldn't reach
+// the node through the address it advertises.
+func applyAdvertise(cfg *Config, d *cluster.AdvertiseDetector) error {
+	bind := strings.Split(cfg.raftAddr, ",")[0]
+	if cfg.raftAdv == "" {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		adv, err := d.Detect(ctx, bind)
+		if err != nil {
+			return fmt.Errorf("failed to detect the address to advertise for %s, set -raft-advertise-address: %s", bind, err)
+		}
+		if adv != bind {
+			log.Printf("bound to %s, advertising %s", bind, adv)
+			cfg.raftAdv = adv
+			// Nodes without an ID are named after the address they
+			// advertise, as every node shares the bind address, but
+			// existing nodes keep the one they had before detection.
+			if cfg.nodeID == "" {
+				if store.IsNewNode(cfg.dataPath) {
+					cfg.nodeID = adv
+				} else {
+					cfg.nodeID = cfg.raftAddr
+				}
+			}
+		}
+	}
+	adv := cfg.raftAdv
+	if adv == "" {
+		adv = bind
+	}
+	var joins []string
+	if cfg.joinAddr != "" {
+		joins = strings.Split(cfg.joinAddr, ",")
+	}
+	if err := cluster.CheckAdvertiseAddr(adv, joins); err != nil {
+		return fmt.Errorf("%s, set -raft-advertise-address to an address other nodes can reach", err)
+	}
+	return nil
+}
@@ -0,0 +1,125 @@
+// Copyright 2022 The casbin-mesh Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/casbin/casbin-mesh/pkg/cluster"
+)
+
+func Test_AdvertiseNodeID(t *testing.T) {
+	dir, err := ioutil.TempDir("", "casbin-mesh-advertise-")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %s", err.Error())
+	}
+	defer os.RemoveAll(dir)
+
+	// Two new containers bound to the same wildcard address are named
+	// after the addresses they advertise.
+	ids := make(map[string]bool)
+	for _, host := range []string{"10.0.0.1", "10.0.0.2"} {
+		cfg := &Config{raftAddr: "0.0.0.0:4002", dataPath: filepath.Join(dir, host)}
+		if err := applyAdvertise(cfg, &cluster.AdvertiseDetector{Host: host}); err != nil {
+			t.Fatalf("failed to apply advertise address: %s", err.Error())
+		}
+		if want := host + ":4002"; cfg.raftAdv != want || cfg.nodeID != want {
+			t.Fatalf("expected node %s advertising %s, got node %s advertising %s", want, want, cfg.nodeID, cfg.raftAdv)
+		}
+		ids[cfg.nodeID] = true
+	}
+	if len(ids) != 2 {
+		t.Fatalf("expected distinct node IDs, got %v", ids)
+	}
+
+	// Nodes with Raft state keep the ID they had before detection.
+	existing := filepath.Join(dir, "existing")
+	if err := os.MkdirAll(existing, 0755); err != nil {
+		t.Fatalf("failed to create data dir: %s", err.Error())
+	}
+	if err := ioutil.WriteFile(filepath.Join(existing, "default-raft.db"), nil, 0644); err != nil {
+		t.Fatalf("failed to create Raft state: %s", err.Error())
+	}
+	cfg := &Config{raftAddr: "0.0.0.0:4002", dataPath: existing}
+	if err := applyAdvertise(cfg, &cluster.AdvertiseDetector{Host: "10.0.0.3"}); err != nil {
+		t.Fatalf("failed to apply advertise address: %s", err.Error())
+	}
+	if cfg.nodeID != "0.0.0.0:4002" || cfg.raftAdv != "10.0.0.3:4002" {
+		t.Fatalf("expected node 0.0.0.0:4002 advertising 10.0.0.3:4002, got node %s advertising %s", cfg.nodeID, cfg.raftAdv)
+	}
+}
+
+// peerList lists the peers of its calls in turn, the last one afterwards.
+type peerList [][]string
+
+func (l *peerList) Peers(ctx context.Context) ([]string, error) {
+	peers := (*l)[0]
+	if len(*l) > 1 {
+		*l = (*l)[1:]
+	}
+	return peers, nil
+}
+
+func Test_DiscoverJoins(t *testing.T) {
+	dir, err := ioutil.TempDir("", "casbin-mesh-discovery-")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %s", err.Error())
+	}
+	defer os.RemoveAll(dir)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %s", err.Error())
+	}
+	defer ln.Close()
+	dead, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %s", err.Error())
+	}
+	dead.Close()
+	live, gone := ln.Addr().String(), dead.Addr().String()
+
+	// New nodes other than the first wait for their peers to answer.
+	cfg := &Config{nodeID: "casmesh-1", dataPath: filepath.Join(dir, "casmesh-1")}
+	peers := &peerList{nil, {gone}, {gone, live}}
+	if err := discoverJoins(cfg, peers, false, time.Millisecond); err != nil {
+		t.Fatalf("failed to discover joins: %s", err.Error())
+	}
+	if cfg.joinAddr != "http://"+live {
+		t.Fatalf("expected to join through %s, got %s", live, cfg.joinAddr)
+	}
+
+	// The first node joins its peers if they answer, and only bootstraps
+	// otherwise.
+	cfg = &Config{nodeID: "casmesh-0", dataPath: filepath.Join(dir, "casmesh-0")}
+	if err := discoverJoins(cfg, &peerList{{gone, live}}, true, time.Millisecond); err != nil {
+		t.Fatalf("failed to discover joins: %s", err.Error())
+	}
+	if cfg.joinAddr != "http://"+live {
+		t.Fatalf("expected to join through %s, got %s", live, cfg.joinAddr)
+	}
+	cfg = &Config{nodeID: "casmesh-0", dataPath: filepath.Join(dir, "casmesh-0")}
+	if err := discoverJoins(cfg, &peerList{{gone}}, true, time.Millisecond); err != nil {
+		t.Fatalf("failed to discover joins: %s", err.Error())
+	}
+	if cfg.joinAddr != "" {
+		t.Fatalf("expected to bootstrap, got join addresses %s", cfg.joinAddr)
+	}
+}
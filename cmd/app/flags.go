@@ -17,8 +17,16 @@ package main
 import (
 	"flag"
 	"fmt"
+	"io/ioutil"
 	"os"
 	"runtime"
+
+	"github.com/casbin/casbin-mesh/pkg/accesslog"
+	"github.com/casbin/casbin-mesh/pkg/auth"
+	"github.com/casbin/casbin-mesh/pkg/extauthz"
+	"github.com/casbin/casbin-mesh/pkg/logsink"
+	"github.com/casbin/casbin-mesh/pkg/store"
+	"github.com/casbin/casbin-mesh/pkg/transport/tcp"
 )
 
 type Config struct {
@@ -31,70 +39,282 @@ type Config struct {
 	x509CACert             string
 	x509Cert               string
 	x509Key                string
+	apiCert                string
+	apiKey                 string
+	apiACMEDomains         string
+	apiACMEEmail           string
+	apiACMEDirectory       string
+	apiACMEHTTPAddr        string
+	apiClientCA            string
+	apiRequireClientCert   bool
+	apiPrincipalMapping    string
+	apiPrincipalScopes     string
 	nodeID                 string
+	nodeMetadata           string
 	joinAddr               string
 	joinAttempts           int
 	joinInterval           string
 	noVerify               bool
 	pprofEnabled           bool
 	raftLogLevel           string
+	logOutput              string
+	logSyslogAddr          string
+	logTag                 string
+	errorReportDSN         string
+	errorReportEnvironment string
+	applyStallTimeout      string
+	applyStallAction       string
+	clockProbeInterval     string
+	maxClockSkew           string
 	raftNonVoter           bool
 	raftSnapThreshold      uint64
 	raftSnapInterval       string
+	raftSnapBandwidth      int64
+	raftFaults             string
+	raftMaxMessageSize     int
+	allowedPeers           string
+	allowLoopbackPeers     bool
+	raftAutoPromote        bool
+	joinToken              string
+	joinWithToken          string
+	requireJoinToken       bool
+	raftPromoteMaxLag      uint64
+	raftMinQuorum          int
+	raftStabilization      string
 	raftLeaderLeaseTimeout string
 	raftHeartbeatTimeout   string
 	raftElectionTimeout    string
 	raftApplyTimeout       string
+	requestTimeout         string
+	forwardTimeout         string
+	maxEnforceRequests     int
+	maxWriteRequests       int
+	maxRequests            int
+	priorityClasses        string
+	requestQueueSize       int
+	requestQueueWait       string
+	shedApplyLatency       string
+	shedFSMWait            string
 	raftOpenTimeout        string
 	raftWaitForLeader      bool
 	raftShutdownOnRemove   bool
+	repairOnCorruption     bool
+	diskMinFree            uint64
+	autoMigrate            bool
+	shutdownTimeout        string
+	stepDownCooldown       string
+	discoveryMode          string
+	k8sService             string
+	k8sLabelSelector       string
+	k8sReplicas            int
+	extAuthz               bool
+	forwardAuth            bool
+	opaShim                bool
+	opaInputMapping        string
+	webhookConfig          string
+	ldapSyncConfig         string
+	standbyOf              string
+	standbyUsername        string
+	standbyPassword        string
+	standbyWait            string
+	standbyNamespaces      string
+	expiryInterval         string
+	maxRoleDepth           int
+	decisionTTL            string
+	scimNamespace          string
+	ui                     bool
+	enforceWSOrigins       string
+	accessLog              bool
+	accessLogFormat        string
+	accessLogOutput        string
+	accessLogSample        float64
+	scimToken              string
+	scimGroupPrefix        string
+	scimUsersRole          string
+	scimGroupsRole         string
+	eventSink              string
+	eventSinkAddr          string
+	eventChangeTopic       string
+	eventDecisionTopic     string
+	eventDecisionSample    float64
+	extAuthzNamespace      string
+	extAuthzMapping        string
 	compressionSize        int
 	compressionBatch       int
 	showVersion            bool
 	cpuProfile             string
 	memProfile             string
 	encrypt                bool
+	tlsAuto                bool
 	dataPath               string
+	configPath             string
+	printConfig            bool
+	// dev is set by `casmesh dev`, see parseDevFlags.
+	dev bool
+}
+
+// apiScheme returns the scheme of the API of the node.
+func (cfg *Config) apiScheme() string {
+	if cfg.x509Cert != "" || cfg.apiCert != "" || cfg.tlsAuto || cfg.apiACMEDomains != "" {
+		return "https"
+	}
+	return "http"
+}
+
+// presentedJoinToken returns the token the node presents when joining.
+func (cfg *Config) presentedJoinToken() string {
+	if cfg.joinWithToken != "" {
+		return cfg.joinWithToken
+	}
+	return cfg.joinToken
+}
+
+// defineFlags defines the flags of the server on fs, storing them into cfg.
+func defineFlags(fs *flag.FlagSet, cfg *Config) {
+	fs.StringVar(&cfg.configPath, "config", "", "Path to a YAML or TOML config file, keyed by flag name")
+	fs.BoolVar(&cfg.printConfig, "print-config", false, "Print the effective configuration and exit")
+	fs.BoolVar(&cfg.enableAuth, "enable-basic", false, "Enable Basic Auth")
+	fs.StringVar(&cfg.rootUsername, "root-username", "root", "Root Account Username")
+	fs.StringVar(&cfg.rootPassword, "root-password", "root", "Root Account Password")
+	fs.StringVar(&cfg.nodeID, "node-id", "", "Unique name for node. If not set, set to hostname")
+	fs.StringVar(&cfg.nodeMetadata, "node-metadata", "", "Comma-separated key=value pairs the node registers in the cluster membership, e.g. zone=us-east-1a,rack=r1")
+	fs.StringVar(&cfg.raftAddr, "raft-address", "localhost:4002", "Raft communication bind address, supports multiple addresses by commas")
+	fs.StringVar(&cfg.raftAdv, "raft-advertise-address", "", "Advertised Raft communication address. If not set, same as Raft bind")
+	fs.StringVar(&cfg.joinSrcIP, "join-source-ip", "", "Set source IP address during Join request")
+	fs.BoolVar(&cfg.encrypt, "tls-encrypt", false, "Enable encryption")
+	fs.BoolVar(&cfg.tlsAuto, "tls-auto", false, "Enable encryption with a CA and node certificate generated in the data directory on first boot")
+	fs.StringVar(&cfg.x509CACert, "endpoint-ca-cert", "", "Path to root X.509 certificate for API endpoint")
+	fs.StringVar(&cfg.x509Cert, "endpoint-cert", "", "Path to X.509 certificate for API endpoint")
+	fs.StringVar(&cfg.x509Key, "endpoint-key", "", "Path to X.509 private key for API endpoint")
+	fs.StringVar(&cfg.apiCert, "api-cert", "", "Path to X.509 certificate for the client API. If not set, the API uses the endpoint certificate when encryption is enabled")
+	fs.StringVar(&cfg.apiKey, "api-key", "", "Path to X.509 private key for the client API")
+	fs.StringVar(&cfg.apiACMEDomains, "api-acme-domains", "", "Comma-separated domains of the client API whose certificates are obtained and renewed from an ACME CA, cached in the data directory")
+	fs.StringVar(&cfg.apiACMEEmail, "api-acme-email", "", "Contact email registered with the ACME CA")
+	fs.StringVar(&cfg.apiACMEDirectory, "api-acme-directory", "", "Directory URL of the ACME CA. If not set, Let's Encrypt")
+	fs.StringVar(&cfg.apiACMEHTTPAddr, "api-acme-http-address", "", "Bind address answering ACME HTTP-01 challenges, e.g. :80. If not set, only TLS-ALPN-01 challenges are answered on the API")
+	fs.StringVar(&cfg.apiClientCA, "api-client-ca", "", "Path to X.509 CA certificate verifying the client certificates presented to the API")
+	fs.BoolVar(&cfg.apiRequireClientCert, "api-require-client-cert", false, "Refuse API clients not presenting a certificate verified by the API client CA")
+	fs.StringVar(&cfg.apiPrincipalMapping, "api-principal-mapping", auth.DefaultPrincipalMapping, "Comma-separated client certificate fields, among uri, dns, email and cn, the first set of which is the principal of the client")
+	fs.StringVar(&cfg.apiPrincipalScopes, "api-principal-scopes", "", "Comma-separated principal=namespace pairs restricting principals to namespaces, * for all of them. Unrestricted if empty")
+	fs.BoolVar(&cfg.noVerify, "endpoint-no-verify", false, "Skip verification of remote HTTPS cert when joining cluster")
+	fs.StringVar(&cfg.joinAddr, "join", "", "Comma-delimited list of nodes, through which a cluster can be joined (proto://host:port)")
+	fs.IntVar(&cfg.joinAttempts, "join-attempts", 5, "Number of join attempts to make")
+	fs.StringVar(&cfg.discoveryMode, "discovery-mode", "", "Discover peers automatically, only kubernetes is supported")
+	fs.StringVar(&cfg.k8sService, "k8s-service", "", "Headless Service of the StatefulSet, peers are addressed by their DNS names if set")
+	fs.StringVar(&cfg.k8sLabelSelector, "k8s-label-selector", "", "Label selector listing the pods of the StatefulSet through the Kubernetes API")
+	fs.IntVar(&cfg.k8sReplicas, "k8s-replicas", 0, "Number of replicas of the StatefulSet, used to derive peers if no label selector is set")
+	fs.BoolVar(&cfg.extAuthz, "ext-authz", false, "Serve the Envoy external authorization (v3) service on the gRPC port")
+	fs.BoolVar(&cfg.forwardAuth, "forward-auth", false, "Serve the /forward-auth endpoint for the forward-auth subrequests of gateways like Traefik or nginx")
+	fs.BoolVar(&cfg.opaShim, "opa-shim", false, "Serve OPA-compatible data API queries under /v1/data/<namespace>")
+	fs.StringVar(&cfg.opaInputMapping, "opa-input-mapping", extauthz.DefaultOPAMapping, "Comma-separated dotted paths of the OPA input fields forming the request tuple")
+	fs.StringVar(&cfg.extAuthzNamespace, "ext-authz-namespace", "", "Namespace external authorization and forward-auth requests are enforced in, unless the route sets the namespace context extension or query parameter")
+	fs.StringVar(&cfg.extAuthzMapping, "ext-authz-mapping", extauthz.DefaultMapping, "Comma-separated HTTP attributes forming the request tuple: method, path, query, host, principal, header:<name>, context:<key> or literal:<value>")
+	fs.StringVar(&cfg.webhookConfig, "webhook-config", "", "Path to a YAML file of webhooks notified of policy and model changes")
+	fs.StringVar(&cfg.ldapSyncConfig, "ldap-sync-config", "", "Path to a YAML file configuring the sync of LDAP groups into grouping policies")
+	fs.StringVar(&cfg.standbyOf, "standby-of", "", "Comma-separated API addresses of nodes of a primary cluster this cluster replicates as a read-only standby")
+	fs.StringVar(&cfg.standbyUsername, "standby-username", "", "Username authenticating to the primary cluster")
+	fs.StringVar(&cfg.standbyPassword, "standby-password", "", "Password authenticating to the primary cluster")
+	fs.StringVar(&cfg.standbyWait, "standby-wait", "10s", "How long a request for the changes of the primary cluster waits for new ones")
+	fs.StringVar(&cfg.standbyNamespaces, "standby-namespaces", "", "Comma-separated namespaces replicated from the primary cluster, which may be patterns like edge-*, all of them if empty")
+	fs.StringVar(&cfg.expiryInterval, "policy-expiry-interval", "1s", "Period between removals of expired rules by the leader")
+	fs.StringVar(&cfg.decisionTTL, "decision-ttl", store.DefaultDecisionTTL.String(), "How long clients are suggested to cache the decisions of /enforce, shortened by the next rule to expire or be scheduled")
+	fs.IntVar(&cfg.maxRoleDepth, "max-role-depth", store.DefaultMaxRoleDepth, "Number of links role hierarchies may not exceed, grouping rules going deeper or making a cycle are refused")
+	fs.BoolVar(&cfg.ui, "ui", false, "Serve the admin dashboard under /ui/, to principals with access to every namespace")
+	fs.StringVar(&cfg.enforceWSOrigins, "enforce-ws-origins", "", "Comma-separated origins of the browser pages allowed to open the WebSocket enforce channel besides the API one, * for any")
+	fs.BoolVar(&cfg.accessLog, "access-log", false, "Record the requests served by the HTTP API in an access log, apart from the application logs")
+	fs.StringVar(&cfg.accessLogFormat, "access-log-format", accesslog.Common, "Format of the access log, common or json")
+	fs.StringVar(&cfg.accessLogOutput, "access-log-output", "stdout", "Where the access log is written: stdout, stderr or the path of a file appended to")
+	fs.Float64Var(&cfg.accessLogSample, "access-log-sample", 1, "Fraction of requests recorded in the access log, between 0 and 1")
+	fs.StringVar(&cfg.scimNamespace, "scim-namespace", "", "Serve a SCIM 2.0 service under /scim/v2, provisioning users and groups as grouping policies of this namespace")
+	fs.StringVar(&cfg.scimToken, "scim-token", "", "Bearer token SCIM clients must present")
+	fs.StringVar(&cfg.scimGroupPrefix, "scim-group-prefix", "scim:", "Prefix of the roles of SCIM groups")
+	fs.StringVar(&cfg.scimUsersRole, "scim-users-role", "scim-users", "Role of the users provisioned through SCIM")
+	fs.StringVar(&cfg.scimGroupsRole, "scim-groups-role", "scim-groups", "Role recording the groups provisioned through SCIM")
+	fs.StringVar(&cfg.eventSink, "event-sink", "", "Publish change and decision events to a streaming system, kafka or nats")
+	fs.StringVar(&cfg.eventSinkAddr, "event-sink-address", "", "Comma-separated Kafka brokers or NATS server URLs")
+	fs.StringVar(&cfg.eventChangeTopic, "event-change-topic", "casbin-mesh.changes", "Topic of change events, disabled if empty")
+	fs.StringVar(&cfg.eventDecisionTopic, "event-decision-topic", "", "Topic of decision events, disabled if empty")
+	fs.Float64Var(&cfg.eventDecisionSample, "event-decision-sample", 1, "Fraction of decisions published, between 0 and 1")
+	fs.StringVar(&cfg.joinInterval, "join-interval", "5s", "Period between join attempts")
+	fs.BoolVar(&cfg.pprofEnabled, "pprof", true, "Serve pprof data on API server")
+	fs.BoolVar(&cfg.showVersion, "version", false, "Show version information and exit")
+	fs.BoolVar(&cfg.raftNonVoter, "raft-non-voter", false, "Configure as non-voting node")
+	fs.StringVar(&cfg.joinToken, "join-token", "", "Token shared by the nodes, presented when joining and required from joining nodes")
+	fs.StringVar(&cfg.joinWithToken, "join-with-token", "", "Single-use token presented when joining instead of the shared join token")
+	fs.BoolVar(&cfg.requireJoinToken, "require-join-token", false, "Require joining nodes to present the shared join token or a single-use one created through /cluster/join-token")
+	fs.BoolVar(&cfg.raftAutoPromote, "raft-auto-promote", false, "Join voters as non-voters, and promote them once caught up with the leader")
+	fs.Uint64Var(&cfg.raftPromoteMaxLag, "raft-promote-max-lag", 100, "Number of log entries a non-voter may lag behind the leader to be promoted")
+	fs.IntVar(&cfg.raftMinQuorum, "raft-min-quorum", 0, "Number of voters removals may not go below. Use 0 for no minimum")
+	fs.StringVar(&cfg.raftStabilization, "raft-membership-stabilization", "0s", "Minimum time between membership changes. Use 0s for none")
+	fs.StringVar(&cfg.raftHeartbeatTimeout, "raft-timeout", "1s", "Raft heartbeat timeout")
+	fs.StringVar(&cfg.raftElectionTimeout, "raft-election-timeout", "1s", "Raft election timeout")
+	fs.StringVar(&cfg.raftApplyTimeout, "raft-apply-timeout", "10s", "Raft apply timeout, optionally followed by scoped timeouts like -request-timeout")
+	fs.StringVar(&cfg.requestTimeout, "request-timeout", "0s", "Deadline of API requests, 0s for none, optionally followed by timeouts scoped by namespace, endpoint or both, e.g. 5s,/enforce=200ms,tenant-a=30s,tenant-a/enforce=1s")
+	fs.IntVar(&cfg.maxEnforceRequests, "max-enforce-requests", 0, "Number of Enforce requests served at once, the others wait in a queue. Use 0 for no limit")
+	fs.IntVar(&cfg.maxWriteRequests, "max-write-requests", 0, "Number of write requests served at once, the others wait in a queue. Use 0 for no limit")
+	fs.IntVar(&cfg.maxRequests, "max-requests", 0, "Number of Enforce, write, list and export requests served at once, the others wait in a queue by priority class. Use 0 for no limit")
+	fs.StringVar(&cfg.priorityClasses, "priority-classes", "", "Priority classes, low, normal or high, of requests by endpoint, principal or both, e.g. /enforce=high,importer=low,auditor/list/policies=low")
+	fs.IntVar(&cfg.requestQueueSize, "request-queue-size", 1000, "Number of limited requests waiting for a slot, beyond which they are rejected with 429")
+	fs.StringVar(&cfg.requestQueueWait, "request-queue-wait", "1s", "Time limited requests wait for a slot before being rejected with 503. Use 0s to wait until their deadline")
+	fs.StringVar(&cfg.shedApplyLatency, "shed-apply-latency", "0s", "Raft apply latency above which list and export requests are shed. Use 0s to never shed on it")
+	fs.StringVar(&cfg.shedFSMWait, "shed-fsm-wait", "0s", "Time log entries wait for the FSM above which list and export requests are shed. Use 0s to never shed on it")
+	fs.StringVar(&cfg.forwardTimeout, "forward-timeout", "0s", "Timeout of the requests forwarded to the leader, 0s for none, optionally followed by scoped timeouts like -request-timeout")
+	fs.StringVar(&cfg.raftOpenTimeout, "raft-open-timeout", "120s", "Time for initial Raft logs to be applied. Use 0s duration to skip wait")
+	fs.BoolVar(&cfg.raftWaitForLeader, "raft-leader-wait", true, "Node waits for a leader before answering requests")
+	fs.Uint64Var(&cfg.raftSnapThreshold, "raft-snap", 8192, "Number of outstanding log entries that trigger snapshot")
+	fs.StringVar(&cfg.raftSnapInterval, "raft-snap-int", "30s", "Snapshot threshold check interval")
+	fs.Int64Var(&cfg.raftSnapBandwidth, "raft-snap-bandwidth", 0, "Maximum bytes per second of the snapshots streamed to followers. Use 0 for no limit")
+	fs.StringVar(&cfg.allowedPeers, "allowed-peers", "", "Comma-separated CIDRs or IP addresses of the hosts allowed to connect to the Raft and API port, all if empty")
+	fs.BoolVar(&cfg.allowLoopbackPeers, "allow-loopback-peers", false, "Allow loopback connections to the Raft and API port besides -allowed-peers, like those of tools running on the host")
+	fs.IntVar(&cfg.raftMaxMessageSize, "raft-max-message-size", tcp.DefaultMaxMessageSize, "Size in bytes above which the frames sent by peers on Raft connections are refused")
+	fs.StringVar(&cfg.raftFaults, "raft-faults", "", "Network faults injected into Raft connections for testing, e.g. drop=0.05,latency=20ms,partition=node2:4002|node3:4002. Never set in production")
+	fs.StringVar(&cfg.raftLeaderLeaseTimeout, "raft-leader-lease-timeout", "0s", "Raft leader lease timeout. Use 0s for Raft default")
+	fs.Uint64Var(&cfg.diskMinFree, "disk-min-free", 0, "Bytes of free space on the data directory under which the node refuses writes. Use 0 for no minimum")
+	fs.BoolVar(&cfg.autoMigrate, "auto-migrate", true, "Upgrade the data directory on startup if it was written by an older version. If disabled, run "+name+" "+migrateCommand+" first")
+	fs.BoolVar(&cfg.repairOnCorruption, "repair-on-corruption", false, "Quarantine the node state if it is found corrupted on startup, and get it again from the leader, instead of failing to start")
+	fs.BoolVar(&cfg.raftShutdownOnRemove, "raft-remove-shutdown", false, "Shutdown Raft if node removed")
+	fs.StringVar(&cfg.stepDownCooldown, "leader-step-down-cooldown", "1m", "Time a leader holds leadership for before it can be asked to step down")
+	fs.StringVar(&cfg.shutdownTimeout, "shutdown-timeout", "30s", "Time to drain requests, transfer leadership and close the store on shutdown")
+	fs.StringVar(&cfg.raftLogLevel, "raft-log-level", "INFO", "Minimum log level for Raft module")
+	fs.StringVar(&cfg.logOutput, "log-output", logsink.Stderr, "Where the logs are sent: stderr, syslog or journald")
+	fs.StringVar(&cfg.logSyslogAddr, "log-syslog-address", "udp://localhost:514", "Syslog server the logs are sent to, as udp://, tcp:// or tls://host:port")
+	fs.StringVar(&cfg.logTag, "log-tag", name, "Application name identifying the node in syslog and journald")
+	fs.StringVar(&cfg.applyStallTimeout, "apply-stall-timeout", store.DefaultApplyStallTimeout.String(), "How long the FSM may make no progress while entries are committed before it is deemed stalled, 0s not to watch it")
+	fs.StringVar(&cfg.applyStallAction, "apply-stall-action", "report", "What a node does when its FSM stalls: report, or exit for its supervisor to restart it")
+	fs.StringVar(&cfg.clockProbeInterval, "clock-probe-interval", "30s", "Period between comparisons of the clock of the node with the clocks of the others, 0s for none but over forwarded requests")
+	fs.StringVar(&cfg.maxClockSkew, "max-clock-skew", store.DefaultMaxClockSkew.String(), "Clock offset between nodes over which their clocks are deemed skewed")
+	fs.StringVar(&cfg.errorReportDSN, "error-report-dsn", "", "Sentry DSN panics and critical errors are reported to, like https://<key>@sentry.example.com/<project>")
+	fs.StringVar(&cfg.errorReportEnvironment, "error-report-environment", "", "Environment the errors are reported in, e.g. production")
+	fs.IntVar(&cfg.compressionSize, "compression-size", 150, "Request query size for compression attempt")
+	fs.IntVar(&cfg.compressionBatch, "compression-batch", 5, "Request batch threshold for compression attempt")
+	fs.StringVar(&cfg.cpuProfile, "cpu-profile", "", "Path to file for CPU profiling information")
+	fs.StringVar(&cfg.memProfile, "mem-profile", "", "Path to file for memory profiling information")
+}
+
+// loadConfig parses args and applies the config file and the environment,
+// the way the configuration is loaded at startup.
+func loadConfig(args []string) (cfg Config, err error) {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(ioutil.Discard)
+	defineFlags(fs, &cfg)
+	if err = fs.Parse(args); err != nil {
+		return
+	}
+	cfg.dataPath = fs.Arg(0)
+	err = applyConfig(fs, cfg.configPath, &cfg.dataPath)
+	return
 }
 
 func parseFlags() (cfg Config) {
-	flag.BoolVar(&cfg.enableAuth, "enable-basic", false, "Enable Basic Auth")
-	flag.StringVar(&cfg.rootUsername, "root-username", "root", "Root Account Username")
-	flag.StringVar(&cfg.rootPassword, "root-password", "root", "Root Account Password")
-	flag.StringVar(&cfg.nodeID, "node-id", "", "Unique name for node. If not set, set to hostname")
-	flag.StringVar(&cfg.raftAddr, "raft-address", "localhost:4002", "Raft communication bind address, supports multiple addresses by commas")
-	flag.StringVar(&cfg.raftAdv, "raft-advertise-address", "", "Advertised Raft communication address. If not set, same as Raft bind")
-	flag.StringVar(&cfg.joinSrcIP, "join-source-ip", "", "Set source IP address during Join request")
-	flag.BoolVar(&cfg.encrypt, "tls-encrypt", false, "Enable encryption")
-	flag.StringVar(&cfg.x509CACert, "endpoint-ca-cert", "", "Path to root X.509 certificate for API endpoint")
-	flag.StringVar(&cfg.x509Cert, "endpoint-cert", "", "Path to X.509 certificate for API endpoint")
-	flag.StringVar(&cfg.x509Key, "endpoint-key", "", "Path to X.509 private key for API endpoint")
-	flag.BoolVar(&cfg.noVerify, "endpoint-no-verify", false, "Skip verification of remote HTTPS cert when joining cluster")
-	flag.StringVar(&cfg.joinAddr, "join", "", "Comma-delimited list of nodes, through which a cluster can be joined (proto://host:port)")
-	flag.IntVar(&cfg.joinAttempts, "join-attempts", 5, "Number of join attempts to make")
-	flag.StringVar(&cfg.joinInterval, "join-interval", "5s", "Period between join attempts")
-	flag.BoolVar(&cfg.pprofEnabled, "pprof", true, "Serve pprof data on API server")
-	flag.BoolVar(&cfg.showVersion, "version", false, "Show version information and exit")
-	flag.BoolVar(&cfg.raftNonVoter, "raft-non-voter", false, "Configure as non-voting node")
-	flag.StringVar(&cfg.raftHeartbeatTimeout, "raft-timeout", "1s", "Raft heartbeat timeout")
-	flag.StringVar(&cfg.raftElectionTimeout, "raft-election-timeout", "1s", "Raft election timeout")
-	flag.StringVar(&cfg.raftApplyTimeout, "raft-apply-timeout", "10s", "Raft apply timeout")
-	flag.StringVar(&cfg.raftOpenTimeout, "raft-open-timeout", "120s", "Time for initial Raft logs to be applied. Use 0s duration to skip wait")
-	flag.BoolVar(&cfg.raftWaitForLeader, "raft-leader-wait", true, "Node waits for a leader before answering requests")
-	flag.Uint64Var(&cfg.raftSnapThreshold, "raft-snap", 8192, "Number of outstanding log entries that trigger snapshot")
-	flag.StringVar(&cfg.raftSnapInterval, "raft-snap-int", "30s", "Snapshot threshold check interval")
-	flag.StringVar(&cfg.raftLeaderLeaseTimeout, "raft-leader-lease-timeout", "0s", "Raft leader lease timeout. Use 0s for Raft default")
-	flag.BoolVar(&cfg.raftShutdownOnRemove, "raft-remove-shutdown", false, "Shutdown Raft if node removed")
-	flag.StringVar(&cfg.raftLogLevel, "raft-log-level", "INFO", "Minimum log level for Raft module")
-	flag.IntVar(&cfg.compressionSize, "compression-size", 150, "Request query size for compression attempt")
-	flag.IntVar(&cfg.compressionBatch, "compression-batch", 5, "Request batch threshold for compression attempt")
-	flag.StringVar(&cfg.cpuProfile, "cpu-profile", "", "Path to file for CPU profiling information")
-	flag.StringVar(&cfg.memProfile, "mem-profile", "", "Path to file for memory profiling information")
+	defineFlags(flag.CommandLine, &cfg)
 
 	flag.Usage = func() {
 		fmt.Fprintf(os.Stderr, "%s\n\n", desc)
 		fmt.Fprintf(os.Stderr, "Usage: %s [flags] <data directory>\n", name)
 		flag.PrintDefaults()
+		fmt.Fprintf(os.Stderr, "\nEvery flag can also be set in the config file, or through the environment\n"+
+			"variable %sFLAG_NAME, e.g. %s. Flags take precedence over the\n"+
+			"environment, which takes precedence over the config file.\n", envPrefix, envName("raft-address"))
 	}
 	flag.Parse()
 	if cfg.showVersion {
@@ -103,12 +323,6 @@ func parseFlags() (cfg Config) {
 		errorExit(0, msg)
 	}
 
-	// Ensure the data path is set.
-	if flag.NArg() < 1 {
-		fmt.Fprintf(os.Stderr, "fatal: no data directory set\n")
-		os.Exit(1)
-	}
-
 	// Ensure no args come after the data directory.
 	if flag.NArg() > 1 {
 		fmt.Fprintf(os.Stderr, "fatal: arguments after data directory are not accepted\n")
@@ -116,6 +330,20 @@ func parseFlags() (cfg Config) {
 	}
 	cfg.dataPath = flag.Arg(0)
 
+	if err := applyConfig(flag.CommandLine, cfg.configPath, &cfg.dataPath); err != nil {
+		errorExit(1, err.Error())
+	}
+	if cfg.printConfig {
+		printConfig(flag.CommandLine, cfg.dataPath)
+		os.Exit(0)
+	}
+
+	// Ensure the data path is set.
+	if cfg.dataPath == "" {
+		fmt.Fprintf(os.Stderr, "fatal: no data directory set\n")
+		os.Exit(1)
+	}
+
 	return
 }
 
@@ -20,19 +20,42 @@ package main
 import (
 	"os"
 	"os/signal"
+	"syscall"
 )
 
 const name = `casmesh`
 const desc = `casmesh is a lightweight, distributed casbin service, which uses casbin as its engine.`
 
 func main() {
-	cfg := parseFlags()
+	if len(os.Args) > 1 && os.Args[1] == migrateCommand {
+		runMigrate(os.Args[2:])
+		return
+	}
 
-	closer := New(&cfg)
+	var cfg Config
+	if len(os.Args) > 1 && os.Args[1] == devCommand {
+		cfg = parseDevFlags(os.Args[2:])
+	} else {
+		cfg = parseFlags()
+	}
+
+	closer, reload := New(&cfg)
+
+	// Reload the configuration on SIGHUP.
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			_ = reload()
+		}
+	}()
 
 	// Block until signalled.
 	terminate := make(chan os.Signal, 1)
-	signal.Notify(terminate, os.Interrupt)
+	signal.Notify(terminate, os.Interrupt, syscall.SIGTERM)
 	<-terminate
 	closer()
+	if cfg.dev {
+		os.RemoveAll(cfg.dataPath)
+	}
 }
@@ -0,0 +1,73 @@
+// Copyright 2022 The casbin-mesh Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/casbin/casbin-mesh/pkg/store"
+)
+
+// migrateCommand upgrades the data directory of a stopped node, see
+// runMigrate.
+const migrateCommand = "migrate"
+
+// runMigrate upgrades the data directory given in args to the format of this
+// version. With -dry-run, the migrations are listed but not applied.
+func runMigrate(args []string) {
+	fs := flag.NewFlagSet(name+" "+migrateCommand, flag.ExitOnError)
+	dryRun := fs.Bool("dry-run", false, "List the migrations without applying them")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s %s [flags] <data directory>\n\n", name, migrateCommand)
+		fmt.Fprintf(os.Stderr, "Upgrades the data directory of a stopped node to the format of this version.\n"+
+			"The node state is verified first. Take a backup before migrating, a migrated\n"+
+			"directory can't be opened by an older version.\n\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	dir := fs.Arg(0)
+	if store.IsNewNode(dir) {
+		errorExit(1, fmt.Sprintf("no node state in %s", dir))
+	}
+
+	info, err := store.ReadDataFormat(dir)
+	if err != nil {
+		errorExit(1, err.Error())
+	}
+	plan, err := store.PlanMigration(dir)
+	if err != nil {
+		errorExit(1, err.Error())
+	}
+	if len(plan) == 0 {
+		fmt.Printf("%s is up to date, at format %d\n", dir, info.Format)
+		return
+	}
+	for _, m := range plan {
+		fmt.Printf("format %d to %d: %s\n", m.From, m.To, m.Description)
+	}
+	if *dryRun {
+		return
+	}
+	if _, err := store.Migrate(dir); err != nil {
+		errorExit(1, err.Error())
+	}
+	fmt.Printf("%s migrated to format %d\n", dir, store.DataFormat)
+}
@@ -0,0 +1,133 @@
+// Copyright 2022 The casbin-mesh Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"log"
+	"os"
+	"sync"
+
+	"github.com/casbin/casbin-mesh/pkg/cluster"
+	"github.com/casbin/casbin-mesh/pkg/store"
+	"github.com/casbin/casbin-mesh/pkg/transport/tcp"
+)
+
+// reloader applies the reloadable subset of the configuration to a running
+// node: the Raft log level, the endpoint and API certificates and keys, the
+// root password and the faults of nodes started with -raft-faults. Other
+// changes only take effect after a restart.
+type reloader struct {
+	mu    sync.Mutex
+	cfg   Config
+	str   *store.Store
+	certs *tcp.CertReloader
+	// apiCerts is nil unless the API has its own certificate.
+	apiCerts *tcp.CertReloader
+	// faults is nil unless the node started with -raft-faults.
+	faults *tcp.Faults
+	// allowList restricts the hosts connecting to the node.
+	allowList *cluster.AllowList
+}
+
+func (r *reloader) reload() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	log.Println("reloading configuration")
+
+	var next Config
+	var err error
+	if r.cfg.dev {
+		next, err = loadDevConfig(os.Args[2:])
+	} else {
+		next, err = loadConfig(os.Args[1:])
+	}
+	if err != nil {
+		log.Printf("failed to load configuration: %s", err.Error())
+		return err
+	}
+
+	if next.raftLogLevel != r.cfg.raftLogLevel {
+		if err = r.str.SetRaftLogLevel(next.raftLogLevel); err != nil {
+			return err
+		}
+		log.Printf("raft log level set to %s", next.raftLogLevel)
+	}
+	if r.certs != nil && next.x509Cert == r.cfg.x509Cert && next.x509Key == r.cfg.x509Key {
+		if err = r.certs.Reload(); err != nil {
+			log.Printf("failed to reload certificate: %s", err.Error())
+			return err
+		}
+		log.Printf("certificate reloaded from %s", next.x509Cert)
+	}
+	if r.apiCerts != nil && next.apiCert == r.cfg.apiCert && next.apiKey == r.cfg.apiKey {
+		if err = r.apiCerts.Reload(); err != nil {
+			log.Printf("failed to reload API certificate: %s", err.Error())
+			return err
+		}
+		log.Printf("API certificate reloaded from %s", next.apiCert)
+	}
+	if r.faults != nil && next.raftFaults != r.cfg.raftFaults {
+		if err = r.faults.Set(next.raftFaults); err != nil {
+			log.Printf("failed to set Raft faults: %s", err.Error())
+			return err
+		}
+		log.Printf("Raft faults set to %q", next.raftFaults)
+	}
+	if next.allowedPeers != r.cfg.allowedPeers {
+		if err = r.allowList.Set(next.allowedPeers); err != nil {
+			log.Printf("failed to set allowed peers: %s", err.Error())
+			return err
+		}
+		log.Printf("allowed peers set to %q", next.allowedPeers)
+	}
+	if next.allowLoopbackPeers != r.cfg.allowLoopbackPeers {
+		r.allowList.SetLoopback(next.allowLoopbackPeers)
+		log.Printf("loopback peers allowed set to %v", next.allowLoopbackPeers)
+	}
+	if r.cfg.enableAuth && next.rootUsername == r.cfg.rootUsername && next.rootPassword != r.cfg.rootPassword {
+		if err = r.str.UpdateCredential(next.rootUsername, next.rootPassword); err != nil {
+			return err
+		}
+		log.Printf("password of %s updated", next.rootUsername)
+	}
+
+	reloaded := next
+	reloaded.raftLogLevel = r.cfg.raftLogLevel
+	reloaded.rootPassword = r.cfg.rootPassword
+	if r.faults != nil {
+		reloaded.raftFaults = r.cfg.raftFaults
+	}
+	reloaded.dataPath = r.cfg.dataPath
+	if reloaded.discoveryMode != "" {
+		// Discovered values are not part of the configuration.
+		reloaded.nodeID, reloaded.raftAdv, reloaded.joinAddr = r.cfg.nodeID, r.cfg.raftAdv, r.cfg.joinAddr
+	}
+	if reloaded.raftAdv == "" {
+		// Neither is the detected advertised address.
+		reloaded.raftAdv = r.cfg.raftAdv
+		if reloaded.nodeID == "" {
+			reloaded.nodeID = r.cfg.nodeID
+		}
+	}
+	if reloaded != r.cfg {
+		log.Println("configuration changes other than raft-log-level, certificate contents and root-password require a restart")
+	}
+	r.cfg.raftLogLevel = next.raftLogLevel
+	r.cfg.rootPassword = next.rootPassword
+	if r.faults != nil {
+		r.cfg.raftFaults = next.raftFaults
+	}
+	return nil
+}
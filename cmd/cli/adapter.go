@@ -0,0 +1,187 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// defaultRuleTable is the table of the Casbin SQL adapters, like the GORM
+// and xorm ones.
+const defaultRuleTable = "casbin_rule"
+
+// tableName guards the table name interpolated into the query.
+var tableName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*(\.[A-Za-z_][A-Za-z0-9_]*)?$`)
+
+// sqlDrivers returns the database/sql drivers linked into the binary.
+func sqlDrivers() string {
+	drivers := sql.Drivers()
+	if len(drivers) == 0 {
+		return "none, build with -tags mysql, postgres or sqlite3"
+	}
+	sort.Strings(drivers)
+	return strings.Join(drivers, ", ")
+}
+
+// openRuleTable checks the driver and the table name, and opens the
+// database.
+func openRuleTable(driver, dsn, table string) (*sql.DB, error) {
+	if !tableName.MatchString(table) {
+		return nil, fmt.Errorf("invalid table name %q", table)
+	}
+	found := false
+	for _, d := range sql.Drivers() {
+		found = found || d == driver
+	}
+	if !found {
+		return nil, fmt.Errorf("unknown SQL driver %q, available: %s", driver, sqlDrivers())
+	}
+	return sql.Open(driver, dsn)
+}
+
+// readSQLPolicies reads the rules stored by a Casbin SQL adapter, in a table
+// of the standard schema: a ptype column and the v0 to v5 columns, NULL or
+// empty when unused.
+func readSQLPolicies(ctx context.Context, driver, dsn, table string) (Rules, error) {
+	db, err := openRuleTable(driver, dsn, table)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+	rows, err := db.QueryContext(ctx, "SELECT ptype, v0, v1, v2, v3, v4, v5 FROM "+table)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var rules Rules
+	for rows.Next() {
+		var ptype string
+		var values [6]sql.NullString
+		if err := rows.Scan(&ptype, &values[0], &values[1], &values[2], &values[3], &values[4], &values[5]); err != nil {
+			return nil, err
+		}
+		var line []string
+		for _, v := range values {
+			if v.String == "" {
+				break
+			}
+			line = append(line, v.String)
+		}
+		rules = append(rules, convertRule(strings.TrimSpace(ptype), line))
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	for _, rule := range rules {
+		if rule.PType == "" || (rule.PType[0] != 'p' && rule.PType[0] != 'g') {
+			return nil, fmt.Errorf("invalid policy type %q in rule %s", rule.PType, rule.Key)
+		}
+	}
+	return rules, nil
+}
+
+// ruleTableDDL creates the table of the Casbin SQL adapters if it is missing.
+// The adapters which add an id column create it with one when they start on
+// an empty table, and keep an existing table as is.
+const ruleTableDDL = `CREATE TABLE IF NOT EXISTS %s (
+  ptype VARCHAR(100) NOT NULL,
+  v0 VARCHAR(100), v1 VARCHAR(100), v2 VARCHAR(100),
+  v3 VARCHAR(100), v4 VARCHAR(100), v5 VARCHAR(100)
+)`
+
+// ruleValues returns the ptype and the v0 to v5 values of rule, unused ones
+// being empty.
+func ruleValues(rule CasbinRule) []string {
+	return []string{rule.PType, rule.V0, rule.V1, rule.V2, rule.V3, rule.V4, rule.V5}
+}
+
+// writeSQLDump writes rules as the SQL statements creating and filling the
+// table of a Casbin SQL adapter.
+func writeSQLDump(w io.Writer, table string, rules Rules) error {
+	if !tableName.MatchString(table) {
+		return fmt.Errorf("invalid table name %q", table)
+	}
+	if _, err := fmt.Fprintf(w, ruleTableDDL+";\n", table); err != nil {
+		return err
+	}
+	for _, rule := range rules {
+		values := ruleValues(rule)
+		for i, v := range values {
+			values[i] = "'" + strings.ReplaceAll(v, "'", "''") + "'"
+		}
+		_, err := fmt.Fprintf(w, "INSERT INTO %s (ptype, v0, v1, v2, v3, v4, v5) VALUES (%s);\n", table, strings.Join(values, ", "))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeSQLPolicies inserts rules into the table of a Casbin SQL adapter,
+// creating it if it is missing, in a single transaction. With replace, the
+// rows of the table are removed first.
+func writeSQLPolicies(ctx context.Context, driver, dsn, table string, rules Rules, replace bool) error {
+	db, err := openRuleTable(driver, dsn, table)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	if _, err := db.ExecContext(ctx, fmt.Sprintf(ruleTableDDL, table)); err != nil {
+		return err
+	}
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if replace {
+		if _, err := tx.ExecContext(ctx, "DELETE FROM "+table); err != nil {
+			return err
+		}
+	}
+	placeholders := make([]string, 7)
+	for i := range placeholders {
+		placeholders[i] = "?"
+		if driver == "postgres" {
+			placeholders[i] = fmt.Sprintf("$%d", i+1)
+		}
+	}
+	stmt, err := tx.PrepareContext(ctx, fmt.Sprintf("INSERT INTO %s (ptype, v0, v1, v2, v3, v4, v5) VALUES (%s)", table, strings.Join(placeholders, ", ")))
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	for _, rule := range rules {
+		values := ruleValues(rule)
+		args := make([]interface{}, len(values))
+		for i, v := range values {
+			args[i] = v
+		}
+		if _, err := stmt.ExecContext(ctx, args...); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,108 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+//go:build sqlite3
+// +build sqlite3
+
+package main
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newRuleTable(t *testing.T, rows ...[]interface{}) string {
+	dsn := filepath.Join(t.TempDir(), "rules.db")
+	db, err := sql.Open("sqlite3", dsn)
+	if err != nil {
+		t.Fatalf("failed to open database: %s", err.Error())
+	}
+	defer db.Close()
+	if _, err := db.Exec("CREATE TABLE casbin_rule (id INTEGER PRIMARY KEY, ptype VARCHAR(100), v0 VARCHAR(100), v1 VARCHAR(100), v2 VARCHAR(100), v3 VARCHAR(100), v4 VARCHAR(100), v5 VARCHAR(100))"); err != nil {
+		t.Fatalf("failed to create table: %s", err.Error())
+	}
+	for _, row := range rows {
+		if _, err := db.Exec("INSERT INTO casbin_rule (ptype, v0, v1, v2, v3, v4, v5) VALUES (?, ?, ?, ?, ?, ?, ?)", row...); err != nil {
+			t.Fatalf("failed to insert rule: %s", err.Error())
+		}
+	}
+	return dsn
+}
+
+func Test_ReadSQLPolicies(t *testing.T) {
+	dsn := newRuleTable(t,
+		[]interface{}{"p", "alice", "data1", "read", "", "", ""},
+		[]interface{}{"g ", "alice", "admin", nil, nil, nil, nil},
+	)
+	rules, err := readSQLPolicies(context.TODO(), "sqlite3", dsn, defaultRuleTable)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, Rules{
+		convertRule("p", []string{"alice", "data1", "read"}),
+		convertRule("g", []string{"alice", "admin"}),
+	}, rules)
+
+	_, err = readSQLPolicies(context.TODO(), "sqlite3", dsn, "casbin_rule; DROP TABLE casbin_rule")
+	assert.NotEqual(t, nil, err)
+	_, err = readSQLPolicies(context.TODO(), "oracle", dsn, defaultRuleTable)
+	assert.NotEqual(t, nil, err)
+
+	dsn = newRuleTable(t, []interface{}{"x", "alice", nil, nil, nil, nil, nil})
+	_, err = readSQLPolicies(context.TODO(), "sqlite3", dsn, defaultRuleTable)
+	assert.NotEqual(t, nil, err)
+}
+
+func Test_WriteSQLPolicies(t *testing.T) {
+	dsn := filepath.Join(t.TempDir(), "rules.db")
+	rules := Rules{
+		convertRule("p", []string{"alice", "data1", "read"}),
+		convertRule("g", []string{"alice", "admin"}),
+	}
+	// the table is created if missing
+	assert.Equal(t, nil, writeSQLPolicies(context.TODO(), "sqlite3", dsn, defaultRuleTable, rules, false))
+	read, err := readSQLPolicies(context.TODO(), "sqlite3", dsn, defaultRuleTable)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, rules, read)
+
+	// rules are appended, or replace the rows with replace
+	more := Rules{convertRule("p", []string{"bob", "data2", "write"})}
+	assert.Equal(t, nil, writeSQLPolicies(context.TODO(), "sqlite3", dsn, defaultRuleTable, more, false))
+	read, err = readSQLPolicies(context.TODO(), "sqlite3", dsn, defaultRuleTable)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, append(append(Rules(nil), rules...), more...), read)
+	assert.Equal(t, nil, writeSQLPolicies(context.TODO(), "sqlite3", dsn, defaultRuleTable, more, true))
+	read, err = readSQLPolicies(context.TODO(), "sqlite3", dsn, defaultRuleTable)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, more, read)
+
+	// the dump loads into an empty database
+	var dump strings.Builder
+	assert.Equal(t, nil, writeSQLDump(&dump, defaultRuleTable, rules))
+	dsn = filepath.Join(t.TempDir(), "dump.db")
+	db, err := sql.Open("sqlite3", dsn)
+	assert.Equal(t, nil, err)
+	defer db.Close()
+	_, err = db.Exec(dump.String())
+	assert.Equal(t, nil, err)
+	read, err = readSQLPolicies(context.TODO(), "sqlite3", dsn, defaultRuleTable)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, rules, read)
+}
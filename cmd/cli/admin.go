@@ -0,0 +1,565 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/erikgeiser/promptkit/confirmation"
+	"github.com/jedib0t/go-pretty/v6/table"
+)
+
+// adminClient talks to the HTTP admin API of a node, following the leader
+// for operations only the leader can perform.
+type adminClient struct {
+	conn   *connOptions
+	client *http.Client
+}
+
+func newAdminClient(conn *connOptions) *adminClient {
+	if conn.host == "" {
+		conn.host = GetHost()
+	}
+	if conn.authType == "" && conn.username != "" {
+		conn.authType = "Basic"
+	}
+	if conn.authType == "Basic" {
+		if conn.username == "" {
+			conn.username = GetUsername()
+		}
+		if conn.password == "" {
+			conn.password = GetPWD()
+		}
+	}
+	return &adminClient{conn: conn, client: &http.Client{Timeout: 30 * time.Second}}
+}
+
+func (a *adminClient) url(host, path string) string {
+	if !strings.HasPrefix(host, "http://") && !strings.HasPrefix(host, "https://") {
+		host = "http://" + host
+	}
+	return strings.TrimSuffix(host, "/") + path
+}
+
+func (a *adminClient) do(host, path string, in, out interface{}) error {
+	var body bytes.Buffer
+	if in != nil {
+		if err := json.NewEncoder(&body).Encode(in); err != nil {
+			return err
+		}
+	}
+	req, err := http.NewRequest(http.MethodPost, a.url(host, path), &body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if a.conn.authType == "Basic" {
+		req.SetBasicAuth(a.conn.username, a.conn.password)
+	}
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	buf, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		var e struct {
+			Error string `json:"error"`
+		}
+		if json.Unmarshal(buf, &e) == nil && e.Error != "" {
+			return errors.New(e.Error)
+		}
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	if out != nil {
+		return json.Unmarshal(buf, out)
+	}
+	return nil
+}
+
+func (a *adminClient) stats(host string) (json.RawMessage, error) {
+	var raw json.RawMessage
+	err := a.do(host, "/stats", nil, &raw)
+	return raw, err
+}
+
+// leaderDo sends the request to the configured node, retrying once against
+// the leader if that node is not the leader.
+func (a *adminClient) leaderDo(path string, in interface{}) error {
+	err := a.do(a.conn.host, path, in, nil)
+	if err == nil || err.Error() != "not leader" {
+		return err
+	}
+	raw, err := a.stats(a.conn.host)
+	if err != nil {
+		return err
+	}
+	var stats clusterStats
+	if err = json.Unmarshal(raw, &stats); err != nil {
+		return err
+	}
+	if stats.Leader.Addr == "" {
+		return errors.New("no leader available")
+	}
+	return a.do(stats.Leader.Addr, path, in, nil)
+}
+
+// confirm asks the user to confirm a destructive action, unless yes is set.
+func confirm(yes bool, format string, a ...interface{}) bool {
+	if yes {
+		return true
+	}
+	ok, err := confirmation.New(fmt.Sprintf(format, a...), confirmation.No).RunPrompt()
+	return err == nil && ok
+}
+
+func runJoin(args []string) error {
+	var conn connOptions
+	fs := flag.NewFlagSet("join", flag.ExitOnError)
+	conn.register(fs)
+	nonVoter := fs.Bool("non-voter", false, "Join as a non-voting node")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: casmesh join [flags] <node-id> <raft-addr>\n")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 2 {
+		fs.Usage()
+		return errors.New("node ID and Raft address are required")
+	}
+	a := newAdminClient(&conn)
+	err := a.leaderDo("/join", map[string]interface{}{
+		"id":    fs.Arg(0),
+		"addr":  fs.Arg(1),
+		"voter": !*nonVoter,
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Node %s at %s joined\n", fs.Arg(0), fs.Arg(1))
+	return nil
+}
+
+func runRemove(args []string) error {
+	var conn connOptions
+	fs := flag.NewFlagSet("remove", flag.ExitOnError)
+	conn.register(fs)
+	yes := fs.Bool("y", false, "Do not ask for confirmation")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: casmesh remove [flags] <node-id>\n")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return errors.New("node ID is required")
+	}
+	a := newAdminClient(&conn)
+	if !confirm(*yes, "Remove node %s from the cluster?", fs.Arg(0)) {
+		fmt.Println("Aborted")
+		return nil
+	}
+	if err := a.leaderDo("/remove", map[string]string{"id": fs.Arg(0)}); err != nil {
+		return err
+	}
+	fmt.Printf("Node %s removed\n", fs.Arg(0))
+	return nil
+}
+
+func runTransferLeader(args []string) error {
+	var conn connOptions
+	fs := flag.NewFlagSet("transfer-leader", flag.ExitOnError)
+	conn.register(fs)
+	yes := fs.Bool("y", false, "Do not ask for confirmation")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: casmesh transfer-leader [flags] [node-id]\n")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() > 1 {
+		fs.Usage()
+		return errors.New("at most one node ID is allowed")
+	}
+	target := fs.Arg(0)
+	a := newAdminClient(&conn)
+	prompt := "Transfer leadership to the most up-to-date node?"
+	if target != "" {
+		prompt = fmt.Sprintf("Transfer leadership to node %s?", target)
+	}
+	if !confirm(*yes, "%s", prompt) {
+		fmt.Println("Aborted")
+		return nil
+	}
+	if err := a.leaderDo("/transfer-leader", map[string]string{"id": target}); err != nil {
+		return err
+	}
+	fmt.Println("Leadership transferred")
+	return nil
+}
+
+func runStepDown(args []string) error {
+	var conn connOptions
+	fs := flag.NewFlagSet("step-down", flag.ExitOnError)
+	conn.register(fs)
+	yes := fs.Bool("y", false, "Do not ask for confirmation")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: casmesh step-down [flags] [node-id]\n")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() > 1 {
+		fs.Usage()
+		return errors.New("at most one node ID is allowed")
+	}
+	a := newAdminClient(&conn)
+	if !confirm(*yes, "Make the leader step down?") {
+		fmt.Println("Aborted")
+		return nil
+	}
+	if err := a.leaderDo("/leader/step-down", map[string]string{"id": fs.Arg(0)}); err != nil {
+		return err
+	}
+	fmt.Println("Leader stepped down")
+	return nil
+}
+
+type leadership struct {
+	NodeID  string `json:"node_id"`
+	Addr    string `json:"addr"`
+	Since   string `json:"since"`
+	Until   string `json:"until"`
+	HeldFor string `json:"held_for"`
+}
+
+func runLeader(args []string) error {
+	var conn connOptions
+	fs := flag.NewFlagSet("leader", flag.ExitOnError)
+	conn.register(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	a := newAdminClient(&conn)
+	var out struct {
+		leadership
+		Term    uint64       `json:"term"`
+		History []leadership `json:"history"`
+	}
+	if err := a.do(conn.host, "/leader", nil, &out); err != nil {
+		return err
+	}
+	t := table.NewWriter()
+	t.SetOutputMirror(os.Stdout)
+	t.AppendHeader(table.Row{"Node", "Raft Address", "Since", "Until", "Held For"})
+	for _, l := range append([]leadership{out.leadership}, out.History...) {
+		t.AppendRow(table.Row{l.NodeID, l.Addr, l.Since, l.Until, l.HeldFor})
+	}
+	t.Render()
+	fmt.Printf("Term: %d\n", out.Term)
+	return nil
+}
+
+type followerProgress struct {
+	NodeID     string `json:"node_id"`
+	Addr       string `json:"addr"`
+	Voter      bool   `json:"voter"`
+	MatchIndex uint64 `json:"match_index"`
+	Lag        uint64 `json:"lag"`
+	Snapshot   *struct {
+		Size int64 `json:"size"`
+		Sent int64 `json:"sent"`
+	} `json:"snapshot"`
+	CatchUp  string `json:"catch_up"`
+	CaughtUp bool   `json:"caught_up"`
+}
+
+func runReplication(args []string) error {
+	var conn connOptions
+	fs := flag.NewFlagSet("replication", flag.ExitOnError)
+	conn.register(fs)
+	maxLag := fs.Uint64("max-lag", 0, "Number of entries a follower may lag behind and still be caught up")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	a := newAdminClient(&conn)
+	var out struct {
+		Followers []followerProgress `json:"followers"`
+	}
+	if err := a.do(conn.host, fmt.Sprintf("/cluster/replication?max_lag=%d", *maxLag), nil, &out); err != nil {
+		return err
+	}
+	t := table.NewWriter()
+	t.SetOutputMirror(os.Stdout)
+	t.AppendHeader(table.Row{"Node", "Raft Address", "Voter", "Match Index", "Lag", "Snapshot", "Catch-Up", "Caught Up"})
+	for _, f := range out.Followers {
+		snapshot := ""
+		if f.Snapshot != nil {
+			snapshot = fmt.Sprintf("%d/%d bytes", f.Snapshot.Sent, f.Snapshot.Size)
+		}
+		t.AppendRow(table.Row{f.NodeID, f.Addr, f.Voter, f.MatchIndex, f.Lag, snapshot, f.CatchUp, f.CaughtUp})
+	}
+	t.Render()
+	return nil
+}
+
+func runConsistency(args []string) error {
+	var conn connOptions
+	fs := flag.NewFlagSet("consistency", flag.ExitOnError)
+	conn.register(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	a := newAdminClient(&conn)
+	var out struct {
+		Index uint64 `json:"index"`
+		Nodes []struct {
+			NodeID    string   `json:"node_id"`
+			Digest    string   `json:"digest"`
+			Divergent []string `json:"divergent"`
+			Error     string   `json:"error"`
+		} `json:"nodes"`
+		Divergent bool `json:"divergent"`
+	}
+	if err := a.do(conn.host, "/cluster/consistency", nil, &out); err != nil {
+		return err
+	}
+	t := table.NewWriter()
+	t.SetOutputMirror(os.Stdout)
+	t.AppendHeader(table.Row{"Node", "Digest", "Divergent Namespaces", "Error"})
+	for _, n := range out.Nodes {
+		t.AppendRow(table.Row{n.NodeID, n.Digest, strings.Join(n.Divergent, ", "), n.Error})
+	}
+	t.Render()
+	fmt.Printf("Index: %d\n", out.Index)
+	if out.Divergent {
+		return errors.New("the state of some nodes diverged from the leader's")
+	}
+	return nil
+}
+
+func runVerifySnapshot(args []string) error {
+	var conn connOptions
+	fs := flag.NewFlagSet("verify-snapshot", flag.ExitOnError)
+	conn.register(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	a := newAdminClient(&conn)
+	var out struct {
+		ID         string `json:"id"`
+		Index      uint64 `json:"index"`
+		Size       int64  `json:"size"`
+		Namespaces int    `json:"namespaces"`
+		Policies   int    `json:"policies"`
+		Digest     string `json:"digest"`
+		Recorded   string `json:"recorded"`
+		OK         bool   `json:"ok"`
+		Error      string `json:"error"`
+		Duration   string `json:"duration"`
+	}
+	if err := a.do(conn.host, "/snapshot/verify", nil, &out); err != nil {
+		return err
+	}
+	fmt.Printf("Snapshot:   %s (index %d, %d bytes)\n", out.ID, out.Index, out.Size)
+	fmt.Printf("Restored:   %d namespaces, %d policies in %s\n", out.Namespaces, out.Policies, out.Duration)
+	fmt.Printf("Digest:     %s\n", out.Digest)
+	if out.Recorded != "" {
+		fmt.Printf("Recorded:   %s\n", out.Recorded)
+	}
+	if !out.OK {
+		return fmt.Errorf("snapshot verification failed: %s", out.Error)
+	}
+	fmt.Println("Snapshot verified")
+	return nil
+}
+
+func runReadOnly(args []string) error {
+	var conn connOptions
+	fs := flag.NewFlagSet("read-only", flag.ExitOnError)
+	conn.register(fs)
+	reason := fs.String("reason", "", "Reason reported to the rejected writers")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: casmesh read-only [flags] on|off\n")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 || (fs.Arg(0) != "on" && fs.Arg(0) != "off") {
+		fs.Usage()
+		return errors.New("on or off is required")
+	}
+	enabled := fs.Arg(0) == "on"
+	a := newAdminClient(&conn)
+	err := a.leaderDo("/set/read_only", map[string]interface{}{
+		"enabled": enabled,
+		"reason":  *reason,
+	})
+	if err != nil {
+		return err
+	}
+	if enabled {
+		fmt.Println("Cluster is read-only")
+	} else {
+		fmt.Println("Cluster is writable")
+	}
+	return nil
+}
+
+// runCordon cordons the node given by -host, it is not forwarded to the
+// leader.
+func runCordon(args []string) error {
+	var conn connOptions
+	fs := flag.NewFlagSet("cordon", flag.ExitOnError)
+	conn.register(fs)
+	reason := fs.String("reason", "", "Reason reported to the refused clients")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: casmesh cordon [flags]\n")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	a := newAdminClient(&conn)
+	if err := a.do(a.conn.host, "/cordon", map[string]string{"reason": *reason}, nil); err != nil {
+		return err
+	}
+	fmt.Printf("Node %s cordoned\n", a.conn.host)
+	return nil
+}
+
+func runUncordon(args []string) error {
+	var conn connOptions
+	fs := flag.NewFlagSet("uncordon", flag.ExitOnError)
+	conn.register(fs)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: casmesh uncordon [flags]\n")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	a := newAdminClient(&conn)
+	if err := a.do(a.conn.host, "/uncordon", nil, nil); err != nil {
+		return err
+	}
+	fmt.Printf("Node %s uncordoned\n", a.conn.host)
+	return nil
+}
+
+// runDrain waits for the node given by -host to serve no Enforce or write
+// request anymore, e.g. once cordoned before a deploy.
+func runDrain(args []string) error {
+	var conn connOptions
+	fs := flag.NewFlagSet("drain", flag.ExitOnError)
+	conn.register(fs)
+	wait := fs.Duration("wait", 30*time.Second, "How long to wait for the requests in flight to complete")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: casmesh drain [flags]\n")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	a := newAdminClient(&conn)
+	a.client.Timeout = *wait + 30*time.Second
+	var out struct {
+		Drained  bool `json:"drained"`
+		InFlight struct {
+			Enforce struct {
+				Count     int           `json:"count"`
+				OldestAge time.Duration `json:"oldest_age"`
+			} `json:"enforce"`
+			Write struct {
+				Count     int           `json:"count"`
+				OldestAge time.Duration `json:"oldest_age"`
+			} `json:"write"`
+		} `json:"in_flight"`
+	}
+	if err := a.do(a.conn.host, "/drain?wait="+wait.String(), nil, &out); err != nil {
+		return err
+	}
+	if !out.Drained {
+		return fmt.Errorf("node %s not drained: %d enforce requests in flight (oldest %s), %d writes (oldest %s)",
+			a.conn.host, out.InFlight.Enforce.Count, out.InFlight.Enforce.OldestAge,
+			out.InFlight.Write.Count, out.InFlight.Write.OldestAge)
+	}
+	fmt.Printf("Node %s drained\n", a.conn.host)
+	return nil
+}
+
+// runJoinToken creates a single-use join token on the leader, and prints
+// it.
+func runJoinToken(args []string) error {
+	var conn connOptions
+	fs := flag.NewFlagSet("join-token", flag.ExitOnError)
+	conn.register(fs)
+	ttl := fs.Duration("ttl", time.Hour, "How long the token remains valid")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: casmesh join-token [flags]\n")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	a := newAdminClient(&conn)
+	var out struct {
+		Token   string    `json:"token"`
+		Expires time.Time `json:"expires"`
+	}
+	if err := a.do(a.conn.host, "/cluster/join-token?ttl="+ttl.String(), nil, &out); err != nil {
+		return err
+	}
+	fmt.Printf("%s\nValid until %s\n", out.Token, out.Expires.Format(time.RFC3339))
+	return nil
+}
+
+func runStatus(args []string) error {
+	var conn connOptions
+	fs := flag.NewFlagSet("status", flag.ExitOnError)
+	conn.register(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	a := newAdminClient(&conn)
+	start := time.Now()
+	raw, err := a.stats(conn.host)
+	if err != nil {
+		return err
+	}
+	return printClusterStatus(raw, time.Since(start))
+}
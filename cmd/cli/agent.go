@@ -0,0 +1,76 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"github.com/casbin/casbin-mesh/client/v2"
+)
+
+func runAgent(args []string) error {
+	var conn connOptions
+	fs := flag.NewFlagSet("agent", flag.ExitOnError)
+	conn.register(fs)
+	socket := fs.String("socket", "/var/run/casmesh.sock", "Path of the Unix socket to serve on")
+	namespaces := fs.String("namespaces", "", "Comma separated namespaces to load at startup, others are loaded on first use")
+	cacheSize := fs.Int("cache-size", client.DefaultSidecarCacheSize, "Number of decisions memoized per namespace, negative to disable")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: casmesh agent [flags]\n")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	c := conn.connect()
+	defer c.Close()
+	opts := client.SidecarOptions{CacheSize: *cacheSize}
+	for _, ns := range strings.Split(*namespaces, ",") {
+		if ns = strings.TrimSpace(ns); ns != "" {
+			opts.Namespaces = append(opts.Namespaces, ns)
+		}
+	}
+	s := c.NewSidecar(opts)
+	defer s.Close()
+	if err := s.Start(context.Background()); err != nil {
+		return err
+	}
+
+	errs := make(chan error, 1)
+	go func() {
+		errs <- s.ListenAndServeUnix(*socket)
+	}()
+	log.Printf("serving enforce on %s", *socket)
+	terminate := make(chan os.Signal, 1)
+	signal.Notify(terminate, os.Interrupt, syscall.SIGTERM)
+	select {
+	case err := <-errs:
+		return err
+	case <-terminate:
+	}
+	// the socket is removed when the sidecar closes its listener
+	return nil
+}
@@ -23,10 +23,12 @@ import (
 	"fmt"
 	"github.com/c-bata/go-prompt"
 	"github.com/casbin/casbin-mesh/client/v2"
+	"github.com/casbin/casbin-mesh/proto/command"
 	"github.com/jedib0t/go-pretty/v6/table"
 	"github.com/tidwall/pretty"
 	"log"
 	"os"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -63,6 +65,7 @@ var UpdateSuggests = []prompt.Suggest{
 var NamespaceSuggests = append([]prompt.Suggest{
 	{"print model", "Print model"},
 	{"show policies", "List all policies"},
+	{"enforce", "Enforce a request"},
 }, TopLevelSuggests...)
 
 var TopLevelSuggests = merge([]prompt.Suggest{
@@ -70,6 +73,8 @@ var TopLevelSuggests = merge([]prompt.Suggest{
 	{"use", "Set namespace"},
 	{"exit", "Exit"},
 	{"show namespaces", "Show namespaces"},
+	{"cluster status", "Show cluster members and leader"},
+	{"history", "Show command history"},
 }, AddSuggests, UpdateSuggests, RemoveSuggests)
 
 func merge(ps ...[]prompt.Suggest) []prompt.Suggest {
@@ -95,6 +100,9 @@ type ctx struct {
 	cmd              string
 	namespace        string
 	namespaces       []prompt.Suggest
+	host             string
+	history          []string
+	historyPath      string
 }
 
 func (c *ctx) LoadNamespaces() error {
@@ -114,6 +122,83 @@ func NewCtx(c *client.Client) *ctx {
 	}
 }
 
+func (c *ctx) Prefix() (string, bool) {
+	if c.namespace == "" {
+		return fmt.Sprintf("%s >> ", c.host), true
+	}
+	return fmt.Sprintf("%s:%s >> ", c.host, c.namespace), true
+}
+
+type clusterStats struct {
+	NodeID string `json:"node_id"`
+	Leader struct {
+		NodeID string `json:"node_id"`
+		Addr   string `json:"addr"`
+	} `json:"leader"`
+	Nodes []struct {
+		ID       string            `json:"id"`
+		Addr     string            `json:"addr"`
+		Metadata map[string]string `json:"metadata"`
+	} `json:"nodes"`
+	Raft     map[string]interface{} `json:"raft"`
+	ReadOnly struct {
+		Enabled bool   `json:"enabled"`
+		Reason  string `json:"reason"`
+	} `json:"read_only"`
+}
+
+func (c *ctx) PrintClusterStatus() {
+	start := time.Now()
+	buf, err := c.ShowStats(context.TODO())
+	if err == nil {
+		err = printClusterStatus(buf, time.Since(start))
+	}
+	if err != nil {
+		fmt.Printf("Error:%s\n", err.Error())
+	}
+}
+
+func printClusterStatus(buf []byte, elapsed time.Duration) error {
+	var stats clusterStats
+	if err := json.Unmarshal(buf, &stats); err != nil {
+		return err
+	}
+	t := table.NewWriter()
+	t.SetOutputMirror(os.Stdout)
+	t.AppendHeader(table.Row{"Node", "Raft Address", "Role", "Labels", ""})
+	for _, n := range stats.Nodes {
+		role := "Follower"
+		if n.ID == stats.Leader.NodeID {
+			role = "Leader"
+		}
+		var self string
+		if n.ID == stats.NodeID {
+			self = "*"
+		}
+		t.AppendRow(table.Row{n.ID, n.Addr, role, labels(n.Metadata), self})
+	}
+	t.Render()
+	fmt.Printf("Term: %v, Commit index: %v, Applied index: %v <%s>\n",
+		stats.Raft["term"], stats.Raft["commit_index"], stats.Raft["applied_index"], elapsed)
+	if stats.ReadOnly.Enabled {
+		fmt.Printf("Read-only: %s\n", stats.ReadOnly.Reason)
+	}
+	return nil
+}
+
+// labels formats the metadata of a node, but for its API address and
+// protocol.
+func labels(md map[string]string) string {
+	var pairs []string
+	for k, v := range md {
+		if k != "api_addr" && k != "api_proto" {
+			pairs = append(pairs, k+"="+v)
+		}
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
 func (c *ctx) PrintUpdateOperations() {
 	t := table.NewWriter()
 	t.SetOutputMirror(os.Stdout)
@@ -227,12 +312,13 @@ func (c *ctx) InitTx() {
 	c.updateOperations = nil
 }
 func (c *ctx) Executor(line string) {
-	cmds := strings.Split(strings.TrimSpace(line), " ")
-	var argv []string
-	if len(cmds) > 0 {
-		c.cmd = cmds[0]
-		argv = cmds[1:]
+	cmds := strings.Fields(line)
+	if len(cmds) == 0 {
+		return
 	}
+	c.AppendHistory(strings.TrimSpace(line))
+	c.cmd = cmds[0]
+	argv := cmds[1:]
 	switch strings.ToUpper(cmds[0]) {
 	case "DELETE":
 		if len(argv) > 0 {
@@ -321,6 +407,29 @@ func (c *ctx) Executor(line string) {
 				fmt.Printf("No effected rules! <%s>\n", elapsed)
 			}
 		}
+	case "ENFORCE":
+		if c.namespace == "" {
+			fmt.Printf("No namespace selected, run use <namespace> first\n")
+			return
+		}
+		params := make([]interface{}, len(argv))
+		for i, arg := range argv {
+			params[i] = arg
+		}
+		start := time.Now()
+		ok, err := c.Enforce(context.TODO(), c.namespace, command.EnforcePayload_QUERY_REQUEST_LEVEL_NONE, 0, params...)
+		elapsed := time.Since(start)
+		if err != nil {
+			fmt.Printf("Error:%s\n", err.Error())
+			return
+		}
+		fmt.Printf("%v <%s>\n", ok, elapsed)
+	case "CLUSTER":
+		if len(argv) > 0 && strings.ToUpper(argv[0]) == "STATUS" {
+			c.PrintClusterStatus()
+		}
+	case "HISTORY":
+		c.PrintHistory()
 	case "USE":
 		if len(argv) > 0 {
 			c.namespace = argv[0]
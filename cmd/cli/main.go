@@ -18,49 +18,141 @@
 package main
 
 import (
+	"flag"
 	"fmt"
+	"os"
+	"strings"
+
 	"github.com/c-bata/go-prompt"
 	"github.com/casbin/casbin-mesh/client/v2"
 )
 
-//func completer(d prompt.Document) []prompt.Suggest {
-//	s := []prompt.Suggest{
-//		{Text: "show", Description: "Store the username and age"},
-//		{Text: "create", Description: "Store the article text posted by user"},
-//		{Text: "delete", Description: "Store the text commented to articles"},
-//		{Text: "exit"},
-//	}
-//	return prompt.FilterHasPrefix(s, d.GetWordBeforeCursorWithSpace(), true)
-//}
-//
-//func action(ctx *cli.Context) error {
-//	addr := ctx.String("Addr")
-//	c := NewCtx(NewClient(options{}))
-//	p := prompt.New(c.Executor, c.Completer)
-//	p.Run()
-//	return nil
-//}
+type subcommand struct {
+	name  string
+	usage string
+	run   func(args []string) error
+}
+
+var subcommands = []subcommand{
+	{"shell", "Start an interactive shell (default)", runShell},
+	{"status", "Show cluster members and leader", runStatus},
+	{"join", "Add a node to the cluster", runJoin},
+	{"remove", "Remove a node from the cluster", runRemove},
+	{"transfer-leader", "Hand leadership over to another node", runTransferLeader},
+	{"leader", "Show the leader and the former leaders", runLeader},
+	{"step-down", "Make the leader step down, unless it was just elected", runStepDown},
+	{"replication", "Show how far each follower is behind the leader", runReplication},
+	{"consistency", "Compare the state of every node to the leader's", runConsistency},
+	{"read-only", "Turn the read-only maintenance mode on or off", runReadOnly},
+	{"cordon", "Stop a node from serving clients and leading, for maintenance", runCordon},
+	{"uncordon", "Have a cordoned node serve clients again", runUncordon},
+	{"drain", "Wait for a node to complete the requests in flight", runDrain},
+	{"join-token", "Create a single-use token for a node to join the cluster", runJoinToken},
+	{"verify-snapshot", "Check the latest snapshot of a node restores", runVerifySnapshot},
+	{"create", "Create a namespace, optionally from a model preset", runCreate},
+	{"import", "Import policies from a CSV or JSON file, or a Casbin SQL adapter", runImport},
+	{"export", "Export policies to a CSV, JSON or SQL file, or a Casbin SQL adapter", runExport},
+	{"diff", "Show the changes needed to reach a state file", runDiff},
+	{"apply", "Sync a namespace to a state file", runApply},
+	{"agent", "Serve enforce on a Unix socket from local copies of the namespaces", runAgent},
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: casmesh <command> [flags]\n\nCommands:\n")
+	for _, cmd := range subcommands {
+		fmt.Fprintf(os.Stderr, "  %-16s %s\n", cmd.name, cmd.usage)
+	}
+	fmt.Fprintf(os.Stderr, "\nRun 'casmesh <command> -h' for the flags of a command.\n")
+}
 
 func main() {
-	host := GetHost()
-	authType := GetAuthType()
-	var name, pwd string
-	if authType == "Basic" {
-		name = GetUsername()
-		pwd = GetPWD()
+	name, args := "shell", os.Args[1:]
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		name, args = args[0], args[1:]
+	}
+	if name == "help" {
+		usage()
+		return
+	}
+	for _, cmd := range subcommands {
+		if cmd.name == name {
+			if err := cmd.run(args); err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %s\n", err.Error())
+				os.Exit(1)
+			}
+			return
+		}
+	}
+	fmt.Fprintf(os.Stderr, "unknown command %q\n\n", name)
+	usage()
+	os.Exit(2)
+}
+
+// connOptions are the flags shared by all commands to reach the cluster.
+type connOptions struct {
+	host     string
+	authType string
+	username string
+	password string
+}
+
+func (o *connOptions) register(fs *flag.FlagSet) {
+	fs.StringVar(&o.host, "host", "", "Address of a node, prompted for if not set")
+	fs.StringVar(&o.authType, "auth", "", "Auth type, Noop or Basic, prompted for if not set")
+	fs.StringVar(&o.username, "username", "", "Username for Basic auth")
+	fs.StringVar(&o.password, "password", "", "Password for Basic auth")
+}
+
+// connect asks for whatever is missing from the flags and dials the cluster.
+func (o *connOptions) connect() *client.Client {
+	if o.host == "" {
+		o.host = GetHost()
+	}
+	if o.authType == "" {
+		if o.username != "" {
+			o.authType = client.Basic
+		} else {
+			o.authType = GetAuthType()
+		}
 	}
-	c := client.NewClient(client.Options{
-		Target:   host,
-		AuthType: client.Basic,
-		Username: name,
-		Password: pwd,
+	if o.authType == client.Basic {
+		if o.username == "" {
+			o.username = GetUsername()
+		}
+		if o.password == "" {
+			o.password = GetPWD()
+		}
+	}
+	return client.NewClient(client.Options{
+		Target:   o.host,
+		AuthType: o.authType,
+		Username: o.username,
+		Password: o.password,
 	})
-	ctx := NewCtx(c)
+}
+
+func runShell(args []string) error {
+	var conn connOptions
+	fs := flag.NewFlagSet("shell", flag.ExitOnError)
+	conn.register(fs)
+	historyFile := fs.String("history", defaultHistoryPath(), "File the shell history is kept in, empty to disable")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	ctx := NewCtx(conn.connect())
+	ctx.host = conn.host
+	ctx.LoadHistory(*historyFile)
+	if err := ctx.LoadNamespaces(); err != nil {
+		fmt.Printf("Error:%s\n", err.Error())
+	}
 	p := prompt.New(
 		ctx.Executor,
 		ctx.Completer,
 		prompt.OptionCompletionOnDown(),
-		prompt.OptionPrefix(fmt.Sprintf("%s >> ", host)),
+		prompt.OptionHistory(ctx.history),
+		prompt.OptionLivePrefix(ctx.Prefix),
 	)
 	p.Run()
+	return nil
 }
@@ -0,0 +1,87 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/casbin/casbin-mesh/pkg/preset"
+)
+
+func runCreate(args []string) error {
+	var conn connOptions
+	fs := flag.NewFlagSet("create", flag.ExitOnError)
+	conn.register(fs)
+	namespace := fs.String("namespace", "", "Namespace to create")
+	presetName := fs.String("preset", "", "Model preset the namespace starts with, see -list-presets")
+	modelFile := fs.String("model", "", "Model file the namespace starts with")
+	listPresets := fs.Bool("list-presets", false, "List the model presets and exit")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: casmesh create [flags] -namespace <namespace>\n")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *listPresets {
+		for _, p := range preset.List() {
+			fmt.Printf("%-18s %s\n", p.Name, p.Description)
+		}
+		return nil
+	}
+	if *namespace == "" {
+		return errors.New("namespace is required")
+	}
+	if *presetName != "" && *modelFile != "" {
+		return errors.New("preset and model are mutually exclusive")
+	}
+	var text string
+	if *presetName != "" {
+		p, err := preset.Get(*presetName)
+		if err != nil {
+			return err
+		}
+		text = p.Text
+	}
+	if *modelFile != "" {
+		b, err := ioutil.ReadFile(*modelFile)
+		if err != nil {
+			return err
+		}
+		text = string(b)
+	}
+
+	c := conn.connect()
+	defer c.Close()
+	ctx := context.Background()
+	if err := c.CreateNamespace(ctx, *namespace); err != nil {
+		return err
+	}
+	if text != "" {
+		if err := c.SetModelFromString(ctx, *namespace, text); err != nil {
+			return err
+		}
+	}
+	fmt.Fprintf(os.Stderr, "Created namespace %s\n", *namespace)
+	return nil
+}
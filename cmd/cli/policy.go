@@ -0,0 +1,331 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/casbin/casbin-mesh/client/v2"
+	"github.com/casbin/casbin-mesh/proto/command"
+)
+
+const (
+	formatCSV  = "csv"
+	formatJSON = "json"
+	// formatSQL is a dump of a Casbin SQL adapter table, only exported.
+	formatSQL = "sql"
+)
+
+// policyFormat returns format, or the format implied by the extension of
+// path if format is empty.
+func policyFormat(format, path string) (string, error) {
+	if format == "" {
+		format = strings.TrimPrefix(strings.ToLower(filepath.Ext(path)), ".")
+		if format == "" {
+			format = formatCSV
+		}
+	}
+	switch format {
+	case formatCSV, formatJSON, formatSQL:
+		return format, nil
+	}
+	return "", fmt.Errorf("unsupported format %q, must be csv, json or sql", format)
+}
+
+// readPolicies reads rules in the Casbin CSV format ("p, alice, data1, read")
+// or as a JSON array of rules.
+func readPolicies(r io.Reader, format string) (Rules, error) {
+	var rules Rules
+	switch format {
+	case formatSQL:
+		return nil, errors.New("SQL dumps can't be imported, load the dump into a database and import it with -driver")
+	case formatJSON:
+		if err := json.NewDecoder(r).Decode(&rules); err != nil {
+			return nil, err
+		}
+		for i, rule := range rules {
+			rules[i] = convertRule(rule.PType, rule.ToStringArray())
+		}
+	default:
+		reader := csv.NewReader(r)
+		reader.Comment = '#'
+		reader.FieldsPerRecord = -1
+		reader.TrimLeadingSpace = true
+		for {
+			line, err := reader.Read()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return nil, err
+			}
+			rules = append(rules, convertRule(line[0], line[1:]))
+		}
+	}
+	for _, rule := range rules {
+		if rule.PType == "" || (rule.PType[0] != 'p' && rule.PType[0] != 'g') {
+			return nil, fmt.Errorf("invalid policy type %q in rule %s", rule.PType, rule.Key)
+		}
+	}
+	return rules, nil
+}
+
+func writePolicies(w io.Writer, format string, rules Rules) error {
+	switch format {
+	case formatJSON:
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(rules)
+	default:
+		writer := csv.NewWriter(w)
+		for _, rule := range rules {
+			if err := writer.Write(append([]string{rule.PType}, rule.ToStringArray()...)); err != nil {
+				return err
+			}
+		}
+		writer.Flush()
+		return writer.Error()
+	}
+}
+
+// livePolicies returns the model and rules currently stored in namespace.
+func livePolicies(ctx context.Context, c *client.Client, namespace string) (string, Rules, error) {
+	snapshot, err := c.Snapshot(ctx, namespace)
+	if err != nil {
+		return "", nil, err
+	}
+	var rules Rules
+	for _, p := range snapshot.Policies {
+		for _, rule := range command.ToStringArray(p.Rules) {
+			rules = append(rules, convertRule(p.PType, rule))
+		}
+	}
+	return snapshot.Model, rules, nil
+}
+
+// diffRules returns the rules of desired missing from current, and the rules
+// of current missing from desired.
+func diffRules(current, desired Rules) (add, remove Rules) {
+	have := make(map[string]bool, len(current))
+	for _, rule := range current {
+		have[rule.Key] = true
+	}
+	want := make(map[string]bool, len(desired))
+	for _, rule := range desired {
+		if !have[rule.Key] && !want[rule.Key] {
+			add = append(add, rule)
+		}
+		want[rule.Key] = true
+	}
+	for _, rule := range current {
+		if !want[rule.Key] {
+			remove = append(remove, rule)
+		}
+	}
+	return
+}
+
+func printDiff(w io.Writer, add, remove Rules) {
+	for _, rule := range remove {
+		fmt.Fprintf(w, "- %s\n", strings.Join(append([]string{rule.PType}, rule.ToStringArray()...), ", "))
+	}
+	for _, rule := range add {
+		fmt.Fprintf(w, "+ %s\n", strings.Join(append([]string{rule.PType}, rule.ToStringArray()...), ", "))
+	}
+	fmt.Fprintf(w, "%d to add, %d to remove\n", len(add), len(remove))
+}
+
+type batchFn func(ctx context.Context, namespace, sec, ptype string, rules [][]string) ([][]string, error)
+
+// applyRules sends rules in batches of at most size rules of the same policy
+// type, calling progress after each batch.
+func applyRules(ctx context.Context, fn batchFn, namespace string, rules Rules, size int, progress func(done int)) error {
+	if size <= 0 {
+		size = len(rules)
+	}
+	var done int
+	for start := 0; start < len(rules); {
+		ptype := rules[start].PType
+		end := start
+		var batch [][]string
+		for end < len(rules) && rules[end].PType == ptype && len(batch) < size {
+			batch = append(batch, rules[end].ToStringArray())
+			end++
+		}
+		if _, err := fn(ctx, namespace, ptype[:1], ptype, batch); err != nil {
+			return err
+		}
+		done += len(batch)
+		progress(done)
+		start = end
+	}
+	return nil
+}
+
+func progressPrinter(verb string, total int) func(int) {
+	return func(done int) {
+		fmt.Fprintf(os.Stderr, "\r%s %d/%d rules", verb, done, total)
+		if done == total {
+			fmt.Fprintln(os.Stderr)
+		}
+	}
+}
+
+func runExport(args []string) error {
+	var conn connOptions
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	conn.register(fs)
+	namespace := fs.String("namespace", "", "Namespace to export")
+	format := fs.String("format", "", "File format, csv, json or sql, by default implied by the file extension")
+	driver := fs.String("driver", "", "Write the rules into the table of a Casbin SQL adapter with this database/sql driver, the argument being its DSN. Available: "+sqlDrivers())
+	table := fs.String("table", defaultRuleTable, "Table of the Casbin SQL adapter, for the sql format and -driver")
+	replace := fs.Bool("replace", false, "With -driver, remove the rows of the table first")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: casmesh export [flags] [file]\n")
+		fmt.Fprintf(fs.Output(), "       casmesh export -driver <driver> [flags] <dsn>\n")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *namespace == "" {
+		return errors.New("namespace is required")
+	}
+	if *driver != "" && fs.NArg() != 1 {
+		return errors.New("dsn is required")
+	}
+	f, err := policyFormat(*format, fs.Arg(0))
+	if *driver != "" {
+		f, err = formatSQL, nil
+	}
+	if err != nil {
+		return err
+	}
+
+	c := conn.connect()
+	defer c.Close()
+	ctx := context.Background()
+	_, rules, err := livePolicies(ctx, c, *namespace)
+	if err != nil {
+		return err
+	}
+	if *driver != "" {
+		if err = writeSQLPolicies(ctx, *driver, fs.Arg(0), *table, rules, *replace); err != nil {
+			return fmt.Errorf("failed to write %s: %s", *table, err.Error())
+		}
+		fmt.Fprintf(os.Stderr, "Exported %d rules to %s\n", len(rules), *table)
+		return nil
+	}
+	write := func(w io.Writer) error { return writePolicies(w, f, rules) }
+	if f == formatSQL {
+		write = func(w io.Writer) error { return writeSQLDump(w, *table, rules) }
+	}
+	if fs.Arg(0) == "" {
+		return write(os.Stdout)
+	}
+	out, err := os.Create(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	if err = write(out); err != nil {
+		out.Close()
+		return err
+	}
+	if err = out.Close(); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "Exported %d rules to %s\n", len(rules), fs.Arg(0))
+	return nil
+}
+
+func runImport(args []string) error {
+	var conn connOptions
+	fs := flag.NewFlagSet("import", flag.ExitOnError)
+	conn.register(fs)
+	namespace := fs.String("namespace", "", "Namespace to import into")
+	format := fs.String("format", "", "File format, csv or json, by default implied by the file extension")
+	dryRun := fs.Bool("dry-run", false, "Only show the changes the import would make")
+	replace := fs.Bool("replace", false, "Remove rules of the namespace that are not in the file")
+	batch := fs.Int("batch", 500, "Maximum number of rules sent per request")
+	driver := fs.String("driver", "", "Read the rules of a Casbin SQL adapter with this database/sql driver, the argument being its DSN. Available: "+sqlDrivers())
+	table := fs.String("table", defaultRuleTable, "Table of the Casbin SQL adapter")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: casmesh import [flags] <file>\n")
+		fmt.Fprintf(fs.Output(), "       casmesh import -driver <driver> [flags] <dsn>\n")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *namespace == "" || fs.NArg() != 1 {
+		fs.Usage()
+		return errors.New("namespace and source are required")
+	}
+	var desired Rules
+	if *driver != "" {
+		rules, err := readSQLPolicies(context.Background(), *driver, fs.Arg(0), *table)
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %s", *table, err.Error())
+		}
+		desired = rules
+	} else {
+		f, err := policyFormat(*format, fs.Arg(0))
+		if err != nil {
+			return err
+		}
+		in, err := os.Open(fs.Arg(0))
+		if err != nil {
+			return err
+		}
+		desired, err = readPolicies(in, f)
+		in.Close()
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %s", fs.Arg(0), err.Error())
+		}
+	}
+
+	c := conn.connect()
+	defer c.Close()
+	ctx := context.Background()
+	_, current, err := livePolicies(ctx, c, *namespace)
+	if err != nil {
+		return err
+	}
+	add, remove := diffRules(current, desired)
+	if !*replace {
+		remove = nil
+	}
+	printDiff(os.Stdout, add, remove)
+	if *dryRun {
+		return nil
+	}
+
+	if err = applyRules(ctx, c.RemovePolicies, *namespace, remove, *batch, progressPrinter("Removed", len(remove))); err != nil {
+		return err
+	}
+	return applyRules(ctx, c.AddPolicies, *namespace, add, *batch, progressPrinter("Added", len(add)))
+}
@@ -0,0 +1,188 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/casbin/casbin-mesh/pkg/core"
+	"github.com/casbin/casbin-mesh/pkg/testkit"
+	"github.com/casbin/casbin-mesh/proto/command"
+	"github.com/stretchr/testify/assert"
+)
+
+const modelText = `
+[request_definition]
+r = sub, obj, act
+
+[policy_definition]
+p = sub, obj, act
+
+[role_definition]
+g = _, _
+
+[policy_effect]
+e = some(where (p.eft == allow))
+
+[matchers]
+m = g(r.sub, p.sub) && r.obj == p.obj && r.act == p.act
+`
+
+// hostCore reports the node it is served by as the leader, as the Raft
+// address of the in-process nodes can't be dialed.
+type hostCore struct {
+	core.Core
+	addr string
+}
+
+func (c hostCore) Stats(ctx context.Context) (map[string]interface{}, error) {
+	return map[string]interface{}{"leader": map[string]string{"addr": c.addr}}, nil
+}
+
+// newTestHost serves the gRPC API of a single node cluster, whose namespace
+// ns holds rules.
+func newTestHost(t *testing.T, ns string, rules ...[]string) (string, *testkit.Node) {
+	c := testkit.NewCluster(t, 1)
+	node := c.Leader()
+	ctx := context.TODO()
+	assert.Equal(t, nil, node.Core.CreateNamespace(ctx, ns))
+	assert.Equal(t, nil, node.Core.SetModelFromString(ctx, ns, modelText))
+	if len(rules) > 0 {
+		_, err := node.Core.AddPolicies(ctx, ns, "p", "p", rules)
+		assert.Equal(t, nil, err)
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %s", err.Error())
+	}
+	srv := core.NewGrpcService(hostCore{Core: node.Core, addr: ln.Addr().String()}, nil, nil, nil, nil)
+	go srv.Serve(ln)
+	t.Cleanup(srv.Stop)
+	return ln.Addr().String(), node
+}
+
+// captureStdout returns what fn writes to the standard output.
+func captureStdout(t *testing.T, fn func() error) string {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatalf("failed to create pipe: %s", err.Error())
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	out := make(chan string)
+	go func() {
+		var buf bytes.Buffer
+		io.Copy(&buf, r)
+		out <- buf.String()
+	}()
+	err = fn()
+	os.Stdout = stdout
+	w.Close()
+	assert.Equal(t, nil, err)
+	return <-out
+}
+
+func writeFile(t *testing.T, name, content string) string {
+	path := filepath.Join(t.TempDir(), name)
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatalf("failed to write %s: %s", name, err.Error())
+	}
+	return path
+}
+
+func livePolicyLines(t *testing.T, node *testkit.Node, ns string) [][]string {
+	_, policies, _, err := node.Core.NamespaceSnapshot(context.TODO(), ns)
+	assert.Equal(t, nil, err)
+	var rules [][]string
+	for _, p := range policies {
+		rules = append(rules, command.ToStringArray(p.Rules)...)
+	}
+	return rules
+}
+
+func Test_ReadWritePolicies(t *testing.T) {
+	csv := "p, alice, data1, read\n# a comment\ng, alice, admin\n"
+	rules, err := readPolicies(strings.NewReader(csv), formatCSV)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, Rules{convertRule("p", []string{"alice", "data1", "read"}), convertRule("g", []string{"alice", "admin"})}, rules)
+
+	for _, format := range []string{formatCSV, formatJSON} {
+		var buf bytes.Buffer
+		assert.Equal(t, nil, writePolicies(&buf, format, rules))
+		read, err := readPolicies(&buf, format)
+		assert.Equal(t, nil, err, format)
+		assert.Equal(t, rules, read, format)
+	}
+
+	_, err = readPolicies(strings.NewReader("x, alice, data1\n"), formatCSV)
+	assert.NotNil(t, err)
+	_, err = policyFormat("", "rules.xml")
+	assert.NotNil(t, err)
+	f, err := policyFormat("", "rules.JSON")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, formatJSON, f)
+}
+
+func Test_DiffRules(t *testing.T) {
+	current := Rules{convertRule("p", []string{"alice", "data1", "read"}), convertRule("p", []string{"bob", "data2", "write"})}
+	desired := Rules{convertRule("p", []string{"alice", "data1", "read"}), convertRule("g", []string{"bob", "admin"}), convertRule("g", []string{"bob", "admin"})}
+	add, remove := diffRules(current, desired)
+	assert.Equal(t, Rules{desired[1]}, add)
+	assert.Equal(t, Rules{current[1]}, remove)
+
+	var buf bytes.Buffer
+	printDiff(&buf, add, remove)
+	assert.Equal(t, "- p, bob, data2, write\n+ g, bob, admin\n1 to add, 1 to remove\n", buf.String())
+}
+
+func Test_ImportPolicies(t *testing.T) {
+	host, node := newTestHost(t, "default", []string{"bob", "data2", "write"})
+	path := writeFile(t, "rules.csv", "p, alice, data1, read\np, bob, data2, write\n")
+	conn := []string{"-host", host, "-auth", "Noop", "-namespace", "default"}
+
+	// A dry run only shows the changes.
+	out := captureStdout(t, func() error {
+		return runImport(append(conn, "-dry-run", "-replace", path))
+	})
+	assert.Equal(t, "+ p, alice, data1, read\n1 to add, 0 to remove\n", out)
+	assert.Equal(t, [][]string{{"bob", "data2", "write"}}, livePolicyLines(t, node, "default"))
+
+	captureStdout(t, func() error { return runImport(append(conn, path)) })
+	assert.ElementsMatch(t, [][]string{{"alice", "data1", "read"}, {"bob", "data2", "write"}}, livePolicyLines(t, node, "default"))
+
+	// -replace removes the rules missing from the file.
+	path = writeFile(t, "rules.json", `[{"p_type":"p","v0":"alice","v1":"data1","v2":"read"}]`)
+	out = captureStdout(t, func() error { return runImport(append(conn, "-replace", path)) })
+	assert.Equal(t, "- p, bob, data2, write\n0 to add, 1 to remove\n", out)
+	assert.Equal(t, [][]string{{"alice", "data1", "read"}}, livePolicyLines(t, node, "default"))
+
+	// Exports read back the same.
+	exported := filepath.Join(t.TempDir(), "export.csv")
+	captureStdout(t, func() error { return runExport(append(conn, exported)) })
+	b, err := ioutil.ReadFile(exported)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "p,alice,data1,read\n", string(b))
+}
@@ -0,0 +1,227 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/casbin/casbin-mesh/client/v2"
+	"github.com/casbin/casbin/v2/model"
+	"google.golang.org/grpc/status"
+	"gopkg.in/yaml.v3"
+)
+
+// desiredState is the content of a state file, in YAML or JSON:
+//
+//	namespace: ns1
+//	model: |
+//	  [request_definition]
+//	  ...
+//	policies:
+//	  - p, alice, data1, read
+//	  - g, alice, admin
+type desiredState struct {
+	Namespace string   `yaml:"namespace" json:"namespace"`
+	Model     string   `yaml:"model" json:"model"`
+	Policies  []string `yaml:"policies" json:"policies"`
+}
+
+func loadDesiredState(path string) (*desiredState, Rules, error) {
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	var state desiredState
+	if err = yaml.Unmarshal(buf, &state); err != nil {
+		return nil, nil, fmt.Errorf("failed to parse %s: %s", path, err.Error())
+	}
+	rules, err := readPolicies(strings.NewReader(strings.Join(state.Policies, "\n")), formatCSV)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to parse policies of %s: %s", path, err.Error())
+	}
+	return &state, rules, nil
+}
+
+// modelLines returns the significant lines of a model in the canonical form
+// the server prints it in, so that equivalent models compare equal.
+func modelLines(text string) []string {
+	if m, err := model.NewModelFromString(text); err == nil {
+		text = m.ToText()
+	}
+	var lines []string
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// plan is the set of changes that brings a namespace to its desired state.
+type plan struct {
+	namespace       string
+	createNamespace bool
+	model           string
+	oldModel        []string
+	newModel        []string
+	add             Rules
+	remove          Rules
+}
+
+func (p *plan) empty() bool {
+	return !p.createNamespace && p.newModel == nil && len(p.add) == 0 && len(p.remove) == 0
+}
+
+func makePlan(ctx context.Context, c *client.Client, state *desiredState, desired Rules, prune bool) (*plan, error) {
+	p := &plan{namespace: state.Namespace, model: state.Model}
+	model, current, err := livePolicies(ctx, c, state.Namespace)
+	if err != nil {
+		if status.Convert(err).Message() != "namespace not exist" {
+			return nil, err
+		}
+		p.createNamespace = true
+	}
+	if state.Model != "" {
+		oldModel, newModel := modelLines(model), modelLines(state.Model)
+		if strings.Join(oldModel, "\n") != strings.Join(newModel, "\n") {
+			p.oldModel, p.newModel = oldModel, newModel
+		}
+	} else if p.createNamespace {
+		return nil, fmt.Errorf("namespace %s does not exist and no model is given", state.Namespace)
+	}
+	p.add, p.remove = diffRules(current, desired)
+	if !prune {
+		p.remove = nil
+	}
+	return p, nil
+}
+
+func (p *plan) print(w io.Writer) {
+	if p.empty() {
+		fmt.Fprintf(w, "Namespace %s is up to date\n", p.namespace)
+		return
+	}
+	if p.createNamespace {
+		fmt.Fprintf(w, "+ namespace %s\n", p.namespace)
+	}
+	if p.newModel != nil {
+		fmt.Fprintf(w, "~ model\n")
+		old := make(map[string]bool, len(p.oldModel))
+		for _, line := range p.oldModel {
+			old[line] = true
+		}
+		updated := make(map[string]bool, len(p.newModel))
+		for _, line := range p.newModel {
+			updated[line] = true
+		}
+		for _, line := range p.oldModel {
+			if !updated[line] {
+				fmt.Fprintf(w, "    - %s\n", line)
+			}
+		}
+		for _, line := range p.newModel {
+			if !old[line] {
+				fmt.Fprintf(w, "    + %s\n", line)
+			}
+		}
+	}
+	printDiff(w, p.add, p.remove)
+}
+
+func (p *plan) apply(ctx context.Context, c *client.Client, batch int) error {
+	if p.createNamespace {
+		if err := c.CreateNamespace(ctx, p.namespace); err != nil {
+			return err
+		}
+	}
+	if p.newModel != nil {
+		if err := c.SetModelFromString(ctx, p.namespace, p.model); err != nil {
+			return err
+		}
+	}
+	if err := applyRules(ctx, c.RemovePolicies, p.namespace, p.remove, batch, progressPrinter("Removed", len(p.remove))); err != nil {
+		return err
+	}
+	return applyRules(ctx, c.AddPolicies, p.namespace, p.add, batch, progressPrinter("Added", len(p.add)))
+}
+
+func runSync(name string, args []string) error {
+	var conn connOptions
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	conn.register(fs)
+	file := fs.String("f", "", "State file holding the namespace, model and policies")
+	namespace := fs.String("namespace", "", "Namespace to sync, overrides the one of the state file")
+	prune := fs.Bool("prune", true, "Remove rules of the namespace that are not in the state file")
+	yes := fs.Bool("y", false, "Do not ask for confirmation")
+	batch := fs.Int("batch", 500, "Maximum number of rules sent per request")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: casmesh %s [flags] -f <file>\n", name)
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *file == "" {
+		fs.Usage()
+		return errors.New("state file is required")
+	}
+	state, desired, err := loadDesiredState(*file)
+	if err != nil {
+		return err
+	}
+	if *namespace != "" {
+		state.Namespace = *namespace
+	}
+	if state.Namespace == "" {
+		return errors.New("namespace is required")
+	}
+
+	c := conn.connect()
+	defer c.Close()
+	ctx := context.Background()
+	p, err := makePlan(ctx, c, state, desired, *prune)
+	if err != nil {
+		return err
+	}
+	p.print(os.Stdout)
+	if name == "diff" || p.empty() {
+		return nil
+	}
+	if !confirm(*yes, "Apply these changes to namespace %s?", state.Namespace) {
+		fmt.Println("Aborted")
+		return nil
+	}
+	return p.apply(ctx, c, *batch)
+}
+
+func runDiff(args []string) error {
+	return runSync("diff", args)
+}
+
+func runApply(args []string) error {
+	return runSync("apply", args)
+}
@@ -0,0 +1,53 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_DiffApply(t *testing.T) {
+	host, node := newTestHost(t, "default", []string{"bob", "data2", "write"})
+	conn := []string{"-host", host, "-auth", "Noop"}
+	state := writeFile(t, "state.yaml", "namespace: billing\nmodel: |"+strings.ReplaceAll(modelText, "\n", "\n  ")+
+		"\npolicies:\n  - p, alice, invoices, read\n  - g, alice, admin\n")
+
+	// A missing namespace is created with the model.
+	out := captureStdout(t, func() error { return runDiff(append(conn, "-f", state)) })
+	assert.True(t, strings.HasPrefix(out, "+ namespace billing\n~ model\n"), out)
+	assert.True(t, strings.HasSuffix(out, "+ p, alice, invoices, read\n+ g, alice, admin\n2 to add, 0 to remove\n"), out)
+	_, _, _, err := node.Core.NamespaceSnapshot(context.TODO(), "billing")
+	assert.NotNil(t, err)
+
+	captureStdout(t, func() error { return runApply(append(conn, "-f", state, "-y")) })
+	out = captureStdout(t, func() error { return runDiff(append(conn, "-f", state)) })
+	assert.Equal(t, "Namespace billing is up to date\n", out)
+
+	// The namespace of the file can be overridden, and rules not in it pruned
+	// unless -prune=false.
+	out = captureStdout(t, func() error { return runDiff(append(conn, "-f", state, "-namespace", "default")) })
+	assert.Equal(t, "- p, bob, data2, write\n+ p, alice, invoices, read\n+ g, alice, admin\n2 to add, 1 to remove\n", out)
+	out = captureStdout(t, func() error { return runDiff(append(conn, "-f", state, "-namespace", "default", "-prune=false")) })
+	assert.Equal(t, "+ p, alice, invoices, read\n+ g, alice, admin\n2 to add, 0 to remove\n", out)
+	captureStdout(t, func() error { return runApply(append(conn, "-f", state, "-namespace", "default", "-y")) })
+	assert.Equal(t, [][]string{{"alice", "invoices", "read"}, {"alice", "admin"}}, livePolicyLines(t, node, "default"))
+}
@@ -4,24 +4,35 @@ go 1.15
 
 require (
 	github.com/BBVA/raft-badger v1.1.0
+	github.com/BurntSushi/toml v0.3.1
 	github.com/c-bata/go-prompt v0.2.6
 	github.com/casbin/casbin/v2 v2.31.10
 	github.com/dgraph-io/badger/v3 v3.2011.1
+	github.com/envoyproxy/go-control-plane v0.9.9-0.20210512163311-63b5d3c536b0
 	github.com/erikgeiser/promptkit v0.6.0
+	github.com/go-ldap/ldap/v3 v3.3.0
 	github.com/go-playground/universal-translator v0.17.0 // indirect
 	github.com/go-playground/validator v9.31.0+incompatible
+	github.com/go-sql-driver/mysql v1.6.0
 	github.com/golang/protobuf v1.5.2
 	github.com/grpc-ecosystem/go-grpc-middleware v1.3.0
+	github.com/hashicorp/go-hclog v0.9.1
 	github.com/hashicorp/go-multierror v1.1.1
 	github.com/hashicorp/raft v1.3.1
 	github.com/jedib0t/go-pretty/v6 v6.2.4
 	github.com/leodido/go-urn v1.2.1 // indirect
+	github.com/lib/pq v1.10.2
+	github.com/mattn/go-sqlite3 v1.14.6
+	github.com/nats-io/nats.go v1.11.0
 	github.com/rs/cors v1.8.0
+	github.com/segmentio/kafka-go v0.4.17
 	github.com/soheilhy/cmux v0.1.5
 	github.com/stretchr/testify v1.6.1
 	github.com/tidwall/pretty v1.2.0
-	golang.org/x/crypto v0.0.0-20201016220609-9e8e0b390897
-	golang.org/x/net v0.0.0-20201202161906-c7110b5ffcbb
+	golang.org/x/crypto v0.0.0-20210314154223-e6e6c4f2bb5b
+	golang.org/x/net v0.0.0-20210226172049-e18ecbb05110
+	google.golang.org/genproto v0.0.0-20200526211855-cb27e3aa2013
 	google.golang.org/grpc v1.39.0
 	google.golang.org/protobuf v1.26.0
+	gopkg.in/yaml.v3 v3.0.0-20200313102051-9f266ea9e77c
 )
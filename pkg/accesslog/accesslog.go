@@ -0,0 +1,216 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+// Package accesslog records the requests served by the HTTP API, apart from
+// the application logs.
+package accesslog
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/casbin/casbin-mesh/pkg/auth"
+)
+
+const (
+	// Common is the Common Log Format of web servers.
+	Common = "common"
+	// JSON writes a JSON object per request.
+	JSON = "json"
+)
+
+// Config configures the access log.
+type Config struct {
+	// Format is Common or JSON.
+	Format string
+	// Output is stdout, stderr or the path of a file appended to.
+	Output string
+	// SampleRate is the fraction of requests recorded, between 0 and 1.
+	SampleRate float64
+}
+
+// Entry is the record of a request, written as is in the JSON format.
+type Entry struct {
+	Time       time.Time `json:"time"`
+	RemoteAddr string    `json:"remote_addr"`
+	// User is the principal authenticated by its certificate, or the
+	// username of basic authentication.
+	User     string  `json:"user,omitempty"`
+	Method   string  `json:"method"`
+	URI      string  `json:"uri"`
+	Proto    string  `json:"proto"`
+	Status   int     `json:"status"`
+	Bytes    int64   `json:"bytes"`
+	Duration float64 `json:"duration_ms"`
+}
+
+// Logger writes the access log.
+type Logger struct {
+	cfg    Config
+	mu     sync.Mutex
+	out    io.Writer
+	closer io.Closer
+}
+
+// New returns a Logger writing to cfg.Output.
+func New(cfg Config) (*Logger, error) {
+	switch cfg.Format {
+	case Common, JSON:
+	default:
+		return nil, fmt.Errorf("unknown access log format %q, expected %s or %s", cfg.Format, Common, JSON)
+	}
+	if cfg.SampleRate < 0 || cfg.SampleRate > 1 {
+		return nil, fmt.Errorf("access log sample %v is not between 0 and 1", cfg.SampleRate)
+	}
+	l := &Logger{cfg: cfg}
+	switch cfg.Output {
+	case "", "stdout":
+		l.out = os.Stdout
+	case "stderr":
+		l.out = os.Stderr
+	default:
+		f, err := os.OpenFile(cfg.Output, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			return nil, err
+		}
+		l.out, l.closer = f, f
+	}
+	return l, nil
+}
+
+// Close closes the file the access log is written to, if any.
+func (l *Logger) Close() error {
+	if l.closer == nil {
+		return nil
+	}
+	return l.closer.Close()
+}
+
+// Handler records the requests served by next.
+func (l *Logger) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if l.cfg.SampleRate < 1 && rand.Float64() >= l.cfg.SampleRate {
+			next.ServeHTTP(w, r)
+			return
+		}
+		start := time.Now()
+		rec := &recorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+		user := auth.PrincipalFromContext(r.Context())
+		if user == "" {
+			user, _, _ = r.BasicAuth()
+		}
+		status := rec.status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		l.write(Entry{
+			Time:       start,
+			RemoteAddr: r.RemoteAddr,
+			User:       user,
+			Method:     r.Method,
+			URI:        r.RequestURI,
+			Proto:      r.Proto,
+			Status:     status,
+			Bytes:      rec.bytes,
+			Duration:   float64(time.Since(start)) / float64(time.Millisecond),
+		})
+	})
+}
+
+func (l *Logger) write(e Entry) {
+	var line []byte
+	if l.cfg.Format == JSON {
+		b, err := json.Marshal(e)
+		if err != nil {
+			return
+		}
+		line = append(b, '\n')
+	} else {
+		line = []byte(formatCommon(e))
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	// a failed write must not fail the request
+	_, _ = l.out.Write(line)
+}
+
+// formatCommon formats e in the Common Log Format.
+func formatCommon(e Entry) string {
+	host, _, err := net.SplitHostPort(e.RemoteAddr)
+	if err != nil {
+		host = e.RemoteAddr
+	}
+	user := e.User
+	if user == "" {
+		user = "-"
+	}
+	return fmt.Sprintf("%s - %s [%s] %q %d %d\n", host, strings.ReplaceAll(user, " ", "_"),
+		e.Time.Format("02/Jan/2006:15:04:05 -0700"), e.Method+" "+e.URI+" "+e.Proto, e.Status, e.Bytes)
+}
+
+// recorder records the status and the size of a response.
+type recorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (w *recorder) WriteHeader(code int) {
+	if w.status == 0 {
+		w.status = code
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *recorder) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(b)
+	w.bytes += int64(n)
+	return n, err
+}
+
+func (w *recorder) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Hijack lets the WebSocket endpoints take over the connection, which is
+// recorded as switching protocols.
+func (w *recorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("connection cannot be hijacked")
+	}
+	if w.status == 0 {
+		w.status = http.StatusSwitchingProtocols
+	}
+	return h.Hijack()
+}
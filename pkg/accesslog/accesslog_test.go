@@ -0,0 +1,80 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package accesslog
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func serve(t *testing.T, l *Logger, r *http.Request) {
+	h := l.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte("hello"))
+	}))
+	h.ServeHTTP(httptest.NewRecorder(), r)
+}
+
+func Test_Formats(t *testing.T) {
+	var buf bytes.Buffer
+	l := &Logger{cfg: Config{Format: Common, SampleRate: 1}, out: &buf}
+	r := httptest.NewRequest(http.MethodPost, "/enforce?x=1", nil)
+	r.RemoteAddr = "10.0.0.7:51544"
+	r.SetBasicAuth("alice", "secret")
+	serve(t, l, r)
+	line := buf.String()
+	if !strings.HasPrefix(line, `10.0.0.7 - alice [`) || !strings.HasSuffix(line, `] "POST /enforce?x=1 HTTP/1.1" 201 5`+"\n") {
+		t.Fatalf("unexpected common log line %q", line)
+	}
+
+	buf.Reset()
+	l.cfg.Format = JSON
+	serve(t, l, r)
+	var e Entry
+	if err := json.Unmarshal(buf.Bytes(), &e); err != nil {
+		t.Fatalf("failed to decode JSON log line %q: %s", buf.String(), err)
+	}
+	if e.User != "alice" || e.Method != http.MethodPost || e.URI != "/enforce?x=1" || e.Status != http.StatusCreated || e.Bytes != 5 || time.Since(e.Time) > time.Minute {
+		t.Fatalf("unexpected JSON log entry %+v", e)
+	}
+}
+
+func Test_Sampling(t *testing.T) {
+	var buf bytes.Buffer
+	l := &Logger{cfg: Config{Format: Common, SampleRate: 0}, out: &buf}
+	for i := 0; i < 10; i++ {
+		serve(t, l, httptest.NewRequest(http.MethodGet, "/stats", nil))
+	}
+	if buf.Len() != 0 {
+		t.Fatalf("expected no request recorded, got %q", buf.String())
+	}
+}
+
+func Test_NewInvalid(t *testing.T) {
+	if _, err := New(Config{Format: "apache", SampleRate: 1}); err == nil {
+		t.Fatalf("expected an unknown format refused")
+	}
+	if _, err := New(Config{Format: JSON, SampleRate: 2}); err == nil {
+		t.Fatalf("expected a sample rate above 1 refused")
+	}
+}
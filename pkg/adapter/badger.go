@@ -158,6 +158,40 @@ func (bucket *Bucket) List(cursor string, skip int64, limit int64, reverse bool)
 	return out, err
 }
 
+// Page returns up to limit key/value pairs of the keys with prefix, in key
+// order, starting after the key after. more tells whether keys follow the
+// page.
+func (bucket *Bucket) Page(prefix, after []byte, limit int) (out [][]string, more bool, err error) {
+	err = bucket.conn.View(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.DefaultIteratorOptions)
+		defer it.Close()
+		bucketPrefix := bucket.withPrefix(nil)
+		keyPrefix := bucket.withPrefix(prefix)
+		start := keyPrefix
+		if len(after) > 0 {
+			start = bucket.withPrefix(after)
+		}
+		for it.Seek(start); it.ValidForPrefix(keyPrefix); it.Next() {
+			item := it.Item()
+			k := item.Key()
+			if len(after) > 0 && string(k[len(bucketPrefix):]) == string(after) {
+				continue
+			}
+			if len(out) == limit {
+				more = true
+				return nil
+			}
+			value, err := item.ValueCopy(nil)
+			if err != nil {
+				return err
+			}
+			out = append(out, []string{string(k[len(bucketPrefix):]), string(value)})
+		}
+		return nil
+	})
+	return out, more, err
+}
+
 func (bucket *Bucket) ForEach(fn func(key []byte, value []byte) error) error {
 	return bucket.conn.View(func(txn *badger.Txn) error {
 		it := txn.NewIterator(badger.DefaultIteratorOptions)
@@ -244,6 +278,20 @@ func (b BadgerStore) Update(fn func(tx *Tx) error) error {
 	return nil
 }
 
+// VerifyChecksum verifies the checksums of the tables of the database.
+func (b *BadgerStore) VerifyChecksum() error {
+	return b.conn.VerifyChecksum()
+}
+
+// Close stops the value log GC and closes the database.
+func (b *BadgerStore) Close() error {
+	if b.vlogTicker != nil {
+		b.vlogTicker.Stop()
+		b.mandatoryVlogTicker.Stop()
+	}
+	return b.conn.Close()
+}
+
 type IBoltStore interface {
 	Restore(reader io.Reader) error
 	Snapshot(writer io.Writer) error
@@ -291,6 +339,12 @@ func NewBadgerStore(path string) (*BadgerStore, error) {
 	return New(Options{Path: path})
 }
 
+// NewInMemoryBadgerStore returns a Raft backend held in memory, for tests.
+func NewInMemoryBadgerStore() (*BadgerStore, error) {
+	opts := badger.DefaultOptions("").WithInMemory(true)
+	return New(Options{BadgerOptions: &opts, NoSync: true})
+}
+
 // New uses the supplied options to open the Badger db and prepare it for
 // use as a raft backend.
 func New(options Options) (*BadgerStore, error) {
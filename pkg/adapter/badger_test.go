@@ -113,3 +113,20 @@ func (suite *BadgerTestSuite) TestListWithCursorSkip() {
 	})
 	assert.Nil(suite.T(), err)
 }
+
+func (suite *BadgerTestSuite) TestPage() {
+	err := suite.db.View(func(tx *Tx) error {
+		bucket := tx.Bucket([]byte("test"))
+		r1, more, err := bucket.Page([]byte("nested."), nil, 600)
+		assert.Nil(suite.T(), err)
+		assert.Equal(suite.T(), 600, len(r1))
+		assert.True(suite.T(), more)
+		r2, more, err := bucket.Page([]byte("nested."), []byte(r1[599][0]), 600)
+		assert.Nil(suite.T(), err)
+		assert.Equal(suite.T(), 400, len(r2))
+		assert.False(suite.T(), more)
+		assert.True(suite.T(), r1[599][0] < r2[0][0])
+		return nil
+	})
+	assert.Nil(suite.T(), err)
+}
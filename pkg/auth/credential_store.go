@@ -22,6 +22,7 @@ import (
 	"errors"
 	"golang.org/x/crypto/bcrypt"
 	"io"
+	"sync"
 )
 
 // BasicAuthProvider is the interface an object must support to return basic auth information.
@@ -37,6 +38,7 @@ type Credential struct {
 
 // CredentialsStore stores authentication and authorization information for all users.
 type CredentialsStore struct {
+	mu    sync.RWMutex
 	store map[string]string
 }
 
@@ -54,6 +56,8 @@ var (
 
 // Add adds a Account
 func (c *CredentialsStore) Add(username string, password string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
 	if _, ok := c.store[username]; ok {
 		return ErrUserExists
 	}
@@ -67,6 +71,8 @@ func (c *CredentialsStore) Add(username string, password string) error {
 
 // Remove removes a Account
 func (c *CredentialsStore) Remove(username string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
 	if _, ok := c.store[username]; ok {
 		delete(c.store, username)
 		return nil
@@ -76,15 +82,23 @@ func (c *CredentialsStore) Remove(username string) error {
 
 // Update updates a Account
 func (c *CredentialsStore) Update(username, newPassword string) error {
-	if _, ok := c.store[username]; ok {
-		c.store[username] = newPassword
-		return nil
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.store[username]; !ok {
+		return ErrUserNotExists
 	}
-	return ErrUserNotExists
+	hashed, err := bcrypt.GenerateFromPassword([]byte(newPassword), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+	c.store[username] = string(hashed)
+	return nil
 }
 
 // Load loads credential information from a reader.
 func (c *CredentialsStore) Load(r io.Reader) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
 	dec := json.NewDecoder(r)
 	err := dec.Decode(&c.store)
 	if err != nil {
@@ -95,6 +109,8 @@ func (c *CredentialsStore) Load(r io.Reader) error {
 
 // Snapshot takes a snapshot
 func (c *CredentialsStore) Snapshot(w io.Writer) error {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
 	data, err := json.Marshal(c.store)
 	if err != nil {
 		return err
@@ -105,6 +121,8 @@ func (c *CredentialsStore) Snapshot(w io.Writer) error {
 
 // Check returns true if the password is correct for the given username.
 func (c *CredentialsStore) Check(username, password string) bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
 	pw, ok := c.store[username]
 	if !ok {
 		return false
@@ -101,3 +101,22 @@ func Test_AuthLoadHashedSingleRequest(t *testing.T) {
 		t.Fatalf("username2 (b4) credential not checked correctly via request")
 	}
 }
+
+func Test_AuthUpdate(t *testing.T) {
+	store := NewCredentialsStore()
+	if err := store.Add("root", "old"); err != nil {
+		t.Fatalf("failed to add credential: %s", err.Error())
+	}
+	if err := store.Update("root", "new"); err != nil {
+		t.Fatalf("failed to update credential: %s", err.Error())
+	}
+	if store.Check("root", "old") {
+		t.Fatalf("old password still accepted after update")
+	}
+	if !store.Check("root", "new") {
+		t.Fatalf("new password not accepted after update")
+	}
+	if err := store.Update("nobody", "new"); err != ErrUserNotExists {
+		t.Fatalf("expected ErrUserNotExists, got %v", err)
+	}
+}
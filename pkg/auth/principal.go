@@ -0,0 +1,141 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package auth
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// DefaultPrincipalMapping prefers URI SANs, like SPIFFE IDs, then DNS SANs,
+// then the common name.
+const DefaultPrincipalMapping = "uri,dns,cn"
+
+var ErrForbidden = errors.New("namespace out of the scope of the principal")
+
+// PrincipalMapping lists the fields of client certificates, among uri, dns,
+// email and cn, the principal is taken from. The first one set wins.
+type PrincipalMapping []string
+
+// ParsePrincipalMapping parses a comma-separated PrincipalMapping.
+func ParsePrincipalMapping(spec string) (PrincipalMapping, error) {
+	var m PrincipalMapping
+	for _, field := range strings.Split(spec, ",") {
+		field = strings.TrimSpace(field)
+		switch field {
+		case "uri", "dns", "email", "cn":
+			m = append(m, field)
+		default:
+			return nil, fmt.Errorf("unknown certificate field %q", field)
+		}
+	}
+	return m, nil
+}
+
+// Principal returns the principal of the client of a TLS connection, or an
+// empty string if the client did not present a verified certificate.
+func (m PrincipalMapping) Principal(state *tls.ConnectionState) string {
+	if state == nil || len(state.VerifiedChains) == 0 || len(state.VerifiedChains[0]) == 0 {
+		return ""
+	}
+	cert := state.VerifiedChains[0][0]
+	for _, field := range m {
+		if p := certField(cert, field); p != "" {
+			return p
+		}
+	}
+	return ""
+}
+
+func certField(cert *x509.Certificate, field string) string {
+	switch field {
+	case "uri":
+		if len(cert.URIs) > 0 {
+			return cert.URIs[0].String()
+		}
+	case "dns":
+		if len(cert.DNSNames) > 0 {
+			return cert.DNSNames[0]
+		}
+	case "email":
+		if len(cert.EmailAddresses) > 0 {
+			return cert.EmailAddresses[0]
+		}
+	case "cn":
+		return cert.Subject.CommonName
+	}
+	return ""
+}
+
+// Scopes are the namespaces principals may access. Principals without
+// scopes may access none, * grants access to every namespace.
+type Scopes map[string][]string
+
+// ParseScopes parses comma-separated principal=namespace pairs, a principal
+// may be repeated to access several namespaces.
+func ParseScopes(spec string) (Scopes, error) {
+	s := make(Scopes)
+	for _, item := range strings.Split(spec, ",") {
+		i := strings.LastIndex(item, "=")
+		if i <= 0 || i == len(item)-1 {
+			return nil, fmt.Errorf("invalid scope %q, expected principal=namespace", item)
+		}
+		p := strings.TrimSpace(item[:i])
+		s[p] = append(s[p], strings.TrimSpace(item[i+1:]))
+	}
+	return s, nil
+}
+
+// Allowed reports whether principal may access namespace ns.
+func (s Scopes) Allowed(principal, ns string) bool {
+	for _, scope := range s[principal] {
+		if scope == "*" || scope == ns {
+			return true
+		}
+	}
+	return false
+}
+
+// CertAuth authenticates API clients by their certificate.
+type CertAuth struct {
+	Mapping PrincipalMapping
+	// Scopes restrict the namespaces of principals, nil for no restriction.
+	Scopes Scopes
+}
+
+type principalKey struct{}
+
+// WithPrincipal returns a copy of ctx carrying the principal of the client,
+// if there is one.
+func WithPrincipal(ctx context.Context, principal string) context.Context {
+	if principal == "" {
+		return ctx
+	}
+	return context.WithValue(ctx, principalKey{}, principal)
+}
+
+// PrincipalFromContext returns the principal of the client, authenticated by
+// its certificate, or an empty string.
+func PrincipalFromContext(ctx context.Context) string {
+	p, _ := ctx.Value(principalKey{}).(string)
+	return p
+}
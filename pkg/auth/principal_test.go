@@ -0,0 +1,80 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package auth
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net/url"
+	"testing"
+)
+
+func Test_PrincipalMapping(t *testing.T) {
+	spiffe, _ := url.Parse("spiffe://mesh.local/ns/billing/sa/api")
+	cert := &x509.Certificate{
+		Subject:  pkix.Name{CommonName: "billing-api"},
+		URIs:     []*url.URL{spiffe},
+		DNSNames: []string{"api.billing.svc"},
+	}
+	state := &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{cert}}}
+
+	m, err := ParsePrincipalMapping(DefaultPrincipalMapping)
+	if err != nil {
+		t.Fatalf("failed to parse mapping: %s", err.Error())
+	}
+	if p := m.Principal(state); p != spiffe.String() {
+		t.Fatalf("wrong principal, exp %s, got %s", spiffe, p)
+	}
+	m, _ = ParsePrincipalMapping("email,cn")
+	if p := m.Principal(state); p != "billing-api" {
+		t.Fatalf("wrong principal, exp billing-api, got %s", p)
+	}
+
+	// Certificates that were not verified don't identify anybody.
+	if p := m.Principal(&tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}}); p != "" {
+		t.Fatalf("unverified certificate mapped to %s", p)
+	}
+	if _, err := ParsePrincipalMapping("uri,serial"); err == nil {
+		t.Fatalf("unknown certificate field accepted")
+	}
+}
+
+func Test_Scopes(t *testing.T) {
+	s, err := ParseScopes("spiffe://mesh.local/ns/billing/sa/api=billing,billing-api=billing,billing-api=invoices,admin=*")
+	if err != nil {
+		t.Fatalf("failed to parse scopes: %s", err.Error())
+	}
+	for _, c := range []struct {
+		principal, ns string
+		exp           bool
+	}{
+		{"spiffe://mesh.local/ns/billing/sa/api", "billing", true},
+		{"spiffe://mesh.local/ns/billing/sa/api", "invoices", false},
+		{"billing-api", "invoices", true},
+		{"admin", "anything", true},
+		{"unknown", "billing", false},
+	} {
+		if got := s.Allowed(c.principal, c.ns); got != c.exp {
+			t.Fatalf("Allowed(%s, %s) = %v, exp %v", c.principal, c.ns, got, c.exp)
+		}
+	}
+	if _, err := ParseScopes("billing-api"); err == nil {
+		t.Fatalf("scope without namespace accepted")
+	}
+}
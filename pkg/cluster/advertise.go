@@ -0,0 +1,228 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package cluster
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrUnroutableAddr is returned when other nodes can't reach a node
+	// through the address it advertises.
+	ErrUnroutableAddr = errors.New("address is not routable")
+
+	// ErrNoAdvertiseAddr is returned when no address to advertise is found.
+	ErrNoAdvertiseAddr = errors.New("no address to advertise found")
+)
+
+// AdvertiseDetector determines the address a node bound to an unspecified
+// host, such as 0.0.0.0 in a container, is reached through. The first source
+// giving an address wins: Host, PodIP, the ECS container metadata and then
+// the network interfaces.
+type AdvertiseDetector struct {
+	// Host is the host to advertise, it defaults to the
+	// CASBIN_MESH_ADVERTISE_HOST environment variable.
+	Host string
+	// PodIP is the IP of the Kubernetes pod, it defaults to the POD_IP
+	// environment variable, usually set through the downward API.
+	PodIP string
+	// ECSMetadataURI is the container metadata endpoint of an ECS task, it
+	// defaults to the ECS_CONTAINER_METADATA_URI_V4 environment variable.
+	ECSMetadataURI string
+	// InterfaceAddrs lists the addresses of the network interfaces.
+	InterfaceAddrs func() ([]net.Addr, error)
+	client         *http.Client
+}
+
+// NewAdvertiseDetector returns an AdvertiseDetector configured from the
+// environment.
+func NewAdvertiseDetector() *AdvertiseDetector {
+	return &AdvertiseDetector{
+		Host:           os.Getenv("CASBIN_MESH_ADVERTISE_HOST"),
+		PodIP:          os.Getenv("POD_IP"),
+		ECSMetadataURI: os.Getenv("ECS_CONTAINER_METADATA_URI_V4"),
+		InterfaceAddrs: net.InterfaceAddrs,
+		client:         &http.Client{Timeout: 5 * time.Second},
+	}
+}
+
+// Detect returns the address to advertise for the bind address. Bind
+// addresses with a specified host are returned as is.
+func (d *AdvertiseDetector) Detect(ctx context.Context, bind string) (string, error) {
+	host, port, err := net.SplitHostPort(bind)
+	if err != nil {
+		return "", err
+	}
+	if !unspecifiedHost(host) {
+		return bind, nil
+	}
+	if d.Host != "" {
+		return net.JoinHostPort(d.Host, port), nil
+	}
+	if d.PodIP != "" {
+		return net.JoinHostPort(d.PodIP, port), nil
+	}
+	if d.ECSMetadataURI != "" {
+		ip, err := d.ecsIP(ctx)
+		if err != nil {
+			return "", fmt.Errorf("failed to read ECS container metadata: %s", err)
+		}
+		return net.JoinHostPort(ip, port), nil
+	}
+	ip, err := d.interfaceIP()
+	if err != nil {
+		return "", err
+	}
+	return net.JoinHostPort(ip, port), nil
+}
+
+// ecsIP returns the first IPv4 address of the container in its ECS task.
+func (d *AdvertiseDetector) ecsIP(ctx context.Context) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, d.ECSMetadataURI, nil)
+	if err != nil {
+		return "", err
+	}
+	client := d.client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	var md struct {
+		Networks []struct {
+			IPv4Addresses []string
+		}
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&md); err != nil {
+		return "", err
+	}
+	for _, n := range md.Networks {
+		if len(n.IPv4Addresses) > 0 {
+			return n.IPv4Addresses[0], nil
+		}
+	}
+	return "", ErrNoAdvertiseAddr
+}
+
+// interfaceIP returns the first global unicast address of the network
+// interfaces, preferring IPv4.
+func (d *AdvertiseDetector) interfaceIP() (string, error) {
+	list := d.InterfaceAddrs
+	if list == nil {
+		list = net.InterfaceAddrs
+	}
+	addrs, err := list()
+	if err != nil {
+		return "", err
+	}
+	var v6 net.IP
+	for _, a := range addrs {
+		ipNet, ok := a.(*net.IPNet)
+		if !ok || !ipNet.IP.IsGlobalUnicast() {
+			continue
+		}
+		if ipNet.IP.To4() != nil {
+			return ipNet.IP.String(), nil
+		}
+		if v6 == nil {
+			v6 = ipNet.IP
+		}
+	}
+	if v6 == nil {
+		return "", ErrNoAdvertiseAddr
+	}
+	return v6.String(), nil
+}
+
+// CheckAdvertiseAddr returns ErrUnroutableAddr if other nodes can't reach a
+// node advertising addr: its host is unspecified, or it is a loopback
+// address while some of the join addresses are not.
+func CheckAdvertiseAddr(addr string, joins []string) error {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return err
+	}
+	if unspecifiedHost(host) {
+		return fmt.Errorf("%w, advertise address %s has no host", ErrUnroutableAddr, addr)
+	}
+	if !loopbackHost(host) {
+		return nil
+	}
+	for _, j := range joins {
+		if h := joinHost(j); h != "" && !loopbackHost(h) {
+			return fmt.Errorf("%w, advertise address %s is loopback but join address %s is not", ErrUnroutableAddr, addr, j)
+		}
+	}
+	return nil
+}
+
+// InContainer reports whether the process runs in a container.
+func InContainer() bool {
+	if _, err := os.Stat("/.dockerenv"); err == nil {
+		return true
+	}
+	for _, env := range []string{"KUBERNETES_SERVICE_HOST", "ECS_CONTAINER_METADATA_URI_V4", "container"} {
+		if os.Getenv(env) != "" {
+			return true
+		}
+	}
+	return false
+}
+
+func unspecifiedHost(host string) bool {
+	if host == "" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsUnspecified()
+}
+
+func loopbackHost(host string) bool {
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// joinHost returns the host of a join address, with or without scheme.
+func joinHost(addr string) string {
+	if !strings.Contains(addr, "://") {
+		addr = "http://" + addr
+	}
+	u, err := url.Parse(addr)
+	if err != nil {
+		return ""
+	}
+	return u.Hostname()
+}
@@ -0,0 +1,100 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package cluster
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func interfaceAddrs(cidrs ...string) func() ([]net.Addr, error) {
+	return func() ([]net.Addr, error) {
+		var addrs []net.Addr
+		for _, c := range cidrs {
+			ip, ipNet, err := net.ParseCIDR(c)
+			if err != nil {
+				return nil, err
+			}
+			ipNet.IP = ip
+			addrs = append(addrs, ipNet)
+		}
+		return addrs, nil
+	}
+}
+
+func Test_AdvertiseDetect(t *testing.T) {
+	ecs := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"Networks":[{"NetworkMode":"awsvpc","IPv4Addresses":["10.0.2.106"]}]}`))
+	}))
+	defer ecs.Close()
+	ifaces := interfaceAddrs("127.0.0.1/8", "fe80::1/64", "2001:db8::5/64", "172.17.0.2/16")
+
+	for _, tt := range []struct {
+		name string
+		d    AdvertiseDetector
+		bind string
+		exp  string
+	}{
+		{"specified", AdvertiseDetector{Host: "node0"}, "10.0.0.1:4002", "10.0.0.1:4002"},
+		{"host", AdvertiseDetector{Host: "node0", PodIP: "10.1.0.3"}, "0.0.0.0:4002", "node0:4002"},
+		{"pod", AdvertiseDetector{PodIP: "10.1.0.3", InterfaceAddrs: ifaces}, ":4002", "10.1.0.3:4002"},
+		{"ecs", AdvertiseDetector{ECSMetadataURI: ecs.URL, InterfaceAddrs: ifaces}, "0.0.0.0:4002", "10.0.2.106:4002"},
+		{"interfaces", AdvertiseDetector{InterfaceAddrs: ifaces}, "[::]:4002", "172.17.0.2:4002"},
+		{"ipv6", AdvertiseDetector{InterfaceAddrs: interfaceAddrs("127.0.0.1/8", "2001:db8::5/64")}, "0.0.0.0:4002", "[2001:db8::5]:4002"},
+	} {
+		addr, err := tt.d.Detect(context.Background(), tt.bind)
+		if err != nil {
+			t.Fatalf("%s: failed to detect address: %s", tt.name, err.Error())
+		}
+		if addr != tt.exp {
+			t.Fatalf("%s: wrong address, exp %s, got %s", tt.name, tt.exp, addr)
+		}
+	}
+
+	d := AdvertiseDetector{InterfaceAddrs: interfaceAddrs("127.0.0.1/8", "fe80::1/64")}
+	if _, err := d.Detect(context.Background(), "0.0.0.0:4002"); !errors.Is(err, ErrNoAdvertiseAddr) {
+		t.Fatalf("expected no address, got %v", err)
+	}
+}
+
+func Test_CheckAdvertiseAddr(t *testing.T) {
+	for _, tt := range []struct {
+		addr  string
+		joins []string
+		ok    bool
+	}{
+		{"10.0.0.1:4002", []string{"http://10.0.0.2:4002"}, true},
+		{"0.0.0.0:4002", nil, false},
+		{":4002", nil, false},
+		{"localhost:4002", nil, true},
+		{"localhost:4004", []string{"http://localhost:4002", "127.0.0.1:4006"}, true},
+		{"127.0.0.1:4004", []string{"http://node0:4002"}, false},
+	} {
+		err := CheckAdvertiseAddr(tt.addr, tt.joins)
+		if tt.ok && err != nil {
+			t.Fatalf("%s with joins %v refused: %s", tt.addr, tt.joins, err.Error())
+		}
+		if !tt.ok && !errors.Is(err, ErrUnroutableAddr) {
+			t.Fatalf("%s with joins %v not refused: %v", tt.addr, tt.joins, err)
+		}
+	}
+}
@@ -0,0 +1,148 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package cluster
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"strings"
+	"sync"
+)
+
+// AllowList restricts the hosts allowed to connect to the cluster port to
+// a set of networks. Connections from other hosts are closed as soon as
+// they are accepted, before anything is read from them. An empty AllowList
+// allows every host. Loopback connections are only allowed if listed, or
+// once SetLoopback allows them all.
+type AllowList struct {
+	mu       sync.RWMutex
+	nets     []*net.IPNet
+	loopback bool
+}
+
+// NewAllowList returns an AllowList allowing every host.
+func NewAllowList() *AllowList {
+	return &AllowList{}
+}
+
+// ParseAllowList returns an AllowList of the networks of spec.
+func ParseAllowList(spec string) (*AllowList, error) {
+	a := NewAllowList()
+	if err := a.Set(spec); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// Set replaces the networks allowed by a with those of spec, a
+// comma-separated list of CIDRs or IP addresses. An empty spec allows every
+// host.
+func (a *AllowList) Set(spec string) error {
+	var nets []*net.IPNet
+	for _, s := range strings.Split(spec, ",") {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		if !strings.Contains(s, "/") {
+			ip := net.ParseIP(s)
+			if ip == nil {
+				return fmt.Errorf("invalid address %q", s)
+			}
+			bits := 8 * net.IPv6len
+			if ip4 := ip.To4(); ip4 != nil {
+				ip, bits = ip4, 8*net.IPv4len
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, n, err := net.ParseCIDR(s)
+		if err != nil {
+			return fmt.Errorf("invalid network %q", s)
+		}
+		nets = append(nets, n)
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.nets = nets
+	return nil
+}
+
+// SetLoopback sets whether a allows every loopback connection, besides its
+// networks, like those of tools running on the host of the node.
+func (a *AllowList) SetLoopback(allow bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.loopback = allow
+}
+
+// String returns the networks allowed by a, comma-separated.
+func (a *AllowList) String() string {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	s := make([]string, len(a.nets))
+	for i, n := range a.nets {
+		s[i] = n.String()
+	}
+	return strings.Join(s, ",")
+}
+
+// Allowed returns whether the host of addr may connect.
+func (a *AllowList) Allowed(addr net.Addr) bool {
+	tcp, ok := addr.(*net.TCPAddr)
+	if !ok {
+		return false
+	}
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	if len(a.nets) == 0 || a.loopback && tcp.IP.IsLoopback() {
+		return true
+	}
+	for _, n := range a.nets {
+		if n.Contains(tcp.IP) {
+			return true
+		}
+	}
+	return false
+}
+
+// Listener returns a listener accepting the connections of ln allowed by a.
+func (a *AllowList) Listener(ln net.Listener) net.Listener {
+	return &allowListener{Listener: ln, allow: a}
+}
+
+// allowListener closes the connections its AllowList does not allow.
+type allowListener struct {
+	net.Listener
+	allow *AllowList
+}
+
+func (l *allowListener) Accept() (net.Conn, error) {
+	for {
+		c, err := l.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+		if l.allow.Allowed(c.RemoteAddr()) {
+			return c, nil
+		}
+		log.Printf("refusing connection from %s, not in the allow-list", c.RemoteAddr())
+		_ = c.Close()
+	}
+}
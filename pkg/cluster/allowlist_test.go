@@ -0,0 +1,119 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package cluster
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAllowList(t *testing.T) {
+	a, err := ParseAllowList("10.0.0.0/8, 192.168.1.7,fd00::/8")
+	assert.NoError(t, err)
+	assert.Equal(t, "10.0.0.0/8,192.168.1.7/32,fd00::/8", a.String())
+
+	allowed := func(ip string) bool {
+		return a.Allowed(&net.TCPAddr{IP: net.ParseIP(ip), Port: 4002})
+	}
+	assert.True(t, allowed("10.1.2.3"))
+	assert.True(t, allowed("192.168.1.7"))
+	assert.True(t, allowed("fd12::1"))
+	assert.False(t, allowed("192.168.1.8"))
+	assert.False(t, allowed("172.16.0.1"))
+
+	// Loopback connections are only allowed on demand.
+	assert.False(t, allowed("127.0.0.1"))
+	assert.False(t, allowed("::1"))
+	a.SetLoopback(true)
+	assert.True(t, allowed("127.0.0.1"))
+	assert.True(t, allowed("::1"))
+	assert.False(t, allowed("172.16.0.1"))
+	a.SetLoopback(false)
+	assert.False(t, allowed("127.0.0.1"))
+	assert.NoError(t, a.Set("10.0.0.0/8,127.0.0.1"))
+	assert.True(t, allowed("127.0.0.1"))
+
+	assert.NoError(t, a.Set(""))
+	assert.True(t, allowed("172.16.0.1"))
+
+	for _, spec := range []string{"10.0.0.0/33", "node1", "10.0.0"} {
+		_, err := ParseAllowList(spec)
+		assert.Error(t, err, spec)
+	}
+}
+
+// addrConn is a connection from addr.
+type addrConn struct {
+	net.Conn
+	addr   net.Addr
+	closed bool
+}
+
+func (c *addrConn) RemoteAddr() net.Addr { return c.addr }
+
+func (c *addrConn) Close() error {
+	c.closed = true
+	return nil
+}
+
+// connListener accepts the connections of conns.
+type connListener struct {
+	net.Listener
+	conns []net.Conn
+}
+
+func (l *connListener) Accept() (net.Conn, error) {
+	c := l.conns[0]
+	l.conns = l.conns[1:]
+	return c, nil
+}
+
+func TestAllowListListener(t *testing.T) {
+	a, err := ParseAllowList("10.0.0.0/8")
+	assert.NoError(t, err)
+	refused := &addrConn{addr: &net.TCPAddr{IP: net.ParseIP("172.16.0.1")}}
+	allowed := &addrConn{addr: &net.TCPAddr{IP: net.ParseIP("10.0.0.1")}}
+	local := &addrConn{addr: &net.TCPAddr{IP: net.ParseIP("127.0.0.1")}}
+	l := a.Listener(&connListener{conns: []net.Conn{refused, allowed, local, local}})
+
+	c, err := l.Accept()
+	assert.NoError(t, err)
+	assert.Equal(t, allowed, c)
+	assert.True(t, refused.closed)
+	// Loopback connections are refused unless allowed.
+	a.SetLoopback(true)
+	c, err = l.Accept()
+	assert.NoError(t, err)
+	assert.Equal(t, local, c)
+	assert.False(t, local.closed)
+}
+
+func TestAllowListLoopbackRefused(t *testing.T) {
+	a, err := ParseAllowList("10.0.0.0/8")
+	assert.NoError(t, err)
+	local := &addrConn{addr: &net.TCPAddr{IP: net.ParseIP("127.0.0.1")}}
+	allowed := &addrConn{addr: &net.TCPAddr{IP: net.ParseIP("10.0.0.1")}}
+	l := a.Listener(&connListener{conns: []net.Conn{local, allowed}})
+
+	c, err := l.Accept()
+	assert.NoError(t, err)
+	assert.Equal(t, allowed, c)
+	assert.True(t, local.closed)
+}
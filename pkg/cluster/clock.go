@@ -0,0 +1,85 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package cluster
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/casbin/casbin-mesh/pkg/auth"
+	"github.com/casbin/casbin-mesh/pkg/utils"
+)
+
+const (
+	// NodeIDHeader identifies the node answering an API request.
+	NodeIDHeader = "X-Node-Id"
+	// NodeTimeHeader is the time, in unix nanoseconds, of the clock of the
+	// node answering an API request, so that nodes compare their clocks
+	// over the requests they forward each other.
+	NodeTimeHeader = "X-Node-Time"
+)
+
+// ClockSample is a reading of the clock of a node, in a response to a
+// request sent and received at Sent and Received by the local clock.
+type ClockSample struct {
+	NodeID   string
+	Sent     time.Time
+	Received time.Time
+	Remote   time.Time
+}
+
+// ParseClock returns the reading of the clock of the node which answered
+// with h, and whether h has one.
+func ParseClock(h http.Header, sent, received time.Time) (ClockSample, bool) {
+	id := h.Get(NodeIDHeader)
+	ns, err := strconv.ParseInt(h.Get(NodeTimeHeader), 10, 64)
+	if id == "" || err != nil {
+		return ClockSample{}, false
+	}
+	return ClockSample{NodeID: id, Sent: sent, Received: received, Remote: time.Unix(0, ns)}, true
+}
+
+// ReadClock reads the clock of the node at apiAddr.
+func ReadClock(apiAddr string, tlsConfig *tls.Config, authConfig auth.AuthConfig) (ClockSample, error) {
+	tr := &http.Transport{TLSClientConfig: tlsConfig}
+	defer tr.CloseIdleConnections()
+	client := &http.Client{Transport: tr, Timeout: 5 * time.Second}
+	req, err := http.NewRequest(http.MethodGet, utils.NormalizeAddr(apiAddr+"/leader"), nil)
+	if err != nil {
+		return ClockSample{}, err
+	}
+	switch authConfig.AuthType {
+	case auth.Basic:
+		req.SetBasicAuth(authConfig.Username, authConfig.Password)
+	}
+	sent := time.Now()
+	resp, err := client.Do(req)
+	if err != nil {
+		return ClockSample{}, err
+	}
+	received := time.Now()
+	resp.Body.Close()
+	sample, ok := ParseClock(resp.Header, sent, received)
+	if !ok {
+		return ClockSample{}, fmt.Errorf("node at %s did not report its clock", apiAddr)
+	}
+	return sample, nil
+}
@@ -0,0 +1,55 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package cluster
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/casbin/casbin-mesh/pkg/auth"
+)
+
+func Test_ReadClock(t *testing.T) {
+	ahead := 2 * time.Second
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if u, p, _ := r.BasicAuth(); u != "root" || p != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+		w.Header().Set(NodeIDHeader, "node1")
+		w.Header().Set(NodeTimeHeader, strconv.FormatInt(time.Now().Add(ahead).UnixNano(), 10))
+	}))
+	defer ts.Close()
+
+	sample, err := ReadClock(ts.URL, nil, auth.AuthConfig{AuthType: auth.Basic, Username: "root", Password: "secret"})
+	if err != nil {
+		t.Fatalf("failed to read clock: %s", err)
+	}
+	if sample.NodeID != "node1" {
+		t.Fatalf("unexpected node %q", sample.NodeID)
+	}
+	if offset := sample.Remote.Sub(sample.Sent); offset < ahead || offset > ahead+time.Second {
+		t.Fatalf("expected the remote clock %s ahead, got %s", ahead, offset)
+	}
+
+	if _, ok := ParseClock(http.Header{}, time.Now(), time.Now()); ok {
+		t.Fatalf("expected no clock without headers")
+	}
+}
@@ -42,9 +42,9 @@ var (
 
 // Join attempts to join the cluster at one of the addresses given in joinAddr.
 // It walks through joinAddr in order, and sets the node ID and Raft address of
-// the joining node as id addr respectively. It returns the endpoint successfully
-// used to join the cluster.
-func Join(srcIP string, joinAddr []string, id, addr string, voter bool, meta map[string]string, numAttempts int,
+// the joining node as id addr respectively, presenting token unless empty. It
+// returns the endpoint successfully used to join the cluster.
+func Join(srcIP string, joinAddr []string, id, addr string, voter bool, meta map[string]string, token string, numAttempts int,
 	attemptInterval time.Duration, tlsConfig *tls.Config, authConfig auth.AuthConfig) (string, error) {
 	var err error
 	var j string
@@ -55,7 +55,7 @@ func Join(srcIP string, joinAddr []string, id, addr string, voter bool, meta map
 
 	for i := 0; i < numAttempts; i++ {
 		for _, a := range joinAddr {
-			j, err = join(srcIP, a, id, addr, voter, meta, tlsConfig, logger, authConfig)
+			j, err = join(srcIP, a, id, addr, voter, meta, token, tlsConfig, logger, authConfig)
 			if err == nil {
 				// Success!
 				return j, nil
@@ -68,7 +68,7 @@ func Join(srcIP string, joinAddr []string, id, addr string, voter bool, meta map
 	return "", ErrJoinFailed
 }
 
-func join(srcIP, joinAddr, id, addr string, voter bool, meta map[string]string, tlsConfig *tls.Config, logger *log.Logger, authConfig auth.AuthConfig) (string, error) {
+func join(srcIP, joinAddr, id, addr string, voter bool, meta map[string]string, token string, tlsConfig *tls.Config, logger *log.Logger, authConfig auth.AuthConfig) (string, error) {
 	if id == "" {
 		return "", fmt.Errorf("node ID not set")
 	}
@@ -94,7 +94,7 @@ func join(srcIP, joinAddr, id, addr string, voter bool, meta map[string]string,
 	// Create and configure the client to connect to the other node.
 	tr := &http.Transport{
 		TLSClientConfig: tlsConfig,
-		Dial:            dialer.Dial,
+		DialContext:     dialer.DialContext,
 	}
 	client := &http.Client{Transport: tr}
 	client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
@@ -102,12 +102,16 @@ func join(srcIP, joinAddr, id, addr string, voter bool, meta map[string]string,
 	}
 
 	for {
-		b, err := json.Marshal(map[string]interface{}{
-			"id":    id,
-			"addr":  addr,
-			"voter": voter,
-			"meta":  meta,
-		})
+		body := map[string]interface{}{
+			"id":       id,
+			"addr":     addr,
+			"voter":    voter,
+			"metadata": meta,
+		}
+		if token != "" {
+			body["token"] = token
+		}
+		b, err := json.Marshal(body)
 		if err != nil {
 			return "", err
 		}
@@ -53,7 +53,7 @@ func Test_SingleJoinOK(t *testing.T) {
 
 	defer ts.Close()
 
-	j, err := Join("127.0.0.1", []string{ts.URL}, "id0", "127.0.0.1:9090", false, nil,
+	j, err := Join("127.0.0.1", []string{ts.URL}, "id0", "127.0.0.1:9090", false, nil, "",
 		numAttempts, attemptInterval, nil, auth.AuthConfig{})
 	if err != nil {
 		t.Fatalf("failed to join a single node: %s", err.Error())
@@ -78,7 +78,7 @@ func Test_SingleJoinZeroAttempts(t *testing.T) {
 		t.Fatalf("handler should not have been called")
 	}))
 
-	_, err := Join("127.0.0.1", []string{ts.URL}, "id0", "127.0.0.1:9090", false, nil, 0, attemptInterval, nil, auth.AuthConfig{})
+	_, err := Join("127.0.0.1", []string{ts.URL}, "id0", "127.0.0.1:9090", false, nil, "", 0, attemptInterval, nil, auth.AuthConfig{})
 	if err != ErrJoinFailed {
 		t.Fatalf("Incorrect error returned when zero attempts specified")
 	}
@@ -108,7 +108,7 @@ func Test_SingleJoinMetaOK(t *testing.T) {
 
 	nodeAddr := "127.0.0.1:9090"
 	md := map[string]string{"foo": "bar"}
-	j, err := Join("", []string{ts.URL}, "id0", nodeAddr, true, md,
+	j, err := Join("", []string{ts.URL}, "id0", nodeAddr, true, md, "token",
 		numAttempts, attemptInterval, nil, auth.AuthConfig{})
 	if err != nil {
 		t.Fatalf("failed to join a single node: %s", err.Error())
@@ -123,7 +123,10 @@ func Test_SingleJoinMetaOK(t *testing.T) {
 	if addr, _ := body["addr"]; addr != nodeAddr {
 		t.Fatalf("node joined supplying wrong address, exp %s, got %s", nodeAddr, body["addr"])
 	}
-	rxMd, _ := body["meta"].(map[string]interface{})
+	if token, _ := body["token"]; token != "token" {
+		t.Fatalf("node joined supplying wrong token, got %s", body["token"])
+	}
+	rxMd, _ := body["metadata"].(map[string]interface{})
 	if len(rxMd) != len(md) || rxMd["foo"] != "bar" {
 		t.Fatalf("node joined supplying wrong meta")
 	}
@@ -135,7 +138,7 @@ func Test_SingleJoinFail(t *testing.T) {
 	}))
 	defer ts.Close()
 
-	_, err := Join("", []string{ts.URL}, "id0", "127.0.0.1:9090", true, nil,
+	_, err := Join("", []string{ts.URL}, "id0", "127.0.0.1:9090", true, nil, "",
 		numAttempts, attemptInterval, nil, auth.AuthConfig{})
 	if err == nil {
 		t.Fatalf("expected error when joining bad node")
@@ -150,7 +153,7 @@ func Test_DoubleJoinOK(t *testing.T) {
 	}))
 	defer ts2.Close()
 
-	j, err := Join("127.0.0.1", []string{ts1.URL, ts2.URL}, "id0", "127.0.0.1:9090", true, nil,
+	j, err := Join("127.0.0.1", []string{ts1.URL, ts2.URL}, "id0", "127.0.0.1:9090", true, nil, "",
 		numAttempts, attemptInterval, nil, auth.AuthConfig{})
 	if err != nil {
 		t.Fatalf("failed to join a single node: %s", err.Error())
@@ -169,7 +172,7 @@ func Test_DoubleJoinOKSecondNode(t *testing.T) {
 	}))
 	defer ts2.Close()
 
-	j, err := Join("", []string{ts1.URL, ts2.URL}, "id0", "127.0.0.1:9090", true, nil,
+	j, err := Join("", []string{ts1.URL, ts2.URL}, "id0", "127.0.0.1:9090", true, nil, "",
 		numAttempts, attemptInterval, nil, auth.AuthConfig{})
 	if err != nil {
 		t.Fatalf("failed to join a single node: %s", err.Error())
@@ -190,7 +193,7 @@ func Test_DoubleJoinOKSecondNodeRedirect(t *testing.T) {
 	}))
 	defer ts2.Close()
 
-	j, err := Join("127.0.0.1", []string{ts2.URL}, "id0", "127.0.0.1:9090", true, nil,
+	j, err := Join("127.0.0.1", []string{ts2.URL}, "id0", "127.0.0.1:9090", true, nil, "",
 		numAttempts, attemptInterval, nil, auth.AuthConfig{})
 	if err != nil {
 		t.Fatalf("failed to join a single node: %s", err.Error())
@@ -0,0 +1,233 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package cluster
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	serviceAccountDir = "/var/run/secrets/kubernetes.io/serviceaccount"
+)
+
+var (
+	// ErrNotStatefulSetPod is returned when the pod name doesn't end with an
+	// ordinal, as pods of a StatefulSet do.
+	ErrNotStatefulSetPod = errors.New("pod name has no StatefulSet ordinal")
+)
+
+// KubernetesDiscovery determines the peers of a node running as a pod of a
+// StatefulSet. Peers are listed through the Kubernetes API when LabelSelector
+// is set, otherwise they are derived from Replicas and the DNS names the
+// headless Service gives to the pods.
+type KubernetesDiscovery struct {
+	// PodName and Namespace identify this pod, they default to the POD_NAME
+	// (or HOSTNAME) and POD_NAMESPACE environment variables, which are
+	// usually set through the downward API.
+	PodName   string
+	Namespace string
+	// Service is the headless Service governing the StatefulSet. If set,
+	// peers are addressed by their stable DNS names instead of pod IPs.
+	Service       string
+	LabelSelector string
+	Replicas      int
+	// Port is the port peers serve Raft and the API on.
+	Port string
+
+	// APIServer is the URL of the Kubernetes API, in-cluster by default.
+	APIServer string
+	client    *http.Client
+	token     string
+}
+
+// NewKubernetesDiscovery returns a KubernetesDiscovery with the pod identity
+// and the API access taken from the environment of the pod.
+func NewKubernetesDiscovery(service, labelSelector string, replicas int, port string) (*KubernetesDiscovery, error) {
+	d := &KubernetesDiscovery{
+		PodName:       os.Getenv("POD_NAME"),
+		Namespace:     os.Getenv("POD_NAMESPACE"),
+		Service:       service,
+		LabelSelector: labelSelector,
+		Replicas:      replicas,
+		Port:          port,
+	}
+	if d.PodName == "" {
+		d.PodName, _ = os.Hostname()
+	}
+	if d.Namespace == "" {
+		if b, err := ioutil.ReadFile(serviceAccountDir + "/namespace"); err == nil {
+			d.Namespace = strings.TrimSpace(string(b))
+		} else {
+			d.Namespace = "default"
+		}
+	}
+	if labelSelector == "" {
+		if service == "" || replicas <= 0 {
+			return nil, errors.New("either a label selector or a service and the number of replicas are required")
+		}
+		return d, nil
+	}
+
+	host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+	if host == "" {
+		return nil, errors.New("not running in a Kubernetes cluster, KUBERNETES_SERVICE_HOST is not set")
+	}
+	d.APIServer = "https://" + net.JoinHostPort(host, port)
+	token, err := ioutil.ReadFile(serviceAccountDir + "/token")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read service account token: %s", err)
+	}
+	d.token = strings.TrimSpace(string(token))
+	ca, err := ioutil.ReadFile(serviceAccountDir + "/ca.crt")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read service account CA: %s", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(ca) {
+		return nil, errors.New("failed to parse service account CA")
+	}
+	d.client = &http.Client{
+		Timeout:   10 * time.Second,
+		Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}},
+	}
+	return d, nil
+}
+
+// Ordinal returns the ordinal of this pod within the StatefulSet.
+func (d *KubernetesDiscovery) Ordinal() (int, error) {
+	i := strings.LastIndex(d.PodName, "-")
+	if i < 0 {
+		return 0, ErrNotStatefulSetPod
+	}
+	n, err := strconv.Atoi(d.PodName[i+1:])
+	if err != nil || n < 0 {
+		return 0, ErrNotStatefulSetPod
+	}
+	return n, nil
+}
+
+// Bootstrap reports whether this pod bootstraps the cluster, which is done by
+// the pod with ordinal 0.
+func (d *KubernetesDiscovery) Bootstrap() (bool, error) {
+	n, err := d.Ordinal()
+	return n == 0, err
+}
+
+// addr returns the address of the pod with the given name and IP.
+func (d *KubernetesDiscovery) addr(name, ip string) string {
+	if d.Service != "" {
+		return net.JoinHostPort(fmt.Sprintf("%s.%s.%s.svc", name, d.Service, d.Namespace), d.Port)
+	}
+	if ip == "" {
+		return ""
+	}
+	return net.JoinHostPort(ip, d.Port)
+}
+
+// AdvertiseAddr returns the stable address of this pod, empty if no Service
+// is set.
+func (d *KubernetesDiscovery) AdvertiseAddr() string {
+	if d.Service == "" {
+		return ""
+	}
+	return d.addr(d.PodName, "")
+}
+
+// Peers returns the addresses of the other pods of the StatefulSet.
+func (d *KubernetesDiscovery) Peers(ctx context.Context) ([]string, error) {
+	if d.LabelSelector == "" {
+		prefix := d.PodName[:strings.LastIndex(d.PodName, "-")+1]
+		var peers []string
+		for i := 0; i < d.Replicas; i++ {
+			if name := prefix + strconv.Itoa(i); name != d.PodName {
+				peers = append(peers, d.addr(name, ""))
+			}
+		}
+		return peers, nil
+	}
+
+	pods, err := d.listPods(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var peers []string
+	for _, p := range pods {
+		if p.Metadata.Name == d.PodName {
+			continue
+		}
+		if a := d.addr(p.Metadata.Name, p.Status.PodIP); a != "" {
+			peers = append(peers, a)
+		}
+	}
+	sort.Strings(peers)
+	return peers, nil
+}
+
+type pod struct {
+	Metadata struct {
+		Name string `json:"name"`
+	} `json:"metadata"`
+	Status struct {
+		PodIP string `json:"podIP"`
+	} `json:"status"`
+}
+
+func (d *KubernetesDiscovery) listPods(ctx context.Context) ([]pod, error) {
+	u := fmt.Sprintf("%s/api/v1/namespaces/%s/pods?labelSelector=%s",
+		strings.TrimSuffix(d.APIServer, "/"), url.PathEscape(d.Namespace), url.QueryEscape(d.LabelSelector))
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	if d.token != "" {
+		req.Header.Set("Authorization", "Bearer "+d.token)
+	}
+	client := d.client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to list pods: %s", resp.Status)
+	}
+	var pods struct {
+		Items []pod `json:"items"`
+	}
+	if err = json.NewDecoder(resp.Body).Decode(&pods); err != nil {
+		return nil, err
+	}
+	return pods.Items, nil
+}
@@ -0,0 +1,84 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package cluster
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func Test_KubernetesOrdinal(t *testing.T) {
+	for name, exp := range map[string]int{"casmesh-0": 0, "casmesh-12": 12, "my-casmesh-3": 3} {
+		d := &KubernetesDiscovery{PodName: name}
+		n, err := d.Ordinal()
+		if err != nil {
+			t.Fatalf("failed to get ordinal of %s: %s", name, err.Error())
+		}
+		if n != exp {
+			t.Fatalf("wrong ordinal for %s, exp %d, got %d", name, exp, n)
+		}
+	}
+	for _, name := range []string{"casmesh", "casmesh-abc"} {
+		d := &KubernetesDiscovery{PodName: name}
+		if _, err := d.Ordinal(); err != ErrNotStatefulSetPod {
+			t.Fatalf("expected ErrNotStatefulSetPod for %s, got %v", name, err)
+		}
+	}
+}
+
+func Test_KubernetesPeersFromReplicas(t *testing.T) {
+	d := &KubernetesDiscovery{PodName: "casmesh-1", Namespace: "auth", Service: "casmesh", Replicas: 3, Port: "4002"}
+	peers, err := d.Peers(context.Background())
+	if err != nil {
+		t.Fatalf("failed to get peers: %s", err.Error())
+	}
+	exp := []string{"casmesh-0.casmesh.auth.svc:4002", "casmesh-2.casmesh.auth.svc:4002"}
+	if !reflect.DeepEqual(peers, exp) {
+		t.Fatalf("wrong peers, exp %v, got %v", exp, peers)
+	}
+	if got := d.AdvertiseAddr(); got != "casmesh-1.casmesh.auth.svc:4002" {
+		t.Fatalf("wrong advertise address: %s", got)
+	}
+}
+
+func Test_KubernetesPeersFromAPI(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v1/namespaces/auth/pods" || r.URL.Query().Get("labelSelector") != "app=casmesh" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write([]byte(`{"items":[
+			{"metadata":{"name":"casmesh-0"},"status":{"podIP":"10.0.0.1"}},
+			{"metadata":{"name":"casmesh-1"},"status":{"podIP":"10.0.0.2"}},
+			{"metadata":{"name":"casmesh-2"},"status":{}}
+		]}`))
+	}))
+	defer ts.Close()
+
+	d := &KubernetesDiscovery{PodName: "casmesh-1", Namespace: "auth", LabelSelector: "app=casmesh", Port: "4002", APIServer: ts.URL}
+	peers, err := d.Peers(context.Background())
+	if err != nil {
+		t.Fatalf("failed to get peers: %s", err.Error())
+	}
+	if exp := []string{"10.0.0.1:4002"}; !reflect.DeepEqual(peers, exp) {
+		t.Fatalf("wrong peers, exp %v, got %v", exp, peers)
+	}
+}
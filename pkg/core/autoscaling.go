@@ -0,0 +1,47 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package core
+
+import (
+	http2 "net/http"
+
+	"github.com/casbin/casbin-mesh/pkg/handler/http"
+	"github.com/casbin/casbin-mesh/pkg/store"
+)
+
+// AutoscalingSignals are the load signals of a node, for autoscalers like
+// KEDA or the HPA through an external metrics adapter.
+type AutoscalingSignals struct {
+	NodeID string `json:"node_id"`
+	store.LoadSignals
+	// EnforceInFlight and EnforceQueued are the enforce requests served and
+	// waiting, if they are limited.
+	EnforceInFlight int `json:"enforce_in_flight"`
+	EnforceQueued   int `json:"enforce_queued"`
+}
+
+// handleAutoscalingSignals serves the load of this node. It is not forwarded
+// to the leader, each node reports its own.
+func (s *httpService) handleAutoscalingSignals(ctx *http.Context) error {
+	out := AutoscalingSignals{NodeID: s.NodeID(), LoadSignals: s.LoadSignals(ctx.Request.Context())}
+	if s.limits != nil {
+		st := s.limits.Enforce.Stats()
+		out.EnforceInFlight, out.EnforceQueued = st.InFlight, st.Queued
+	}
+	return ctx.StatusCode(http2.StatusOK).JSON(out)
+}
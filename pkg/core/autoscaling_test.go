@@ -0,0 +1,58 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package core_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/casbin/casbin-mesh/pkg/core"
+	"github.com/casbin/casbin-mesh/pkg/limit"
+)
+
+func Test_AutoscalingSignals(t *testing.T) {
+	_, node := newTestServer(t)
+	limits := &core.Limits{Enforce: limit.New(limit.Config{Concurrency: 2})}
+	srv := core.NewHttpService(node.Core, nil)
+	srv.EnableLimits(limits)
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+
+	release, err := limits.Enforce.Acquire(context.TODO())
+	if err != nil {
+		t.Fatalf("failed to acquire: %s", err.Error())
+	}
+	defer release()
+
+	var out core.AutoscalingSignals
+	if resp := doJSON(t, http.MethodGet, ts.URL+"/autoscaling/signals", "", &out); resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected the signals, got %d", resp.StatusCode)
+	}
+	if out.NodeID != node.ID {
+		t.Fatalf("expected the signals of %s, got %s", node.ID, out.NodeID)
+	}
+	// rates are those of the last complete window, none is over yet
+	if out.Window <= 0 || out.EnforceRate != 0 {
+		t.Fatalf("expected no enforce rate before the first window, got %+v", out.LoadSignals)
+	}
+	if out.EnforceInFlight != 1 || out.EnforceQueued != 0 {
+		t.Fatalf("expected one enforce in flight, got %d in flight and %d queued", out.EnforceInFlight, out.EnforceQueued)
+	}
+}
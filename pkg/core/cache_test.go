@@ -0,0 +1,84 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package core_test
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/casbin/casbin-mesh/pkg/core"
+)
+
+func Test_DecisionCacheHints(t *testing.T) {
+	ts, _ := newTestServer(t)
+
+	enforce := `{"ns":"default","params":["alice","data1","read"]}`
+	var before core.EnforceReply
+	if resp := doJSON(t, http.MethodPost, ts.URL+"/enforce", enforce, &before); resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected enforce served, got %d", resp.StatusCode)
+	}
+	if before.Cache == nil || before.Cache.Version == 0 || before.Cache.TTL != 10 {
+		t.Fatalf("expected cache hints with a version and the default TTL, got %+v", before.Cache)
+	}
+
+	add := `{"ns":"default","sec":"p","ptype":"p","rules":[["bob","data2","write"]]}`
+	if resp := doJSON(t, http.MethodPost, ts.URL+"/add/policies", add, nil); resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected the write served, got %d", resp.StatusCode)
+	}
+	var after core.EnforceReply
+	doJSON(t, http.MethodPost, ts.URL+"/enforce", enforce, &after)
+	if after.Cache == nil || after.Cache.Version <= before.Cache.Version {
+		t.Fatalf("expected a newer version after a change, got %+v then %+v", before.Cache, after.Cache)
+	}
+}
+
+func Test_PolicyVersions(t *testing.T) {
+	ts, node := newTestServer(t)
+	if err := node.Core.CreateNamespace(context.TODO(), "billing"); err != nil {
+		t.Fatalf("failed to create namespace: %s", err.Error())
+	}
+
+	var out core.PolicyVersionsResponse
+	resp := doJSON(t, http.MethodGet, ts.URL+"/policy_versions?namespace=default,billing,unknown", "", &out)
+	if resp.StatusCode != http.StatusOK || len(out.Versions) != 2 || out.Versions["default"] == 0 || out.Versions["billing"] == 0 {
+		t.Fatalf("expected the versions of both namespaces, got %d %v", resp.StatusCode, out.Versions)
+	}
+
+	// polling with the ETag is answered with 304 until a version changes
+	req, _ := http.NewRequest(http.MethodGet, ts.URL+"/policy_versions?namespace=default,billing", nil)
+	req.Header.Set("If-None-Match", resp.Header.Get("ETag"))
+	if status := doStatus(t, req); status != http.StatusNotModified {
+		t.Fatalf("expected 304 for unchanged versions, got %d", status)
+	}
+	if err := node.Core.SetModelFromString(context.TODO(), "billing", modelText); err != nil {
+		t.Fatalf("failed to set model: %s", err.Error())
+	}
+	if status := doStatus(t, req); status != http.StatusOK {
+		t.Fatalf("expected 200 once a version changed, got %d", status)
+	}
+}
+
+func doStatus(t *testing.T, req *http.Request) int {
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("failed to %s %s: %s", req.Method, req.URL, err.Error())
+	}
+	resp.Body.Close()
+	return resp.StatusCode
+}
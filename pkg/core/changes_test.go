@@ -0,0 +1,79 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package core_test
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/casbin/casbin-mesh/pkg/store"
+)
+
+func Test_Changes(t *testing.T) {
+	ts, node := newTestServer(t)
+	ctx := context.TODO()
+	if err := node.Core.CreateNamespace(ctx, "other"); err != nil {
+		t.Fatalf("failed to create namespace: %s", err.Error())
+	}
+
+	var feed store.ChangeFeed
+	if resp := doJSON(t, http.MethodGet, ts.URL+"/changes", "", &feed); resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected the feed, got %d", resp.StatusCode)
+	}
+	var types []string
+	for _, c := range feed.Changes {
+		if c.Namespace == "default" {
+			types = append(types, c.Type)
+		}
+	}
+	if len(types) == 0 || types[0] != "COMMAND_TYPE_CREATE_NAMESPACE" || types[len(types)-1] != "COMMAND_TYPE_ADD_POLICIES" {
+		t.Fatalf("expected the changes of default in order, got %v", types)
+	}
+
+	feed = store.ChangeFeed{}
+	doJSON(t, http.MethodGet, ts.URL+"/changes?namespace=oth*", "", &feed)
+	if len(feed.Changes) != 1 || feed.Changes[0].Namespace != "other" {
+		t.Fatalf("expected the change of other only, got %+v", feed.Changes)
+	}
+
+	// resuming after the last change waits for the next one
+	next := strconv.FormatUint(feed.Next, 10)
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		node.Core.AddPolicies(ctx, "other", "p", "p", [][]string{{"bob", "data2", "write"}})
+	}()
+	feed = store.ChangeFeed{}
+	doJSON(t, http.MethodGet, ts.URL+"/changes?namespace=other&wait=5s&since="+next, "", &feed)
+	if len(feed.Changes) != 1 || feed.Changes[0].Type != "COMMAND_TYPE_ADD_POLICIES" {
+		t.Fatalf("expected the change applied while waiting, got %+v", feed.Changes)
+	}
+
+	next = strconv.FormatUint(feed.Next, 10)
+	feed = store.ChangeFeed{}
+	doJSON(t, http.MethodGet, ts.URL+"/changes?namespace=other&wait=50ms&since="+next, "", &feed)
+	if len(feed.Changes) != 0 {
+		t.Fatalf("expected no change after the last one, got %+v", feed.Changes)
+	}
+
+	if resp := doJSON(t, http.MethodGet, ts.URL+"/changes?since=abc", "", nil); resp.StatusCode == http.StatusOK {
+		t.Fatalf("expected an invalid since refused")
+	}
+}
@@ -0,0 +1,51 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package core
+
+import (
+	"context"
+	http2 "net/http"
+	"strconv"
+	"time"
+
+	"github.com/casbin/casbin-mesh/pkg/cluster"
+	"github.com/casbin/casbin-mesh/pkg/handler/http"
+)
+
+// clock reports the ID and the clock of the node in the responses, so that
+// the nodes forwarding requests to it compare their clocks with its.
+func (s *httpService) clock(ctx *http.Context) error {
+	h := ctx.ResponseWriter.Header()
+	h.Set(cluster.NodeIDHeader, s.NodeID())
+	h.Set(cluster.NodeTimeHeader, strconv.FormatInt(time.Now().UnixNano(), 10))
+	return nil
+}
+
+// observeClock compares the clock of the node which answered a forwarded
+// request with h with the clock of this node.
+func (s *httpService) observeClock(ctx context.Context, h http2.Header, sent, received time.Time) {
+	if sample, ok := cluster.ParseClock(h, sent, received); ok {
+		s.ObserveClock(ctx, sample.NodeID, sample.Sent, sample.Received, sample.Remote)
+	}
+}
+
+// handleClock reports the offsets of the clocks of the other nodes from the
+// clock of this node.
+func (s *httpService) handleClock(ctx *http.Context) error {
+	return ctx.StatusCode(http2.StatusOK).JSON(s.ClockStatus(ctx.Request.Context()))
+}
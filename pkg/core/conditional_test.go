@@ -0,0 +1,68 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package core_test
+
+import (
+	"context"
+	"net/http"
+	"testing"
+)
+
+func getWithETag(t *testing.T, url, etag string) *http.Response {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		t.Fatalf("failed to create request: %s", err.Error())
+	}
+	if etag != "" {
+		req.Header.Set("If-None-Match", etag)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("failed to get %s: %s", url, err.Error())
+	}
+	resp.Body.Close()
+	return resp
+}
+
+func Test_ConditionalReads(t *testing.T) {
+	ts, node := newTestServer(t)
+	url := ts.URL + "/namespaces/default/policies"
+
+	resp := getWithETag(t, url, "")
+	etag := resp.Header.Get("ETag")
+	if resp.StatusCode != http.StatusOK || etag == "" {
+		t.Fatalf("expected 200 with an ETag, got %d %q", resp.StatusCode, etag)
+	}
+	if resp := getWithETag(t, url, etag); resp.StatusCode != http.StatusNotModified {
+		t.Fatalf("expected 304 for an unchanged read, got %d", resp.StatusCode)
+	}
+	if resp := getWithETag(t, url, `"stale"`); resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200 for a stale ETag, got %d", resp.StatusCode)
+	}
+
+	if _, err := node.Core.AddPolicies(context.TODO(), "default", "p", "p", [][]string{{"bob", "data2", "write"}}); err != nil {
+		t.Fatalf("failed to add policies: %s", err.Error())
+	}
+	resp = getWithETag(t, url, etag)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200 once the policies changed, got %d", resp.StatusCode)
+	}
+	if resp.Header.Get("ETag") == etag {
+		t.Fatalf("expected the ETag to change with the policies")
+	}
+}
@@ -0,0 +1,194 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	http2 "net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/casbin/casbin-mesh/pkg/handler/http"
+	"github.com/casbin/casbin-mesh/pkg/store"
+)
+
+// digestFetchTimeout bounds the time a node takes to return its digest.
+const digestFetchTimeout = 10 * time.Second
+
+// NodeDigest is the state digest of a node, compared to the leader's.
+type NodeDigest struct {
+	NodeID string `json:"node_id"`
+	Digest string `json:"digest,omitempty"`
+	// Divergent lists the namespaces whose state differs from the leader's.
+	Divergent []string `json:"divergent,omitempty"`
+	// Error is set when the digest of the node could not be read.
+	Error string `json:"error,omitempty"`
+}
+
+type ConsistencyResponse struct {
+	// Index is the log entry the nodes digested their state at.
+	Index uint64       `json:"index"`
+	Nodes []NodeDigest `json:"nodes"`
+	// Divergent is set when the state of a node differs from the leader's.
+	Divergent bool `json:"divergent"`
+}
+
+// handleStateDigest returns the digest of the state of this node at index,
+// once it applied the entry.
+func (s *httpService) handleStateDigest(ctx *http.Context) error {
+	v := ctx.Request.URL.Query().Get("index")
+	index, err := strconv.ParseUint(v, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid index: %s", v)
+	}
+	if err := s.WaitForIndex(ctx.Request.Context(), index); err != nil {
+		return err
+	}
+	d, ok := s.StateDigest(ctx.Request.Context(), index)
+	if !ok {
+		return fmt.Errorf("no digest at index %d", index)
+	}
+	return ctx.StatusCode(http2.StatusOK).JSON(d)
+}
+
+// handleVerifySnapshot restores a snapshot into a scratch FSM and reports
+// whether it restores to the state it was taken of. The snapshot is the
+// body of the request, a snapshot file, or the latest snapshot of this node
+// if the body is empty.
+func (s *httpService) handleVerifySnapshot(ctx *http.Context) error {
+	if ctx.Request.Method != http2.MethodPost {
+		return fmt.Errorf("method %s not allowed", ctx.Request.Method)
+	}
+	var r io.Reader
+	if ctx.Request.ContentLength != 0 {
+		r = ctx.Request.Body
+	}
+	v, err := s.VerifySnapshot(ctx.Request.Context(), r)
+	if errors.Is(err, store.ErrNoSnapshot) {
+		ctx.StatusCode(http2.StatusNotFound)
+		return err
+	}
+	if err != nil {
+		return err
+	}
+	return ctx.StatusCode(http2.StatusOK).JSON(v)
+}
+
+// handleConsistency has every node digest its state at the same log entry,
+// and compares their digests to the leader's.
+func (s *httpService) handleConsistency(ctx *http.Context) error {
+	rctx := ctx.Request.Context()
+	index, err := s.RecordDigest(rctx)
+	if err != nil {
+		return err
+	}
+	leader, ok := s.StateDigest(rctx, index)
+	if !ok {
+		return fmt.Errorf("no digest at index %d", index)
+	}
+	nodes, err := s.Nodes(rctx)
+	if err != nil {
+		return err
+	}
+
+	out := ConsistencyResponse{Index: index, Nodes: make([]NodeDigest, len(nodes))}
+	var wg sync.WaitGroup
+	for i, n := range nodes {
+		out.Nodes[i].NodeID = n.ID
+		if n.ID == s.NodeID() {
+			out.Nodes[i].Digest = leader.Digest
+			continue
+		}
+		wg.Add(1)
+		go func(nd *NodeDigest, n *store.Server) {
+			defer wg.Done()
+			d, err := s.fetchDigest(rctx, n, index, ctx.Request.Header.Get("Authorization"))
+			if err != nil {
+				nd.Error = err.Error()
+				return
+			}
+			nd.Digest = d.Digest
+			nd.Divergent = divergentNamespaces(leader, d)
+		}(&out.Nodes[i], n)
+	}
+	wg.Wait()
+	for _, n := range out.Nodes {
+		out.Divergent = out.Divergent || len(n.Divergent) > 0
+	}
+	return ctx.StatusCode(http2.StatusOK).JSON(out)
+}
+
+// fetchDigest reads the digest of node n at index through its API.
+func (s *httpService) fetchDigest(ctx context.Context, n *store.Server, index uint64, authorization string) (store.StateDigest, error) {
+	var d store.StateDigest
+	addr := n.Metadata["api_addr"]
+	if addr == "" {
+		addr = n.Addr
+	}
+	proto := n.Metadata["api_proto"]
+	if proto == "" {
+		proto = "http"
+	}
+	ctx, cancel := context.WithTimeout(ctx, digestFetchTimeout)
+	defer cancel()
+	req, err := http2.NewRequestWithContext(ctx, http2.MethodGet, fmt.Sprintf("%s://%s/state/digest?index=%d", proto, addr, index), nil)
+	if err != nil {
+		return d, err
+	}
+	if authorization != "" {
+		req.Header.Set("Authorization", authorization)
+	}
+	resp, err := http2.DefaultClient.Do(req)
+	if err != nil {
+		return d, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http2.StatusOK {
+		var body struct {
+			Error string `json:"error"`
+		}
+		_ = json.NewDecoder(resp.Body).Decode(&body)
+		return d, fmt.Errorf("unexpected status %s: %s", resp.Status, body.Error)
+	}
+	err = json.NewDecoder(resp.Body).Decode(&d)
+	return d, err
+}
+
+// divergentNamespaces returns the namespaces whose hash differs between the
+// digests, or which only one of them has.
+func divergentNamespaces(a, b store.StateDigest) []string {
+	var out []string
+	for ns, h := range a.Namespaces {
+		if b.Namespaces[ns] != h {
+			out = append(out, ns)
+		}
+	}
+	for ns := range b.Namespaces {
+		if _, ok := a.Namespaces[ns]; !ok {
+			out = append(out, ns)
+		}
+	}
+	sort.Strings(out)
+	return out
+}
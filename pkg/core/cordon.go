@@ -0,0 +1,131 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package core
+
+import (
+	"context"
+	"io"
+	http2 "net/http"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/casbin/casbin-mesh/pkg/handler/http"
+	"github.com/casbin/casbin-mesh/pkg/store"
+)
+
+// maintenanceEndpoints are still served by cordoned nodes, they manage the
+// node and the cluster rather than serve clients.
+var maintenanceEndpoints = map[string]bool{
+	"/join":                true,
+	"/remove":              true,
+	"/transfer-leader":     true,
+	"/leader":              true,
+	"/leader/step-down":    true,
+	"/cluster/status":      true,
+	"/cluster/replication": true,
+	"/cluster/consistency": true,
+	"/cluster/clock":       true,
+	"/cluster/join-token":  true,
+	"/set/node_metadata":   true,
+	"/state/digest":        true,
+	"/snapshot/verify":     true,
+	"/stats":               true,
+	"/autoscaling/signals": true,
+	"/reload":              true,
+	"/cordon":              true,
+	"/uncordon":            true,
+	"/drain":               true,
+}
+
+// cordonedMethods are the gRPC methods still served by cordoned nodes.
+var cordonedMethods = map[string]bool{
+	"/command.CasbinMesh/ShowStats": true,
+}
+
+// cordoned refuses the client requests of a cordoned node with 503 Service
+// Unavailable, so that load balancers route them to other nodes.
+func (s *httpService) cordoned(ctx *http.Context) error {
+	if maintenanceEndpoints[ctx.Request.URL.Path] {
+		return nil
+	}
+	if err := s.CheckCordon(ctx.Request.Context()); err != nil {
+		ctx.ResponseWriter.Header().Set("Retry-After", retryAfter)
+		ctx.StatusCode(http2.StatusServiceUnavailable)
+		return err
+	}
+	return nil
+}
+
+// cordonedUnary is the gRPC counterpart of cordoned, it fails the calls of a
+// cordoned node with codes.Unavailable.
+func cordonedUnary(core Core) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if !cordonedMethods[info.FullMethod] && !strings.HasPrefix(info.FullMethod, healthMethodPrefix) {
+			if err := core.CheckCordon(ctx); err != nil {
+				return nil, status.Error(codes.Unavailable, err.Error())
+			}
+		}
+		return handler(ctx, req)
+	}
+}
+
+// cordonedStream fails the streams opened on a cordoned node.
+func cordonedStream(core Core) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if !strings.HasPrefix(info.FullMethod, healthMethodPrefix) {
+			if err := core.CheckCordon(ss.Context()); err != nil {
+				return status.Error(codes.Unavailable, err.Error())
+			}
+		}
+		return handler(srv, ss)
+	}
+}
+
+type CordonRequest struct {
+	// Reason is reported to the clients refused while cordoned.
+	Reason string `json:"reason"`
+}
+
+// handleCordon cordons the node on POST, and reports its status on GET.
+func (s *httpService) handleCordon(ctx *http.Context) error {
+	switch ctx.Request.Method {
+	case http2.MethodGet:
+		return ctx.StatusCode(http2.StatusOK).JSON(s.CordonStatus(ctx.Request.Context()))
+	case http2.MethodPost:
+		var request CordonRequest
+		if err := s.decode(ctx.Request.Body, &request); err != nil && err != io.EOF {
+			return err
+		}
+		s.Cordon(ctx.Request.Context(), request.Reason)
+		return ctx.StatusCode(http2.StatusOK).JSON(s.CordonStatus(ctx.Request.Context()))
+	}
+	ctx.StatusCode(http2.StatusMethodNotAllowed)
+	return nil
+}
+
+func (s *httpService) handleUncordon(ctx *http.Context) error {
+	if ctx.Request.Method != http2.MethodPost {
+		ctx.StatusCode(http2.StatusMethodNotAllowed)
+		return nil
+	}
+	s.Uncordon(ctx.Request.Context())
+	return ctx.StatusCode(http2.StatusOK).JSON(store.CordonStatus{})
+}
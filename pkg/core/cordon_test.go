@@ -0,0 +1,131 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package core_test
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/casbin/casbin-mesh/pkg/cluster"
+	"github.com/casbin/casbin-mesh/pkg/core"
+	"github.com/casbin/casbin-mesh/pkg/store"
+)
+
+func Test_Cordon(t *testing.T) {
+	ts, _ := newTestServer(t)
+
+	enforce := `{"ns":"default","params":["alice","data1","read"]}`
+	var status store.CordonStatus
+	if resp := doJSON(t, http.MethodPost, ts.URL+"/cordon", `{"reason":"kernel patch"}`, &status); resp.StatusCode != http.StatusOK || !status.Cordoned {
+		t.Fatalf("expected the node cordoned, got %d %+v", resp.StatusCode, status)
+	}
+
+	// client requests are refused, maintenance ones still served
+	resp := doJSON(t, http.MethodPost, ts.URL+"/enforce", enforce, nil)
+	if resp.StatusCode != http.StatusServiceUnavailable || resp.Header.Get("Retry-After") == "" {
+		t.Fatalf("expected 503 with Retry-After while cordoned, got %d", resp.StatusCode)
+	}
+	if resp := doJSON(t, http.MethodGet, ts.URL+"/stats", "", nil); resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected stats served while cordoned, got %d", resp.StatusCode)
+	}
+	status = store.CordonStatus{}
+	doJSON(t, http.MethodGet, ts.URL+"/cordon", "", &status)
+	if !status.Cordoned || status.Reason != "kernel patch" || status.Since == 0 {
+		t.Fatalf("expected the cordon status, got %+v", status)
+	}
+
+	if resp := doJSON(t, http.MethodPost, ts.URL+"/uncordon", "", nil); resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected the node uncordoned, got %d", resp.StatusCode)
+	}
+	if resp := doJSON(t, http.MethodPost, ts.URL+"/enforce", enforce, nil); resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected enforce served once uncordoned, got %d", resp.StatusCode)
+	}
+}
+
+func Test_Drain(t *testing.T) {
+	ts, _ := newTestServer(t)
+
+	doJSON(t, http.MethodPost, ts.URL+"/cordon", "", nil)
+	var out core.DrainResponse
+	if resp := doJSON(t, http.MethodGet, ts.URL+"/drain?wait=5s", "", &out); resp.StatusCode != http.StatusOK || !out.Drained {
+		t.Fatalf("expected the cordoned node drained, got %d %+v", resp.StatusCode, out)
+	}
+	if resp := doJSON(t, http.MethodGet, ts.URL+"/drain?wait=soon", "", nil); resp.StatusCode == http.StatusOK {
+		t.Fatalf("expected an invalid wait refused")
+	}
+}
+
+func Test_ClockHeaders(t *testing.T) {
+	ts, _ := newTestServer(t)
+
+	var status store.ClockStatus
+	resp := doJSON(t, http.MethodGet, ts.URL+"/cluster/clock", "", &status)
+	if resp.StatusCode != http.StatusOK || status.MaxSkew != store.DefaultMaxClockSkew || status.Skewed {
+		t.Fatalf("unexpected clock status %d %+v", resp.StatusCode, status)
+	}
+	if resp.Header.Get(cluster.NodeIDHeader) == "" || resp.Header.Get(cluster.NodeTimeHeader) == "" {
+		t.Fatalf("expected the node and its clock in the response headers, got %v", resp.Header)
+	}
+}
+
+func Test_JoinToken(t *testing.T) {
+	ts, node := newTestServer(t)
+	node.Store.RequireJoinToken = true
+
+	join := `{"id":"node1","addr":"localhost:0"}`
+	if resp := doJSON(t, http.MethodPost, ts.URL+"/join", join, nil); resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("expected a join without token forbidden, got %d", resp.StatusCode)
+	}
+	// members can't change their metadata through a join without token
+	rejoin := fmt.Sprintf(`{"id":"%s","addr":"%s","metadata":{"zone":"a"}}`, node.ID, node.Addr)
+	if resp := doJSON(t, http.MethodPost, ts.URL+"/join", rejoin, nil); resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("expected a member changing its metadata without token forbidden, got %d", resp.StatusCode)
+	}
+	var token store.JoinToken
+	if resp := doJSON(t, http.MethodPost, ts.URL+"/cluster/join-token?ttl=10m", "", &token); resp.StatusCode != http.StatusOK || token.Token == "" {
+		t.Fatalf("failed to create a join token, got %d %+v", resp.StatusCode, token)
+	}
+	if d := time.Until(token.Expires); d <= 9*time.Minute || d > 10*time.Minute {
+		t.Fatalf("wrong token expiry %s", token.Expires)
+	}
+	if resp := doJSON(t, http.MethodPost, ts.URL+"/cluster/join-token?ttl=-1s", "", nil); resp.StatusCode == http.StatusOK {
+		t.Fatalf("expected an invalid ttl refused")
+	}
+}
+
+func Test_JoinClusterMismatch(t *testing.T) {
+	ts, node := newTestServer(t)
+	var status core.ClusterStatusResponse
+	for i := 0; status.ClusterID == ""; i++ {
+		if i == 50 {
+			t.Fatalf("cluster ID not set")
+		}
+		time.Sleep(100 * time.Millisecond)
+		doJSON(t, http.MethodGet, ts.URL+"/cluster/status", "", &status)
+	}
+	if status.ClusterID != node.Store.ClusterID() {
+		t.Fatalf("expected cluster ID %s, got %s", node.Store.ClusterID(), status.ClusterID)
+	}
+
+	join := `{"id":"node1","addr":"localhost:0","metadata":{"cluster_id":"other"}}`
+	if resp := doJSON(t, http.MethodPost, ts.URL+"/join", join, nil); resp.StatusCode != http.StatusConflict {
+		t.Fatalf("expected a join of another cluster refused, got %d", resp.StatusCode)
+	}
+}
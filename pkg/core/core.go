@@ -22,6 +22,9 @@ import (
 	"github.com/casbin/casbin-mesh/pkg/auth"
 	"github.com/casbin/casbin-mesh/pkg/store"
 	"github.com/casbin/casbin-mesh/proto/command"
+	"io"
+	"net/url"
+	"time"
 )
 
 type core struct {
@@ -40,14 +43,86 @@ func (s core) PrintModel(ctx context.Context, namespace string) (string, error)
 	return s.store.PrintModel(ctx, namespace)
 }
 
-func (s core) Join(ctx context.Context, id, addr string, voter bool, metadata map[string]string) error {
-	return s.store.Join(id, addr, voter, metadata)
+func (s core) NamespaceSnapshot(ctx context.Context, namespace string) (string, []*command.PolicyRules, uint64, error) {
+	return s.store.NamespaceSnapshot(ctx, namespace)
+}
+
+func (s core) Join(ctx context.Context, id, addr string, voter bool, metadata map[string]string, token string) error {
+	return s.store.JoinWithToken(id, addr, voter, metadata, token)
+}
+
+func (s core) CreateJoinToken(ctx context.Context, ttl time.Duration) (store.JoinToken, error) {
+	return s.store.CreateJoinToken(ttl)
 }
 
 func (s core) Remove(ctx context.Context, id string) error {
 	return s.store.Remove(id)
 }
 
+func (s core) TransferLeadership(ctx context.Context, id string) error {
+	return s.store.TransferLeadership(id)
+}
+
+func (s core) Nodes(ctx context.Context) ([]*store.Server, error) {
+	return s.store.Nodes()
+}
+
+func (s core) SetNodeMetadata(ctx context.Context, id string, md map[string]string) error {
+	return s.store.SetNodeMetadata(id, md)
+}
+
+func (s core) Leader(ctx context.Context) (store.LeaderInfo, error) {
+	return s.store.Leader()
+}
+
+func (s core) Cordon(ctx context.Context, reason string) {
+	s.store.Cordon(reason)
+}
+
+func (s core) Uncordon(ctx context.Context) {
+	s.store.Uncordon()
+}
+
+func (s core) CordonStatus(ctx context.Context) store.CordonStatus {
+	return s.store.CordonStatus()
+}
+
+func (s core) CheckCordon(ctx context.Context) error {
+	return s.store.CheckCordon()
+}
+
+func (s core) ObserveClock(ctx context.Context, id string, sent, received, remote time.Time) {
+	s.store.ObserveClock(id, sent, received, remote)
+}
+
+func (s core) ClockStatus(ctx context.Context) store.ClockStatus {
+	return s.store.ClockStatus()
+}
+
+func (s core) ApplyStatus(ctx context.Context) store.ApplyStatus {
+	return s.store.ApplyStatus()
+}
+
+func (s core) BeginRequest(ctx context.Context, class store.RequestClass) func() {
+	return s.store.BeginRequest(class)
+}
+
+func (s core) InFlight(ctx context.Context) store.InFlightStatus {
+	return s.store.InFlight()
+}
+
+func (s core) AwaitDrain(ctx context.Context) error {
+	return s.store.AwaitDrain(ctx)
+}
+
+func (s core) StepDown(ctx context.Context, id string) error {
+	return s.store.StepDown(id)
+}
+
+func (s core) Replication(ctx context.Context) ([]store.FollowerProgress, error) {
+	return s.store.Replication()
+}
+
 func (s core) CreateNamespace(ctx context.Context, ns string) error {
 	return s.store.CreateNamespace(ctx, ns)
 }
@@ -60,10 +135,79 @@ func (s core) Enforce(ctx context.Context, ns string, level int32, freshness int
 	return s.store.Enforce(ctx, ns, command.EnforcePayload_Level(level), freshness, params...)
 }
 
+func (s core) EnforceWithContext(ctx context.Context, ns string, level int32, freshness int64, ec *command.EnforceContext, params ...interface{}) (bool, error) {
+	return s.store.EnforceWithContext(ctx, ns, command.EnforcePayload_Level(level), freshness, ec, params...)
+}
+
 func (s core) AddPolicies(ctx context.Context, ns string, sec string, pType string, rules [][]string) ([][]string, error) {
 	return s.store.AddPolicies(ctx, ns, sec, pType, rules)
 }
 
+func (s core) AddExpiringPolicies(ctx context.Context, ns string, sec string, pType string, rules [][]string, expireAt time.Time) ([][]string, error) {
+	return s.store.AddExpiringPolicies(ctx, ns, sec, pType, rules, expireAt)
+}
+
+func (s core) DuePolicies(ctx context.Context, now time.Time) []store.DuePolicies {
+	return s.store.DuePolicies(now)
+}
+
+func (s core) ExpirePolicies(ctx context.Context, ns string, sec string, pType string, now time.Time) ([][]string, error) {
+	return s.store.ExpirePolicies(ctx, ns, sec, pType, now)
+}
+
+func (s core) AddScheduledPolicies(ctx context.Context, ns string, sec string, pType string, rules [][]string, schedule *command.Schedule) ([][]string, error) {
+	return s.store.AddScheduledPolicies(ctx, ns, sec, pType, rules, schedule)
+}
+
+func (s core) ScheduleChanges(ctx context.Context, now time.Time) []store.ScheduleChange {
+	return s.store.ScheduleChanges(now)
+}
+
+func (s core) SchedulePolicies(ctx context.Context, ns string, sec string, pType string, now time.Time, activate bool) ([][]string, error) {
+	return s.store.SchedulePolicies(ctx, ns, sec, pType, now, activate)
+}
+
+func (s core) AnnotatePolicies(ctx context.Context, ns string, sec string, pType string, rules [][]string, annotation *command.Annotation) ([][]string, error) {
+	return s.store.AnnotatePolicies(ctx, ns, sec, pType, rules, annotation)
+}
+
+func (s core) ListAnnotations(ctx context.Context, ns string) ([]*command.PolicyAnnotation, error) {
+	return s.store.ListAnnotations(ctx, ns)
+}
+
+func (s core) PagePolicies(ctx context.Context, ns, prefix, after string, limit int64) ([][]string, string, error) {
+	return s.store.PagePolicies(ctx, ns, prefix, after, limit)
+}
+
+func (s core) TagVersion(ctx context.Context, ns string, tag string, version uint64) (uint64, error) {
+	return s.store.TagVersion(ctx, ns, tag, version)
+}
+
+func (s core) RollbackPolicies(ctx context.Context, ns string, version uint64, tag string) (bool, error) {
+	return s.store.RollbackPolicies(ctx, ns, version, tag)
+}
+
+// ReplacePolicies makes policies the rules of ns and returns the rules added
+// and removed. With dryRun, nothing changes.
+func (s core) ReplacePolicies(ctx context.Context, ns string, policies []*command.PolicyRules, dryRun bool) ([]*command.PolicyRules, []*command.PolicyRules, error) {
+	if dryRun {
+		return s.store.PlanReplacePolicies(ns, policies)
+	}
+	return s.store.ReplacePolicies(ctx, ns, policies)
+}
+
+func (s core) ListVersions(ctx context.Context, ns string) ([]*command.PolicyVersion, error) {
+	return s.store.ListVersions(ctx, ns)
+}
+
+func (s core) SetReadOnly(ctx context.Context, enabled bool, reason string) (bool, error) {
+	return s.store.SetReadOnly(ctx, enabled, reason)
+}
+
+func (s core) ReadOnly(ctx context.Context) store.ReadOnlyStatus {
+	return s.store.ReadOnly()
+}
+
 func (s core) RemovePolicies(ctx context.Context, ns string, sec string, pType string, rules [][]string) ([][]string, error) {
 	return s.store.RemovePolicies(ctx, ns, sec, pType, rules)
 }
@@ -80,6 +224,119 @@ func (s core) ClearPolicy(ctx context.Context, ns string) error {
 	return s.store.ClearPolicy(ctx, ns)
 }
 
+func (s core) SetTemplate(ctx context.Context, t *command.Template) (uint64, error) {
+	return s.store.SetTemplate(ctx, t)
+}
+
+func (s core) DeleteTemplate(ctx context.Context, name string) error {
+	return s.store.DeleteTemplate(ctx, name)
+}
+
+func (s core) ListTemplates(ctx context.Context) ([]*command.Template, []*command.TemplateInstance, error) {
+	return s.store.ListTemplates(ctx)
+}
+
+func (s core) SetTemplateInstance(ctx context.Context, inst *command.TemplateInstance) error {
+	return s.store.SetTemplateInstance(ctx, inst)
+}
+
+func (s core) DeleteTemplateInstance(ctx context.Context, ns, name string) error {
+	return s.store.DeleteTemplateInstance(ctx, ns, name)
+}
+
+func (s core) Watch(ctx context.Context, ns string, index uint64, fn func(*command.WatchEvent) error) error {
+	return s.store.Watch(ctx, ns, index, fn)
+}
+
+func (s core) Changes(ctx context.Context, namespaces []string, index uint64, limit int) (*store.ChangeFeed, error) {
+	return s.store.Changes(namespaces, index, limit)
+}
+
+func (s core) ApplyChange(ctx context.Context, ch store.ChangeEvent) error {
+	return s.store.ApplyChange(ctx, ch)
+}
+
+func (s core) SeedNamespace(ctx context.Context, ns, model string, policies []*command.PolicyRules, index uint64) error {
+	return s.store.SeedNamespace(ctx, ns, model, policies, index)
+}
+
+func (s core) StandbyPosition(ctx context.Context) store.StandbyPosition {
+	return s.store.StandbyPosition()
+}
+
+func (s core) WaitForIndex(ctx context.Context, index uint64) error {
+	return s.store.WaitForIndex(ctx, index)
+}
+
+func (s core) RemoveSubject(ctx context.Context, ns, sub string, all bool) ([]store.RemovedRules, error) {
+	return s.store.RemoveSubject(ctx, ns, sub, all)
+}
+
+func (s core) RenameSubject(ctx context.Context, ns, from, to string, all bool) ([]store.RenamedRules, error) {
+	return s.store.RenameSubject(ctx, ns, from, to, all)
+}
+
+func (s core) CopyPolicies(ctx context.Context, from, to string, filter url.Values, move bool) ([]store.CopiedRules, error) {
+	return s.store.CopyPolicies(ctx, from, to, filter, move)
+}
+
+func (s core) SetNamespaceLabels(ctx context.Context, ns string, labels map[string]string) error {
+	return s.store.SetNamespaceLabels(ctx, ns, labels)
+}
+
+func (s core) NamespaceLabels(ctx context.Context, ns string) (map[string]string, error) {
+	return s.store.NamespaceLabels(ns)
+}
+
+func (s core) CacheHints(ctx context.Context, ns string) (store.CacheHints, error) {
+	return s.store.CacheHints(ns)
+}
+
+func (s core) PolicySetVersions(ctx context.Context, patterns []string) (map[string]uint64, error) {
+	return s.store.PolicySetVersions(patterns)
+}
+
+func (s core) NamespaceEffects(ctx context.Context, ns string) ([]store.Effect, error) {
+	return s.store.NamespaceEffects(ns)
+}
+
+func (s core) SetNamespaceSettings(ctx context.Context, ns string, settings store.NamespaceSettings) error {
+	return s.store.SetNamespaceSettings(ctx, ns, settings)
+}
+
+func (s core) NamespaceSettings(ctx context.Context, ns string) (store.NamespaceSettings, error) {
+	return s.store.NamespaceSettings(ns)
+}
+
+func (s core) MatchNamespaces(ctx context.Context, selector string) ([]string, error) {
+	return s.store.MatchNamespaces(selector)
+}
+
+func (s core) Explain(ctx context.Context, ns string, ec *command.EnforceContext, params ...interface{}) (*store.Explanation, error) {
+	return s.store.Explain(ns, ec, params...)
+}
+
+func (s core) RecordDigest(ctx context.Context) (uint64, error) {
+	return s.store.RecordDigest(ctx)
+}
+
+func (s core) StateDigest(ctx context.Context, index uint64) (store.StateDigest, bool) {
+	return s.store.StateDigest(index)
+}
+
+// VerifySnapshot verifies the snapshot read from r, or the latest snapshot
+// of this node if r is nil.
+func (s core) VerifySnapshot(ctx context.Context, r io.Reader) (*store.SnapshotVerification, error) {
+	if r == nil {
+		return s.store.VerifyLatestSnapshot()
+	}
+	return store.VerifySnapshot(r), nil
+}
+
+func (s core) LoadSignals(ctx context.Context) store.LoadSignals {
+	return s.store.LoadSignals()
+}
+
 func (s core) Stats(ctx context.Context) (map[string]interface{}, error) {
 	return s.store.Stats()
 }
@@ -92,6 +349,17 @@ func (s core) LeaderAddr() string {
 	return s.store.LeaderAddr()
 }
 
+// NodeID returns the ID of this node.
+func (s core) NodeID() string {
+	return s.store.ID()
+}
+
+// ClusterID returns the ID of the cluster of this node, empty until the
+// node learns it.
+func (s core) ClusterID() string {
+	return s.store.ClusterID()
+}
+
 // LeaderAPIAddr returns the API address of the leader, as known by this node.
 func (s core) LeaderAPIAddr() string {
 	return s.store.LeaderAddr()
@@ -125,21 +393,110 @@ type Core interface {
 	ListNamespaces(ctx context.Context) ([]string, error)
 	ListPolicies(ctx context.Context, namespace, cursor string, skip, limit int64, reverse bool) ([][]string, error)
 	PrintModel(ctx context.Context, namespace string) (string, error)
+	NamespaceSnapshot(ctx context.Context, namespace string) (string, []*command.PolicyRules, uint64, error)
 	IsLeader(ctx context.Context) bool
 	LeaderAddr() string
+	NodeID() string
+	ClusterID() string
+	LoadSignals(ctx context.Context) store.LoadSignals
 	Stats(ctx context.Context) (map[string]interface{}, error)
 	CreateNamespace(ctx context.Context, ns string) error
 	SetModelFromString(ctx context.Context, ns string, text string) error
 	Enforce(ctx context.Context, ns string, level int32, freshness int64, params ...interface{}) (bool, error)
+	EnforceWithContext(ctx context.Context, ns string, level int32, freshness int64, ec *command.EnforceContext, params ...interface{}) (bool, error)
 	AddPolicies(ctx context.Context, ns string, sec string, pType string, rules [][]string) ([][]string, error)
+	AddExpiringPolicies(ctx context.Context, ns string, sec string, pType string, rules [][]string, expireAt time.Time) ([][]string, error)
+	DuePolicies(ctx context.Context, now time.Time) []store.DuePolicies
+	ExpirePolicies(ctx context.Context, ns string, sec string, pType string, now time.Time) ([][]string, error)
+	AddScheduledPolicies(ctx context.Context, ns string, sec string, pType string, rules [][]string, schedule *command.Schedule) ([][]string, error)
+	ScheduleChanges(ctx context.Context, now time.Time) []store.ScheduleChange
+	SchedulePolicies(ctx context.Context, ns string, sec string, pType string, now time.Time, activate bool) ([][]string, error)
+	AnnotatePolicies(ctx context.Context, ns string, sec string, pType string, rules [][]string, annotation *command.Annotation) ([][]string, error)
+	ListAnnotations(ctx context.Context, ns string) ([]*command.PolicyAnnotation, error)
+	PagePolicies(ctx context.Context, ns, prefix, after string, limit int64) ([][]string, string, error)
+	TagVersion(ctx context.Context, ns string, tag string, version uint64) (uint64, error)
+	RollbackPolicies(ctx context.Context, ns string, version uint64, tag string) (bool, error)
+	ReplacePolicies(ctx context.Context, ns string, policies []*command.PolicyRules, dryRun bool) ([]*command.PolicyRules, []*command.PolicyRules, error)
+	ListVersions(ctx context.Context, ns string) ([]*command.PolicyVersion, error)
+	SetReadOnly(ctx context.Context, enabled bool, reason string) (bool, error)
+	ReadOnly(ctx context.Context) store.ReadOnlyStatus
 	RemovePolicies(ctx context.Context, ns string, sec string, pType string, rules [][]string) ([][]string, error)
 	RemoveFilteredPolicy(ctx context.Context, ns string, sec string, pType string, fi int32, fv []string) ([][]string, error)
 	UpdatePolicies(ctx context.Context, ns string, sec string, pType string, nr, or [][]string) (bool, error)
 	ClearPolicy(ctx context.Context, ns string) error
-	Join(ctx context.Context, id, addr string, voter bool, metadata map[string]string) error
+	SetTemplate(ctx context.Context, t *command.Template) (uint64, error)
+	DeleteTemplate(ctx context.Context, name string) error
+	ListTemplates(ctx context.Context) ([]*command.Template, []*command.TemplateInstance, error)
+	SetTemplateInstance(ctx context.Context, inst *command.TemplateInstance) error
+	DeleteTemplateInstance(ctx context.Context, ns, name string) error
+	Watch(ctx context.Context, ns string, index uint64, fn func(*command.WatchEvent) error) error
+	Changes(ctx context.Context, namespaces []string, index uint64, limit int) (*store.ChangeFeed, error)
+	ApplyChange(ctx context.Context, ch store.ChangeEvent) error
+	SeedNamespace(ctx context.Context, ns, model string, policies []*command.PolicyRules, index uint64) error
+	StandbyPosition(ctx context.Context) store.StandbyPosition
+	WaitForIndex(ctx context.Context, index uint64) error
+	RemoveSubject(ctx context.Context, ns, sub string, all bool) ([]store.RemovedRules, error)
+	RenameSubject(ctx context.Context, ns, from, to string, all bool) ([]store.RenamedRules, error)
+	CopyPolicies(ctx context.Context, from, to string, filter url.Values, move bool) ([]store.CopiedRules, error)
+	SetNamespaceLabels(ctx context.Context, ns string, labels map[string]string) error
+	NamespaceLabels(ctx context.Context, ns string) (map[string]string, error)
+	NamespaceEffects(ctx context.Context, ns string) ([]store.Effect, error)
+	CacheHints(ctx context.Context, ns string) (store.CacheHints, error)
+	PolicySetVersions(ctx context.Context, patterns []string) (map[string]uint64, error)
+	SetNamespaceSettings(ctx context.Context, ns string, settings store.NamespaceSettings) error
+	NamespaceSettings(ctx context.Context, ns string) (store.NamespaceSettings, error)
+	MatchNamespaces(ctx context.Context, selector string) ([]string, error)
+	Explain(ctx context.Context, ns string, ec *command.EnforceContext, params ...interface{}) (*store.Explanation, error)
+	RecordDigest(ctx context.Context) (uint64, error)
+	StateDigest(ctx context.Context, index uint64) (store.StateDigest, bool)
+	VerifySnapshot(ctx context.Context, r io.Reader) (*store.SnapshotVerification, error)
+	Join(ctx context.Context, id, addr string, voter bool, metadata map[string]string, token string) error
+	CreateJoinToken(ctx context.Context, ttl time.Duration) (store.JoinToken, error)
 	Remove(ctx context.Context, id string) error
+	TransferLeadership(ctx context.Context, id string) error
+	Nodes(ctx context.Context) ([]*store.Server, error)
+	SetNodeMetadata(ctx context.Context, id string, md map[string]string) error
+	Leader(ctx context.Context) (store.LeaderInfo, error)
+	StepDown(ctx context.Context, id string) error
+	Cordon(ctx context.Context, reason string)
+	Uncordon(ctx context.Context)
+	CordonStatus(ctx context.Context) store.CordonStatus
+	CheckCordon(ctx context.Context) error
+	ApplyStatus(ctx context.Context) store.ApplyStatus
+	ObserveClock(ctx context.Context, id string, sent, received, remote time.Time)
+	ClockStatus(ctx context.Context) store.ClockStatus
+	BeginRequest(ctx context.Context, class store.RequestClass) func()
+	InFlight(ctx context.Context) store.InFlightStatus
+	AwaitDrain(ctx context.Context) error
+	Replication(ctx context.Context) ([]store.FollowerProgress, error)
 }
 
 func New(store *store.Store) Core {
 	return &core{store}
 }
+
+// DecisionFunc is called with the outcome of every Enforce.
+type DecisionFunc func(ctx context.Context, ns string, params []interface{}, result bool, err error)
+
+type observedCore struct {
+	Core
+	fn DecisionFunc
+}
+
+func (s observedCore) Enforce(ctx context.Context, ns string, level int32, freshness int64, params ...interface{}) (bool, error) {
+	result, err := s.Core.Enforce(ctx, ns, level, freshness, params...)
+	s.fn(ctx, ns, params, result, err)
+	return result, err
+}
+
+func (s observedCore) EnforceWithContext(ctx context.Context, ns string, level int32, freshness int64, ec *command.EnforceContext, params ...interface{}) (bool, error) {
+	result, err := s.Core.EnforceWithContext(ctx, ns, level, freshness, ec, params...)
+	s.fn(ctx, ns, params, result, err)
+	return result, err
+}
+
+// ObserveDecisions returns a Core calling fn with the outcome of every Enforce
+// served by c.
+func ObserveDecisions(c Core, fn DecisionFunc) Core {
+	return observedCore{c, fn}
+}
@@ -0,0 +1,262 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package core
+
+import (
+	"errors"
+	"fmt"
+	http2 "net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/casbin/casbin-mesh/pkg/handler/http"
+	"github.com/casbin/casbin-mesh/pkg/preset"
+	"github.com/casbin/casbin-mesh/pkg/store"
+	"github.com/casbin/casbin-mesh/proto/command"
+	"github.com/casbin/casbin/v2/model"
+)
+
+// The declarative resources are served under /namespaces/<namespace>, and
+// /model and /rules under it: GET reads the state of a resource, PUT makes it
+// the state in the body, changing nothing if it already is. Their IDs are
+// stable, "<namespace>", "<namespace>/model" and "<namespace>/rules", so that
+// infrastructure-as-code tools can manage them.
+
+type NamespaceResource struct {
+	ID     string            `json:"id"`
+	Labels map[string]string `json:"labels"`
+	// Changed tells whether a PUT changed the namespace.
+	Changed bool `json:"changed"`
+}
+
+type PutNamespaceRequest struct {
+	// Labels are set if given.
+	Labels map[string]string `json:"labels"`
+}
+
+type ModelResource struct {
+	ID   string `json:"id"`
+	Text string `json:"text"`
+	// Changed tells whether a PUT changed the model.
+	Changed bool `json:"changed"`
+}
+
+type PutModelRequest struct {
+	// Text or Preset is the model.
+	Text   string `json:"text"`
+	Preset string `json:"preset"`
+}
+
+type RulesResource struct {
+	ID string `json:"id"`
+	// Rules start with their policy type, like "p, alice, data1, read" in
+	// the CSV format, and are sorted.
+	Rules [][]string `json:"rules"`
+	// Added and Removed are the changes of a PUT.
+	Added   [][]string `json:"added,omitempty"`
+	Removed [][]string `json:"removed,omitempty"`
+	// Changed tells whether a PUT changed the rules, or would have for a
+	// dry run.
+	Changed bool `json:"changed"`
+}
+
+type PutRulesRequest struct {
+	Rules [][]string `json:"rules"`
+}
+
+// resourceError sets the status of the errors of the declarative resources.
+func resourceError(ctx *http.Context, err error) error {
+	switch {
+	case errors.Is(err, store.NamespaceNotExist):
+		ctx.StatusCode(http2.StatusNotFound)
+	case errors.Is(err, store.ModelUnsetYet):
+		ctx.StatusCode(http2.StatusConflict)
+	}
+	return err
+}
+
+func methodNotAllowed(ctx *http.Context, allowed ...string) error {
+	ctx.ResponseWriter.Header().Set("Allow", strings.Join(allowed, ", "))
+	ctx.StatusCode(http2.StatusMethodNotAllowed)
+	return fmt.Errorf("method %s not allowed", ctx.Request.Method)
+}
+
+// handleNamespace serves the namespace resource. Namespaces can't be
+// deleted, a DELETE is not allowed, deleting its rules empties one.
+func (s *httpService) handleNamespace(ctx *http.Context, ns string) error {
+	rctx := ctx.Request.Context()
+	switch ctx.Request.Method {
+	case http2.MethodGet:
+		labels, err := s.NamespaceLabels(rctx, ns)
+		if err != nil {
+			return resourceError(ctx, err)
+		}
+		return ctx.CacheableJSON(NamespaceResource{ID: ns, Labels: labels})
+	case http2.MethodPut:
+		var request PutNamespaceRequest
+		if ctx.Request.ContentLength != 0 {
+			if err := s.decode(ctx.Request.Body, &request); err != nil {
+				return err
+			}
+		}
+		out := NamespaceResource{ID: ns}
+		labels, err := s.NamespaceLabels(rctx, ns)
+		if errors.Is(err, store.NamespaceNotExist) {
+			if err = s.CreateNamespace(rctx, ns); err != nil {
+				return err
+			}
+			out.Changed = true
+		} else if err != nil {
+			return err
+		}
+		out.Labels = labels
+		if request.Labels != nil && !reflect.DeepEqual(normalizeLabels(labels), normalizeLabels(request.Labels)) {
+			if err = s.SetNamespaceLabels(rctx, ns, request.Labels); err != nil {
+				return err
+			}
+			out.Labels, out.Changed = request.Labels, true
+		}
+		return ctx.StatusCode(http2.StatusOK).JSON(out)
+	}
+	return methodNotAllowed(ctx, http2.MethodGet, http2.MethodPut)
+}
+
+func normalizeLabels(labels map[string]string) map[string]string {
+	if labels == nil {
+		return map[string]string{}
+	}
+	return labels
+}
+
+// handleModel serves the model resource. The model is compared in the form
+// Casbin prints it, so that formatting changes are no changes.
+func (s *httpService) handleModel(ctx *http.Context, ns string) error {
+	rctx := ctx.Request.Context()
+	id := ns + "/model"
+	switch ctx.Request.Method {
+	case http2.MethodGet:
+		text, _, _, err := s.NamespaceSnapshot(rctx, ns)
+		if err != nil {
+			return resourceError(ctx, err)
+		}
+		return ctx.CacheableJSON(ModelResource{ID: id, Text: text})
+	case http2.MethodPut:
+		var request PutModelRequest
+		if err := s.decode(ctx.Request.Body, &request); err != nil {
+			return err
+		}
+		text := request.Text
+		if request.Preset != "" {
+			p, err := preset.Get(request.Preset)
+			if err != nil {
+				return err
+			}
+			text = p.Text
+		}
+		m, err := model.NewModelFromString(text)
+		if err != nil {
+			ctx.StatusCode(http2.StatusBadRequest)
+			return err
+		}
+		current, _, _, err := s.NamespaceSnapshot(rctx, ns)
+		if err != nil && !errors.Is(err, store.ModelUnsetYet) {
+			return resourceError(ctx, err)
+		}
+		out := ModelResource{ID: id, Text: m.ToText()}
+		if err != nil || current != out.Text {
+			if err = s.SetModelFromString(rctx, ns, text); err != nil {
+				return err
+			}
+			out.Changed = true
+		}
+		return ctx.StatusCode(http2.StatusOK).JSON(out)
+	}
+	return methodNotAllowed(ctx, http2.MethodGet, http2.MethodPut)
+}
+
+// handleRules serves the rules resource. A PUT replaces every rule of the
+// namespace at once, and only reports the changes with ?dry_run=true. DELETE
+// removes every rule.
+func (s *httpService) handleRules(ctx *http.Context, ns string) error {
+	rctx := ctx.Request.Context()
+	id := ns + "/rules"
+	var request PutRulesRequest
+	switch ctx.Request.Method {
+	case http2.MethodGet:
+		_, policies, _, err := s.NamespaceSnapshot(rctx, ns)
+		if err != nil {
+			return resourceError(ctx, err)
+		}
+		return ctx.CacheableJSON(RulesResource{ID: id, Rules: ruleLines(policies)})
+	case http2.MethodPut:
+		if err := s.decode(ctx.Request.Body, &request); err != nil {
+			return err
+		}
+	case http2.MethodDelete:
+	default:
+		return methodNotAllowed(ctx, http2.MethodGet, http2.MethodPut, http2.MethodDelete)
+	}
+	policies, err := ruleSets(request.Rules)
+	if err != nil {
+		ctx.StatusCode(http2.StatusBadRequest)
+		return err
+	}
+	dryRun, _ := strconv.ParseBool(ctx.Request.URL.Query().Get("dry_run"))
+	added, removed, err := s.ReplacePolicies(rctx, ns, policies, dryRun)
+	if err != nil {
+		return resourceError(ctx, err)
+	}
+	out := RulesResource{ID: id, Rules: ruleLines(policies), Added: ruleLines(added), Removed: ruleLines(removed)}
+	out.Changed = len(out.Added) > 0 || len(out.Removed) > 0
+	return ctx.StatusCode(http2.StatusOK).JSON(out)
+}
+
+// ruleSets groups rule lines by policy type.
+func ruleSets(lines [][]string) ([]*command.PolicyRules, error) {
+	var out []*command.PolicyRules
+	byType := make(map[string]*command.PolicyRules)
+	for _, line := range lines {
+		if len(line) < 2 || line[0] == "" || (line[0][0] != 'p' && line[0][0] != 'g') {
+			return nil, fmt.Errorf("invalid rule %q, expected the policy type followed by the values", line)
+		}
+		p, ok := byType[line[0]]
+		if !ok {
+			p = &command.PolicyRules{Sec: line[0][:1], PType: line[0]}
+			byType[line[0]] = p
+			out = append(out, p)
+		}
+		p.Rules = append(p.Rules, &command.StringArray{S: line[1:]})
+	}
+	return out, nil
+}
+
+// ruleLines returns the rules of policies as sorted rule lines.
+func ruleLines(policies []*command.PolicyRules) [][]string {
+	lines := [][]string{}
+	for _, p := range policies {
+		for _, rule := range command.ToStringArray(p.Rules) {
+			lines = append(lines, append([]string{p.PType}, rule...))
+		}
+	}
+	sort.Slice(lines, func(i, j int) bool {
+		return strings.Join(lines[i], "\x00") < strings.Join(lines[j], "\x00")
+	})
+	return lines
+}
@@ -0,0 +1,110 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package core_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/casbin/casbin-mesh/pkg/core"
+)
+
+func doJSON(t *testing.T, method, url, body string, out interface{}) *http.Response {
+	req, err := http.NewRequest(method, url, strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("failed to create request: %s", err.Error())
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("failed to %s %s: %s", method, url, err.Error())
+	}
+	defer resp.Body.Close()
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("failed to read response: %s", err.Error())
+	}
+	if out != nil && resp.StatusCode == http.StatusOK {
+		if err := json.Unmarshal(b, out); err != nil {
+			t.Fatalf("failed to unmarshal %s: %s", b, err.Error())
+		}
+	}
+	return resp
+}
+
+func Test_DeclarativeResources(t *testing.T) {
+	ts, _ := newTestServer(t)
+	base := ts.URL + "/namespaces/billing"
+
+	var ns core.NamespaceResource
+	resp := doJSON(t, http.MethodPut, base, `{"labels":{"env":"prod"}}`, &ns)
+	if resp.StatusCode != http.StatusOK || !ns.Changed || ns.Labels["env"] != "prod" {
+		t.Fatalf("expected the namespace created, got %d %+v", resp.StatusCode, ns)
+	}
+	ns = core.NamespaceResource{}
+	doJSON(t, http.MethodPut, base, `{"labels":{"env":"prod"}}`, &ns)
+	if ns.Changed {
+		t.Fatalf("expected a PUT of the same namespace to change nothing")
+	}
+
+	var m core.ModelResource
+	body, _ := json.Marshal(core.PutModelRequest{Text: modelText})
+	if resp := doJSON(t, http.MethodPut, base+"/model", string(body), &m); resp.StatusCode != http.StatusOK || !m.Changed {
+		t.Fatalf("expected the model set, got %d %+v", resp.StatusCode, m)
+	}
+	m = core.ModelResource{}
+	doJSON(t, http.MethodPut, base+"/model", string(body), &m)
+	if m.Changed {
+		t.Fatalf("expected a PUT of the same model to change nothing")
+	}
+
+	var rules core.RulesResource
+	put := `{"rules":[["p","alice","invoices","read"],["p","bob","invoices","write"]]}`
+	doJSON(t, http.MethodPut, base+"/rules?dry_run=true", put, &rules)
+	if !rules.Changed || len(rules.Added) != 2 {
+		t.Fatalf("expected a dry run to report 2 added rules, got %+v", rules)
+	}
+	rules = core.RulesResource{}
+	doJSON(t, http.MethodGet, base+"/rules", "", &rules)
+	if len(rules.Rules) != 0 {
+		t.Fatalf("expected a dry run to change nothing, got %+v", rules)
+	}
+	doJSON(t, http.MethodPut, base+"/rules", put, &rules)
+	rules = core.RulesResource{}
+	doJSON(t, http.MethodPut, base+"/rules", `{"rules":[["p","alice","invoices","read"]]}`, &rules)
+	if len(rules.Added) != 0 || len(rules.Removed) != 1 || rules.Removed[0][1] != "bob" {
+		t.Fatalf("expected bob's rule removed, got %+v", rules)
+	}
+
+	// Namespaces can't be deleted.
+	resp = doJSON(t, http.MethodDelete, base, "", nil)
+	if resp.StatusCode != http.StatusMethodNotAllowed || resp.Header.Get("Allow") != "GET, PUT" {
+		t.Fatalf("expected 405 with Allow: GET, PUT, got %d %q", resp.StatusCode, resp.Header.Get("Allow"))
+	}
+	rules = core.RulesResource{}
+	doJSON(t, http.MethodDelete, base+"/rules", "", &rules)
+	if len(rules.Removed) != 1 {
+		t.Fatalf("expected the rules deleted, got %+v", rules)
+	}
+	resp = doJSON(t, http.MethodGet, ts.URL+"/namespaces/missing/rules", "", nil)
+	if resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("expected 404 for a missing namespace, got %d", resp.StatusCode)
+	}
+}
@@ -0,0 +1,106 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package core
+
+import (
+	"context"
+	"fmt"
+	http2 "net/http"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc"
+
+	"github.com/casbin/casbin-mesh/pkg/handler/http"
+	"github.com/casbin/casbin-mesh/pkg/store"
+)
+
+// maxDrainWait bounds how long a request awaiting the drain of the node
+// waits.
+const maxDrainWait = 5 * time.Minute
+
+// httpRequestClass returns the class of the request, and whether it is
+// tracked while in flight at all.
+func httpRequestClass(r *http2.Request) (store.RequestClass, bool) {
+	switch p := r.URL.Path; {
+	case p == "/enforce", p == "/enforce/namespaces", p == "/forward-auth", strings.HasPrefix(p, "/v1/data/"):
+		return store.EnforceRequest, true
+	case writeEndpoints[p], strings.HasPrefix(p, "/namespaces/") && r.Method != http2.MethodGet:
+		return store.WriteRequest, true
+	}
+	return 0, false
+}
+
+// tracked tracks the Enforce and the write requests while they are served,
+// so that deploys can await the drain of a node.
+func (s *httpService) tracked(ctx *http.Context) error {
+	class, ok := httpRequestClass(ctx.Request)
+	if !ok {
+		return nil
+	}
+	defer s.BeginRequest(ctx.Request.Context(), class)()
+	return ctx.Next()
+}
+
+// grpcRequestClass returns the class of method, and whether it is tracked
+// while in flight at all.
+func grpcRequestClass(method string) (store.RequestClass, bool) {
+	switch method {
+	case "/command.CasbinMesh/Enforce", "/envoy.service.auth.v3.Authorization/Check":
+		return store.EnforceRequest, true
+	case "/command.CasbinMesh/Request":
+		return store.WriteRequest, true
+	}
+	return 0, false
+}
+
+// trackedUnary is the gRPC counterpart of tracked.
+func trackedUnary(core Core) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if class, ok := grpcRequestClass(info.FullMethod); ok {
+			defer core.BeginRequest(ctx, class)()
+		}
+		return handler(ctx, req)
+	}
+}
+
+type DrainResponse struct {
+	Drained  bool                 `json:"drained"`
+	InFlight store.InFlightStatus `json:"in_flight"`
+}
+
+// handleDrain reports the requests served by the node. With a wait
+// parameter, it first waits for the node to serve none anymore, until wait
+// expires; cordon the node beforehand for it to be sent no new requests.
+func (s *httpService) handleDrain(ctx *http.Context) error {
+	if v := ctx.Request.URL.Query().Get("wait"); v != "" {
+		wait, err := time.ParseDuration(v)
+		if err != nil {
+			return fmt.Errorf("invalid wait: %s", v)
+		}
+		if wait > maxDrainWait {
+			wait = maxDrainWait
+		}
+		wctx, cancel := context.WithTimeout(ctx.Request.Context(), wait)
+		defer cancel()
+		// an expired wait is reported as not drained
+		_ = s.AwaitDrain(wctx)
+	}
+	status := s.InFlight(ctx.Request.Context())
+	return ctx.StatusCode(http2.StatusOK).JSON(DrainResponse{Drained: status.Drained(), InFlight: status})
+}
@@ -0,0 +1,112 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package core
+
+import (
+	"io"
+	"io/ioutil"
+	"mime"
+	http2 "net/http"
+	"strings"
+
+	"github.com/casbin/casbin-mesh/pkg/handler/http"
+	"github.com/casbin/casbin-mesh/proto/command"
+	"github.com/golang/protobuf/proto"
+)
+
+// protobufContentType selects the protobuf encoding of /enforce, with the
+// EnforceRequest and EnforceResponse messages of the gRPC API.
+const protobufContentType = "application/x-protobuf"
+
+// maxProtobufRequest bounds the size of the protobuf requests.
+const maxProtobufRequest = 1 << 20
+
+// isProtobuf tells whether contentType is the protobuf one.
+func isProtobuf(contentType string) bool {
+	t, _, err := mime.ParseMediaType(contentType)
+	return err == nil && t == protobufContentType
+}
+
+// accepts tells whether the Accept header of r lists contentType. Protobuf
+// requests without an Accept header are answered in protobuf too.
+func accepts(r *http2.Request, contentType string) bool {
+	accept := r.Header.Get("Accept")
+	if accept == "" {
+		return contentType == protobufContentType && isProtobuf(r.Header.Get("Content-Type"))
+	}
+	for _, t := range strings.Split(accept, ",") {
+		if t, _, err := mime.ParseMediaType(strings.TrimSpace(t)); err == nil && t == contentType {
+			return true
+		}
+	}
+	return false
+}
+
+func readEnforceProtobuf(r *http2.Request) (*command.EnforceRequest, error) {
+	b, err := ioutil.ReadAll(io.LimitReader(r.Body, maxProtobufRequest))
+	if err != nil {
+		return nil, err
+	}
+	var request command.EnforceRequest
+	if err := proto.Unmarshal(b, &request); err != nil {
+		return nil, err
+	}
+	return &request, nil
+}
+
+// handleEnforceProtobuf serves /enforce requests encoded in protobuf, which
+// spares the JSON decoding of the request envelope. The params are JSON
+// encoded one by one, as in the gRPC API. Errors are reported in the error
+// field of the EnforceResponse, with the status the JSON encoding has.
+func (s *httpService) handleEnforceProtobuf(ctx *http.Context) error {
+	request, err := readEnforceProtobuf(ctx.Request)
+	if err != nil {
+		return err
+	}
+	if request.GetNamespace() == "" {
+		return writeEnforceError(ctx, "namespace required")
+	}
+	p := request.GetPayload()
+	cache := s.decisionCache(ctx.Request.Context(), request.GetNamespace())
+	ok, err := s.EnforceWithContext(ctx.Request.Context(), request.GetNamespace(), int32(p.GetLevel()), p.GetFreshness(), p.GetContext(), command.ToInterfaces(p.GetB())...)
+	if err != nil {
+		return writeEnforceError(ctx, err.Error())
+	}
+	if !accepts(ctx.Request, protobufContentType) {
+		return ctx.StatusCode(http2.StatusOK).JSON(EnforceReply{Ok: ok, Cache: cache})
+	}
+	return writeProtobuf(ctx, http2.StatusOK, &command.EnforceResponse{Ok: ok})
+}
+
+func writeEnforceError(ctx *http.Context, msg string) error {
+	if !accepts(ctx.Request, protobufContentType) {
+		return ctx.StatusCode(http2.StatusInternalServerError).JSON(map[string]string{"error": msg})
+	}
+	return writeProtobuf(ctx, http2.StatusInternalServerError, &command.EnforceResponse{Error: msg})
+}
+
+func writeProtobuf(ctx *http.Context, code int, m proto.Message) error {
+	b, err := proto.Marshal(m)
+	if err != nil {
+		return err
+	}
+	ctx.ResponseWriter.Header().Set("Content-Type", protobufContentType)
+	ctx.StatusCode(code)
+	_, err = ctx.ResponseWriter.Write(b)
+	return err
+}
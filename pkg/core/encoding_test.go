@@ -0,0 +1,136 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package core_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"testing"
+
+	"github.com/casbin/casbin-mesh/proto/command"
+	"github.com/golang/protobuf/proto"
+)
+
+func enforceProtobuf(t *testing.T, ns string, params ...string) []byte {
+	var b [][]byte
+	for _, p := range params {
+		v, _ := json.Marshal(p)
+		b = append(b, v)
+	}
+	data, err := proto.Marshal(&command.EnforceRequest{Namespace: ns, Payload: &command.EnforcePayload{B: b}})
+	if err != nil {
+		t.Fatalf("failed to marshal request: %s", err.Error())
+	}
+	return data
+}
+
+func postEnforce(t *testing.T, url, contentType, accept string, body []byte) (*http.Response, []byte) {
+	req, err := http.NewRequest(http.MethodPost, url+"/enforce", bytes.NewReader(body))
+	if err != nil {
+		t.Fatalf("failed to create request: %s", err.Error())
+	}
+	req.Header.Set("Content-Type", contentType)
+	if accept != "" {
+		req.Header.Set("Accept", accept)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("failed to post /enforce: %s", err.Error())
+	}
+	defer resp.Body.Close()
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("failed to read response: %s", err.Error())
+	}
+	return resp, b
+}
+
+func Test_EnforceProtobuf(t *testing.T) {
+	ts, _ := newTestServer(t)
+
+	for _, tt := range []struct {
+		params []string
+		exp    bool
+	}{
+		{[]string{"alice", "data1", "read"}, true},
+		{[]string{"alice", "data1", "write"}, false},
+	} {
+		resp, b := postEnforce(t, ts.URL, "application/x-protobuf", "", enforceProtobuf(t, "default", tt.params...))
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("%v: expected status 200, got %d", tt.params, resp.StatusCode)
+		}
+		if ct := resp.Header.Get("Content-Type"); ct != "application/x-protobuf" {
+			t.Fatalf("%v: expected a protobuf response, got %s", tt.params, ct)
+		}
+		var reply command.EnforceResponse
+		if err := proto.Unmarshal(b, &reply); err != nil {
+			t.Fatalf("%v: failed to unmarshal response: %s", tt.params, err.Error())
+		}
+		if reply.GetOk() != tt.exp || reply.GetError() != "" {
+			t.Fatalf("%v: expected %v, got %+v", tt.params, tt.exp, &reply)
+		}
+	}
+
+	// errors are reported in the response
+	resp, b := postEnforce(t, ts.URL, "application/x-protobuf", "", enforceProtobuf(t, "", "alice", "data1", "read"))
+	var reply command.EnforceResponse
+	if err := proto.Unmarshal(b, &reply); err != nil {
+		t.Fatalf("failed to unmarshal error response: %s", err.Error())
+	}
+	if resp.StatusCode != http.StatusInternalServerError || reply.GetError() == "" {
+		t.Fatalf("expected an error without namespace, got %d %+v", resp.StatusCode, &reply)
+	}
+}
+
+func Test_EnforceNegotiation(t *testing.T) {
+	ts, _ := newTestServer(t)
+
+	// protobuf request, JSON response
+	resp, b := postEnforce(t, ts.URL, "application/x-protobuf", "application/json", enforceProtobuf(t, "default", "alice", "data1", "read"))
+	var reply struct {
+		Ok bool `json:"ok"`
+	}
+	if err := json.Unmarshal(b, &reply); err != nil {
+		t.Fatalf("failed to unmarshal JSON response %q: %s", b, err.Error())
+	}
+	if resp.StatusCode != http.StatusOK || !reply.Ok {
+		t.Fatalf("expected allowed in JSON, got %d %s", resp.StatusCode, b)
+	}
+
+	// JSON request, protobuf response
+	body := []byte(`{"ns":"default","params":["alice","data1","read"]}`)
+	resp, b = postEnforce(t, ts.URL, "application/json", "application/json;q=0.5, application/x-protobuf", body)
+	if ct := resp.Header.Get("Content-Type"); ct != "application/x-protobuf" {
+		t.Fatalf("expected a protobuf response, got %s: %s", ct, b)
+	}
+	var pb command.EnforceResponse
+	if err := proto.Unmarshal(b, &pb); err != nil {
+		t.Fatalf("failed to unmarshal protobuf response: %s", err.Error())
+	}
+	if resp.StatusCode != http.StatusOK || !pb.GetOk() {
+		t.Fatalf("expected allowed in protobuf, got %d %+v", resp.StatusCode, &pb)
+	}
+
+	// JSON request without Accept, JSON response
+	resp, b = postEnforce(t, ts.URL, "application/json", "", body)
+	if err := json.Unmarshal(b, &reply); err != nil || !reply.Ok {
+		t.Fatalf("expected allowed in JSON, got %d %s", resp.StatusCode, b)
+	}
+}
@@ -0,0 +1,114 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package core
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	http2 "net/http"
+	"strings"
+
+	"github.com/casbin/casbin-mesh/pkg/entitlement"
+	"github.com/casbin/casbin-mesh/pkg/handler/http"
+)
+
+// entitlementsFlushEvery is the number of rows written between flushes.
+const entitlementsFlushEvery = 1000
+
+// handleEntitlements streams the entitlement matrix of a namespace, as CSV
+// if format is csv, as a JSON array otherwise.
+func (s *httpService) handleEntitlements(ctx *http.Context, ns string) error {
+	if ctx.Request.Method != http2.MethodGet {
+		return fmt.Errorf("method %s not allowed", ctx.Request.Method)
+	}
+	text, policies, _, err := s.NamespaceSnapshot(ctx.Request.Context(), ns)
+	if err != nil {
+		return err
+	}
+	x, err := entitlement.New(text, policies)
+	if err != nil {
+		return err
+	}
+	w := ctx.ResponseWriter
+	flush := func() {}
+	if f, ok := w.(http2.Flusher); ok {
+		flush = f.Flush
+	}
+	rows := 0
+	wrote := func() {
+		if rows++; rows%entitlementsFlushEvery == 0 {
+			flush()
+		}
+	}
+
+	if ctx.Request.URL.Query().Get("format") == "csv" {
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		ctx.StatusCode(http2.StatusOK)
+		cw := csv.NewWriter(w)
+		header := append(append([]string{"subject"}, x.Columns...), "via")
+		if err := cw.Write(header); err != nil {
+			return err
+		}
+		err = x.Each(func(e entitlement.Entitlement) error {
+			row := append(append([]string{e.Subject}, e.Fields...), strings.Join(e.Via, " > "))
+			if err := cw.Write(row); err != nil {
+				return err
+			}
+			wrote()
+			return nil
+		})
+		cw.Flush()
+		if err != nil {
+			return err
+		}
+		return cw.Error()
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	ctx.StatusCode(http2.StatusOK)
+	if _, err := io.WriteString(w, "["); err != nil {
+		return err
+	}
+	err = x.Each(func(e entitlement.Entitlement) error {
+		row := map[string]interface{}{"subject": e.Subject, "via": e.Via}
+		for i, column := range x.Columns {
+			if i < len(e.Fields) {
+				row[column] = e.Fields[i]
+			}
+		}
+		b, err := json.Marshal(row)
+		if err != nil {
+			return err
+		}
+		if rows > 0 {
+			b = append([]byte(","), b...)
+		}
+		if _, err := w.Write(b); err != nil {
+			return err
+		}
+		wrote()
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(w, "]\n")
+	return err
+}
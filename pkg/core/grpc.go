@@ -23,11 +23,18 @@ import (
 	"errors"
 	"github.com/casbin/casbin-mesh/pkg/auth"
 	grpc2 "github.com/casbin/casbin-mesh/pkg/handler/grpc"
+	"github.com/casbin/casbin-mesh/pkg/store"
 	"github.com/casbin/casbin-mesh/proto/command"
 	_ "github.com/grpc-ecosystem/go-grpc-middleware"
 	grpc_middleware "github.com/grpc-ecosystem/go-grpc-middleware"
+	grpc_recovery "github.com/grpc-ecosystem/go-grpc-middleware/recovery"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
 	"google.golang.org/protobuf/proto"
+	"strconv"
+	"time"
 )
 
 type grpcServer struct {
@@ -56,7 +63,7 @@ func FormatResponse(err error) *command.Response {
 
 func (s grpcServer) Enforce(ctx context.Context, request *command.EnforceRequest) (*command.EnforceResponse, error) {
 	params := command.ToInterfaces(request.Payload.B)
-	result, err := s.Core.Enforce(ctx, request.GetNamespace(), int32(request.Payload.GetLevel()), request.Payload.GetFreshness(), params...)
+	result, err := s.Core.EnforceWithContext(ctx, request.GetNamespace(), int32(request.Payload.GetLevel()), request.Payload.GetFreshness(), request.Payload.GetContext(), params...)
 	if err != nil {
 		return &command.EnforceResponse{
 			Ok:    false,
@@ -88,7 +95,14 @@ func (s grpcServer) Request(ctx context.Context, cmd *command.Command) (*command
 		if err = proto.Unmarshal(cmd.Payload, &p); err != nil {
 			return FormatResponse(UnmarshalFailed), nil
 		}
-		rules, err := s.Core.AddPolicies(ctx, cmd.GetNamespace(), p.GetSec(), p.GetPType(), command.ToStringArray(p.GetRules()))
+		var rules [][]string
+		if p.GetSchedule() != nil {
+			rules, err = s.Core.AddScheduledPolicies(ctx, cmd.GetNamespace(), p.GetSec(), p.GetPType(), command.ToStringArray(p.GetRules()), p.GetSchedule())
+		} else if p.GetExpireAt() != 0 {
+			rules, err = s.Core.AddExpiringPolicies(ctx, cmd.GetNamespace(), p.GetSec(), p.GetPType(), command.ToStringArray(p.GetRules()), time.Unix(p.GetExpireAt(), 0))
+		} else {
+			rules, err = s.Core.AddPolicies(ctx, cmd.GetNamespace(), p.GetSec(), p.GetPType(), command.ToStringArray(p.GetRules()))
+		}
 		if err != nil {
 			return FormatResponse(err), nil
 		}
@@ -130,6 +144,28 @@ func (s grpcServer) Request(ctx context.Context, cmd *command.Command) (*command
 	case command.Type_COMMAND_TYPE_CLEAR_POLICY:
 		err = s.Core.Remove(ctx, cmd.GetNamespace())
 		return FormatResponse(err), nil
+
+	case command.Type_COMMAND_TYPE_ANNOTATE_POLICIES:
+		var p command.AnnotatePoliciesPayload
+		if err = proto.Unmarshal(cmd.Payload, &p); err != nil {
+			return FormatResponse(UnmarshalFailed), nil
+		}
+		rules, err := s.Core.AnnotatePolicies(ctx, cmd.GetNamespace(), p.GetSec(), p.GetPType(), command.ToStringArray(p.GetRules()), p.GetAnnotation())
+		if err != nil {
+			return FormatResponse(err), nil
+		}
+		return &command.Response{EffectedRules: command.NewStringArray(rules)}, nil
+
+	case command.Type_COMMAND_TYPE_SET_READ_ONLY:
+		var p command.SetReadOnlyPayload
+		if err = proto.Unmarshal(cmd.Payload, &p); err != nil {
+			return FormatResponse(UnmarshalFailed), nil
+		}
+		effected, err := s.Core.SetReadOnly(ctx, p.GetEnabled(), p.GetReason())
+		if err != nil {
+			return FormatResponse(err), nil
+		}
+		return &command.Response{Effected: effected}, nil
 	}
 	return nil, nil
 }
@@ -155,8 +191,24 @@ func (s grpcServer) ListPolicies(ctx context.Context, req *command.ListPoliciesR
 	if err != nil {
 		return nil, err
 	}
-	return &command.ListPoliciesResponse{Policies: command.NewStringArray(policies)}, nil
+	annotations, err := s.Core.ListAnnotations(ctx, req.Namespace)
+	if err != nil {
+		return nil, err
+	}
+	return &command.ListPoliciesResponse{Policies: command.NewStringArray(policies), Annotations: annotations}, nil
+
+}
 
+func (s grpcServer) Snapshot(ctx context.Context, req *command.SnapshotRequest) (*command.SnapshotResponse, error) {
+	model, policies, index, err := s.Core.NamespaceSnapshot(ctx, req.Namespace)
+	if err != nil {
+		return nil, err
+	}
+	annotations, err := s.Core.ListAnnotations(ctx, req.Namespace)
+	if err != nil {
+		return nil, err
+	}
+	return &command.SnapshotResponse{Model: model, Policies: policies, Index: index, Annotations: annotations}, nil
 }
 
 func (s grpcServer) ShowStats(ctx context.Context, req *command.StatsRequest) (*command.StatsResponse, error) {
@@ -172,17 +224,157 @@ func (s grpcServer) ShowStats(ctx context.Context, req *command.StatsRequest) (*
 	return &command.StatsResponse{Payload: buf}, nil
 }
 
+func (s grpcServer) Watch(req *command.WatchRequest, stream command.CasbinMesh_WatchServer) error {
+	err := s.Core.Watch(stream.Context(), req.GetNamespace(), req.GetIndex(), stream.Send)
+	switch err {
+	case store.ErrWatchCompacted:
+		return status.Error(codes.OutOfRange, err.Error())
+	case store.ErrWatchLagging:
+		return status.Error(codes.ResourceExhausted, err.Error())
+	}
+	return err
+}
+
 func newServer(core Core) command.CasbinMeshServer {
 	return &grpcServer{core, command.UnimplementedCasbinMeshServer{}}
 }
 
-func NewGrpcService(core Core) *grpc.Server {
-	var interceptors []grpc.UnaryServerInterceptor
+// readYourWritesUnary is the gRPC counterpart of readYourWrites, it reads
+// x-min-raft-index from the incoming metadata and sends x-raft-index back in
+// the header.
+func readYourWritesUnary(core Core) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if md, ok := metadata.FromIncomingContext(ctx); ok {
+			if v := md.Get("x-min-raft-index"); len(v) > 0 {
+				idx, err := strconv.ParseUint(v[0], 10, 64)
+				if err != nil {
+					return nil, status.Errorf(codes.InvalidArgument, "invalid min raft index: %s", v[0])
+				}
+				if err := core.WaitForIndex(ctx, idx); err != nil {
+					return nil, status.Error(codes.DeadlineExceeded, err.Error())
+				}
+			}
+		}
+		ctx, r := store.WithIndexRecorder(ctx)
+		resp, err := handler(ctx, req)
+		if idx := r.Index(); idx != 0 {
+			_ = grpc.SetHeader(ctx, metadata.Pairs("x-raft-index", strconv.FormatUint(idx, 10)))
+		}
+		return resp, err
+	}
+}
+
+// idempotentUnary is the gRPC counterpart of idempotent, it reads the key from
+// the idempotency-key metadata.
+func idempotentUnary(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	keys := md.Get("idempotency-key")
+	if len(keys) == 0 || keys[0] == "" {
+		return handler(ctx, req)
+	}
+	if len(keys[0]) > maxIdempotencyKeyLen {
+		return nil, status.Errorf(codes.InvalidArgument, "idempotency key longer than %d bytes", maxIdempotencyKeyLen)
+	}
+	request := []byte(info.FullMethod + "\n")
+	if m, ok := req.(proto.Message); ok {
+		b, err := proto.MarshalOptions{Deterministic: true}.Marshal(m)
+		if err != nil {
+			return nil, err
+		}
+		request = append(request, b...)
+	}
+	ctx = store.WithIdempotentRequest(ctx, keys[0], request)
+	resp, err := handler(ctx, req)
+	if store.Replayed(ctx) {
+		_ = grpc.SetHeader(ctx, metadata.Pairs("idempotent-replayed", "true"))
+	}
+	return resp, err
+}
+
+// scopedUnary refuses the calls of principals out of their scopes. Calls
+// not addressing a namespace need access to every namespace.
+func scopedUnary(scopes auth.Scopes) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if p := auth.PrincipalFromContext(ctx); p != "" && !scopes.Allowed(p, grpcNamespace(req)) {
+			return nil, status.Error(codes.PermissionDenied, auth.ErrForbidden.Error())
+		}
+		return handler(ctx, req)
+	}
+}
+
+// scopedStream refuses the messages of principals out of their scopes.
+func scopedStream(scopes auth.Scopes) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		p := auth.PrincipalFromContext(ss.Context())
+		if p == "" {
+			return handler(srv, ss)
+		}
+		return handler(srv, &scopedServerStream{ServerStream: ss, scopes: scopes, principal: p})
+	}
+}
+
+type scopedServerStream struct {
+	grpc.ServerStream
+	scopes    auth.Scopes
+	principal string
+}
+
+func (s *scopedServerStream) RecvMsg(m interface{}) error {
+	if err := s.ServerStream.RecvMsg(m); err != nil {
+		return err
+	}
+	if !s.scopes.Allowed(s.principal, grpcNamespace(m)) {
+		return status.Error(codes.PermissionDenied, auth.ErrForbidden.Error())
+	}
+	return nil
+}
+
+// grpcNamespace returns the namespace a request addresses.
+func grpcNamespace(req interface{}) string {
+	if r, ok := req.(interface{ GetNamespace() string }); ok {
+		return r.GetNamespace()
+	}
+	return ""
+}
+
+// NewGrpcService returns the gRPC server of core. The clients presenting a
+// certificate are authenticated by certAuth, if not nil. The calls served at
+// once are bounded by limits, if not nil. The panics serving calls are
+// passed to report, if not nil.
+func NewGrpcService(core Core, timeouts *Timeouts, certAuth *auth.CertAuth, limits *Limits, report func(error)) *grpc.Server {
+	// a panic serving a call fails the call with codes.Internal rather than
+	// crashing the node
+	recovery := grpc_recovery.WithRecoveryHandlerContext(recoverGrpc(report))
+	interceptors := []grpc.UnaryServerInterceptor{grpc_recovery.UnaryServerInterceptor(recovery)}
+	streamInterceptors := []grpc.StreamServerInterceptor{grpc_recovery.StreamServerInterceptor(recovery)}
+	if certAuth != nil {
+		interceptors = append(interceptors, grpc2.CertPrincipal(certAuth.Mapping))
+		streamInterceptors = append(streamInterceptors, grpc2.CertPrincipalStream(certAuth.Mapping))
+	}
 	switch core.AuthType() {
 	case auth.Basic:
-		interceptors = append(interceptors, grpc2.BasicAuthor(core.Check))
+		interceptors = append(interceptors, skipHealthUnary(grpc2.BasicAuthor(core.Check)))
+		streamInterceptors = append(streamInterceptors, skipHealthStream(grpc2.BasicAuthorStream(core.Check)))
+	}
+	if certAuth != nil && certAuth.Scopes != nil {
+		interceptors = append(interceptors, skipHealthUnary(scopedUnary(certAuth.Scopes)))
+		streamInterceptors = append(streamInterceptors, skipHealthStream(scopedStream(certAuth.Scopes)))
+	}
+	interceptors = append(interceptors, cordonedUnary(core))
+	streamInterceptors = append(streamInterceptors, cordonedStream(core))
+	interceptors = append(interceptors, trackedUnary(core))
+	if timeouts != nil {
+		interceptors = append(interceptors, timeoutsUnary(timeouts))
+	}
+	if limits != nil {
+		interceptors = append(interceptors, limitedUnary(limits))
 	}
-	srv := grpc.NewServer(grpc.UnaryInterceptor(grpc_middleware.ChainUnaryServer(interceptors...)))
+	interceptors = append(interceptors, readYourWritesUnary(core), idempotentUnary)
+	srv := grpc.NewServer(
+		grpc.Creds(grpc2.ListenerCredentials()),
+		grpc.UnaryInterceptor(grpc_middleware.ChainUnaryServer(interceptors...)),
+		grpc.StreamInterceptor(grpc_middleware.ChainStreamServer(streamInterceptors...)),
+	)
 	command.RegisterCasbinMeshServer(srv, newServer(core))
 	return srv
 }
@@ -0,0 +1,94 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package core
+
+import (
+	"context"
+	"github.com/casbin/casbin-mesh/proto/command"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"strings"
+	"time"
+)
+
+// healthCheckInterval is how often the serving status is refreshed.
+const healthCheckInterval = time.Second
+
+// healthMethodPrefix is the prefix of the grpc.health.v1 methods, which are
+// served without authentication so standard probes can reach them.
+const healthMethodPrefix = "/grpc.health.v1.Health/"
+
+// RegisterHealthServer registers the grpc.health.v1 service on srv. Both the
+// overall server ("") and the casbin-mesh service report SERVING while the
+// node knows the cluster leader, is not cordoned and its FSM is not stalled,
+// and NOT_SERVING otherwise. The returned function marks every service NOT_SERVING and stops
+// the refresh, it should be called before the server is drained.
+func RegisterHealthServer(srv *grpc.Server, core Core) (shutdown func()) {
+	hs := health.NewServer()
+	healthpb.RegisterHealthServer(srv, hs)
+
+	update := func() {
+		st := healthpb.HealthCheckResponse_NOT_SERVING
+		ctx := context.Background()
+		if core.LeaderAddr() != "" && !core.CordonStatus(ctx).Cordoned && !core.ApplyStatus(ctx).Stalled {
+			st = healthpb.HealthCheckResponse_SERVING
+		}
+		hs.SetServingStatus("", st)
+		hs.SetServingStatus(command.CasbinMesh_ServiceDesc.ServiceName, st)
+	}
+	update()
+
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(healthCheckInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				update()
+			case <-done:
+				return
+			}
+		}
+	}()
+	return func() {
+		close(done)
+		hs.Shutdown()
+	}
+}
+
+// skipHealthUnary bypasses interceptor for health checks.
+func skipHealthUnary(interceptor grpc.UnaryServerInterceptor) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if strings.HasPrefix(info.FullMethod, healthMethodPrefix) {
+			return handler(ctx, req)
+		}
+		return interceptor(ctx, req, info, handler)
+	}
+}
+
+// skipHealthStream bypasses interceptor for health watches.
+func skipHealthStream(interceptor grpc.StreamServerInterceptor) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if strings.HasPrefix(info.FullMethod, healthMethodPrefix) {
+			return handler(srv, ss)
+		}
+		return interceptor(srv, ss, info, handler)
+	}
+}
@@ -18,22 +18,49 @@
 package core
 
 import (
+	"bufio"
 	"bytes"
+	"context"
+	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/casbin/casbin-mesh/pkg/auth"
+	"github.com/casbin/casbin-mesh/pkg/events"
+	"github.com/casbin/casbin-mesh/pkg/extauthz"
 	"github.com/casbin/casbin-mesh/pkg/handler/http"
+	"github.com/casbin/casbin-mesh/pkg/lint"
+	"github.com/casbin/casbin-mesh/pkg/preset"
+	"github.com/casbin/casbin-mesh/pkg/search"
+	"github.com/casbin/casbin-mesh/pkg/simulate"
+	"github.com/casbin/casbin-mesh/pkg/standby"
+	"github.com/casbin/casbin-mesh/pkg/store"
+	"github.com/casbin/casbin-mesh/pkg/template"
+	"github.com/casbin/casbin-mesh/proto/command"
 	"github.com/go-playground/validator"
-	"golang.org/x/net/context"
 	"io"
 	"io/ioutil"
+	"net"
 	http2 "net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
 )
 
 type httpService struct {
 	http.Server
 	Core
 	*validator.Validate
+	timeoutRules *Timeouts
+	scopes       auth.Scopes
+	limits       *Limits
+	// wsOrigins are the origins allowed to open the WebSocket enforce
+	// channel besides the API one.
+	wsOrigins []string
+	// reportError receives the panics serving requests.
+	reportError func(error)
 }
 
 type Middleware func(handlerFunc http.HandlerFunc) http.HandlerFunc
@@ -47,21 +74,44 @@ func chain(outer Middleware, others ...Middleware) Middleware {
 	}
 }
 
-func NewHttpService(core Core) *httpService {
+func NewHttpService(core Core, timeouts *Timeouts) *httpService {
 	httpS := http.New()
 	validate := validator.New()
-	srv := httpService{httpS, core, validate}
+	srv := httpService{Server: httpS, Core: core, Validate: validate, timeoutRules: timeouts}
 	// set response header
 	httpS.Use(setResponseHeader)
+	httpS.Use(srv.recovered)
+	httpS.Use(srv.clock)
 
 	// enable global middleware
 	switch core.AuthType() {
 	case auth.Basic:
 		httpS.Use(http.BasicAuthor(core.Check))
 	}
+	httpS.Use(srv.scoped)
+	httpS.Use(srv.cordoned)
+	httpS.Use(srv.tracked)
+	httpS.Use(srv.timeouts)
+	httpS.Use(srv.limited)
+	httpS.Use(srv.readYourWrites)
+	httpS.Use(idempotent)
 
 	httpS.Handle("/join", srv.handleJoin)
 	httpS.Handle("/remove", srv.handleRemove)
+	httpS.Handle("/transfer-leader", srv.handleTransferLeader)
+	httpS.Handle("/leader", srv.handleLeader)
+	httpS.Handle("/cluster/status", srv.handleClusterStatus)
+	httpS.Handle("/cluster/replication", chain(srv.autoForwardToLeader)(srv.handleReplication))
+	httpS.Handle("/set/node_metadata", chain(srv.autoForwardToLeader)(srv.handleSetNodeMetadata))
+	httpS.Handle("/leader/step-down", chain(srv.autoForwardToLeader)(srv.handleStepDown))
+	httpS.Handle("/cluster/consistency", chain(srv.autoForwardToLeader)(srv.handleConsistency))
+	httpS.Handle("/cluster/clock", srv.handleClock)
+	httpS.Handle("/cluster/join-token", chain(srv.autoForwardToLeader)(srv.handleCreateJoinToken))
+	httpS.Handle("/state/digest", srv.handleStateDigest)
+	httpS.Handle("/snapshot/verify", srv.handleVerifySnapshot)
+	httpS.Handle("/cordon", srv.handleCordon)
+	httpS.Handle("/uncordon", srv.handleUncordon)
+	httpS.Handle("/drain", srv.handleDrain)
 
 	// write
 	httpS.Handle("/create/namespace", chain(srv.autoForwardToLeader)(srv.handleCreateNameSpace))
@@ -74,18 +124,172 @@ func NewHttpService(core Core) *httpService {
 	httpS.Handle("/remove/filtered_policies", chain(srv.autoForwardToLeader)(srv.handleRemoveFilteredPolicy))
 	httpS.Handle("/update/policies", chain(srv.autoForwardToLeader)(srv.handleUpdatePolicies))
 	httpS.Handle("/clear/policy", chain(srv.autoForwardToLeader)(srv.handleClearPolicy))
+	httpS.Handle("/annotate/policies", chain(srv.autoForwardToLeader)(srv.handleAnnotatePolicies))
+	httpS.Handle("/list/annotations", chain(srv.autoForwardToLeader)(srv.handleListAnnotations))
+	httpS.Handle("/simulate/policies", chain(srv.autoForwardToLeader)(srv.handleSimulatePolicies))
+	httpS.Handle("/tag/version", chain(srv.autoForwardToLeader)(srv.handleTagVersion))
+	httpS.Handle("/rollback/policies", chain(srv.autoForwardToLeader)(srv.handleRollbackPolicies))
+	httpS.Handle("/copy/policies", chain(srv.autoForwardToLeader)(srv.handleCopyPolicies(false)))
+	httpS.Handle("/move/policies", chain(srv.autoForwardToLeader)(srv.handleCopyPolicies(true)))
+	httpS.Handle("/rename/subject", chain(srv.autoForwardToLeader)(srv.handleRenameSubject))
+	httpS.Handle("/set/namespace_labels", chain(srv.autoForwardToLeader)(srv.handleSetNamespaceLabels))
+	httpS.Handle("/set/namespace_settings", chain(srv.autoForwardToLeader)(srv.handleSetNamespaceSettings))
+	httpS.Handle("/set/read_only", chain(srv.autoForwardToLeader)(srv.handleSetReadOnly))
+	httpS.Handle("/namespaces", chain(srv.autoForwardToLeader)(srv.handlePageNamespaces))
+	httpS.Handle("/namespaces/", chain(srv.autoForwardToLeader)(srv.handleNamespaceResource))
+	httpS.Handle("/set/template", chain(srv.autoForwardToLeader)(srv.handleSetTemplate))
+	httpS.Handle("/delete/template", chain(srv.autoForwardToLeader)(srv.handleDeleteTemplate))
+	httpS.Handle("/list/templates", chain(srv.autoForwardToLeader)(srv.handleListTemplates))
+	httpS.Handle("/upgrade/template", chain(srv.autoForwardToLeader)(srv.handleUpgradeTemplate))
+	httpS.Handle("/instantiate/template", chain(srv.autoForwardToLeader)(srv.handleInstantiateTemplate))
+	httpS.Handle("/remove/template_instance", chain(srv.autoForwardToLeader)(srv.handleRemoveTemplateInstance))
 
 	// read
 	httpS.Handle("/enforce", srv.handleEnforce)
+	httpS.Handle("/enforce/namespaces", srv.handleEnforceNamespaces)
+	httpS.Handle(enforceWSPath, srv.handleEnforceWS)
+	httpS.Handle("/explain", srv.handleExplain)
+	httpS.Handle("/changes", srv.handleChanges)
+	httpS.Handle("/policy_versions", srv.handlePolicyVersions)
+	httpS.Handle("/list/presets", srv.handleListPresets)
 	httpS.Handle("/stats", srv.handleStats)
+	httpS.Handle("/autoscaling/signals", srv.handleAutoscalingSignals)
 	return &srv
 }
 
+// EnableReload serves /reload, which reloads the configuration of the node
+// with fn.
+func (s *httpService) EnableReload(fn func() error) {
+	s.Handle("/reload", func(ctx *http.Context) error {
+		if err := fn(); err != nil {
+			return err
+		}
+		ctx.StatusCode(http2.StatusOK)
+		return nil
+	})
+}
+
+// EnableCertAuth restricts the clients authenticated by their certificate to
+// the namespaces of their scopes.
+func (s *httpService) EnableCertAuth(ca *auth.CertAuth) {
+	s.scopes = ca.Scopes
+}
+
+// scoped refuses the requests of principals out of their scopes. Requests
+// not addressing a namespace need access to every namespace. The frames of
+// the WebSocket enforce channel are checked one by one.
+func (s *httpService) scoped(ctx *http.Context) error {
+	if s.scopes == nil || ctx.Request.URL.Path == enforceWSPath {
+		return nil
+	}
+	p := auth.PrincipalFromContext(ctx.Request.Context())
+	if p != "" && !s.scopes.Allowed(p, httpNamespace(ctx)) {
+		ctx.StatusCode(http2.StatusForbidden)
+		return auth.ErrForbidden
+	}
+	return nil
+}
+
+// scopedAll refuses the requests addressing every namespace of principals
+// without access to every namespace.
+func (s *httpService) scopedAll(ctx *http.Context) error {
+	if s.scopes == nil {
+		return nil
+	}
+	if p := auth.PrincipalFromContext(ctx.Request.Context()); p != "" && !s.scopes.Allowed(p, "") {
+		ctx.StatusCode(http2.StatusForbidden)
+		return auth.ErrForbidden
+	}
+	return nil
+}
+
+// EnableForwardAuth serves /forward-auth, which answers the forward-auth
+// subrequests of gateways like Traefik or nginx with 200 if z allows the
+// original request, 403 otherwise.
+func (s *httpService) EnableForwardAuth(z *extauthz.Authorizer) {
+	s.Handle("/forward-auth", func(ctx *http.Context) error {
+		ok, err := z.Authorize(ctx.Request.Context(), extauthz.ForwardedAttributes(ctx.Request))
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return ctx.StatusCode(http2.StatusForbidden).JSON(EnforceReply{Ok: false})
+		}
+		return ctx.StatusCode(http2.StatusOK).JSON(EnforceReply{Ok: true})
+	})
+}
+
+// EnableSCIM serves the SCIM service provider h under prefix. Provisioning
+// changes policies, so requests are forwarded to the leader.
+func (s *httpService) EnableSCIM(prefix string, h http2.Handler) {
+	s.Handle(prefix+"/", chain(s.autoForwardToLeader)(func(ctx *http.Context) error {
+		h.ServeHTTP(ctx.ResponseWriter, ctx.Request)
+		return nil
+	}))
+}
+
+// EnableUI serves the admin dashboard h under prefix. Like the requests
+// addressing every namespace, it is only served to principals with access to
+// every namespace.
+func (s *httpService) EnableUI(prefix string, h http2.Handler) {
+	s.Handle(prefix, func(ctx *http.Context) error {
+		if err := s.scopedAll(ctx); err != nil {
+			return err
+		}
+		h.ServeHTTP(ctx.ResponseWriter, ctx.Request)
+		return nil
+	})
+}
+
+// EnableStandby serves the replication status of a standby cluster at
+// /standby/status, and its promotion at /standby/promote. Only the leader
+// replicates, so requests are forwarded to it.
+func (s *httpService) EnableStandby(a *standby.Agent) {
+	s.Handle("/standby/status", chain(s.autoForwardToLeader)(func(ctx *http.Context) error {
+		return ctx.StatusCode(http2.StatusOK).JSON(a.Status())
+	}))
+	s.Handle("/standby/promote", chain(s.autoForwardToLeader)(func(ctx *http.Context) error {
+		if err := a.Promote(ctx.Request.Context()); err != nil {
+			return err
+		}
+		return ctx.StatusCode(http2.StatusOK).JSON(a.Status())
+	}))
+}
+
+// OPARequest is the body of an OPA data API query.
+type OPARequest struct {
+	Input map[string]interface{} `json:"input"`
+}
+
+// OPAReply is the answer to an OPA data API query.
+type OPAReply struct {
+	Result bool `json:"result"`
+}
+
+// EnableOPA serves POST /v1/data/<namespace>/..., answering OPA data API
+// queries by enforcing their input, mapped by m, in the namespace.
+func (s *httpService) EnableOPA(m extauthz.OPAMapping) {
+	s.Handle("/v1/data/", func(ctx *http.Context) error {
+		var request OPARequest
+		if err := s.decode(ctx.Request.Body, &request); err != nil {
+			return err
+		}
+		path := strings.TrimPrefix(ctx.Request.URL.Path, "/v1/data/")
+		ok, err := extauthz.OPADecision(ctx.Request.Context(), s.Core, m, path, request.Input)
+		if err != nil {
+			return err
+		}
+		return ctx.StatusCode(http2.StatusOK).JSON(OPAReply{Result: ok})
+	})
+}
+
 type JoinRequest struct {
 	ID       string            `json:"id" validate:"required"`
 	Addr     string            `json:"addr" validate:"required"`
-	Voter    bool              `json:"voter" validate:"required"`
+	Voter    bool              `json:"voter"`
 	Metadata map[string]string `json:"metadata"`
+	// Token is the join token of the node, shared or single-use.
+	Token string `json:"token,omitempty"`
 }
 
 func setResponseHeader(ctx *http.Context) error {
@@ -93,9 +297,112 @@ func setResponseHeader(ctx *http.Context) error {
 	return nil
 }
 
+// readYourWrites waits until the node has applied the index the request asks
+// for with min-index, and reports the Raft index of the commands the request
+// applied in the X-Raft-Index header.
+func (s *httpService) readYourWrites(ctx *http.Context) error {
+	if v := minIndex(ctx.Request); v != "" {
+		idx, err := strconv.ParseUint(v, 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid min-index: %s", v)
+		}
+		if err := s.WaitForIndex(ctx.Request.Context(), idx); err != nil {
+			return err
+		}
+	}
+	c, r := store.WithIndexRecorder(ctx.Request.Context())
+	ctx.Request = ctx.Request.WithContext(c)
+	ctx.ResponseWriter = &headerWriter{ResponseWriter: ctx.ResponseWriter, set: func(h http2.Header) {
+		if idx := r.Index(); idx != 0 {
+			h.Set("X-Raft-Index", strconv.FormatUint(idx, 10))
+		}
+	}}
+	return nil
+}
+
+func minIndex(r *http2.Request) string {
+	if v := r.URL.Query().Get("min-index"); v != "" {
+		return v
+	}
+	return r.Header.Get("X-Min-Raft-Index")
+}
+
+// maxIdempotencyKeyLen bounds the length of an Idempotency-Key.
+const maxIdempotencyKeyLen = 255
+
+// idempotent deduplicates the writes of requests sent with an Idempotency-Key
+// header, a retry gets the result of the first attempt and the
+// Idempotent-Replayed header.
+func idempotent(ctx *http.Context) error {
+	key := ctx.Request.Header.Get("Idempotency-Key")
+	if key == "" {
+		return nil
+	}
+	if len(key) > maxIdempotencyKeyLen {
+		return fmt.Errorf("idempotency key longer than %d bytes", maxIdempotencyKeyLen)
+	}
+	// Retries are told apart by their request rather than the commands
+	// they apply, which hold values computed on each attempt.
+	body, err := ioutil.ReadAll(ctx.Request.Body)
+	if err != nil {
+		return err
+	}
+	ctx.Request.Body = ioutil.NopCloser(bytes.NewReader(body))
+	request := append([]byte(ctx.Request.Method+" "+ctx.Request.URL.RequestURI()+"\n"), body...)
+	c := store.WithIdempotentRequest(ctx.Request.Context(), key, request)
+	ctx.Request = ctx.Request.WithContext(c)
+	ctx.ResponseWriter = &headerWriter{ResponseWriter: ctx.ResponseWriter, set: func(h http2.Header) {
+		if store.Replayed(c) {
+			h.Set("Idempotent-Replayed", "true")
+		}
+	}}
+	return nil
+}
+
+// headerWriter calls set with the header right before the response is
+// written.
+type headerWriter struct {
+	http2.ResponseWriter
+	set         func(http2.Header)
+	wroteHeader bool
+}
+
+func (w *headerWriter) WriteHeader(code int) {
+	if !w.wroteHeader {
+		w.wroteHeader = true
+		w.set(w.Header())
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *headerWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http2.StatusOK)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *headerWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http2.Flusher); ok {
+		if !w.wroteHeader {
+			w.WriteHeader(http2.StatusOK)
+		}
+		f.Flush()
+	}
+}
+
+// Hijack lets the WebSocket endpoints take over the connection.
+func (w *headerWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := w.ResponseWriter.(http2.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("connection cannot be hijacked")
+	}
+	return h.Hijack()
+}
+
 func (s *httpService) autoForwardToLeader(fn http.HandlerFunc) http.HandlerFunc {
 	return func(c *http.Context) error {
-		if s.IsLeader(context.TODO()) {
+		if s.IsLeader(c.Request.Context()) {
 			return fn(c)
 		} else {
 			schema := "http"
@@ -110,7 +417,18 @@ func (s *httpService) autoForwardToLeader(fn http.HandlerFunc) http.HandlerFunc
 				http2.Error(c.ResponseWriter, err.Error(), http2.StatusInternalServerError)
 			}
 			url := fmt.Sprintf("%s://%s%s", schema, s.LeaderAddr(), c.Request.RequestURI)
-			proxyReq, err := http2.NewRequest(c.Request.Method, url, bytes.NewReader(body))
+			// the forwarded request is canceled along with the incoming one
+			fctx := c.Request.Context()
+			if d := forwardTimeout(fctx); d != 0 {
+				var cancel context.CancelFunc
+				fctx, cancel = context.WithTimeout(fctx, d)
+				defer cancel()
+			}
+			proxyReq, err := http2.NewRequestWithContext(fctx, c.Request.Method, url, bytes.NewReader(body))
+			if err != nil {
+				http2.Error(c.ResponseWriter, err.Error(), http2.StatusInternalServerError)
+				return nil
+			}
 
 			// clone the header
 			proxyReq.Header = make(http2.Header)
@@ -119,12 +437,18 @@ func (s *httpService) autoForwardToLeader(fn http.HandlerFunc) http.HandlerFunc
 			}
 
 			// forward the incoming request to leader
+			sent := time.Now()
 			resp, err := http2.DefaultClient.Do(proxyReq)
 			if err != nil {
 				http2.Error(c.ResponseWriter, err.Error(), http2.StatusBadGateway)
 				return nil
 			}
+			s.observeClock(c.Request.Context(), resp.Header, sent, time.Now())
 			// copy the response
+			for h, val := range resp.Header {
+				c.ResponseWriter.Header()[h] = val
+			}
+			c.ResponseWriter.WriteHeader(resp.StatusCode)
 			_, err = io.Copy(c.ResponseWriter, resp.Body)
 			if err != nil {
 				fmt.Printf("Copy failed:%s", err)
@@ -141,7 +465,12 @@ func (s *httpService) handleJoin(ctx *http.Context) (err error) {
 	if err = s.decode(ctx.Request.Body, &request); err != nil {
 		return
 	}
-	if err = s.Join(context.TODO(), request.ID, request.Addr, request.Voter, request.Metadata); err != nil {
+	if err = s.Join(ctx.Request.Context(), request.ID, request.Addr, request.Voter, request.Metadata, request.Token); err != nil {
+		if errors.Is(err, store.ErrJoinToken) {
+			ctx.StatusCode(http2.StatusForbidden)
+		} else if errors.Is(err, store.ErrClusterMismatch) {
+			ctx.StatusCode(http2.StatusConflict)
+		}
 		return
 	}
 	ctx.StatusCode(http2.StatusOK)
@@ -157,218 +486,1664 @@ func (s *httpService) handleRemove(ctx *http.Context) (err error) {
 	if err = s.decode(ctx.Request.Body, &request); err != nil {
 		return
 	}
-	if err = s.Remove(context.TODO(), request.ID); err != nil {
+	if err = s.Remove(ctx.Request.Context(), request.ID); err != nil {
 		return
 	}
 	ctx.StatusCode(http2.StatusOK)
 	return nil
 }
 
-type CreateNameSpaceRequest struct {
-	NS string `json:"ns" validate:"required"`
+type TransferLeaderRequest struct {
+	ID string `json:"id"`
 }
 
-func (s *httpService) handleCreateNameSpace(ctx *http.Context) (err error) {
-	var request CreateNameSpaceRequest
+func (s *httpService) handleTransferLeader(ctx *http.Context) (err error) {
+	var request TransferLeaderRequest
 	if err = s.decode(ctx.Request.Body, &request); err != nil {
 		return
 	}
-	if err = s.CreateNamespace(context.TODO(), request.NS); err != nil {
+	if err = s.TransferLeadership(ctx.Request.Context(), request.ID); err != nil {
 		return
 	}
 	ctx.StatusCode(http2.StatusOK)
 	return nil
 }
 
-type SetModelFromStringRequest struct {
-	NS   string `json:"ns" validate:"required"`
-	Text string `json:"text" validate:"required"`
+type ClusterNode struct {
+	ID       string            `json:"id"`
+	Addr     string            `json:"addr"`
+	Voter    bool              `json:"voter"`
+	Leader   bool              `json:"leader"`
+	Metadata map[string]string `json:"metadata"`
 }
 
-func (s *httpService) handleSetModelFromString(ctx *http.Context) (err error) {
-	var request SetModelFromStringRequest
-	if err = s.decode(ctx.Request.Body, &request); err != nil {
-		return
+type ClusterStatusResponse struct {
+	// NodeID is the node answering.
+	NodeID string `json:"node_id"`
+	// ClusterID is the ID of the cluster, empty until it is set.
+	ClusterID string        `json:"cluster_id"`
+	Nodes     []ClusterNode `json:"nodes"`
+	// Zones groups the IDs of the nodes by their zone metadata, nodes
+	// without a zone are left out.
+	Zones map[string][]string `json:"zones"`
+	// Versions groups the IDs of the nodes by the version of the binary
+	// they run, nodes which did not record one are grouped under unknown.
+	Versions map[string][]string `json:"versions"`
+	// FSMVersion is the newest FSM schema all the nodes support, commands
+	// introduced later are refused until every node is upgraded.
+	FSMVersion int `json:"fsm_version"`
+	// Skew is set when the nodes run different versions.
+	Skew bool `json:"skew"`
+}
+
+func (s *httpService) handleClusterStatus(ctx *http.Context) error {
+	nodes, err := s.Nodes(ctx.Request.Context())
+	if err != nil {
+		return err
 	}
-	if err = s.SetModelFromString(context.TODO(), request.NS, request.Text); err != nil {
-		return
+	leader := s.LeaderAddr()
+	out := ClusterStatusResponse{NodeID: s.NodeID(), ClusterID: s.ClusterID(), Nodes: []ClusterNode{}, Zones: map[string][]string{},
+		Versions: map[string][]string{}, FSMVersion: store.FSMVersion}
+	for _, n := range nodes {
+		md := n.Metadata
+		if md == nil {
+			md = map[string]string{}
+		}
+		out.Nodes = append(out.Nodes, ClusterNode{ID: n.ID, Addr: n.Addr, Voter: n.Voter, Leader: n.Addr == leader, Metadata: md})
+		if zone := md["zone"]; zone != "" {
+			out.Zones[zone] = append(out.Zones[zone], n.ID)
+		}
+		version := md[store.VersionKey]
+		if version == "" {
+			version = "unknown"
+		}
+		out.Versions[version] = append(out.Versions[version], n.ID)
+		if v := store.MetadataFSMVersion(md); v < out.FSMVersion {
+			out.FSMVersion = v
+		}
 	}
-	ctx.StatusCode(http2.StatusOK)
-	return nil
+	out.Skew = len(out.Versions) > 1
+	return ctx.CacheableJSON(out)
 }
 
-type EnforceRequest struct {
-	NS        string        `json:"ns" validate:"required"`
-	Level     int32         `json:"level"`
-	Freshness int64         `json:"freshness"`
-	Params    []interface{} `json:"params"`
+type FollowerProgress struct {
+	NodeID     string `json:"node_id"`
+	Addr       string `json:"addr"`
+	Voter      bool   `json:"voter"`
+	MatchIndex uint64 `json:"match_index"`
+	Lag        uint64 `json:"lag"`
+	// LastContact is when the leader last heard from the follower, empty if
+	// it didn't since it took leadership.
+	LastContact string                  `json:"last_contact,omitempty"`
+	Snapshot    *store.SnapshotProgress `json:"snapshot,omitempty"`
+	// CatchUp estimates how long the follower takes to catch up, empty if
+	// unknown.
+	CatchUp string `json:"catch_up,omitempty"`
+	// CaughtUp is set when no snapshot is being installed and the follower
+	// is at most max_lag entries behind.
+	CaughtUp bool `json:"caught_up"`
 }
 
-type EnforceReply struct {
-	Ok bool `json:"ok"`
+type ReplicationResponse struct {
+	Followers []FollowerProgress `json:"followers"`
 }
 
-func (s *httpService) handleEnforce(ctx *http.Context) (err error) {
-	var request EnforceRequest
-	var output bool
-	if err = s.decode(ctx.Request.Body, &request); err != nil {
-		return
+func (s *httpService) handleReplication(ctx *http.Context) error {
+	var maxLag uint64
+	if v := ctx.Request.URL.Query().Get("max_lag"); v != "" {
+		var err error
+		if maxLag, err = strconv.ParseUint(v, 10, 64); err != nil {
+			return fmt.Errorf("invalid max_lag: %s", v)
+		}
 	}
-	if output, err = s.Enforce(context.TODO(), request.NS, request.Level, request.Freshness, request.Params...); err != nil {
-		return
+	followers, err := s.Replication(ctx.Request.Context())
+	if err != nil {
+		return err
+	}
+	out := ReplicationResponse{Followers: []FollowerProgress{}}
+	for _, f := range followers {
+		p := FollowerProgress{
+			NodeID:     f.NodeID,
+			Addr:       f.Addr,
+			Voter:      f.Voter,
+			MatchIndex: f.MatchIndex,
+			Lag:        f.Lag,
+			Snapshot:   f.Snapshot,
+			CaughtUp:   f.Snapshot == nil && !f.LastContact.IsZero() && f.Lag <= maxLag,
+		}
+		if !f.LastContact.IsZero() {
+			p.LastContact = f.LastContact.UTC().Format(time.RFC3339)
+		}
+		if f.CatchUp >= 0 {
+			p.CatchUp = f.CatchUp.Round(time.Second).String()
+		}
+		out.Followers = append(out.Followers, p)
 	}
-	return ctx.StatusCode(http2.StatusOK).JSON(EnforceReply{Ok: output})
+	return ctx.StatusCode(http2.StatusOK).JSON(out)
 }
 
-type AddPoliciesRequest struct {
-	NS    string     `json:"ns" validate:"required"`
-	Sec   string     `json:"sec" validate:"required"`
-	PType string     `json:"ptype" validate:"required"`
-	Rules [][]string `json:"rules" validate:"required"`
+// maxChangesWait bounds how long a request for changes waits for new ones.
+const maxChangesWait = 30 * time.Second
+
+// queryNamespaces returns the namespace parameters of q, repeated or
+// comma-separated, which may be patterns.
+func queryNamespaces(q url.Values) []string {
+	var namespaces []string
+	for _, v := range q["namespace"] {
+		for _, ns := range strings.Split(v, ",") {
+			if ns = strings.TrimSpace(ns); ns != "" {
+				namespaces = append(namespaces, ns)
+			}
+		}
+	}
+	return namespaces
 }
 
-type Response struct {
-	Effected      bool       `json:"effected,omitempty"`
-	EffectedRules [][]string `json:"effected_rules,omitempty"`
+type PolicyVersionsResponse struct {
+	Versions map[string]uint64 `json:"versions"`
 }
 
-func (s *httpService) handleAddPolicies(ctx *http.Context) (err error) {
-	var request AddPoliciesRequest
-	if err = s.decode(ctx.Request.Body, &request); err != nil {
-		return
+// handlePolicyVersions serves the policy set versions of the namespaces
+// selected by the namespace parameters, of all namespaces without any, so
+// that clients can poll many namespaces for changes at once. Replies carry
+// an ETag, unchanged versions are answered with 304 Not Modified.
+func (s *httpService) handlePolicyVersions(ctx *http.Context) error {
+	if ctx.Request.Method != http2.MethodGet {
+		return fmt.Errorf("method %s not allowed", ctx.Request.Method)
 	}
-	var rules [][]string
-	if rules, err = s.AddPolicies(context.TODO(), request.NS, request.Sec, request.PType, request.Rules); err != nil {
+	versions, err := s.PolicySetVersions(ctx.Request.Context(), queryNamespaces(ctx.Request.URL.Query()))
+	if err != nil {
 		return err
 	}
-	return ctx.StatusCode(http2.StatusOK).JSON(Response{EffectedRules: rules})
+	return ctx.CacheableJSON(PolicyVersionsResponse{Versions: versions})
 }
 
-type RemovePoliciesRequest struct {
-	NS    string     `json:"ns" validate:"required"`
-	Sec   string     `json:"sec" validate:"required"`
-	PType string     `json:"ptype" validate:"required"`
-	Rules [][]string `json:"rules" validate:"required"`
+func (s *httpService) handleChanges(ctx *http.Context) error {
+	q := ctx.Request.URL.Query()
+	var since uint64
+	if v := q.Get("since"); v != "" {
+		var err error
+		if since, err = strconv.ParseUint(v, 10, 64); err != nil {
+			return fmt.Errorf("invalid since: %s", v)
+		}
+	}
+	var limit int
+	if v := q.Get("limit"); v != "" {
+		var err error
+		if limit, err = strconv.Atoi(v); err != nil {
+			return fmt.Errorf("invalid limit: %s", v)
+		}
+	}
+	namespaces := queryNamespaces(q)
+	var wait time.Duration
+	if v := q.Get("wait"); v != "" {
+		var err error
+		if wait, err = time.ParseDuration(v); err != nil {
+			return fmt.Errorf("invalid wait: %s", v)
+		}
+		if wait > maxChangesWait {
+			wait = maxChangesWait
+		}
+	}
+
+	// Without changes yet, wait for new entries to be applied until wait
+	// expires.
+	wctx, cancel := context.WithTimeout(ctx.Request.Context(), wait)
+	defer cancel()
+	for {
+		feed, err := s.Changes(ctx.Request.Context(), namespaces, since, limit)
+		if err != nil {
+			return err
+		}
+		if len(feed.Changes) > 0 || wctx.Err() != nil {
+			return ctx.StatusCode(http2.StatusOK).JSON(feed)
+		}
+		since = feed.Next
+		if err := s.WaitForIndex(wctx, since+1); err != nil {
+			return ctx.StatusCode(http2.StatusOK).JSON(feed)
+		}
+	}
 }
 
-func (s *httpService) handleRemovePolicies(ctx *http.Context) (err error) {
-	var request RemovePoliciesRequest
+type SetNodeMetadataRequest struct {
+	ID       string            `json:"id" validate:"required"`
+	Metadata map[string]string `json:"metadata" validate:"required"`
+}
+
+func (s *httpService) handleSetNodeMetadata(ctx *http.Context) (err error) {
+	var request SetNodeMetadataRequest
 	if err = s.decode(ctx.Request.Body, &request); err != nil {
 		return
 	}
-	var rules [][]string
-	if rules, err = s.RemovePolicies(context.TODO(), request.NS, request.Sec, request.PType, request.Rules); err != nil {
+	if err = s.SetNodeMetadata(ctx.Request.Context(), request.ID, request.Metadata); err != nil {
 		return
 	}
+	ctx.StatusCode(http2.StatusOK)
+	return nil
+}
 
-	return ctx.StatusCode(http2.StatusOK).JSON(Response{EffectedRules: rules})
+type Leadership struct {
+	NodeID string `json:"node_id"`
+	Addr   string `json:"addr"`
+	Since  string `json:"since,omitempty"`
+	Until  string `json:"until,omitempty"`
+	// HeldFor is how long the node held leadership for, as observed by the
+	// node answering.
+	HeldFor string `json:"held_for,omitempty"`
 }
 
-type RemoveFilteredPolicyRequest struct {
-	NS          string   `json:"ns" validate:"required"`
-	Sec         string   `json:"sec" validate:"required"`
-	PType       string   `json:"ptype" validate:"required"`
-	FieldIndex  int32    `json:"fieldIndex" validate:"required"`
-	FieldValues []string `json:"fieldValues" validate:"required"`
+type LeaderResponse struct {
+	Leadership
+	Term    uint64       `json:"term"`
+	Self    bool         `json:"self"`
+	History []Leadership `json:"history"`
 }
 
-func (s *httpService) handleRemoveFilteredPolicy(ctx *http.Context) (err error) {
-	var request RemoveFilteredPolicyRequest
-	if err = s.decode(ctx.Request.Body, &request); err != nil {
-		return
+func newLeadership(l store.Leadership, now time.Time) Leadership {
+	out := Leadership{NodeID: l.NodeID, Addr: l.Addr}
+	if l.Addr == "" || l.Since.IsZero() {
+		return out
 	}
-	var rules [][]string
-	if rules, err = s.RemoveFilteredPolicy(context.TODO(), request.NS, request.Sec, request.PType, request.FieldIndex, request.FieldValues); err != nil {
-		return
+	out.Since = l.Since.UTC().Format(time.RFC3339)
+	until := now
+	if !l.Until.IsZero() {
+		out.Until = l.Until.UTC().Format(time.RFC3339)
+		until = l.Until
 	}
-	return ctx.StatusCode(http2.StatusOK).JSON(Response{EffectedRules: rules})
+	out.HeldFor = until.Sub(l.Since).Round(time.Second).String()
+	return out
 }
 
-type UpdatePoliciesRequest struct {
-	NS       string     `json:"ns" validate:"required"`
-	Sec      string     `json:"sec" validate:"required"`
-	PType    string     `json:"ptype" validate:"required"`
-	NewRules [][]string `json:"newRules" validate:"required"`
-	OldRules [][]string `json:"oldRules" validate:"required"`
+func (s *httpService) handleLeader(ctx *http.Context) error {
+	info, err := s.Leader(ctx.Request.Context())
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	out := LeaderResponse{
+		Leadership: newLeadership(info.Leadership, now),
+		Term:       info.Term,
+		Self:       s.IsLeader(ctx.Request.Context()),
+		History:    []Leadership{},
+	}
+	for _, l := range info.History {
+		out.History = append(out.History, newLeadership(l, now))
+	}
+	return ctx.StatusCode(http2.StatusOK).JSON(out)
 }
 
-func (s *httpService) handleUpdatePolicies(ctx *http.Context) (err error) {
-	var request UpdatePoliciesRequest
+type StepDownRequest struct {
+	// ID is the node to hand leadership over to, the most up-to-date voter
+	// if empty.
+	ID string `json:"id"`
+}
+
+func (s *httpService) handleStepDown(ctx *http.Context) (err error) {
+	var request StepDownRequest
 	if err = s.decode(ctx.Request.Body, &request); err != nil {
 		return
 	}
-	var effected bool
-	if effected, err = s.UpdatePolicies(context.TODO(), request.NS, request.Sec, request.PType, request.NewRules, request.OldRules); err != nil {
+	if err = s.StepDown(ctx.Request.Context(), request.ID); err != nil {
 		return
 	}
-	return ctx.StatusCode(http2.StatusOK).JSON(Response{Effected: effected})
+	ctx.StatusCode(http2.StatusOK)
+	return nil
 }
 
-type ClearPolicyRequest struct {
+type CreateNameSpaceRequest struct {
 	NS string `json:"ns" validate:"required"`
+	// Preset is the name of the model preset the namespace starts with.
+	Preset string `json:"preset"`
 }
 
-func (s *httpService) handleClearPolicy(ctx *http.Context) (err error) {
-	var request ClearPolicyRequest
+func (s *httpService) handleCreateNameSpace(ctx *http.Context) (err error) {
+	var request CreateNameSpaceRequest
 	if err = s.decode(ctx.Request.Body, &request); err != nil {
 		return
 	}
-	if err = s.ClearPolicy(context.TODO(), request.NS); err != nil {
+	var p preset.Preset
+	if request.Preset != "" {
+		// checked first, not to leave a namespace without model behind
+		if p, err = preset.Get(request.Preset); err != nil {
+			return
+		}
+	}
+	if err = s.CreateNamespace(ctx.Request.Context(), request.NS); err != nil {
 		return
 	}
+	if p.Text != "" {
+		if err = s.SetModelFromString(ctx.Request.Context(), request.NS, p.Text); err != nil {
+			return
+		}
+		return s.replyEffects(ctx, p.Text)
+	}
 	ctx.StatusCode(http2.StatusOK)
-	return
+	return nil
 }
 
-type ListPoliciesRequest struct {
-	NS      string `json:"ns" validate:"required"`
-	Cursor  string `json:"cursor"`
-	Skip    int64  `json:"skip"`
-	Limit   int64  `json:"limit"`
-	Reverse bool   `json:"reverse"`
+type Preset struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Text        string `json:"text"`
 }
 
-func (s *httpService) handleListPolicies(ctx *http.Context) error {
-	var request ListPoliciesRequest
-	if err := s.decode(ctx.Request.Body, &request); err != nil {
-		return err
-	}
-	out, err := s.ListPolicies(context.TODO(), request.NS, request.Cursor, request.Skip, request.Limit, request.Reverse)
-	if err != nil {
-		return err
+type ListPresetsResponse struct {
+	Presets []Preset `json:"presets"`
+}
+
+func (s *httpService) handleListPresets(ctx *http.Context) error {
+	var out ListPresetsResponse
+	for _, p := range preset.List() {
+		out.Presets = append(out.Presets, Preset{Name: p.Name, Description: p.Description, Text: p.Text})
 	}
-	return ctx.StatusCode(http2.StatusOK).JSON(out)
+	return ctx.CacheableJSON(out)
 }
 
-type PrintModelRequest struct {
-	NS string `json:"ns" validate:"required"`
+type SetModelFromStringRequest struct {
+	NS   string `json:"ns" validate:"required"`
+	Text string `json:"text" validate:"required"`
 }
 
-func (s *httpService) handlePrintModel(ctx *http.Context) error {
-	var request PrintModelRequest
-	if err := s.decode(ctx.Request.Body, &request); err != nil {
-		return err
+func (s *httpService) handleSetModelFromString(ctx *http.Context) (err error) {
+	var request SetModelFromStringRequest
+	if err = s.decode(ctx.Request.Body, &request); err != nil {
+		return
 	}
-	out, err := s.PrintModel(context.TODO(), request.NS)
-	if err != nil {
-		return err
+	if err = s.SetModelFromString(ctx.Request.Context(), request.NS, request.Text); err != nil {
+		return
 	}
-	return ctx.StatusCode(http2.StatusOK).JSON(out)
+	return s.replyEffects(ctx, request.Text)
 }
 
-func (s *httpService) handleListNamespace(ctx *http.Context) error {
-	out, err := s.ListNamespaces(context.TODO())
+type EffectsResponse struct {
+	Effects []store.Effect `json:"effects"`
+}
+
+// replyEffects replies with the policy effects of the model text just set.
+func (s *httpService) replyEffects(ctx *http.Context, text string) error {
+	effects, err := store.ModelEffects(text)
 	if err != nil {
 		return err
 	}
-	return ctx.StatusCode(http2.StatusOK).JSON(out)
+	return ctx.StatusCode(http2.StatusOK).JSON(EffectsResponse{Effects: effects})
 }
 
-func (s *httpService) handleStats(ctx *http.Context) error {
-	out, err := s.Stats(context.TODO())
+func (s *httpService) handleNamespaceEffects(ctx *http.Context, ns string) error {
+	if ctx.Request.Method != http2.MethodGet {
+		return fmt.Errorf("method %s not allowed", ctx.Request.Method)
+	}
+	effects, err := s.NamespaceEffects(ctx.Request.Context(), ns)
 	if err != nil {
 		return err
 	}
+	return ctx.CacheableJSON(EffectsResponse{Effects: effects})
+}
+
+type EnforceRequest struct {
+	NS        string        `json:"ns" validate:"required"`
+	Level     int32         `json:"level"`
+	Freshness int64         `json:"freshness"`
+	Params    []interface{} `json:"params"`
+	// Context selects the request, policy, effect and matcher definitions
+	// to enforce with, e.g. r2/p2/e2/m2. Empty fields default to r/p/e/m.
+	Context *EnforceContext `json:"context"`
+}
+
+type EnforceContext struct {
+	RType string `json:"rType"`
+	PType string `json:"pType"`
+	EType string `json:"eType"`
+	MType string `json:"mType"`
+}
+
+type EnforceReply struct {
+	Ok    bool           `json:"ok"`
+	Cache *DecisionCache `json:"cache,omitempty"`
+}
+
+// DecisionCache tells clients how to cache a decision.
+type DecisionCache struct {
+	// Version is the version of the policy set the decision was made with,
+	// cached decisions of an older version are stale.
+	Version uint64 `json:"version"`
+	// TTL is the number of seconds the decision may be cached.
+	TTL int64 `json:"ttl"`
+}
+
+// decisionCache returns the cache hints of ns, nil if it has none. Hints
+// are read before enforcing, so that a decision is never cached under a
+// version newer than the rules it was made with.
+func (s *httpService) decisionCache(ctx context.Context, ns string) *DecisionCache {
+	h, err := s.CacheHints(ctx, ns)
+	if err != nil {
+		return nil
+	}
+	return &DecisionCache{Version: h.Version, TTL: int64(h.TTL / time.Second)}
+}
+
+func (s *httpService) handleEnforce(ctx *http.Context) (err error) {
+	if isProtobuf(ctx.Request.Header.Get("Content-Type")) {
+		return s.handleEnforceProtobuf(ctx)
+	}
+	var request EnforceRequest
+	var output bool
+	if err = s.decode(ctx.Request.Body, &request); err != nil {
+		return
+	}
+	var ec *command.EnforceContext
+	if request.Context != nil {
+		ec = &command.EnforceContext{
+			RType: request.Context.RType,
+			PType: request.Context.PType,
+			EType: request.Context.EType,
+			MType: request.Context.MType,
+		}
+	}
+	cache := s.decisionCache(ctx.Request.Context(), request.NS)
+	if output, err = s.EnforceWithContext(ctx.Request.Context(), request.NS, request.Level, request.Freshness, ec, request.Params...); err != nil {
+		return
+	}
+	if accepts(ctx.Request, protobufContentType) {
+		return writeProtobuf(ctx, http2.StatusOK, &command.EnforceResponse{Ok: output})
+	}
+	return ctx.StatusCode(http2.StatusOK).JSON(EnforceReply{Ok: output, Cache: cache})
+}
+
+type EnforceNamespacesRequest struct {
+	// Namespaces are enforced in order, followed by those matching Selector
+	// which are not listed.
+	Namespaces []string `json:"namespaces"`
+	// Selector selects namespaces by their labels, e.g. "env=prod,team".
+	Selector  string          `json:"selector"`
+	Level     int32           `json:"level"`
+	Freshness int64           `json:"freshness"`
+	Params    []interface{}   `json:"params"`
+	Context   *EnforceContext `json:"context"`
+}
+
+// NamespaceDecision is the decision of a namespace, or the error enforcing
+// in it.
+type NamespaceDecision struct {
+	NS    string `json:"ns"`
+	Ok    bool   `json:"ok"`
+	Error string `json:"error,omitempty"`
+}
+
+type EnforceNamespacesReply struct {
+	Results []NamespaceDecision `json:"results"`
+}
+
+// handleEnforceNamespaces enforces a request in several namespaces, listed
+// or selected by label. A namespace failing to enforce does not fail the
+// others, its error is reported with its result.
+func (s *httpService) handleEnforceNamespaces(ctx *http.Context) (err error) {
+	var request EnforceNamespacesRequest
+	if err = s.decode(ctx.Request.Body, &request); err != nil {
+		return
+	}
+	if len(request.Namespaces) == 0 && request.Selector == "" {
+		return fmt.Errorf("namespaces or selector required")
+	}
+	namespaces := request.Namespaces
+	if request.Selector != "" {
+		matched, err := s.MatchNamespaces(ctx.Request.Context(), request.Selector)
+		if err != nil {
+			return err
+		}
+		namespaces = append(append([]string{}, namespaces...), matched...)
+	}
+	var ec *command.EnforceContext
+	if request.Context != nil {
+		ec = &command.EnforceContext{
+			RType: request.Context.RType,
+			PType: request.Context.PType,
+			EType: request.Context.EType,
+			MType: request.Context.MType,
+		}
+	}
+	out := EnforceNamespacesReply{Results: []NamespaceDecision{}}
+	seen := make(map[string]bool)
+	for _, ns := range namespaces {
+		if seen[ns] {
+			continue
+		}
+		seen[ns] = true
+		d := NamespaceDecision{NS: ns}
+		if d.Ok, err = s.EnforceWithContext(ctx.Request.Context(), ns, request.Level, request.Freshness, ec, request.Params...); err != nil {
+			d.Error = err.Error()
+		}
+		out.Results = append(out.Results, d)
+	}
+	return ctx.StatusCode(http2.StatusOK).JSON(out)
+}
+
+type ExplainRequest struct {
+	NS      string          `json:"ns" validate:"required"`
+	Params  []interface{}   `json:"params"`
+	Context *EnforceContext `json:"context"`
+}
+
+// handleExplain traces how this node enforces a request: the matcher
+// evaluation of every rule, the roles leading to the matched ones, and the
+// effect deciding with them.
+func (s *httpService) handleExplain(ctx *http.Context) (err error) {
+	var request ExplainRequest
+	if err = s.decode(ctx.Request.Body, &request); err != nil {
+		return
+	}
+	var ec *command.EnforceContext
+	if request.Context != nil {
+		ec = &command.EnforceContext{
+			RType: request.Context.RType,
+			PType: request.Context.PType,
+			EType: request.Context.EType,
+			MType: request.Context.MType,
+		}
+	}
+	x, err := s.Explain(ctx.Request.Context(), request.NS, ec, request.Params...)
+	if err != nil {
+		return
+	}
+	return ctx.StatusCode(http2.StatusOK).JSON(x)
+}
+
+type AddPoliciesRequest struct {
+	NS    string     `json:"ns" validate:"required"`
+	Sec   string     `json:"sec" validate:"required"`
+	PType string     `json:"ptype" validate:"required"`
+	Rules [][]string `json:"rules" validate:"required"`
+	// ExpireAt is the Unix time the rules expire at.
+	ExpireAt int64 `json:"expireAt"`
+	// TTL is the duration after which the rules expire, e.g. "8h".
+	TTL string `json:"ttl"`
+	// Schedule restricts when the rules are active.
+	Schedule *Schedule `json:"schedule"`
+	// Annotation is attached to the rules, replacing their annotation.
+	Annotation *Annotation `json:"annotation"`
+}
+
+type Schedule struct {
+	// NotBefore and NotAfter are the Unix times the rules are active
+	// between, 0 leaves the window open.
+	NotBefore int64 `json:"notBefore"`
+	NotAfter  int64 `json:"notAfter"`
+	// Days are the weekdays the rules are active on, e.g. "mon".
+	Days []string `json:"days"`
+	// Hours are the hours the rules are active within, e.g. "09:00-17:00".
+	Hours    string `json:"hours"`
+	Timezone string `json:"timezone"`
+}
+
+type Response struct {
+	Effected      bool       `json:"effected,omitempty"`
+	EffectedRules [][]string `json:"effected_rules,omitempty"`
+}
+
+func (s *httpService) handleAddPolicies(ctx *http.Context) (err error) {
+	var request AddPoliciesRequest
+	if err = s.decode(ctx.Request.Body, &request); err != nil {
+		return
+	}
+	var expireAt time.Time
+	if request.ExpireAt != 0 {
+		expireAt = time.Unix(request.ExpireAt, 0)
+	}
+	if request.TTL != "" {
+		ttl, err := time.ParseDuration(request.TTL)
+		if err != nil {
+			return err
+		}
+		expireAt = time.Now().Add(ttl)
+	}
+	var rules [][]string
+	if request.Schedule != nil {
+		schedule := &command.Schedule{
+			NotBefore: request.Schedule.NotBefore,
+			NotAfter:  request.Schedule.NotAfter,
+			Days:      request.Schedule.Days,
+			Hours:     request.Schedule.Hours,
+			Timezone:  request.Schedule.Timezone,
+		}
+		if schedule.NotAfter == 0 && !expireAt.IsZero() {
+			schedule.NotAfter = expireAt.Unix()
+		}
+		rules, err = s.AddScheduledPolicies(ctx.Request.Context(), request.NS, request.Sec, request.PType, request.Rules, schedule)
+	} else if expireAt.IsZero() {
+		rules, err = s.AddPolicies(ctx.Request.Context(), request.NS, request.Sec, request.PType, request.Rules)
+	} else {
+		rules, err = s.AddExpiringPolicies(ctx.Request.Context(), request.NS, request.Sec, request.PType, request.Rules, expireAt)
+	}
+	if err != nil {
+		return err
+	}
+	if request.Annotation != nil {
+		if _, err = s.AnnotatePolicies(ctx.Request.Context(), request.NS, request.Sec, request.PType, request.Rules, request.Annotation.toCommand()); err != nil {
+			return err
+		}
+	}
+	return ctx.StatusCode(http2.StatusOK).JSON(Response{EffectedRules: rules})
+}
+
+type Annotation struct {
+	Description string            `json:"description,omitempty"`
+	Owner       string            `json:"owner,omitempty"`
+	Ticket      string            `json:"ticket,omitempty"`
+	Labels      map[string]string `json:"labels,omitempty"`
+}
+
+func (a *Annotation) toCommand() *command.Annotation {
+	if a == nil {
+		return nil
+	}
+	return &command.Annotation{Description: a.Description, Owner: a.Owner, Ticket: a.Ticket, Labels: a.Labels}
+}
+
+type PolicyAnnotation struct {
+	Sec        string     `json:"sec"`
+	PType      string     `json:"ptype"`
+	Rule       []string   `json:"rule"`
+	Annotation Annotation `json:"annotation"`
+}
+
+func newPolicyAnnotations(annotations []*command.PolicyAnnotation) []PolicyAnnotation {
+	out := make([]PolicyAnnotation, 0, len(annotations))
+	for _, a := range annotations {
+		out = append(out, PolicyAnnotation{Sec: a.Sec, PType: a.PType, Rule: a.Rule, Annotation: Annotation{
+			Description: a.Annotation.GetDescription(),
+			Owner:       a.Annotation.GetOwner(),
+			Ticket:      a.Annotation.GetTicket(),
+			Labels:      a.Annotation.GetLabels(),
+		}})
+	}
+	return out
+}
+
+type AnnotatePoliciesRequest struct {
+	NS    string     `json:"ns" validate:"required"`
+	Sec   string     `json:"sec" validate:"required"`
+	PType string     `json:"ptype" validate:"required"`
+	Rules [][]string `json:"rules" validate:"required"`
+	// Annotation replaces the annotation of the rules, they are no longer
+	// annotated if it is null.
+	Annotation *Annotation `json:"annotation"`
+}
+
+func (s *httpService) handleAnnotatePolicies(ctx *http.Context) (err error) {
+	var request AnnotatePoliciesRequest
+	if err = s.decode(ctx.Request.Body, &request); err != nil {
+		return
+	}
+	var rules [][]string
+	if rules, err = s.AnnotatePolicies(ctx.Request.Context(), request.NS, request.Sec, request.PType, request.Rules, request.Annotation.toCommand()); err != nil {
+		return
+	}
+	return ctx.StatusCode(http2.StatusOK).JSON(Response{EffectedRules: rules})
+}
+
+type ListAnnotationsRequest struct {
+	NS string `json:"ns" validate:"required"`
+}
+
+func (s *httpService) handleListAnnotations(ctx *http.Context) error {
+	var request ListAnnotationsRequest
+	if err := s.decode(ctx.Request.Body, &request); err != nil {
+		return err
+	}
+	annotations, err := s.ListAnnotations(ctx.Request.Context(), request.NS)
+	if err != nil {
+		return err
+	}
+	return ctx.CacheableJSON(newPolicyAnnotations(annotations))
+}
+
+type SearchResult struct {
+	Sec        string      `json:"sec"`
+	PType      string      `json:"ptype"`
+	Rule       []string    `json:"rule"`
+	Annotation *Annotation `json:"annotation,omitempty"`
+}
+
+type SearchPoliciesResponse struct {
+	Policies   []SearchResult `json:"policies"`
+	Total      int            `json:"total"`
+	NextCursor string         `json:"nextCursor,omitempty"`
+}
+
+// handleNamespaceResource serves the resources of a namespace under
+// /namespaces/<namespace>/.
+func (s *httpService) handleNamespaceResource(ctx *http.Context) error {
+	parts := strings.Split(strings.TrimPrefix(ctx.Request.URL.Path, "/namespaces/"), "/")
+	switch {
+	case len(parts) == 1:
+		return s.handleNamespace(ctx, parts[0])
+	case len(parts) == 2 && parts[1] == "model":
+		return s.handleModel(ctx, parts[0])
+	case len(parts) == 2 && parts[1] == "rules":
+		return s.handleRules(ctx, parts[0])
+	case len(parts) == 3 && parts[1] == "policies" && parts[2] == "search":
+		return s.handleSearchPolicies(ctx, parts[0])
+	case len(parts) == 2 && parts[1] == "policies":
+		return s.handlePagePolicies(ctx, parts[0], "p")
+	case len(parts) == 2 && parts[1] == "grouping_policies":
+		return s.handlePagePolicies(ctx, parts[0], "g")
+	case len(parts) == 2 && parts[1] == "versions":
+		return s.handleListVersions(ctx, parts[0])
+	case len(parts) == 3 && parts[1] == "versions" && parts[2] == "diff":
+		return s.handleDiffVersions(ctx, parts[0])
+	case len(parts) == 3 && parts[1] == "versions":
+		return s.handleGetVersion(ctx, parts[0], parts[2])
+	case len(parts) == 2 && parts[1] == "snapshot":
+		return s.handleNamespaceSnapshot(ctx, parts[0])
+	case len(parts) == 2 && parts[1] == "labels":
+		return s.handleNamespaceLabels(ctx, parts[0])
+	case len(parts) == 2 && parts[1] == "settings":
+		return s.handleNamespaceSettings(ctx, parts[0])
+	case len(parts) == 2 && parts[1] == "effects":
+		return s.handleNamespaceEffects(ctx, parts[0])
+	case len(parts) == 2 && parts[1] == "lint":
+		return s.handleLintPolicies(ctx, parts[0])
+	case len(parts) == 2 && parts[1] == "entitlements":
+		return s.handleEntitlements(ctx, parts[0])
+	case len(parts) == 3 && parts[1] == "subjects":
+		return s.handleRemoveSubject(ctx, parts[0], parts[2])
+	}
+	return fmt.Errorf("unknown resource %s", ctx.Request.URL.Path)
+}
+
+// pageParams parses the limit and the cursor of a page, the cursor is
+// decoded to the key of the last item of the previous page.
+func pageParams(values url.Values) (limit int, after string, err error) {
+	limit = search.DefaultLimit
+	if s := values.Get("limit"); s != "" {
+		if limit, err = strconv.Atoi(s); err != nil || limit <= 0 {
+			return 0, "", fmt.Errorf("invalid limit %q", s)
+		}
+		if limit > search.MaxLimit {
+			limit = search.MaxLimit
+		}
+	}
+	if cursor := values.Get("cursor"); cursor != "" {
+		b, err := base64.RawURLEncoding.DecodeString(cursor)
+		if err != nil {
+			return 0, "", fmt.Errorf("invalid cursor %q", cursor)
+		}
+		after = string(b)
+	}
+	return limit, after, nil
+}
+
+func encodeCursor(key string) string {
+	if key == "" {
+		return ""
+	}
+	return base64.RawURLEncoding.EncodeToString([]byte(key))
+}
+
+type PageNamespacesResponse struct {
+	Namespaces []string `json:"namespaces"`
+	NextCursor string   `json:"nextCursor,omitempty"`
+}
+
+func (s *httpService) handlePageNamespaces(ctx *http.Context) error {
+	if ctx.Request.Method != http2.MethodGet {
+		return fmt.Errorf("method %s not allowed", ctx.Request.Method)
+	}
+	limit, after, err := pageParams(ctx.Request.URL.Query())
+	if err != nil {
+		return err
+	}
+	namespaces, err := s.ListNamespaces(ctx.Request.Context())
+	if err != nil {
+		return err
+	}
+	sort.Strings(namespaces)
+	out := PageNamespacesResponse{Namespaces: []string{}}
+	for _, ns := range namespaces {
+		if ns <= after {
+			continue
+		}
+		if len(out.Namespaces) == limit {
+			out.NextCursor = encodeCursor(out.Namespaces[limit-1])
+			break
+		}
+		out.Namespaces = append(out.Namespaces, ns)
+	}
+	return ctx.StatusCode(http2.StatusOK).JSON(out)
+}
+
+type PagedPolicy struct {
+	PType string   `json:"ptype"`
+	Rule  []string `json:"rule"`
+}
+
+type PagePoliciesResponse struct {
+	Policies   []PagedPolicy `json:"policies"`
+	NextCursor string        `json:"nextCursor,omitempty"`
+}
+
+// handlePagePolicies lists the rules of a section of a namespace, or of a
+// policy type of the section given as ptype.
+func (s *httpService) handlePagePolicies(ctx *http.Context, ns, sec string) error {
+	if ctx.Request.Method != http2.MethodGet {
+		return fmt.Errorf("method %s not allowed", ctx.Request.Method)
+	}
+	limit, after, err := pageParams(ctx.Request.URL.Query())
+	if err != nil {
+		return err
+	}
+	prefix := sec
+	if pType := ctx.Request.URL.Query().Get("ptype"); pType != "" {
+		if !strings.HasPrefix(pType, sec) {
+			return fmt.Errorf("policy type %s is not of section %s", pType, sec)
+		}
+		prefix = pType + "::"
+	}
+	lines, next, err := s.PagePolicies(ctx.Request.Context(), ns, prefix, after, int64(limit))
+	if err != nil {
+		return err
+	}
+	out := PagePoliciesResponse{Policies: make([]PagedPolicy, 0, len(lines)), NextCursor: encodeCursor(next)}
+	for _, line := range lines {
+		out.Policies = append(out.Policies, PagedPolicy{PType: line[0], Rule: line[1:]})
+	}
+	return ctx.CacheableJSON(out)
+}
+
+type NamespaceSnapshotResponse struct {
+	Model    string             `json:"model"`
+	Policies []TemplatePolicies `json:"policies"`
+	// Index is the Raft index the model and the policies were read at.
+	Index uint64 `json:"index"`
+}
+
+func (s *httpService) handleNamespaceSnapshot(ctx *http.Context, ns string) error {
+	if ctx.Request.Method != http2.MethodGet {
+		return fmt.Errorf("method %s not allowed", ctx.Request.Method)
+	}
+	model, policies, index, err := s.NamespaceSnapshot(ctx.Request.Context(), ns)
+	if err != nil {
+		return err
+	}
+	return ctx.StatusCode(http2.StatusOK).JSON(NamespaceSnapshotResponse{Model: model, Policies: fromPolicyRules(policies), Index: index})
+}
+
+func (s *httpService) handleSearchPolicies(ctx *http.Context, ns string) error {
+	if ctx.Request.Method != http2.MethodGet {
+		return fmt.Errorf("method %s not allowed", ctx.Request.Method)
+	}
+	q, err := search.ParseQuery(ctx.Request.URL.Query())
+	if err != nil {
+		return err
+	}
+	_, policies, _, err := s.NamespaceSnapshot(ctx.Request.Context(), ns)
+	if err != nil {
+		return err
+	}
+	annotations, err := s.ListAnnotations(ctx.Request.Context(), ns)
+	if err != nil {
+		return err
+	}
+	page, err := search.Search(policies, annotations, q)
+	if err != nil {
+		return err
+	}
+	out := SearchPoliciesResponse{Policies: make([]SearchResult, 0, len(page.Results)), Total: page.Total, NextCursor: page.NextCursor}
+	for _, r := range page.Results {
+		result := SearchResult{Sec: r.Sec, PType: r.PType, Rule: r.Rule}
+		if a := r.Annotation; a != nil {
+			result.Annotation = &Annotation{Description: a.Description, Owner: a.Owner, Ticket: a.Ticket, Labels: a.Labels}
+		}
+		out.Policies = append(out.Policies, result)
+	}
+	return ctx.CacheableJSON(out)
+}
+
+type RemoveSubjectResponse struct {
+	Removed []store.RemovedRules `json:"removed"`
+}
+
+// handleRemoveSubject removes the rules referencing a subject from a
+// namespace, or from every namespace with all=true.
+func (s *httpService) handleRemoveSubject(ctx *http.Context, ns, sub string) error {
+	if ctx.Request.Method != http2.MethodDelete {
+		return fmt.Errorf("method %s not allowed", ctx.Request.Method)
+	}
+	all, _ := strconv.ParseBool(ctx.Request.URL.Query().Get("all"))
+	if all {
+		if err := s.scopedAll(ctx); err != nil {
+			return err
+		}
+	}
+	removed, err := s.RemoveSubject(ctx.Request.Context(), ns, sub, all)
+	if err != nil {
+		return err
+	}
+	if removed == nil {
+		removed = []store.RemovedRules{}
+	}
+	return ctx.StatusCode(http2.StatusOK).JSON(RemoveSubjectResponse{Removed: removed})
+}
+
+type CopyPoliciesRequest struct {
+	From string `json:"from" validate:"required"`
+	To   string `json:"to" validate:"required"`
+	// Filter selects the rules with the parameters of the policy search,
+	// e.g. {"ptype": "p", "v1": "billing"}. Every rule is selected without.
+	Filter map[string]string `json:"filter"`
+}
+
+type CopyPoliciesResponse struct {
+	Copied []store.CopiedRules `json:"copied"`
+}
+
+// handleCopyPolicies copies the selected rules of a namespace to another,
+// removing them from the first if move.
+func (s *httpService) handleCopyPolicies(move bool) http.HandlerFunc {
+	return func(ctx *http.Context) (err error) {
+		var request CopyPoliciesRequest
+		if err = s.decode(ctx.Request.Body, &request); err != nil {
+			return
+		}
+		filter := url.Values{}
+		for k, v := range request.Filter {
+			filter.Set(k, v)
+		}
+		copied, err := s.CopyPolicies(ctx.Request.Context(), request.From, request.To, filter, move)
+		if err != nil {
+			return
+		}
+		if copied == nil {
+			copied = []store.CopiedRules{}
+		}
+		return ctx.StatusCode(http2.StatusOK).JSON(CopyPoliciesResponse{Copied: copied})
+	}
+}
+
+type RenameSubjectRequest struct {
+	NS   string `json:"ns" validate:"required"`
+	From string `json:"from" validate:"required"`
+	To   string `json:"to" validate:"required"`
+	// All renames the subject in every namespace.
+	All bool `json:"all"`
+}
+
+type RenameSubjectResponse struct {
+	Renamed []store.RenamedRules `json:"renamed"`
+}
+
+// handleRenameSubject renames a subject in the rules referencing it.
+func (s *httpService) handleRenameSubject(ctx *http.Context) (err error) {
+	var request RenameSubjectRequest
+	if err = s.decode(ctx.Request.Body, &request); err != nil {
+		return
+	}
+	if request.All {
+		if err = s.scopedAll(ctx); err != nil {
+			return
+		}
+	}
+	renamed, err := s.RenameSubject(ctx.Request.Context(), request.NS, request.From, request.To, request.All)
+	if err != nil {
+		return
+	}
+	if renamed == nil {
+		renamed = []store.RenamedRules{}
+	}
+	return ctx.StatusCode(http2.StatusOK).JSON(RenameSubjectResponse{Renamed: renamed})
+}
+
+type LintFinding struct {
+	Kind    string   `json:"kind"`
+	Sec     string   `json:"sec"`
+	PType   string   `json:"ptype"`
+	Rule    []string `json:"rule,omitempty"`
+	Subject string   `json:"subject,omitempty"`
+	Message string   `json:"message"`
+}
+
+type LintPoliciesResponse struct {
+	Findings []LintFinding `json:"findings"`
+}
+
+// handleLintPolicies reports the duplicate and shadowed rules, and the
+// orphaned and dangling roles of a namespace.
+func (s *httpService) handleLintPolicies(ctx *http.Context, ns string) error {
+	if ctx.Request.Method != http2.MethodGet {
+		return fmt.Errorf("method %s not allowed", ctx.Request.Method)
+	}
+	text, policies, _, err := s.NamespaceSnapshot(ctx.Request.Context(), ns)
+	if err != nil {
+		return err
+	}
+	findings, err := lint.Run(text, policies)
+	if err != nil {
+		return err
+	}
+	out := LintPoliciesResponse{Findings: make([]LintFinding, 0, len(findings))}
+	for _, f := range findings {
+		out.Findings = append(out.Findings, LintFinding{Kind: string(f.Kind), Sec: f.Sec, PType: f.PType, Rule: f.Rule, Subject: f.Subject, Message: f.Message})
+	}
+	return ctx.CacheableJSON(out)
+}
+
+type PolicyChange struct {
+	Sec   string     `json:"sec" validate:"required"`
+	PType string     `json:"ptype" validate:"required"`
+	Rules [][]string `json:"rules" validate:"required"`
+}
+
+type SimulatePoliciesRequest struct {
+	NS     string         `json:"ns" validate:"required"`
+	Add    []PolicyChange `json:"add" validate:"dive"`
+	Remove []PolicyChange `json:"remove" validate:"dive"`
+	// Requests are the params of the sample requests.
+	Requests [][]interface{} `json:"requests"`
+	// Decisions are decision events, as published to the decision topic.
+	// Those of other namespaces are skipped.
+	Decisions []events.Decision `json:"decisions"`
+}
+
+type SimulatedDecision struct {
+	Request []interface{} `json:"request"`
+	Before  bool          `json:"before"`
+	After   bool          `json:"after"`
+	Error   string        `json:"error,omitempty"`
+}
+
+type SimulatePoliciesResponse struct {
+	Total   int                 `json:"total"`
+	Granted int                 `json:"granted"`
+	Revoked int                 `json:"revoked"`
+	Flipped []SimulatedDecision `json:"flipped"`
+	Failed  []SimulatedDecision `json:"failed,omitempty"`
+}
+
+func newSimulatedDecisions(results []simulate.Result) []SimulatedDecision {
+	out := make([]SimulatedDecision, 0, len(results))
+	for _, r := range results {
+		out = append(out, SimulatedDecision{Request: r.Request, Before: r.Before, After: r.After, Error: r.Error})
+	}
+	return out
+}
+
+func toChanges(in []PolicyChange) []simulate.Change {
+	var out []simulate.Change
+	for _, c := range in {
+		out = append(out, simulate.Change{Sec: c.Sec, PType: c.PType, Rules: c.Rules})
+	}
+	return out
+}
+
+// handleSimulatePolicies reports which decisions of the sample requests the
+// proposed change flips, without applying it.
+func (s *httpService) handleSimulatePolicies(ctx *http.Context) (err error) {
+	var request SimulatePoliciesRequest
+	if err = s.decode(ctx.Request.Body, &request); err != nil {
+		return
+	}
+	requests := request.Requests
+	for _, d := range request.Decisions {
+		if d.Namespace == request.NS {
+			requests = append(requests, d.Request)
+		}
+	}
+	text, policies, _, err := s.NamespaceSnapshot(ctx.Request.Context(), request.NS)
+	if err != nil {
+		return
+	}
+	report, err := simulate.Run(text, policies, simulate.Delta{Add: toChanges(request.Add), Remove: toChanges(request.Remove)}, requests)
+	if err != nil {
+		return
+	}
+	return ctx.StatusCode(http2.StatusOK).JSON(SimulatePoliciesResponse{
+		Total:   report.Total,
+		Granted: report.Granted,
+		Revoked: report.Revoked,
+		Flipped: newSimulatedDecisions(report.Flipped),
+		Failed:  newSimulatedDecisions(report.Failed),
+	})
+}
+
+type RemovePoliciesRequest struct {
+	NS    string     `json:"ns" validate:"required"`
+	Sec   string     `json:"sec" validate:"required"`
+	PType string     `json:"ptype" validate:"required"`
+	Rules [][]string `json:"rules" validate:"required"`
+}
+
+func (s *httpService) handleRemovePolicies(ctx *http.Context) (err error) {
+	var request RemovePoliciesRequest
+	if err = s.decode(ctx.Request.Body, &request); err != nil {
+		return
+	}
+	var rules [][]string
+	if rules, err = s.RemovePolicies(ctx.Request.Context(), request.NS, request.Sec, request.PType, request.Rules); err != nil {
+		return
+	}
+
+	return ctx.StatusCode(http2.StatusOK).JSON(Response{EffectedRules: rules})
+}
+
+type RemoveFilteredPolicyRequest struct {
+	NS          string   `json:"ns" validate:"required"`
+	Sec         string   `json:"sec" validate:"required"`
+	PType       string   `json:"ptype" validate:"required"`
+	FieldIndex  int32    `json:"fieldIndex" validate:"required"`
+	FieldValues []string `json:"fieldValues" validate:"required"`
+}
+
+func (s *httpService) handleRemoveFilteredPolicy(ctx *http.Context) (err error) {
+	var request RemoveFilteredPolicyRequest
+	if err = s.decode(ctx.Request.Body, &request); err != nil {
+		return
+	}
+	var rules [][]string
+	if rules, err = s.RemoveFilteredPolicy(ctx.Request.Context(), request.NS, request.Sec, request.PType, request.FieldIndex, request.FieldValues); err != nil {
+		return
+	}
+	return ctx.StatusCode(http2.StatusOK).JSON(Response{EffectedRules: rules})
+}
+
+type UpdatePoliciesRequest struct {
+	NS       string     `json:"ns" validate:"required"`
+	Sec      string     `json:"sec" validate:"required"`
+	PType    string     `json:"ptype" validate:"required"`
+	NewRules [][]string `json:"newRules" validate:"required"`
+	OldRules [][]string `json:"oldRules" validate:"required"`
+}
+
+func (s *httpService) handleUpdatePolicies(ctx *http.Context) (err error) {
+	var request UpdatePoliciesRequest
+	if err = s.decode(ctx.Request.Body, &request); err != nil {
+		return
+	}
+	var effected bool
+	if effected, err = s.UpdatePolicies(ctx.Request.Context(), request.NS, request.Sec, request.PType, request.NewRules, request.OldRules); err != nil {
+		return
+	}
+	return ctx.StatusCode(http2.StatusOK).JSON(Response{Effected: effected})
+}
+
+type ClearPolicyRequest struct {
+	NS string `json:"ns" validate:"required"`
+}
+
+func (s *httpService) handleClearPolicy(ctx *http.Context) (err error) {
+	var request ClearPolicyRequest
+	if err = s.decode(ctx.Request.Body, &request); err != nil {
+		return
+	}
+	if err = s.ClearPolicy(ctx.Request.Context(), request.NS); err != nil {
+		return
+	}
+	ctx.StatusCode(http2.StatusOK)
+	return
+}
+
+type TagVersionRequest struct {
+	NS  string `json:"ns" validate:"required"`
+	Tag string `json:"tag" validate:"required"`
+	// Version is the version to tag, the current one if 0.
+	Version uint64 `json:"version"`
+}
+
+type TagVersionResponse struct {
+	Version uint64 `json:"version"`
+}
+
+func (s *httpService) handleTagVersion(ctx *http.Context) (err error) {
+	var request TagVersionRequest
+	if err = s.decode(ctx.Request.Body, &request); err != nil {
+		return
+	}
+	// numbers refer to versions
+	if _, err := strconv.ParseUint(request.Tag, 10, 64); err == nil {
+		return fmt.Errorf("invalid tag %s: tags must not be numbers", request.Tag)
+	}
+	version, err := s.TagVersion(ctx.Request.Context(), request.NS, request.Tag, request.Version)
+	if err != nil {
+		return
+	}
+	return ctx.StatusCode(http2.StatusOK).JSON(TagVersionResponse{Version: version})
+}
+
+type RollbackPoliciesRequest struct {
+	NS string `json:"ns" validate:"required"`
+	// Version or Tag selects the version to roll back to.
+	Version uint64 `json:"version"`
+	Tag     string `json:"tag"`
+}
+
+func (s *httpService) handleRollbackPolicies(ctx *http.Context) (err error) {
+	var request RollbackPoliciesRequest
+	if err = s.decode(ctx.Request.Body, &request); err != nil {
+		return
+	}
+	if request.Version == 0 && request.Tag == "" {
+		return fmt.Errorf("version or tag required")
+	}
+	effected, err := s.RollbackPolicies(ctx.Request.Context(), request.NS, request.Version, request.Tag)
+	if err != nil {
+		return
+	}
+	return ctx.StatusCode(http2.StatusOK).JSON(Response{Effected: effected})
+}
+
+type SetNamespaceLabelsRequest struct {
+	NS string `json:"ns" validate:"required"`
+	// Labels replace those of the namespace, empty to remove them.
+	Labels map[string]string `json:"labels"`
+}
+
+type NamespaceLabelsResponse struct {
+	Labels map[string]string `json:"labels"`
+}
+
+func (s *httpService) handleSetNamespaceLabels(ctx *http.Context) (err error) {
+	var request SetNamespaceLabelsRequest
+	if err = s.decode(ctx.Request.Body, &request); err != nil {
+		return
+	}
+	if err = s.SetNamespaceLabels(ctx.Request.Context(), request.NS, request.Labels); err != nil {
+		return
+	}
+	ctx.StatusCode(http2.StatusOK)
+	return nil
+}
+
+func (s *httpService) handleNamespaceLabels(ctx *http.Context, ns string) error {
+	if ctx.Request.Method != http2.MethodGet {
+		return fmt.Errorf("method %s not allowed", ctx.Request.Method)
+	}
+	labels, err := s.NamespaceLabels(ctx.Request.Context(), ns)
+	if err != nil {
+		return err
+	}
+	return ctx.CacheableJSON(NamespaceLabelsResponse{Labels: labels})
+}
+
+type SetNamespaceSettingsRequest struct {
+	NS string `json:"ns" validate:"required"`
+	// Settings replace those of the namespace, empty to remove them.
+	Settings store.NamespaceSettings `json:"settings"`
+}
+
+func (s *httpService) handleSetNamespaceSettings(ctx *http.Context) (err error) {
+	var request SetNamespaceSettingsRequest
+	if err = s.decode(ctx.Request.Body, &request); err != nil {
+		return
+	}
+	if err = s.SetNamespaceSettings(ctx.Request.Context(), request.NS, request.Settings); err != nil {
+		return
+	}
+	ctx.StatusCode(http2.StatusOK)
+	return nil
+}
+
+func (s *httpService) handleNamespaceSettings(ctx *http.Context, ns string) error {
+	if ctx.Request.Method != http2.MethodGet {
+		return fmt.Errorf("method %s not allowed", ctx.Request.Method)
+	}
+	settings, err := s.NamespaceSettings(ctx.Request.Context(), ns)
+	if err != nil {
+		return err
+	}
+	return ctx.CacheableJSON(settings)
+}
+
+type SetReadOnlyRequest struct {
+	Enabled bool `json:"enabled"`
+	// Reason is reported to the writers rejected while read-only.
+	Reason string `json:"reason"`
+}
+
+func (s *httpService) handleSetReadOnly(ctx *http.Context) (err error) {
+	var request SetReadOnlyRequest
+	if err = s.decode(ctx.Request.Body, &request); err != nil {
+		return
+	}
+	effected, err := s.SetReadOnly(ctx.Request.Context(), request.Enabled, request.Reason)
+	if err != nil {
+		return
+	}
+	return ctx.StatusCode(http2.StatusOK).JSON(Response{Effected: effected})
+}
+
+type PolicyVersion struct {
+	Version uint64 `json:"version"`
+	// Time is the Unix time of the change.
+	Time     int64          `json:"time"`
+	Tag      string         `json:"tag,omitempty"`
+	Rules    int            `json:"rules"`
+	Policies []PolicyChange `json:"policies,omitempty"`
+}
+
+type ListVersionsResponse struct {
+	Versions []PolicyVersion `json:"versions"`
+}
+
+type DiffVersionsResponse struct {
+	From    uint64         `json:"from"`
+	To      uint64         `json:"to"`
+	Added   []PolicyChange `json:"added"`
+	Removed []PolicyChange `json:"removed"`
+}
+
+func newPolicyChanges(policies []*command.PolicyRules) []PolicyChange {
+	out := make([]PolicyChange, 0, len(policies))
+	for _, p := range policies {
+		out = append(out, PolicyChange{Sec: p.Sec, PType: p.PType, Rules: command.ToStringArray(p.Rules)})
+	}
+	return out
+}
+
+func newPolicyVersion(v *command.PolicyVersion) PolicyVersion {
+	out := PolicyVersion{Version: v.Version, Time: v.Time / int64(time.Second), Tag: v.Tag}
+	for _, p := range v.Policies {
+		out.Rules += len(p.Rules)
+	}
+	return out
+}
+
+// findVersion returns the version with the number or the tag ref, the latest
+// one if ref is empty.
+func findVersion(versions []*command.PolicyVersion, ref string) (*command.PolicyVersion, error) {
+	if ref == "" && len(versions) > 0 {
+		return versions[len(versions)-1], nil
+	}
+	n, err := strconv.ParseUint(ref, 10, 64)
+	for _, v := range versions {
+		if (err == nil && v.Version == n) || (err != nil && v.Tag == ref) {
+			return v, nil
+		}
+	}
+	return nil, store.ErrVersionNotExist
+}
+
+func (s *httpService) handleListVersions(ctx *http.Context, ns string) error {
+	if ctx.Request.Method != http2.MethodGet {
+		return fmt.Errorf("method %s not allowed", ctx.Request.Method)
+	}
+	versions, err := s.ListVersions(ctx.Request.Context(), ns)
+	if err != nil {
+		return err
+	}
+	out := ListVersionsResponse{Versions: make([]PolicyVersion, 0, len(versions))}
+	for _, v := range versions {
+		out.Versions = append(out.Versions, newPolicyVersion(v))
+	}
+	return ctx.CacheableJSON(out)
+}
+
+func (s *httpService) handleGetVersion(ctx *http.Context, ns, ref string) error {
+	if ctx.Request.Method != http2.MethodGet {
+		return fmt.Errorf("method %s not allowed", ctx.Request.Method)
+	}
+	versions, err := s.ListVersions(ctx.Request.Context(), ns)
+	if err != nil {
+		return err
+	}
+	v, err := findVersion(versions, ref)
+	if err != nil {
+		return err
+	}
+	out := newPolicyVersion(v)
+	out.Policies = newPolicyChanges(v.Policies)
+	return ctx.CacheableJSON(out)
+}
+
+// handleDiffVersions lists the rules added and removed between the versions
+// from and to, to defaults to the current version.
+func (s *httpService) handleDiffVersions(ctx *http.Context, ns string) error {
+	if ctx.Request.Method != http2.MethodGet {
+		return fmt.Errorf("method %s not allowed", ctx.Request.Method)
+	}
+	query := ctx.Request.URL.Query()
+	if query.Get("from") == "" {
+		return fmt.Errorf("from required")
+	}
+	versions, err := s.ListVersions(ctx.Request.Context(), ns)
+	if err != nil {
+		return err
+	}
+	from, err := findVersion(versions, query.Get("from"))
+	if err != nil {
+		return err
+	}
+	to, err := findVersion(versions, query.Get("to"))
+	if err != nil {
+		return err
+	}
+	added, removed := store.DiffPolicies(from.Policies, to.Policies)
+	return ctx.CacheableJSON(DiffVersionsResponse{
+		From:    from.Version,
+		To:      to.Version,
+		Added:   newPolicyChanges(added),
+		Removed: newPolicyChanges(removed),
+	})
+}
+
+type ListPoliciesRequest struct {
+	NS      string `json:"ns" validate:"required"`
+	Cursor  string `json:"cursor"`
+	Skip    int64  `json:"skip"`
+	Limit   int64  `json:"limit"`
+	Reverse bool   `json:"reverse"`
+	// Annotations also returns the annotations of the rules of the namespace.
+	Annotations bool `json:"annotations"`
+}
+
+type ListPoliciesResponse struct {
+	Policies    [][]string         `json:"policies"`
+	Annotations []PolicyAnnotation `json:"annotations"`
+}
+
+func (s *httpService) handleListPolicies(ctx *http.Context) error {
+	var request ListPoliciesRequest
+	if err := s.decode(ctx.Request.Body, &request); err != nil {
+		return err
+	}
+	out, err := s.ListPolicies(ctx.Request.Context(), request.NS, request.Cursor, request.Skip, request.Limit, request.Reverse)
+	if err != nil {
+		return err
+	}
+	if request.Annotations {
+		annotations, err := s.ListAnnotations(ctx.Request.Context(), request.NS)
+		if err != nil {
+			return err
+		}
+		return ctx.CacheableJSON(ListPoliciesResponse{Policies: out, Annotations: newPolicyAnnotations(annotations)})
+	}
+	return ctx.CacheableJSON(out)
+}
+
+type PrintModelRequest struct {
+	NS string `json:"ns" validate:"required"`
+}
+
+func (s *httpService) handlePrintModel(ctx *http.Context) error {
+	var request PrintModelRequest
+	if err := s.decode(ctx.Request.Body, &request); err != nil {
+		return err
+	}
+	out, err := s.PrintModel(ctx.Request.Context(), request.NS)
+	if err != nil {
+		return err
+	}
+	return ctx.CacheableJSON(out)
+}
+
+func (s *httpService) handleListNamespace(ctx *http.Context) error {
+	out, err := s.ListNamespaces(ctx.Request.Context())
+	if err != nil {
+		return err
+	}
+	return ctx.StatusCode(http2.StatusOK).JSON(out)
+}
+
+type TemplatePolicies struct {
+	Sec   string     `json:"sec"`
+	PType string     `json:"ptype"`
+	Rules [][]string `json:"rules"`
+}
+
+func fromPolicyRules(policies []*command.PolicyRules) []TemplatePolicies {
+	out := make([]TemplatePolicies, 0, len(policies))
+	for _, p := range policies {
+		out = append(out, TemplatePolicies{Sec: p.Sec, PType: p.PType, Rules: command.ToStringArray(p.Rules)})
+	}
+	return out
+}
+
+type Template struct {
+	Name     string             `json:"name" validate:"required"`
+	Version  uint64             `json:"version,omitempty"`
+	Params   []string           `json:"params"`
+	Policies []TemplatePolicies `json:"policies" validate:"required"`
+}
+
+type TemplateInstance struct {
+	NS       string             `json:"ns"`
+	Name     string             `json:"name"`
+	Template string             `json:"template"`
+	Version  uint64             `json:"version"`
+	Vars     map[string]string  `json:"vars"`
+	Policies []TemplatePolicies `json:"policies"`
+}
+
+func fromTemplateInstance(inst *command.TemplateInstance) TemplateInstance {
+	return TemplateInstance{NS: inst.Namespace, Name: inst.Name, Template: inst.Template, Version: inst.Version,
+		Vars: inst.Vars, Policies: fromPolicyRules(inst.Policies)}
+}
+
+type SetTemplateReply struct {
+	Version uint64 `json:"version"`
+}
+
+func (s *httpService) handleSetTemplate(ctx *http.Context) error {
+	var request Template
+	if err := s.decode(ctx.Request.Body, &request); err != nil {
+		return err
+	}
+	t := &command.Template{Name: request.Name, Params: request.Params}
+	for _, p := range request.Policies {
+		t.Policies = append(t.Policies, &command.PolicyRules{Sec: p.Sec, PType: p.PType, Rules: command.NewStringArray(p.Rules)})
+	}
+	if err := template.Validate(t); err != nil {
+		return err
+	}
+	version, err := s.SetTemplate(ctx.Request.Context(), t)
+	if err != nil {
+		return err
+	}
+	return ctx.StatusCode(http2.StatusOK).JSON(SetTemplateReply{Version: version})
+}
+
+type DeleteTemplateRequest struct {
+	Name string `json:"name" validate:"required"`
+}
+
+func (s *httpService) handleDeleteTemplate(ctx *http.Context) error {
+	var request DeleteTemplateRequest
+	if err := s.decode(ctx.Request.Body, &request); err != nil {
+		return err
+	}
+	if err := s.DeleteTemplate(ctx.Request.Context(), request.Name); err != nil {
+		return err
+	}
+	ctx.StatusCode(http2.StatusOK)
+	return nil
+}
+
+type ListTemplatesReply struct {
+	Templates []Template         `json:"templates"`
+	Instances []TemplateInstance `json:"instances"`
+}
+
+func (s *httpService) handleListTemplates(ctx *http.Context) error {
+	templates, instances, err := s.ListTemplates(ctx.Request.Context())
+	if err != nil {
+		return err
+	}
+	reply := ListTemplatesReply{Templates: []Template{}, Instances: []TemplateInstance{}}
+	for _, t := range templates {
+		reply.Templates = append(reply.Templates, Template{Name: t.Name, Version: t.Version, Params: t.Params,
+			Policies: fromPolicyRules(t.Policies)})
+	}
+	for _, inst := range instances {
+		reply.Instances = append(reply.Instances, fromTemplateInstance(inst))
+	}
+	return ctx.StatusCode(http2.StatusOK).JSON(reply)
+}
+
+type UpgradeTemplateRequest struct {
+	Name string `json:"name" validate:"required"`
+}
+
+type UpgradeTemplateReply struct {
+	Instances []TemplateInstance `json:"instances"`
+}
+
+func (s *httpService) handleUpgradeTemplate(ctx *http.Context) error {
+	var request UpgradeTemplateRequest
+	if err := s.decode(ctx.Request.Body, &request); err != nil {
+		return err
+	}
+	upgraded, err := template.New(s).Upgrade(ctx.Request.Context(), request.Name)
+	if err != nil {
+		return err
+	}
+	reply := UpgradeTemplateReply{Instances: []TemplateInstance{}}
+	for _, inst := range upgraded {
+		reply.Instances = append(reply.Instances, fromTemplateInstance(inst))
+	}
+	return ctx.StatusCode(http2.StatusOK).JSON(reply)
+}
+
+type InstantiateTemplateRequest struct {
+	NS       string            `json:"ns" validate:"required"`
+	Name     string            `json:"name" validate:"required"`
+	Template string            `json:"template" validate:"required"`
+	Vars     map[string]string `json:"vars"`
+}
+
+func (s *httpService) handleInstantiateTemplate(ctx *http.Context) error {
+	var request InstantiateTemplateRequest
+	if err := s.decode(ctx.Request.Body, &request); err != nil {
+		return err
+	}
+	inst, err := template.New(s).Instantiate(ctx.Request.Context(), request.NS, request.Name, request.Template, request.Vars)
+	if err != nil {
+		return err
+	}
+	return ctx.StatusCode(http2.StatusOK).JSON(fromTemplateInstance(inst))
+}
+
+type RemoveTemplateInstanceRequest struct {
+	NS   string `json:"ns" validate:"required"`
+	Name string `json:"name" validate:"required"`
+}
+
+func (s *httpService) handleRemoveTemplateInstance(ctx *http.Context) error {
+	var request RemoveTemplateInstanceRequest
+	if err := s.decode(ctx.Request.Body, &request); err != nil {
+		return err
+	}
+	if err := template.New(s).Remove(ctx.Request.Context(), request.NS, request.Name); err != nil {
+		return err
+	}
+	ctx.StatusCode(http2.StatusOK)
+	return nil
+}
+
+func (s *httpService) handleStats(ctx *http.Context) error {
+	out, err := s.Stats(ctx.Request.Context())
+	if err != nil {
+		return err
+	}
+	if s.limits != nil {
+		out["limits"] = s.limits.Stats()
+	}
 	return ctx.StatusCode(http2.StatusOK).JSON(out)
 }
 
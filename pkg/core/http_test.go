@@ -0,0 +1,98 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package core_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/casbin/casbin-mesh/pkg/core"
+	"github.com/casbin/casbin-mesh/pkg/testkit"
+)
+
+const modelText = `
+[request_definition]
+r = sub, obj, act
+
+[policy_definition]
+p = sub, obj, act
+
+[role_definition]
+g = _, _
+
+[policy_effect]
+e = some(where (p.eft == allow))
+
+[matchers]
+m = g(r.sub, p.sub) && r.obj == p.obj && r.act == p.act
+`
+
+// newTestServer serves the HTTP API of a single node cluster, whose default
+// namespace allows alice to read data1.
+func newTestServer(t *testing.T) (*httptest.Server, *testkit.Node) {
+	c := testkit.NewCluster(t, 1)
+	node := c.Leader()
+	ctx := context.TODO()
+	if err := node.Core.CreateNamespace(ctx, "default"); err != nil {
+		t.Fatalf("failed to create namespace: %s", err.Error())
+	}
+	if err := node.Core.SetModelFromString(ctx, "default", modelText); err != nil {
+		t.Fatalf("failed to set model: %s", err.Error())
+	}
+	if _, err := node.Core.AddPolicies(ctx, "default", "p", "p", [][]string{{"alice", "data1", "read"}}); err != nil {
+		t.Fatalf("failed to add policies: %s", err.Error())
+	}
+	ts := httptest.NewServer(core.NewHttpService(node.Core, nil))
+	t.Cleanup(ts.Close)
+	return ts, node
+}
+
+func Test_IdempotentTTLRetry(t *testing.T) {
+	ts, _ := newTestServer(t)
+	post := func(body string) *http.Response {
+		req, err := http.NewRequest(http.MethodPost, ts.URL+"/add/policies", strings.NewReader(body))
+		if err != nil {
+			t.Fatalf("failed to create request: %s", err.Error())
+		}
+		req.Header.Set("Idempotency-Key", "ttl-1")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("failed to add policies: %s", err.Error())
+		}
+		resp.Body.Close()
+		return resp
+	}
+
+	body := `{"ns":"default","sec":"p","ptype":"p","rules":[["bob","data2","read"]],"ttl":"1h"}`
+	if resp := post(body); resp.StatusCode != http.StatusOK || resp.Header.Get("Idempotent-Replayed") != "" {
+		t.Fatalf("expected the policies added, got %d", resp.StatusCode)
+	}
+	// The retry computes another expiry, yet is the same request.
+	time.Sleep(1100 * time.Millisecond)
+	if resp := post(body); resp.StatusCode != http.StatusOK || resp.Header.Get("Idempotent-Replayed") != "true" {
+		t.Fatalf("expected the retry replayed, got %d replayed %q", resp.StatusCode, resp.Header.Get("Idempotent-Replayed"))
+	}
+	other := `{"ns":"default","sec":"p","ptype":"p","rules":[["bob","data2","read"]],"ttl":"2h"}`
+	if resp := post(other); resp.StatusCode == http.StatusOK {
+		t.Fatalf("expected another request with the same key refused")
+	}
+}
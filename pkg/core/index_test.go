@@ -0,0 +1,73 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package core_test
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/casbin/casbin-mesh/pkg/core"
+)
+
+func Test_ReadYourWrites(t *testing.T) {
+	ts, node := newTestServer(t)
+
+	resp := doJSON(t, http.MethodPost, ts.URL+"/add/policies",
+		`{"ns":"default","sec":"p","ptype":"p","rules":[["bob","data2","write"]]}`, nil)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected the policies added, got %d", resp.StatusCode)
+	}
+	idx, err := strconv.ParseUint(resp.Header.Get("X-Raft-Index"), 10, 64)
+	if err != nil || idx == 0 {
+		t.Fatalf("expected the applied index in X-Raft-Index, got %q", resp.Header.Get("X-Raft-Index"))
+	}
+
+	enforce := `{"ns":"default","params":["bob","data2","write"]}`
+	var reply core.EnforceReply
+	resp = doJSON(t, http.MethodPost, ts.URL+"/enforce?min-index="+strconv.FormatUint(idx, 10), enforce, &reply)
+	if resp.StatusCode != http.StatusOK || !reply.Ok {
+		t.Fatalf("expected the write seen at min-index %d, got %d %+v", idx, resp.StatusCode, reply)
+	}
+	if resp.Header.Get("X-Raft-Index") != "" {
+		t.Fatalf("expected no X-Raft-Index on a read, got %q", resp.Header.Get("X-Raft-Index"))
+	}
+
+	if resp := doJSON(t, http.MethodPost, ts.URL+"/enforce?min-index=abc", enforce, nil); resp.StatusCode == http.StatusOK {
+		t.Fatalf("expected an invalid min-index refused")
+	}
+
+	// a read at an index not applied yet waits for it, up to the apply timeout
+	node.Store.ApplyTimeout = 200 * time.Millisecond
+	req, err := http.NewRequest(http.MethodPost, ts.URL+"/enforce", strings.NewReader(enforce))
+	if err != nil {
+		t.Fatalf("failed to create request: %s", err.Error())
+	}
+	req.Header.Set("X-Min-Raft-Index", strconv.FormatUint(idx+1000, 10))
+	start := time.Now()
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("failed to enforce: %s", err.Error())
+	}
+	resp.Body.Close()
+	if resp.StatusCode == http.StatusOK || time.Since(start) < node.Store.ApplyTimeout {
+		t.Fatalf("expected the read to wait for the index and time out, got %d after %s", resp.StatusCode, time.Since(start))
+	}
+}
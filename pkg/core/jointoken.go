@@ -0,0 +1,46 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package core
+
+import (
+	"fmt"
+	http2 "net/http"
+	"time"
+
+	"github.com/casbin/casbin-mesh/pkg/handler/http"
+)
+
+// handleCreateJoinToken creates a single-use join token, valid for the
+// duration of the ttl query parameter if set.
+func (s *httpService) handleCreateJoinToken(ctx *http.Context) error {
+	if ctx.Request.Method != http2.MethodPost {
+		return fmt.Errorf("method %s not allowed", ctx.Request.Method)
+	}
+	var ttl time.Duration
+	if v := ctx.Request.URL.Query().Get("ttl"); v != "" {
+		var err error
+		if ttl, err = time.ParseDuration(v); err != nil || ttl <= 0 {
+			return fmt.Errorf("invalid ttl: %s", v)
+		}
+	}
+	token, err := s.CreateJoinToken(ctx.Request.Context(), ttl)
+	if err != nil {
+		return err
+	}
+	return ctx.StatusCode(http2.StatusOK).JSON(token)
+}
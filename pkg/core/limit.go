@@ -0,0 +1,302 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package core
+
+import (
+	"context"
+	"fmt"
+	"github.com/casbin/casbin-mesh/pkg/auth"
+	grpc2 "github.com/casbin/casbin-mesh/pkg/handler/grpc"
+	"github.com/casbin/casbin-mesh/pkg/handler/http"
+	"github.com/casbin/casbin-mesh/pkg/limit"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	http2 "net/http"
+	"strings"
+)
+
+// Limits bound the Enforce and the write requests served at once, and shed
+// the low-priority requests under stress. Shared bounds all of them, Enforce,
+// write and low-priority requests together, serving the waiting requests by
+// priority class. A nil limiter does not limit its class of requests.
+type Limits struct {
+	Enforce    *limit.Limiter
+	Write      *limit.Limiter
+	Shared     *limit.Limiter
+	Shed       *limit.Shedder
+	Priorities *PriorityRules
+}
+
+// Stats returns the counters of the limiters.
+func (l *Limits) Stats() map[string]interface{} {
+	out := make(map[string]interface{})
+	if l.Enforce != nil {
+		out["enforce"] = l.Enforce.Stats()
+	}
+	if l.Write != nil {
+		out["write"] = l.Write.Stats()
+	}
+	if l.Shared != nil {
+		out["shared"] = l.Shared.Stats()
+	}
+	if l.Shed != nil {
+		out["shed"] = l.Shed.Stats()
+	}
+	return out
+}
+
+// retryAfter is the Retry-After, in seconds, of the requests rejected with a
+// full queue.
+const retryAfter = "1"
+
+// writeEndpoints are the HTTP endpoints applying a Raft command.
+var writeEndpoints = map[string]bool{
+	"/create/namespace":         true,
+	"/set/model":                true,
+	"/add/policies":             true,
+	"/remove/policies":          true,
+	"/remove/filtered_policies": true,
+	"/update/policies":          true,
+	"/clear/policy":             true,
+	"/annotate/policies":        true,
+	"/tag/version":              true,
+	"/rollback/policies":        true,
+	"/copy/policies":            true,
+	"/move/policies":            true,
+	"/rename/subject":           true,
+	"/set/namespace_labels":     true,
+	"/set/namespace_settings":   true,
+	"/set/template":             true,
+	"/delete/template":          true,
+	"/upgrade/template":         true,
+	"/instantiate/template":     true,
+	"/remove/template_instance": true,
+}
+
+// lowPriorityEndpoints are the HTTP endpoints listing or exporting data, shed
+// under stress.
+var lowPriorityEndpoints = map[string]bool{
+	"/list/namespaces":     true,
+	"/list/policies":       true,
+	"/list/annotations":    true,
+	"/list/templates":      true,
+	"/print/model":         true,
+	"/simulate/policies":   true,
+	"/namespaces":          true,
+	"/state/digest":        true,
+	"/cluster/consistency": true,
+}
+
+// PriorityRules assign priority classes to requests by endpoint, principal
+// or both. They are parsed from "<scope>=<class>[,<scope>=<class>...]", where
+// a scope is an endpoint starting with "/", like /enforce or
+// /command.CasbinMesh/Request, a principal, or both, like importer/add/policies.
+type PriorityRules struct {
+	scoped map[string]limit.Priority
+}
+
+// ParsePriorityRules parses spec, e.g. "/enforce=high,importer=low,auditor=low".
+func ParsePriorityRules(spec string) (*PriorityRules, error) {
+	r := &PriorityRules{scoped: make(map[string]limit.Priority)}
+	if strings.TrimSpace(spec) == "" {
+		return r, nil
+	}
+	for _, item := range strings.Split(spec, ",") {
+		kv := strings.SplitN(strings.TrimSpace(item), "=", 2)
+		if len(kv) != 2 || kv[0] == "" {
+			return nil, fmt.Errorf("invalid priority class %q, expected <scope>=<class>", item)
+		}
+		p, err := limit.ParsePriority(kv[1])
+		if err != nil {
+			return nil, err
+		}
+		r.scoped[kv[0]] = p
+	}
+	return r, nil
+}
+
+// Lookup returns the priority class of the requests of principal to
+// endpoint. The most specific scope wins: principal and endpoint, endpoint,
+// principal, then normal.
+func (r *PriorityRules) Lookup(principal, endpoint string) limit.Priority {
+	if r == nil {
+		return limit.Normal
+	}
+	if principal != "" {
+		if p, ok := r.scoped[principal+endpoint]; ok {
+			return p
+		}
+	}
+	if p, ok := r.scoped[endpoint]; ok {
+		return p
+	}
+	if principal != "" {
+		if p, ok := r.scoped[principal]; ok {
+			return p
+		}
+	}
+	return limit.Normal
+}
+
+// acquire takes a slot of class, then of the shared limiter, with the
+// priority of ctx. Low-priority requests are shed first under stress.
+func (l *Limits) acquire(ctx context.Context, class *limit.Limiter) (release func(), err error) {
+	if limit.PriorityFromContext(ctx) == limit.Low {
+		if err := l.Shed.Allow(); err != nil {
+			return nil, err
+		}
+	}
+	releaseClass, err := class.Acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	releaseShared, err := l.Shared.Acquire(ctx)
+	if err != nil {
+		releaseClass()
+		return nil, err
+	}
+	return func() {
+		releaseShared()
+		releaseClass()
+	}, nil
+}
+
+// EnableLimits bounds the Enforce and the write requests served at once.
+func (s *httpService) EnableLimits(l *Limits) {
+	s.limits = l
+}
+
+// httpLimiter returns the limiter of the request, nil if its class of
+// requests is not limited, and whether it is limited at all.
+func (l *Limits) httpLimiter(r *http2.Request) (*limit.Limiter, bool) {
+	switch p := r.URL.Path; {
+	case p == "/enforce", p == "/enforce/namespaces", p == "/forward-auth", strings.HasPrefix(p, "/v1/data/"):
+		return l.Enforce, true
+	case writeEndpoints[p], strings.HasPrefix(p, "/namespaces/") && r.Method != http2.MethodGet:
+		return l.Write, true
+	case lowPriorityEndpoints[p], strings.HasPrefix(p, "/namespaces/"):
+		return nil, true
+	}
+	return nil, false
+}
+
+// httpPriority returns the priority class of the request. The requests
+// listing or exporting data are low-priority unless classed otherwise.
+func (l *Limits) httpPriority(r *http2.Request) limit.Priority {
+	principal := auth.PrincipalFromContext(r.Context())
+	if principal == "" {
+		principal, _, _ = r.BasicAuth()
+	}
+	p := l.Priorities.Lookup(principal, r.URL.Path)
+	if p == limit.Normal && (lowPriorityEndpoints[r.URL.Path] || strings.HasPrefix(r.URL.Path, "/namespaces/") && r.Method == http2.MethodGet) {
+		return limit.Low
+	}
+	return p
+}
+
+// limited waits for a slot before serving the request. Requests rejected
+// with a full queue are answered 429 Too Many Requests, those which waited
+// for too long, or low-priority ones shed, 503 Service Unavailable. The
+// frames of the WebSocket enforce channel are bounded by the channel itself.
+func (s *httpService) limited(ctx *http.Context) error {
+	if s.limits == nil {
+		return nil
+	}
+	class, ok := s.limits.httpLimiter(ctx.Request)
+	if !ok {
+		return nil
+	}
+	c := limit.WithPriority(ctx.Request.Context(), s.limits.httpPriority(ctx.Request))
+	release, err := s.limits.acquire(c, class)
+	switch err {
+	case nil:
+	case limit.ErrQueueFull:
+		ctx.ResponseWriter.Header().Set("Retry-After", retryAfter)
+		ctx.StatusCode(http2.StatusTooManyRequests)
+		return err
+	case limit.ErrShed:
+		ctx.ResponseWriter.Header().Set("Retry-After", retryAfter)
+		ctx.StatusCode(http2.StatusServiceUnavailable)
+		return err
+	default:
+		ctx.StatusCode(http2.StatusServiceUnavailable)
+		return err
+	}
+	defer release()
+	ctx.Request = ctx.Request.WithContext(c)
+	return ctx.Next()
+}
+
+// grpcLimiter returns the limiter of method, nil if its class of calls is
+// not limited, and whether it is limited at all.
+func (l *Limits) grpcLimiter(method string) (*limit.Limiter, bool) {
+	switch method {
+	case "/command.CasbinMesh/Enforce", "/envoy.service.auth.v3.Authorization/Check":
+		return l.Enforce, true
+	case "/command.CasbinMesh/Request":
+		return l.Write, true
+	}
+	return nil, grpcLowPriority[method]
+}
+
+// grpcLowPriority are the methods listing or exporting data, shed under
+// stress.
+var grpcLowPriority = map[string]bool{
+	"/command.CasbinMesh/ListNamespaces": true,
+	"/command.CasbinMesh/ListPolicies":   true,
+	"/command.CasbinMesh/PrintModel":     true,
+	"/command.CasbinMesh/Snapshot":       true,
+}
+
+// grpcPriority returns the priority class of a call. The methods listing or
+// exporting data are low-priority unless classed otherwise.
+func (l *Limits) grpcPriority(ctx context.Context, method string) limit.Priority {
+	principal := auth.PrincipalFromContext(ctx)
+	if principal == "" {
+		principal = grpc2.Username(ctx)
+	}
+	p := l.Priorities.Lookup(principal, method)
+	if p == limit.Normal && grpcLowPriority[method] {
+		return limit.Low
+	}
+	return p
+}
+
+// limitedUnary waits for a slot before serving the call. Calls rejected
+// with a full queue fail with ResourceExhausted, those which waited for too
+// long, or low-priority ones shed, with Unavailable.
+func limitedUnary(l *Limits) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		class, ok := l.grpcLimiter(info.FullMethod)
+		if !ok {
+			return handler(ctx, req)
+		}
+		ctx = limit.WithPriority(ctx, l.grpcPriority(ctx, info.FullMethod))
+		release, err := l.acquire(ctx, class)
+		switch err {
+		case nil:
+		case limit.ErrQueueFull:
+			return nil, status.Error(codes.ResourceExhausted, err.Error())
+		default:
+			return nil, status.Error(codes.Unavailable, err.Error())
+		}
+		defer release()
+		return handler(ctx, req)
+	}
+}
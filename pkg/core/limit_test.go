@@ -0,0 +1,85 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package core_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/casbin/casbin-mesh/pkg/core"
+	"github.com/casbin/casbin-mesh/pkg/limit"
+)
+
+func Test_LimitedRequests(t *testing.T) {
+	_, node := newTestServer(t)
+	limits := &core.Limits{
+		Enforce: limit.New(limit.Config{Concurrency: 1}),
+		Write:   limit.New(limit.Config{Concurrency: 1, Queue: 1, MaxWait: 50 * time.Millisecond}),
+		Shed: limit.NewShedder(0, limit.Threshold{
+			Name:   "test",
+			Signal: func() time.Duration { return time.Second },
+			Max:    time.Millisecond,
+		}),
+	}
+	srv := core.NewHttpService(node.Core, nil)
+	srv.EnableLimits(limits)
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+
+	enforce := `{"ns":"default","params":["alice","data1","read"]}`
+	if resp := doJSON(t, http.MethodPost, ts.URL+"/enforce", enforce, nil); resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected enforce served with a free slot, got %d", resp.StatusCode)
+	}
+
+	// with the only slot taken and no queue, enforce is rejected at once
+	release, err := limits.Enforce.Acquire(context.TODO())
+	if err != nil {
+		t.Fatalf("failed to acquire: %s", err.Error())
+	}
+	resp := doJSON(t, http.MethodPost, ts.URL+"/enforce", enforce, nil)
+	if resp.StatusCode != http.StatusTooManyRequests || resp.Header.Get("Retry-After") == "" {
+		t.Fatalf("expected 429 with Retry-After, got %d %q", resp.StatusCode, resp.Header.Get("Retry-After"))
+	}
+	release()
+
+	// a write waiting in the queue for longer than MaxWait gives up
+	release, err = limits.Write.Acquire(context.TODO())
+	if err != nil {
+		t.Fatalf("failed to acquire: %s", err.Error())
+	}
+	add := `{"ns":"default","sec":"p","ptype":"p","rules":[["bob","data2","write"]]}`
+	if resp := doJSON(t, http.MethodPost, ts.URL+"/add/policies", add, nil); resp.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("expected 503 for a write timing out in the queue, got %d", resp.StatusCode)
+	}
+	release()
+	if resp := doJSON(t, http.MethodPost, ts.URL+"/add/policies", add, nil); resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected the write served once the slot is free, got %d", resp.StatusCode)
+	}
+
+	// low-priority reads are shed under stress, enforce is not
+	resp = doJSON(t, http.MethodPost, ts.URL+"/list/policies", `{"ns":"default"}`, nil)
+	if resp.StatusCode != http.StatusServiceUnavailable || resp.Header.Get("Retry-After") == "" {
+		t.Fatalf("expected 503 with Retry-After for a shed read, got %d", resp.StatusCode)
+	}
+	if resp := doJSON(t, http.MethodPost, ts.URL+"/enforce", enforce, nil); resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected enforce not shed, got %d", resp.StatusCode)
+	}
+}
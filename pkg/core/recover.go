@@ -0,0 +1,74 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package core
+
+import (
+	"context"
+	"errors"
+	"log"
+	http2 "net/http"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/casbin/casbin-mesh/pkg/errreport"
+	"github.com/casbin/casbin-mesh/pkg/handler/http"
+)
+
+// errInternal answers the requests whose handler panicked.
+var errInternal = errors.New("internal error")
+
+// EnableErrorReport passes the panics serving requests to report.
+func (s *httpService) EnableErrorReport(report func(error)) {
+	s.reportError = report
+}
+
+// recovered answers 500 Internal Server Error to the requests whose handler
+// panics, rather than dropping the connection, and reports the panic.
+func (s *httpService) recovered(ctx *http.Context) (err error) {
+	defer func() {
+		p := recover()
+		if p == nil {
+			return
+		}
+		if p == http2.ErrAbortHandler {
+			panic(p)
+		}
+		recoverPanic(errreport.Recovered("serving "+ctx.Request.URL.Path, p), s.reportError)
+		err = errInternal
+	}()
+	return ctx.Next()
+}
+
+// recoverGrpc returns the recovery handler of the gRPC calls, which reports
+// their panics.
+func recoverGrpc(report func(error)) func(ctx context.Context, p interface{}) error {
+	return func(ctx context.Context, p interface{}) error {
+		method, _ := grpc.Method(ctx)
+		recoverPanic(errreport.Recovered("serving "+method, p), report)
+		return status.Error(codes.Internal, errInternal.Error())
+	}
+}
+
+func recoverPanic(p *errreport.PanicError, report func(error)) {
+	log.Printf("%s\n%s", p.Error(), p.Stack)
+	if report != nil {
+		report(p)
+	}
+}
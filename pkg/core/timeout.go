@@ -0,0 +1,209 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package core
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/casbin/casbin-mesh/pkg/handler/http"
+	"github.com/casbin/casbin-mesh/pkg/store"
+	"github.com/casbin/casbin-mesh/proto/command"
+	"github.com/golang/protobuf/proto"
+	"google.golang.org/grpc"
+	"io"
+	"io/ioutil"
+	http2 "net/http"
+	"strings"
+	"time"
+)
+
+// TimeoutRules are timeouts scoped by namespace and endpoint. They are parsed
+// from "<default>[,<scope>=<timeout>...]", where a scope is a namespace, an
+// endpoint starting with "/", like /enforce or /command.CasbinMesh/Enforce, or
+// both, like tenant-a/enforce.
+type TimeoutRules struct {
+	Default time.Duration
+	scoped  map[string]time.Duration
+	// byNamespace is set if some scopes name a namespace.
+	byNamespace bool
+}
+
+// ParseTimeoutRules parses spec, a default timeout followed by the scoped
+// ones, e.g. "10s,/enforce=200ms,tenant-a=30s,tenant-a/enforce=1s".
+func ParseTimeoutRules(spec string) (*TimeoutRules, error) {
+	items := strings.Split(spec, ",")
+	d, err := time.ParseDuration(strings.TrimSpace(items[0]))
+	if err != nil {
+		return nil, err
+	}
+	r := &TimeoutRules{Default: d, scoped: make(map[string]time.Duration)}
+	for _, item := range items[1:] {
+		kv := strings.SplitN(strings.TrimSpace(item), "=", 2)
+		if len(kv) != 2 || kv[0] == "" {
+			return nil, fmt.Errorf("invalid scoped timeout %q, expected <scope>=<timeout>", item)
+		}
+		d, err := time.ParseDuration(kv[1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid scoped timeout %q: %s", item, err.Error())
+		}
+		r.scoped[kv[0]] = d
+		if !strings.HasPrefix(kv[0], "/") {
+			r.byNamespace = true
+		}
+	}
+	return r, nil
+}
+
+// Lookup returns the timeout of endpoint in namespace ns. The most specific
+// scope wins: namespace and endpoint, endpoint, namespace, then the default.
+func (r *TimeoutRules) Lookup(ns, endpoint string) time.Duration {
+	if r == nil {
+		return 0
+	}
+	if ns != "" {
+		if d, ok := r.scoped[ns+endpoint]; ok {
+			return d
+		}
+	}
+	if d, ok := r.scoped[endpoint]; ok {
+		return d
+	}
+	if ns != "" {
+		if d, ok := r.scoped[ns]; ok {
+			return d
+		}
+	}
+	return r.Default
+}
+
+// Timeouts configures the deadline of API requests, the timeout of the Raft
+// commands they apply and the timeout of the requests forwarded to the leader.
+// Zero timeouts disable the deadline, but for Apply, which falls back to the
+// timeout of the store.
+type Timeouts struct {
+	Request *TimeoutRules
+	Apply   *TimeoutRules
+	Forward *TimeoutRules
+}
+
+func (t *Timeouts) byNamespace() bool {
+	for _, r := range []*TimeoutRules{t.Request, t.Apply, t.Forward} {
+		if r != nil && r.byNamespace {
+			return true
+		}
+	}
+	return false
+}
+
+// withTimeouts returns ctx bounded by the timeouts of endpoint in ns.
+func (t *Timeouts) withTimeouts(ctx context.Context, ns, endpoint string) (context.Context, context.CancelFunc) {
+	if d := t.Apply.Lookup(ns, endpoint); d != 0 {
+		ctx = store.WithApplyTimeout(ctx, d)
+	}
+	if d := t.Forward.Lookup(ns, endpoint); d != 0 {
+		ctx = context.WithValue(ctx, forwardTimeoutKey{}, d)
+	}
+	if d := t.Request.Lookup(ns, endpoint); d != 0 {
+		return context.WithTimeout(ctx, d)
+	}
+	return context.WithCancel(ctx)
+}
+
+type forwardTimeoutKey struct{}
+
+// forwardTimeout returns the timeout of the request forwarded to the leader,
+// 0 if none.
+func forwardTimeout(ctx context.Context) time.Duration {
+	d, _ := ctx.Value(forwardTimeoutKey{}).(time.Duration)
+	return d
+}
+
+// timeouts bounds the request by the timeouts of its endpoint and namespace.
+// The namespace is only looked up when some timeouts are scoped by namespace.
+func (s *httpService) timeouts(ctx *http.Context) error {
+	if s.timeoutRules == nil {
+		return nil
+	}
+	var ns string
+	if s.timeoutRules.byNamespace() {
+		ns = httpNamespace(ctx)
+	}
+	c, cancel := s.timeoutRules.withTimeouts(ctx.Request.Context(), ns, ctx.Request.URL.Path)
+	defer cancel()
+	ctx.Request = ctx.Request.WithContext(c)
+	return ctx.Next()
+}
+
+// maxNamespaceBody bounds the part of a body read to find its namespace.
+const maxNamespaceBody = 1 << 20
+
+type namespaceKey struct{}
+
+// httpNamespace returns the namespace a request addresses, through its path
+// or the ns field of its body. The body is only parsed once, the namespace
+// being kept in the context of the request.
+func httpNamespace(ctx *http.Context) string {
+	r := ctx.Request
+	if strings.HasPrefix(r.URL.Path, "/namespaces/") {
+		return strings.SplitN(strings.TrimPrefix(r.URL.Path, "/namespaces/"), "/", 2)[0]
+	}
+	if ns, ok := r.Context().Value(namespaceKey{}).(string); ok {
+		return ns
+	}
+	ns := bodyNamespace(r)
+	ctx.Request = r.WithContext(context.WithValue(r.Context(), namespaceKey{}, ns))
+	return ns
+}
+
+// bodyNamespace returns the ns field of the body of r, read up to
+// maxNamespaceBody bytes. The body is left whole for the handler.
+func bodyNamespace(r *http2.Request) string {
+	if r.Body == nil {
+		return ""
+	}
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, maxNamespaceBody))
+	r.Body = struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(bytes.NewReader(body), r.Body), r.Body}
+	if err != nil {
+		return ""
+	}
+	if isProtobuf(r.Header.Get("Content-Type")) {
+		var request command.EnforceRequest
+		_ = proto.Unmarshal(body, &request)
+		return request.GetNamespace()
+	}
+	var v struct {
+		NS string `json:"ns"`
+	}
+	_ = json.Unmarshal(body, &v)
+	return v.NS
+}
+
+// timeoutsUnary bounds the calls by the timeouts of their method and
+// namespace.
+func timeoutsUnary(t *Timeouts) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		ctx, cancel := t.withTimeouts(ctx, grpcNamespace(req), info.FullMethod)
+		defer cancel()
+		return handler(ctx, req)
+	}
+}
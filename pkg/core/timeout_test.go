@@ -0,0 +1,74 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package core
+
+import (
+	"io/ioutil"
+	http2 "net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/casbin/casbin-mesh/pkg/handler/http"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_TimeoutRulesLookup(t *testing.T) {
+	r, err := ParseTimeoutRules("10s,/enforce=200ms,tenant-a=30s,tenant-a/enforce=1s")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, time.Second, r.Lookup("tenant-a", "/enforce"))
+	assert.Equal(t, 200*time.Millisecond, r.Lookup("tenant-b", "/enforce"))
+	assert.Equal(t, 30*time.Second, r.Lookup("tenant-a", "/add/policies"))
+	assert.Equal(t, 10*time.Second, r.Lookup("tenant-b", "/add/policies"))
+	assert.Equal(t, 10*time.Second, r.Lookup("", "/add/policies"))
+	assert.True(t, r.byNamespace)
+
+	var none *TimeoutRules
+	assert.Equal(t, time.Duration(0), none.Lookup("tenant-a", "/enforce"))
+	_, err = ParseTimeoutRules("10s,tenant-a")
+	assert.NotEqual(t, nil, err)
+	_, err = ParseTimeoutRules("10s,tenant-a=soon")
+	assert.NotEqual(t, nil, err)
+}
+
+func Test_HTTPNamespace(t *testing.T) {
+	body := `{"ns":"tenant-a","sec":"p","ptype":"p","rules":[["alice","data1","read"]]}`
+	ctx := &http.Context{Request: httptest.NewRequest(http2.MethodPost, "/add/policies", strings.NewReader(body))}
+	assert.Equal(t, "tenant-a", httpNamespace(ctx))
+	// the body is parsed once and left whole for the handler
+	ctx.Request.Body = ioutil.NopCloser(strings.NewReader(`{"ns":"tenant-b"}`))
+	assert.Equal(t, "tenant-a", httpNamespace(ctx))
+
+	ctx = &http.Context{Request: httptest.NewRequest(http2.MethodPost, "/add/policies", strings.NewReader(body))}
+	httpNamespace(ctx)
+	b, err := ioutil.ReadAll(ctx.Request.Body)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, body, string(b))
+
+	ctx = &http.Context{Request: httptest.NewRequest(http2.MethodGet, "/namespaces/tenant-c/policies", nil)}
+	assert.Equal(t, "tenant-c", httpNamespace(ctx))
+
+	// bodies too large to look at address no namespace
+	large := `{"rules":[["` + strings.Repeat("a", maxNamespaceBody) + `"]],"ns":"tenant-a"}`
+	ctx = &http.Context{Request: httptest.NewRequest(http2.MethodPost, "/add/policies", strings.NewReader(large))}
+	assert.Equal(t, "", httpNamespace(ctx))
+	b, err = ioutil.ReadAll(ctx.Request.Body)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, len(large), len(b))
+}
@@ -0,0 +1,164 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	http2 "net/http"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/casbin/casbin-mesh/pkg/auth"
+	"github.com/casbin/casbin-mesh/pkg/handler/http"
+	"github.com/casbin/casbin-mesh/proto/command"
+	"golang.org/x/net/websocket"
+)
+
+const (
+	// enforceWSPath is the endpoint of the WebSocket enforce channel.
+	enforceWSPath = "/enforce/ws"
+	// maxEnforceFrame bounds the size of the frames clients send.
+	maxEnforceFrame = 1 << 20
+	// maxEnforceInFlight bounds the frames of a connection enforced at once,
+	// the connection is not read while the bound is reached.
+	maxEnforceInFlight = 64
+)
+
+// EnforceFrame is a request sent on the WebSocket enforce channel. ID is
+// echoed in the result, so that clients can match the results, which may
+// come out of order, to their requests.
+type EnforceFrame struct {
+	ID string `json:"id"`
+	EnforceRequest
+}
+
+// EnforceResult is the result of an EnforceFrame.
+type EnforceResult struct {
+	ID    string `json:"id"`
+	Ok    bool   `json:"ok"`
+	Error string `json:"error,omitempty"`
+}
+
+// handleEnforceWS upgrades the request to a WebSocket carrying enforce
+// frames and their results, sparing interactive clients the overhead of an
+// HTTP request per decision. The frames are enforced concurrently, each
+// bounded by the timeouts of /enforce in its namespace.
+func (s *httpService) handleEnforceWS(ctx *http.Context) error {
+	principal := auth.PrincipalFromContext(ctx.Request.Context())
+	websocket.Server{Handshake: s.checkWSOrigin, Handler: func(conn *websocket.Conn) {
+		defer conn.Close()
+		conn.MaxPayloadBytes = maxEnforceFrame
+		// frames outlive the deadline of the upgrade request, they get their
+		// own one
+		c, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		var wg sync.WaitGroup
+		defer wg.Wait()
+		inFlight := make(chan struct{}, maxEnforceInFlight)
+		for {
+			var data []byte
+			if err := websocket.Message.Receive(conn, &data); err != nil {
+				return
+			}
+			var frame EnforceFrame
+			if err := json.Unmarshal(data, &frame); err != nil {
+				websocket.JSON.Send(conn, EnforceResult{Error: err.Error()})
+				continue
+			}
+			inFlight <- struct{}{}
+			wg.Add(1)
+			go func() {
+				defer func() {
+					<-inFlight
+					wg.Done()
+				}()
+				websocket.JSON.Send(conn, s.enforceFrame(c, principal, &frame))
+			}()
+		}
+	}}.ServeHTTP(ctx.ResponseWriter, ctx.Request)
+	return nil
+}
+
+// EnableEnforceWSOrigins allows the pages of origins, like
+// https://app.example.com, to open the WebSocket enforce channel, "*"
+// allowing every origin. Only the pages served by the API itself may by
+// default.
+func (s *httpService) EnableEnforceWSOrigins(origins []string) {
+	s.wsOrigins = origins
+}
+
+// checkWSOrigin refuses the handshakes of browser pages of other origins, so
+// that other sites can't enforce with the credentials of the browser.
+// Clients which aren't browsers send no Origin header and are let through.
+func (s *httpService) checkWSOrigin(config *websocket.Config, r *http2.Request) error {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return nil
+	}
+	u, err := url.Parse(origin)
+	if err != nil {
+		return err
+	}
+	if strings.EqualFold(u.Host, r.Host) {
+		return nil
+	}
+	for _, o := range s.wsOrigins {
+		if o == "*" || strings.EqualFold(strings.TrimSuffix(o, "/"), origin) {
+			return nil
+		}
+	}
+	return fmt.Errorf("origin %s not allowed", origin)
+}
+
+func (s *httpService) enforceFrame(c context.Context, principal string, frame *EnforceFrame) EnforceResult {
+	result := EnforceResult{ID: frame.ID}
+	if err := s.Validate.Struct(frame); err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	if s.scopes != nil && principal != "" && !s.scopes.Allowed(principal, frame.NS) {
+		result.Error = auth.ErrForbidden.Error()
+		return result
+	}
+	var cancel context.CancelFunc
+	if s.timeoutRules != nil {
+		c, cancel = s.timeoutRules.withTimeouts(c, frame.NS, "/enforce")
+	} else {
+		c, cancel = context.WithCancel(c)
+	}
+	defer cancel()
+	var ec *command.EnforceContext
+	if frame.Context != nil {
+		ec = &command.EnforceContext{
+			RType: frame.Context.RType,
+			PType: frame.Context.PType,
+			EType: frame.Context.EType,
+			MType: frame.Context.MType,
+		}
+	}
+	ok, err := s.EnforceWithContext(c, frame.NS, frame.Level, frame.Freshness, ec, frame.Params...)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	result.Ok = ok
+	return result
+}
@@ -0,0 +1,84 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package core_test
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/casbin/casbin-mesh/pkg/core"
+	"github.com/casbin/casbin-mesh/pkg/testkit"
+	"golang.org/x/net/websocket"
+)
+
+func dialEnforceWS(url, origin string) (*websocket.Conn, error) {
+	return websocket.Dial("ws"+strings.TrimPrefix(url, "http")+"/enforce/ws", "", origin)
+}
+
+func Test_EnforceWS(t *testing.T) {
+	ts, _ := newTestServer(t)
+	conn, err := dialEnforceWS(ts.URL, ts.URL)
+	if err != nil {
+		t.Fatalf("failed to dial from the API origin: %s", err.Error())
+	}
+	defer conn.Close()
+	frames := []core.EnforceFrame{
+		{ID: "1", EnforceRequest: core.EnforceRequest{NS: "default", Params: []interface{}{"alice", "data1", "read"}}},
+		{ID: "2", EnforceRequest: core.EnforceRequest{NS: "default", Params: []interface{}{"bob", "data1", "read"}}},
+	}
+	for _, f := range frames {
+		if err := websocket.JSON.Send(conn, f); err != nil {
+			t.Fatalf("failed to send frame %s: %s", f.ID, err.Error())
+		}
+	}
+	results := map[string]core.EnforceResult{}
+	for range frames {
+		var r core.EnforceResult
+		if err := websocket.JSON.Receive(conn, &r); err != nil {
+			t.Fatalf("failed to receive result: %s", err.Error())
+		}
+		results[r.ID] = r
+	}
+	if r := results["1"]; !r.Ok || r.Error != "" {
+		t.Fatalf("frame 1: expected allowed, got %+v", r)
+	}
+	if r := results["2"]; r.Ok || r.Error != "" {
+		t.Fatalf("frame 2: expected denied, got %+v", r)
+	}
+}
+
+func Test_EnforceWSOrigin(t *testing.T) {
+	c := testkit.NewCluster(t, 1)
+	srv := core.NewHttpService(c.Leader().Core, nil)
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+
+	if _, err := dialEnforceWS(ts.URL, "https://evil.example.com"); err == nil {
+		t.Fatalf("expected the handshake from another origin to fail")
+	}
+	srv.EnableEnforceWSOrigins([]string{"https://app.example.com/"})
+	if _, err := dialEnforceWS(ts.URL, "https://evil.example.com"); err == nil {
+		t.Fatalf("expected the handshake from an origin not listed to fail")
+	}
+	conn, err := dialEnforceWS(ts.URL, "https://APP.example.com")
+	if err != nil {
+		t.Fatalf("failed to dial from an allowed origin: %s", err.Error())
+	}
+	conn.Close()
+}
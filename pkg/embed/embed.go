@@ -0,0 +1,254 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+// Package embed runs a casbin-mesh node inside a Go application, so that
+// small deployments do not need a separate process.
+package embed
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/casbin/casbin-mesh/pkg/auth"
+	"github.com/casbin/casbin-mesh/pkg/cluster"
+	"github.com/casbin/casbin-mesh/pkg/core"
+	"github.com/casbin/casbin-mesh/pkg/store"
+	"github.com/casbin/casbin-mesh/pkg/transport/tcp"
+	"github.com/casbin/casbin-mesh/proto/command"
+	"github.com/soheilhy/cmux"
+)
+
+const (
+	// DefaultAddr is the address nodes listen on if none is set.
+	DefaultAddr = "localhost:4002"
+
+	defaultOpenTimeout = 120 * time.Second
+	joinAttempts       = 5
+	joinInterval       = 3 * time.Second
+)
+
+// Config configures an embedded node.
+type Config struct {
+	// Dir is the directory of the Raft log and snapshots. A node restarted
+	// on the same directory keeps its state.
+	Dir string
+	// ID identifies the node in the cluster, the advertised address if
+	// empty.
+	ID string
+	// Addr is the address Raft, and the API if enabled, listen on. A port
+	// of 0 picks a free one.
+	Addr string
+	// Advertise is the address the other nodes reach this one through, the
+	// address Addr bound to if empty.
+	Advertise string
+	// Join lists the API addresses of nodes of the cluster to join. A new
+	// single-node cluster is bootstrapped if empty.
+	Join []string
+	// API serves the HTTP API on Addr, which HTTP clients and nodes joining
+	// this one use.
+	API bool
+	// OpenTimeout is how long Start waits for a leader, 2 minutes if 0.
+	OpenTimeout time.Duration
+	// Logger receives the logs of the store, os.Stderr if nil.
+	Logger *log.Logger
+	// Faults are injected into the Raft connections of the node, to test
+	// it under network failures.
+	Faults *tcp.Faults
+}
+
+// Node is a casbin-mesh node running in process. Writes must go to the
+// leader, others fail with store.ErrNotLeader; enforcing works on any node.
+type Node struct {
+	store *store.Store
+	core  core.Core
+	ln    net.Listener
+	addr  string
+	http  *http.Server
+}
+
+// Start starts a node with cfg, and returns once it has a leader.
+func Start(cfg Config) (*Node, error) {
+	if cfg.Dir == "" {
+		return nil, errors.New("data directory not set")
+	}
+	dir, err := filepath.Abs(cfg.Dir)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Addr == "" {
+		cfg.Addr = DefaultAddr
+	}
+	if cfg.OpenTimeout == 0 {
+		cfg.OpenTimeout = defaultOpenTimeout
+	}
+
+	ln, err := net.Listen("tcp", cfg.Addr)
+	if err != nil {
+		return nil, err
+	}
+	adv := cfg.Advertise
+	if adv == "" {
+		adv = ln.Addr().String()
+	}
+	id := cfg.ID
+	if id == "" {
+		id = adv
+	}
+
+	// Raft and the API share the port, as they do on casmesh nodes.
+	mux := cmux.New(ln)
+	raftLn := tcp.NewTransportFromListener(mux.Match(tcp.RaftRPCMatcher()), false, false, adv)
+	if cfg.Faults != nil {
+		raftLn.SetFaults(cfg.Faults)
+	}
+	var httpLn net.Listener
+	if cfg.API {
+		httpLn = mux.Match(cmux.HTTP1Fast(http.MethodPatch))
+	}
+	go mux.Serve()
+
+	isNew := store.IsNewNode(dir)
+	str := store.New(raftLn, &store.StoreConfig{
+		Dir:      dir,
+		ID:       id,
+		AuthType: auth.Noop,
+		Logger:   cfg.Logger,
+	})
+	raftLn.SetIdentity(tcp.Identity{NodeID: id, ClusterID: str.ClusterID})
+	if err := str.Open(isNew && len(cfg.Join) == 0); err != nil {
+		ln.Close()
+		return nil, fmt.Errorf("failed to open store: %w", err)
+	}
+	n := &Node{store: str, core: core.New(str), ln: ln, addr: adv}
+	if httpLn != nil {
+		n.http = &http.Server{Handler: core.NewHttpService(n.core, nil)}
+		go n.http.Serve(httpLn)
+	}
+
+	meta := map[string]string{
+		"api_addr":           adv,
+		"api_proto":          "http",
+		store.VersionKey:     store.Version,
+		store.FSMVersionKey:  strconv.Itoa(store.FSMVersion),
+		store.RaftFramingKey: "true",
+	}
+	if cid := str.ClusterID(); cid != "" {
+		meta[store.ClusterIDKey] = cid
+	}
+	if len(cfg.Join) > 0 {
+		_, err := cluster.Join("", cfg.Join, id, adv, true, meta, "", joinAttempts, joinInterval, nil, auth.AuthConfig{AuthType: auth.Noop})
+		if err != nil && isNew {
+			n.Close()
+			return nil, fmt.Errorf("failed to join cluster at %s: %w", cfg.Join, err)
+		}
+	}
+	if _, err := str.WaitForLeader(cfg.OpenTimeout); err != nil {
+		n.Close()
+		return nil, fmt.Errorf("leader did not appear within timeout: %w", err)
+	}
+	if err := str.WaitForApplied(cfg.OpenTimeout); err != nil {
+		n.Close()
+		return nil, fmt.Errorf("log was not fully applied within timeout: %w", err)
+	}
+	if err := str.SetMetadata(meta); err != nil && err != store.ErrNotLeader {
+		n.Close()
+		return nil, fmt.Errorf("failed to set store metadata: %w", err)
+	}
+	return n, nil
+}
+
+// Close stops the node. Its state is kept in the data directory.
+func (n *Node) Close() error {
+	if n.http != nil {
+		n.http.Close()
+	}
+	err := n.store.Close(true)
+	n.ln.Close()
+	return err
+}
+
+// Addr returns the address the other nodes reach this one through.
+func (n *Node) Addr() string {
+	return n.addr
+}
+
+// IsLeader reports whether the node is the leader of the cluster.
+func (n *Node) IsLeader() bool {
+	return n.store.IsLeader()
+}
+
+// Core returns the whole API of the node.
+func (n *Node) Core() core.Core {
+	return n.core
+}
+
+// CreateNamespace creates namespace ns.
+func (n *Node) CreateNamespace(ctx context.Context, ns string) error {
+	return n.core.CreateNamespace(ctx, ns)
+}
+
+// SetModel sets the model of namespace ns from its text.
+func (n *Node) SetModel(ctx context.Context, ns, text string) error {
+	return n.core.SetModelFromString(ctx, ns, text)
+}
+
+// Enforce decides whether the request params is allowed in namespace ns,
+// from the policies of this node.
+func (n *Node) Enforce(ctx context.Context, ns string, params ...interface{}) (bool, error) {
+	return n.core.Enforce(ctx, ns, int32(command.EnforcePayload_QUERY_REQUEST_LEVEL_NONE), 0, params...)
+}
+
+// AddPolicy adds the policy rule to namespace ns, and reports whether it was
+// not there yet.
+func (n *Node) AddPolicy(ctx context.Context, ns string, rule ...string) (bool, error) {
+	return n.addPolicy(ctx, ns, "p", rule)
+}
+
+// RemovePolicy removes the policy rule from namespace ns, and reports
+// whether it was there.
+func (n *Node) RemovePolicy(ctx context.Context, ns string, rule ...string) (bool, error) {
+	return n.removePolicy(ctx, ns, "p", rule)
+}
+
+// AddGroupingPolicy adds the grouping policy rule to namespace ns, and
+// reports whether it was not there yet.
+func (n *Node) AddGroupingPolicy(ctx context.Context, ns string, rule ...string) (bool, error) {
+	return n.addPolicy(ctx, ns, "g", rule)
+}
+
+// RemoveGroupingPolicy removes the grouping policy rule from namespace ns,
+// and reports whether it was there.
+func (n *Node) RemoveGroupingPolicy(ctx context.Context, ns string, rule ...string) (bool, error) {
+	return n.removePolicy(ctx, ns, "g", rule)
+}
+
+func (n *Node) addPolicy(ctx context.Context, ns, sec string, rule []string) (bool, error) {
+	effected, err := n.core.AddPolicies(ctx, ns, sec, sec, [][]string{rule})
+	return len(effected) > 0, err
+}
+
+func (n *Node) removePolicy(ctx context.Context, ns, sec string, rule []string) (bool, error) {
+	effected, err := n.core.RemovePolicies(ctx, ns, sec, sec, [][]string{rule})
+	return len(effected) > 0, err
+}
@@ -0,0 +1,121 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package embed
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+const rbacModel = `
+[request_definition]
+r = sub, obj, act
+
+[policy_definition]
+p = sub, obj, act
+
+[role_definition]
+g = _, _
+
+[policy_effect]
+e = some(where (p.eft == allow))
+
+[matchers]
+m = g(r.sub, p.sub) && r.obj == p.obj && r.act == p.act
+`
+
+func mustStart(t *testing.T, cfg Config) *Node {
+	cfg.Dir = t.TempDir()
+	cfg.Addr = "localhost:0"
+	n, err := Start(cfg)
+	if err != nil {
+		t.Fatalf("failed to start node: %s", err.Error())
+	}
+	t.Cleanup(func() { n.Close() })
+	return n
+}
+
+func TestSingleNode(t *testing.T) {
+	ctx := context.Background()
+	n := mustStart(t, Config{})
+	if !n.IsLeader() {
+		t.Fatalf("single node is not leader")
+	}
+	if err := n.CreateNamespace(ctx, "default"); err != nil {
+		t.Fatalf("failed to create namespace: %s", err.Error())
+	}
+	if err := n.SetModel(ctx, "default", rbacModel); err != nil {
+		t.Fatalf("failed to set model: %s", err.Error())
+	}
+	if ok, err := n.AddPolicy(ctx, "default", "admin", "data1", "read"); err != nil || !ok {
+		t.Fatalf("failed to add policy: %v, %v", ok, err)
+	}
+	if ok, err := n.AddGroupingPolicy(ctx, "default", "alice", "admin"); err != nil || !ok {
+		t.Fatalf("failed to add grouping policy: %v, %v", ok, err)
+	}
+	if ok, _ := n.AddPolicy(ctx, "default", "admin", "data1", "read"); ok {
+		t.Fatalf("existing policy reported added")
+	}
+
+	if ok, err := n.Enforce(ctx, "default", "alice", "data1", "read"); err != nil || !ok {
+		t.Fatalf("alice denied: %v, %v", ok, err)
+	}
+	if ok, _ := n.Enforce(ctx, "default", "bob", "data1", "read"); ok {
+		t.Fatalf("bob allowed")
+	}
+	if ok, err := n.RemoveGroupingPolicy(ctx, "default", "alice", "admin"); err != nil || !ok {
+		t.Fatalf("failed to remove grouping policy: %v, %v", ok, err)
+	}
+	if ok, _ := n.Enforce(ctx, "default", "alice", "data1", "read"); ok {
+		t.Fatalf("alice allowed after leaving admin")
+	}
+}
+
+func TestJoin(t *testing.T) {
+	ctx := context.Background()
+	leader := mustStart(t, Config{API: true})
+	if err := leader.CreateNamespace(ctx, "default"); err != nil {
+		t.Fatalf("failed to create namespace: %s", err.Error())
+	}
+	if err := leader.SetModel(ctx, "default", rbacModel); err != nil {
+		t.Fatalf("failed to set model: %s", err.Error())
+	}
+	if _, err := leader.AddPolicy(ctx, "default", "alice", "data1", "read"); err != nil {
+		t.Fatalf("failed to add policy: %s", err.Error())
+	}
+
+	follower := mustStart(t, Config{Join: []string{leader.Addr()}})
+	if follower.IsLeader() {
+		t.Fatalf("joining node is leader")
+	}
+	if _, err := follower.AddPolicy(ctx, "default", "bob", "data1", "read"); err == nil {
+		t.Fatalf("follower accepted a write")
+	}
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		ok, err := follower.Enforce(ctx, "default", "alice", "data1", "read")
+		if err == nil && ok {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("policy not replicated to the follower: %v, %v", ok, err)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
@@ -0,0 +1,176 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+// Package entitlement expands the roles of a namespace into the permissions
+// every subject holds, for access reviews.
+package entitlement
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/casbin/casbin-mesh/proto/command"
+	"github.com/casbin/casbin/v2/model"
+)
+
+// Entitlement is a permission of a subject, granted directly or through
+// roles.
+type Entitlement struct {
+	Subject string
+	// Fields are the values of the rule granting it but the subject, named
+	// by the columns of the matrix.
+	Fields []string
+	// Via are the roles leading from the subject to the subject of the
+	// rule, empty if it is granted directly.
+	Via []string
+}
+
+// Matrix holds the rules of a namespace to expand.
+type Matrix struct {
+	// Columns name the fields of the entitlements, e.g. obj and act.
+	Columns []string
+
+	rules    [][]string
+	grouping [][]string
+	sub, dom int
+}
+
+// New returns the matrix of the rules of p and g of a namespace. The
+// subject of the rules of p is their sub token, or the first one.
+func New(text string, policies []*command.PolicyRules) (*Matrix, error) {
+	m, err := model.NewModelFromString(text)
+	if err != nil {
+		return nil, err
+	}
+	p, ok := m["p"]["p"]
+	if !ok {
+		return nil, fmt.Errorf("policy type p not defined in the model")
+	}
+	x := &Matrix{sub: 0, dom: -1}
+	for i, token := range p.Tokens {
+		switch token {
+		case "p_sub":
+			x.sub = i
+		case "p_dom":
+			x.dom = i
+		}
+	}
+	for i, token := range p.Tokens {
+		if i != x.sub {
+			x.Columns = append(x.Columns, strings.TrimPrefix(token, "p_"))
+		}
+	}
+	for _, pr := range policies {
+		switch {
+		case pr.GetSec() == "p" && pr.GetPType() == "p":
+			x.rules = append(x.rules, command.ToStringArray(pr.GetRules())...)
+		case pr.GetSec() == "g" && pr.GetPType() == "g":
+			x.grouping = append(x.grouping, command.ToStringArray(pr.GetRules())...)
+		}
+	}
+	return x, nil
+}
+
+// Subjects returns the names no other name inherits, in order: the users,
+// and the roles without members.
+func (x *Matrix) Subjects() []string {
+	names := make(map[string]bool)
+	for _, rule := range x.rules {
+		if len(rule) > x.sub {
+			names[rule[x.sub]] = true
+		}
+	}
+	for _, rule := range x.grouping {
+		if len(rule) >= 2 {
+			names[rule[0]] = true
+		}
+	}
+	for _, rule := range x.grouping {
+		if len(rule) >= 2 {
+			delete(names, rule[1])
+		}
+	}
+	subjects := make([]string, 0, len(names))
+	for name := range names {
+		subjects = append(subjects, name)
+	}
+	sort.Strings(subjects)
+	return subjects
+}
+
+// Each calls fn with the entitlements of every subject, in the order of
+// the subjects, until fn returns an error. Roles of a domain only lead to
+// the rules of that domain.
+func (x *Matrix) Each(fn func(Entitlement) error) error {
+	for _, subject := range x.Subjects() {
+		if err := x.expand(subject, fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// grant is a role reached by a subject, within a domain if set.
+type grant struct {
+	role   string
+	domain string
+	via    []string
+}
+
+func (x *Matrix) expand(subject string, fn func(Entitlement) error) error {
+	seen := map[[2]string]bool{{subject, ""}: true}
+	queue := []grant{{role: subject}}
+	for len(queue) > 0 {
+		g := queue[0]
+		queue = queue[1:]
+		for _, rule := range x.rules {
+			if len(rule) <= x.sub || rule[x.sub] != g.role {
+				continue
+			}
+			if g.domain != "" && x.dom >= 0 && x.dom < len(rule) && rule[x.dom] != g.domain {
+				continue
+			}
+			fields := make([]string, 0, len(rule)-1)
+			fields = append(fields, rule[:x.sub]...)
+			fields = append(fields, rule[x.sub+1:]...)
+			if err := fn(Entitlement{Subject: subject, Fields: fields, Via: g.via}); err != nil {
+				return err
+			}
+		}
+		for _, rule := range x.grouping {
+			if len(rule) < 2 || rule[0] != g.role {
+				continue
+			}
+			domain := g.domain
+			if len(rule) > 2 {
+				if domain != "" && rule[2] != domain {
+					continue
+				}
+				domain = rule[2]
+			}
+			key := [2]string{rule[1], domain}
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+			via := append(append([]string(nil), g.via...), rule[1])
+			queue = append(queue, grant{role: rule[1], domain: domain, via: via})
+		}
+	}
+	return nil
+}
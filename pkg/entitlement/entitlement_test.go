@@ -0,0 +1,118 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package entitlement
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/casbin/casbin-mesh/proto/command"
+)
+
+const modelText = `
+[request_definition]
+r = sub, obj, act
+
+[policy_definition]
+p = sub, obj, act
+
+[role_definition]
+g = _, _
+
+[policy_effect]
+e = some(where (p.eft == allow))
+
+[matchers]
+m = g(r.sub, p.sub) && r.obj == p.obj && r.act == p.act
+`
+
+func collect(t *testing.T, x *Matrix) []Entitlement {
+	var out []Entitlement
+	if err := x.Each(func(e Entitlement) error {
+		out = append(out, e)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	return out
+}
+
+func TestEach(t *testing.T) {
+	x, err := New(modelText, []*command.PolicyRules{
+		{Sec: "p", PType: "p", Rules: command.NewStringArray([][]string{
+			{"admin", "data1", "write"},
+			{"staff", "data1", "read"},
+			{"bob", "data2", "read"},
+		})},
+		{Sec: "g", PType: "g", Rules: command.NewStringArray([][]string{
+			{"alice", "staff"},
+			{"staff", "admin"},
+		})},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(x.Columns, []string{"obj", "act"}) {
+		t.Fatalf("unexpected columns %v", x.Columns)
+	}
+	want := []Entitlement{
+		{Subject: "alice", Fields: []string{"data1", "read"}, Via: []string{"staff"}},
+		{Subject: "alice", Fields: []string{"data1", "write"}, Via: []string{"staff", "admin"}},
+		{Subject: "bob", Fields: []string{"data2", "read"}},
+	}
+	if got := collect(t, x); !reflect.DeepEqual(got, want) {
+		t.Fatalf("unexpected entitlements %+v", got)
+	}
+}
+
+func TestEachDomains(t *testing.T) {
+	text := `
+[request_definition]
+r = sub, dom, obj, act
+
+[policy_definition]
+p = sub, dom, obj, act
+
+[role_definition]
+g = _, _, _
+
+[policy_effect]
+e = some(where (p.eft == allow))
+
+[matchers]
+m = g(r.sub, p.sub, r.dom) && r.dom == p.dom && r.obj == p.obj && r.act == p.act
+`
+	x, err := New(text, []*command.PolicyRules{
+		{Sec: "p", PType: "p", Rules: command.NewStringArray([][]string{
+			{"admin", "tenant1", "data1", "read"},
+			{"admin", "tenant2", "data2", "read"},
+		})},
+		{Sec: "g", PType: "g", Rules: command.NewStringArray([][]string{
+			{"alice", "admin", "tenant1"},
+		})},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Entitlement{
+		{Subject: "alice", Fields: []string{"tenant1", "data1", "read"}, Via: []string{"admin"}},
+	}
+	if got := collect(t, x); !reflect.DeepEqual(got, want) {
+		t.Fatalf("unexpected entitlements %+v", got)
+	}
+}
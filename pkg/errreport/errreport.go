@@ -0,0 +1,272 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+// Package errreport reports panics and critical errors to Sentry, or any
+// error tracker accepting its store API, so that operators learn of faults
+// before users do.
+package errreport
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"runtime/debug"
+	"strings"
+	"sync"
+	"time"
+)
+
+// queueSize bounds the events waiting to be sent, later ones are dropped.
+const queueSize = 64
+
+// PanicError is a recovered panic, with the stack of the goroutine which
+// panicked.
+type PanicError struct {
+	// Where tells what was being done, e.g. the endpoint served.
+	Where string
+	Value interface{}
+	Stack []byte
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("panic %s: %v", e.Where, e.Value)
+}
+
+// Recovered returns the panic p, recovered while doing where, with the
+// current stack.
+func Recovered(where string, p interface{}) *PanicError {
+	return &PanicError{Where: where, Value: p, Stack: debug.Stack()}
+}
+
+// Config configures the reports.
+type Config struct {
+	// DSN is the Sentry DSN of the project, like
+	// https://<key>@sentry.example.com/<project>.
+	DSN         string
+	Environment string
+	Release     string
+	// Tags are sent with every event, e.g. the ID of the node.
+	Tags map[string]string
+}
+
+// Reporter sends errors to the error tracker in the background.
+type Reporter struct {
+	cfg      Config
+	endpoint string
+	auth     string
+	hostname string
+	client   *http.Client
+	events   chan *event
+	wg       sync.WaitGroup
+	mu       sync.Mutex
+	closed   bool
+}
+
+// event is a Sentry event.
+type event struct {
+	EventID     string            `json:"event_id"`
+	Timestamp   string            `json:"timestamp"`
+	Level       string            `json:"level"`
+	Platform    string            `json:"platform"`
+	Logger      string            `json:"logger"`
+	Message     string            `json:"message"`
+	ServerName  string            `json:"server_name,omitempty"`
+	Environment string            `json:"environment,omitempty"`
+	Release     string            `json:"release,omitempty"`
+	Tags        map[string]string `json:"tags,omitempty"`
+	Extra       map[string]string `json:"extra,omitempty"`
+
+	// flushed, if set, marks a call to Flush rather than an event, closed
+	// once the events queued before it are sent.
+	flushed chan struct{}
+}
+
+// New returns a Reporter sending to the project of cfg.DSN.
+func New(cfg Config) (*Reporter, error) {
+	u, err := url.Parse(cfg.DSN)
+	if err != nil || u.User == nil || u.User.Username() == "" || u.Host == "" {
+		return nil, errors.New("invalid DSN, expected https://<key>@<host>/<project>")
+	}
+	i := strings.LastIndex(u.Path, "/")
+	project := u.Path[i+1:]
+	if project == "" {
+		return nil, errors.New("invalid DSN, the project is missing")
+	}
+	hostname, _ := os.Hostname()
+	r := &Reporter{
+		cfg:      cfg,
+		endpoint: fmt.Sprintf("%s://%s%s/api/%s/store/", u.Scheme, u.Host, u.Path[:i], project),
+		auth:     fmt.Sprintf("Sentry sentry_version=7, sentry_client=casbin-mesh/%s, sentry_key=%s", cfg.Release, u.User.Username()),
+		hostname: hostname,
+		client:   &http.Client{Timeout: 10 * time.Second},
+		events:   make(chan *event, queueSize),
+	}
+	if secret, ok := u.User.Password(); ok {
+		r.auth += ", sentry_secret=" + secret
+	}
+	r.wg.Add(1)
+	go r.run()
+	return r, nil
+}
+
+// Report queues err to be sent. Panics are reported as fatal, with their
+// stack, other errors as errors.
+func (r *Reporter) Report(err error) {
+	e := &event{
+		EventID:     newEventID(),
+		Timestamp:   time.Now().UTC().Format(time.RFC3339),
+		Level:       "error",
+		Platform:    "go",
+		Logger:      "casbin-mesh",
+		Message:     err.Error(),
+		ServerName:  r.hostname,
+		Environment: r.cfg.Environment,
+		Release:     r.cfg.Release,
+		Tags:        r.cfg.Tags,
+	}
+	var p *PanicError
+	if errors.As(err, &p) {
+		e.Level = "fatal"
+		e.Extra = map[string]string{"stack": string(p.Stack)}
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed {
+		return
+	}
+	select {
+	case r.events <- e:
+	default:
+		log.Printf("error report queue full, dropping %q", e.Message)
+	}
+}
+
+func (r *Reporter) run() {
+	defer r.wg.Done()
+	for e := range r.events {
+		if e.flushed != nil {
+			close(e.flushed)
+			continue
+		}
+		if err := r.send(e); err != nil {
+			log.Printf("failed to report error: %s", err.Error())
+		}
+	}
+}
+
+func (r *Reporter) send(e *event) error {
+	body, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, r.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Sentry-Auth", r.auth)
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}
+
+// Flush waits for the events queued so far to be sent, for up to timeout,
+// and returns whether they were. Callers about to crash, like after a panic
+// they can't recover from, flush so that the panic is reported first.
+func (r *Reporter) Flush(timeout time.Duration) bool {
+	tmr := time.NewTimer(timeout)
+	defer tmr.Stop()
+	flushed := make(chan struct{})
+	r.mu.Lock()
+	if r.closed {
+		r.mu.Unlock()
+		return r.wait(tmr.C)
+	}
+	// the queue may be full, r.mu is held while waiting for room so that
+	// Close doesn't close it meanwhile
+	select {
+	case r.events <- &event{flushed: flushed}:
+		r.mu.Unlock()
+	case <-tmr.C:
+		r.mu.Unlock()
+		return false
+	}
+	select {
+	case <-flushed:
+		return true
+	case <-tmr.C:
+		return false
+	}
+}
+
+// wait waits for the queued events to be sent after Close, until expired
+// fires, and returns whether they were.
+func (r *Reporter) wait(expired <-chan time.Time) bool {
+	done := make(chan struct{})
+	go func() {
+		r.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return true
+	case <-expired:
+		return false
+	}
+}
+
+// Close sends the queued events, until ctx is done. Later errors are not
+// reported.
+func (r *Reporter) Close(ctx context.Context) {
+	r.mu.Lock()
+	if !r.closed {
+		r.closed = true
+		close(r.events)
+	}
+	r.mu.Unlock()
+	done := make(chan struct{})
+	go func() {
+		r.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+	}
+}
+
+func newEventID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return strings.Repeat("0", 32)
+	}
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,130 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package errreport
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_Report(t *testing.T) {
+	events := make(chan event, 2)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/sentry/api/42/store/" {
+			t.Errorf("unexpected path %s", r.URL.Path)
+		}
+		if auth := r.Header.Get("X-Sentry-Auth"); !strings.Contains(auth, "sentry_key=public") {
+			t.Errorf("unexpected auth header %q", auth)
+		}
+		var e event
+		if err := json.NewDecoder(r.Body).Decode(&e); err != nil {
+			t.Errorf("failed to decode event: %s", err)
+		}
+		events <- e
+	}))
+	defer ts.Close()
+
+	r, err := New(Config{
+		DSN:     strings.Replace(ts.URL, "://", "://public@", 1) + "/sentry/42",
+		Release: "v1.0.0",
+		Tags:    map[string]string{"node_id": "node0"},
+	})
+	if err != nil {
+		t.Fatalf("failed to create reporter: %s", err)
+	}
+	r.Report(errors.New("failed to snapshot: disk full"))
+	func() {
+		defer func() {
+			r.Report(Recovered("serving /enforce", recover()))
+		}()
+		panic("nil map")
+	}()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	r.Close(ctx)
+	r.Report(errors.New("after close"))
+
+	e := <-events
+	if e.Level != "error" || e.Message != "failed to snapshot: disk full" || e.Tags["node_id"] != "node0" || e.Release != "v1.0.0" || len(e.EventID) != 32 {
+		t.Fatalf("unexpected error event %+v", e)
+	}
+	e = <-events
+	if e.Level != "fatal" || e.Message != "panic serving /enforce: nil map" || !strings.Contains(e.Extra["stack"], "Test_Report") {
+		t.Fatalf("unexpected panic event %+v", e)
+	}
+	select {
+	case e := <-events:
+		t.Fatalf("unexpected event reported after close %+v", e)
+	default:
+	}
+}
+
+func Test_Flush(t *testing.T) {
+	release := make(chan struct{})
+	sent := make(chan string, 2)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		var e event
+		json.NewDecoder(r.Body).Decode(&e)
+		sent <- e.Message
+	}))
+	defer ts.Close()
+
+	r, err := New(Config{DSN: strings.Replace(ts.URL, "://", "://public@", 1) + "/42"})
+	if err != nil {
+		t.Fatalf("failed to create reporter: %s", err)
+	}
+	r.Report(Recovered("applying log entry 7", "nil map"))
+	// the event is still being sent
+	if r.Flush(50 * time.Millisecond) {
+		t.Fatalf("expected the flush to time out")
+	}
+	close(release)
+	if !r.Flush(5 * time.Second) {
+		t.Fatalf("failed to flush")
+	}
+	select {
+	case m := <-sent:
+		if m != "panic applying log entry 7: nil map" {
+			t.Fatalf("unexpected event %q", m)
+		}
+	default:
+		t.Fatalf("event not sent once flushed")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	r.Close(ctx)
+	if !r.Flush(time.Second) {
+		t.Fatalf("failed to flush once closed")
+	}
+}
+
+func Test_NewInvalidDSN(t *testing.T) {
+	for _, dsn := range []string{"", "https://sentry.example.com/42", "https://key@sentry.example.com/"} {
+		if _, err := New(Config{DSN: dsn}); err == nil {
+			t.Fatalf("expected DSN %q refused", dsn)
+		}
+	}
+}
@@ -0,0 +1,56 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package events
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+)
+
+func init() {
+	RegisterSink("kafka", newKafkaSink)
+}
+
+type kafkaSink struct {
+	w *kafka.Writer
+}
+
+// newKafkaSink publishes to the Kafka cluster of the given brokers, messages
+// with the same key, i.e. of the same namespace, go to the same partition.
+func newKafkaSink(brokers []string) (Sink, error) {
+	if len(brokers) == 0 {
+		return nil, errors.New("no Kafka brokers")
+	}
+	return &kafkaSink{w: &kafka.Writer{
+		Addr:     kafka.TCP(brokers...),
+		Balancer: &kafka.Hash{},
+		// Events are written one at a time, don't wait for a batch to fill.
+		BatchTimeout: 10 * time.Millisecond,
+	}}, nil
+}
+
+func (s *kafkaSink) Publish(ctx context.Context, topic string, key, value []byte) error {
+	return s.w.WriteMessages(ctx, kafka.Message{Topic: topic, Key: key, Value: value})
+}
+
+func (s *kafkaSink) Close() error {
+	return s.w.Close()
+}
@@ -0,0 +1,55 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package events
+
+import (
+	"context"
+	"strings"
+
+	"github.com/nats-io/nats.go"
+)
+
+func init() {
+	RegisterSink("nats", newNATSSink)
+}
+
+type natsSink struct {
+	conn *nats.Conn
+}
+
+// newNATSSink publishes to the NATS servers of the given URLs, topics are
+// used as subjects.
+func newNATSSink(urls []string) (Sink, error) {
+	url := nats.DefaultURL
+	if len(urls) != 0 {
+		url = strings.Join(urls, ",")
+	}
+	conn, err := nats.Connect(url, nats.Name("casbin-mesh"), nats.MaxReconnects(-1))
+	if err != nil {
+		return nil, err
+	}
+	return &natsSink{conn: conn}, nil
+}
+
+func (s *natsSink) Publish(ctx context.Context, topic string, key, value []byte) error {
+	return s.conn.Publish(topic, value)
+}
+
+func (s *natsSink) Close() error {
+	return s.conn.Drain()
+}
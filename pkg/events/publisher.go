@@ -0,0 +1,225 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/casbin/casbin-mesh/pkg/auth"
+	"github.com/casbin/casbin-mesh/pkg/store"
+	"github.com/casbin/casbin-mesh/pkg/webhook"
+	"github.com/casbin/casbin-mesh/proto/command"
+)
+
+const (
+	queueSize  = 4096
+	watchRetry = time.Second
+	// dropLogInterval is the number of dropped decision events between two
+	// warnings.
+	dropLogInterval = 1000
+)
+
+// Config configures the events published and where to.
+type Config struct {
+	// Sink is the name of a registered sink, e.g. kafka or nats.
+	Sink  string
+	Addrs []string
+	// ChangeTopic receives the changes applied to namespaces, no change
+	// events are published if empty.
+	ChangeTopic string
+	// DecisionTopic receives the outcome of enforce requests, no decision
+	// events are published if empty.
+	DecisionTopic string
+	// SampleRate is the fraction of decisions published, between 0 and 1.
+	SampleRate float64
+	// NodeID identifies the node in decision events.
+	NodeID string
+}
+
+// Decision is the event published for an enforce request.
+type Decision struct {
+	Namespace string        `json:"namespace"`
+	Request   []interface{} `json:"request"`
+	Allowed   bool          `json:"allowed"`
+	Error     string        `json:"error,omitempty"`
+	// Principal identifies the client, if it was authenticated by its
+	// certificate.
+	Principal string    `json:"principal,omitempty"`
+	Node      string    `json:"node"`
+	Time      time.Time `json:"time"`
+}
+
+// Source is the subset of core.Core the Publisher needs.
+type Source interface {
+	Watch(ctx context.Context, ns string, index uint64, fn func(*command.WatchEvent) error) error
+	IsLeader(ctx context.Context) bool
+}
+
+type message struct {
+	topic      string
+	key, value []byte
+}
+
+// Publisher publishes change and decision events to a Sink. Change events,
+// in the format of the webhook payloads, are published by the leader only,
+// decision events by the node serving the enforce request. Both are keyed by
+// namespace.
+type Publisher struct {
+	cfg     Config
+	sink    Sink
+	src     Source
+	queue   chan message
+	dropped uint64
+
+	ctx      context.Context
+	cancel   context.CancelFunc
+	closeCtx context.Context
+	wg       sync.WaitGroup
+}
+
+// New returns a Publisher of the events of src, connected to the configured
+// sink.
+func New(cfg Config, src Source) (*Publisher, error) {
+	sink, err := OpenSink(cfg.Sink, cfg.Addrs)
+	if err != nil {
+		return nil, err
+	}
+	return newPublisher(cfg, src, sink), nil
+}
+
+func newPublisher(cfg Config, src Source, sink Sink) *Publisher {
+	return &Publisher{cfg: cfg, sink: sink, src: src, queue: make(chan message, queueSize)}
+}
+
+// Start starts publishing events.
+func (p *Publisher) Start() {
+	p.ctx, p.cancel = context.WithCancel(context.Background())
+	p.wg.Add(1)
+	go p.publish()
+	if p.cfg.ChangeTopic != "" {
+		p.wg.Add(1)
+		go p.watch()
+	}
+}
+
+// Close stops the Publisher, publishing the queued events until ctx is done,
+// and closes the sink.
+func (p *Publisher) Close(ctx context.Context) {
+	p.closeCtx = ctx
+	p.cancel()
+	p.wg.Wait()
+	if err := p.sink.Close(); err != nil {
+		log.Printf("failed to close event sink: %s", err.Error())
+	}
+}
+
+// Decision publishes a sample of the decisions, it is a core.DecisionFunc.
+// Decisions are dropped rather than slowing down enforce requests if the
+// sink doesn't keep up.
+func (p *Publisher) Decision(ctx context.Context, ns string, params []interface{}, result bool, err error) {
+	if p.cfg.DecisionTopic == "" || rand.Float64() >= p.cfg.SampleRate {
+		return
+	}
+	d := Decision{Namespace: ns, Request: params, Allowed: result, Principal: auth.PrincipalFromContext(ctx), Node: p.cfg.NodeID, Time: time.Now().UTC()}
+	if err != nil {
+		d.Error = err.Error()
+	}
+	value, err := json.Marshal(d)
+	if err != nil {
+		log.Printf("failed to encode decision event: %s", err.Error())
+		return
+	}
+	select {
+	case p.queue <- message{topic: p.cfg.DecisionTopic, key: []byte(ns), value: value}:
+	default:
+		if n := atomic.AddUint64(&p.dropped, 1); n%dropLogInterval == 1 {
+			log.Printf("event sink is lagging, %d decision events dropped", n)
+		}
+	}
+}
+
+func (p *Publisher) watch() {
+	defer p.wg.Done()
+	var index uint64
+	for {
+		err := p.src.Watch(p.ctx, "", index, func(ev *command.WatchEvent) error {
+			index = ev.GetIndex()
+			if !p.src.IsLeader(p.ctx) {
+				return nil
+			}
+			value, err := json.Marshal(webhook.NewEvent(ev))
+			if err != nil {
+				return err
+			}
+			select {
+			case p.queue <- message{topic: p.cfg.ChangeTopic, key: []byte(ev.GetNamespace()), value: value}:
+				return nil
+			case <-p.ctx.Done():
+				return p.ctx.Err()
+			}
+		})
+		// Watches also end while the node shuts down, give Close a chance
+		// to stop the Publisher before resuming.
+		select {
+		case <-p.ctx.Done():
+			return
+		case <-time.After(watchRetry):
+		}
+		if err == store.ErrWatchCompacted {
+			log.Println("change events were missed, resuming from the latest change")
+			index = 0
+		} else {
+			log.Printf("change event watch ended: %s, resuming", err.Error())
+		}
+	}
+}
+
+func (p *Publisher) publish() {
+	defer p.wg.Done()
+	for {
+		select {
+		case m := <-p.queue:
+			p.send(p.ctx, m)
+		case <-p.ctx.Done():
+			for {
+				select {
+				case m := <-p.queue:
+					if p.closeCtx.Err() != nil {
+						log.Printf("failed to publish %d queued events: %s", len(p.queue)+1, p.closeCtx.Err().Error())
+						return
+					}
+					p.send(p.closeCtx, m)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+func (p *Publisher) send(ctx context.Context, m message) {
+	if err := p.sink.Publish(ctx, m.topic, m.key, m.value); err != nil {
+		log.Printf("failed to publish event to %s: %s", m.topic, err.Error())
+	}
+}
@@ -0,0 +1,153 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/casbin/casbin-mesh/pkg/auth"
+	"github.com/casbin/casbin-mesh/pkg/webhook"
+	"github.com/casbin/casbin-mesh/proto/command"
+)
+
+type fakeSource struct {
+	events chan *command.WatchEvent
+	leader bool
+}
+
+func (f *fakeSource) Watch(ctx context.Context, ns string, index uint64, fn func(*command.WatchEvent) error) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case ev := <-f.events:
+			if err := fn(ev); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func (f *fakeSource) IsLeader(ctx context.Context) bool {
+	return f.leader
+}
+
+type fakeSink struct {
+	mu       sync.Mutex
+	messages []message
+	closed   bool
+}
+
+func (s *fakeSink) Publish(ctx context.Context, topic string, key, value []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.messages = append(s.messages, message{topic, key, value})
+	return nil
+}
+
+func (s *fakeSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	return nil
+}
+
+func (s *fakeSink) wait(t *testing.T, n int) []message {
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		s.mu.Lock()
+		msgs := append([]message(nil), s.messages...)
+		s.mu.Unlock()
+		if len(msgs) >= n {
+			return msgs
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected %d messages, got %d", n, len(msgs))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func Test_PublishChanges(t *testing.T) {
+	src := &fakeSource{events: make(chan *command.WatchEvent, 1), leader: true}
+	sink := &fakeSink{}
+	p := newPublisher(Config{ChangeTopic: "changes"}, src, sink)
+	p.Start()
+
+	src.events <- &command.WatchEvent{Index: 5, Namespace: "orders", Type: command.Type_COMMAND_TYPE_CLEAR_POLICY}
+	msgs := sink.wait(t, 1)
+	if msgs[0].topic != "changes" || string(msgs[0].key) != "orders" {
+		t.Fatalf("wrong message: %s %s", msgs[0].topic, msgs[0].key)
+	}
+	var ev webhook.Event
+	if err := json.Unmarshal(msgs[0].value, &ev); err != nil {
+		t.Fatalf("failed to decode change event: %s", err.Error())
+	}
+	if ev.Index != 5 || ev.Type != "COMMAND_TYPE_CLEAR_POLICY" {
+		t.Fatalf("wrong change event: %+v", ev)
+	}
+
+	// Decisions aren't published without a topic.
+	p.Decision(context.Background(), "orders", []interface{}{"alice"}, true, nil)
+	p.Close(context.Background())
+	if len(sink.messages) != 1 || !sink.closed {
+		t.Fatalf("expected 1 message and a closed sink, got %d, %v", len(sink.messages), sink.closed)
+	}
+}
+
+func Test_PublishDecisions(t *testing.T) {
+	src := &fakeSource{events: make(chan *command.WatchEvent)}
+	sink := &fakeSink{}
+	p := newPublisher(Config{DecisionTopic: "decisions", SampleRate: 1, NodeID: "node0"}, src, sink)
+	p.Start()
+	ctx := auth.WithPrincipal(context.Background(), "spiffe://mesh.local/sa/orders")
+	p.Decision(ctx, "orders", []interface{}{"alice", "data1", "read"}, true, nil)
+	msgs := sink.wait(t, 1)
+	var d Decision
+	if err := json.Unmarshal(msgs[0].value, &d); err != nil {
+		t.Fatalf("failed to decode decision event: %s", err.Error())
+	}
+	if d.Namespace != "orders" || !d.Allowed || d.Node != "node0" || len(d.Request) != 3 || d.Principal != "spiffe://mesh.local/sa/orders" {
+		t.Fatalf("wrong decision event: %+v", d)
+	}
+	p.Close(context.Background())
+
+	sink = &fakeSink{}
+	p = newPublisher(Config{DecisionTopic: "decisions", SampleRate: 0}, src, sink)
+	p.Start()
+	for i := 0; i < 100; i++ {
+		p.Decision(context.Background(), "orders", nil, false, nil)
+	}
+	p.Close(context.Background())
+	if len(sink.messages) != 0 {
+		t.Fatalf("expected no sampled decisions, got %d", len(sink.messages))
+	}
+}
+
+func Test_OpenUnknownSink(t *testing.T) {
+	if _, err := OpenSink("carrier-pigeon", nil); err == nil {
+		t.Fatalf("expected error opening unknown sink")
+	}
+	if names := Sinks(); len(names) != 2 || names[0] != "kafka" || names[1] != "nats" {
+		t.Fatalf("wrong sinks: %v", names)
+	}
+}
@@ -0,0 +1,77 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+// Package events publishes policy change events and decision events to
+// streaming systems like Kafka or NATS.
+package events
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Sink publishes messages to topics of a streaming system.
+type Sink interface {
+	Publish(ctx context.Context, topic string, key, value []byte) error
+	Close() error
+}
+
+// SinkFactory connects to the comma-separated addresses of a streaming
+// system.
+type SinkFactory func(addrs []string) (Sink, error)
+
+var (
+	sinksMu sync.RWMutex
+	sinks   = make(map[string]SinkFactory)
+)
+
+// RegisterSink makes a sink available by name, it panics if the name is
+// already registered.
+func RegisterSink(name string, factory SinkFactory) {
+	sinksMu.Lock()
+	defer sinksMu.Unlock()
+	if _, ok := sinks[name]; ok {
+		panic("events: sink " + name + " registered twice")
+	}
+	sinks[name] = factory
+}
+
+// OpenSink connects to the sink registered by name.
+func OpenSink(name string, addrs []string) (Sink, error) {
+	sinksMu.RLock()
+	factory, ok := sinks[name]
+	sinksMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown event sink %q, available sinks are %s", name, strings.Join(Sinks(), ", "))
+	}
+	return factory(addrs)
+}
+
+// Sinks returns the names of the registered sinks.
+func Sinks() []string {
+	sinksMu.RLock()
+	defer sinksMu.RUnlock()
+	var names []string
+	for name := range sinks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,125 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+// Package expiry removes the rules added with an expiry once they expired,
+// and activates the scheduled rules within their schedule.
+package expiry
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/casbin/casbin-mesh/pkg/store"
+)
+
+// Source is the subset of core.Core the Janitor needs.
+type Source interface {
+	IsLeader(ctx context.Context) bool
+	DuePolicies(ctx context.Context, now time.Time) []store.DuePolicies
+	ExpirePolicies(ctx context.Context, ns string, sec string, pType string, now time.Time) ([][]string, error)
+	ScheduleChanges(ctx context.Context, now time.Time) []store.ScheduleChange
+	SchedulePolicies(ctx context.Context, ns string, sec string, pType string, now time.Time, activate bool) ([][]string, error)
+}
+
+// Janitor periodically removes the expired rules and applies the schedules.
+// Only the leader proposes the changes, along with its clock, so that all
+// nodes change the same rules at the same Raft index.
+type Janitor struct {
+	src      Source
+	interval time.Duration
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// New returns a Janitor checking src for expired and scheduled rules every
+// interval.
+func New(src Source, interval time.Duration) *Janitor {
+	return &Janitor{src: src, interval: interval}
+}
+
+// Start starts removing the expired rules and applying the schedules.
+func (j *Janitor) Start() {
+	j.ctx, j.cancel = context.WithCancel(context.Background())
+	j.wg.Add(1)
+	go j.run()
+}
+
+// Close stops the Janitor, waiting until ctx is done for the removal in
+// progress.
+func (j *Janitor) Close(ctx context.Context) {
+	j.cancel()
+	done := make(chan struct{})
+	go func() {
+		j.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		log.Println("failed to finish removing expired rules:", ctx.Err().Error())
+	}
+}
+
+func (j *Janitor) run() {
+	defer j.wg.Done()
+	ticker := time.NewTicker(j.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-j.ctx.Done():
+			return
+		case <-ticker.C:
+			j.expire(time.Now())
+		}
+	}
+}
+
+// expire removes the rules expired at now and applies the schedules at now,
+// if this node is the leader.
+func (j *Janitor) expire(now time.Time) {
+	if !j.src.IsLeader(j.ctx) {
+		return
+	}
+	for _, due := range j.src.DuePolicies(j.ctx, now) {
+		rules, err := j.src.ExpirePolicies(j.ctx, due.Namespace, due.Sec, due.PType, now)
+		if err != nil {
+			log.Printf("failed to remove expired %s rules of %s: %s", due.PType, due.Namespace, err.Error())
+			continue
+		}
+		if len(rules) > 0 {
+			log.Printf("removed %d expired %s rules of %s", len(rules), due.PType, due.Namespace)
+		}
+	}
+	for _, c := range j.src.ScheduleChanges(j.ctx, now) {
+		action := "deactivate"
+		if c.Activate {
+			action = "activate"
+		}
+		rules, err := j.src.SchedulePolicies(j.ctx, c.Namespace, c.Sec, c.PType, now, c.Activate)
+		if err != nil {
+			log.Printf("failed to %s scheduled %s rules of %s: %s", action, c.PType, c.Namespace, err.Error())
+			continue
+		}
+		if len(rules) > 0 {
+			log.Printf("%sd %d scheduled %s rules of %s", action, len(rules), c.PType, c.Namespace)
+		}
+	}
+}
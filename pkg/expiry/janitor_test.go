@@ -0,0 +1,139 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package expiry
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/casbin/casbin-mesh/pkg/store"
+)
+
+type fakeSource struct {
+	mu        sync.Mutex
+	leader    bool
+	due       []store.DuePolicies
+	expired   []string
+	changes   []store.ScheduleChange
+	scheduled []string
+}
+
+func (f *fakeSource) IsLeader(ctx context.Context) bool {
+	return f.leader
+}
+
+func (f *fakeSource) DuePolicies(ctx context.Context, now time.Time) []store.DuePolicies {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	due := f.due
+	f.due = nil
+	return due
+}
+
+func (f *fakeSource) ExpirePolicies(ctx context.Context, ns string, sec string, pType string, now time.Time) ([][]string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.expired = append(f.expired, ns+"/"+pType)
+	return [][]string{{"alice", "data1", "read"}}, nil
+}
+
+func (f *fakeSource) ScheduleChanges(ctx context.Context, now time.Time) []store.ScheduleChange {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	changes := f.changes
+	f.changes = nil
+	return changes
+}
+
+func (f *fakeSource) SchedulePolicies(ctx context.Context, ns string, sec string, pType string, now time.Time, activate bool) ([][]string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	action := "deactivate"
+	if activate {
+		action = "activate"
+	}
+	f.scheduled = append(f.scheduled, action+" "+ns+"/"+pType)
+	return [][]string{{"alice", "data1", "read"}}, nil
+}
+
+func (f *fakeSource) scheduledCount() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.scheduled)
+}
+
+func (f *fakeSource) count() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.expired)
+}
+
+func Test_JanitorExpires(t *testing.T) {
+	src := &fakeSource{leader: true, due: []store.DuePolicies{
+		{Namespace: "orders", Sec: "p", PType: "p"},
+		{Namespace: "billing", Sec: "g", PType: "g"},
+	}}
+	j := New(src, 10*time.Millisecond)
+	j.Start()
+	defer j.Close(context.Background())
+
+	deadline := time.Now().Add(5 * time.Second)
+	for src.count() < 2 {
+		if time.Now().After(deadline) {
+			t.Fatalf("expired rules were not removed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if src.expired[0] != "orders/p" || src.expired[1] != "billing/g" {
+		t.Fatalf("wrong removals %v", src.expired)
+	}
+}
+
+func Test_JanitorFollowerDoesNotExpire(t *testing.T) {
+	src := &fakeSource{due: []store.DuePolicies{{Namespace: "orders", Sec: "p", PType: "p"}}}
+	j := New(src, 10*time.Millisecond)
+	j.Start()
+	time.Sleep(100 * time.Millisecond)
+	j.Close(context.Background())
+	if n := src.count(); n != 0 {
+		t.Fatalf("follower removed %d policy types", n)
+	}
+}
+
+func Test_JanitorSchedules(t *testing.T) {
+	src := &fakeSource{leader: true, changes: []store.ScheduleChange{
+		{Namespace: "orders", Sec: "p", PType: "p", Activate: true},
+		{Namespace: "billing", Sec: "p", PType: "p"},
+	}}
+	j := New(src, 10*time.Millisecond)
+	j.Start()
+	defer j.Close(context.Background())
+
+	deadline := time.Now().Add(5 * time.Second)
+	for src.scheduledCount() < 2 {
+		if time.Now().After(deadline) {
+			t.Fatalf("scheduled rules were not changed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if src.scheduled[0] != "activate orders/p" || src.scheduled[1] != "deactivate billing/p" {
+		t.Fatalf("wrong schedule changes %v", src.scheduled)
+	}
+}
@@ -0,0 +1,85 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package extauthz
+
+import (
+	"context"
+
+	authv3 "github.com/envoyproxy/go-control-plane/envoy/service/auth/v3"
+	typev3 "github.com/envoyproxy/go-control-plane/envoy/type/v3"
+	rpcstatus "google.golang.org/genproto/googleapis/rpc/status"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// EnvoyServer implements the Envoy external authorization (v3) service.
+type EnvoyServer struct {
+	authv3.UnimplementedAuthorizationServer
+	authorizer *Authorizer
+}
+
+// NewEnvoyServer returns an Envoy authorization service deciding through z.
+func NewEnvoyServer(z *Authorizer) *EnvoyServer {
+	return &EnvoyServer{authorizer: z}
+}
+
+// Register registers the Envoy authorization service on srv.
+func Register(srv *grpc.Server, z *Authorizer) {
+	authv3.RegisterAuthorizationServer(srv, NewEnvoyServer(z))
+}
+
+// Check answers OK if the namespace allows the request, PERMISSION_DENIED
+// with a 403 otherwise. Failing to enforce is returned as an error, leaving
+// Envoy's failure_mode_allow to decide.
+func (s *EnvoyServer) Check(ctx context.Context, req *authv3.CheckRequest) (*authv3.CheckResponse, error) {
+	attrs := envoyAttributes(req)
+	ok, err := s.authorizer.Authorize(ctx, attrs)
+	if err != nil {
+		return nil, status.Error(codes.Unavailable, err.Error())
+	}
+	if !ok {
+		return &authv3.CheckResponse{
+			Status: &rpcstatus.Status{Code: int32(codes.PermissionDenied)},
+			HttpResponse: &authv3.CheckResponse_DeniedResponse{
+				DeniedResponse: &authv3.DeniedHttpResponse{
+					Status: &typev3.HttpStatus{Code: typev3.StatusCode_Forbidden},
+				},
+			},
+		}, nil
+	}
+	return &authv3.CheckResponse{
+		Status: &rpcstatus.Status{Code: int32(codes.OK)},
+		HttpResponse: &authv3.CheckResponse_OkResponse{
+			OkResponse: &authv3.OkHttpResponse{},
+		},
+	}, nil
+}
+
+func envoyAttributes(req *authv3.CheckRequest) *Attributes {
+	attrs := req.GetAttributes()
+	http := attrs.GetRequest().GetHttp()
+	return &Attributes{
+		Method:    http.GetMethod(),
+		Path:      http.GetPath(),
+		Host:      http.GetHost(),
+		Headers:   http.GetHeaders(),
+		Principal: attrs.GetSource().GetPrincipal(),
+		Context:   attrs.GetContextExtensions(),
+	}
+}
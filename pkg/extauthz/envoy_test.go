@@ -0,0 +1,122 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package extauthz
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	authv3 "github.com/envoyproxy/go-control-plane/envoy/service/auth/v3"
+	"google.golang.org/grpc/codes"
+)
+
+type fakeEnforcer struct {
+	ns     string
+	params []interface{}
+	allow  bool
+	err    error
+}
+
+func (f *fakeEnforcer) Enforce(ctx context.Context, ns string, level int32, freshness int64, params ...interface{}) (bool, error) {
+	f.ns, f.params = ns, params
+	return f.allow, f.err
+}
+
+func Test_ParseMapping(t *testing.T) {
+	m, err := ParseMapping(" header:X-User , path,method,literal:a:b")
+	if err != nil {
+		t.Fatalf("failed to parse mapping: %s", err.Error())
+	}
+	exp := Mapping{"header:x-user", "path", "method", "literal:a:b"}
+	if !reflect.DeepEqual(m, exp) {
+		t.Fatalf("wrong mapping, exp %v, got %v", exp, m)
+	}
+	for _, spec := range []string{"cookie:sid", "header:", "path:/a", ""} {
+		if _, err := ParseMapping(spec); err == nil {
+			t.Fatalf("expected error parsing %q", spec)
+		}
+	}
+}
+
+func Test_MappingRequest(t *testing.T) {
+	m, _ := ParseMapping("header:x-user,path,query,host,principal,context:tenant,literal:x")
+	got := m.Request(&Attributes{
+		Method:    "GET",
+		Path:      "/data1?v=1",
+		Host:      "example.com",
+		Headers:   map[string]string{"x-user": "alice"},
+		Principal: "spiffe://cluster/ns/default/sa/web",
+		Context:   map[string]string{"tenant": "acme"},
+	})
+	exp := []interface{}{"alice", "/data1", "v=1", "example.com", "spiffe://cluster/ns/default/sa/web", "acme", "x"}
+	if !reflect.DeepEqual(got, exp) {
+		t.Fatalf("wrong request, exp %v, got %v", exp, got)
+	}
+}
+
+func checkRequest(ctxExt map[string]string) *authv3.CheckRequest {
+	return &authv3.CheckRequest{
+		Attributes: &authv3.AttributeContext{
+			Source: &authv3.AttributeContext_Peer{Principal: "web"},
+			Request: &authv3.AttributeContext_Request{
+				Http: &authv3.AttributeContext_HttpRequest{
+					Method:  "GET",
+					Path:    "/data1",
+					Headers: map[string]string{"x-user": "alice"},
+				},
+			},
+			ContextExtensions: ctxExt,
+		},
+	}
+}
+
+func Test_EnvoyCheck(t *testing.T) {
+	e := &fakeEnforcer{allow: true}
+	m, _ := ParseMapping(DefaultMapping)
+	s := NewEnvoyServer(&Authorizer{Enforcer: e, Namespace: "default", Mapping: m})
+
+	resp, err := s.Check(context.Background(), checkRequest(nil))
+	if err != nil {
+		t.Fatalf("failed to check: %s", err.Error())
+	}
+	if resp.GetStatus().GetCode() != int32(codes.OK) || resp.GetOkResponse() == nil {
+		t.Fatalf("expected request to be allowed, got %v", resp)
+	}
+	if e.ns != "default" || !reflect.DeepEqual(e.params, []interface{}{"alice", "/data1", "GET"}) {
+		t.Fatalf("wrong enforce call: %s %v", e.ns, e.params)
+	}
+
+	e.allow = false
+	resp, err = s.Check(context.Background(), checkRequest(map[string]string{"namespace": "other"}))
+	if err != nil {
+		t.Fatalf("failed to check: %s", err.Error())
+	}
+	if resp.GetStatus().GetCode() != int32(codes.PermissionDenied) || resp.GetDeniedResponse().GetStatus().GetCode() != 403 {
+		t.Fatalf("expected request to be denied, got %v", resp)
+	}
+	if e.ns != "other" {
+		t.Fatalf("expected namespace from context extension, got %s", e.ns)
+	}
+
+	e.err = errors.New("namespace not exist")
+	if _, err := s.Check(context.Background(), checkRequest(nil)); err == nil {
+		t.Fatalf("expected error when enforcing fails")
+	}
+}
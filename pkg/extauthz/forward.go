@@ -0,0 +1,56 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package extauthz
+
+import (
+	"net/http"
+	"strings"
+)
+
+// ForwardedAttributes returns the attributes of the original request of a
+// forward-auth subrequest. The method, URI and host are read from the
+// X-Forwarded-* headers set by Traefik, or the X-Original-* headers usually
+// set for nginx's auth_request, and fall back to the subrequest's own. The
+// query parameters of the subrequest make up the context, so a route can
+// pick the namespace with e.g. /forward-auth?namespace=orders.
+func ForwardedAttributes(r *http.Request) *Attributes {
+	headers := make(map[string]string, len(r.Header))
+	for k, v := range r.Header {
+		headers[strings.ToLower(k)] = strings.Join(v, ",")
+	}
+	context := make(map[string]string)
+	for k, v := range r.URL.Query() {
+		context[k] = v[0]
+	}
+	return &Attributes{
+		Method:  firstHeader(r, r.Method, "X-Forwarded-Method", "X-Original-Method"),
+		Path:    firstHeader(r, r.URL.RequestURI(), "X-Forwarded-Uri", "X-Original-Uri"),
+		Host:    firstHeader(r, r.Host, "X-Forwarded-Host", "X-Original-Host"),
+		Headers: headers,
+		Context: context,
+	}
+}
+
+func firstHeader(r *http.Request, fallback string, names ...string) string {
+	for _, name := range names {
+		if v := r.Header.Get(name); v != "" {
+			return v
+		}
+	}
+	return fallback
+}
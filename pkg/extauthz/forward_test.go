@@ -0,0 +1,61 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package extauthz
+
+import (
+	"context"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func Test_ForwardedAttributes(t *testing.T) {
+	r := httptest.NewRequest("GET", "/forward-auth?namespace=orders", nil)
+	r.Header.Set("X-Forwarded-Method", "DELETE")
+	r.Header.Set("X-Forwarded-Uri", "/orders/1?force=true")
+	r.Header.Set("X-Forwarded-Host", "shop.example.com")
+	r.Header.Set("X-User", "alice")
+
+	a := ForwardedAttributes(r)
+	if a.Method != "DELETE" || a.Path != "/orders/1?force=true" || a.Host != "shop.example.com" {
+		t.Fatalf("wrong forwarded attributes: %+v", a)
+	}
+	if a.Headers["x-user"] != "alice" || a.Context["namespace"] != "orders" {
+		t.Fatalf("wrong headers or context: %+v", a)
+	}
+
+	e := &fakeEnforcer{allow: true}
+	z := &Authorizer{Enforcer: e, Namespace: "default", Mapping: Mapping{"header:x-user", "path", "method"}}
+	if ok, err := z.Authorize(context.Background(), a); err != nil || !ok {
+		t.Fatalf("expected request to be allowed, got %v, %v", ok, err)
+	}
+	if e.ns != "orders" || !reflect.DeepEqual(e.params, []interface{}{"alice", "/orders/1", "DELETE"}) {
+		t.Fatalf("wrong enforce call: %s %v", e.ns, e.params)
+	}
+}
+
+func Test_ForwardedAttributesNginx(t *testing.T) {
+	r := httptest.NewRequest("GET", "/forward-auth", nil)
+	r.Header.Set("X-Original-Method", "POST")
+	r.Header.Set("X-Original-URI", "/data1")
+
+	a := ForwardedAttributes(r)
+	if a.Method != "POST" || a.Path != "/data1" || a.Host != "example.com" {
+		t.Fatalf("wrong forwarded attributes: %+v", a)
+	}
+}
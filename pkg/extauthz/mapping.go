@@ -0,0 +1,155 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package extauthz
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// DefaultMapping maps the subject to the x-user header, the object to the
+// path and the action to the method, fitting the classic sub, obj, act model.
+const DefaultMapping = "header:x-user,path,method"
+
+// namespaceExtension is the context extension a route can set to authorize
+// against another namespace than the configured one.
+const namespaceExtension = "namespace"
+
+var (
+	// ErrNoNamespace is returned when neither the authorizer nor the request
+	// name a namespace.
+	ErrNoNamespace = errors.New("no namespace to authorize against")
+)
+
+// Attributes are the parts of an HTTP request a Mapping can pick from.
+type Attributes struct {
+	Method string
+	// Path is the request path, including the query string.
+	Path string
+	Host string
+	// Headers are keyed by lower-case header names.
+	Headers map[string]string
+	// Principal is the identity of the peer, e.g. the SAN of its certificate.
+	Principal string
+	// Context holds values configured on the route by the proxy.
+	Context map[string]string
+}
+
+// Mapping lists, for each element of the request tuple of the namespace's
+// model, the attribute of the HTTP request it is taken from. An element is
+// one of:
+//
+//	method, path, query, host, principal, header:<name>, context:<key>, literal:<value>
+//
+// path doesn't include the query string, which is available as query.
+type Mapping []string
+
+// ParseMapping parses a comma-separated Mapping, e.g. DefaultMapping.
+func ParseMapping(spec string) (Mapping, error) {
+	var m Mapping
+	for _, elem := range strings.Split(spec, ",") {
+		elem = strings.TrimSpace(elem)
+		kind, arg := elem, ""
+		if i := strings.Index(elem, ":"); i >= 0 {
+			kind, arg = elem[:i], elem[i+1:]
+		}
+		switch kind {
+		case "method", "path", "query", "host", "principal":
+			if arg != "" {
+				return nil, fmt.Errorf("attribute %s takes no argument", kind)
+			}
+		case "header", "context":
+			if arg == "" {
+				return nil, fmt.Errorf("attribute %s needs a name", kind)
+			}
+			if kind == "header" {
+				elem = kind + ":" + strings.ToLower(arg)
+			}
+		case "literal":
+		default:
+			return nil, fmt.Errorf("unknown attribute %q", elem)
+		}
+		m = append(m, elem)
+	}
+	return m, nil
+}
+
+// Request builds the request tuple for a.
+func (m Mapping) Request(a *Attributes) []interface{} {
+	params := make([]interface{}, 0, len(m))
+	for _, elem := range m {
+		kind, arg := elem, ""
+		if i := strings.Index(elem, ":"); i >= 0 {
+			kind, arg = elem[:i], elem[i+1:]
+		}
+		var v string
+		switch kind {
+		case "method":
+			v = a.Method
+		case "path":
+			v = a.Path
+			if i := strings.IndexByte(v, '?'); i >= 0 {
+				v = v[:i]
+			}
+		case "query":
+			if i := strings.IndexByte(a.Path, '?'); i >= 0 {
+				v = a.Path[i+1:]
+			}
+		case "host":
+			v = a.Host
+		case "principal":
+			v = a.Principal
+		case "header":
+			v = a.Headers[arg]
+		case "context":
+			v = a.Context[arg]
+		case "literal":
+			v = arg
+		}
+		params = append(params, v)
+	}
+	return params
+}
+
+// Enforcer is the subset of core.Core the Authorizer needs.
+type Enforcer interface {
+	Enforce(ctx context.Context, ns string, level int32, freshness int64, params ...interface{}) (bool, error)
+}
+
+// Authorizer decides on HTTP requests by enforcing them in a namespace.
+type Authorizer struct {
+	Enforcer Enforcer
+	// Namespace is used unless the route sets the "namespace" context
+	// extension.
+	Namespace string
+	Mapping   Mapping
+}
+
+// Authorize reports whether the request described by a is allowed.
+func (z *Authorizer) Authorize(ctx context.Context, a *Attributes) (bool, error) {
+	ns := z.Namespace
+	if v := a.Context[namespaceExtension]; v != "" {
+		ns = v
+	}
+	if ns == "" {
+		return false, ErrNoNamespace
+	}
+	return z.Enforcer.Enforce(ctx, ns, 0, 0, z.Mapping.Request(a)...)
+}
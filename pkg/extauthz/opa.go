@@ -0,0 +1,105 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package extauthz
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// DefaultOPAMapping fits the input of OPA's HTTP API authorization example.
+const DefaultOPAMapping = "user,path,method"
+
+// ErrBadDataPath is returned for OPA data paths not naming a namespace.
+var ErrBadDataPath = errors.New("data path must start with a namespace")
+
+// OPAMapping lists, for each element of the request tuple, the dotted path of
+// the field of the OPA input document it is taken from, e.g. "user" or
+// "attributes.request.http.method".
+type OPAMapping [][]string
+
+// ParseOPAMapping parses a comma-separated OPAMapping, e.g. DefaultOPAMapping.
+func ParseOPAMapping(spec string) (OPAMapping, error) {
+	var m OPAMapping
+	for _, elem := range strings.Split(spec, ",") {
+		elem = strings.TrimSpace(elem)
+		fields := strings.Split(elem, ".")
+		for _, f := range fields {
+			if f == "" {
+				return nil, fmt.Errorf("invalid input path %q", elem)
+			}
+		}
+		m = append(m, fields)
+	}
+	return m, nil
+}
+
+// Request builds the request tuple for the input document. Missing fields
+// are empty strings, and arrays of strings, like the path of OPA's example,
+// are joined into a slash-separated path. Other values are passed as is.
+func (m OPAMapping) Request(input map[string]interface{}) []interface{} {
+	params := make([]interface{}, 0, len(m))
+	for _, fields := range m {
+		var v interface{} = input
+		for _, f := range fields {
+			obj, ok := v.(map[string]interface{})
+			if !ok {
+				v = nil
+				break
+			}
+			v = obj[f]
+		}
+		switch value := v.(type) {
+		case nil:
+			v = ""
+		case []interface{}:
+			if path, ok := joinPath(value); ok {
+				v = path
+			}
+		}
+		params = append(params, v)
+	}
+	return params
+}
+
+func joinPath(elems []interface{}) (string, bool) {
+	var b strings.Builder
+	for _, e := range elems {
+		s, ok := e.(string)
+		if !ok {
+			return "", false
+		}
+		b.WriteString("/")
+		b.WriteString(s)
+	}
+	return b.String(), true
+}
+
+// OPADecision enforces the input document of an OPA data API query. The
+// first segment of the data path, e.g. "httpapi" in "httpapi/authz/allow",
+// names the namespace, the rest is ignored since a namespace has a single
+// decision.
+func OPADecision(ctx context.Context, e Enforcer, m OPAMapping, path string, input map[string]interface{}) (bool, error) {
+	ns := strings.Split(strings.Trim(path, "/"), "/")[0]
+	if ns == "" {
+		return false, ErrBadDataPath
+	}
+	return e.Enforce(ctx, ns, 0, 0, m.Request(input)...)
+}
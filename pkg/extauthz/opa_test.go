@@ -0,0 +1,62 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package extauthz
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func Test_OPAMappingRequest(t *testing.T) {
+	m, err := ParseOPAMapping("user, path ,method,attributes.tenant,missing.field")
+	if err != nil {
+		t.Fatalf("failed to parse mapping: %s", err.Error())
+	}
+	var input map[string]interface{}
+	doc := `{"user": "alice", "path": ["finance", "salary", "alice"], "method": "GET", "attributes": {"tenant": "acme"}}`
+	if err := json.Unmarshal([]byte(doc), &input); err != nil {
+		t.Fatalf("failed to decode input: %s", err.Error())
+	}
+	exp := []interface{}{"alice", "/finance/salary/alice", "GET", "acme", ""}
+	if got := m.Request(input); !reflect.DeepEqual(got, exp) {
+		t.Fatalf("wrong request, exp %v, got %v", exp, got)
+	}
+	for _, spec := range []string{"", "user,", "a..b"} {
+		if _, err := ParseOPAMapping(spec); err == nil {
+			t.Fatalf("expected error parsing %q", spec)
+		}
+	}
+}
+
+func Test_OPADecision(t *testing.T) {
+	e := &fakeEnforcer{allow: true}
+	m, _ := ParseOPAMapping(DefaultOPAMapping)
+	input := map[string]interface{}{"user": "alice", "path": "/data1", "method": "GET"}
+	ok, err := OPADecision(context.Background(), e, m, "httpapi/authz/allow", input)
+	if err != nil || !ok {
+		t.Fatalf("expected query to be allowed, got %v, %v", ok, err)
+	}
+	if e.ns != "httpapi" || !reflect.DeepEqual(e.params, []interface{}{"alice", "/data1", "GET"}) {
+		t.Fatalf("wrong enforce call: %s %v", e.ns, e.params)
+	}
+	if _, err := OPADecision(context.Background(), e, m, "/", input); err != ErrBadDataPath {
+		t.Fatalf("expected ErrBadDataPath, got %v", err)
+	}
+}
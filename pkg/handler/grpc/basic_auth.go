@@ -22,6 +22,7 @@ import (
 	"encoding/base64"
 	"errors"
 	"fmt"
+	"github.com/casbin/casbin-mesh/pkg/auth"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/metadata"
 	"log"
@@ -63,8 +64,27 @@ func getBasicAuthFormContext(ctx context.Context) (username, password string, ok
 	return
 }
 
+// Username returns the username of the basic credentials of the call, if
+// any.
+func Username(ctx context.Context) string {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return ""
+	}
+	if auth := md.Get("authorization"); len(auth) > 0 {
+		username, _, _ := parseBasicAuth(auth[0])
+		return username
+	}
+	return ""
+}
+
+// BasicAuthor authenticates calls with their credentials, unless the client
+// was authenticated by its certificate.
 func BasicAuthor(author func(username, password string) bool) grpc.UnaryServerInterceptor {
 	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp interface{}, err error) {
+		if auth.PrincipalFromContext(ctx) != "" {
+			return handler(ctx, req)
+		}
 		username, password, ok := getBasicAuthFormContext(ctx)
 		//UNAUTHORIZED
 		if !ok || !author(username, password) {
@@ -75,6 +95,21 @@ func BasicAuthor(author func(username, password string) bool) grpc.UnaryServerIn
 	}
 }
 
+func BasicAuthorStream(author func(username, password string) bool) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if auth.PrincipalFromContext(ss.Context()) != "" {
+			return handler(srv, ss)
+		}
+		username, password, ok := getBasicAuthFormContext(ss.Context())
+		//UNAUTHORIZED
+		if !ok || !author(username, password) {
+			return ErrUnauthorized
+		}
+		// AUTHORIZED
+		return handler(srv, ss)
+	}
+}
+
 func unauthorized(w http.ResponseWriter, realm string) {
 	w.Header().Add("WWW-Authenticate", fmt.Sprintf(`Basic realm="%s"`, realm))
 	w.WriteHeader(http.StatusUnauthorized)
@@ -0,0 +1,104 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package grpc
+
+import (
+	"context"
+	"errors"
+	"net"
+
+	"github.com/casbin/casbin-mesh/pkg/auth"
+	"github.com/casbin/casbin-mesh/pkg/transport/tcp"
+	grpc_middleware "github.com/grpc-ecosystem/go-grpc-middleware"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+)
+
+// listenerCredentials are the server credentials of connections whose TLS,
+// if any, was already terminated by the listener. They expose the TLS state
+// of the connections to the calls.
+type listenerCredentials struct{}
+
+// ListenerCredentials returns the server credentials of a gRPC server serving
+// the connections of a tcp.Listener.
+func ListenerCredentials() credentials.TransportCredentials {
+	return listenerCredentials{}
+}
+
+type plaintextInfo struct {
+	credentials.CommonAuthInfo
+}
+
+func (plaintextInfo) AuthType() string {
+	return "insecure"
+}
+
+func (listenerCredentials) ClientHandshake(context.Context, string, net.Conn) (net.Conn, credentials.AuthInfo, error) {
+	return nil, nil, errors.New("listener credentials are server side only")
+}
+
+func (listenerCredentials) ServerHandshake(c net.Conn) (net.Conn, credentials.AuthInfo, error) {
+	state := tcp.ConnectionState(c)
+	if state == nil {
+		return c, plaintextInfo{credentials.CommonAuthInfo{SecurityLevel: credentials.NoSecurity}}, nil
+	}
+	return c, credentials.TLSInfo{State: *state, CommonAuthInfo: credentials.CommonAuthInfo{SecurityLevel: credentials.PrivacyAndIntegrity}}, nil
+}
+
+func (listenerCredentials) Info() credentials.ProtocolInfo {
+	return credentials.ProtocolInfo{SecurityProtocol: "tls"}
+}
+
+func (c listenerCredentials) Clone() credentials.TransportCredentials {
+	return c
+}
+
+func (listenerCredentials) OverrideServerName(string) error {
+	return nil
+}
+
+// withPrincipal returns ctx carrying the principal m maps the certificate of
+// the peer to.
+func withPrincipal(ctx context.Context, m auth.PrincipalMapping) context.Context {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return ctx
+	}
+	info, ok := p.AuthInfo.(credentials.TLSInfo)
+	if !ok {
+		return ctx
+	}
+	return auth.WithPrincipal(ctx, m.Principal(&info.State))
+}
+
+// CertPrincipal identifies the clients of calls by their certificate.
+func CertPrincipal(m auth.PrincipalMapping) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		return handler(withPrincipal(ctx, m), req)
+	}
+}
+
+// CertPrincipalStream identifies the clients of streams by their certificate.
+func CertPrincipalStream(m auth.PrincipalMapping) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		wrapped := grpc_middleware.WrapServerStream(ss)
+		wrapped.WrappedContext = withPrincipal(ss.Context(), m)
+		return handler(srv, wrapped)
+	}
+}
@@ -19,8 +19,11 @@ package http
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"net/http"
+	"strings"
 )
 
 type Context struct {
@@ -51,6 +54,38 @@ func (c *Context) JSON(resp interface{}) error {
 	return json.NewEncoder(c.ResponseWriter).Encode(resp)
 }
 
+// CacheableJSON writes resp with an ETag hashing its content. It answers 304
+// Not Modified, without the content, if the request has a matching
+// If-None-Match header.
+func (c *Context) CacheableJSON(resp interface{}) error {
+	b, err := json.Marshal(resp)
+	if err != nil {
+		return err
+	}
+	sum := sha256.Sum256(b)
+	etag := `"` + hex.EncodeToString(sum[:16]) + `"`
+	c.ResponseWriter.Header().Set("ETag", etag)
+	if noneMatch(c.Request.Header.Get("If-None-Match"), etag) {
+		c.ResponseWriter.WriteHeader(http.StatusNotModified)
+		return nil
+	}
+	c.ResponseWriter.WriteHeader(http.StatusOK)
+	_, err = c.ResponseWriter.Write(append(b, '\n'))
+	return err
+}
+
+// noneMatch tells whether an If-None-Match header matches etag, weak
+// validators match their strong counterpart.
+func noneMatch(header, etag string) bool {
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+		if tag == "*" || tag == etag {
+			return true
+		}
+	}
+	return false
+}
+
 // Next runs the next handler func until out of range
 func (c *Context) Next() (err error) {
 	c.indexHandler++
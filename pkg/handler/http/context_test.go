@@ -0,0 +1,52 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package http
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func Test_CacheableJSON(t *testing.T) {
+	get := func(ifNoneMatch string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/print/model", nil)
+		if ifNoneMatch != "" {
+			r.Header.Set("If-None-Match", ifNoneMatch)
+		}
+		c := &Context{ResponseWriter: w, Request: r}
+		if err := c.CacheableJSON(map[string]string{"model": "m"}); err != nil {
+			t.Fatalf("failed to write response: %s", err.Error())
+		}
+		return w
+	}
+	w := get("")
+	etag := w.Header().Get("ETag")
+	if w.Code != http.StatusOK || etag == "" || w.Body.String() != "{\"model\":\"m\"}\n" {
+		t.Fatalf("wrong response %d %q %q", w.Code, etag, w.Body.String())
+	}
+	for _, header := range []string{etag, "W/" + etag, `"other", ` + etag, "*"} {
+		if w := get(header); w.Code != http.StatusNotModified || w.Body.Len() != 0 {
+			t.Fatalf("expected 304 for %q, got %d", header, w.Code)
+		}
+	}
+	if w := get(`"other"`); w.Code != http.StatusOK {
+		t.Fatalf("expected 200 for a stale ETag, got %d", w.Code)
+	}
+}
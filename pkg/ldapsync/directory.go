@@ -0,0 +1,104 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package ldapsync
+
+import (
+	"crypto/tls"
+	"fmt"
+	"strings"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+const searchPageSize = 500
+
+// Directory lists the groups of a directory with their members.
+type Directory interface {
+	Groups() (map[string][]string, error)
+}
+
+type ldapDirectory struct {
+	cfg *Config
+}
+
+// NewLDAPDirectory returns a Directory searching the LDAP server of cfg.
+func NewLDAPDirectory(cfg *Config) Directory {
+	return &ldapDirectory{cfg: cfg}
+}
+
+func (d *ldapDirectory) Groups() (map[string][]string, error) {
+	tlsConfig := &tls.Config{InsecureSkipVerify: d.cfg.InsecureSkipVerify}
+	conn, err := ldap.DialURL(d.cfg.URL, ldap.DialWithTLSConfig(tlsConfig))
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	if d.cfg.StartTLS {
+		if err := conn.StartTLS(tlsConfig); err != nil {
+			return nil, fmt.Errorf("failed to start TLS: %s", err.Error())
+		}
+	}
+	if d.cfg.BindDN != "" {
+		if err := conn.Bind(d.cfg.BindDN, d.cfg.BindPassword); err != nil {
+			return nil, fmt.Errorf("failed to bind as %s: %s", d.cfg.BindDN, err.Error())
+		}
+	}
+
+	req := ldap.NewSearchRequest(d.cfg.BaseDN, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 0, 0, false,
+		d.cfg.GroupFilter, []string{d.cfg.GroupAttribute, d.cfg.MemberAttribute}, nil)
+	res, err := conn.SearchWithPaging(req, searchPageSize)
+	if err != nil {
+		return nil, fmt.Errorf("failed to search groups: %s", err.Error())
+	}
+	groups := make(map[string][]string, len(res.Entries))
+	for _, entry := range res.Entries {
+		name := entry.GetEqualFoldAttributeValue(d.cfg.GroupAttribute)
+		if name == "" {
+			continue
+		}
+		var members []string
+		for _, m := range entry.GetEqualFoldAttributeValues(d.cfg.MemberAttribute) {
+			if user := memberName(m, d.cfg.UserAttribute); user != "" {
+				members = append(members, user)
+			}
+		}
+		groups[name] = members
+	}
+	return groups, nil
+}
+
+// memberName returns the user name of a member value. Values of attributes
+// like member are DNs, the user is named by their first RDN if its type is
+// userAttr, e.g. uid=alice,ou=people,dc=example,dc=com is alice. Members
+// named otherwise, e.g. nested groups, are skipped. Values of attributes like
+// memberUid are names already.
+func memberName(value, userAttr string) string {
+	if !strings.Contains(value, "=") {
+		return value
+	}
+	dn, err := ldap.ParseDN(value)
+	if err != nil || len(dn.RDNs) == 0 {
+		return ""
+	}
+	for _, attr := range dn.RDNs[0].Attributes {
+		if strings.EqualFold(attr.Type, userAttr) {
+			return attr.Value
+		}
+	}
+	return ""
+}
@@ -0,0 +1,237 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+// Package ldapsync keeps the grouping policies of a namespace aligned with
+// the groups of an LDAP or Active Directory server.
+package ldapsync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/casbin/casbin-mesh/proto/command"
+	"gopkg.in/yaml.v3"
+)
+
+// Config is the LDAP sync configuration file.
+type Config struct {
+	// URL is the LDAP server, e.g. ldaps://ldap.example.com:636.
+	URL                string `yaml:"url"`
+	StartTLS           bool   `yaml:"start-tls"`
+	InsecureSkipVerify bool   `yaml:"insecure-skip-verify"`
+	BindDN             string `yaml:"bind-dn"`
+	BindPassword       string `yaml:"bind-password"`
+	// BaseDN and GroupFilter select the groups.
+	BaseDN      string `yaml:"base-dn"`
+	GroupFilter string `yaml:"group-filter"`
+	// GroupAttribute names the groups, MemberAttribute lists their members.
+	GroupAttribute  string `yaml:"group-attribute"`
+	MemberAttribute string `yaml:"member-attribute"`
+	// UserAttribute is the RDN attribute naming users in member DNs.
+	UserAttribute string `yaml:"user-attribute"`
+
+	// Namespace and PType are the grouping policies kept in sync.
+	Namespace string `yaml:"namespace"`
+	PType     string `yaml:"ptype"`
+	// RolePrefix is prepended to group names to form the roles. Only the
+	// policies of roles with this prefix are managed, so that policies added
+	// by other means are left alone.
+	RolePrefix string `yaml:"role-prefix"`
+	Interval   string `yaml:"interval"`
+}
+
+// LoadConfig reads the LDAP sync configuration from a YAML file.
+func LoadConfig(path string) (*Config, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	cfg := &Config{
+		GroupFilter:     "(objectClass=groupOfNames)",
+		GroupAttribute:  "cn",
+		MemberAttribute: "member",
+		UserAttribute:   "uid",
+		PType:           "g",
+		Interval:        "5m",
+	}
+	dec := yaml.NewDecoder(f)
+	dec.KnownFields(true)
+	if err := dec.Decode(cfg); err != nil && err != io.EOF {
+		return nil, fmt.Errorf("failed to parse %s: %s", path, err.Error())
+	}
+	switch {
+	case cfg.URL == "":
+		return nil, errors.New("url is required")
+	case cfg.BaseDN == "":
+		return nil, errors.New("base-dn is required")
+	case cfg.Namespace == "":
+		return nil, errors.New("namespace is required")
+	}
+	return cfg, nil
+}
+
+// Target is the subset of core.Core the Syncer needs.
+type Target interface {
+	IsLeader(ctx context.Context) bool
+	NamespaceSnapshot(ctx context.Context, namespace string) (string, []*command.PolicyRules, uint64, error)
+	AddPolicies(ctx context.Context, ns string, sec string, pType string, rules [][]string) ([][]string, error)
+	RemovePolicies(ctx context.Context, ns string, sec string, pType string, rules [][]string) ([][]string, error)
+}
+
+// Syncer periodically reconciles the grouping policies of a namespace with
+// the groups of a Directory. Changes go through Raft, so only the leader
+// syncs.
+type Syncer struct {
+	cfg      *Config
+	dir      Directory
+	target   Target
+	interval time.Duration
+
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// New returns a Syncer of the groups of dir into target.
+func New(cfg *Config, dir Directory, target Target) (*Syncer, error) {
+	interval, err := time.ParseDuration(cfg.Interval)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse interval %s: %s", cfg.Interval, err.Error())
+	}
+	return &Syncer{cfg: cfg, dir: dir, target: target, interval: interval}, nil
+}
+
+// Start syncs at once and then periodically.
+func (s *Syncer) Start() {
+	var ctx context.Context
+	ctx, s.cancel = context.WithCancel(context.Background())
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		ticker := time.NewTicker(s.interval)
+		defer ticker.Stop()
+		for {
+			if s.target.IsLeader(ctx) {
+				if added, removed, err := s.Sync(ctx); err != nil {
+					log.Printf("failed to sync LDAP groups into %s: %s", s.cfg.Namespace, err.Error())
+				} else if added+removed > 0 {
+					log.Printf("synced LDAP groups into %s: %d policies added, %d removed", s.cfg.Namespace, added, removed)
+				}
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// Close stops the Syncer, waiting for a sync in progress until ctx is done.
+func (s *Syncer) Close(ctx context.Context) {
+	s.cancel()
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+	}
+}
+
+// Sync reconciles the managed grouping policies with the directory once.
+func (s *Syncer) Sync(ctx context.Context) (added, removed int, err error) {
+	groups, err := s.dir.Groups()
+	if err != nil {
+		return 0, 0, err
+	}
+	desired := make(map[string][]string)
+	for group, members := range groups {
+		role := s.cfg.RolePrefix + group
+		for _, user := range members {
+			rule := []string{user, role}
+			desired[ruleKey(rule)] = rule
+		}
+	}
+
+	_, policies, _, err := s.target.NamespaceSnapshot(ctx, s.cfg.Namespace)
+	if err != nil {
+		return 0, 0, err
+	}
+	current := make(map[string][]string)
+	for _, p := range policies {
+		if p.GetSec() != "g" || p.GetPType() != s.cfg.PType {
+			continue
+		}
+		for _, rule := range command.ToStringArray(p.GetRules()) {
+			if len(rule) == 2 && strings.HasPrefix(rule[1], s.cfg.RolePrefix) {
+				current[ruleKey(rule)] = rule
+			}
+		}
+	}
+
+	var toAdd, toRemove [][]string
+	for key, rule := range desired {
+		if _, ok := current[key]; !ok {
+			toAdd = append(toAdd, rule)
+		}
+	}
+	for key, rule := range current {
+		if _, ok := desired[key]; !ok {
+			toRemove = append(toRemove, rule)
+		}
+	}
+	if len(groups) == 0 && len(toRemove) > 0 {
+		// An empty directory is more likely a misconfigured filter than
+		// the intent to revoke every role.
+		return 0, 0, fmt.Errorf("directory returned no groups, refusing to remove %d policies", len(toRemove))
+	}
+	sortRules(toAdd)
+	sortRules(toRemove)
+
+	if len(toAdd) > 0 {
+		if _, err = s.target.AddPolicies(ctx, s.cfg.Namespace, "g", s.cfg.PType, toAdd); err != nil {
+			return 0, 0, err
+		}
+	}
+	if len(toRemove) > 0 {
+		if _, err = s.target.RemovePolicies(ctx, s.cfg.Namespace, "g", s.cfg.PType, toRemove); err != nil {
+			return len(toAdd), 0, err
+		}
+	}
+	return len(toAdd), len(toRemove), nil
+}
+
+func ruleKey(rule []string) string {
+	return strings.Join(rule, "\x00")
+}
+
+func sortRules(rules [][]string) {
+	sort.Slice(rules, func(i, j int) bool {
+		return ruleKey(rules[i]) < ruleKey(rules[j])
+	})
+}
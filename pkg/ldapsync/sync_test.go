@@ -0,0 +1,110 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package ldapsync
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/casbin/casbin-mesh/proto/command"
+)
+
+type fakeDirectory map[string][]string
+
+func (d fakeDirectory) Groups() (map[string][]string, error) {
+	return d, nil
+}
+
+type fakeTarget struct {
+	rules   [][]string
+	added   [][]string
+	removed [][]string
+}
+
+func (t *fakeTarget) IsLeader(ctx context.Context) bool {
+	return true
+}
+
+func (t *fakeTarget) NamespaceSnapshot(ctx context.Context, namespace string) (string, []*command.PolicyRules, uint64, error) {
+	return "", []*command.PolicyRules{
+		{Sec: "p", PType: "p", Rules: command.NewStringArray([][]string{{"ldap:admins", "data1", "write"}})},
+		{Sec: "g", PType: "g", Rules: command.NewStringArray(t.rules)},
+	}, 1, nil
+}
+
+func (t *fakeTarget) AddPolicies(ctx context.Context, ns string, sec string, pType string, rules [][]string) ([][]string, error) {
+	t.added = rules
+	return rules, nil
+}
+
+func (t *fakeTarget) RemovePolicies(ctx context.Context, ns string, sec string, pType string, rules [][]string) ([][]string, error) {
+	t.removed = rules
+	return rules, nil
+}
+
+func Test_Sync(t *testing.T) {
+	dir := fakeDirectory{"admins": {"alice", "bob"}, "devs": {"carol"}}
+	target := &fakeTarget{rules: [][]string{
+		{"alice", "ldap:admins"},
+		{"dave", "ldap:admins"},
+		// Not managed, the role has no prefix.
+		{"erin", "auditors"},
+	}}
+	s, err := New(&Config{Namespace: "default", PType: "g", RolePrefix: "ldap:", Interval: "1m"}, dir, target)
+	if err != nil {
+		t.Fatalf("failed to create syncer: %s", err.Error())
+	}
+	added, removed, err := s.Sync(context.Background())
+	if err != nil {
+		t.Fatalf("failed to sync: %s", err.Error())
+	}
+	if added != 2 || removed != 1 {
+		t.Fatalf("expected 2 added and 1 removed, got %d and %d", added, removed)
+	}
+	if exp := [][]string{{"bob", "ldap:admins"}, {"carol", "ldap:devs"}}; !reflect.DeepEqual(target.added, exp) {
+		t.Fatalf("wrong added policies, exp %v, got %v", exp, target.added)
+	}
+	if exp := [][]string{{"dave", "ldap:admins"}}; !reflect.DeepEqual(target.removed, exp) {
+		t.Fatalf("wrong removed policies, exp %v, got %v", exp, target.removed)
+	}
+}
+
+func Test_SyncEmptyDirectory(t *testing.T) {
+	target := &fakeTarget{rules: [][]string{{"alice", "admins"}}}
+	s, _ := New(&Config{Namespace: "default", PType: "g", Interval: "1m"}, fakeDirectory{}, target)
+	if _, _, err := s.Sync(context.Background()); err == nil {
+		t.Fatalf("expected sync to refuse removing every policy")
+	}
+	if target.removed != nil {
+		t.Fatalf("policies were removed: %v", target.removed)
+	}
+}
+
+func Test_MemberName(t *testing.T) {
+	for value, exp := range map[string]string{
+		"uid=alice,ou=people,dc=example,dc=com": "alice",
+		"UID=bob,ou=people,dc=example,dc=com":   "bob",
+		"cn=admins,ou=groups,dc=example,dc=com": "",
+		"carol":                                 "carol",
+	} {
+		if got := memberName(value, "uid"); got != exp {
+			t.Fatalf("wrong member name for %s, exp %q, got %q", value, exp, got)
+		}
+	}
+}
@@ -0,0 +1,196 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+// Package limit bounds the number of requests served at once. Requests over
+// the bound wait in a queue for a slot to free up, and are rejected when the
+// queue is full or once they waited for too long, so that an overloaded node
+// keeps its latency for the requests it accepts.
+package limit
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrQueueFull is returned when a request arrives with every slot taken
+	// and the queue full.
+	ErrQueueFull = errors.New("too many requests")
+	// ErrQueueTimeout is returned when a request waited in the queue for
+	// longer than the maximum wait.
+	ErrQueueTimeout = errors.New("timed out waiting for capacity")
+)
+
+// Config configures a Limiter.
+type Config struct {
+	// Concurrency is the number of requests served at once.
+	Concurrency int
+	// Queue is the number of requests waiting for a slot, no request waits
+	// if zero.
+	Queue int
+	// MaxWait is how long a request waits in the queue, until its context
+	// is done if zero.
+	MaxWait time.Duration
+}
+
+// Stats are the counters of a Limiter.
+type Stats struct {
+	InFlight int `json:"in_flight"`
+	Queued   int `json:"queued"`
+	// Rejected counts the requests rejected with ErrQueueFull.
+	Rejected uint64 `json:"rejected"`
+	// TimedOut counts the requests rejected with ErrQueueTimeout.
+	TimedOut uint64 `json:"timed_out"`
+	// Preempted counts the waiting requests rejected with ErrQueueFull to
+	// make room for a request of a higher priority.
+	Preempted uint64 `json:"preempted"`
+}
+
+// Limiter bounds the number of requests served at once. A nil Limiter does
+// not limit.
+type Limiter struct {
+	cfg Config
+
+	mu       sync.Mutex
+	inFlight int
+	// queue holds the waiting requests by decreasing priority, in arrival
+	// order within a priority.
+	queue     *list.List
+	rejected  uint64
+	timedOut  uint64
+	preempted uint64
+}
+
+type waiter struct {
+	priority Priority
+	// ready is closed once the waiter was handed a slot, or preempted.
+	ready chan struct{}
+	// preempted is set before ready is closed if the waiter was preempted.
+	preempted bool
+}
+
+// New returns a Limiter of cfg.
+func New(cfg Config) *Limiter {
+	return &Limiter{cfg: cfg, queue: list.New()}
+}
+
+// Acquire takes a slot, waiting in the queue if none is free, and returns
+// the function releasing it. It fails with ErrQueueFull, ErrQueueTimeout or
+// the error of ctx. The request has the priority of ctx: with the queue
+// full, it preempts the last request of a lower priority waiting.
+func (l *Limiter) Acquire(ctx context.Context) (release func(), err error) {
+	if l == nil {
+		return func() {}, nil
+	}
+	l.mu.Lock()
+	if l.inFlight < l.cfg.Concurrency {
+		l.inFlight++
+		l.mu.Unlock()
+		return l.releaser(), nil
+	}
+	w := &waiter{priority: PriorityFromContext(ctx), ready: make(chan struct{})}
+	if l.queue.Len() >= l.cfg.Queue {
+		last := l.queue.Back()
+		if last == nil || last.Value.(*waiter).priority >= w.priority {
+			l.rejected++
+			l.mu.Unlock()
+			return nil, ErrQueueFull
+		}
+		l.queue.Remove(last)
+		p := last.Value.(*waiter)
+		p.preempted = true
+		close(p.ready)
+		l.preempted++
+	}
+	e := l.enqueue(w)
+	l.mu.Unlock()
+
+	var timeout <-chan time.Time
+	if l.cfg.MaxWait > 0 {
+		t := time.NewTimer(l.cfg.MaxWait)
+		defer t.Stop()
+		timeout = t.C
+	}
+	select {
+	case <-w.ready:
+		if w.preempted {
+			return nil, ErrQueueFull
+		}
+		return l.releaser(), nil
+	case <-timeout:
+		err = ErrQueueTimeout
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	select {
+	case <-w.ready:
+		// handed a slot, or preempted, meanwhile
+		if w.preempted {
+			return nil, ErrQueueFull
+		}
+		return l.releaser(), nil
+	default:
+	}
+	l.queue.Remove(e)
+	if err == ErrQueueTimeout {
+		l.timedOut++
+	}
+	return nil, err
+}
+
+// enqueue inserts w after the waiters of the same or a higher priority.
+func (l *Limiter) enqueue(w *waiter) *list.Element {
+	for e := l.queue.Back(); e != nil; e = e.Prev() {
+		if e.Value.(*waiter).priority >= w.priority {
+			return l.queue.InsertAfter(w, e)
+		}
+	}
+	return l.queue.PushFront(w)
+}
+
+// releaser returns the function releasing a slot once, handing it to the
+// first waiting request if any.
+func (l *Limiter) releaser() func() {
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			l.mu.Lock()
+			defer l.mu.Unlock()
+			if e := l.queue.Front(); e != nil {
+				l.queue.Remove(e)
+				close(e.Value.(*waiter).ready)
+				return
+			}
+			l.inFlight--
+		})
+	}
+}
+
+// Stats returns the counters of the Limiter.
+func (l *Limiter) Stats() Stats {
+	if l == nil {
+		return Stats{}
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return Stats{InFlight: l.inFlight, Queued: l.queue.Len(), Rejected: l.rejected, TimedOut: l.timedOut, Preempted: l.preempted}
+}
@@ -0,0 +1,138 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package limit
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestLimiter(t *testing.T) {
+	l := New(Config{Concurrency: 1, Queue: 1, MaxWait: time.Minute})
+	release, err := l.Acquire(context.Background())
+	if err != nil {
+		t.Fatalf("failed to acquire: %s", err.Error())
+	}
+
+	acquired := make(chan func())
+	go func() {
+		r, err := l.Acquire(context.Background())
+		if err != nil {
+			t.Errorf("failed to acquire: %s", err.Error())
+		}
+		acquired <- r
+	}()
+	for l.Stats().Queued != 1 {
+		time.Sleep(time.Millisecond)
+	}
+	// The queue is full.
+	if _, err := l.Acquire(context.Background()); err != ErrQueueFull {
+		t.Fatalf("expected ErrQueueFull, got %v", err)
+	}
+
+	// The slot is handed to the waiting request.
+	release()
+	release()
+	r := <-acquired
+	if s := l.Stats(); s.InFlight != 1 || s.Queued != 0 || s.Rejected != 1 {
+		t.Fatalf("wrong stats %+v", s)
+	}
+	r()
+	if s := l.Stats(); s.InFlight != 0 {
+		t.Fatalf("wrong stats %+v", s)
+	}
+}
+
+func TestLimiterTimeout(t *testing.T) {
+	l := New(Config{Concurrency: 1, Queue: 10, MaxWait: 10 * time.Millisecond})
+	release, err := l.Acquire(context.Background())
+	if err != nil {
+		t.Fatalf("failed to acquire: %s", err.Error())
+	}
+	defer release()
+	if _, err := l.Acquire(context.Background()); err != ErrQueueTimeout {
+		t.Fatalf("expected ErrQueueTimeout, got %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := l.Acquire(ctx); err != context.Canceled {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+	if s := l.Stats(); s.Queued != 0 || s.TimedOut != 1 {
+		t.Fatalf("wrong stats %+v", s)
+	}
+}
+
+func TestNilLimiter(t *testing.T) {
+	var l *Limiter
+	release, err := l.Acquire(context.Background())
+	if err != nil {
+		t.Fatalf("failed to acquire: %s", err.Error())
+	}
+	release()
+}
+
+func TestLimiterPriority(t *testing.T) {
+	l := New(Config{Concurrency: 1, Queue: 2, MaxWait: time.Minute})
+	release, err := l.Acquire(context.Background())
+	if err != nil {
+		t.Fatalf("failed to acquire: %s", err.Error())
+	}
+
+	type result struct {
+		p       Priority
+		release func()
+		err     error
+	}
+	results := make(chan result, 3)
+	wait := func(p Priority, queued int) {
+		go func() {
+			r, err := l.Acquire(WithPriority(context.Background(), p))
+			results <- result{p, r, err}
+		}()
+		for l.Stats().Queued != queued {
+			time.Sleep(time.Millisecond)
+		}
+	}
+	wait(Low, 1)
+	wait(Normal, 2)
+	// The queue is full: the high priority request preempts the low one.
+	wait(High, 2)
+	if r := <-results; r.p != Low || r.err != ErrQueueFull {
+		t.Fatalf("expected the low priority request preempted, got %+v", r)
+	}
+	if _, err := l.Acquire(WithPriority(context.Background(), Low)); err != ErrQueueFull {
+		t.Fatalf("expected ErrQueueFull, got %v", err)
+	}
+
+	// Slots go to the higher priorities first.
+	release()
+	r := <-results
+	if r.p != High || r.err != nil {
+		t.Fatalf("expected the high priority request served, got %+v", r)
+	}
+	r.release()
+	if r = <-results; r.p != Normal || r.err != nil {
+		t.Fatalf("expected the normal priority request served, got %+v", r)
+	}
+	r.release()
+	if s := l.Stats(); s.InFlight != 0 || s.Preempted != 1 || s.Rejected != 1 {
+		t.Fatalf("wrong stats %+v", s)
+	}
+}
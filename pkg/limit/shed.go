@@ -0,0 +1,111 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package limit
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrShed is returned for the requests shed under stress.
+var ErrShed = errors.New("shedding load")
+
+// Threshold is a latency signal and the value above which it sheds load.
+type Threshold struct {
+	Name   string
+	Signal func() time.Duration
+	Max    time.Duration
+}
+
+// ShedStats are the state and counters of a Shedder.
+type ShedStats struct {
+	Shedding bool `json:"shedding"`
+	// Over names the signals over their threshold when shedding started.
+	Over []string `json:"over,omitempty"`
+	Shed uint64   `json:"shed"`
+}
+
+// Shedder sheds low-priority requests while a latency signal is over its
+// threshold. Signals are sampled at most once per interval. Once shedding,
+// it resumes when every signal is back under half its threshold, so that it
+// does not flap around the thresholds. A nil Shedder never sheds.
+type Shedder struct {
+	interval   time.Duration
+	thresholds []Threshold
+
+	mu       sync.Mutex
+	checked  time.Time
+	shedding bool
+	over     []string
+	shed     uint64
+}
+
+// NewShedder returns a Shedder sampling thresholds every interval.
+func NewShedder(interval time.Duration, thresholds ...Threshold) *Shedder {
+	return &Shedder{interval: interval, thresholds: thresholds}
+}
+
+// Allow reports whether a low-priority request may be served, or returns
+// ErrShed.
+func (s *Shedder) Allow() error {
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if now := time.Now(); now.Sub(s.checked) >= s.interval {
+		s.checked = now
+		s.update()
+	}
+	if s.shedding {
+		s.shed++
+		return ErrShed
+	}
+	return nil
+}
+
+func (s *Shedder) update() {
+	var over []string
+	calm := true
+	for _, t := range s.thresholds {
+		if t.Max <= 0 {
+			continue
+		}
+		v := t.Signal()
+		if v > t.Max {
+			over = append(over, t.Name)
+		}
+		if v > t.Max/2 {
+			calm = false
+		}
+	}
+	switch {
+	case len(over) > 0 && !s.shedding:
+		s.shedding, s.over = true, over
+	case calm:
+		s.shedding, s.over = false, nil
+	}
+}
+
+// Stats returns the state and counters of the Shedder.
+func (s *Shedder) Stats() ShedStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return ShedStats{Shedding: s.shedding, Over: s.over, Shed: s.shed}
+}
@@ -0,0 +1,61 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package limit
+
+import (
+	"testing"
+	"time"
+)
+
+func TestShedder(t *testing.T) {
+	var latency time.Duration
+	s := NewShedder(0,
+		Threshold{Name: "apply_latency", Signal: func() time.Duration { return latency }, Max: 100 * time.Millisecond},
+		Threshold{Name: "disabled", Signal: func() time.Duration { return time.Hour }})
+	if err := s.Allow(); err != nil {
+		t.Fatalf("shedding without stress: %s", err.Error())
+	}
+
+	latency = 200 * time.Millisecond
+	if err := s.Allow(); err != ErrShed {
+		t.Fatalf("expected ErrShed, got %v", err)
+	}
+	if st := s.Stats(); !st.Shedding || len(st.Over) != 1 || st.Over[0] != "apply_latency" || st.Shed != 1 {
+		t.Fatalf("wrong stats %+v", st)
+	}
+
+	// Shedding goes on until the signal is back under half the threshold.
+	latency = 80 * time.Millisecond
+	if err := s.Allow(); err != ErrShed {
+		t.Fatalf("expected ErrShed, got %v", err)
+	}
+	latency = 40 * time.Millisecond
+	if err := s.Allow(); err != nil {
+		t.Fatalf("still shedding: %s", err.Error())
+	}
+	if st := s.Stats(); st.Shedding || st.Shed != 2 {
+		t.Fatalf("wrong stats %+v", st)
+	}
+}
+
+func TestNilShedder(t *testing.T) {
+	var s *Shedder
+	if err := s.Allow(); err != nil {
+		t.Fatalf("nil Shedder shed: %s", err.Error())
+	}
+}
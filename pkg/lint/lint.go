@@ -0,0 +1,238 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+// Package lint finds the rules of a namespace that are likely mistakes, so
+// that CI pipelines can check them before or after they are applied.
+package lint
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/casbin/casbin-mesh/proto/command"
+	"github.com/casbin/casbin/v2"
+	"github.com/casbin/casbin/v2/model"
+)
+
+// Kind is the kind of a finding.
+type Kind string
+
+const (
+	// Duplicate rules are stored more than once.
+	Duplicate Kind = "duplicate"
+	// Shadowed rules don't change the decision of the request they are
+	// written for: other rules, or the effect, already decide it.
+	Shadowed Kind = "shadowed"
+	// OrphanedRole subjects are granted permissions, but nobody inherits
+	// them and they inherit no role.
+	OrphanedRole Kind = "orphaned_role"
+	// DanglingRole rules assign a role that grants nothing: it has no rules
+	// and inherits no role.
+	DanglingRole Kind = "dangling_role"
+)
+
+// Finding is a rule, or a subject, that is likely a mistake.
+type Finding struct {
+	Kind  Kind
+	Sec   string
+	PType string
+	// Rule is the rule of the finding, empty for orphaned roles.
+	Rule []string
+	// Subject is the role of orphaned and dangling role findings.
+	Subject string
+	Message string
+}
+
+// Run checks the rules of a namespace against its model text.
+//
+// Shadowing is checked for the rules of p, by enforcing the request made of
+// the values of each rule, with and without it. Models whose request
+// definition has tokens missing from the policy definition are skipped.
+//
+// Roles are the second field of the rules of g, whose first field is the
+// member, and the subject is the sub token of p. Domains are not told apart.
+func Run(text string, policies []*command.PolicyRules) ([]Finding, error) {
+	m, err := model.NewModelFromString(text)
+	if err != nil {
+		return nil, err
+	}
+	e, err := casbin.NewEnforcer(m)
+	if err != nil {
+		return nil, err
+	}
+	var findings []Finding
+	for _, p := range policies {
+		rules := command.ToStringArray(p.GetRules())
+		if _, ok := m[p.GetSec()][p.GetPType()]; !ok {
+			return nil, fmt.Errorf("policy type %s not defined in the model", p.GetPType())
+		}
+		m.AddPoliciesWithAffected(p.GetSec(), p.GetPType(), rules)
+		findings = append(findings, duplicates(p.GetSec(), p.GetPType(), rules)...)
+	}
+	if err := e.BuildRoleLinks(); err != nil {
+		return nil, err
+	}
+	findings = append(findings, shadowed(e, m)...)
+	findings = append(findings, roles(m)...)
+	return findings, nil
+}
+
+func ruleKey(rule []string) string {
+	return strings.Join(rule, "\x00")
+}
+
+// duplicates reports the rules seen more than once, once each.
+func duplicates(sec, pType string, rules [][]string) []Finding {
+	var findings []Finding
+	seen := make(map[string]int, len(rules))
+	for _, rule := range rules {
+		key := ruleKey(rule)
+		seen[key]++
+		if seen[key] == 2 {
+			findings = append(findings, Finding{Kind: Duplicate, Sec: sec, PType: pType, Rule: rule,
+				Message: "rule stored more than once"})
+		}
+	}
+	return findings
+}
+
+// requestFields returns the index in the rules of p of each token of the
+// request, or false if some token is missing from p.
+func requestFields(m model.Model) ([]int, bool) {
+	r, ok := m["r"]["r"]
+	if !ok {
+		return nil, false
+	}
+	p, ok := m["p"]["p"]
+	if !ok {
+		return nil, false
+	}
+	fields := make([]int, len(r.Tokens))
+	for i, token := range r.Tokens {
+		fields[i] = tokenIndex(p.Tokens, "p_"+strings.TrimPrefix(token, "r_"))
+		if fields[i] < 0 {
+			return nil, false
+		}
+	}
+	return fields, true
+}
+
+func tokenIndex(tokens []string, token string) int {
+	for i, t := range tokens {
+		if t == token {
+			return i
+		}
+	}
+	return -1
+}
+
+// shadowed reports the rules of p whose removal doesn't change the decision
+// of their own request. The copies of a duplicate rule are removed together,
+// so that they are only reported as duplicates.
+func shadowed(e *casbin.Enforcer, m model.Model) []Finding {
+	fields, ok := requestFields(m)
+	if !ok {
+		return nil
+	}
+	ast := m["p"]["p"]
+	all := ast.Policy
+	defer func() { ast.Policy = all }()
+
+	var findings []Finding
+	checked := make(map[string]bool, len(all))
+	for _, rule := range all {
+		key := ruleKey(rule)
+		if checked[key] || len(rule) < len(ast.Tokens) {
+			continue
+		}
+		checked[key] = true
+		request := make([]interface{}, len(fields))
+		for i, f := range fields {
+			request[i] = rule[f]
+		}
+
+		ast.Policy = all
+		with, err := e.Enforce(request...)
+		if err != nil {
+			continue
+		}
+		ast.Policy = make([][]string, 0, len(all))
+		for _, other := range all {
+			if ruleKey(other) != key {
+				ast.Policy = append(ast.Policy, other)
+			}
+		}
+		without, err := e.Enforce(request...)
+		if err != nil || with != without {
+			continue
+		}
+		findings = append(findings, Finding{Kind: Shadowed, Sec: "p", PType: "p", Rule: rule,
+			Message: fmt.Sprintf("removing the rule does not change the decision of %v, which is %t", request, with)})
+	}
+	return findings
+}
+
+// roles reports the orphaned subjects of p and the rules of g assigning
+// dangling roles.
+func roles(m model.Model) []Finding {
+	g, ok := m["g"]["g"]
+	if !ok {
+		return nil
+	}
+	p, ok := m["p"]["p"]
+	if !ok {
+		return nil
+	}
+	sub := tokenIndex(p.Tokens, "p_sub")
+	if sub < 0 {
+		return nil
+	}
+
+	granted := make(map[string]bool)
+	var subjects []string
+	for _, rule := range p.Policy {
+		if len(rule) > sub && !granted[rule[sub]] {
+			granted[rule[sub]] = true
+			subjects = append(subjects, rule[sub])
+		}
+	}
+	members := make(map[string]bool)
+	inherits := make(map[string]bool)
+	for _, rule := range g.Policy {
+		if len(rule) < 2 {
+			continue
+		}
+		inherits[rule[0]] = true
+		members[rule[1]] = true
+	}
+
+	var findings []Finding
+	for _, s := range subjects {
+		if !members[s] && !inherits[s] {
+			findings = append(findings, Finding{Kind: OrphanedRole, Sec: "p", PType: "p", Subject: s,
+				Message: fmt.Sprintf("%s is granted permissions but has no members", s)})
+		}
+	}
+	for _, rule := range g.Policy {
+		if len(rule) < 2 || granted[rule[1]] || inherits[rule[1]] {
+			continue
+		}
+		findings = append(findings, Finding{Kind: DanglingRole, Sec: "g", PType: "g", Rule: rule, Subject: rule[1],
+			Message: fmt.Sprintf("role %s grants nothing: it has no rules and inherits no role", rule[1])})
+	}
+	return findings
+}
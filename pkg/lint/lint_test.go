@@ -0,0 +1,106 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package lint
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/casbin/casbin-mesh/proto/command"
+)
+
+const modelText = `
+[request_definition]
+r = sub, obj, act
+
+[policy_definition]
+p = sub, obj, act
+
+[role_definition]
+g = _, _
+
+[policy_effect]
+e = some(where (p.eft == allow))
+
+[matchers]
+m = g(r.sub, p.sub) && r.obj == p.obj && r.act == p.act
+`
+
+func TestRun(t *testing.T) {
+	policies := []*command.PolicyRules{
+		{Sec: "p", PType: "p", Rules: command.NewStringArray([][]string{
+			{"admin", "data1", "read"},
+			{"admin", "data1", "read"},
+			{"alice", "data1", "read"},
+			{"auditor", "logs", "read"},
+		})},
+		{Sec: "g", PType: "g", Rules: command.NewStringArray([][]string{
+			{"alice", "admin"},
+			{"bob", "reader"},
+		})},
+	}
+	findings, err := Run(modelText, policies)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []Finding
+	for _, f := range findings {
+		f.Message = ""
+		got = append(got, f)
+	}
+	want := []Finding{
+		{Kind: Duplicate, Sec: "p", PType: "p", Rule: []string{"admin", "data1", "read"}},
+		// alice is granted data1 through admin already.
+		{Kind: Shadowed, Sec: "p", PType: "p", Rule: []string{"alice", "data1", "read"}},
+		{Kind: OrphanedRole, Sec: "p", PType: "p", Subject: "auditor"},
+		{Kind: DanglingRole, Sec: "g", PType: "g", Rule: []string{"bob", "reader"}, Subject: "reader"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("unexpected findings %+v", got)
+	}
+}
+
+func TestRunDenyOverride(t *testing.T) {
+	text := `
+[request_definition]
+r = sub, obj, act
+
+[policy_definition]
+p = sub, obj, act, eft
+
+[policy_effect]
+e = some(where (p.eft == allow)) && !some(where (p.eft == deny))
+
+[matchers]
+m = r.sub == p.sub && r.obj == p.obj && r.act == p.act
+`
+	policies := []*command.PolicyRules{
+		{Sec: "p", PType: "p", Rules: command.NewStringArray([][]string{
+			{"alice", "data1", "read", "allow"},
+			{"alice", "data1", "read", "deny"},
+			{"bob", "data2", "write", "allow"},
+		})},
+	}
+	findings, err := Run(text, policies)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(findings) != 1 || findings[0].Kind != Shadowed || !reflect.DeepEqual(findings[0].Rule, []string{"alice", "data1", "read", "allow"}) {
+		t.Fatalf("expected the allow rule of alice to be shadowed, got %+v", findings)
+	}
+}
@@ -19,13 +19,24 @@ package log
 
 import (
 	"fmt"
+	"io"
+
 	raftbadgerdb "github.com/BBVA/raft-badger"
 	"github.com/hashicorp/raft"
 )
 
+// ErrKeyNotFound is returned by the stable store of a Log for keys never set.
+var ErrKeyNotFound = raftbadgerdb.ErrKeyNotFound
+
 // Log is an object that can return information about the Raft log.
 type Log struct {
-	*raftbadgerdb.BadgerStore
+	logStore
+}
+
+// logStore holds the Raft log and the Raft stable store.
+type logStore interface {
+	raft.LogStore
+	raft.StableStore
 }
 
 // NewLog returns an instantiated Log object.
@@ -37,6 +48,19 @@ func NewLog(path string) (*Log, error) {
 	return &Log{bs}, nil
 }
 
+// NewInmemLog returns a Log held in memory, for tests.
+func NewInmemLog() *Log {
+	return &Log{raft.NewInmemStore()}
+}
+
+// Close closes the underlying store. Logs held in memory are kept.
+func (l *Log) Close() error {
+	if c, ok := l.logStore.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
 // Indexes returns the first and last indexes.
 func (l *Log) Indexes() (uint64, uint64, error) {
 	fi, err := l.FirstIndex()
@@ -0,0 +1,80 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package logsink
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"strconv"
+	"sync"
+)
+
+// journalSocket is where journald receives the messages of its native
+// protocol.
+var journalSocket = "/run/systemd/journal/socket"
+
+// journaldWriter sends each write as a journal entry, with its priority and
+// the tag as SYSLOG_IDENTIFIER.
+type journaldWriter struct {
+	tag  string
+	mu   sync.Mutex
+	conn *net.UnixConn
+}
+
+func newJournaldWriter(tag string) (*journaldWriter, error) {
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: journalSocket, Net: "unixgram"})
+	if err != nil {
+		return nil, err
+	}
+	return &journaldWriter{tag: tag, conn: conn}, nil
+}
+
+func (w *journaldWriter) Write(p []byte) (int, error) {
+	msg := bytes.TrimRight(p, "\n")
+	var b bytes.Buffer
+	writeField(&b, "MESSAGE", msg)
+	writeField(&b, "PRIORITY", []byte(strconv.Itoa(severity(msg))))
+	if w.tag != "" {
+		writeField(&b, "SYSLOG_IDENTIFIER", []byte(w.tag))
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if _, err := w.conn.Write(b.Bytes()); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// writeField writes a field of the native journal protocol, values with
+// newlines are sent with their length.
+func writeField(b *bytes.Buffer, name string, value []byte) {
+	b.WriteString(name)
+	if !bytes.ContainsRune(value, '\n') {
+		b.WriteByte('=')
+		b.Write(value)
+		b.WriteByte('\n')
+		return
+	}
+	b.WriteByte('\n')
+	var n [8]byte
+	binary.LittleEndian.PutUint64(n[:], uint64(len(value)))
+	b.Write(n[:])
+	b.Write(value)
+	b.WriteByte('\n')
+}
@@ -0,0 +1,175 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+// Package logsink sends the logs of a node to syslog or journald, so that
+// they are collected along with the logs of the host.
+package logsink
+
+import (
+	"bytes"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// Stderr writes the logs to the standard error, the default.
+	Stderr = "stderr"
+	// Syslog sends the logs to a syslog server in the RFC 5424 format.
+	Syslog = "syslog"
+	// Journald sends the logs to the systemd journal.
+	Journald = "journald"
+)
+
+// Config configures where the logs are sent.
+type Config struct {
+	// Output is Stderr, Syslog or Journald.
+	Output string
+	// SyslogAddr is the syslog server, as udp://, tcp:// or tls://host:port.
+	SyslogAddr string
+	// TLS configures the tls:// syslog connections.
+	TLS *tls.Config
+	// Tag identifies the node in the logs.
+	Tag string
+}
+
+// Open returns the writer sending the logs to cfg.Output. Each write is a
+// log message.
+func Open(cfg Config) (io.Writer, error) {
+	switch cfg.Output {
+	case "", Stderr:
+		return os.Stderr, nil
+	case Syslog:
+		return newSyslogWriter(cfg)
+	case Journald:
+		return newJournaldWriter(cfg.Tag)
+	}
+	return nil, fmt.Errorf("unknown log output %q, expected %s, %s or %s", cfg.Output, Stderr, Syslog, Journald)
+}
+
+// Syslog severities.
+const (
+	severityErr     = 3
+	severityWarning = 4
+	severityInfo    = 6
+)
+
+// severity guesses the severity of a log message, as the standard logger
+// has no levels.
+func severity(msg []byte) int {
+	lower := bytes.ToLower(msg)
+	switch {
+	case bytes.Contains(lower, []byte("[error]")), bytes.Contains(lower, []byte("fatal")), bytes.Contains(lower, []byte("failed")):
+		return severityErr
+	case bytes.Contains(lower, []byte("[warn]")), bytes.Contains(lower, []byte("warning")):
+		return severityWarning
+	}
+	return severityInfo
+}
+
+// facilityDaemon is the syslog facility of the messages.
+const facilityDaemon = 3
+
+// syslogWriter sends each write as a RFC 5424 message. Messages sent over
+// TCP or TLS are framed by octet counting, per RFC 6587 and RFC 5425. A
+// broken connection is dialed again on the next message.
+type syslogWriter struct {
+	network, addr string
+	tls           *tls.Config
+	tag, hostname string
+	mu            sync.Mutex
+	conn          net.Conn
+}
+
+func newSyslogWriter(cfg Config) (*syslogWriter, error) {
+	u, err := url.Parse(cfg.SyslogAddr)
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("invalid syslog address %q, expected udp://, tcp:// or tls://host:port", cfg.SyslogAddr)
+	}
+	switch u.Scheme {
+	case "udp", "tcp", "tls":
+	default:
+		return nil, fmt.Errorf("unsupported syslog protocol %q, expected udp, tcp or tls", u.Scheme)
+	}
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "-"
+	}
+	w := &syslogWriter{network: u.Scheme, addr: u.Host, tls: cfg.TLS, tag: nilValue(cfg.Tag), hostname: hostname}
+	if err := w.dial(); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+func (w *syslogWriter) dial() (err error) {
+	switch w.network {
+	case "tls":
+		w.conn, err = tls.DialWithDialer(&net.Dialer{Timeout: 5 * time.Second}, "tcp", w.addr, w.tls)
+	default:
+		w.conn, err = net.DialTimeout(w.network, w.addr, 5*time.Second)
+	}
+	return err
+}
+
+// format returns msg as a RFC 5424 message.
+func (w *syslogWriter) format(msg []byte) []byte {
+	msg = bytes.TrimRight(msg, "\n")
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "<%d>1 %s %s %s %d - - ", facilityDaemon*8+severity(msg),
+		time.Now().UTC().Format(time.RFC3339Nano), w.hostname, w.tag, os.Getpid())
+	b.Write(msg)
+	if w.network == "udp" {
+		return b.Bytes()
+	}
+	return append([]byte(fmt.Sprintf("%d ", b.Len())), b.Bytes()...)
+}
+
+func (w *syslogWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	msg := w.format(p)
+	if w.conn != nil {
+		if _, err := w.conn.Write(msg); err == nil {
+			return len(p), nil
+		}
+		w.conn.Close()
+		w.conn = nil
+	}
+	if err := w.dial(); err != nil {
+		return 0, err
+	}
+	if _, err := w.conn.Write(msg); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// nilValue returns s, or the RFC 5424 nil value if s is empty. Spaces are
+// not allowed in header fields.
+func nilValue(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return strings.ReplaceAll(s, " ", "_")
+}
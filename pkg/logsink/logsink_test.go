@@ -0,0 +1,141 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package logsink
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+var rfc5424 = regexp.MustCompile(`^<(\d+)>1 \S+ \S+ casmesh \d+ - - (.*)$`)
+
+func Test_SyslogUDP(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %s", err)
+	}
+	defer pc.Close()
+	w, err := Open(Config{Output: Syslog, SyslogAddr: "udp://" + pc.LocalAddr().String(), Tag: "casmesh"})
+	if err != nil {
+		t.Fatalf("failed to open syslog output: %s", err)
+	}
+	w.Write([]byte("failed to join cluster\n"))
+	buf := make([]byte, 1024)
+	n, _, err := pc.ReadFrom(buf)
+	if err != nil {
+		t.Fatalf("failed to read message: %s", err)
+	}
+	m := rfc5424.FindStringSubmatch(string(buf[:n]))
+	if m == nil || m[2] != "failed to join cluster" {
+		t.Fatalf("unexpected syslog message %q", buf[:n])
+	}
+	if pri, _ := strconv.Atoi(m[1]); pri != facilityDaemon*8+severityErr {
+		t.Fatalf("expected an error priority, got %d", pri)
+	}
+}
+
+func Test_SyslogTCPFraming(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %s", err)
+	}
+	defer ln.Close()
+	w, err := Open(Config{Output: Syslog, SyslogAddr: "tcp://" + ln.Addr().String(), Tag: "casmesh"})
+	if err != nil {
+		t.Fatalf("failed to open syslog output: %s", err)
+	}
+	conn, err := ln.Accept()
+	if err != nil {
+		t.Fatalf("failed to accept: %s", err)
+	}
+	defer conn.Close()
+	w.Write([]byte("node is ready\n"))
+	w.Write([]byte("WARNING: disk almost full\n"))
+	r := bufio.NewReader(conn)
+	for _, want := range []string{"node is ready", "WARNING: disk almost full"} {
+		size, err := r.ReadString(' ')
+		if err != nil {
+			t.Fatalf("failed to read frame: %s", err)
+		}
+		n, err := strconv.Atoi(strings.TrimSpace(size))
+		if err != nil {
+			t.Fatalf("invalid frame size %q", size)
+		}
+		msg := make([]byte, n)
+		if _, err := io.ReadFull(r, msg); err != nil {
+			t.Fatalf("failed to read message: %s", err)
+		}
+		if m := rfc5424.FindStringSubmatch(string(msg)); m == nil || m[2] != want {
+			t.Fatalf("unexpected syslog message %q", msg)
+		}
+	}
+}
+
+func Test_Journald(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "socket")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		t.Skipf("unix datagram sockets not supported: %s", err)
+	}
+	defer conn.Close()
+	defer func(old string) { journalSocket = old }(journalSocket)
+	journalSocket = path
+
+	w, err := Open(Config{Output: Journald, Tag: "casmesh"})
+	if err != nil {
+		t.Fatalf("failed to open journald output: %s", err)
+	}
+	w.Write([]byte("node is ready\n"))
+	buf := make([]byte, 1024)
+	n, err := conn.Read(buf)
+	if err != nil {
+		t.Fatalf("failed to read entry: %s", err)
+	}
+	if got := string(buf[:n]); got != "MESSAGE=node is ready\nPRIORITY=6\nSYSLOG_IDENTIFIER=casmesh\n" {
+		t.Fatalf("unexpected journal entry %q", got)
+	}
+
+	w.Write([]byte("line one\nline two\n"))
+	n, err = conn.Read(buf)
+	if err != nil {
+		t.Fatalf("failed to read entry: %s", err)
+	}
+	var size [8]byte
+	binary.LittleEndian.PutUint64(size[:], uint64(len("line one\nline two")))
+	want := append(append([]byte("MESSAGE\n"), size[:]...), "line one\nline two\n"...)
+	if !bytes.HasPrefix(buf[:n], want) {
+		t.Fatalf("unexpected multi-line journal entry %q", buf[:n])
+	}
+}
+
+func Test_OpenInvalid(t *testing.T) {
+	if _, err := Open(Config{Output: "kafka"}); err == nil {
+		t.Fatalf("expected an unknown output refused")
+	}
+	if _, err := Open(Config{Output: Syslog, SyslogAddr: "http://localhost:514"}); err == nil {
+		t.Fatalf("expected an unsupported protocol refused")
+	}
+}
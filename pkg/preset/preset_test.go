@@ -0,0 +1,83 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package preset
+
+import (
+	"testing"
+
+	"github.com/casbin/casbin/v2"
+	"github.com/casbin/casbin/v2/model"
+)
+
+func TestPresets(t *testing.T) {
+	tests := []struct {
+		preset string
+		rules  [][]string
+		groups [][]string
+		allow  []interface{}
+		deny   []interface{}
+	}{
+		{"acl", [][]string{{"alice", "data1", "read"}}, nil,
+			[]interface{}{"alice", "data1", "read"}, []interface{}{"alice", "data1", "write"}},
+		{"rbac", [][]string{{"admin", "data1", "read"}}, [][]string{{"alice", "admin"}},
+			[]interface{}{"alice", "data1", "read"}, []interface{}{"bob", "data1", "read"}},
+		{"rbac-with-domains", [][]string{{"admin", "tenant1", "data1", "read"}}, [][]string{{"alice", "admin", "tenant1"}},
+			[]interface{}{"alice", "tenant1", "data1", "read"}, []interface{}{"alice", "tenant2", "data1", "read"}},
+		{"abac", [][]string{{"r.sub == 'alice'", "data1", "read"}}, nil,
+			[]interface{}{"alice", "data1", "read"}, []interface{}{"bob", "data1", "read"}},
+		{"restful", [][]string{{"alice", "/users/:id", "(GET)|(PUT)"}}, nil,
+			[]interface{}{"alice", "/users/42", "PUT"}, []interface{}{"alice", "/users/42", "DELETE"}},
+		{"deny-override", [][]string{{"admin", "data1", "read", "allow"}, {"bob", "data1", "read", "deny"}}, [][]string{{"alice", "admin"}, {"bob", "admin"}},
+			[]interface{}{"alice", "data1", "read"}, []interface{}{"bob", "data1", "read"}},
+	}
+	if len(tests) != len(List()) {
+		t.Fatalf("expected a test for each of the %d presets", len(List()))
+	}
+	for _, tt := range tests {
+		p, err := Get(tt.preset)
+		if err != nil {
+			t.Fatalf("failed to get preset: %s", err.Error())
+		}
+		m, err := model.NewModelFromString(p.Text)
+		if err != nil {
+			t.Fatalf("invalid model of preset %s: %s", tt.preset, err.Error())
+		}
+		e, err := casbin.NewEnforcer(m)
+		if err != nil {
+			t.Fatalf("failed to create enforcer: %s", err.Error())
+		}
+		if _, err := e.AddPolicies(tt.rules); err != nil {
+			t.Fatalf("failed to add rules: %s", err.Error())
+		}
+		if len(tt.groups) > 0 {
+			if _, err := e.AddGroupingPolicies(tt.groups); err != nil {
+				t.Fatalf("failed to add grouping rules: %s", err.Error())
+			}
+		}
+		if ok, err := e.Enforce(tt.allow...); err != nil || !ok {
+			t.Fatalf("preset %s: expected %v to be allowed, got %v, %v", tt.preset, tt.allow, ok, err)
+		}
+		if ok, err := e.Enforce(tt.deny...); err != nil || ok {
+			t.Fatalf("preset %s: expected %v to be denied, got %v, %v", tt.preset, tt.deny, ok, err)
+		}
+	}
+
+	if _, err := Get("nope"); err == nil {
+		t.Fatalf("expected an error for an unknown preset")
+	}
+}
@@ -0,0 +1,242 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package scim
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+type member struct {
+	Value string `json:"value"`
+}
+
+type groupRequest struct {
+	DisplayName string   `json:"displayName"`
+	Members     []member `json:"members"`
+}
+
+func (s *Server) groupResource(d *directory, group string) map[string]interface{} {
+	members := make([]map[string]string, 0)
+	for _, user := range d.groupMembers(group) {
+		members = append(members, map[string]string{"value": user, "display": user, "$ref": s.location("Users", user)})
+	}
+	return map[string]interface{}{
+		"schemas":     []string{groupSchema},
+		"id":          group,
+		"displayName": group,
+		"members":     members,
+		"meta":        map[string]string{"resourceType": "Group", "location": s.location("Groups", group)},
+	}
+}
+
+func (s *Server) serveGroups(r *http.Request, id string) (int, interface{}, error) {
+	ctx := r.Context()
+	d, err := s.load(ctx)
+	if err != nil {
+		return 0, nil, err
+	}
+	if id == "" {
+		switch r.Method {
+		case http.MethodGet:
+			l, err := parseList(r, "displayName", "id")
+			if err != nil {
+				return 0, nil, err
+			}
+			var groups []string
+			for group := range d.groups {
+				groups = append(groups, group)
+			}
+			sort.Strings(groups)
+			return http.StatusOK, l.page(groups, func(group string) interface{} { return s.groupResource(d, group) }), nil
+		case http.MethodPost:
+			var req groupRequest
+			if err := decode(r, &req); err != nil {
+				return 0, nil, err
+			}
+			if req.DisplayName == "" {
+				return 0, nil, errorf(http.StatusBadRequest, "invalidValue", "displayName is required")
+			}
+			if d.groups[req.DisplayName] {
+				return 0, nil, errorf(http.StatusConflict, "uniqueness", "group %s already exists", req.DisplayName)
+			}
+			c := d.change()
+			c.ensure(s.cfg.GroupPrefix+req.DisplayName, s.cfg.GroupsRole)
+			for _, m := range req.Members {
+				c.ensure(m.Value, s.cfg.GroupPrefix+req.DisplayName)
+			}
+			return s.applyGroup(ctx, c, req.DisplayName, http.StatusCreated)
+		}
+		return 0, nil, errorf(http.StatusMethodNotAllowed, "", "method %s not allowed", r.Method)
+	}
+
+	if !d.groups[id] {
+		return 0, nil, errorf(http.StatusNotFound, "", "group %s not found", id)
+	}
+	role := s.cfg.GroupPrefix + id
+	switch r.Method {
+	case http.MethodGet:
+		return http.StatusOK, s.groupResource(d, id), nil
+	case http.MethodPut:
+		var req groupRequest
+		if err := decode(r, &req); err != nil {
+			return 0, nil, err
+		}
+		if req.DisplayName != "" && req.DisplayName != id {
+			return 0, nil, errorf(http.StatusBadRequest, "mutability", "displayName can't be changed")
+		}
+		c := d.change()
+		setMembers(c, d, id, role, req.Members)
+		return s.applyGroup(ctx, c, id, http.StatusOK)
+	case http.MethodPatch:
+		var req patchRequest
+		if err := decode(r, &req); err != nil {
+			return 0, nil, err
+		}
+		c := d.change()
+		for _, op := range req.Operations {
+			if err := patchMembers(c, d, id, role, op); err != nil {
+				return 0, nil, err
+			}
+		}
+		return s.applyGroup(ctx, c, id, http.StatusOK)
+	case http.MethodDelete:
+		c := d.change()
+		c.drop(role, s.cfg.GroupsRole)
+		for _, user := range d.groupMembers(id) {
+			c.drop(user, role)
+		}
+		if err := s.apply(ctx, c); err != nil {
+			return 0, nil, err
+		}
+		return http.StatusNoContent, nil, nil
+	}
+	return 0, nil, errorf(http.StatusMethodNotAllowed, "", "method %s not allowed", r.Method)
+}
+
+func (s *Server) applyGroup(ctx context.Context, c *change, group string, status int) (int, interface{}, error) {
+	if err := s.apply(ctx, c); err != nil {
+		return 0, nil, err
+	}
+	d, err := s.load(ctx)
+	if err != nil {
+		return 0, nil, err
+	}
+	return status, s.groupResource(d, group), nil
+}
+
+func setMembers(c *change, d *directory, group, role string, members []member) {
+	keep := make(map[string]bool, len(members))
+	for _, m := range members {
+		keep[m.Value] = true
+		c.ensure(m.Value, role)
+	}
+	for _, user := range d.groupMembers(group) {
+		if !keep[user] {
+			c.drop(user, role)
+		}
+	}
+}
+
+// patchMembers applies a PATCH operation on the members of a group, in the
+// forms used by Okta and Azure AD:
+//
+//	{"op": "add", "path": "members", "value": [{"value": "alice"}]}
+//	{"op": "remove", "path": "members[value eq \"alice\"]"}
+//	{"op": "replace", "value": {"members": [{"value": "alice"}]}}
+func patchMembers(c *change, d *directory, group, role string, op patchOp) error {
+	path := op.Path
+	value := op.Value
+	if path == "" {
+		var attrs map[string]json.RawMessage
+		if err := json.Unmarshal(op.Value, &attrs); err != nil {
+			return errorf(http.StatusBadRequest, "invalidValue", "invalid patch value: %s", err.Error())
+		}
+		for k, v := range attrs {
+			switch {
+			case strings.EqualFold(k, "displayName"):
+				if err := checkDisplayName(v, group); err != nil {
+					return err
+				}
+			case strings.EqualFold(k, "members"):
+				path, value = "members", v
+			}
+		}
+		if path == "" {
+			return nil
+		}
+	}
+
+	if strings.EqualFold(path, "displayName") {
+		return checkDisplayName(value, group)
+	}
+	var filtered string
+	if lower := strings.ToLower(path); strings.HasPrefix(lower, "members[") && strings.HasSuffix(path, "]") {
+		fields := strings.SplitN(path[len("members["):len(path)-1], " ", 3)
+		v, err := strconv.Unquote(strings.TrimSpace(fields[len(fields)-1]))
+		if len(fields) != 3 || !strings.EqualFold(fields[0], "value") || !strings.EqualFold(fields[1], "eq") || err != nil {
+			return errorf(http.StatusBadRequest, "invalidPath", "unsupported path %s", path)
+		}
+		filtered = v
+	} else if !strings.EqualFold(path, "members") {
+		return errorf(http.StatusBadRequest, "invalidPath", "unsupported path %s", path)
+	}
+
+	var members []member
+	if len(value) > 0 && string(value) != "null" {
+		if err := json.Unmarshal(value, &members); err != nil {
+			return errorf(http.StatusBadRequest, "invalidValue", "invalid members: %s", err.Error())
+		}
+	}
+	switch strings.ToLower(op.Op) {
+	case "add":
+		for _, m := range members {
+			c.ensure(m.Value, role)
+		}
+	case "remove":
+		switch {
+		case filtered != "":
+			c.drop(filtered, role)
+		case len(members) > 0:
+			for _, m := range members {
+				c.drop(m.Value, role)
+			}
+		default:
+			for _, user := range d.groupMembers(group) {
+				c.drop(user, role)
+			}
+		}
+	case "replace":
+		setMembers(c, d, group, role, members)
+	default:
+		return errorf(http.StatusBadRequest, "invalidSyntax", "unsupported operation %s", op.Op)
+	}
+	return nil
+}
+
+func checkDisplayName(raw json.RawMessage, group string) error {
+	var name string
+	if err := json.Unmarshal(raw, &name); err != nil || name != group {
+		return errorf(http.StatusBadRequest, "mutability", "displayName can't be changed")
+	}
+	return nil
+}
@@ -0,0 +1,257 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+// Package scim implements a SCIM 2.0 service provider, so identity providers
+// like Okta or Azure AD can provision users and group memberships as the
+// grouping policies of a namespace.
+package scim
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const (
+	userSchema     = "urn:ietf:params:scim:schemas:core:2.0:User"
+	groupSchema    = "urn:ietf:params:scim:schemas:core:2.0:Group"
+	listSchema     = "urn:ietf:params:scim:api:messages:2.0:ListResponse"
+	errorSchema    = "urn:ietf:params:scim:api:messages:2.0:Error"
+	patchSchema    = "urn:ietf:params:scim:api:messages:2.0:PatchOp"
+	spConfigSchema = "urn:ietf:params:scim:schemas:core:2.0:ServiceProviderConfig"
+
+	contentType = "application/scim+json"
+)
+
+// Config configures how users and groups map onto grouping policies.
+type Config struct {
+	// BasePath is the path the service is served under, e.g. /scim/v2.
+	BasePath  string
+	Namespace string
+	PType     string
+	// GroupPrefix is prepended to group names to form the roles.
+	GroupPrefix string
+	// UsersRole and GroupsRole are the roles recording which users and
+	// groups are provisioned.
+	UsersRole  string
+	GroupsRole string
+	// Token, if set, is the bearer token clients must present.
+	Token string
+}
+
+// Server is the SCIM service provider.
+type Server struct {
+	cfg    Config
+	target Target
+}
+
+// New returns a SCIM service provider storing users and groups in target.
+func New(cfg Config, target Target) *Server {
+	cfg.BasePath = strings.TrimSuffix(cfg.BasePath, "/")
+	return &Server{cfg: cfg, target: target}
+}
+
+// Error is a SCIM error response.
+type Error struct {
+	Status   int
+	ScimType string
+	Detail   string
+}
+
+func (e *Error) Error() string {
+	return e.Detail
+}
+
+func errorf(status int, scimType string, format string, args ...interface{}) *Error {
+	return &Error{Status: status, ScimType: scimType, Detail: fmt.Sprintf(format, args...)}
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", contentType)
+	if s.cfg.Token != "" {
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(token), []byte(s.cfg.Token)) != 1 {
+			s.writeError(w, errorf(http.StatusUnauthorized, "", "invalid bearer token"))
+			return
+		}
+	}
+	path := strings.Trim(strings.TrimPrefix(r.URL.Path, s.cfg.BasePath), "/")
+	parts := strings.SplitN(path, "/", 2)
+	id := ""
+	if len(parts) == 2 {
+		id = parts[1]
+	}
+
+	var status int
+	var resp interface{}
+	var err error
+	switch parts[0] {
+	case "ServiceProviderConfig":
+		status, resp = http.StatusOK, serviceProviderConfig()
+	case "Users":
+		status, resp, err = s.serveUsers(r, id)
+	case "Groups":
+		status, resp, err = s.serveGroups(r, id)
+	default:
+		err = errorf(http.StatusNotFound, "", "unknown resource %s", parts[0])
+	}
+	if err != nil {
+		s.writeError(w, err)
+		return
+	}
+	w.WriteHeader(status)
+	if resp != nil {
+		json.NewEncoder(w).Encode(resp)
+	}
+}
+
+func (s *Server) writeError(w http.ResponseWriter, err error) {
+	e, ok := err.(*Error)
+	if !ok {
+		e = &Error{Status: http.StatusInternalServerError, Detail: err.Error()}
+	}
+	resp := map[string]interface{}{
+		"schemas": []string{errorSchema},
+		"status":  strconv.Itoa(e.Status),
+		"detail":  e.Detail,
+	}
+	if e.ScimType != "" {
+		resp["scimType"] = e.ScimType
+	}
+	w.WriteHeader(e.Status)
+	json.NewEncoder(w).Encode(resp)
+}
+
+func (s *Server) location(resource, id string) string {
+	return s.cfg.BasePath + "/" + resource + "/" + id
+}
+
+func serviceProviderConfig() map[string]interface{} {
+	supported := func(ok bool) map[string]interface{} {
+		return map[string]interface{}{"supported": ok}
+	}
+	return map[string]interface{}{
+		"schemas":        []string{spConfigSchema},
+		"patch":          supported(true),
+		"bulk":           map[string]interface{}{"supported": false, "maxOperations": 0, "maxPayloadSize": 0},
+		"filter":         map[string]interface{}{"supported": true, "maxResults": 1000},
+		"changePassword": supported(false),
+		"sort":           supported(false),
+		"etag":           supported(false),
+		"authenticationSchemes": []map[string]interface{}{
+			{"type": "oauthbearertoken", "name": "OAuth Bearer Token", "description": "Authentication with a bearer token"},
+			{"type": "httpbasic", "name": "HTTP Basic", "description": "Authentication with the credentials of the node"},
+		},
+	}
+}
+
+// listRequest holds the filter and pagination of a list request.
+type listRequest struct {
+	attr, value  string
+	start, count int
+}
+
+// parseList parses the query of a list request. Only filters of the form
+// <attr> eq "<value>" are supported, which is what identity providers use to
+// look up resources.
+func parseList(r *http.Request, attrs ...string) (*listRequest, error) {
+	q := r.URL.Query()
+	l := &listRequest{start: 1, count: -1}
+	if v := q.Get("startIndex"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n > 1 {
+			l.start = n
+		}
+	}
+	if v := q.Get("count"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n >= 0 {
+			l.count = n
+		}
+	}
+	filter := strings.TrimSpace(q.Get("filter"))
+	if filter == "" {
+		return l, nil
+	}
+	fields := strings.SplitN(filter, " ", 3)
+	if len(fields) != 3 || !strings.EqualFold(fields[1], "eq") {
+		return nil, errorf(http.StatusBadRequest, "invalidFilter", "unsupported filter %s", filter)
+	}
+	for _, attr := range attrs {
+		if strings.EqualFold(fields[0], attr) {
+			l.attr = attr
+		}
+	}
+	value, err := strconv.Unquote(fields[2])
+	if l.attr == "" || err != nil {
+		return nil, errorf(http.StatusBadRequest, "invalidFilter", "unsupported filter %s", filter)
+	}
+	l.value = value
+	return l, nil
+}
+
+// page returns the ListResponse of the resources named names, which are
+// sorted.
+func (l *listRequest) page(names []string, resource func(string) interface{}) map[string]interface{} {
+	if l.attr != "" {
+		var matched []string
+		for _, name := range names {
+			if name == l.value {
+				matched = append(matched, name)
+			}
+		}
+		names = matched
+	}
+	total := len(names)
+	from := l.start - 1
+	if from > total {
+		from = total
+	}
+	names = names[from:]
+	if l.count >= 0 && l.count < len(names) {
+		names = names[:l.count]
+	}
+	resources := make([]interface{}, 0, len(names))
+	for _, name := range names {
+		resources = append(resources, resource(name))
+	}
+	return map[string]interface{}{
+		"schemas":      []string{listSchema},
+		"totalResults": total,
+		"startIndex":   l.start,
+		"itemsPerPage": len(resources),
+		"Resources":    resources,
+	}
+}
+
+type patchRequest struct {
+	Operations []patchOp `json:"Operations"`
+}
+
+type patchOp struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	Value json.RawMessage `json:"value"`
+}
+
+func decode(r *http.Request, v interface{}) error {
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		return errorf(http.StatusBadRequest, "invalidSyntax", "invalid request body: %s", err.Error())
+	}
+	return nil
+}
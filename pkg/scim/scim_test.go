@@ -0,0 +1,156 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package scim
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/casbin/casbin-mesh/proto/command"
+)
+
+type fakeTarget struct {
+	rules map[[2]string]bool
+}
+
+func (t *fakeTarget) NamespaceSnapshot(ctx context.Context, namespace string) (string, []*command.PolicyRules, uint64, error) {
+	var rules [][]string
+	for r := range t.rules {
+		rules = append(rules, []string{r[0], r[1]})
+	}
+	return "", []*command.PolicyRules{{Sec: "g", PType: "g", Rules: command.NewStringArray(rules)}}, 1, nil
+}
+
+func (t *fakeTarget) AddPolicies(ctx context.Context, ns string, sec string, pType string, rules [][]string) ([][]string, error) {
+	for _, r := range rules {
+		t.rules[[2]string{r[0], r[1]}] = true
+	}
+	return rules, nil
+}
+
+func (t *fakeTarget) RemovePolicies(ctx context.Context, ns string, sec string, pType string, rules [][]string) ([][]string, error) {
+	for _, r := range rules {
+		delete(t.rules, [2]string{r[0], r[1]})
+	}
+	return rules, nil
+}
+
+func (t *fakeTarget) list() []string {
+	var rules []string
+	for r := range t.rules {
+		rules = append(rules, r[0]+","+r[1])
+	}
+	sort.Strings(rules)
+	return rules
+}
+
+func newTestServer() (*Server, *fakeTarget) {
+	target := &fakeTarget{rules: make(map[[2]string]bool)}
+	return New(Config{BasePath: "/scim/v2", Namespace: "default", PType: "g", GroupPrefix: "scim:",
+		UsersRole: "scim-users", GroupsRole: "scim-groups", Token: "t0k3n"}, target), target
+}
+
+func do(t *testing.T, s *Server, method, path, body string) (int, map[string]interface{}) {
+	r := httptest.NewRequest(method, path, strings.NewReader(body))
+	r.Header.Set("Authorization", "Bearer t0k3n")
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, r)
+	var resp map[string]interface{}
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	return w.Code, resp
+}
+
+func Test_SCIMUsersAndGroups(t *testing.T) {
+	s, target := newTestServer()
+
+	if code, _ := do(t, s, "POST", "/scim/v2/Users", `{"userName": "alice", "active": true}`); code != http.StatusCreated {
+		t.Fatalf("failed to create user: %d", code)
+	}
+	do(t, s, "POST", "/scim/v2/Users", `{"userName": "bob"}`)
+	if code, _ := do(t, s, "POST", "/scim/v2/Users", `{"userName": "bob"}`); code != http.StatusConflict {
+		t.Fatalf("expected conflict creating bob twice, got %d", code)
+	}
+	code, resp := do(t, s, "POST", "/scim/v2/Groups", `{"displayName": "admins", "members": [{"value": "alice"}]}`)
+	if code != http.StatusCreated || len(resp["members"].([]interface{})) != 1 {
+		t.Fatalf("failed to create group: %d %v", code, resp)
+	}
+
+	// Okta adds members, Azure AD removes them with a filter.
+	do(t, s, "PATCH", "/scim/v2/Groups/admins", `{"Operations": [{"op": "add", "path": "members", "value": [{"value": "bob"}]}]}`)
+	do(t, s, "PATCH", "/scim/v2/Groups/admins", `{"Operations": [{"op": "Remove", "path": "members[value eq \"alice\"]"}]}`)
+	exp := []string{"alice,scim-users", "bob,scim-users", "bob,scim:admins", "scim:admins,scim-groups"}
+	if got := target.list(); !reflect.DeepEqual(got, exp) {
+		t.Fatalf("wrong policies, exp %v, got %v", exp, got)
+	}
+
+	code, resp = do(t, s, "GET", "/scim/v2/Users?filter="+"userName%20eq%20%22bob%22", "")
+	if code != http.StatusOK || resp["totalResults"].(float64) != 1 {
+		t.Fatalf("failed to filter users: %d %v", code, resp)
+	}
+	user := resp["Resources"].([]interface{})[0].(map[string]interface{})
+	if user["id"] != "bob" || len(user["groups"].([]interface{})) != 1 {
+		t.Fatalf("wrong user: %v", user)
+	}
+
+	// Deactivating a user removes it from its groups.
+	if code, _ := do(t, s, "PATCH", "/scim/v2/Users/bob", `{"Operations": [{"op": "Replace", "path": "active", "value": "False"}]}`); code != http.StatusOK {
+		t.Fatalf("failed to deactivate user: %d", code)
+	}
+	if code, _ := do(t, s, "GET", "/scim/v2/Users/bob", ""); code != http.StatusNotFound {
+		t.Fatalf("expected deactivated user to be gone, got %d", code)
+	}
+	if code, _ := do(t, s, "DELETE", "/scim/v2/Groups/admins", ""); code != http.StatusNoContent {
+		t.Fatalf("failed to delete group: %d", code)
+	}
+	if exp := []string{"alice,scim-users"}; !reflect.DeepEqual(target.list(), exp) {
+		t.Fatalf("wrong policies, exp %v, got %v", exp, target.list())
+	}
+}
+
+func Test_SCIMGroupReplace(t *testing.T) {
+	s, target := newTestServer()
+	do(t, s, "POST", "/scim/v2/Groups", `{"displayName": "devs", "members": [{"value": "alice"}, {"value": "bob"}]}`)
+	code, _ := do(t, s, "PATCH", "/scim/v2/Groups/devs", `{"Operations": [{"op": "replace", "value": {"displayName": "devs", "members": [{"value": "bob"}, {"value": "carol"}]}}]}`)
+	if code != http.StatusOK {
+		t.Fatalf("failed to replace members: %d", code)
+	}
+	exp := []string{"bob,scim:devs", "carol,scim:devs", "scim:devs,scim-groups"}
+	if got := target.list(); !reflect.DeepEqual(got, exp) {
+		t.Fatalf("wrong policies, exp %v, got %v", exp, got)
+	}
+	if code, _ := do(t, s, "PUT", "/scim/v2/Groups/devs", `{"displayName": "ops"}`); code != http.StatusBadRequest {
+		t.Fatalf("expected renaming to fail, got %d", code)
+	}
+}
+
+func Test_SCIMAuth(t *testing.T) {
+	s, _ := newTestServer()
+	r := httptest.NewRequest("GET", "/scim/v2/Users", nil)
+	r.Header.Set("Authorization", "Bearer wrong")
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, r)
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401, got %d", w.Code)
+	}
+}
@@ -0,0 +1,156 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package scim
+
+import (
+	"context"
+	"sort"
+
+	"github.com/casbin/casbin-mesh/proto/command"
+)
+
+// Target is the subset of core.Core the SCIM server needs.
+type Target interface {
+	NamespaceSnapshot(ctx context.Context, namespace string) (string, []*command.PolicyRules, uint64, error)
+	AddPolicies(ctx context.Context, ns string, sec string, pType string, rules [][]string) ([][]string, error)
+	RemovePolicies(ctx context.Context, ns string, sec string, pType string, rules [][]string) ([][]string, error)
+}
+
+// directory is the view of the grouping policies as users and groups:
+//
+//	g, <user>, <UsersRole>               the user is provisioned
+//	g, <GroupPrefix><group>, <GroupsRole> the group is provisioned
+//	g, <user>, <GroupPrefix><group>       the user is a member of the group
+type directory struct {
+	users   map[string]bool
+	groups  map[string]bool
+	members map[string]map[string]bool
+	rules   map[[2]string]bool
+}
+
+func (s *Server) load(ctx context.Context) (*directory, error) {
+	_, policies, _, err := s.target.NamespaceSnapshot(ctx, s.cfg.Namespace)
+	if err != nil {
+		return nil, err
+	}
+	d := &directory{
+		users:   make(map[string]bool),
+		groups:  make(map[string]bool),
+		members: make(map[string]map[string]bool),
+		rules:   make(map[[2]string]bool),
+	}
+	for _, p := range policies {
+		if p.GetSec() != "g" || p.GetPType() != s.cfg.PType {
+			continue
+		}
+		for _, rule := range command.ToStringArray(p.GetRules()) {
+			if len(rule) != 2 {
+				continue
+			}
+			d.rules[[2]string{rule[0], rule[1]}] = true
+		}
+	}
+	for rule := range d.rules {
+		switch {
+		case rule[1] == s.cfg.UsersRole:
+			d.users[rule[0]] = true
+		case rule[1] == s.cfg.GroupsRole:
+			if group, ok := s.groupName(rule[0]); ok {
+				d.groups[group] = true
+			}
+		default:
+			if group, ok := s.groupName(rule[1]); ok {
+				if d.members[group] == nil {
+					d.members[group] = make(map[string]bool)
+				}
+				d.members[group][rule[0]] = true
+			}
+		}
+	}
+	return d, nil
+}
+
+func (s *Server) groupName(role string) (string, bool) {
+	if len(role) <= len(s.cfg.GroupPrefix) || role[:len(s.cfg.GroupPrefix)] != s.cfg.GroupPrefix {
+		return "", false
+	}
+	return role[len(s.cfg.GroupPrefix):], true
+}
+
+func (d *directory) userGroups(user string) []string {
+	var groups []string
+	for group := range d.groups {
+		if d.members[group][user] {
+			groups = append(groups, group)
+		}
+	}
+	sort.Strings(groups)
+	return groups
+}
+
+func (d *directory) groupMembers(group string) []string {
+	var users []string
+	for user := range d.members[group] {
+		users = append(users, user)
+	}
+	sort.Strings(users)
+	return users
+}
+
+// change collects the policies to add and remove to apply an operation.
+type change struct {
+	d           *directory
+	add, remove [][]string
+	added, gone map[[2]string]bool
+}
+
+func (d *directory) change() *change {
+	return &change{d: d, added: make(map[[2]string]bool), gone: make(map[[2]string]bool)}
+}
+
+func (c *change) ensure(sub, role string) {
+	key := [2]string{sub, role}
+	if (c.d.rules[key] && !c.gone[key]) || c.added[key] {
+		return
+	}
+	c.added[key] = true
+	c.add = append(c.add, []string{sub, role})
+}
+
+func (c *change) drop(sub, role string) {
+	key := [2]string{sub, role}
+	if !c.d.rules[key] || c.gone[key] {
+		return
+	}
+	c.gone[key] = true
+	c.remove = append(c.remove, []string{sub, role})
+}
+
+func (s *Server) apply(ctx context.Context, c *change) error {
+	if len(c.remove) > 0 {
+		if _, err := s.target.RemovePolicies(ctx, s.cfg.Namespace, "g", s.cfg.PType, c.remove); err != nil {
+			return err
+		}
+	}
+	if len(c.add) > 0 {
+		if _, err := s.target.AddPolicies(ctx, s.cfg.Namespace, "g", s.cfg.PType, c.add); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,201 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package scim
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+type userRequest struct {
+	UserName string `json:"userName"`
+	Active   *bool  `json:"active"`
+}
+
+func (s *Server) userResource(d *directory, user string) map[string]interface{} {
+	groups := make([]map[string]string, 0)
+	for _, group := range d.userGroups(user) {
+		groups = append(groups, map[string]string{"value": group, "display": group, "$ref": s.location("Groups", group)})
+	}
+	return map[string]interface{}{
+		"schemas":  []string{userSchema},
+		"id":       user,
+		"userName": user,
+		"active":   d.users[user],
+		"groups":   groups,
+		"meta":     map[string]string{"resourceType": "User", "location": s.location("Users", user)},
+	}
+}
+
+func (s *Server) serveUsers(r *http.Request, id string) (int, interface{}, error) {
+	ctx := r.Context()
+	d, err := s.load(ctx)
+	if err != nil {
+		return 0, nil, err
+	}
+	if id == "" {
+		switch r.Method {
+		case http.MethodGet:
+			l, err := parseList(r, "userName", "id")
+			if err != nil {
+				return 0, nil, err
+			}
+			var users []string
+			for user := range d.users {
+				users = append(users, user)
+			}
+			sort.Strings(users)
+			return http.StatusOK, l.page(users, func(user string) interface{} { return s.userResource(d, user) }), nil
+		case http.MethodPost:
+			return s.createUser(ctx, r, d)
+		}
+		return 0, nil, errorf(http.StatusMethodNotAllowed, "", "method %s not allowed", r.Method)
+	}
+
+	if !d.users[id] {
+		return 0, nil, errorf(http.StatusNotFound, "", "user %s not found", id)
+	}
+	switch r.Method {
+	case http.MethodGet:
+		return http.StatusOK, s.userResource(d, id), nil
+	case http.MethodPut:
+		var req userRequest
+		if err := decode(r, &req); err != nil {
+			return 0, nil, err
+		}
+		if req.UserName != "" && req.UserName != id {
+			return 0, nil, errorf(http.StatusBadRequest, "mutability", "userName can't be changed")
+		}
+		if req.Active != nil && !*req.Active {
+			return s.deactivateUser(ctx, d, id)
+		}
+		return http.StatusOK, s.userResource(d, id), nil
+	case http.MethodPatch:
+		var req patchRequest
+		if err := decode(r, &req); err != nil {
+			return 0, nil, err
+		}
+		active, err := patchActive(req.Operations)
+		if err != nil {
+			return 0, nil, err
+		}
+		if active != nil && !*active {
+			return s.deactivateUser(ctx, d, id)
+		}
+		return http.StatusOK, s.userResource(d, id), nil
+	case http.MethodDelete:
+		if _, _, err := s.deactivateUser(ctx, d, id); err != nil {
+			return 0, nil, err
+		}
+		return http.StatusNoContent, nil, nil
+	}
+	return 0, nil, errorf(http.StatusMethodNotAllowed, "", "method %s not allowed", r.Method)
+}
+
+func (s *Server) createUser(ctx context.Context, r *http.Request, d *directory) (int, interface{}, error) {
+	var req userRequest
+	if err := decode(r, &req); err != nil {
+		return 0, nil, err
+	}
+	if req.UserName == "" {
+		return 0, nil, errorf(http.StatusBadRequest, "invalidValue", "userName is required")
+	}
+	if d.users[req.UserName] {
+		return 0, nil, errorf(http.StatusConflict, "uniqueness", "user %s already exists", req.UserName)
+	}
+	c := d.change()
+	c.ensure(req.UserName, s.cfg.UsersRole)
+	if err := s.apply(ctx, c); err != nil {
+		return 0, nil, err
+	}
+	d.users[req.UserName] = true
+	return http.StatusCreated, s.userResource(d, req.UserName), nil
+}
+
+// deactivateUser deprovisions the user, removing it from all its groups.
+func (s *Server) deactivateUser(ctx context.Context, d *directory, user string) (int, interface{}, error) {
+	c := d.change()
+	c.drop(user, s.cfg.UsersRole)
+	for _, group := range d.userGroups(user) {
+		c.drop(user, s.cfg.GroupPrefix+group)
+	}
+	if err := s.apply(ctx, c); err != nil {
+		return 0, nil, err
+	}
+	delete(d.users, user)
+	for _, members := range d.members {
+		delete(members, user)
+	}
+	return http.StatusOK, s.userResource(d, user), nil
+}
+
+// patchActive returns the value the operations set active to, if any. Other
+// attributes aren't stored and their changes are ignored.
+func patchActive(ops []patchOp) (*bool, error) {
+	var active *bool
+	for _, op := range ops {
+		switch strings.ToLower(op.Op) {
+		case "add", "replace":
+		default:
+			continue
+		}
+		var raw json.RawMessage
+		switch {
+		case strings.EqualFold(op.Path, "active"):
+			raw = op.Value
+		case op.Path == "":
+			var attrs map[string]json.RawMessage
+			if err := json.Unmarshal(op.Value, &attrs); err != nil {
+				return nil, errorf(http.StatusBadRequest, "invalidValue", "invalid patch value: %s", err.Error())
+			}
+			for k, v := range attrs {
+				if strings.EqualFold(k, "active") {
+					raw = v
+				}
+			}
+		}
+		if raw == nil {
+			continue
+		}
+		v, err := parseBool(raw)
+		if err != nil {
+			return nil, err
+		}
+		active = &v
+	}
+	return active, nil
+}
+
+// parseBool accepts booleans as well as the strings Azure AD sends.
+func parseBool(raw json.RawMessage) (bool, error) {
+	var b bool
+	if err := json.Unmarshal(raw, &b); err == nil {
+		return b, nil
+	}
+	var s string
+	if err := json.Unmarshal(raw, &s); err == nil {
+		if b, err := strconv.ParseBool(strings.ToLower(s)); err == nil {
+			return b, nil
+		}
+	}
+	return false, errorf(http.StatusBadRequest, "invalidValue", "invalid boolean %s", raw)
+}
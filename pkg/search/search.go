@@ -0,0 +1,335 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+// Package search filters, sorts and pages the rules of a namespace, so that
+// clients don't need to download every rule to find some.
+package search
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/casbin/casbin-mesh/proto/command"
+)
+
+const (
+	// DefaultLimit is the page size of queries without a limit.
+	DefaultLimit = 100
+	// MaxLimit is the largest page size.
+	MaxLimit = 1000
+)
+
+// Query selects rules. Every set criterion must match.
+type Query struct {
+	Sec   string
+	PType string
+	// Fields are exact values of rule fields, keyed by field index.
+	Fields map[int]string
+	// Contains is a case-insensitive substring of any field.
+	Contains string
+	// Regex matches any field.
+	Regex *regexp.Regexp
+	// Owner is the owner of the annotation of the rules.
+	Owner string
+	// Labels select rules by the labels of their annotation.
+	Labels Selector
+	// Sort is "sec", "ptype", "owner" or "v<N>", descending if prefixed by
+	// "-". Ties are ordered by sec, ptype and rule.
+	Sort   string
+	Limit  int
+	Cursor string
+}
+
+var fieldRe = regexp.MustCompile(`^v([0-9]+)$`)
+
+// ParseQuery parses a query from URL parameters: sec, ptype, v0, v1, ...,
+// q, regex, owner, label, sort, limit and cursor.
+func ParseQuery(values url.Values) (*Query, error) {
+	q := &Query{
+		Sec:      values.Get("sec"),
+		PType:    values.Get("ptype"),
+		Fields:   make(map[int]string),
+		Contains: values.Get("q"),
+		Owner:    values.Get("owner"),
+		Sort:     values.Get("sort"),
+		Limit:    DefaultLimit,
+		Cursor:   values.Get("cursor"),
+	}
+	for k := range values {
+		if m := fieldRe.FindStringSubmatch(k); m != nil {
+			i, err := strconv.Atoi(m[1])
+			if err != nil {
+				return nil, fmt.Errorf("invalid field %q", k)
+			}
+			q.Fields[i] = values.Get(k)
+		}
+	}
+	if expr := values.Get("regex"); expr != "" {
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid regex: %s", err.Error())
+		}
+		q.Regex = re
+	}
+	if s := values.Get("label"); s != "" {
+		selector, err := ParseSelector(s)
+		if err != nil {
+			return nil, err
+		}
+		q.Labels = selector
+	}
+	if s := values.Get("limit"); s != "" {
+		limit, err := strconv.Atoi(s)
+		if err != nil || limit <= 0 {
+			return nil, fmt.Errorf("invalid limit %q", s)
+		}
+		if limit > MaxLimit {
+			limit = MaxLimit
+		}
+		q.Limit = limit
+	}
+	if _, err := sortKey(q.Sort); err != nil {
+		return nil, err
+	}
+	if _, err := offset(q.Cursor); err != nil {
+		return nil, err
+	}
+	return q, nil
+}
+
+// Requirement is a term of a label selector.
+type Requirement struct {
+	Key string
+	// Value is compared if Op is "=" or "!=", "exists" and "!exists" only
+	// check the key.
+	Value string
+	Op    string
+}
+
+// Selector is a label selector, every requirement must be met.
+type Selector []Requirement
+
+// ParseSelector parses comma separated requirements: key=value, key==value,
+// key!=value, key and !key.
+func ParseSelector(s string) (Selector, error) {
+	var selector Selector
+	for _, term := range strings.Split(s, ",") {
+		term = strings.TrimSpace(term)
+		var r Requirement
+		switch {
+		case term == "":
+			return nil, fmt.Errorf("invalid label selector %q", s)
+		case strings.Contains(term, "!="):
+			parts := strings.SplitN(term, "!=", 2)
+			r = Requirement{Key: parts[0], Value: parts[1], Op: "!="}
+		case strings.Contains(term, "=="):
+			parts := strings.SplitN(term, "==", 2)
+			r = Requirement{Key: parts[0], Value: parts[1], Op: "="}
+		case strings.Contains(term, "="):
+			parts := strings.SplitN(term, "=", 2)
+			r = Requirement{Key: parts[0], Value: parts[1], Op: "="}
+		case strings.HasPrefix(term, "!"):
+			r = Requirement{Key: term[1:], Op: "!exists"}
+		default:
+			r = Requirement{Key: term, Op: "exists"}
+		}
+		r.Key = strings.TrimSpace(r.Key)
+		r.Value = strings.TrimSpace(r.Value)
+		if r.Key == "" {
+			return nil, fmt.Errorf("invalid label selector %q", s)
+		}
+		selector = append(selector, r)
+	}
+	return selector, nil
+}
+
+// Matches tells whether labels meet the selector.
+func (s Selector) Matches(labels map[string]string) bool {
+	for _, r := range s {
+		v, ok := labels[r.Key]
+		switch r.Op {
+		case "=":
+			if !ok || v != r.Value {
+				return false
+			}
+		case "!=":
+			if ok && v == r.Value {
+				return false
+			}
+		case "exists":
+			if !ok {
+				return false
+			}
+		case "!exists":
+			if ok {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// Result is a rule matching a query, along with its annotation.
+type Result struct {
+	Sec        string
+	PType      string
+	Rule       []string
+	Annotation *command.Annotation
+}
+
+// Page is a page of the rules matching a query.
+type Page struct {
+	Results []Result
+	// Total is the number of matching rules.
+	Total int
+	// NextCursor gets the next page, it is empty on the last page.
+	NextCursor string
+}
+
+// Search returns the page of the rules of policies matching q.
+func Search(policies []*command.PolicyRules, annotations []*command.PolicyAnnotation, q *Query) (*Page, error) {
+	less, err := sortKey(q.Sort)
+	if err != nil {
+		return nil, err
+	}
+	start, err := offset(q.Cursor)
+	if err != nil {
+		return nil, err
+	}
+	byRule := make(map[string]*command.Annotation, len(annotations))
+	for _, a := range annotations {
+		byRule[key(a.Sec, a.PType, a.Rule)] = a.Annotation
+	}
+	var matches []Result
+	for _, p := range policies {
+		for _, rule := range command.ToStringArray(p.Rules) {
+			r := Result{Sec: p.Sec, PType: p.PType, Rule: rule, Annotation: byRule[key(p.Sec, p.PType, rule)]}
+			if q.Matches(r) {
+				matches = append(matches, r)
+			}
+		}
+	}
+	sort.SliceStable(matches, func(i, j int) bool {
+		if c := less(matches[i], matches[j]); c != 0 {
+			return c < 0
+		}
+		return key(matches[i].Sec, matches[i].PType, matches[i].Rule) < key(matches[j].Sec, matches[j].PType, matches[j].Rule)
+	})
+	page := &Page{Total: len(matches)}
+	if start >= len(matches) {
+		return page, nil
+	}
+	limit := q.Limit
+	if limit <= 0 {
+		limit = DefaultLimit
+	}
+	end := start + limit
+	if end < len(matches) {
+		page.NextCursor = strconv.Itoa(end)
+	} else {
+		end = len(matches)
+	}
+	page.Results = matches[start:end]
+	return page, nil
+}
+
+// Matches tells whether r matches q. Sort, limit and cursor are ignored.
+func (q *Query) Matches(r Result) bool {
+	if q.Sec != "" && r.Sec != q.Sec || q.PType != "" && r.PType != q.PType {
+		return false
+	}
+	for i, v := range q.Fields {
+		if i >= len(r.Rule) || r.Rule[i] != v {
+			return false
+		}
+	}
+	if q.Contains != "" && !anyField(r.Rule, func(f string) bool {
+		return strings.Contains(strings.ToLower(f), strings.ToLower(q.Contains))
+	}) {
+		return false
+	}
+	if q.Regex != nil && !anyField(r.Rule, q.Regex.MatchString) {
+		return false
+	}
+	if q.Owner != "" && r.Annotation.GetOwner() != q.Owner {
+		return false
+	}
+	return q.Labels.Matches(r.Annotation.GetLabels())
+}
+
+func anyField(rule []string, fn func(string) bool) bool {
+	for _, f := range rule {
+		if fn(f) {
+			return true
+		}
+	}
+	return false
+}
+
+func key(sec, pType string, rule []string) string {
+	return sec + "\x00" + pType + "\x00" + strings.Join(rule, "\x00")
+}
+
+// sortKey returns the comparison of results by field.
+func sortKey(field string) (func(a, b Result) int, error) {
+	desc := strings.HasPrefix(field, "-")
+	field = strings.TrimPrefix(field, "-")
+	var value func(r Result) string
+	switch field {
+	case "", "sec":
+		value = func(r Result) string { return r.Sec }
+	case "ptype":
+		value = func(r Result) string { return r.PType }
+	case "owner":
+		value = func(r Result) string { return r.Annotation.GetOwner() }
+	default:
+		m := fieldRe.FindStringSubmatch(field)
+		if m == nil {
+			return nil, fmt.Errorf("invalid sort %q", field)
+		}
+		i, _ := strconv.Atoi(m[1])
+		value = func(r Result) string {
+			if i < len(r.Rule) {
+				return r.Rule[i]
+			}
+			return ""
+		}
+	}
+	return func(a, b Result) int {
+		c := strings.Compare(value(a), value(b))
+		if desc {
+			return -c
+		}
+		return c
+	}, nil
+}
+
+// offset decodes a cursor, the position of the first result of the page.
+func offset(cursor string) (int, error) {
+	if cursor == "" {
+		return 0, nil
+	}
+	n, err := strconv.Atoi(cursor)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid cursor %q", cursor)
+	}
+	return n, nil
+}
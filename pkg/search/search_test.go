@@ -0,0 +1,110 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package search
+
+import (
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/casbin/casbin-mesh/proto/command"
+)
+
+var policies = []*command.PolicyRules{
+	{Sec: "p", PType: "p", Rules: command.NewStringArray([][]string{
+		{"alice", "/orders/1", "read"},
+		{"bob", "/orders/2", "write"},
+		{"carol", "/billing", "read"},
+	})},
+	{Sec: "g", PType: "g", Rules: command.NewStringArray([][]string{
+		{"alice", "admin"},
+	})},
+}
+
+var annotations = []*command.PolicyAnnotation{
+	{Sec: "p", PType: "p", Rule: []string{"alice", "/orders/1", "read"},
+		Annotation: &command.Annotation{Owner: "team-a", Labels: map[string]string{"env": "prod"}}},
+	{Sec: "p", PType: "p", Rule: []string{"carol", "/billing", "read"},
+		Annotation: &command.Annotation{Owner: "team-b", Labels: map[string]string{"env": "dev"}}},
+}
+
+func search(t *testing.T, query string) ([]string, *Page) {
+	values, err := url.ParseQuery(query)
+	if err != nil {
+		t.Fatalf("failed to parse %q: %s", query, err.Error())
+	}
+	q, err := ParseQuery(values)
+	if err != nil {
+		t.Fatalf("failed to parse query %q: %s", query, err.Error())
+	}
+	page, err := Search(policies, annotations, q)
+	if err != nil {
+		t.Fatalf("failed to search %q: %s", query, err.Error())
+	}
+	var out []string
+	for _, r := range page.Results {
+		out = append(out, strings.Join(r.Rule, ","))
+	}
+	return out, page
+}
+
+func Test_Search(t *testing.T) {
+	tests := []struct {
+		query string
+		exp   []string
+	}{
+		{"", []string{"alice,admin", "alice,/orders/1,read", "bob,/orders/2,write", "carol,/billing,read"}},
+		{"ptype=p&v2=read", []string{"alice,/orders/1,read", "carol,/billing,read"}},
+		{"q=ORDERS", []string{"alice,/orders/1,read", "bob,/orders/2,write"}},
+		{"regex=^/orders/[0-9]%2B$&v0=bob", []string{"bob,/orders/2,write"}},
+		{"label=env=prod", []string{"alice,/orders/1,read"}},
+		{"label=env,env!=prod", []string{"carol,/billing,read"}},
+		{"label=!env&sec=p", []string{"bob,/orders/2,write"}},
+		{"owner=team-b", []string{"carol,/billing,read"}},
+		{"sec=p&sort=-v0", []string{"carol,/billing,read", "bob,/orders/2,write", "alice,/orders/1,read"}},
+	}
+	for _, tt := range tests {
+		if got, _ := search(t, tt.query); !reflect.DeepEqual(got, tt.exp) {
+			t.Fatalf("wrong results for %q, got %v, exp %v", tt.query, got, tt.exp)
+		}
+	}
+}
+
+func Test_SearchPages(t *testing.T) {
+	got, page := search(t, "sec=p&limit=2")
+	if !reflect.DeepEqual(got, []string{"alice,/orders/1,read", "bob,/orders/2,write"}) || page.Total != 3 {
+		t.Fatalf("wrong first page %v of %d", got, page.Total)
+	}
+	if page.Results[0].Annotation.GetOwner() != "team-a" {
+		t.Fatalf("annotation missing from result")
+	}
+	got, page = search(t, "sec=p&limit=2&cursor="+page.NextCursor)
+	if !reflect.DeepEqual(got, []string{"carol,/billing,read"}) || page.NextCursor != "" {
+		t.Fatalf("wrong last page %v, next cursor %q", got, page.NextCursor)
+	}
+}
+
+func Test_ParseQueryErrors(t *testing.T) {
+	for _, query := range []string{"regex=(", "limit=0", "sort=name", "cursor=x", "label=a,,b"} {
+		values, _ := url.ParseQuery(query)
+		if _, err := ParseQuery(values); err == nil {
+			t.Fatalf("expected error for %q", query)
+		}
+	}
+}
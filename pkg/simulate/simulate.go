@@ -0,0 +1,144 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+// Package simulate previews how a change to the rules of a namespace would
+// affect its decisions, without applying the change.
+package simulate
+
+import (
+	"fmt"
+
+	"github.com/casbin/casbin-mesh/proto/command"
+	"github.com/casbin/casbin/v2"
+	"github.com/casbin/casbin/v2/model"
+)
+
+// Change is a set of rules of a policy type.
+type Change struct {
+	Sec   string
+	PType string
+	Rules [][]string
+}
+
+// Delta is a proposed change to the rules of a namespace. Removals are
+// applied before additions.
+type Delta struct {
+	Add    []Change
+	Remove []Change
+}
+
+// Result is the outcome of a request before and after the delta.
+type Result struct {
+	Request []interface{}
+	Before  bool
+	After   bool
+	Error   string
+}
+
+// Report lists the requests whose decision the delta flips.
+type Report struct {
+	Total int
+	// Granted and Revoked count the requests the delta allows and denies.
+	Granted int
+	Revoked int
+	Flipped []Result
+	// Failed are the requests that could not be enforced.
+	Failed []Result
+}
+
+// Run enforces requests against the model text and rules of a namespace,
+// before and after applying d to a copy of them.
+func Run(text string, policies []*command.PolicyRules, d Delta, requests [][]interface{}) (*Report, error) {
+	m, err := model.NewModelFromString(text)
+	if err != nil {
+		return nil, err
+	}
+	e, err := casbin.NewEnforcer(m)
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range policies {
+		if err := addRules(m, p.GetSec(), p.GetPType(), command.ToStringArray(p.GetRules())); err != nil {
+			return nil, err
+		}
+	}
+	if err := e.BuildRoleLinks(); err != nil {
+		return nil, err
+	}
+
+	results := make([]Result, len(requests))
+	for i, r := range requests {
+		results[i].Request = r
+		results[i].Before, err = e.Enforce(r...)
+		if err != nil {
+			results[i].Error = err.Error()
+		}
+	}
+
+	for _, c := range d.Remove {
+		if err := checkType(m, c.Sec, c.PType); err != nil {
+			return nil, err
+		}
+		m.RemovePoliciesWithEffected(c.Sec, c.PType, c.Rules)
+	}
+	for _, c := range d.Add {
+		if err := addRules(m, c.Sec, c.PType, c.Rules); err != nil {
+			return nil, err
+		}
+	}
+	if err := e.BuildRoleLinks(); err != nil {
+		return nil, err
+	}
+
+	report := &Report{Total: len(requests)}
+	for i := range results {
+		r := &results[i]
+		if r.Error == "" {
+			r.After, err = e.Enforce(r.Request...)
+			if err != nil {
+				r.Error = err.Error()
+			}
+		}
+		switch {
+		case r.Error != "":
+			report.Failed = append(report.Failed, *r)
+		case r.Before != r.After:
+			report.Flipped = append(report.Flipped, *r)
+			if r.After {
+				report.Granted++
+			} else {
+				report.Revoked++
+			}
+		}
+	}
+	return report, nil
+}
+
+func checkType(m model.Model, sec, pType string) error {
+	if _, ok := m[sec][pType]; !ok {
+		return fmt.Errorf("policy type %s not defined in the model", pType)
+	}
+	return nil
+}
+
+func addRules(m model.Model, sec, pType string, rules [][]string) error {
+	if err := checkType(m, sec, pType); err != nil {
+		return err
+	}
+	m.AddPoliciesWithAffected(sec, pType, rules)
+	return nil
+}
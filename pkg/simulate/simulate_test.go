@@ -0,0 +1,104 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package simulate
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/casbin/casbin-mesh/proto/command"
+)
+
+const modelText = `
+[request_definition]
+r = sub, obj, act
+
+[policy_definition]
+p = sub, obj, act
+
+[role_definition]
+g = _, _
+
+[policy_effect]
+e = some(where (p.eft == allow))
+
+[matchers]
+m = g(r.sub, p.sub) && r.obj == p.obj && r.act == p.act
+`
+
+var policies = []*command.PolicyRules{
+	{Sec: "p", PType: "p", Rules: command.NewStringArray([][]string{
+		{"admin", "data1", "read"},
+		{"bob", "data2", "write"},
+	})},
+	{Sec: "g", PType: "g", Rules: command.NewStringArray([][]string{
+		{"alice", "admin"},
+	})},
+}
+
+func TestRun(t *testing.T) {
+	d := Delta{
+		Remove: []Change{{Sec: "g", PType: "g", Rules: [][]string{{"alice", "admin"}}}},
+		Add:    []Change{{Sec: "p", PType: "p", Rules: [][]string{{"carol", "data1", "read"}}}},
+	}
+	requests := [][]interface{}{
+		{"alice", "data1", "read"},
+		{"bob", "data2", "write"},
+		{"carol", "data1", "read"},
+	}
+	report, err := Run(modelText, policies, d, requests)
+	if err != nil {
+		t.Fatalf("failed to run simulation: %s", err.Error())
+	}
+	want := &Report{
+		Total:   3,
+		Granted: 1,
+		Revoked: 1,
+		Flipped: []Result{
+			{Request: []interface{}{"alice", "data1", "read"}, Before: true, After: false},
+			{Request: []interface{}{"carol", "data1", "read"}, Before: false, After: true},
+		},
+	}
+	if !reflect.DeepEqual(want, report) {
+		t.Fatalf("unexpected report: %+v", report)
+	}
+
+	// the rules passed in are left untouched
+	report, err = Run(modelText, policies, Delta{}, requests)
+	if err != nil {
+		t.Fatalf("failed to run simulation: %s", err.Error())
+	}
+	if len(report.Flipped) != 0 {
+		t.Fatalf("expected no flipped decisions, got %+v", report.Flipped)
+	}
+}
+
+func TestRunErrors(t *testing.T) {
+	d := Delta{Add: []Change{{Sec: "p", PType: "p2", Rules: [][]string{{"carol", "data1", "read"}}}}}
+	if _, err := Run(modelText, policies, d, nil); err == nil {
+		t.Fatalf("expected an error for an undefined policy type")
+	}
+
+	report, err := Run(modelText, policies, Delta{}, [][]interface{}{{"alice", "data1"}})
+	if err != nil {
+		t.Fatalf("failed to run simulation: %s", err.Error())
+	}
+	if len(report.Failed) != 1 {
+		t.Fatalf("expected a failed request, got %+v", report)
+	}
+}
@@ -0,0 +1,375 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+// Package standby replicates the namespaces of a primary cluster into a
+// standby cluster, usually in another region, by tailing the change feed of
+// the primary. The standby cluster stays read-only until it is promoted.
+package standby
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/casbin/casbin-mesh/pkg/store"
+	"github.com/casbin/casbin-mesh/proto/command"
+)
+
+// ErrPrimaryUnreachable is returned when no node of the primary cluster
+// answers.
+var ErrPrimaryUnreachable = errors.New("primary cluster unreachable")
+
+// errCompacted is returned by the primary when the feed can no longer be
+// resumed, see store.ErrChangesCompacted.
+var errCompacted = errors.New("changes compacted")
+
+const (
+	// retryInterval is how long the agent waits after a failure.
+	retryInterval = 5 * time.Second
+	// pageLimit is the number of changes read at once.
+	pageLimit = 500
+)
+
+// Config configures the replication from the primary cluster.
+type Config struct {
+	// Primary lists the API addresses of nodes of the primary cluster.
+	Primary []string
+	// Username and Password authenticate to the primary, if set.
+	Username string
+	Password string
+	TLS      *tls.Config
+	// Wait is how long a request for changes waits for new ones.
+	Wait time.Duration
+	// Namespaces selects the namespaces replicated, as patterns matched
+	// with path.Match, all of them if empty.
+	Namespaces []string
+}
+
+// Target is the subset of core.Core the Agent needs.
+type Target interface {
+	IsLeader(ctx context.Context) bool
+	ReadOnly(ctx context.Context) store.ReadOnlyStatus
+	SetReadOnly(ctx context.Context, enabled bool, reason string) (bool, error)
+	ApplyChange(ctx context.Context, ch store.ChangeEvent) error
+	SeedNamespace(ctx context.Context, ns, model string, policies []*command.PolicyRules, index uint64) error
+	StandbyPosition(ctx context.Context) store.StandbyPosition
+}
+
+// Status is the replication status of the standby cluster.
+type Status struct {
+	// Promoted is set once the standby cluster took over, it no longer
+	// replicates the primary.
+	Promoted bool `json:"promoted"`
+	// Primary is the node of the primary cluster last read from.
+	Primary string `json:"primary,omitempty"`
+	// Index is the index of the primary the standby replicated up to.
+	Index uint64 `json:"index"`
+	// PrimaryIndex is the index the primary had applied up to when last
+	// read from.
+	PrimaryIndex uint64 `json:"primary_index"`
+	// Lag is the number of entries of the primary not replicated yet.
+	Lag uint64 `json:"lag"`
+	// LastSync is when the primary was last read from.
+	LastSync time.Time `json:"last_sync,omitempty"`
+	// CaughtUp is when the standby last replicated all the changes of the
+	// primary, the standby misses at most the changes made since.
+	CaughtUp time.Time `json:"caught_up,omitempty"`
+	Error    string    `json:"error,omitempty"`
+}
+
+// Agent replicates the primary cluster into the standby cluster. Changes go
+// through Raft, so only the leader of the standby cluster replicates.
+type Agent struct {
+	cfg    Config
+	target Target
+	client *http.Client
+
+	// mu serializes the replication and the promotion.
+	mu sync.Mutex
+	// checked is set once the namespaces selected were checked to be
+	// replicated, which they may not be if the selection was widened.
+	checked  bool
+	statusMu sync.RWMutex
+	status   Status
+
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// New returns an Agent replicating the primary cluster of cfg into target.
+func New(cfg Config, target Target) (*Agent, error) {
+	if len(cfg.Primary) == 0 {
+		return nil, errors.New("no primary address")
+	}
+	// the request for changes is held for up to Wait
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: cfg.TLS}, Timeout: cfg.Wait + 30*time.Second}
+	return &Agent{cfg: cfg, target: target, client: client}, nil
+}
+
+// Start replicates the primary until Close is called.
+func (a *Agent) Start() {
+	var ctx context.Context
+	ctx, a.cancel = context.WithCancel(context.Background())
+	a.wg.Add(1)
+	go func() {
+		defer a.wg.Done()
+		for ctx.Err() == nil {
+			wait := time.Duration(0)
+			if !a.target.IsLeader(ctx) {
+				wait = retryInterval
+			} else if err := a.Sync(ctx); errors.Is(err, errPromoted) {
+				wait = retryInterval
+			} else if err != nil && ctx.Err() == nil {
+				log.Printf("failed to replicate the primary cluster: %s", err.Error())
+				a.setError(err)
+				wait = retryInterval
+			}
+			select {
+			case <-ctx.Done():
+			case <-time.After(wait):
+			}
+		}
+	}()
+}
+
+// Close stops the Agent, waiting for the replication in progress until ctx
+// is done.
+func (a *Agent) Close(ctx context.Context) {
+	a.cancel()
+	done := make(chan struct{})
+	go func() {
+		a.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+	}
+}
+
+// Status returns the replication status.
+func (a *Agent) Status() Status {
+	a.statusMu.RLock()
+	defer a.statusMu.RUnlock()
+	return a.status
+}
+
+func (a *Agent) setError(err error) {
+	a.statusMu.Lock()
+	defer a.statusMu.Unlock()
+	a.status.Error = err.Error()
+}
+
+// errPromoted is returned once the standby cluster was promoted.
+var errPromoted = errors.New("standby cluster promoted")
+
+// Sync replicates one page of changes of the primary, waiting up to
+// Config.Wait for them. A standby cluster which never replicated is made
+// read-only first, and a standby cluster which is no longer read-only was
+// promoted and is left alone.
+func (a *Agent) Sync(ctx context.Context) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.sync(ctx, a.cfg.Wait)
+}
+
+func (a *Agent) sync(ctx context.Context, wait time.Duration) error {
+	pos := a.target.StandbyPosition(ctx)
+	replicating := pos.Cursor > 0 || len(pos.Namespaces) > 0
+	if !a.target.ReadOnly(ctx).Enabled {
+		if replicating {
+			a.statusMu.Lock()
+			a.status.Promoted = true
+			a.statusMu.Unlock()
+			return errPromoted
+		}
+		reason := "standby of " + strings.Join(a.cfg.Primary, ",")
+		if _, err := a.target.SetReadOnly(ctx, true, reason); err != nil {
+			return err
+		}
+	}
+
+	if replicating && !a.checked {
+		if err := a.seed(ctx, pos.Namespaces); err != nil {
+			return err
+		}
+		a.checked = true
+		pos = a.target.StandbyPosition(ctx)
+	}
+
+	q := url.Values{}
+	q.Set("since", strconv.FormatUint(pos.Resume(), 10))
+	q.Set("limit", strconv.Itoa(pageLimit))
+	q.Set("wait", wait.String())
+	if len(a.cfg.Namespaces) > 0 {
+		q.Set("namespace", strings.Join(a.cfg.Namespaces, ","))
+	}
+	var feed store.ChangeFeed
+	primary, err := a.get(ctx, "/changes?"+q.Encode(), &feed)
+	if errors.Is(err, errCompacted) {
+		// the standby is too far behind, or starts from a primary whose
+		// first entries were compacted
+		log.Printf("seeding the standby cluster from %s", a.cfg.Primary)
+		if err := a.seed(ctx, nil); err != nil {
+			return err
+		}
+		a.checked = true
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	for _, ch := range feed.Changes {
+		if err := a.target.ApplyChange(ctx, ch); err != nil {
+			// changes the primary rejected are rejected again, and only
+			// move the position
+			if a.target.StandbyPosition(ctx).Namespaces[ch.Namespace] < ch.Index {
+				return fmt.Errorf("failed to apply change %d: %s", ch.Index, err.Error())
+			}
+			log.Printf("change %d of %s rejected: %s", ch.Index, ch.Namespace, err.Error())
+		}
+	}
+
+	a.statusMu.Lock()
+	defer a.statusMu.Unlock()
+	now := time.Now()
+	a.status = Status{Primary: primary, Index: feed.Next, PrimaryIndex: feed.Applied, LastSync: now, CaughtUp: a.status.CaughtUp}
+	if feed.Applied > feed.Next {
+		a.status.Lag = feed.Applied - feed.Next
+	} else {
+		a.status.CaughtUp = now
+	}
+	return nil
+}
+
+type snapshotPolicies struct {
+	Sec   string     `json:"sec"`
+	PType string     `json:"ptype"`
+	Rules [][]string `json:"rules"`
+}
+
+type namespaceSnapshot struct {
+	Model    string             `json:"model"`
+	Policies []snapshotPolicies `json:"policies"`
+	Index    uint64             `json:"index"`
+}
+
+// seed copies the namespaces of the primary selected and not in skip, each
+// as of the index it was read at. The feed then resumes from the oldest of
+// these indexes, the changes the namespaces already include are skipped.
+func (a *Agent) seed(ctx context.Context, skip map[string]uint64) error {
+	var all, namespaces []string
+	if _, err := a.get(ctx, "/list/namespaces", &all); err != nil {
+		return err
+	}
+	for _, ns := range all {
+		if _, ok := skip[ns]; !ok && store.MatchNamespace(a.cfg.Namespaces, ns) {
+			namespaces = append(namespaces, ns)
+		}
+	}
+	if len(namespaces) == 0 {
+		return nil
+	}
+	for _, ns := range namespaces {
+		var snap namespaceSnapshot
+		if _, err := a.get(ctx, "/namespaces/"+url.PathEscape(ns)+"/snapshot", &snap); err != nil {
+			return err
+		}
+		policies := make([]*command.PolicyRules, 0, len(snap.Policies))
+		for _, p := range snap.Policies {
+			policies = append(policies, &command.PolicyRules{Sec: p.Sec, PType: p.PType, Rules: command.NewStringArray(p.Rules)})
+		}
+		if err := a.target.SeedNamespace(ctx, ns, snap.Model, policies, snap.Index); err != nil {
+			return fmt.Errorf("failed to seed %s: %s", ns, err.Error())
+		}
+	}
+	log.Printf("seeded %d namespaces from the primary cluster", len(namespaces))
+	return nil
+}
+
+// Promote makes the standby cluster take over from the primary. The changes
+// of the primary are replicated one last time if it is reachable, then the
+// standby cluster leaves read-only mode and stops replicating.
+func (a *Agent) Promote(ctx context.Context) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if err := a.sync(ctx, 0); err != nil && !errors.Is(err, errPromoted) {
+		log.Printf("promoting the standby cluster without a last replication: %s", err.Error())
+	}
+	if _, err := a.target.SetReadOnly(ctx, false, ""); err != nil {
+		return err
+	}
+	a.statusMu.Lock()
+	defer a.statusMu.Unlock()
+	a.status.Promoted = true
+	log.Printf("standby cluster promoted at index %d of the primary", a.status.Index)
+	return nil
+}
+
+// get reads path from the first node of the primary answering into out, and
+// returns its address.
+func (a *Agent) get(ctx context.Context, path string, out interface{}) (string, error) {
+	err := ErrPrimaryUnreachable
+	for _, addr := range a.cfg.Primary {
+		if err = a.getFrom(ctx, addr, path, out); err == nil || errors.Is(err, errCompacted) {
+			return addr, err
+		}
+	}
+	return "", fmt.Errorf("%w: %s", ErrPrimaryUnreachable, err.Error())
+}
+
+func (a *Agent) getFrom(ctx context.Context, addr, path string, out interface{}) error {
+	if !strings.Contains(addr, "://") {
+		addr = "http://" + addr
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(addr, "/")+path, nil)
+	if err != nil {
+		return err
+	}
+	if a.cfg.Username != "" {
+		req.SetBasicAuth(a.cfg.Username, a.cfg.Password)
+	}
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		var e struct {
+			Error string `json:"error"`
+		}
+		if json.Unmarshal(b, &e) == nil && strings.Contains(e.Error, errCompacted.Error()) {
+			return fmt.Errorf("%w: %s", errCompacted, e.Error)
+		}
+		return fmt.Errorf("%s returned %s: %s", addr, resp.Status, strings.TrimSpace(string(b)))
+	}
+	return json.Unmarshal(b, out)
+}
@@ -0,0 +1,190 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package standby
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/casbin/casbin-mesh/pkg/store"
+	"github.com/casbin/casbin-mesh/proto/command"
+)
+
+type fakeTarget struct {
+	readOnly store.ReadOnlyStatus
+	pos      store.StandbyPosition
+	applied  []store.ChangeEvent
+	seeded   map[string][]*command.PolicyRules
+}
+
+func newFakeTarget() *fakeTarget {
+	return &fakeTarget{pos: store.StandbyPosition{Namespaces: map[string]uint64{}}, seeded: map[string][]*command.PolicyRules{}}
+}
+
+func (t *fakeTarget) IsLeader(ctx context.Context) bool { return true }
+
+func (t *fakeTarget) ReadOnly(ctx context.Context) store.ReadOnlyStatus { return t.readOnly }
+
+func (t *fakeTarget) SetReadOnly(ctx context.Context, enabled bool, reason string) (bool, error) {
+	t.readOnly = store.ReadOnlyStatus{Enabled: enabled, Reason: reason}
+	return true, nil
+}
+
+func (t *fakeTarget) ApplyChange(ctx context.Context, ch store.ChangeEvent) error {
+	if ch.Index <= t.pos.Namespaces[ch.Namespace] {
+		return nil
+	}
+	t.applied = append(t.applied, ch)
+	t.pos.Namespaces[ch.Namespace] = ch.Index
+	t.pos.Cursor = ch.Index
+	return nil
+}
+
+func (t *fakeTarget) SeedNamespace(ctx context.Context, ns, model string, policies []*command.PolicyRules, index uint64) error {
+	t.seeded[ns] = policies
+	t.pos.Namespaces[ns] = index
+	return nil
+}
+
+func (t *fakeTarget) StandbyPosition(ctx context.Context) store.StandbyPosition { return t.pos }
+
+func newPrimary(t *testing.T, routes map[string]interface{}) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		out, ok := routes[r.URL.Path]
+		if !ok {
+			t.Errorf("unexpected request to %s", r.URL.Path)
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if err, ok := out.(error); ok {
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+			return
+		}
+		json.NewEncoder(w).Encode(out)
+	}))
+}
+
+func TestAgentReplicatesAndPromotes(t *testing.T) {
+	primary := newPrimary(t, map[string]interface{}{
+		"/changes": store.ChangeFeed{Changes: []store.ChangeEvent{
+			{Index: 3, Namespace: "a", Type: "COMMAND_TYPE_CREATE_NAMESPACE"},
+			{Index: 5, Namespace: "a", Type: "COMMAND_TYPE_ADD_POLICIES"},
+		}, Next: 6, Applied: 8},
+		"/list/namespaces": []string{"a"},
+	})
+	defer primary.Close()
+
+	target := newFakeTarget()
+	a, err := New(Config{Primary: []string{primary.URL}}, target)
+	if err != nil {
+		t.Fatalf("failed to create agent: %s", err.Error())
+	}
+	if err := a.Sync(context.Background()); err != nil {
+		t.Fatalf("failed to sync: %s", err.Error())
+	}
+	if !target.readOnly.Enabled {
+		t.Fatalf("standby cluster not made read-only")
+	}
+	if len(target.applied) != 2 {
+		t.Fatalf("expected 2 changes applied, got %d", len(target.applied))
+	}
+	if s := a.Status(); s.Index != 6 || s.PrimaryIndex != 8 || s.Lag != 2 {
+		t.Fatalf("unexpected status %+v", s)
+	}
+
+	// Replicated changes are applied once.
+	if err := a.Sync(context.Background()); err != nil {
+		t.Fatalf("failed to sync: %s", err.Error())
+	}
+	if len(target.applied) != 2 {
+		t.Fatalf("changes applied again")
+	}
+
+	if err := a.Promote(context.Background()); err != nil {
+		t.Fatalf("failed to promote: %s", err.Error())
+	}
+	if target.readOnly.Enabled || !a.Status().Promoted {
+		t.Fatalf("standby cluster not promoted")
+	}
+	if err := a.Sync(context.Background()); err != errPromoted {
+		t.Fatalf("promoted cluster still replicating: %v", err)
+	}
+}
+
+func TestAgentSeedsWhenCompacted(t *testing.T) {
+	primary := newPrimary(t, map[string]interface{}{
+		"/changes":               store.ErrChangesCompacted,
+		"/list/namespaces":       []string{"a"},
+		"/namespaces/a/snapshot": namespaceSnapshot{Model: "m", Policies: []snapshotPolicies{{Sec: "p", PType: "p", Rules: [][]string{{"alice", "data1", "read"}}}}, Index: 42},
+	})
+	defer primary.Close()
+
+	target := newFakeTarget()
+	a, err := New(Config{Primary: []string{primary.URL}}, target)
+	if err != nil {
+		t.Fatalf("failed to create agent: %s", err.Error())
+	}
+	if err := a.Sync(context.Background()); err != nil {
+		t.Fatalf("failed to sync: %s", err.Error())
+	}
+	if len(target.seeded["a"]) != 1 || target.pos.Namespaces["a"] != 42 {
+		t.Fatalf("namespace not seeded: %+v", target.pos)
+	}
+	if target.pos.Resume() != 42 {
+		t.Fatalf("feed resumes after %d, expected 42", target.pos.Resume())
+	}
+}
+
+func TestAgentSelectsNamespaces(t *testing.T) {
+	var selected string
+	primary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/changes":
+			selected = r.URL.Query().Get("namespace")
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]string{"error": store.ErrChangesCompacted.Error()})
+		case "/list/namespaces":
+			json.NewEncoder(w).Encode([]string{"edge-1", "hq"})
+		case "/namespaces/edge-1/snapshot":
+			json.NewEncoder(w).Encode(namespaceSnapshot{Model: "m", Index: 7})
+		default:
+			t.Errorf("unexpected request to %s", r.URL.Path)
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer primary.Close()
+
+	target := newFakeTarget()
+	a, err := New(Config{Primary: []string{primary.URL}, Namespaces: []string{"edge-*"}}, target)
+	if err != nil {
+		t.Fatalf("failed to create agent: %s", err.Error())
+	}
+	if err := a.Sync(context.Background()); err != nil {
+		t.Fatalf("failed to sync: %s", err.Error())
+	}
+	if selected != "edge-*" {
+		t.Fatalf("changes requested for %q, expected edge-*", selected)
+	}
+	if _, ok := target.seeded["edge-1"]; !ok || len(target.seeded) != 1 {
+		t.Fatalf("expected edge-1 only to be seeded, got %v", target.seeded)
+	}
+}
@@ -22,16 +22,26 @@ import (
 	"github.com/casbin/casbin-mesh/pkg/adapter"
 	"github.com/casbin/casbin-mesh/proto/command"
 	"github.com/golang/protobuf/proto"
-	"github.com/hashicorp/raft"
 )
 
 // AddPolicies implements the casbin.Adapter interface.
 func (s *Store) AddPolicies(ctx context.Context, ns string, sec string, pType string, rules [][]string) ([][]string, error) {
-	payload, err := proto.Marshal(&command.AddPoliciesPayload{
+	return s.addPolicies(ctx, ns, &command.AddPoliciesPayload{
 		Sec:   sec,
 		PType: pType,
 		Rules: command.NewStringArray(rules),
 	})
+}
+
+func (s *Store) addPolicies(ctx context.Context, ns string, p *command.AddPoliciesPayload) ([][]string, error) {
+	if p.Sec == "g" {
+		s.roleMu.Lock()
+		defer s.roleMu.Unlock()
+		if err := s.checkGroupingRules(ns, p.Sec, p.PType, command.ToStringArray(p.Rules), nil); err != nil {
+			return nil, err
+		}
+	}
+	payload, err := proto.Marshal(p)
 	if err != nil {
 		return nil, err
 	}
@@ -46,12 +56,9 @@ func (s *Store) AddPolicies(ctx context.Context, ns string, sec string, pType st
 		return nil, err
 	}
 
-	f := s.raft.Apply(cmd, s.ApplyTimeout)
-	if e := f.(raft.Future); e.Error() != nil {
-		if e.Error() == raft.ErrNotLeader {
-			return nil, ErrNotLeader
-		}
-		return nil, e.Error()
+	f, err := s.apply(ctx, cmd)
+	if err != nil {
+		return nil, err
 	}
 	r := f.Response().(*FSMResponse)
 	return r.effectedRules, r.error
@@ -78,12 +85,9 @@ func (s *Store) RemovePolicies(ctx context.Context, ns string, sec string, pType
 		return nil, err
 	}
 
-	f := s.raft.Apply(cmd, s.ApplyTimeout)
-	if e := f.(raft.Future); e.Error() != nil {
-		if e.Error() == raft.ErrNotLeader {
-			return nil, ErrNotLeader
-		}
-		return nil, e.Error()
+	f, err := s.apply(ctx, cmd)
+	if err != nil {
+		return nil, err
 	}
 	r := f.Response().(*FSMResponse)
 	return r.effectedRules, r.error
@@ -111,12 +115,9 @@ func (s *Store) RemoveFilteredPolicy(ctx context.Context, ns string, sec string,
 		return nil, err
 	}
 
-	f := s.raft.Apply(cmd, s.ApplyTimeout)
-	if e := f.(raft.Future); e.Error() != nil {
-		if e.Error() == raft.ErrNotLeader {
-			return nil, ErrNotLeader
-		}
-		return nil, e.Error()
+	f, err := s.apply(ctx, cmd)
+	if err != nil {
+		return nil, err
 	}
 	r := f.Response().(*FSMResponse)
 	return r.effectedRules, r.error
@@ -124,6 +125,13 @@ func (s *Store) RemoveFilteredPolicy(ctx context.Context, ns string, sec string,
 
 // UpdatePolicies implements the casbin.Adapter interface.
 func (s *Store) UpdatePolicies(ctx context.Context, ns string, sec string, pType string, nr, or [][]string) (bool, error) {
+	if sec == "g" {
+		s.roleMu.Lock()
+		defer s.roleMu.Unlock()
+		if err := s.checkGroupingRules(ns, sec, pType, nr, or); err != nil {
+			return false, err
+		}
+	}
 	payload, err := proto.Marshal(&command.UpdatePoliciesPayload{
 		Sec:      sec,
 		PType:    pType,
@@ -144,12 +152,9 @@ func (s *Store) UpdatePolicies(ctx context.Context, ns string, sec string, pType
 		return false, err
 	}
 
-	f := s.raft.Apply(cmd, s.ApplyTimeout)
-	if e := f.(raft.Future); e.Error() != nil {
-		if e.Error() == raft.ErrNotLeader {
-			return false, ErrNotLeader
-		}
-		return false, e.Error()
+	f, err := s.apply(ctx, cmd)
+	if err != nil {
+		return false, err
 	}
 	r := f.Response().(*FSMResponse)
 	return r.effected, r.error
@@ -167,12 +172,9 @@ func (s *Store) ClearPolicy(ctx context.Context, ns string) error {
 		return err
 	}
 
-	f := s.raft.Apply(cmd, s.ApplyTimeout)
-	if e := f.(raft.Future); e.Error() != nil {
-		if e.Error() == raft.ErrNotLeader {
-			return ErrNotLeader
-		}
-		return e.Error()
+	f, err := s.apply(ctx, cmd)
+	if err != nil {
+		return err
 	}
 	r := f.Response().(*FSMResponse)
 	return r.error
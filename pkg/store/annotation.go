@@ -0,0 +1,165 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package store
+
+import (
+	"context"
+	"sort"
+
+	"github.com/casbin/casbin-mesh/proto/command"
+	"github.com/casbin/casbin/v2"
+	"github.com/golang/protobuf/proto"
+)
+
+// annotationRegistry holds the annotations of the rules of each namespace. It
+// is only accessed by the FSM.
+type annotationRegistry struct {
+	// Rules are keyed by namespace and rule key.
+	Rules map[string]map[string]*command.PolicyAnnotation `json:"rules"`
+}
+
+func newAnnotationRegistry() *annotationRegistry {
+	return &annotationRegistry{Rules: make(map[string]map[string]*command.PolicyAnnotation)}
+}
+
+// set attaches annotation to rules, a nil annotation removes theirs.
+func (r *annotationRegistry) set(ns, sec, pType string, rules [][]string, annotation *command.Annotation) {
+	if annotation == nil {
+		r.drop(ns, sec, pType, rules)
+		return
+	}
+	if _, ok := r.Rules[ns]; !ok {
+		r.Rules[ns] = make(map[string]*command.PolicyAnnotation)
+	}
+	for _, rule := range rules {
+		r.Rules[ns][ruleKey(sec, pType, rule)] = &command.PolicyAnnotation{Sec: sec, PType: pType, Rule: rule,
+			Annotation: annotation}
+	}
+}
+
+func (r *annotationRegistry) drop(ns, sec, pType string, rules [][]string) {
+	for _, rule := range rules {
+		delete(r.Rules[ns], ruleKey(sec, pType, rule))
+	}
+	if len(r.Rules[ns]) == 0 {
+		delete(r.Rules, ns)
+	}
+}
+
+// move moves the annotations of oldRules to the newRules at the same index.
+func (r *annotationRegistry) move(ns, sec, pType string, oldRules, newRules [][]string) {
+	moved := make([]*command.Annotation, len(oldRules))
+	for i, rule := range oldRules {
+		if a, ok := r.Rules[ns][ruleKey(sec, pType, rule)]; ok {
+			moved[i] = a.Annotation
+		}
+	}
+	r.drop(ns, sec, pType, oldRules)
+	for i, a := range moved {
+		if a != nil && i < len(newRules) {
+			r.set(ns, sec, pType, newRules[i:i+1], a)
+		}
+	}
+}
+
+// copy attaches the annotations of rules in ns to the same rules in to.
+func (r *annotationRegistry) copy(ns, to, sec, pType string, rules [][]string) {
+	for _, rule := range rules {
+		if a, ok := r.Rules[ns][ruleKey(sec, pType, rule)]; ok {
+			r.set(to, sec, pType, [][]string{rule}, a.Annotation)
+		}
+	}
+}
+
+func (r *annotationRegistry) dropNamespace(ns string) {
+	delete(r.Rules, ns)
+}
+
+// list returns the annotations of a namespace, sorted by rule.
+func (r *annotationRegistry) list(ns string) []*command.PolicyAnnotation {
+	out := make([]*command.PolicyAnnotation, 0, len(r.Rules[ns]))
+	for _, a := range r.Rules[ns] {
+		out = append(out, proto.Clone(a).(*command.PolicyAnnotation))
+	}
+	sort.Slice(out, func(i, j int) bool {
+		return ruleKey(out[i].Sec, out[i].PType, out[i].Rule) < ruleKey(out[j].Sec, out[j].PType, out[j].Rule)
+	})
+	return out
+}
+
+type ListAnnotationsResponse struct {
+	annotations []*command.PolicyAnnotation
+	error
+}
+
+// hasRule tells whether the namespace has the rule, in the enforcer or as an
+// inactive scheduled rule.
+func (s *Store) hasRule(enforcer *casbin.DistributedEnforcer, ns, sec, pType string, rule []string) bool {
+	if enforcer.GetModel().HasPolicy(sec, pType, rule) {
+		return true
+	}
+	s.expiries.mu.RLock()
+	defer s.expiries.mu.RUnlock()
+	_, ok := s.expiries.rules[ns][ruleKey(sec, pType, rule)]
+	return ok
+}
+
+// AnnotatePolicies replaces the annotation of existing rules, a nil
+// annotation removes it. It returns the annotated rules.
+func (s *Store) AnnotatePolicies(ctx context.Context, ns string, sec string, pType string, rules [][]string, annotation *command.Annotation) ([][]string, error) {
+	payload, err := proto.Marshal(&command.AnnotatePoliciesPayload{
+		Sec:        sec,
+		PType:      pType,
+		Rules:      command.NewStringArray(rules),
+		Annotation: annotation,
+	})
+	if err != nil {
+		return nil, err
+	}
+	cmd, err := proto.Marshal(&command.Command{
+		Type:      command.Type_COMMAND_TYPE_ANNOTATE_POLICIES,
+		Namespace: ns,
+		Payload:   payload,
+	})
+	if err != nil {
+		return nil, err
+	}
+	f, err := s.apply(ctx, cmd)
+	if err != nil {
+		return nil, err
+	}
+	r := f.Response().(*FSMResponse)
+	return r.effectedRules, r.error
+}
+
+// ListAnnotations returns the annotations of the rules of a namespace.
+func (s *Store) ListAnnotations(ctx context.Context, ns string) ([]*command.PolicyAnnotation, error) {
+	cmd, err := proto.Marshal(&command.Command{
+		Type:      command.Type_COMMAND_TYPE_LIST_ANNOTATIONS,
+		Namespace: ns,
+	})
+	if err != nil {
+		return nil, err
+	}
+	f, err := s.apply(ctx, cmd)
+	if err != nil {
+		return nil, err
+	}
+	r := f.Response().(*ListAnnotationsResponse)
+	return r.annotations, r.error
+}
@@ -0,0 +1,178 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package store
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/raft"
+
+	"github.com/casbin/casbin-mesh/proto/command"
+)
+
+// DefaultDecisionTTL is how long clients are suggested to cache decisions.
+const DefaultDecisionTTL = 10 * time.Second
+
+// CacheHints tell clients how to cache the decisions of a namespace.
+type CacheHints struct {
+	// Version is the index of the last entry changing the model, the rules
+	// or the settings of the namespace. Cached decisions are stale once it
+	// changes.
+	Version uint64
+	// TTL is how long a decision may be cached, cut short by the next rule
+	// of the namespace to expire or to be scheduled in or out.
+	TTL time.Duration
+}
+
+// policySetRegistry holds the version of the policy set of each namespace.
+// It is changed by the FSM and saved in snapshots, so that every node
+// reports the same versions.
+type policySetRegistry struct {
+	mu         sync.RWMutex
+	namespaces map[string]uint64
+}
+
+func newPolicySetRegistry() *policySetRegistry {
+	return &policySetRegistry{namespaces: make(map[string]uint64)}
+}
+
+func (r *policySetRegistry) get(ns string) uint64 {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.namespaces[ns]
+}
+
+func (r *policySetRegistry) set(ns string, index uint64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.namespaces[ns] = index
+}
+
+func (r *policySetRegistry) reset() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.namespaces = make(map[string]uint64)
+}
+
+func (r *policySetRegistry) MarshalJSON() ([]byte, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return json.Marshal(r.namespaces)
+}
+
+func (r *policySetRegistry) UnmarshalJSON(data []byte) error {
+	namespaces := make(map[string]uint64)
+	if err := json.Unmarshal(data, &namespaces); err != nil {
+		return err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.namespaces = namespaces
+	return nil
+}
+
+// recordPolicySet versions the namespaces cmd, a command of the change
+// feed, may change. Subjects removed or renamed in every namespace version
+// them all.
+func (s *Store) recordPolicySet(l *raft.Log, cmd *command.Command) {
+	if cmd.Metadata[removeSubjectAllMeta] != "" || cmd.Metadata[renameSubjectAllMeta] != "" {
+		s.enforcers.Range(func(key, _ interface{}) bool {
+			s.policySets.set(key.(string), l.Index)
+			return true
+		})
+		return
+	}
+	s.policySets.set(cmd.Namespace, l.Index)
+	if from := cmd.Metadata[copyFromMeta]; from != "" && cmd.Metadata[copyMoveMeta] != "" {
+		s.policySets.set(from, l.Index)
+	}
+}
+
+// nextChange returns the earliest Unix time after now at which a rule of ns
+// expires or may be scheduled in or out, 0 if none. Schedules by hour or
+// day may change at every minute.
+func (r *expiryRegistry) nextChange(ns string, now int64) int64 {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	var next int64
+	earliest := func(t int64) {
+		if t > now && (next == 0 || t < next) {
+			next = t
+		}
+	}
+	for _, e := range r.rules[ns] {
+		if e.ExpireAt != 0 {
+			earliest(e.ExpireAt)
+		}
+		if sch := e.Schedule; sch != nil {
+			earliest(sch.NotBefore)
+			earliest(sch.NotAfter)
+			if len(sch.Days) > 0 || sch.Hours != "" {
+				earliest(now - now%60 + 60)
+			}
+		}
+	}
+	return next
+}
+
+func (s *Store) decisionTTL() time.Duration {
+	if s.DecisionTTL > 0 {
+		return s.DecisionTTL
+	}
+	return DefaultDecisionTTL
+}
+
+// CacheHints returns how clients may cache the decisions of a namespace.
+// Read before enforcing, they never version a decision newer than the rules
+// it was made with.
+func (s *Store) CacheHints(ns string) (CacheHints, error) {
+	if _, ok := s.enforcers.Load(ns); !ok {
+		return CacheHints{}, NamespaceNotExist
+	}
+	h := CacheHints{Version: s.policySets.get(ns), TTL: s.decisionTTL()}
+	now := time.Now()
+	if next := s.expiries.nextChange(ns, now.Unix()); next != 0 {
+		if ttl := time.Unix(next, 0).Sub(now); ttl < h.TTL {
+			h.TTL = ttl
+		}
+	}
+	return h, nil
+}
+
+// PolicySetVersions returns the versions of the policy sets of the
+// namespaces matching patterns, as in path.Match, or of all namespaces if
+// there is none.
+func (s *Store) PolicySetVersions(patterns []string) (map[string]uint64, error) {
+	for _, p := range patterns {
+		if _, err := path.Match(p, ""); err != nil {
+			return nil, fmt.Errorf("invalid namespace pattern %q", p)
+		}
+	}
+	versions := make(map[string]uint64)
+	s.enforcers.Range(func(key, _ interface{}) bool {
+		if ns := key.(string); MatchNamespace(patterns, ns) {
+			versions[ns] = s.policySets.get(ns)
+		}
+		return true
+	})
+	return versions, nil
+}
@@ -0,0 +1,222 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package store
+
+import (
+	"errors"
+	"fmt"
+	"path"
+
+	"github.com/casbin/casbin-mesh/proto/command"
+	"github.com/golang/protobuf/proto"
+	"github.com/hashicorp/raft"
+)
+
+// ErrChangesCompacted is returned when the change feed is resumed from an
+// index whose following entries were compacted out of the Raft log.
+var ErrChangesCompacted = errors.New("changes compacted")
+
+// ErrCrossNamespaceChange is returned when a feed filtered by namespace meets
+// a copy of rules between a selected and an unselected namespace, which the
+// log entry does not hold enough to replicate.
+var ErrCrossNamespaceChange = errors.New("change crosses the namespace filter")
+
+const (
+	// changesMaxLimit bounds the number of changes returned at once.
+	changesMaxLimit = 1000
+	// changesScanLimit bounds the number of log entries read at once, so
+	// that a feed filtered to a quiet namespace still makes progress.
+	changesScanLimit = 10000
+)
+
+// ChangeEvent is a policy or model command, as committed to the Raft log.
+type ChangeEvent struct {
+	Index     uint64 `json:"index"`
+	Namespace string `json:"namespace"`
+	// Type is the name of the command type, like COMMAND_TYPE_ADD_POLICIES.
+	Type string `json:"type"`
+	// Payload is the payload of the command, encoded as in command.proto.
+	Payload []byte `json:"payload,omitempty"`
+	// IdempotencyKey is set for the writes sent with an idempotency key, the
+	// same write may be committed more than once and has to be applied once.
+	// The events a write is split into have the namespace appended.
+	IdempotencyKey string `json:"idempotency_key,omitempty"`
+	// Options hold the metadata of the commands whose effect is not carried
+	// by their payload, like the copy of the rules of another namespace.
+	Options map[string]string `json:"options,omitempty"`
+}
+
+// changeOptions are the metadata keys replicated in ChangeEvent.Options.
+var changeOptions = []string{
+	removeSubjectMeta, removeSubjectAllMeta,
+	renameSubjectMeta, renameSubjectToMeta, renameSubjectAllMeta,
+	copyFromMeta, copyFilterMeta, copyMoveMeta,
+	namespaceLabelsMeta, namespaceSettingsMeta,
+}
+
+// options returns the values of changeOptions in md, nil if none is set.
+func options(md map[string]string) map[string]string {
+	var out map[string]string
+	for _, k := range changeOptions {
+		if v, ok := md[k]; ok {
+			if out == nil {
+				out = make(map[string]string)
+			}
+			out[k] = v
+		}
+	}
+	return out
+}
+
+// ChangeFeed is a page of the change feed.
+type ChangeFeed struct {
+	Changes []ChangeEvent `json:"changes"`
+	// Next is the index to resume the feed after.
+	Next uint64 `json:"next"`
+	// Applied is the index the node had applied up to when reading.
+	Applied uint64 `json:"applied"`
+}
+
+// feeds reports whether commands of type t are part of the change feed.
+func feeds(t command.Type) bool {
+	return t == command.Type_COMMAND_TYPE_CREATE_NAMESPACE || changesPolicies(t)
+}
+
+// MatchNamespace reports whether ns is selected by patterns, matched as in
+// path.Match. No pattern selects every namespace.
+func MatchNamespace(patterns []string, ns string) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+	for _, p := range patterns {
+		if ok, _ := path.Match(p, ns); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Changes returns up to limit policy and model changes applied after index,
+// in the order they were applied, of the namespaces matching patterns or of
+// all namespaces if there is none. Changes are read from the Raft log, so the feed can be
+// resumed on any node until the entries are compacted after a snapshot, in
+// which case ErrChangesCompacted is returned.
+func (s *Store) Changes(patterns []string, index uint64, limit int) (*ChangeFeed, error) {
+	for _, p := range patterns {
+		if _, err := path.Match(p, ""); err != nil {
+			return nil, fmt.Errorf("invalid namespace pattern %q", p)
+		}
+	}
+	if limit <= 0 || limit > changesMaxLimit {
+		limit = changesMaxLimit
+	}
+	first, err := s.raftLog.FirstIndex()
+	if err != nil {
+		return nil, err
+	}
+	if first > 1 && index+1 < first {
+		return nil, fmt.Errorf("%w, the oldest retained index is %d", ErrChangesCompacted, first)
+	}
+
+	feed := &ChangeFeed{Changes: []ChangeEvent{}, Next: index, Applied: s.raft.AppliedIndex()}
+	last := feed.Applied
+	if last > index+changesScanLimit {
+		last = index + changesScanLimit
+	}
+	for i := index + 1; i <= last && len(feed.Changes) < limit; i++ {
+		var l raft.Log
+		if err := s.raftLog.GetLog(i, &l); err != nil {
+			if errors.Is(err, raft.ErrLogNotFound) {
+				return nil, fmt.Errorf("%w, index %d no longer retained", ErrChangesCompacted, i)
+			}
+			return nil, err
+		}
+		feed.Next = i
+		if l.Type != raft.LogCommand {
+			continue
+		}
+		var cmd command.Command
+		if err := proto.Unmarshal(l.Data, &cmd); err != nil || !feeds(cmd.Type) {
+			continue
+		}
+		events, err := s.changeEvents(patterns, &l, &cmd)
+		if err != nil {
+			if len(feed.Changes) > 0 {
+				// return the changes before the entry first
+				feed.Next = i - 1
+				break
+			}
+			return nil, err
+		}
+		feed.Changes = append(feed.Changes, events...)
+	}
+	return feed, nil
+}
+
+// changeEvents returns the events of cmd in a feed filtered by patterns.
+// Subjects removed or renamed in every namespace are split into an event per
+// selected namespace, as the consumer may not hold the namespace cmd was
+// sent to.
+func (s *Store) changeEvents(patterns []string, l *raft.Log, cmd *command.Command) ([]ChangeEvent, error) {
+	ev := ChangeEvent{
+		Index:          l.Index,
+		Namespace:      cmd.Namespace,
+		Type:           cmd.Type.String(),
+		Payload:        cmd.Payload,
+		IdempotencyKey: cmd.Metadata[idempotencyKeyMeta],
+		Options:        options(cmd.Metadata),
+	}
+	if len(patterns) == 0 {
+		return []ChangeEvent{ev}, nil
+	}
+	if cmd.Metadata[removeSubjectAllMeta] != "" || cmd.Metadata[renameSubjectAllMeta] != "" {
+		namespaces, _ := s.subjectNamespaces(cmd, true)
+		var events []ChangeEvent
+		for _, ns := range namespaces {
+			if !MatchNamespace(patterns, ns) {
+				continue
+			}
+			e := ev
+			e.Namespace = ns
+			if e.IdempotencyKey != "" {
+				e.IdempotencyKey += "/" + ns
+			}
+			e.Options = make(map[string]string, len(ev.Options))
+			for k, v := range ev.Options {
+				if k != removeSubjectAllMeta && k != renameSubjectAllMeta {
+					e.Options[k] = v
+				}
+			}
+			events = append(events, e)
+		}
+		return events, nil
+	}
+	to := MatchNamespace(patterns, cmd.Namespace)
+	if from := cmd.Metadata[copyFromMeta]; from != "" {
+		// copies from an unselected namespace lack the rules, and moves to
+		// one would leave them in the selected one
+		fromSelected := MatchNamespace(patterns, from)
+		if to && !fromSelected || fromSelected && !to && cmd.Metadata[copyMoveMeta] != "" {
+			return nil, fmt.Errorf("%w, entry %d copies rules from %s to %s", ErrCrossNamespaceChange, l.Index, from, cmd.Namespace)
+		}
+	}
+	if !to {
+		return nil, nil
+	}
+	return []ChangeEvent{ev}, nil
+}
@@ -0,0 +1,153 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package store
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// DefaultMaxClockSkew is the clock offset between nodes over which their
+// clocks are deemed skewed.
+const DefaultMaxClockSkew = 500 * time.Millisecond
+
+const clockSkewed = "clock_skewed"
+
+// PeerClock is the offset of the clock of a node from the clock of this
+// node, positive if ahead, as of the last comparison.
+type PeerClock struct {
+	NodeID string        `json:"node_id"`
+	Offset time.Duration `json:"offset"`
+	// RTT is the round trip of the comparison, which bounds its error to
+	// half of it.
+	RTT time.Duration `json:"rtt"`
+	At  time.Time     `json:"at"`
+	// Skewed is set if the offset is over the maximum skew, whatever the
+	// error of the comparison.
+	Skewed bool `json:"skewed"`
+}
+
+// ClockStatus compares the clock of this node with the clocks of the others.
+type ClockStatus struct {
+	MaxSkew time.Duration `json:"max_skew"`
+	// Skewed is set if the clock of any node is skewed.
+	Skewed bool        `json:"skewed"`
+	Peers  []PeerClock `json:"peers"`
+}
+
+// clockTracker holds the last comparison of the clock of each node.
+type clockTracker struct {
+	mu     sync.Mutex
+	peers  map[string]PeerClock
+	skewed bool
+}
+
+func newClockTracker() *clockTracker {
+	return &clockTracker{peers: make(map[string]PeerClock)}
+}
+
+// observe records that the clock of node id read remote between sent and
+// received, and tells whether any clock is skewed now and if it changed.
+func (c *clockTracker) observe(id string, sent, received, remote time.Time, max time.Duration) (PeerClock, bool, bool) {
+	rtt := received.Sub(sent)
+	offset := remote.Sub(sent.Add(rtt / 2))
+	abs := offset
+	if abs < 0 {
+		abs = -abs
+	}
+	p := PeerClock{NodeID: id, Offset: offset, RTT: rtt, At: received, Skewed: abs-rtt/2 > max}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.peers[id] = p
+	skewed := false
+	for _, p := range c.peers {
+		skewed = skewed || p.Skewed
+	}
+	changed := skewed != c.skewed
+	c.skewed = skewed
+	return p, skewed, changed
+}
+
+// forget drops the comparisons of the nodes not in ids.
+func (c *clockTracker) forget(ids map[string]bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for id := range c.peers {
+		if !ids[id] {
+			delete(c.peers, id)
+		}
+	}
+}
+
+func (c *clockTracker) status() ClockStatus {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	out := ClockStatus{Peers: make([]PeerClock, 0, len(c.peers))}
+	for _, p := range c.peers {
+		out.Peers = append(out.Peers, p)
+		out.Skewed = out.Skewed || p.Skewed
+	}
+	sort.Slice(out.Peers, func(i, j int) bool { return out.Peers[i].NodeID < out.Peers[j].NodeID })
+	return out
+}
+
+func (s *Store) maxClockSkew() time.Duration {
+	if s.MaxClockSkew <= 0 {
+		return DefaultMaxClockSkew
+	}
+	return s.MaxClockSkew
+}
+
+// ObserveClock records that the clock of node id read remote in a response
+// to a request sent at sent and received at received, by the clock of this
+// node. Excessive skews are logged, as they break expiring and scheduled
+// rules and the timestamps of audit records.
+func (s *Store) ObserveClock(id string, sent, received, remote time.Time) {
+	if id == "" || id == s.raftID {
+		return
+	}
+	p, skewed, changed := s.clocks.observe(id, sent, received, remote, s.maxClockSkew())
+	if !changed {
+		return
+	}
+	if skewed {
+		stats.Set(clockSkewed, intVar(1))
+		s.logger.Printf("ALERT: clock of node %s is %s off, over %s", id, p.Offset, s.maxClockSkew())
+	} else {
+		stats.Set(clockSkewed, intVar(0))
+		s.logger.Printf("clocks of the nodes agree again")
+	}
+}
+
+// ClockStatus compares the clock of this node with the clocks of the other
+// nodes of the cluster.
+func (s *Store) ClockStatus() ClockStatus {
+	if s.raft != nil {
+		if nodes, err := s.Nodes(); err == nil {
+			ids := make(map[string]bool, len(nodes))
+			for _, n := range nodes {
+				ids[n.ID] = true
+			}
+			s.clocks.forget(ids)
+		}
+	}
+	out := s.clocks.status()
+	out.MaxSkew = s.maxClockSkew()
+	return out
+}
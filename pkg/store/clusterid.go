@@ -0,0 +1,206 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package store
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/casbin/casbin-mesh/proto/command"
+	"github.com/golang/protobuf/proto"
+	"github.com/hashicorp/raft"
+)
+
+const (
+	// clusterIDFile keeps the cluster ID in the data directory, so that the
+	// node knows it before replaying its log.
+	clusterIDFile = "cluster_id"
+
+	// clusterIDMeta marks the no-op entry setting the cluster ID.
+	clusterIDMeta = "cluster_id"
+
+	clusterIDInterval = time.Second
+)
+
+// ClusterIDKey is the node metadata key holding the ID of the cluster the
+// node belongs to, checked by the leader when the node joins.
+const ClusterIDKey = "cluster_id"
+
+// ErrClusterMismatch is returned when a node or a snapshot of another
+// cluster is refused.
+var ErrClusterMismatch = errors.New("cluster ID mismatch")
+
+// clusterIdentity holds the ID of the cluster, set once by the first entry
+// carrying one.
+type clusterIdentity struct {
+	mu sync.RWMutex
+	id string
+}
+
+func newClusterIdentity() *clusterIdentity {
+	return &clusterIdentity{}
+}
+
+func (c *clusterIdentity) get() string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.id
+}
+
+// set records id unless the ID is set already, and returns the ID.
+func (c *clusterIdentity) set(id string) string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.id == "" {
+		c.id = id
+	}
+	return c.id
+}
+
+// newClusterID returns a random UUID.
+func newClusterID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:]), nil
+}
+
+// ClusterID returns the ID of the cluster of the node, generated when the
+// cluster was bootstrapped, empty until the node learns it.
+func (s *Store) ClusterID() string {
+	return s.cluster.get()
+}
+
+// CheckClusterID returns an error wrapping ErrClusterMismatch if id is the
+// ID of another cluster. An empty ID, of a node which doesn't know its
+// cluster yet, matches any.
+func (s *Store) CheckClusterID(id string) error {
+	if current := s.ClusterID(); id != "" && current != "" && id != current {
+		return fmt.Errorf("%w: %s is not %s", ErrClusterMismatch, id, current)
+	}
+	return nil
+}
+
+// setClusterID records the cluster ID applied or restored, and keeps it in
+// the data directory.
+func (s *Store) setClusterID(id string) {
+	if current := s.cluster.set(id); current != id {
+		s.logger.Printf("ignoring cluster ID %s, the cluster is %s", id, current)
+		return
+	}
+	if s.raftDir == "" {
+		return
+	}
+	b, err := ioutil.ReadFile(filepath.Join(s.raftDir, clusterIDFile))
+	if err == nil && strings.TrimSpace(string(b)) == id {
+		return
+	}
+	if err := writeClusterID(s.raftDir, id); err != nil {
+		s.logger.Printf("failed to keep cluster ID %s: %s", id, err.Error())
+	}
+}
+
+func writeClusterID(raftDir, id string) error {
+	tmp := filepath.Join(raftDir, clusterIDFile+".tmp")
+	if err := ioutil.WriteFile(tmp, []byte(id+"\n"), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filepath.Join(raftDir, clusterIDFile))
+}
+
+// loadClusterID reads the cluster ID kept in the data directory, if any.
+func (s *Store) loadClusterID() error {
+	b, err := ioutil.ReadFile(filepath.Join(s.raftDir, clusterIDFile))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if id := strings.TrimSpace(string(b)); id != "" {
+		s.cluster.set(id)
+		s.logger.Printf("node is a member of cluster %s", id)
+	}
+	return nil
+}
+
+// startClusterIDWatch has the leader generate the cluster ID, once the
+// cluster is bootstrapped or, for clusters bootstrapped before IDs existed,
+// once the node is elected and every member supports FSM version 5.
+func (s *Store) startClusterIDWatch() {
+	s.clusterIDDone = make(chan struct{})
+	go func(done chan struct{}) {
+		t := time.NewTicker(clusterIDInterval)
+		defer t.Stop()
+		for s.ClusterID() == "" {
+			select {
+			case <-t.C:
+				if s.raft.State() == raft.Leader {
+					// clusters with members which don't know cluster
+					// IDs get one once they are all upgraded
+					if err := s.proposeClusterID(); err != nil && !errors.Is(err, ErrUnsupportedByCluster) {
+						s.logger.Printf("failed to set cluster ID: %s", err.Error())
+					}
+				}
+			case <-done:
+				return
+			}
+		}
+	}(s.clusterIDDone)
+}
+
+func (s *Store) stopClusterIDWatch() {
+	if s.clusterIDDone != nil {
+		close(s.clusterIDDone)
+		s.clusterIDDone = nil
+	}
+}
+
+// proposeClusterID appends a no-op entry carrying a new cluster ID, which
+// every node records once it applies it, unless the cluster has one.
+func (s *Store) proposeClusterID() error {
+	id, err := newClusterID()
+	if err != nil {
+		return err
+	}
+	cmd, err := proto.Marshal(&command.Command{
+		Type:     command.Type_COMMAND_TYPE_NOOP,
+		Metadata: map[string]string{clusterIDMeta: id},
+	})
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), applyTimeout)
+	defer cancel()
+	if _, err := s.apply(ctx, cmd); err != nil {
+		return err
+	}
+	s.logger.Printf("cluster ID is %s", s.ClusterID())
+	return nil
+}
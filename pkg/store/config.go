@@ -19,7 +19,11 @@ package store
 
 import (
 	"github.com/casbin/casbin-mesh/pkg/auth"
+	"io"
 	"log"
+	"time"
+
+	"github.com/hashicorp/raft"
 )
 
 // StoreConfig represents the configuration of the underlying Store.
@@ -28,7 +32,16 @@ type StoreConfig struct {
 	Tn       Transport   // The underlying Transport for raft.
 	ID       string      // Node ID.
 	Logger   *log.Logger // The logger to use to log stuff.
+	RaftLog  io.Writer   // Receives the logs of Raft, os.Stderr if nil.
 	AuthType auth.AuthType
 	*auth.CredentialsStore
 	AdvAddr string
+	// RaftTransport, if set, carries Raft instead of the listener, like an
+	// in-memory transport in tests.
+	RaftTransport raft.Transport
+	// Memory, if set, holds the state of the node instead of Dir.
+	Memory *MemoryStorage
+	// Clock returns the time rules expire and are scheduled against,
+	// time.Now if nil.
+	Clock func() time.Time
 }
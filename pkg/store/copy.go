@@ -0,0 +1,248 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+
+	"github.com/casbin/casbin/v2"
+	"github.com/golang/protobuf/proto"
+	"github.com/hashicorp/raft"
+
+	"github.com/casbin/casbin-mesh/pkg/search"
+	"github.com/casbin/casbin-mesh/proto/command"
+)
+
+const (
+	// copyFromMeta marks the ADD_POLICIES entries copying the rules of
+	// another namespace, instead of adding the rules of their payload.
+	copyFromMeta = "copy-from"
+	// copyFilterMeta is the search query selecting the rules to copy.
+	copyFilterMeta = "copy-filter"
+	// copyMoveMeta removes the copied rules from their namespace.
+	copyMoveMeta = "copy-move"
+)
+
+var (
+	// ErrCopySameNamespace is returned when copying the rules of a
+	// namespace to itself.
+	ErrCopySameNamespace = errors.New("rules copied to their own namespace")
+)
+
+// CopiedRules are the rules of a policy type copied or moved.
+type CopiedRules struct {
+	Sec   string     `json:"sec"`
+	PType string     `json:"ptype"`
+	Rules [][]string `json:"rules"`
+}
+
+// CopyPoliciesResponse is the response of an ADD_POLICIES command copying
+// rules.
+type CopyPoliciesResponse struct {
+	copied []CopiedRules
+	error
+	replayMark
+}
+
+// CopyPolicies copies the rules of from matching filter, a search query, to
+// to, removing them from from if move. The rules keep their expiry, schedule
+// and annotation. They are copied by a single log entry, so all of them or
+// none are. The role hierarchies the grouping rules copied make in to are
+// checked like those of AddPolicies.
+func (s *Store) CopyPolicies(ctx context.Context, from, to string, filter url.Values, move bool) ([]CopiedRules, error) {
+	if from == to {
+		return nil, ErrCopySameNamespace
+	}
+	q, err := search.ParseQuery(filter)
+	if err != nil {
+		return nil, err
+	}
+	s.roleMu.Lock()
+	defer s.roleMu.Unlock()
+	if err := s.checkCopiedGrouping(ctx, from, to, q); err != nil {
+		return nil, err
+	}
+	md := map[string]string{copyFromMeta: from, copyFilterMeta: filter.Encode()}
+	if move {
+		md[copyMoveMeta] = "true"
+	}
+	cmd, err := proto.Marshal(&command.Command{
+		Type:      command.Type_COMMAND_TYPE_ADD_POLICIES,
+		Namespace: to,
+		Metadata:  md,
+	})
+	if err != nil {
+		return nil, err
+	}
+	f, err := s.apply(ctx, cmd)
+	if err != nil {
+		return nil, err
+	}
+	switch r := f.Response().(type) {
+	case *CopyPoliciesResponse:
+		return r.copied, r.error
+	case *FSMResponse:
+		return nil, r.error
+	}
+	return nil, nil
+}
+
+// checkCopiedGrouping checks the grouping rules of from matching q copied to
+// to. Moving them away from from can't make a cycle or a deeper hierarchy
+// there. Inactive scheduled rules are left out, as they are not links yet.
+func (s *Store) checkCopiedGrouping(ctx context.Context, from, to string, q *search.Query) error {
+	e, ok := s.enforcers.Load(from)
+	if !ok {
+		return nil
+	}
+	source := e.(*casbin.DistributedEnforcer)
+	m := source.GetModel()
+	if m == nil {
+		return nil
+	}
+	var annotations map[string]*command.Annotation
+	for _, pType := range policyTypes(m, "g") {
+		rules := source.GetNamedGroupingPolicy(pType)
+		if len(rules) == 0 {
+			continue
+		}
+		if annotations == nil {
+			// annotations are only read through the log
+			list, err := s.ListAnnotations(ctx, from)
+			if err != nil {
+				return err
+			}
+			annotations = make(map[string]*command.Annotation, len(list))
+			for _, pa := range list {
+				annotations[ruleKey(pa.Sec, pa.PType, pa.Rule)] = pa.Annotation
+			}
+		}
+		var copied [][]string
+		for _, rule := range rules {
+			if q.Matches(search.Result{Sec: "g", PType: pType, Rule: rule, Annotation: annotations[ruleKey("g", pType, rule)]}) {
+				copied = append(copied, rule)
+			}
+		}
+		if err := s.checkGroupingRules(to, "g", pType, copied, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// applyCopyPolicies copies the rules matching the filter of cmd to
+// cmd.Namespace, whose versions are recorded by Apply. Those of the source
+// are recorded here, if the rules are moved.
+func (s *Store) applyCopyPolicies(l *raft.Log, cmd *command.Command) interface{} {
+	from, move := cmd.Metadata[copyFromMeta], cmd.Metadata[copyMoveMeta] != ""
+	if from == cmd.Namespace {
+		return &FSMResponse{error: ErrCopySameNamespace}
+	}
+	values, err := url.ParseQuery(cmd.Metadata[copyFilterMeta])
+	if err != nil {
+		return &FSMResponse{error: err}
+	}
+	q, err := search.ParseQuery(values)
+	if err != nil {
+		return &FSMResponse{error: err}
+	}
+	src, ok := s.enforcers.Load(from)
+	if !ok {
+		return &FSMResponse{error: NamespaceNotExist}
+	}
+	dst, ok := s.enforcers.Load(cmd.Namespace)
+	if !ok {
+		return &FSMResponse{error: NamespaceNotExist}
+	}
+	source, target := src.(*casbin.DistributedEnforcer), dst.(*casbin.DistributedEnforcer)
+	sm, tm := source.GetModel(), target.GetModel()
+	if sm == nil || tm == nil {
+		return &FSMResponse{error: ModelUnsetYet}
+	}
+
+	// the rules are selected, and checked against the model of the target,
+	// before anything changes
+	var selected []CopiedRules
+	for _, sec := range []string{"p", "g"} {
+		for _, pType := range policyTypes(sm, sec) {
+			var rules [][]string
+			// inactive scheduled rules are copied to the registries only
+			for _, rule := range append(append([][]string(nil), sm[sec][pType].Policy...), s.expiries.inactive(from, sec, pType)...) {
+				var a *command.Annotation
+				if pa, ok := s.annotations.Rules[from][ruleKey(sec, pType, rule)]; ok {
+					a = pa.Annotation
+				}
+				if q.Matches(search.Result{Sec: sec, PType: pType, Rule: rule, Annotation: a}) {
+					rules = append(rules, rule)
+				}
+			}
+			if len(rules) == 0 {
+				continue
+			}
+			if _, ok := tm[sec][pType]; !ok {
+				return &FSMResponse{error: fmt.Errorf("policy type %s not defined in the model of %s", pType, cmd.Namespace)}
+			}
+			selected = append(selected, CopiedRules{Sec: sec, PType: pType, Rules: rules})
+		}
+	}
+
+	for _, c := range selected {
+		inactive := make(map[string]bool)
+		for _, rule := range s.expiries.inactive(from, c.Sec, c.PType) {
+			inactive[ruleKey(c.Sec, c.PType, rule)] = true
+		}
+		var active [][]string
+		for _, rule := range c.Rules {
+			if !inactive[ruleKey(c.Sec, c.PType, rule)] {
+				active = append(active, rule)
+			}
+		}
+		if len(active) > 0 {
+			added, err := target.AddPoliciesSelf(persist, c.Sec, c.PType, active)
+			if err != nil {
+				return &CopyPoliciesResponse{error: err}
+			}
+			if len(added) > 0 {
+				s.watchers.publish(&command.WatchEvent{Index: l.Index, Namespace: cmd.Namespace, Type: cmd.Type,
+					Sec: c.Sec, PType: c.PType, Rules: command.NewStringArray(added)})
+			}
+		}
+		s.expiries.copy(from, cmd.Namespace, c.Sec, c.PType, c.Rules)
+		s.annotations.copy(from, cmd.Namespace, c.Sec, c.PType, c.Rules)
+		if !move {
+			continue
+		}
+		removed, err := source.RemovePoliciesSelf(persist, c.Sec, c.PType, c.Rules)
+		if err != nil {
+			return &CopyPoliciesResponse{error: err}
+		}
+		s.expiries.drop(from, c.Sec, c.PType, c.Rules)
+		s.annotations.drop(from, c.Sec, c.PType, c.Rules)
+		if len(removed) > 0 {
+			s.watchers.publish(&command.WatchEvent{Index: l.Index, Namespace: from, Type: command.Type_COMMAND_TYPE_REMOVE_POLICIES,
+				Sec: c.Sec, PType: c.PType, Rules: command.NewStringArray(removed)})
+		}
+	}
+	if move && len(selected) > 0 {
+		s.recordVersion(l, from)
+	}
+	return &CopyPoliciesResponse{copied: selected}
+}
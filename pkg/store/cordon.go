@@ -0,0 +1,144 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package store
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/raft"
+)
+
+// ErrCordoned is returned for the client requests of a cordoned node.
+var ErrCordoned = errors.New("node is cordoned")
+
+// cordonInterval is how often a cordoned node hands over the leadership it
+// won again.
+const cordonInterval = time.Second
+
+// CordonStatus tells whether the node is cordoned for maintenance.
+type CordonStatus struct {
+	Cordoned bool   `json:"cordoned"`
+	Reason   string `json:"reason,omitempty"`
+	// Since is the unix time in nanoseconds the node was cordoned at.
+	Since int64 `json:"since,omitempty"`
+}
+
+// cordonState holds the cordon status of the node. It is not replicated,
+// each node is cordoned on its own.
+type cordonState struct {
+	mu     sync.RWMutex
+	status CordonStatus
+}
+
+func newCordonState() *cordonState {
+	return &cordonState{}
+}
+
+func (c *cordonState) get() CordonStatus {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.status
+}
+
+func (c *cordonState) set(status CordonStatus) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.status = status
+}
+
+// Cordon stops the node from serving clients and from leading, while it
+// keeps replicating and voting, so its host can be drained for maintenance.
+// A node leading hands its leadership over, which is retried every
+// cordonInterval while it fails, e.g. for want of another voter.
+func (s *Store) Cordon(reason string) {
+	status := s.cordon.get()
+	if !status.Cordoned {
+		status = CordonStatus{Cordoned: true, Since: time.Now().UnixNano()}
+	}
+	status.Reason = reason
+	s.cordon.set(status)
+	s.logger.Printf("node cordoned: %q", reason)
+	if err := s.shedLeadership(); err != nil {
+		s.logger.Printf("%s", err.Error())
+	}
+}
+
+// Uncordon has the node serve clients again and be elected again.
+func (s *Store) Uncordon() {
+	s.cordon.set(CordonStatus{})
+	s.logger.Printf("node uncordoned")
+}
+
+// CordonStatus returns the cordon status of the node.
+func (s *Store) CordonStatus() CordonStatus {
+	return s.cordon.get()
+}
+
+// CheckCordon returns an error wrapping ErrCordoned if the node is
+// cordoned.
+func (s *Store) CheckCordon() error {
+	status := s.cordon.get()
+	if !status.Cordoned {
+		return nil
+	}
+	if status.Reason == "" {
+		return ErrCordoned
+	}
+	return fmt.Errorf("%w: %s", ErrCordoned, status.Reason)
+}
+
+// shedLeadership hands the leadership of a cordoned node over.
+func (s *Store) shedLeadership() error {
+	if !s.cordon.get().Cordoned || s.raft.State() != raft.Leader {
+		return nil
+	}
+	if err := s.TransferLeadership(""); err != nil {
+		return fmt.Errorf("cordoned, but failed to hand leadership over: %w", err)
+	}
+	return nil
+}
+
+// startCordonWatch hands over the leadership a cordoned node wins again, as
+// it still takes part in elections.
+func (s *Store) startCordonWatch() {
+	s.cordonDone = make(chan struct{})
+	go func(done chan struct{}) {
+		t := time.NewTicker(cordonInterval)
+		defer t.Stop()
+		for {
+			select {
+			case <-t.C:
+				// Cordon logged the failure already, the only voter
+				// would fail every time
+				_ = s.shedLeadership()
+			case <-done:
+				return
+			}
+		}
+	}(s.cordonDone)
+}
+
+func (s *Store) stopCordonWatch() {
+	if s.cordonDone != nil {
+		close(s.cordonDone)
+		s.cordonDone = nil
+	}
+}
@@ -0,0 +1,151 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package store
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"sort"
+	"sync"
+
+	"github.com/casbin/casbin/v2"
+	"github.com/golang/protobuf/proto"
+
+	"github.com/casbin/casbin-mesh/proto/command"
+)
+
+// digestMeta marks the no-op entries at which the nodes digest their state.
+const digestMeta = "state-digest"
+
+// digestsKept is the number of digests a node keeps.
+const digestsKept = 16
+
+// StateDigest hashes the state of a node at a log index. Nodes which applied
+// the same entries have the same digests.
+type StateDigest struct {
+	Index uint64 `json:"index"`
+	// Digest hashes all the namespaces.
+	Digest string `json:"digest"`
+	// Namespaces maps each namespace to the hash of its model and policies.
+	Namespaces map[string]string `json:"namespaces"`
+}
+
+// digestRegistry keeps the last digests of the node. They are diagnostics
+// of this node, so not part of snapshots.
+type digestRegistry struct {
+	mu      sync.RWMutex
+	digests []StateDigest
+}
+
+func newDigestRegistry() *digestRegistry {
+	return &digestRegistry{}
+}
+
+func (r *digestRegistry) add(d StateDigest) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.digests = append(r.digests, d)
+	if len(r.digests) > digestsKept {
+		r.digests = r.digests[len(r.digests)-digestsKept:]
+	}
+}
+
+func (r *digestRegistry) get(index uint64) (StateDigest, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for _, d := range r.digests {
+		if d.Index == index {
+			return d, true
+		}
+	}
+	return StateDigest{}, false
+}
+
+// RecordDigest has every node digest its state once it applies the entry
+// this appends, and returns the index of that entry.
+func (s *Store) RecordDigest(ctx context.Context) (uint64, error) {
+	cmd, err := proto.Marshal(&command.Command{
+		Type:     command.Type_COMMAND_TYPE_NOOP,
+		Metadata: map[string]string{digestMeta: "true"},
+	})
+	if err != nil {
+		return 0, err
+	}
+	f, err := s.apply(ctx, cmd)
+	if err != nil {
+		return 0, err
+	}
+	return f.Index(), nil
+}
+
+// StateDigest returns the digest of the state of this node at index, if it
+// recorded one. Nodes restored from a snapshot taken after index have none.
+func (s *Store) StateDigest(index uint64) (StateDigest, bool) {
+	return s.digests.get(index)
+}
+
+// namespaceState is what the digest of a namespace covers.
+type namespaceState struct {
+	Model    string                `json:"model"`
+	Policies map[string][][]string `json:"policies"`
+}
+
+// digestState hashes the models and policies of the namespaces. Policies
+// are sorted, as their order depends on whether they were restored from a
+// snapshot.
+func (s *Store) digestState(index uint64) StateDigest {
+	d := StateDigest{Index: index, Namespaces: make(map[string]string)}
+	s.enforcers.Range(func(key, value interface{}) bool {
+		e, ok := value.(*casbin.DistributedEnforcer)
+		if !ok {
+			return true
+		}
+		state := namespaceState{Policies: make(map[string][][]string)}
+		if m := e.GetModel(); m != nil {
+			state.Model = m.ToText()
+			for _, sec := range []string{"p", "g"} {
+				for pType, ast := range m[sec] {
+					rules := make([][]string, len(ast.Policy))
+					copy(rules, ast.Policy)
+					sort.Slice(rules, func(i, j int) bool { return lessRule(rules[i], rules[j]) })
+					state.Policies[sec+"/"+pType] = rules
+				}
+			}
+		}
+		// maps are marshalled with sorted keys
+		b, _ := json.Marshal(state)
+		sum := sha256.Sum256(b)
+		d.Namespaces[key.(string)] = hex.EncodeToString(sum[:])
+		return true
+	})
+	b, _ := json.Marshal(d.Namespaces)
+	sum := sha256.Sum256(b)
+	d.Digest = hex.EncodeToString(sum[:])
+	return d
+}
+
+func lessRule(a, b []string) bool {
+	for i := 0; i < len(a) && i < len(b); i++ {
+		if a[i] != b[i] {
+			return a[i] < b[i]
+		}
+	}
+	return len(a) < len(b)
+}
@@ -0,0 +1,251 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package store
+
+import (
+	"expvar"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/casbin/casbin-mesh/proto/command"
+	"github.com/golang/protobuf/proto"
+	"github.com/hashicorp/raft"
+)
+
+// DiskLowKey is the node metadata key publishing whether the free space of
+// the data directory of the node is low.
+const DiskLowKey = "disk_low"
+
+// diskCheckInterval is how often the free space of the data directory is
+// checked.
+const diskCheckInterval = 10 * time.Second
+
+const (
+	diskFreeBytes   = "disk_free_bytes"
+	diskLow         = "disk_low"
+	numDiskRefusals = "num_disk_refusals"
+)
+
+// DiskStatus is the free space of the data directory of this node.
+type DiskStatus struct {
+	Free    uint64 `json:"free"`
+	MinFree uint64 `json:"min_free"`
+	// Low is set while Free is under MinFree, writes are then refused.
+	Low bool `json:"low"`
+	// Since is the unix time in nanoseconds the free space went low at.
+	Since int64 `json:"since,omitempty"`
+	// Refused counts the writes refused for lack of space.
+	Refused uint64 `json:"refused"`
+}
+
+// diskWatchdog holds the free space of the data directory. It is changed by
+// the watchdog and read when commands are applied.
+type diskWatchdog struct {
+	mu     sync.RWMutex
+	status DiskStatus
+	// published is the Low status last published in the metadata of the
+	// node, empty until it is.
+	published string
+}
+
+func newDiskWatchdog() *diskWatchdog {
+	return &diskWatchdog{}
+}
+
+func (w *diskWatchdog) get() DiskStatus {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	return w.status
+}
+
+// update records free bytes, and returns whether the space went low or back
+// to normal.
+func (w *diskWatchdog) update(free, minFree uint64) (changed bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	low := free < minFree
+	changed = low != w.status.Low
+	since := w.status.Since
+	if !low {
+		since = 0
+	} else if changed {
+		since = time.Now().UnixNano()
+	}
+	w.status = DiskStatus{Free: free, MinFree: minFree, Low: low, Since: since, Refused: w.status.Refused}
+	return changed
+}
+
+// refuse counts a write refused for lack of space.
+func (w *diskWatchdog) refuse() {
+	w.mu.Lock()
+	w.status.Refused++
+	w.mu.Unlock()
+	stats.Add(numDiskRefusals, 1)
+}
+
+// checkDiskSpace returns an error wrapping ErrReadOnly if cmd mutates the
+// policies while the free space is low, on this node or on so many voters
+// that the others are no quorum.
+func (s *Store) checkDiskSpace(cmd []byte) error {
+	status := s.disk.get()
+	var low []string
+	if !status.Low {
+		if low = s.lowDiskVoters(); low == nil {
+			return nil
+		}
+	}
+	var c command.Command
+	if err := proto.Unmarshal(cmd, &c); err != nil || !mutates(c.Type) {
+		return nil
+	}
+	s.disk.refuse()
+	if status.Low {
+		return fmt.Errorf("%w: %d bytes free on the data directory, under %d", ErrReadOnly, status.Free, status.MinFree)
+	}
+	return fmt.Errorf("%w: voters %s are low on disk space, leaving no quorum to write", ErrReadOnly, strings.Join(low, ", "))
+}
+
+// lowDiskVoters returns the voters whose metadata publish a low free space,
+// if the other voters are no quorum, nil otherwise.
+func (s *Store) lowDiskVoters() []string {
+	low := make(map[string]bool)
+	s.metaMu.RLock()
+	for id, md := range s.meta {
+		if id != s.raftID && md[DiskLowKey] == "true" {
+			low[id] = true
+		}
+	}
+	s.metaMu.RUnlock()
+	if len(low) == 0 {
+		return nil
+	}
+	f := s.raft.GetConfiguration()
+	if f.Error() != nil {
+		return nil
+	}
+	var voters int
+	var ids []string
+	for _, srv := range f.Configuration().Servers {
+		if srv.Suffrage != raft.Voter {
+			continue
+		}
+		voters++
+		if low[string(srv.ID)] {
+			ids = append(ids, string(srv.ID))
+		}
+	}
+	if voters-len(ids) > voters/2 {
+		return nil
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// startDiskWatchdog checks the free space of the data directory every
+// diskCheckInterval, once right away.
+func (s *Store) startDiskWatchdog() {
+	if s.MinFreeDisk == 0 {
+		return
+	}
+	s.checkDisk()
+	s.diskDone = make(chan struct{})
+	go func(done chan struct{}) {
+		t := time.NewTicker(diskCheckInterval)
+		defer t.Stop()
+		for {
+			select {
+			case <-t.C:
+				s.checkDisk()
+			case <-done:
+				return
+			}
+		}
+	}(s.diskDone)
+}
+
+func (s *Store) stopDiskWatchdog() {
+	if s.diskDone != nil {
+		close(s.diskDone)
+		s.diskDone = nil
+	}
+}
+
+func (s *Store) checkDisk() {
+	free, err := freeSpace(s.raftDir)
+	if err != nil {
+		s.logger.Printf("failed to check the free space of %s: %s", s.raftDir, err.Error())
+		return
+	}
+	stats.Set(diskFreeBytes, intVar(int64(free)))
+	changed := s.disk.update(free, s.MinFreeDisk)
+	s.publishDisk()
+	if !changed {
+		return
+	}
+	if s.disk.get().Low {
+		stats.Set(diskLow, intVar(1))
+		s.logger.Printf("ALERT: %d bytes free on %s, under %d, refusing writes until space is freed", free, s.raftDir, s.MinFreeDisk)
+		s.reportError(fmt.Errorf("%d bytes free on %s, under %d, refusing writes", free, s.raftDir, s.MinFreeDisk))
+	} else {
+		stats.Set(diskLow, intVar(0))
+		s.logger.Printf("%d bytes free on %s, accepting writes again", free, s.raftDir)
+	}
+}
+
+// publishDisk publishes whether the free space is low in the metadata of
+// the node, until it succeeds.
+func (s *Store) publishDisk() {
+	low := strconv.FormatBool(s.disk.get().Low)
+	s.disk.mu.RLock()
+	published := s.disk.published
+	s.disk.mu.RUnlock()
+	if low == published {
+		return
+	}
+	md := map[string]string{DiskLowKey: low}
+	var err error
+	if s.raft.State() == raft.Leader {
+		err = s.SetMetadata(md)
+	} else if s.PublishMetadata != nil {
+		err = s.PublishMetadata(md)
+	} else {
+		return
+	}
+	if err != nil {
+		s.logger.Printf("failed to publish the free space of %s: %s", s.raftDir, err.Error())
+		return
+	}
+	s.disk.mu.Lock()
+	s.disk.published = low
+	s.disk.mu.Unlock()
+}
+
+// DiskStatus returns the free space of the data directory of this node.
+func (s *Store) DiskStatus() DiskStatus {
+	return s.disk.get()
+}
+
+func intVar(v int64) *expvar.Int {
+	i := new(expvar.Int)
+	i.Set(v)
+	return i
+}
@@ -0,0 +1,86 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package store
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/casbin/casbin/v2"
+	"github.com/casbin/casbin/v2/model"
+)
+
+// ErrUnsupportedEffect is returned when a model has a policy effect Casbin
+// does not evaluate, which would fail every request of the namespace.
+var ErrUnsupportedEffect = errors.New("unsupported policy effect")
+
+// supportedEffects maps the policy effects Casbin evaluates to the decision
+// of the requests no rule matches.
+var supportedEffects = map[string]string{
+	"some(where (p_eft == allow))":                                 "deny",
+	"!some(where (p_eft == deny))":                                 "allow",
+	"some(where (p_eft == allow)) && !some(where (p_eft == deny))": "deny",
+	"priority(p_eft) || deny":                                      "deny",
+}
+
+// Effect is a policy effect of a model.
+type Effect struct {
+	// Key is the effect definition, e.g. e or e2.
+	Key  string `json:"key"`
+	Expr string `json:"expr"`
+	// Default is the decision, allow or deny, of the requests no rule
+	// matches.
+	Default string `json:"default"`
+}
+
+// ModelEffects returns the policy effects of the model text, refusing those
+// Casbin does not evaluate.
+func ModelEffects(text string) ([]Effect, error) {
+	m, err := model.NewModelFromString(text)
+	if err != nil {
+		return nil, err
+	}
+	return modelEffects(m)
+}
+
+func modelEffects(m model.Model) ([]Effect, error) {
+	var effects []Effect
+	for key, ast := range m["e"] {
+		def, ok := supportedEffects[ast.Value]
+		if !ok {
+			return nil, fmt.Errorf("%w: %s = %s", ErrUnsupportedEffect, key, ast.Value)
+		}
+		effects = append(effects, Effect{Key: key, Expr: ast.Value, Default: def})
+	}
+	sort.Slice(effects, func(i, j int) bool { return effects[i].Key < effects[j].Key })
+	return effects, nil
+}
+
+// NamespaceEffects returns the policy effects of the model of a namespace.
+func (s *Store) NamespaceEffects(ns string) ([]Effect, error) {
+	e, ok := s.enforcers.Load(ns)
+	if !ok {
+		return nil, NamespaceNotExist
+	}
+	m := e.(*casbin.DistributedEnforcer).GetModel()
+	if m == nil || len(m["e"]) == 0 {
+		return nil, ModelUnsetYet
+	}
+	return modelEffects(m)
+}
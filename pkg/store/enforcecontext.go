@@ -0,0 +1,117 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package store
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/casbin/casbin-mesh/proto/command"
+	"github.com/casbin/casbin/v2"
+	"github.com/casbin/casbin/v2/model"
+)
+
+// isDefaultContext tells whether ec selects the default r, p, e and m
+// definitions.
+func isDefaultContext(ec *command.EnforceContext) bool {
+	return ec == nil || (orDefault(ec.RType, "r") == "r" && orDefault(ec.PType, "p") == "p" &&
+		orDefault(ec.EType, "e") == "e" && orDefault(ec.MType, "m") == "m")
+}
+
+func orDefault(t, def string) string {
+	if t == "" {
+		return def
+	}
+	return t
+}
+
+// lookupAssertion returns the definition of type t in section sec.
+func lookupAssertion(m model.Model, sec, t string) (*model.Assertion, error) {
+	if ast, ok := m[sec][t]; ok {
+		return ast, nil
+	}
+	return nil, fmt.Errorf("%s not defined in the model", t)
+}
+
+// renameTokens returns tokens, e.g. r2_sub, renamed to the default type, e.g.
+// r_sub.
+func renameTokens(tokens []string, from, to string) []string {
+	out := make([]string, len(tokens))
+	for i, token := range tokens {
+		out[i] = to + strings.TrimPrefix(token, from)
+	}
+	return out
+}
+
+// contextEnforcer returns an enforcer of the definitions selected by ec,
+// renamed to the default ones, which Casbin enforces with. It shares the role
+// managers of enforcer and holds a copy of the selected rules.
+func contextEnforcer(enforcer *casbin.DistributedEnforcer, ec *command.EnforceContext) (*casbin.Enforcer, error) {
+	m := enforcer.GetModel()
+	if m == nil {
+		return nil, ModelUnsetYet
+	}
+	rType, pType := orDefault(ec.RType, "r"), orDefault(ec.PType, "p")
+	r, err := lookupAssertion(m, "r", rType)
+	if err != nil {
+		return nil, err
+	}
+	p, err := lookupAssertion(m, "p", pType)
+	if err != nil {
+		return nil, err
+	}
+	e, err := lookupAssertion(m, "e", orDefault(ec.EType, "e"))
+	if err != nil {
+		return nil, err
+	}
+	matcher, err := lookupAssertion(m, "m", orDefault(ec.MType, "m"))
+	if err != nil {
+		return nil, err
+	}
+	// the matcher refers to the selected types as e.g. r2.sub or p2_obj
+	value := regexp.MustCompile(`\b`+regexp.QuoteMeta(rType)+`[._]`).ReplaceAllString(matcher.Value, "r_")
+	value = regexp.MustCompile(`\b`+regexp.QuoteMeta(pType)+`[._]`).ReplaceAllString(value, "p_")
+
+	shadow := model.Model{
+		"r": model.AssertionMap{"r": {Key: "r", Value: r.Value, Tokens: renameTokens(r.Tokens, rType+"_", "r_")}},
+		"p": model.AssertionMap{"p": {Key: "p", Value: p.Value, Tokens: renameTokens(p.Tokens, pType+"_", "p_"),
+			Policy: enforcer.GetFilteredNamedPolicy(pType, 0)}},
+		"e": model.AssertionMap{"e": {Key: "e", Value: e.Value}},
+		"m": model.AssertionMap{"m": {Key: "m", Value: value}},
+	}
+	if g, ok := m["g"]; ok {
+		shadow["g"] = model.AssertionMap{}
+		for key, ast := range g {
+			shadow["g"][key] = &model.Assertion{Key: ast.Key, Value: ast.Value, Tokens: ast.Tokens, RM: ast.RM}
+		}
+	}
+	return casbin.NewEnforcer(shadow)
+}
+
+// enforceWithContext enforces params with the definitions selected by ec.
+func enforceWithContext(enforcer *casbin.DistributedEnforcer, ec *command.EnforceContext, params []interface{}) (bool, error) {
+	if isDefaultContext(ec) {
+		return enforcer.Enforce(params...)
+	}
+	e, err := contextEnforcer(enforcer, ec)
+	if err != nil {
+		return false, err
+	}
+	return e.Enforce(params...)
+}
@@ -24,6 +24,7 @@ import (
 	"time"
 
 	"github.com/casbin/casbin/v2"
+	"github.com/casbin/casbin/v2/model"
 
 	"github.com/casbin/casbin-mesh/proto/command"
 	"github.com/golang/protobuf/proto"
@@ -45,19 +46,22 @@ func (s *Store) CreateNamespace(ctx context.Context, ns string) error {
 	if err != nil {
 		return err
 	}
-	f := s.raft.Apply(cmd, s.ApplyTimeout)
-	if e := f.(raft.Future); e.Error() != nil {
-		if e.Error() == raft.ErrNotLeader {
-			return ErrNotLeader
-		}
-		return e.Error()
+	f, err := s.apply(ctx, cmd)
+	if err != nil {
+		return err
 	}
 	r := f.Response().(*FSMResponse)
 	return r.error
 }
 
-// SetModelFromString sets casbin model from string.
+// SetModelFromString sets casbin model from string. Models with a policy
+// effect Casbin does not evaluate are refused.
 func (s *Store) SetModelFromString(ctx context.Context, ns string, text string) error {
+	if m, err := model.NewModelFromString(text); err == nil {
+		if _, err = modelEffects(m); err != nil {
+			return err
+		}
+	}
 	payload, err := proto.Marshal(&command.SetModelFromString{
 		Text: text,
 	})
@@ -74,12 +78,9 @@ func (s *Store) SetModelFromString(ctx context.Context, ns string, text string)
 	if err != nil {
 		return err
 	}
-	f := s.raft.Apply(cmd, s.ApplyTimeout)
-	if e := f.(raft.Future); e.Error() != nil {
-		if e.Error() == raft.ErrNotLeader {
-			return ErrNotLeader
-		}
-		return e.Error()
+	f, err := s.apply(ctx, cmd)
+	if err != nil {
+		return err
 	}
 	r := f.Response().(*FSMResponse)
 	return r.error
@@ -87,6 +88,15 @@ func (s *Store) SetModelFromString(ctx context.Context, ns string, text string)
 
 // Enforce executes enforcement.
 func (s *Store) Enforce(ctx context.Context, ns string, level command.EnforcePayload_Level, freshness int64, params ...interface{}) (bool, error) {
+	return s.EnforceWithContext(ctx, ns, level, freshness, nil, params...)
+}
+
+// EnforceWithContext enforces params with the request, policy, effect and
+// matcher definitions selected by ec, e.g. r2, p2, e2 and m2. A nil ec selects
+// the default ones.
+func (s *Store) EnforceWithContext(ctx context.Context, ns string, level command.EnforcePayload_Level, freshness int64, ec *command.EnforceContext, params ...interface{}) (bool, error) {
+	start := time.Now()
+	defer func() { s.enforceLoad.observe(time.Since(start)) }()
 	if level == command.EnforcePayload_QUERY_REQUEST_LEVEL_STRONG {
 		var B [][]byte
 		for _, p := range params {
@@ -101,6 +111,7 @@ func (s *Store) Enforce(ctx context.Context, ns string, level command.EnforcePay
 			B:         B,
 			Level:     level,
 			Freshness: freshness,
+			Context:   ec,
 		})
 		if err != nil {
 			return false, err
@@ -115,12 +126,9 @@ func (s *Store) Enforce(ctx context.Context, ns string, level command.EnforcePay
 		if err != nil {
 			return false, err
 		}
-		f := s.raft.Apply(cmd, s.ApplyTimeout)
-		if e := f.(raft.Future); e.Error() != nil {
-			if e.Error() == raft.ErrNotLeader {
-				return false, ErrNotLeader
-			}
-			return false, e.Error()
+		f, err := s.apply(ctx, cmd)
+		if err != nil {
+			return false, err
 		}
 		r := f.Response().(*FSMEnforceResponse)
 		return r.ok, r.error
@@ -136,7 +144,7 @@ func (s *Store) Enforce(ctx context.Context, ns string, level command.EnforcePay
 	}
 	if e, ok := s.enforcers.Load(ns); ok {
 		enforcer := e.(*casbin.DistributedEnforcer)
-		r, err := enforcer.Enforce(params...)
+		r, err := s.enforceUnexpired(ns, enforcer, ec, params, s.now().Unix())
 		return r, err
 	} else {
 		return false, NamespaceNotExist
@@ -168,6 +176,24 @@ func (s *Store) SetMetadata(md map[string]string) error {
 	return s.setMetadata(s.raftID, md)
 }
 
+// SetNodeMetadata adds the metadata md to any existing metadata for the
+// cluster member with the given ID.
+func (s *Store) SetNodeMetadata(id string, md map[string]string) error {
+	if s.raft.State() != raft.Leader {
+		return ErrNotLeader
+	}
+	nodes, err := s.Nodes()
+	if err != nil {
+		return err
+	}
+	for _, n := range nodes {
+		if n.ID == id {
+			return s.setMetadata(id, md)
+		}
+	}
+	return ErrNodeNotExist
+}
+
 // setMetadata adds the metadata md to any existing metadata for
 // the given node ID.
 func (s *Store) setMetadata(id string, md map[string]string) error {
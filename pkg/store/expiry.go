@@ -0,0 +1,336 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/casbin/casbin-mesh/proto/command"
+	"github.com/casbin/casbin/v2"
+	"github.com/casbin/casbin/v2/model"
+	"github.com/golang/protobuf/proto"
+)
+
+// expiringRule is a rule added with an expiry or a schedule.
+type expiringRule struct {
+	Sec      string   `json:"sec"`
+	PType    string   `json:"ptype"`
+	Rule     []string `json:"rule"`
+	ExpireAt int64    `json:"expire_at"`
+	// Schedule restricts when the rule is active, Active tells whether the
+	// rule is in the enforcer. Rules without a schedule are always active.
+	Schedule *command.Schedule `json:"schedule,omitempty"`
+	Active   bool              `json:"active,omitempty"`
+}
+
+// DuePolicies names a policy type of a namespace holding expired rules.
+type DuePolicies struct {
+	Namespace string
+	Sec       string
+	PType     string
+}
+
+// expiryRegistry holds the expiry and the schedule of the rules of each
+// namespace. It is changed by the FSM and read by the janitor.
+type expiryRegistry struct {
+	mu    sync.RWMutex
+	rules map[string]map[string]expiringRule
+}
+
+func newExpiryRegistry() *expiryRegistry {
+	return &expiryRegistry{rules: make(map[string]map[string]expiringRule)}
+}
+
+func ruleKey(sec, pType string, rule []string) string {
+	return sec + "\x00" + pType + "\x00" + strings.Join(rule, "\x00")
+}
+
+// set sets the expiry and the schedule of rules, rules without either are
+// permanent.
+func (r *expiryRegistry) set(ns, sec, pType string, rules [][]string, expireAt int64, schedule *command.Schedule, active bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if expireAt == 0 && schedule == nil {
+		r.dropLocked(ns, sec, pType, rules)
+		return
+	}
+	if _, ok := r.rules[ns]; !ok {
+		r.rules[ns] = make(map[string]expiringRule)
+	}
+	for _, rule := range rules {
+		r.rules[ns][ruleKey(sec, pType, rule)] = expiringRule{Sec: sec, PType: pType, Rule: rule, ExpireAt: expireAt,
+			Schedule: schedule, Active: active}
+	}
+}
+
+func (r *expiryRegistry) drop(ns, sec, pType string, rules [][]string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.dropLocked(ns, sec, pType, rules)
+}
+
+func (r *expiryRegistry) dropLocked(ns, sec, pType string, rules [][]string) {
+	for _, rule := range rules {
+		delete(r.rules[ns], ruleKey(sec, pType, rule))
+	}
+	if len(r.rules[ns]) == 0 {
+		delete(r.rules, ns)
+	}
+}
+
+// move moves the expiry and the schedule of oldRules to the newRules at the
+// same index.
+func (r *expiryRegistry) move(ns, sec, pType string, oldRules, newRules [][]string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i, rule := range oldRules {
+		key := ruleKey(sec, pType, rule)
+		e, ok := r.rules[ns][key]
+		if !ok || i >= len(newRules) {
+			continue
+		}
+		delete(r.rules[ns], key)
+		e.Rule = newRules[i]
+		r.rules[ns][ruleKey(sec, pType, newRules[i])] = e
+	}
+}
+
+// copy copies the expiry and the schedule of rules in ns to the same rules
+// in to.
+func (r *expiryRegistry) copy(ns, to, sec, pType string, rules [][]string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, rule := range rules {
+		key := ruleKey(sec, pType, rule)
+		e, ok := r.rules[ns][key]
+		if !ok {
+			continue
+		}
+		if _, ok := r.rules[to]; !ok {
+			r.rules[to] = make(map[string]expiringRule)
+		}
+		r.rules[to][key] = e
+	}
+}
+
+func (r *expiryRegistry) reset() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.rules = make(map[string]map[string]expiringRule)
+}
+
+func (r *expiryRegistry) dropNamespace(ns string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.rules, ns)
+}
+
+// expired returns the rules of a policy type expiring at or before now, and
+// those of them which are in the enforcer.
+func (r *expiryRegistry) expired(ns, sec, pType string, now int64) (all [][]string, active [][]string) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for _, e := range r.rules[ns] {
+		if e.Sec == sec && e.PType == pType && e.ExpireAt != 0 && e.ExpireAt <= now {
+			all = append(all, e.Rule)
+			if e.Schedule == nil || e.Active {
+				active = append(active, e.Rule)
+			}
+		}
+	}
+	sortRules(all)
+	sortRules(active)
+	return all, active
+}
+
+// expiredActive returns the rules of ns in the enforcer and expired at now.
+func (r *expiryRegistry) expiredActive(ns string, now int64) []expiringRule {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	var out []expiringRule
+	for _, e := range r.rules[ns] {
+		if e.ExpireAt != 0 && e.ExpireAt <= now && (e.Schedule == nil || e.Active) {
+			out = append(out, e)
+		}
+	}
+	return out
+}
+
+// permanent returns the rules of a policy type of ns which are in m without
+// an expiry or a schedule. Adding them again with either keeps them
+// permanent.
+func (r *expiryRegistry) permanent(m model.Model, ns, sec, pType string, rules [][]string) map[string]bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	out := make(map[string]bool)
+	for _, rule := range rules {
+		key := ruleKey(sec, pType, rule)
+		if _, ok := r.rules[ns][key]; !ok && m.HasPolicy(sec, pType, rule) {
+			out[key] = true
+		}
+	}
+	return out
+}
+
+func sortRules(rules [][]string) {
+	sort.Slice(rules, func(i, j int) bool { return strings.Join(rules[i], "\x00") < strings.Join(rules[j], "\x00") })
+}
+
+func (r *expiryRegistry) due(now int64) []DuePolicies {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	seen := make(map[DuePolicies]bool)
+	var out []DuePolicies
+	for ns, rules := range r.rules {
+		for _, e := range rules {
+			d := DuePolicies{Namespace: ns, Sec: e.Sec, PType: e.PType}
+			if e.ExpireAt != 0 && e.ExpireAt <= now && !seen[d] {
+				seen[d] = true
+				out = append(out, d)
+			}
+		}
+	}
+	return out
+}
+
+func (r *expiryRegistry) MarshalJSON() ([]byte, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	byNs := make(map[string][]expiringRule)
+	for ns, rules := range r.rules {
+		for _, e := range rules {
+			byNs[ns] = append(byNs[ns], e)
+		}
+	}
+	return json.Marshal(byNs)
+}
+
+func (r *expiryRegistry) UnmarshalJSON(b []byte) error {
+	var byNs map[string][]expiringRule
+	if err := json.Unmarshal(b, &byNs); err != nil {
+		return err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.rules = make(map[string]map[string]expiringRule)
+	for ns, rules := range byNs {
+		r.rules[ns] = make(map[string]expiringRule)
+		for _, e := range rules {
+			r.rules[ns][ruleKey(e.Sec, e.PType, e.Rule)] = e
+		}
+	}
+	return nil
+}
+
+// AddExpiringPolicies adds rules expiring at expireAt, they are removed by
+// ExpirePolicies once expired. Adding an existing rule sets its expiry, a
+// zero expireAt makes it permanent.
+func (s *Store) AddExpiringPolicies(ctx context.Context, ns string, sec string, pType string, rules [][]string, expireAt time.Time) ([][]string, error) {
+	var at int64
+	if !expireAt.IsZero() {
+		at = expireAt.Unix()
+	}
+	return s.addPolicies(ctx, ns, &command.AddPoliciesPayload{
+		Sec:      sec,
+		PType:    pType,
+		Rules:    command.NewStringArray(rules),
+		ExpireAt: at,
+		Now:      s.now().Unix(),
+	})
+}
+
+// enforceUnexpired enforces params in ns as of now, a Unix time, leaving out
+// the rules expired at now which the janitor did not remove yet. The root
+// subjects of ns are allowed whatever the rules, and the requests failing to
+// evaluate are denied if ns fails closed.
+func (s *Store) enforceUnexpired(ns string, enforcer *casbin.DistributedEnforcer, ec *command.EnforceContext, params []interface{}, now int64) (bool, error) {
+	settings := s.settings.get(ns)
+	if settings.isRoot(params) {
+		return true, nil
+	}
+	ok, err := s.enforceActive(ns, enforcer, ec, params, now)
+	if err != nil && settings.FailClosed {
+		return false, nil
+	}
+	return ok, err
+}
+
+// enforceActive enforces params in ns with the rules active at now.
+func (s *Store) enforceActive(ns string, enforcer *casbin.DistributedEnforcer, ec *command.EnforceContext, params []interface{}, now int64) (bool, error) {
+	if expired := s.expiries.expiredActive(ns, now); len(expired) > 0 {
+		m := enforcer.GetModel()
+		if m == nil {
+			return false, ModelUnsetYet
+		}
+		m = m.Copy()
+		for _, e := range expired {
+			m.RemovePolicy(e.Sec, e.PType, e.Rule)
+		}
+		shadow, err := casbin.NewDistributedEnforcer(m)
+		if err != nil {
+			return false, err
+		}
+		if err := shadow.BuildRoleLinks(); err != nil {
+			return false, err
+		}
+		enforcer = shadow
+	}
+	return enforceWithContext(enforcer, ec, params)
+}
+
+// DuePolicies lists the policy types holding rules expired at now. None are
+// due while the cluster is read-only, they are removed once it is writable.
+func (s *Store) DuePolicies(now time.Time) []DuePolicies {
+	if s.readOnly.get().Enabled {
+		return nil
+	}
+	return s.expiries.due(now.Unix())
+}
+
+// ExpirePolicies removes the rules of a policy type expired at now. The time
+// is replicated along with the command, so that every node removes the same
+// rules.
+func (s *Store) ExpirePolicies(ctx context.Context, ns string, sec string, pType string, now time.Time) ([][]string, error) {
+	payload, err := proto.Marshal(&command.ExpirePoliciesPayload{
+		Sec:   sec,
+		PType: pType,
+		Now:   now.Unix(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	cmd, err := proto.Marshal(&command.Command{
+		Type:      command.Type_COMMAND_TYPE_EXPIRE_POLICIES,
+		Namespace: ns,
+		Payload:   payload,
+	})
+	if err != nil {
+		return nil, err
+	}
+	f, err := s.apply(ctx, cmd)
+	if err != nil {
+		return nil, err
+	}
+	r := f.Response().(*FSMResponse)
+	return r.effectedRules, r.error
+}
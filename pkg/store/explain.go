@@ -0,0 +1,184 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package store
+
+import (
+	"fmt"
+
+	"github.com/casbin/casbin/v2"
+	"github.com/casbin/casbin/v2/model"
+
+	"github.com/casbin/casbin-mesh/proto/command"
+)
+
+// ExplainedRule is the evaluation of the matcher against a rule.
+type ExplainedRule struct {
+	Rule    []string `json:"rule"`
+	Matched bool     `json:"matched"`
+	Effect  string   `json:"effect"`
+	// RoleChain leads from the subject of the request to the subject of a
+	// matched rule through the rules of g.
+	RoleChain []string `json:"roleChain,omitempty"`
+	Error     string   `json:"error,omitempty"`
+}
+
+// Explanation traces how a request is enforced.
+type Explanation struct {
+	Allowed bool `json:"allowed"`
+	// Root is set if the subject is a root of the namespace, allowed
+	// whatever the rules.
+	Root    bool   `json:"root,omitempty"`
+	Matcher string `json:"matcher"`
+	Effect  string `json:"effect"`
+	// Allows and Denies count the matched rules by effect.
+	Allows int `json:"allows"`
+	Denies int `json:"denies"`
+	// DecidingRule is the rule the effect decided with, if any.
+	DecidingRule []string `json:"decidingRule,omitempty"`
+	// Rules are the candidate rules, all those of the selected policy type.
+	Rules []ExplainedRule `json:"rules"`
+}
+
+// Explain traces how params are enforced in ns with the definitions selected
+// by ec, against the state of this node.
+func (s *Store) Explain(ns string, ec *command.EnforceContext, params ...interface{}) (*Explanation, error) {
+	e, ok := s.enforcers.Load(ns)
+	if !ok {
+		return nil, NamespaceNotExist
+	}
+	x, err := explain(e.(*casbin.DistributedEnforcer), ec, params)
+	if err != nil {
+		return nil, err
+	}
+	if s.settings.get(ns).isRoot(params) {
+		x.Allowed, x.Root = true, true
+	}
+	return x, nil
+}
+
+func explain(enforcer *casbin.DistributedEnforcer, ec *command.EnforceContext, params []interface{}) (*Explanation, error) {
+	if ec == nil {
+		ec = &command.EnforceContext{}
+	}
+	shadow, err := contextEnforcer(enforcer, ec)
+	if err != nil {
+		return nil, err
+	}
+	allowed, deciding, err := shadow.EnforceEx(params...)
+	if err != nil {
+		return nil, err
+	}
+	m := shadow.GetModel()
+	x := &Explanation{Allowed: allowed, Matcher: m["m"]["m"].Value, Effect: m["e"]["e"].Value, DecidingRule: deciding}
+
+	// Each rule is matched alone, allowing, under an effect allowing if any
+	// rule matches, so that the result is the one of the matcher.
+	p := m["p"]["p"]
+	single := &model.Assertion{Key: "p", Value: p.Value, Tokens: p.Tokens}
+	matchModel := model.Model{
+		"r": m["r"],
+		"p": model.AssertionMap{"p": single},
+		"e": model.AssertionMap{"e": {Key: "e", Value: "some(where (p_eft == allow))"}},
+		"m": m["m"],
+	}
+	if g, ok := m["g"]; ok {
+		matchModel["g"] = g
+	}
+	matcher, err := casbin.NewEnforcer(matchModel)
+	if err != nil {
+		return nil, err
+	}
+
+	eft := indexOf(p.Tokens, "p_eft")
+	sub, rSub := indexOf(p.Tokens, "p_sub"), indexOf(m["r"]["r"].Tokens, "r_sub")
+	dom := indexOf(m["r"]["r"].Tokens, "r_dom")
+	grouping := enforcer.GetNamedGroupingPolicy("g")
+	for _, rule := range p.Policy {
+		r := ExplainedRule{Rule: rule, Effect: "allow"}
+		candidate := append([]string(nil), rule...)
+		if eft >= 0 && eft < len(rule) {
+			r.Effect = rule[eft]
+			candidate[eft] = "allow"
+		}
+		single.Policy = [][]string{candidate}
+		if r.Matched, err = matcher.Enforce(params...); err != nil {
+			r.Error = err.Error()
+		}
+		if r.Matched {
+			if r.Effect == "deny" {
+				x.Denies++
+			} else {
+				x.Allows++
+			}
+			if sub >= 0 && sub < len(rule) && rSub >= 0 && rSub < len(params) {
+				var domain string
+				if dom >= 0 && dom < len(params) {
+					domain = fmt.Sprint(params[dom])
+				}
+				r.RoleChain = roleChain(grouping, fmt.Sprint(params[rSub]), rule[sub], domain)
+			}
+		}
+		x.Rules = append(x.Rules, r)
+	}
+	return x, nil
+}
+
+func indexOf(tokens []string, token string) int {
+	for i, t := range tokens {
+		if t == token {
+			return i
+		}
+	}
+	return -1
+}
+
+// roleChain returns the shortest chain of roles of g leading from member to
+// role, nil if there is none. Rules of other domains than domain are skipped
+// if it is set.
+func roleChain(grouping [][]string, member, role, domain string) []string {
+	if member == role {
+		return []string{member}
+	}
+	prev := map[string]string{member: ""}
+	queue := []string{member}
+	for len(queue) > 0 {
+		name := queue[0]
+		queue = queue[1:]
+		for _, rule := range grouping {
+			if len(rule) < 2 || rule[0] != name {
+				continue
+			}
+			if domain != "" && len(rule) > 2 && rule[2] != domain {
+				continue
+			}
+			if _, ok := prev[rule[1]]; ok {
+				continue
+			}
+			prev[rule[1]] = name
+			if rule[1] == role {
+				var chain []string
+				for n := role; n != member; n = prev[n] {
+					chain = append([]string{n}, chain...)
+				}
+				return append([]string{member}, chain...)
+			}
+			queue = append(queue, rule[1])
+		}
+	}
+	return nil
+}
@@ -46,6 +46,7 @@ type FSMResponse struct {
 	error         error
 	effected      bool
 	effectedRules [][]string
+	replayMark
 }
 
 type FSMEnforceResponse struct {
@@ -67,11 +68,52 @@ var (
 var persist = func() bool { return true }
 
 func (s *Store) Apply(l *raft.Log) (e interface{}) {
+	defer s.reportPanic(l.Index)
+	s.applyWatch.begin(l.Index)
+	defer s.applyWatch.end()
 	var cmd command.Command
 	err := proto.Unmarshal(l.Data, &cmd)
 	if err != nil {
+		s.reportError(fmt.Errorf("failed to unmarshal log entry %d: %w", l.Index, err))
 		return &FSMResponse{error: UnmarshalFailed}
 	}
+	s.watchers.observe(l.Index)
+	if !l.AppendedAt.IsZero() && s.IsLeader() {
+		s.fsmWait.observe(time.Since(l.AppendedAt))
+	}
+	if err := s.checkSchema(l, &cmd); err != nil {
+		return &FSMResponse{error: err}
+	}
+	// standby clusters are read-only, but for the changes they replicate
+	replicated, applied := s.standby.check(&cmd)
+	if applied {
+		return &FSMResponse{}
+	}
+	if !replicated {
+		if err := s.readOnly.check(cmd.Type); err != nil {
+			return &FSMResponse{error: err}
+		}
+	}
+	var resp interface{}
+	if key := cmd.Metadata[idempotencyKeyMeta]; key != "" {
+		resp = s.applyIdempotent(l, &cmd, key)
+	} else {
+		resp = s.applyCommand(l, &cmd)
+	}
+	if replicated {
+		s.standby.record(&cmd)
+	}
+	if changesPolicies(cmd.Type) {
+		s.recordVersion(l, cmd.Namespace)
+	}
+	if feeds(cmd.Type) {
+		s.recordPolicySet(l, &cmd)
+	}
+	return resp
+}
+
+func (s *Store) applyCommand(l *raft.Log, cmd *command.Command) interface{} {
+	var err error
 	switch cmd.Type {
 	case command.Type_COMMAND_TYPE_LIST_NAMESPACES:
 		var ns []string
@@ -95,6 +137,21 @@ func (s *Store) Apply(l *raft.Log) (e interface{}) {
 			return &PrintModelResponse{model: model.ToText()}
 		}
 		return &PrintModelResponse{error: NamespaceNotExist}
+	case command.Type_COMMAND_TYPE_SNAPSHOT:
+		if e, ok := s.enforcers.Load(cmd.Namespace); ok {
+			model := e.(*casbin.DistributedEnforcer).GetModel()
+			if model == nil {
+				return &NamespaceSnapshotResponse{error: ModelUnsetYet}
+			}
+			var policies []*command.PolicyRules
+			for _, sec := range []string{"p", "g"} {
+				for pType, ast := range model[sec] {
+					policies = append(policies, &command.PolicyRules{Sec: sec, PType: pType, Rules: command.NewStringArray(ast.Policy)})
+				}
+			}
+			return &NamespaceSnapshotResponse{model: model.ToText(), policies: policies, index: l.Index}
+		}
+		return &NamespaceSnapshotResponse{error: NamespaceNotExist}
 	case command.Type_COMMAND_TYPE_LIST_POLICIES:
 		ns := cmd.Namespace
 		var policies [][]string
@@ -118,6 +175,35 @@ func (s *Store) Apply(l *raft.Log) (e interface{}) {
 		return &ListPoliciesResponse{
 			policies: policies,
 		}
+	case command.Type_COMMAND_TYPE_PAGE_POLICIES:
+		var payload command.PagePoliciesPayload
+		if err = proto.Unmarshal(cmd.Payload, &payload); err != nil {
+			return &PagePoliciesResponse{error: UnmarshalFailed}
+		}
+		if _, ok := s.enforcers.Load(cmd.Namespace); !ok {
+			return &PagePoliciesResponse{error: NamespaceNotExist}
+		}
+		var entries [][]string
+		var more bool
+		err = s.enforcersState.View(func(tx *adapter.Tx) error {
+			entries, more, err = tx.Bucket([]byte(cmd.Namespace)).Page([]byte(payload.Prefix), []byte(payload.After), int(payload.Limit))
+			return err
+		})
+		if err != nil {
+			return &PagePoliciesResponse{error: StateTransactionFailed}
+		}
+		r := &PagePoliciesResponse{}
+		for _, entry := range entries {
+			var line []string
+			if err := json.Unmarshal([]byte(entry[1]), &line); err != nil {
+				return &PagePoliciesResponse{error: UnmarshalFailed}
+			}
+			r.lines = append(r.lines, line)
+		}
+		if more {
+			r.next = entries[len(entries)-1][0]
+		}
+		return r
 	case command.Type_COMMAND_TYPE_ENFORCE_REQUEST:
 		var p command.EnforcePayload
 		if err = proto.Unmarshal(cmd.Payload, &p); err != nil {
@@ -134,7 +220,13 @@ func (s *Store) Apply(l *raft.Log) (e interface{}) {
 		}
 		if e, ok := s.enforcers.Load(cmd.Namespace); ok {
 			enforcer := e.(*casbin.DistributedEnforcer)
-			r, err := enforcer.Enforce(params...)
+			// rules expire as of the time the leader appended the request,
+			// or of the clock of the store if it has one
+			now := l.AppendedAt
+			if now.IsZero() || s.clock != nil {
+				now = s.now()
+			}
+			r, err := s.enforceUnexpired(cmd.Namespace, enforcer, p.Context, params, now.Unix())
 			if err != nil {
 				return &FSMEnforceResponse{error: err}
 			}
@@ -142,6 +234,12 @@ func (s *Store) Apply(l *raft.Log) (e interface{}) {
 		}
 		return &FSMEnforceResponse{error: NamespaceNotExist}
 	case command.Type_COMMAND_TYPE_CREATE_NAMESPACE:
+		if cmd.Metadata[namespaceLabelsMeta] != "" {
+			return s.applySetNamespaceLabels(l, cmd)
+		}
+		if cmd.Metadata[namespaceSettingsMeta] != "" {
+			return s.applySetNamespaceSettings(l, cmd)
+		}
 		_, ok := s.enforcers.Load(cmd.Namespace)
 		if ok {
 			return &FSMResponse{error: NamespaceExisted}
@@ -152,6 +250,7 @@ func (s *Store) Apply(l *raft.Log) (e interface{}) {
 		}
 
 		s.enforcers.Store(cmd.Namespace, e)
+		s.watchers.publish(&command.WatchEvent{Index: l.Index, Namespace: cmd.Namespace, Type: cmd.Type})
 		return &FSMResponse{}
 	case command.Type_COMMAND_TYPE_SET_MODEL:
 		var p command.SetModelFromString
@@ -172,12 +271,17 @@ func (s *Store) Apply(l *raft.Log) (e interface{}) {
 			if err != nil {
 				return &FSMResponse{error: err}
 			}
+			cachePatterns(enforcer)
 			log.Println("set model successfully")
 		} else {
 			return &FSMResponse{error: NamespaceNotExist}
 		}
+		s.watchers.publish(&command.WatchEvent{Index: l.Index, Namespace: cmd.Namespace, Type: cmd.Type, Model: p.Text})
 		return &FSMResponse{}
 	case command.Type_COMMAND_TYPE_ADD_POLICIES:
+		if cmd.Metadata[copyFromMeta] != "" {
+			return s.applyCopyPolicies(l, cmd)
+		}
 		var p command.AddPoliciesPayload
 		if err = proto.Unmarshal(cmd.Payload, &p); err != nil {
 			return &FSMResponse{error: NamespaceNotExist}
@@ -188,15 +292,45 @@ func (s *Store) Apply(l *raft.Log) (e interface{}) {
 			if enforcer.GetModel() == nil {
 				return &FSMResponse{error: ModelUnsetYet}
 			}
-			effectedRules, err = enforcer.AddPoliciesSelf(persist, p.Sec, p.PType, command.ToStringArray(p.Rules))
-			if err != nil {
-				return &FSMResponse{error: err}
+			rules := command.ToStringArray(p.Rules)
+			if p.ExpireAt != 0 || p.Schedule != nil {
+				// rules in the enforcer without an expiry stay permanent
+				permanent := s.expiries.permanent(enforcer.GetModel(), cmd.Namespace, p.Sec, p.PType, rules)
+				var kept [][]string
+				for _, rule := range rules {
+					if !permanent[ruleKey(p.Sec, p.PType, rule)] {
+						kept = append(kept, rule)
+					}
+				}
+				rules = kept
+			}
+			active := true
+			if p.Schedule != nil {
+				if err = ValidateSchedule(p.Schedule); err != nil {
+					return &FSMResponse{error: err}
+				}
+				active = scheduleActive(p.Schedule, p.Now)
 			}
+			// scheduled rules are only added once active
+			if active {
+				effectedRules, err = enforcer.AddPoliciesSelf(persist, p.Sec, p.PType, command.ToStringArray(p.Rules))
+				if err != nil {
+					return &FSMResponse{error: err}
+				}
+			}
+			s.expiries.set(cmd.Namespace, p.Sec, p.PType, rules, p.ExpireAt, p.Schedule, active)
 		} else {
 			return &FSMResponse{error: NamespaceNotExist}
 		}
+		if len(effectedRules) > 0 {
+			s.watchers.publish(&command.WatchEvent{Index: l.Index, Namespace: cmd.Namespace, Type: cmd.Type,
+				Sec: p.Sec, PType: p.PType, Rules: command.NewStringArray(effectedRules)})
+		}
 		return &FSMResponse{effectedRules: effectedRules}
 	case command.Type_COMMAND_TYPE_UPDATE_POLICIES:
+		if cmd.Metadata[renameSubjectMeta] != "" {
+			return s.applyRenameSubject(l, cmd)
+		}
 		var p command.UpdatePoliciesPayload
 		if err = proto.Unmarshal(cmd.Payload, &p); err != nil {
 			return &FSMResponse{error: UnmarshalFailed}
@@ -208,11 +342,22 @@ func (s *Store) Apply(l *raft.Log) (e interface{}) {
 			if err != nil {
 				return &FSMResponse{error: err}
 			}
+			if effected {
+				s.expiries.drop(cmd.Namespace, p.Sec, p.PType, command.ToStringArray(p.OldRules))
+				s.annotations.move(cmd.Namespace, p.Sec, p.PType, command.ToStringArray(p.OldRules), command.ToStringArray(p.NewRules))
+			}
 		} else {
 			return &FSMResponse{error: NamespaceNotExist}
 		}
+		if effected {
+			s.watchers.publish(&command.WatchEvent{Index: l.Index, Namespace: cmd.Namespace, Type: cmd.Type,
+				Sec: p.Sec, PType: p.PType, Rules: p.NewRules, OldRules: p.OldRules})
+		}
 		return &FSMResponse{effected: effected}
 	case command.Type_COMMAND_TYPE_REMOVE_POLICIES:
+		if cmd.Metadata[removeSubjectMeta] != "" {
+			return s.applyRemoveSubject(l, cmd)
+		}
 		var p command.RemovePoliciesPayload
 		if err = proto.Unmarshal(cmd.Payload, &p); err != nil {
 			return &FSMResponse{error: UnmarshalFailed}
@@ -224,9 +369,16 @@ func (s *Store) Apply(l *raft.Log) (e interface{}) {
 			if err != nil {
 				return &FSMResponse{error: err}
 			}
+			// inactive scheduled rules are removed too
+			s.expiries.drop(cmd.Namespace, p.Sec, p.PType, command.ToStringArray(p.Rules))
+			s.annotations.drop(cmd.Namespace, p.Sec, p.PType, command.ToStringArray(p.Rules))
 		} else {
 			return &FSMResponse{error: NamespaceNotExist}
 		}
+		if len(effectedRules) > 0 {
+			s.watchers.publish(&command.WatchEvent{Index: l.Index, Namespace: cmd.Namespace, Type: cmd.Type,
+				Sec: p.Sec, PType: p.PType, Rules: command.NewStringArray(effectedRules)})
+		}
 		return &FSMResponse{effectedRules: effectedRules}
 	case command.Type_COMMAND_TYPE_REMOVE_FILTERED_POLICY:
 		var p command.RemoveFilteredPolicyPayload
@@ -240,21 +392,137 @@ func (s *Store) Apply(l *raft.Log) (e interface{}) {
 			if err != nil {
 				return &FSMResponse{error: err}
 			}
+			s.expiries.drop(cmd.Namespace, p.Sec, p.PType, effectedRules)
+			s.annotations.drop(cmd.Namespace, p.Sec, p.PType, effectedRules)
 		} else {
 			return &FSMResponse{error: NamespaceNotExist}
 		}
+		if len(effectedRules) > 0 {
+			s.watchers.publish(&command.WatchEvent{Index: l.Index, Namespace: cmd.Namespace, Type: cmd.Type,
+				Sec: p.Sec, PType: p.PType, Rules: command.NewStringArray(effectedRules)})
+		}
 		return &FSMResponse{effectedRules: effectedRules}
 	case command.Type_COMMAND_TYPE_CLEAR_POLICY:
 		if e, ok := s.enforcers.Load(cmd.Namespace); ok {
 			enforcer := e.(*casbin.DistributedEnforcer)
-			err := enforcer.ClearPolicySelf(persist)
+			// the adapter can't save a whole policy, the rules are removed
+			// one policy type at a time
+			if enforcer.GetModel() != nil {
+				for _, rules := range currentPolicies(enforcer) {
+					if _, err := enforcer.RemovePoliciesSelf(persist, rules.Sec, rules.PType, command.ToStringArray(rules.Rules)); err != nil {
+						return &FSMResponse{error: err}
+					}
+				}
+			}
+			s.expiries.dropNamespace(cmd.Namespace)
+			s.annotations.dropNamespace(cmd.Namespace)
+		} else {
+			return &FSMResponse{error: NamespaceNotExist}
+		}
+		s.watchers.publish(&command.WatchEvent{Index: l.Index, Namespace: cmd.Namespace, Type: cmd.Type})
+		return &FSMResponse{}
+	case command.Type_COMMAND_TYPE_EXPIRE_POLICIES:
+		var p command.ExpirePoliciesPayload
+		if err = proto.Unmarshal(cmd.Payload, &p); err != nil {
+			return &FSMResponse{error: UnmarshalFailed}
+		}
+		var effectedRules [][]string
+		if e, ok := s.enforcers.Load(cmd.Namespace); ok {
+			enforcer := e.(*casbin.DistributedEnforcer)
+			expired, active := s.expiries.expired(cmd.Namespace, p.Sec, p.PType, p.Now)
+			if len(expired) == 0 {
+				return &FSMResponse{}
+			}
+			// inactive scheduled rules are not in the enforcer
+			if len(active) > 0 {
+				effectedRules, err = enforcer.RemovePoliciesSelf(persist, p.Sec, p.PType, active)
+				if err != nil {
+					return &FSMResponse{error: err}
+				}
+			}
+			s.expiries.drop(cmd.Namespace, p.Sec, p.PType, expired)
+			s.annotations.drop(cmd.Namespace, p.Sec, p.PType, expired)
+		} else {
+			return &FSMResponse{error: NamespaceNotExist}
+		}
+		// watchers see expired rules as removed ones
+		if len(effectedRules) > 0 {
+			s.watchers.publish(&command.WatchEvent{Index: l.Index, Namespace: cmd.Namespace, Type: command.Type_COMMAND_TYPE_REMOVE_POLICIES,
+				Sec: p.Sec, PType: p.PType, Rules: command.NewStringArray(effectedRules)})
+		}
+		return &FSMResponse{effectedRules: effectedRules}
+	case command.Type_COMMAND_TYPE_SCHEDULE_POLICIES:
+		var p command.SchedulePoliciesPayload
+		if err = proto.Unmarshal(cmd.Payload, &p); err != nil {
+			return &FSMResponse{error: UnmarshalFailed}
+		}
+		var effectedRules [][]string
+		if e, ok := s.enforcers.Load(cmd.Namespace); ok {
+			enforcer := e.(*casbin.DistributedEnforcer)
+			rules := s.expiries.scheduled(cmd.Namespace, p.Sec, p.PType, p.Now, p.Activate)
+			if len(rules) == 0 {
+				return &FSMResponse{}
+			}
+			if p.Activate {
+				effectedRules, err = enforcer.AddPoliciesSelf(persist, p.Sec, p.PType, rules)
+			} else {
+				effectedRules, err = enforcer.RemovePoliciesSelf(persist, p.Sec, p.PType, rules)
+			}
 			if err != nil {
 				return &FSMResponse{error: err}
 			}
+			s.expiries.setActive(cmd.Namespace, p.Sec, p.PType, rules, p.Activate)
 		} else {
 			return &FSMResponse{error: NamespaceNotExist}
 		}
-		return &FSMResponse{}
+		// watchers see activated rules as added ones and deactivated rules as
+		// removed ones
+		if len(effectedRules) > 0 {
+			eventType := command.Type_COMMAND_TYPE_REMOVE_POLICIES
+			if p.Activate {
+				eventType = command.Type_COMMAND_TYPE_ADD_POLICIES
+			}
+			s.watchers.publish(&command.WatchEvent{Index: l.Index, Namespace: cmd.Namespace, Type: eventType,
+				Sec: p.Sec, PType: p.PType, Rules: command.NewStringArray(effectedRules)})
+		}
+		return &FSMResponse{effectedRules: effectedRules}
+	case command.Type_COMMAND_TYPE_ANNOTATE_POLICIES:
+		var p command.AnnotatePoliciesPayload
+		if err = proto.Unmarshal(cmd.Payload, &p); err != nil {
+			return &FSMResponse{error: UnmarshalFailed}
+		}
+		var effectedRules [][]string
+		if e, ok := s.enforcers.Load(cmd.Namespace); ok {
+			enforcer := e.(*casbin.DistributedEnforcer)
+			if enforcer.GetModel() == nil {
+				return &FSMResponse{error: ModelUnsetYet}
+			}
+			for _, rule := range command.ToStringArray(p.Rules) {
+				if s.hasRule(enforcer, cmd.Namespace, p.Sec, p.PType, rule) {
+					effectedRules = append(effectedRules, rule)
+				}
+			}
+			s.annotations.set(cmd.Namespace, p.Sec, p.PType, effectedRules, p.Annotation)
+		} else {
+			return &FSMResponse{error: NamespaceNotExist}
+		}
+		return &FSMResponse{effectedRules: effectedRules}
+	case command.Type_COMMAND_TYPE_LIST_ANNOTATIONS:
+		if _, ok := s.enforcers.Load(cmd.Namespace); !ok {
+			return &ListAnnotationsResponse{error: NamespaceNotExist}
+		}
+		return &ListAnnotationsResponse{annotations: s.annotations.list(cmd.Namespace)}
+	case command.Type_COMMAND_TYPE_TAG_VERSION:
+		return s.applyTag(l, cmd)
+	case command.Type_COMMAND_TYPE_ROLLBACK_POLICIES:
+		return s.applyRollback(l, cmd)
+	case command.Type_COMMAND_TYPE_LIST_VERSIONS:
+		if _, ok := s.enforcers.Load(cmd.Namespace); !ok {
+			return &ListVersionsResponse{error: NamespaceNotExist}
+		}
+		return &ListVersionsResponse{versions: s.versions.list(cmd.Namespace)}
+	case command.Type_COMMAND_TYPE_SET_READ_ONLY:
+		return s.applySetReadOnly(l, cmd)
 	case command.Type_COMMAND_TYPE_METADATA_SET:
 		var ms command.MetadataSet
 		if err := proto.UnmarshalMerge(cmd.Payload, &ms); err != nil {
@@ -282,6 +550,20 @@ func (s *Store) Apply(l *raft.Log) (e interface{}) {
 			delete(s.meta, md.RaftId)
 		}()
 		return &FSMResponse{}
+	case command.Type_COMMAND_TYPE_SET_TEMPLATE,
+		command.Type_COMMAND_TYPE_DELETE_TEMPLATE,
+		command.Type_COMMAND_TYPE_LIST_TEMPLATES,
+		command.Type_COMMAND_TYPE_SET_TEMPLATE_INSTANCE,
+		command.Type_COMMAND_TYPE_DELETE_TEMPLATE_INSTANCE:
+		return s.applyTemplateCommand(cmd)
+	case command.Type_COMMAND_TYPE_NOOP:
+		if cmd.Metadata[digestMeta] != "" {
+			s.digests.add(s.digestState(l.Index))
+		}
+		if id := cmd.Metadata[clusterIDMeta]; id != "" {
+			s.setClusterID(id)
+		}
+		return &FSMResponse{}
 	default:
 		return &FSMResponse{error: fmt.Errorf("unhandled command: %v", cmd.Type)}
 	}
@@ -294,14 +576,42 @@ type fsmSnapshot struct {
 	models          []byte
 	state           []byte
 	meta            []byte
+	templates       []byte
+	expiries        []byte
+	annotations     []byte
+	labels          []byte
+	settings        []byte
+	policySets      []byte
+	idempotency     []byte
+	versions        []byte
+	readOnly        []byte
+	standby         []byte
 	credentialStore []byte
+	digest          []byte
+	clusterID       string
 }
 
 type persistData struct {
 	Models          []byte
 	State           []byte
 	Meta            []byte
+	Templates       []byte
+	Expiries        []byte
+	Annotations     []byte
+	Labels          []byte
+	Settings        []byte
+	PolicySets      []byte
+	Idempotency     []byte
+	Versions        []byte
+	ReadOnly        []byte
+	Standby         []byte
 	CredentialStore []byte
+	// Digest is the StateDigest of the snapshot, to verify it restores to
+	// the same state.
+	Digest []byte
+	// ClusterID is the ID of the cluster the snapshot was taken in, empty
+	// if it had none yet.
+	ClusterID string `json:",omitempty"`
 }
 
 // SnapshotHdr is used to identify the snapshot protocol version.
@@ -323,7 +633,19 @@ func (f fsmSnapshot) Persist(sink raft.SnapshotSink) error {
 			State:           f.state,
 			Models:          f.models,
 			Meta:            f.meta,
+			Templates:       f.templates,
+			Expiries:        f.expiries,
+			Annotations:     f.annotations,
+			Labels:          f.labels,
+			Settings:        f.settings,
+			PolicySets:      f.policySets,
+			Idempotency:     f.idempotency,
+			Versions:        f.versions,
+			ReadOnly:        f.readOnly,
+			Standby:         f.standby,
 			CredentialStore: f.credentialStore,
+			Digest:          f.digest,
+			ClusterID:       f.clusterID,
 		})
 		if err != nil {
 			return err
@@ -349,8 +671,8 @@ func (f fsmSnapshot) Release() {
 }
 
 // Snapshot creates a persistable state for application
-func (s *Store) Snapshot() (raft.FSMSnapshot, error) {
-	var err error
+func (s *Store) Snapshot() (_ raft.FSMSnapshot, err error) {
+	defer s.reportFailure("snapshot", &err)
 	fsm := &fsmSnapshot{
 		startT: time.Now(),
 		logger: s.logger,
@@ -363,7 +685,8 @@ func (s *Store) Snapshot() (raft.FSMSnapshot, error) {
 	}
 	models := make(map[string]string)
 	s.enforcers.Range(func(key, value interface{}) bool {
-		if e, ok := value.(*casbin.DistributedEnforcer); ok {
+		// namespaces without a model yet have no state to snapshot
+		if e, ok := value.(*casbin.DistributedEnforcer); ok && e.GetModel() != nil {
 			models[key.(string)] = e.GetModel().ToText()
 		}
 		return true
@@ -379,6 +702,62 @@ func (s *Store) Snapshot() (raft.FSMSnapshot, error) {
 		s.logger.Printf("failed to encode Meta: %s", err.Error())
 		return nil, err
 	}
+	fsm.templates, err = json.Marshal(s.templates)
+	if err != nil {
+		s.logger.Printf("failed to encode templates: %s", err.Error())
+		return nil, err
+	}
+	fsm.expiries, err = json.Marshal(s.expiries)
+	if err != nil {
+		s.logger.Printf("failed to encode expiries: %s", err.Error())
+		return nil, err
+	}
+	fsm.annotations, err = json.Marshal(s.annotations)
+	if err != nil {
+		s.logger.Printf("failed to encode annotations: %s", err.Error())
+		return nil, err
+	}
+	fsm.labels, err = json.Marshal(s.labels)
+	if err != nil {
+		s.logger.Printf("failed to encode namespace labels: %s", err.Error())
+		return nil, err
+	}
+	fsm.settings, err = json.Marshal(s.settings)
+	if err != nil {
+		s.logger.Printf("failed to encode namespace settings: %s", err.Error())
+		return nil, err
+	}
+	fsm.policySets, err = json.Marshal(s.policySets)
+	if err != nil {
+		s.logger.Printf("failed to encode policy set versions: %s", err.Error())
+		return nil, err
+	}
+	fsm.idempotency, err = json.Marshal(s.idempotency)
+	if err != nil {
+		s.logger.Printf("failed to encode idempotency results: %s", err.Error())
+		return nil, err
+	}
+	fsm.versions, err = json.Marshal(s.versions)
+	if err != nil {
+		s.logger.Printf("failed to encode versions: %s", err.Error())
+		return nil, err
+	}
+	fsm.readOnly, err = json.Marshal(s.readOnly)
+	if err != nil {
+		s.logger.Printf("failed to encode read-only status: %s", err.Error())
+		return nil, err
+	}
+	fsm.standby, err = json.Marshal(s.standby)
+	if err != nil {
+		s.logger.Printf("failed to encode standby position: %s", err.Error())
+		return nil, err
+	}
+	fsm.clusterID = s.ClusterID()
+	fsm.digest, err = json.Marshal(s.digestState(0))
+	if err != nil {
+		s.logger.Printf("failed to encode digest: %s", err.Error())
+		return nil, err
+	}
 	if s.authCredStore != nil {
 		credStoreWriter := new(bytes.Buffer)
 		if err := s.authCredStore.Snapshot(credStoreWriter); err != nil {
@@ -391,8 +770,8 @@ func (s *Store) Snapshot() (raft.FSMSnapshot, error) {
 }
 
 // Restore restores form a preexisted states
-func (s *Store) Restore(closer io.ReadCloser) error {
-	var err error
+func (s *Store) Restore(closer io.ReadCloser) (err error) {
+	defer s.reportFailure("restore snapshot", &err)
 	var data persistData
 	snap, err := ioutil.ReadAll(closer)
 	err = json.Unmarshal(snap[8:], &data)
@@ -400,6 +779,14 @@ func (s *Store) Restore(closer io.ReadCloser) error {
 		s.logger.Println("failed to decode restore data", err)
 		return err
 	}
+	// a snapshot of another cluster would replace the state of this one
+	if err = s.CheckClusterID(data.ClusterID); err != nil {
+		s.logger.Println("refusing to restore snapshot", err)
+		return err
+	}
+	if data.ClusterID != "" {
+		s.setClusterID(data.ClusterID)
+	}
 
 	err = s.enforcersState.Restore(bytes.NewReader(data.State))
 	if err != nil {
@@ -407,6 +794,7 @@ func (s *Store) Restore(closer io.ReadCloser) error {
 		return err
 	}
 	s.enforcers = sync.Map{}
+	s.watchers.reset()
 	models := make(map[string]string)
 	err = json.Unmarshal(data.Models, &models)
 	if err != nil {
@@ -435,6 +823,7 @@ func (s *Store) Restore(closer io.ReadCloser) error {
 				s.logger.Println("failed to init enforcer", err)
 				return err
 			}
+			cachePatterns(enforcer)
 			s.enforcers.Store(string(name), enforcer)
 		} else {
 			s.logger.Printf("%s namespace is not existing a valid model\n", string(name))
@@ -445,6 +834,86 @@ func (s *Store) Restore(closer io.ReadCloser) error {
 		s.logger.Println("failed to restore enforcer ", err)
 		return err
 	}
+	s.templates = newTemplateRegistry()
+	// snapshots taken before templates existed have none
+	if data.Templates != nil {
+		if err := json.Unmarshal(data.Templates, s.templates); err != nil {
+			s.logger.Println("failed to unmarshal templates", err)
+			return err
+		}
+	}
+	// the janitor reads expiries concurrently, they are restored in place
+	s.expiries.reset()
+	if data.Expiries != nil {
+		if err := json.Unmarshal(data.Expiries, s.expiries); err != nil {
+			s.logger.Println("failed to unmarshal expiries", err)
+			return err
+		}
+	}
+	s.annotations = newAnnotationRegistry()
+	if data.Annotations != nil {
+		if err := json.Unmarshal(data.Annotations, s.annotations); err != nil {
+			s.logger.Println("failed to unmarshal annotations", err)
+			return err
+		}
+	}
+	// the enforcement endpoints read labels concurrently, they are restored
+	// in place
+	s.labels.reset()
+	if data.Labels != nil {
+		if err := json.Unmarshal(data.Labels, s.labels); err != nil {
+			s.logger.Println("failed to unmarshal namespace labels", err)
+			return err
+		}
+	}
+	// enforcing reads settings concurrently, they are restored in place
+	s.settings.reset()
+	if data.Settings != nil {
+		if err := json.Unmarshal(data.Settings, s.settings); err != nil {
+			s.logger.Println("failed to unmarshal namespace settings", err)
+			return err
+		}
+	}
+	// enforcing reads the versions concurrently, they are restored in place
+	s.policySets.reset()
+	if data.PolicySets != nil {
+		if err := json.Unmarshal(data.PolicySets, s.policySets); err != nil {
+			s.logger.Println("failed to unmarshal policy set versions", err)
+			return err
+		}
+	}
+	s.idempotency = newIdempotencyRegistry()
+	if data.Idempotency != nil {
+		if err := json.Unmarshal(data.Idempotency, s.idempotency); err != nil {
+			s.logger.Println("failed to unmarshal idempotency results", err)
+			return err
+		}
+		s.idempotency.rebuild()
+	}
+	s.versions = newVersionRegistry()
+	if data.Versions != nil {
+		if err := json.Unmarshal(data.Versions, s.versions); err != nil {
+			s.logger.Println("failed to unmarshal versions", err)
+			return err
+		}
+	}
+	// the janitor reads the status concurrently, it is restored in place
+	s.readOnly.set(ReadOnlyStatus{})
+	if data.ReadOnly != nil {
+		if err := json.Unmarshal(data.ReadOnly, s.readOnly); err != nil {
+			s.logger.Println("failed to unmarshal read-only status", err)
+			return err
+		}
+	}
+	// the replication agent reads the position concurrently, it is restored
+	// in place
+	s.standby.set(StandbyPosition{})
+	if data.Standby != nil {
+		if err := json.Unmarshal(data.Standby, s.standby); err != nil {
+			s.logger.Println("failed to unmarshal standby position", err)
+			return err
+		}
+	}
 	if data.CredentialStore != nil {
 		s.authCredStore = auth.NewCredentialsStore()
 		err := s.authCredStore.Load(bytes.NewReader(data.CredentialStore))
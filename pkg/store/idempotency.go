@@ -0,0 +1,343 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package store
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"sort"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/casbin/casbin-mesh/proto/command"
+	"github.com/golang/protobuf/proto"
+	"github.com/hashicorp/raft"
+)
+
+// ErrIdempotencyKeyReused is returned when an idempotency key is sent again
+// with a different request.
+var ErrIdempotencyKeyReused = errors.New("idempotency key reused with a different request")
+
+const (
+	idempotencyKeyMeta     = "idempotency-key"
+	idempotencyTimeMeta    = "idempotency-time"
+	idempotencyRequestMeta = "idempotency-request"
+
+	// IdempotencyWindow is how long the result of a request sent with an
+	// idempotency key is retained.
+	IdempotencyWindow = 24 * time.Hour
+	// idempotencyMaxKeys bounds the number of retained results.
+	idempotencyMaxKeys = 10000
+)
+
+type idempotencyKey struct{}
+
+// idempotency carries the key of a request. A request may apply several
+// commands, each of them is deduplicated under its own sequence number.
+type idempotency struct {
+	key      string
+	request  string
+	seq      uint32
+	replayed uint32
+}
+
+// WithIdempotencyKey returns a copy of ctx whose writes are deduplicated
+// under key.
+func WithIdempotencyKey(ctx context.Context, key string) context.Context {
+	return context.WithValue(ctx, idempotencyKey{}, &idempotency{key: key})
+}
+
+// WithIdempotentRequest is like WithIdempotencyKey, but tells a retry from
+// another request sent with the same key by request, the request of the
+// client, instead of by the commands it applies. These hold values stamped
+// on each attempt, like the expiry of a TTL, which differ between retries.
+func WithIdempotentRequest(ctx context.Context, key string, request []byte) context.Context {
+	h := sha256.Sum256(request)
+	return context.WithValue(ctx, idempotencyKey{}, &idempotency{key: key, request: hex.EncodeToString(h[:])})
+}
+
+// Replayed reports whether a write in ctx returned the result of an earlier
+// request sent with the same idempotency key instead of being applied.
+func Replayed(ctx context.Context) bool {
+	i, ok := ctx.Value(idempotencyKey{}).(*idempotency)
+	return ok && atomic.LoadUint32(&i.replayed) == 1
+}
+
+func (i *idempotency) markReplayed() {
+	if i != nil {
+		atomic.StoreUint32(&i.replayed, 1)
+	}
+}
+
+// withIdempotency adds the idempotency key of ctx, if any, to the metadata of
+// the marshaled command cmd. Reads are not deduplicated.
+func withIdempotency(ctx context.Context, cmd []byte) ([]byte, *idempotency, error) {
+	i, ok := ctx.Value(idempotencyKey{}).(*idempotency)
+	if !ok {
+		return cmd, nil, nil
+	}
+	var c command.Command
+	if err := proto.Unmarshal(cmd, &c); err != nil {
+		return nil, nil, err
+	}
+	if !mutates(c.Type) {
+		return cmd, nil, nil
+	}
+	if c.Metadata == nil {
+		c.Metadata = make(map[string]string)
+	}
+	seq := atomic.AddUint32(&i.seq, 1)
+	c.Metadata[idempotencyKeyMeta] = i.key + "#" + strconv.FormatUint(uint64(seq), 10)
+	c.Metadata[idempotencyTimeMeta] = strconv.FormatInt(time.Now().UnixNano(), 10)
+	if i.request != "" {
+		c.Metadata[idempotencyRequestMeta] = i.request
+	}
+	b, err := proto.Marshal(&c)
+	return b, i, err
+}
+
+// idempotentResult is the retained result of a write. Version and Data hold
+// what the responses other than FSMResponse carry.
+type idempotentResult struct {
+	Key         string
+	Fingerprint string
+	Time        int64
+	Error       string          `json:",omitempty"`
+	Effected    bool            `json:",omitempty"`
+	Rules       [][]string      `json:",omitempty"`
+	Version     uint64          `json:",omitempty"`
+	Data        json.RawMessage `json:",omitempty"`
+	err         error
+}
+
+// replayable is implemented by the responses of the writes which may be
+// replayed for their idempotency key.
+type replayable interface {
+	wasReplayed() bool
+}
+
+// replayMark is embedded by the responses of the writes to tell a retained
+// result returned again.
+type replayMark struct {
+	replayed bool
+}
+
+func (m *replayMark) wasReplayed() bool {
+	return m.replayed
+}
+
+// replacedRules is the retained data of a ReplacePoliciesResponse.
+type replacedRules struct {
+	Added   []*command.PolicyRules `json:",omitempty"`
+	Removed []*command.PolicyRules `json:",omitempty"`
+}
+
+// newResponse returns a response of the type cmd is answered with, failed
+// with err.
+func newResponse(cmd *command.Command, err error) interface{} {
+	switch {
+	case cmd.Type == command.Type_COMMAND_TYPE_SET_TEMPLATE:
+		return &SetTemplateResponse{error: err}
+	case cmd.Type == command.Type_COMMAND_TYPE_TAG_VERSION:
+		return &TagVersionResponse{error: err}
+	case cmd.Type == command.Type_COMMAND_TYPE_ADD_POLICIES && cmd.Metadata[copyFromMeta] != "":
+		return &CopyPoliciesResponse{error: err}
+	case cmd.Type == command.Type_COMMAND_TYPE_REMOVE_POLICIES && cmd.Metadata[removeSubjectMeta] != "":
+		return &RemoveSubjectResponse{error: err}
+	case cmd.Type == command.Type_COMMAND_TYPE_UPDATE_POLICIES && cmd.Metadata[renameSubjectMeta] != "":
+		return &RenameSubjectResponse{error: err}
+	case cmd.Type == command.Type_COMMAND_TYPE_ROLLBACK_POLICIES && cmd.Metadata[replacePoliciesMeta] != "":
+		return &ReplacePoliciesResponse{error: err}
+	}
+	return &FSMResponse{error: err}
+}
+
+// retain records resp in r, it returns false for the responses which can't
+// be replayed.
+func (r *idempotentResult) retain(resp interface{}) bool {
+	var err error
+	var data interface{}
+	switch v := resp.(type) {
+	case *FSMResponse:
+		err, r.Effected, r.Rules = v.error, v.effected, v.effectedRules
+	case *SetTemplateResponse:
+		err, r.Version = v.error, v.version
+	case *TagVersionResponse:
+		err, r.Version = v.error, v.version
+	case *CopyPoliciesResponse:
+		err, data = v.error, v.copied
+	case *RemoveSubjectResponse:
+		err, data = v.error, v.removed
+	case *RenameSubjectResponse:
+		err, data = v.error, v.renamed
+	case *ReplacePoliciesResponse:
+		err, data = v.error, replacedRules{Added: v.added, Removed: v.removed}
+	default:
+		return false
+	}
+	if data != nil {
+		b, jerr := json.Marshal(data)
+		if jerr != nil {
+			return false
+		}
+		r.Data = b
+	}
+	r.err = err
+	if err != nil {
+		r.Error = err.Error()
+	}
+	return true
+}
+
+// response returns the retained result again, as the response of cmd.
+func (r *idempotentResult) response(cmd *command.Command) interface{} {
+	err := r.err
+	if err == nil && r.Error != "" {
+		err = errors.New(r.Error)
+	}
+	mark := replayMark{replayed: true}
+	resp := newResponse(cmd, err)
+	switch v := resp.(type) {
+	case *FSMResponse:
+		v.effected, v.effectedRules, v.replayMark = r.Effected, r.Rules, mark
+	case *SetTemplateResponse:
+		v.version, v.replayMark = r.Version, mark
+	case *TagVersionResponse:
+		v.version, v.replayMark = r.Version, mark
+	case *CopyPoliciesResponse:
+		v.replayMark = mark
+		r.decode(&v.copied)
+	case *RemoveSubjectResponse:
+		v.replayMark = mark
+		r.decode(&v.removed)
+	case *RenameSubjectResponse:
+		v.replayMark = mark
+		r.decode(&v.renamed)
+	case *ReplacePoliciesResponse:
+		var rules replacedRules
+		r.decode(&rules)
+		v.added, v.removed, v.replayMark = rules.Added, rules.Removed, mark
+	}
+	return resp
+}
+
+func (r *idempotentResult) decode(v interface{}) {
+	if len(r.Data) > 0 {
+		_ = json.Unmarshal(r.Data, v)
+	}
+}
+
+// idempotencyRegistry retains the results of writes sent with an
+// idempotency key. It is only accessed by the FSM.
+type idempotencyRegistry struct {
+	// Results are ordered by the time they were applied.
+	Results []*idempotentResult
+	byKey   map[string]*idempotentResult
+}
+
+func newIdempotencyRegistry() *idempotencyRegistry {
+	return &idempotencyRegistry{byKey: make(map[string]*idempotentResult)}
+}
+
+// rebuild indexes the results after they were restored from a snapshot.
+func (r *idempotencyRegistry) rebuild() {
+	r.byKey = make(map[string]*idempotentResult, len(r.Results))
+	for _, res := range r.Results {
+		r.byKey[res.Key] = res
+	}
+}
+
+// prune drops the results that fell out of the window at now.
+func (r *idempotencyRegistry) prune(now int64) {
+	n := 0
+	for n < len(r.Results) && (len(r.Results)-n > idempotencyMaxKeys || r.Results[n].Time < now-int64(IdempotencyWindow)) {
+		delete(r.byKey, r.Results[n].Key)
+		n++
+	}
+	r.Results = r.Results[n:]
+}
+
+func (r *idempotencyRegistry) add(res *idempotentResult) {
+	r.Results = append(r.Results, res)
+	r.byKey[res.Key] = res
+	r.prune(res.Time)
+}
+
+// unfingerprinted are the metadata stamped on each command sent, which
+// differ between the retries of a request.
+var unfingerprinted = map[string]bool{
+	idempotencyKeyMeta:  true,
+	idempotencyTimeMeta: true,
+	schemaVersionMeta:   true,
+	schemaIgnorableMeta: true,
+	standbyIndexMeta:    true,
+}
+
+// fingerprint identifies the request of cmd, the metadata included since they
+// select the variants of a command type. The request of the client stands
+// for the payload and the metadata when the command carries it.
+func fingerprint(cmd *command.Command) string {
+	h := sha256.New()
+	h.Write([]byte(strconv.Itoa(int(cmd.Type))))
+	h.Write([]byte{0})
+	h.Write([]byte(cmd.Namespace))
+	h.Write([]byte{0})
+	if r := cmd.Metadata[idempotencyRequestMeta]; r != "" {
+		h.Write([]byte(r))
+		return hex.EncodeToString(h.Sum(nil))
+	}
+	h.Write(cmd.Payload)
+	keys := make([]string, 0, len(cmd.Metadata))
+	for k := range cmd.Metadata {
+		if !unfingerprinted[k] {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		h.Write([]byte{0})
+		h.Write([]byte(k))
+		h.Write([]byte{0})
+		h.Write([]byte(cmd.Metadata[k]))
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// applyIdempotent applies cmd unless a write with the same key was applied
+// within the window, in which case its result is returned again.
+func (s *Store) applyIdempotent(l *raft.Log, cmd *command.Command, key string) interface{} {
+	now, _ := strconv.ParseInt(cmd.Metadata[idempotencyTimeMeta], 10, 64)
+	s.idempotency.prune(now)
+	fp := fingerprint(cmd)
+	if res, ok := s.idempotency.byKey[key]; ok {
+		if res.Fingerprint != fp {
+			return newResponse(cmd, ErrIdempotencyKeyReused)
+		}
+		return res.response(cmd)
+	}
+	resp := s.applyCommand(l, cmd)
+	res := &idempotentResult{Key: key, Fingerprint: fp, Time: now}
+	if res.retain(resp) {
+		s.idempotency.add(res)
+	}
+	return resp
+}
@@ -0,0 +1,91 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package store
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+)
+
+// indexWaitDelay is how often WaitForIndex checks the applied index.
+const indexWaitDelay = 5 * time.Millisecond
+
+type indexRecorderKey struct{}
+
+// IndexRecorder records the highest Raft index of the commands applied on
+// behalf of a request.
+type IndexRecorder struct {
+	index uint64
+}
+
+// WithIndexRecorder returns a copy of ctx that carries a new IndexRecorder.
+func WithIndexRecorder(ctx context.Context) (context.Context, *IndexRecorder) {
+	r := &IndexRecorder{}
+	return context.WithValue(ctx, indexRecorderKey{}, r), r
+}
+
+// Index returns the highest recorded index, or zero if nothing was applied.
+func (r *IndexRecorder) Index() uint64 {
+	return atomic.LoadUint64(&r.index)
+}
+
+func (r *IndexRecorder) record(index uint64) {
+	for {
+		cur := atomic.LoadUint64(&r.index)
+		if index <= cur || atomic.CompareAndSwapUint64(&r.index, cur, index) {
+			return
+		}
+	}
+}
+
+func recordIndex(ctx context.Context, index uint64) {
+	if r, ok := ctx.Value(indexRecorderKey{}).(*IndexRecorder); ok {
+		r.record(index)
+	}
+}
+
+// AppliedIndex returns the index of the last log entry applied to the FSM.
+func (s *Store) AppliedIndex() uint64 {
+	return s.raft.AppliedIndex()
+}
+
+// WaitForIndex blocks until the FSM has applied the log entry at idx. It gives
+// up after ApplyTimeout or once ctx is done, whichever is sooner.
+func (s *Store) WaitForIndex(ctx context.Context, idx uint64) error {
+	if s.raft.AppliedIndex() >= idx {
+		return nil
+	}
+	tck := time.NewTicker(indexWaitDelay)
+	defer tck.Stop()
+	tmr := time.NewTimer(s.ApplyTimeout)
+	defer tmr.Stop()
+
+	for {
+		select {
+		case <-tck.C:
+			if s.raft.AppliedIndex() >= idx {
+				return nil
+			}
+		case <-tmr.C:
+			return ErrIndexTimeout
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
@@ -0,0 +1,141 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package store
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// RequestClass is the class of a client request tracked while in flight.
+type RequestClass int
+
+const (
+	EnforceRequest RequestClass = iota
+	WriteRequest
+)
+
+// InFlight counts the requests of a class served by the node.
+type InFlight struct {
+	Count int `json:"count"`
+	// OldestAge is how long the oldest of them has been served for.
+	OldestAge time.Duration `json:"oldest_age"`
+}
+
+// InFlightStatus counts the Enforce and the write requests served by the
+// node.
+type InFlightStatus struct {
+	Enforce InFlight `json:"enforce"`
+	Write   InFlight `json:"write"`
+}
+
+// Drained tells whether the node serves no request anymore.
+func (s InFlightStatus) Drained() bool {
+	return s.Enforce.Count == 0 && s.Write.Count == 0
+}
+
+type inflightRequest struct {
+	class RequestClass
+	start time.Time
+}
+
+// inflightTracker holds the requests served by the node. It is not
+// replicated, each node tracks its own.
+type inflightTracker struct {
+	mu       sync.Mutex
+	next     uint64
+	requests map[uint64]inflightRequest
+	// idle is closed once no request is served anymore.
+	idle chan struct{}
+}
+
+func newInflightTracker() *inflightTracker {
+	idle := make(chan struct{})
+	close(idle)
+	return &inflightTracker{requests: make(map[uint64]inflightRequest), idle: idle}
+}
+
+func (t *inflightTracker) begin(class RequestClass) func() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if len(t.requests) == 0 {
+		t.idle = make(chan struct{})
+	}
+	id := t.next
+	t.next++
+	t.requests[id] = inflightRequest{class: class, start: time.Now()}
+	var once sync.Once
+	return func() {
+		once.Do(func() { t.end(id) })
+	}
+}
+
+func (t *inflightTracker) end(id uint64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.requests, id)
+	if len(t.requests) == 0 {
+		close(t.idle)
+	}
+}
+
+func (t *inflightTracker) status() InFlightStatus {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var out InFlightStatus
+	now := time.Now()
+	for _, r := range t.requests {
+		c := &out.Enforce
+		if r.class == WriteRequest {
+			c = &out.Write
+		}
+		c.Count++
+		if age := now.Sub(r.start); age > c.OldestAge {
+			c.OldestAge = age
+		}
+	}
+	return out
+}
+
+func (t *inflightTracker) idleChan() chan struct{} {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.idle
+}
+
+// BeginRequest tracks a request of class until the returned func is called.
+func (s *Store) BeginRequest(class RequestClass) (done func()) {
+	return s.inflight.begin(class)
+}
+
+// InFlight returns the requests served by the node.
+func (s *Store) InFlight() InFlightStatus {
+	return s.inflight.status()
+}
+
+// AwaitDrain waits until the node serves no request anymore, or ctx is
+// done. It is meant for a cordoned node, which is not sent new requests.
+func (s *Store) AwaitDrain(ctx context.Context) error {
+	select {
+	case <-s.inflight.idleChan():
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
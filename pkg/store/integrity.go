@@ -0,0 +1,248 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package store
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/casbin/casbin-mesh/pkg/adapter"
+	rlog "github.com/casbin/casbin-mesh/pkg/log"
+	"github.com/hashicorp/raft"
+)
+
+const (
+	// quarantineDir is the directory, under the Raft directory, corrupted
+	// state is moved to.
+	quarantineDir = "quarantine"
+	// snapshotsDir is the directory the file snapshot store keeps snapshots
+	// in.
+	snapshotsDir = "snapshots"
+	// repairFile marks a node whose state was quarantined, until it is
+	// removed from its cluster and joins it again.
+	repairFile = "repair_pending"
+
+	// RepairKey is the join metadata key of nodes whose state was
+	// quarantined, which the leader removes and joins again as non-voters.
+	RepairKey = "repair"
+)
+
+// ErrNoOtherVoter is returned by Quarantine when the node is the only voter
+// of its cluster, as far as its state tells: there is no leader to get the
+// state again from.
+var ErrNoOtherVoter = errors.New("no other voter to get the state again from")
+
+// stableKeys are the keys of the Raft stable store, which shares its database
+// with the Raft log. They hold the current term of the node and its vote in
+// it.
+var stableKeys = []string{"CurrentTerm", "LastVoteTerm", "LastVoteCand"}
+
+// IntegrityError reports the corruption of the state at Path.
+type IntegrityError struct {
+	Path string
+	Err  error
+}
+
+func (e *IntegrityError) Error() string {
+	return fmt.Sprintf("%s is corrupted: %s", e.Path, e.Err.Error())
+}
+
+// CheckIntegrity verifies the state of a node in raftDir before it opens:
+// the checksums of its snapshots and of its state database, and that its
+// Raft log has no gap, neither within itself nor after the latest snapshot.
+// It returns an IntegrityError naming what is corrupted.
+func CheckIntegrity(raftDir string) error {
+	if IsNewNode(raftDir) {
+		return nil
+	}
+	snapshots, err := raft.NewFileSnapshotStore(raftDir, retainSnapshotCount, ioutil.Discard)
+	if err != nil {
+		return &IntegrityError{Path: filepath.Join(raftDir, snapshotsDir), Err: err}
+	}
+	metas, err := snapshots.List()
+	if err != nil {
+		return &IntegrityError{Path: filepath.Join(raftDir, snapshotsDir), Err: err}
+	}
+	for _, meta := range metas {
+		// the CRC of the snapshot is checked on open
+		_, rc, err := snapshots.Open(meta.ID)
+		if err != nil {
+			return &IntegrityError{Path: filepath.Join(raftDir, snapshotsDir, meta.ID), Err: err}
+		}
+		rc.Close()
+	}
+
+	logPath := filepath.Join(raftDir, raftDBPath)
+	l, err := rlog.NewLog(logPath)
+	if err != nil {
+		return &IntegrityError{Path: logPath, Err: err}
+	}
+	defer l.Close()
+	first, last, err := l.Indexes()
+	if err != nil {
+		return &IntegrityError{Path: logPath, Err: err}
+	}
+	var entry raft.Log
+	for i := first; i != 0 && i <= last; i++ {
+		if err := l.GetLog(i, &entry); err != nil {
+			return &IntegrityError{Path: logPath, Err: fmt.Errorf("entry %d: %s", i, err.Error())}
+		}
+		if entry.Index != i {
+			return &IntegrityError{Path: logPath, Err: fmt.Errorf("entry %d holds index %d", i, entry.Index)}
+		}
+	}
+	var snapshotIndex uint64
+	if len(metas) > 0 {
+		snapshotIndex = metas[0].Index
+	}
+	if first > snapshotIndex+1 {
+		return &IntegrityError{Path: logPath, Err: fmt.Errorf("log starts at %d, after the latest snapshot at %d", first, snapshotIndex)}
+	}
+
+	statePath := filepath.Join(raftDir, stateDBPath)
+	state, err := adapter.NewBadgerStore(statePath)
+	if err != nil {
+		return &IntegrityError{Path: statePath, Err: err}
+	}
+	defer state.Close()
+	if err := state.VerifyChecksum(); err != nil {
+		return &IntegrityError{Path: statePath, Err: err}
+	}
+	return nil
+}
+
+// Quarantine moves the state of the node in raftDir aside, under a
+// quarantine directory, and returns where it was moved. The quarantined state
+// is kept for inspection.
+//
+// The node then has an empty log, and must not vote with it: a candidate
+// missing committed entries could win its vote. It is marked as repairing
+// until FinishRepair, and must join its cluster with RepairKey set before it
+// opens, so that the leader removes it and adds it again as a non-voter,
+// promoted once it caught up.
+//
+// The term of the node and its vote in it are kept. If they can't be read,
+// the node must be removed from its cluster and join it again with an empty
+// data directory instead. A node which is the only voter of its cluster is
+// refused with ErrNoOtherVoter.
+func Quarantine(raftDir string) (string, error) {
+	logPath := filepath.Join(raftDir, raftDBPath)
+	stable, voters, err := readRaftState(raftDir)
+	if err != nil {
+		return "", fmt.Errorf("%s: %s, remove the node from its cluster and join it again with an empty data directory", logPath, err.Error())
+	}
+	if voters < 2 {
+		return "", ErrNoOtherVoter
+	}
+
+	dir := filepath.Join(raftDir, quarantineDir, time.Now().UTC().Format("20060102T150405Z"))
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+	for _, name := range []string{raftDBPath, stateDBPath, snapshotsDir} {
+		err := os.Rename(filepath.Join(raftDir, name), filepath.Join(dir, name))
+		if err != nil && !os.IsNotExist(err) {
+			return "", err
+		}
+	}
+
+	l, err := rlog.NewLog(logPath)
+	if err != nil {
+		return "", err
+	}
+	defer l.Close()
+	for k, v := range stable {
+		if err := l.Set([]byte(k), v); err != nil {
+			return "", err
+		}
+	}
+	if err := ioutil.WriteFile(filepath.Join(raftDir, repairFile), []byte(dir+"\n"), 0644); err != nil {
+		return "", err
+	}
+	return dir, nil
+}
+
+// RepairPending returns whether the state of the node in raftDir was
+// quarantined, and the node has yet to join its cluster again.
+func RepairPending(raftDir string) bool {
+	return pathExists(filepath.Join(raftDir, repairFile))
+}
+
+// FinishRepair records that the node in raftDir joined its cluster again
+// after its state was quarantined.
+func FinishRepair(raftDir string) error {
+	err := os.Remove(filepath.Join(raftDir, repairFile))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// readRaftState returns the stable store of the node in raftDir, and the
+// number of voters of the latest configuration its snapshots and log hold.
+// The corrupted entries of the log are skipped.
+func readRaftState(raftDir string) (map[string][]byte, int, error) {
+	l, err := rlog.NewLog(filepath.Join(raftDir, raftDBPath))
+	if err != nil {
+		return nil, 0, err
+	}
+	defer l.Close()
+	stable := make(map[string][]byte)
+	for _, k := range stableKeys {
+		v, err := l.Get([]byte(k))
+		if err != nil {
+			// a node which never voted has no vote
+			if errors.Is(err, rlog.ErrKeyNotFound) {
+				continue
+			}
+			return nil, 0, err
+		}
+		stable[k] = v
+	}
+
+	var configuration raft.Configuration
+	var index uint64
+	if snapshots, err := raft.NewFileSnapshotStore(raftDir, retainSnapshotCount, ioutil.Discard); err == nil {
+		if metas, err := snapshots.List(); err == nil && len(metas) > 0 {
+			configuration, index = metas[0].Configuration, metas[0].ConfigurationIndex
+		}
+	}
+	first, last, err := l.Indexes()
+	if err != nil {
+		return nil, 0, err
+	}
+	var entry raft.Log
+	for i := last; i != 0 && i >= first && i > index; i-- {
+		if err := l.GetLog(i, &entry); err != nil || entry.Type != raft.LogConfiguration {
+			continue
+		}
+		configuration = raft.DecodeConfiguration(entry.Data)
+		break
+	}
+	var voters int
+	for _, server := range configuration.Servers {
+		if server.Suffrage == raft.Voter {
+			voters++
+		}
+	}
+	return stable, voters, nil
+}
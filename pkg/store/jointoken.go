@@ -0,0 +1,171 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package store
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/raft"
+)
+
+// DefaultJoinTokenTTL is how long join tokens remain valid by default.
+const DefaultJoinTokenTTL = time.Hour
+
+// ErrJoinToken is returned when a node joins without a valid join token.
+var ErrJoinToken = errors.New("join token missing or invalid")
+
+// JoinToken is a single-use token letting a node join the cluster.
+type JoinToken struct {
+	Token   string    `json:"token"`
+	Expires time.Time `json:"expires"`
+}
+
+// joinTokens holds the single-use join tokens created by the leader, by
+// their hash, until they expire or are used.
+type joinTokens struct {
+	mu     sync.Mutex
+	tokens map[string]time.Time
+}
+
+func newJoinTokens() *joinTokens {
+	return &joinTokens{tokens: make(map[string]time.Time)}
+}
+
+func hashJoinToken(token string) string {
+	h := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(h[:])
+}
+
+// create returns a new token valid until expires.
+func (j *joinTokens) create(expires time.Time) (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	token := hex.EncodeToString(b)
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	now := time.Now()
+	for h, exp := range j.tokens {
+		if now.After(exp) {
+			delete(j.tokens, h)
+		}
+	}
+	j.tokens[hashJoinToken(token)] = expires
+	return token, nil
+}
+
+// take removes token if valid, and returns a func putting it back unless
+// it was used.
+func (j *joinTokens) take(token string) (func(used bool), bool) {
+	h := hashJoinToken(token)
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	exp, ok := j.tokens[h]
+	if !ok {
+		return nil, false
+	}
+	delete(j.tokens, h)
+	if time.Now().After(exp) {
+		return nil, false
+	}
+	return func(used bool) {
+		if used {
+			return
+		}
+		j.mu.Lock()
+		defer j.mu.Unlock()
+		j.tokens[h] = exp
+	}, true
+}
+
+// CreateJoinToken returns a single-use join token valid for ttl, or
+// DefaultJoinTokenTTL if 0. Tokens are held by the leader, and lost if it
+// loses leadership.
+func (s *Store) CreateJoinToken(ttl time.Duration) (JoinToken, error) {
+	if s.raft.State() != raft.Leader {
+		return JoinToken{}, ErrNotLeader
+	}
+	if ttl <= 0 {
+		ttl = DefaultJoinTokenTTL
+	}
+	expires := time.Now().Add(ttl)
+	token, err := s.joinTokens.create(expires)
+	if err != nil {
+		return JoinToken{}, err
+	}
+	s.logger.Printf("join token created, valid until %s", expires.Format(time.RFC3339))
+	return JoinToken{Token: token, Expires: expires}, nil
+}
+
+// JoinWithToken joins the node like Join, once token is checked. Unless no
+// join token is required, token must be the shared JoinToken or a
+// single-use token of CreateJoinToken, which the join uses up. Members
+// joining again with the same ID, address and metadata, which changes
+// nothing, need no token.
+func (s *Store) JoinWithToken(id, addr string, voter bool, metadata map[string]string, token string) error {
+	if !s.RequireJoinToken && s.JoinToken == "" {
+		return s.Join(id, addr, voter, metadata)
+	}
+	if s.rejoinsUnchanged(id, addr, metadata) {
+		return s.Join(id, addr, voter, metadata)
+	}
+	if s.JoinToken != "" && subtle.ConstantTimeCompare([]byte(token), []byte(s.JoinToken)) == 1 {
+		return s.Join(id, addr, voter, metadata)
+	}
+	release, ok := s.joinTokens.take(token)
+	if !ok {
+		s.logger.Printf("refusing node %s at %s, %s", id, addr, ErrJoinToken.Error())
+		return ErrJoinToken
+	}
+	err := s.Join(id, addr, voter, metadata)
+	release(err == nil)
+	return err
+}
+
+// rejoinsUnchanged returns whether a node is member of the cluster with both
+// id and addr, and already has metadata, so that joining again is a no-op.
+func (s *Store) rejoinsUnchanged(id, addr string, metadata map[string]string) bool {
+	f := s.raft.GetConfiguration()
+	if f.Error() != nil {
+		return false
+	}
+	member := false
+	for _, srv := range f.Configuration().Servers {
+		if srv.ID == raft.ServerID(id) && srv.Address == raft.ServerAddress(addr) {
+			member = true
+		}
+	}
+	if !member {
+		return false
+	}
+	s.metaMu.RLock()
+	defer s.metaMu.RUnlock()
+	for k, v := range metadata {
+		if s.meta[id][k] != v {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,157 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"sync"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/hashicorp/raft"
+
+	"github.com/casbin/casbin-mesh/pkg/search"
+	"github.com/casbin/casbin-mesh/proto/command"
+)
+
+// namespaceLabelsMeta marks the CREATE_NAMESPACE entries setting the labels
+// of an existing namespace, JSON encoded, instead of creating it.
+const namespaceLabelsMeta = "namespace-labels"
+
+// labelRegistry holds the labels of the namespaces. It is changed by the FSM
+// and read by the enforcement endpoints selecting namespaces by label.
+type labelRegistry struct {
+	mu         sync.RWMutex
+	namespaces map[string]map[string]string
+}
+
+func newLabelRegistry() *labelRegistry {
+	return &labelRegistry{namespaces: make(map[string]map[string]string)}
+}
+
+func (r *labelRegistry) get(ns string) map[string]string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	labels := make(map[string]string, len(r.namespaces[ns]))
+	for k, v := range r.namespaces[ns] {
+		labels[k] = v
+	}
+	return labels
+}
+
+// set replaces the labels of ns, empty labels remove them.
+func (r *labelRegistry) set(ns string, labels map[string]string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(labels) == 0 {
+		delete(r.namespaces, ns)
+		return
+	}
+	r.namespaces[ns] = labels
+}
+
+// matching returns the labelled namespaces meeting selector, sorted.
+func (r *labelRegistry) matching(selector search.Selector) []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	var out []string
+	for ns, labels := range r.namespaces {
+		if selector.Matches(labels) {
+			out = append(out, ns)
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
+func (r *labelRegistry) reset() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.namespaces = make(map[string]map[string]string)
+}
+
+func (r *labelRegistry) MarshalJSON() ([]byte, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return json.Marshal(r.namespaces)
+}
+
+func (r *labelRegistry) UnmarshalJSON(data []byte) error {
+	namespaces := make(map[string]map[string]string)
+	if err := json.Unmarshal(data, &namespaces); err != nil {
+		return err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.namespaces = namespaces
+	return nil
+}
+
+func (s *Store) applySetNamespaceLabels(l *raft.Log, cmd *command.Command) interface{} {
+	var labels map[string]string
+	if err := json.Unmarshal([]byte(cmd.Metadata[namespaceLabelsMeta]), &labels); err != nil {
+		return &FSMResponse{error: UnmarshalFailed}
+	}
+	if _, ok := s.enforcers.Load(cmd.Namespace); !ok {
+		return &FSMResponse{error: NamespaceNotExist}
+	}
+	s.labels.set(cmd.Namespace, labels)
+	return &FSMResponse{}
+}
+
+// SetNamespaceLabels replaces the labels of a namespace, which select it
+// when enforcing a request against several namespaces. Empty labels remove
+// them.
+func (s *Store) SetNamespaceLabels(ctx context.Context, ns string, labels map[string]string) error {
+	b, err := json.Marshal(labels)
+	if err != nil {
+		return err
+	}
+	cmd, err := proto.Marshal(&command.Command{
+		Type:      command.Type_COMMAND_TYPE_CREATE_NAMESPACE,
+		Namespace: ns,
+		Metadata:  map[string]string{namespaceLabelsMeta: string(b)},
+	})
+	if err != nil {
+		return err
+	}
+	f, err := s.apply(ctx, cmd)
+	if err != nil {
+		return err
+	}
+	return f.Response().(*FSMResponse).error
+}
+
+// NamespaceLabels returns the labels of a namespace as applied on this node.
+func (s *Store) NamespaceLabels(ns string) (map[string]string, error) {
+	if _, ok := s.enforcers.Load(ns); !ok {
+		return nil, NamespaceNotExist
+	}
+	return s.labels.get(ns), nil
+}
+
+// MatchNamespaces returns the namespaces whose labels meet selector, a label
+// selector like "env=prod,team", as applied on this node.
+func (s *Store) MatchNamespaces(selector string) ([]string, error) {
+	sel, err := search.ParseSelector(selector)
+	if err != nil {
+		return nil, err
+	}
+	return s.labels.matching(sel), nil
+}
@@ -0,0 +1,83 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package store
+
+import (
+	"sync"
+	"time"
+)
+
+// latencyWindow is the window latencies are averaged over.
+const latencyWindow = time.Second
+
+// latencyTracker averages latencies over fixed windows. It reports the mean
+// of the last complete window, so that a spike fades away once the window
+// after it passed, even with no new samples.
+type latencyTracker struct {
+	mu    sync.Mutex
+	start time.Time
+	sum   time.Duration
+	n     int64
+	last  time.Duration
+}
+
+func newLatencyTracker() *latencyTracker {
+	return &latencyTracker{start: time.Now()}
+}
+
+func (t *latencyTracker) observe(d time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.roll(time.Now())
+	t.sum += d
+	t.n++
+}
+
+func (t *latencyTracker) value() time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.roll(time.Now())
+	return t.last
+}
+
+// roll closes the current window once it is over. The last window is empty
+// if more than a window passed since.
+func (t *latencyTracker) roll(now time.Time) {
+	elapsed := now.Sub(t.start)
+	if elapsed < latencyWindow {
+		return
+	}
+	t.last = 0
+	if elapsed < 2*latencyWindow && t.n > 0 {
+		t.last = t.sum / time.Duration(t.n)
+	}
+	t.start, t.sum, t.n = now, 0, 0
+}
+
+// ApplyLatency returns the mean time the commands applied through this node
+// took to be committed and applied over the last second.
+func (s *Store) ApplyLatency() time.Duration {
+	return s.applyLatency.value()
+}
+
+// FSMWait returns the mean time the entries appended by this node, as the
+// leader, waited before the FSM applied them over the last second. It grows
+// when the FSM falls behind, e.g. while it is blocked by a snapshot.
+func (s *Store) FSMWait() time.Duration {
+	return s.fsmWait.value()
+}
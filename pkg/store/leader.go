@@ -0,0 +1,153 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package store
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/raft"
+)
+
+// leaderHistorySize bounds the number of former leaderships remembered.
+const leaderHistorySize = 10
+
+// Leadership is a period a node held leadership for, as observed by this
+// node. Until is zero for the current leadership.
+type Leadership struct {
+	NodeID string    `json:"node_id"`
+	Addr   string    `json:"addr"`
+	Since  time.Time `json:"since"`
+	Until  time.Time `json:"until,omitempty"`
+}
+
+// LeaderInfo describes the current leader and the former ones, most recent
+// first.
+type LeaderInfo struct {
+	Leadership
+	Term    uint64       `json:"term"`
+	History []Leadership `json:"history"`
+}
+
+// leaderTracker records the leadership changes observed by the node.
+type leaderTracker struct {
+	mu      sync.Mutex
+	current Leadership
+	history []Leadership
+
+	observations chan raft.Observation
+	observer     *raft.Observer
+	done         chan struct{}
+}
+
+func newLeaderTracker() *leaderTracker {
+	return &leaderTracker{}
+}
+
+// start follows the leadership changes of ra, starting from its current
+// leader.
+func (t *leaderTracker) start(ra *raft.Raft) {
+	t.observe(string(ra.Leader()), time.Now())
+	t.observations = make(chan raft.Observation, 16)
+	t.done = make(chan struct{})
+	t.observer = raft.NewObserver(t.observations, false, func(o *raft.Observation) bool {
+		_, ok := o.Data.(raft.LeaderObservation)
+		return ok
+	})
+	ra.RegisterObserver(t.observer)
+	go func() {
+		for {
+			select {
+			case o := <-t.observations:
+				t.observe(string(o.Data.(raft.LeaderObservation).Leader), time.Now())
+			case <-t.done:
+				return
+			}
+		}
+	}()
+}
+
+func (t *leaderTracker) stop(ra *raft.Raft) {
+	if t.observer == nil {
+		return
+	}
+	ra.DeregisterObserver(t.observer)
+	close(t.done)
+	t.observer = nil
+}
+
+func (t *leaderTracker) observe(addr string, at time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if addr == t.current.Addr && !t.current.Since.IsZero() {
+		return
+	}
+	if t.current.Addr != "" {
+		former := t.current
+		former.Until = at
+		t.history = append([]Leadership{former}, t.history...)
+		if len(t.history) > leaderHistorySize {
+			t.history = t.history[:leaderHistorySize]
+		}
+	}
+	t.current = Leadership{Addr: addr, Since: at}
+}
+
+func (t *leaderTracker) get() (Leadership, []Leadership) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.current, append([]Leadership{}, t.history...)
+}
+
+// Leader returns the current leader, when this node observed it taking
+// leadership, and the former leaders. Nodes no longer in the cluster have no
+// ID.
+func (s *Store) Leader() (LeaderInfo, error) {
+	f := s.raft.GetConfiguration()
+	if err := f.Error(); err != nil {
+		return LeaderInfo{}, err
+	}
+	ids := make(map[string]string)
+	for _, srv := range f.Configuration().Servers {
+		ids[string(srv.Address)] = string(srv.ID)
+	}
+	current, history := s.leaders.get()
+	current.NodeID = ids[current.Addr]
+	for i := range history {
+		history[i].NodeID = ids[history[i].Addr]
+	}
+	info := LeaderInfo{Leadership: current, History: history}
+	fmt.Sscan(s.raft.Stats()["term"], &info.Term)
+	return info, nil
+}
+
+// StepDown hands leadership over like TransferLeadership, unless this node
+// has held leadership for less than StepDownCooldown, so that leadership
+// does not bounce between nodes.
+func (s *Store) StepDown(id string) error {
+	if s.raft.State() != raft.Leader {
+		return ErrNotLeader
+	}
+	current, _ := s.leaders.get()
+	if held := time.Since(current.Since); current.Addr == s.LeaderAddr() && held < s.StepDownCooldown {
+		return fmt.Errorf("%w, leader for %s, retry in %s", ErrStepDownCooldown,
+			held.Round(time.Second), (s.StepDownCooldown - held).Round(time.Second))
+	}
+	return s.TransferLeadership(id)
+}
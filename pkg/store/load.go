@@ -0,0 +1,147 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package store
+
+import (
+	"strconv"
+	"sync"
+	"time"
+)
+
+// loadWindow is the window the load signals are measured over, long enough
+// for autoscalers not to flap on bursts.
+const loadWindow = 10 * time.Second
+
+// loadBuckets are the upper bounds of the latency histogram, doubling from
+// 50µs to about 26s.
+var loadBuckets = func() []time.Duration {
+	b := make([]time.Duration, 20)
+	for i := range b {
+		b[i] = 50 * time.Microsecond << uint(i)
+	}
+	return b
+}()
+
+// loadTracker counts requests and their latencies over fixed windows, and
+// reports the rate and the 99th percentile of the last complete window. The
+// percentile is the upper bound of its histogram bucket.
+type loadTracker struct {
+	mu     sync.Mutex
+	start  time.Time
+	counts []int64
+	n      int64
+	rate   float64
+	p99    time.Duration
+}
+
+func newLoadTracker() *loadTracker {
+	return &loadTracker{start: time.Now(), counts: make([]int64, len(loadBuckets)+1)}
+}
+
+func (t *loadTracker) observe(d time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.roll(time.Now())
+	i := 0
+	for i < len(loadBuckets) && d > loadBuckets[i] {
+		i++
+	}
+	t.counts[i]++
+	t.n++
+}
+
+// value returns the rate per second and the 99th percentile latency of the
+// last window.
+func (t *loadTracker) value() (float64, time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.roll(time.Now())
+	return t.rate, t.p99
+}
+
+// roll closes the current window once it is over. The last window is empty
+// if more than a window passed since.
+func (t *loadTracker) roll(now time.Time) {
+	elapsed := now.Sub(t.start)
+	if elapsed < loadWindow {
+		return
+	}
+	t.rate, t.p99 = 0, 0
+	if elapsed < 2*loadWindow && t.n > 0 {
+		t.rate = float64(t.n) / elapsed.Seconds()
+		rank := t.n - t.n/100
+		var seen int64
+		for i, c := range t.counts {
+			if seen += c; seen >= rank {
+				t.p99 = time.Duration(1<<63 - 1)
+				if i < len(loadBuckets) {
+					t.p99 = loadBuckets[i]
+				}
+				break
+			}
+		}
+	}
+	t.start, t.n = now, 0
+	for i := range t.counts {
+		t.counts[i] = 0
+	}
+}
+
+// LoadSignals are the load of a node, in units fit for autoscalers.
+type LoadSignals struct {
+	// EnforceRate is the enforce requests served per second.
+	EnforceRate float64 `json:"enforce_qps"`
+	// EnforceP99 is the 99th percentile of the time enforce requests took,
+	// in milliseconds.
+	EnforceP99 float64 `json:"enforce_p99_ms"`
+	// ApplyBacklog is the number of committed entries the node did not
+	// apply yet.
+	ApplyBacklog uint64 `json:"apply_backlog"`
+	// ApplyLatency and FSMWait are those of ApplyLatency and FSMWait, in
+	// milliseconds.
+	ApplyLatency float64 `json:"apply_latency_ms"`
+	FSMWait      float64 `json:"fsm_wait_ms"`
+	// Window is the window the rates and latencies are measured over, in
+	// seconds.
+	Window float64 `json:"window_seconds"`
+}
+
+// LoadSignals returns the load of this node.
+func (s *Store) LoadSignals() LoadSignals {
+	rate, p99 := s.enforceLoad.value()
+	out := LoadSignals{
+		EnforceRate:  rate,
+		EnforceP99:   milliseconds(p99),
+		ApplyLatency: milliseconds(s.ApplyLatency()),
+		FSMWait:      milliseconds(s.FSMWait()),
+		Window:       loadWindow.Seconds(),
+	}
+	if s.raft != nil {
+		stats := s.raft.Stats()
+		commit, _ := strconv.ParseUint(stats["commit_index"], 10, 64)
+		applied, _ := strconv.ParseUint(stats["applied_index"], 10, 64)
+		if commit > applied {
+			out.ApplyBacklog = commit - applied
+		}
+	}
+	return out
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
@@ -21,7 +21,6 @@ import (
 	"context"
 	"github.com/casbin/casbin-mesh/proto/command"
 	"github.com/golang/protobuf/proto"
-	"github.com/hashicorp/raft"
 )
 
 type ListNamespacesResponse struct {
@@ -34,12 +33,9 @@ func (s *Store) ListNamespace(ctx context.Context) ([]string, error) {
 	if err != nil {
 		return nil, err
 	}
-	f := s.raft.Apply(cmd, s.ApplyTimeout)
-	if e := f.(raft.Future); e.Error() != nil {
-		if e.Error() == raft.ErrNotLeader {
-			return nil, ErrNotLeader
-		}
-		return nil, e.Error()
+	f, err := s.apply(ctx, cmd)
+	if err != nil {
+		return nil, err
 	}
 	r := f.Response().(*ListNamespacesResponse)
 	return r.namespace, r.error
@@ -63,17 +59,45 @@ func (s *Store) ListPolicies(ctx context.Context, namespace, cursor string, skip
 	if err != nil {
 		return nil, err
 	}
-	f := s.raft.Apply(cmd, s.ApplyTimeout)
-	if e := f.(raft.Future); e.Error() != nil {
-		if e.Error() == raft.ErrNotLeader {
-			return nil, ErrNotLeader
-		}
-		return nil, e.Error()
+	f, err := s.apply(ctx, cmd)
+	if err != nil {
+		return nil, err
 	}
 	r := f.Response().(*ListPoliciesResponse)
 	return r.policies, r.error
 }
 
+type PagePoliciesResponse struct {
+	lines [][]string
+	next  string
+	error
+}
+
+// PagePolicies returns up to limit rules of a namespace whose stored key has
+// prefix, in stored key order, starting after the key after. The rules are
+// prefixed by their policy type, e.g. ["g", "alice", "admin"]. next is the
+// key of the last rule of the page, it is empty on the last page.
+func (s *Store) PagePolicies(ctx context.Context, namespace, prefix, after string, limit int64) (lines [][]string, next string, err error) {
+	payload, err := proto.Marshal(&command.PagePoliciesPayload{Prefix: prefix, After: after, Limit: limit})
+	if err != nil {
+		return nil, "", err
+	}
+	cmd, err := proto.Marshal(&command.Command{
+		Type:      command.Type_COMMAND_TYPE_PAGE_POLICIES,
+		Namespace: namespace,
+		Payload:   payload,
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	f, err := s.apply(ctx, cmd)
+	if err != nil {
+		return nil, "", err
+	}
+	r := f.Response().(*PagePoliciesResponse)
+	return r.lines, r.next, r.error
+}
+
 type PrintModelResponse struct {
 	model string
 	error
@@ -87,13 +111,35 @@ func (s *Store) PrintModel(ctx context.Context, namespace string) (string, error
 	if err != nil {
 		return "", err
 	}
-	f := s.raft.Apply(cmd, s.ApplyTimeout)
-	if e := f.(raft.Future); e.Error() != nil {
-		if e.Error() == raft.ErrNotLeader {
-			return "", ErrNotLeader
-		}
-		return "", e.Error()
+	f, err := s.apply(ctx, cmd)
+	if err != nil {
+		return "", err
 	}
 	r := f.Response().(*PrintModelResponse)
 	return r.model, r.error
 }
+
+type NamespaceSnapshotResponse struct {
+	model    string
+	policies []*command.PolicyRules
+	index    uint64
+	error
+}
+
+// NamespaceSnapshot returns the model and policies of a namespace, along with the
+// Raft index they were read at.
+func (s *Store) NamespaceSnapshot(ctx context.Context, namespace string) (string, []*command.PolicyRules, uint64, error) {
+	cmd, err := proto.Marshal(&command.Command{
+		Type:      command.Type_COMMAND_TYPE_SNAPSHOT,
+		Namespace: namespace,
+	})
+	if err != nil {
+		return "", nil, 0, err
+	}
+	f, err := s.apply(ctx, cmd)
+	if err != nil {
+		return "", nil, 0, err
+	}
+	r := f.Response().(*NamespaceSnapshotResponse)
+	return r.model, r.policies, r.index, r.error
+}
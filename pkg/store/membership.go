@@ -0,0 +1,97 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package store
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/raft"
+)
+
+// membershipGuard serializes the membership changes made by the leader, and
+// remembers when the last one was made.
+type membershipGuard struct {
+	mu   sync.Mutex
+	last time.Time
+}
+
+// changeMembership runs change, which adds node add, as a voter if voter is
+// set, and removes node remove, either being empty if the change doesn't.
+// The change is refused if it comes less than MembershipStabilization after
+// the previous one, or if it would break quorum.
+func (s *Store) changeMembership(add string, voter bool, remove string, change func() error) error {
+	g := s.membership
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if wait := s.MembershipStabilization - time.Since(g.last); !g.last.IsZero() && wait > 0 {
+		return fmt.Errorf("%w, retry in %s", ErrMembershipStabilizing, wait.Round(time.Second))
+	}
+	if err := s.checkQuorum(add, voter, remove); err != nil {
+		return err
+	}
+	if err := change(); err != nil {
+		return err
+	}
+	g.last = time.Now()
+	return nil
+}
+
+// checkQuorum returns an error wrapping ErrQuorumAtRisk if, once add joined
+// and remove left, fewer than a quorum of the voters would be reachable, or
+// voters would be removed below MinQuorum. The node added is reachable, as
+// it asked to join or caught up.
+func (s *Store) checkQuorum(add string, voter bool, remove string) error {
+	f := s.raft.GetConfiguration()
+	if err := f.Error(); err != nil {
+		return err
+	}
+	servers := f.Configuration().Servers
+	voters := make(map[raft.ServerID]raft.Server)
+	for _, srv := range servers {
+		if srv.Suffrage == raft.Voter {
+			voters[srv.ID] = srv
+		}
+	}
+	before := len(voters)
+	delete(voters, raft.ServerID(remove))
+	if add != "" && voter {
+		voters[raft.ServerID(add)] = raft.Server{ID: raft.ServerID(add)}
+	}
+	if n := len(voters); n < before && s.MinQuorum > 0 && n < s.MinQuorum {
+		return fmt.Errorf("%w, %d voters would remain, fewer than the minimum of %d", ErrQuorumAtRisk, n, s.MinQuorum)
+	}
+
+	current, _ := s.leaders.get()
+	last, now := s.raft.LastIndex(), time.Now()
+	reachable := 0
+	for id, srv := range voters {
+		if id == raft.ServerID(add) || id == raft.ServerID(s.raftID) {
+			reachable++
+			continue
+		}
+		if p := s.replication.progress(srv, last, current.Since, now); !p.LastContact.IsZero() && now.Sub(p.LastContact) < contactTimeout {
+			reachable++
+		}
+	}
+	if quorum := len(voters)/2 + 1; reachable < quorum {
+		return fmt.Errorf("%w, %d of the %d voters would be reachable, %d needed", ErrQuorumAtRisk, reachable, len(voters), quorum)
+	}
+	return nil
+}
@@ -0,0 +1,37 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package store
+
+import (
+	rlog "github.com/casbin/casbin-mesh/pkg/log"
+	"github.com/hashicorp/raft"
+)
+
+// MemoryStorage holds the Raft log, the stable store and the snapshots of a
+// node in memory, for tests. It outlives the store, so that a store opened
+// again on it restarts from the state of the previous one. The state of the
+// FSM is rebuilt from them on each open.
+type MemoryStorage struct {
+	log       *rlog.Log
+	snapshots *raft.InmemSnapshotStore
+}
+
+// NewMemoryStorage returns an empty MemoryStorage.
+func NewMemoryStorage() *MemoryStorage {
+	return &MemoryStorage{log: rlog.NewInmemLog(), snapshots: raft.NewInmemSnapshotStore()}
+}
@@ -0,0 +1,173 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package store
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// DataFormat is the version of the layout of the data directory this
+// binary reads. Bump it along with migrations when changing the layout.
+const DataFormat = 1
+
+// formatFile records the DataFormat of a data directory.
+const formatFile = "format.json"
+
+var (
+	// ErrDowngrade is returned for data directories written by a newer
+	// binary, whose layout this binary does not know.
+	ErrDowngrade = errors.New("data directory written by a newer version")
+	// ErrMigrationNeeded is returned when opening a data directory of an
+	// older format without AutoMigrate.
+	ErrMigrationNeeded = errors.New("data directory needs to be migrated")
+)
+
+// DataFormatInfo is the content of the format file of a data directory.
+type DataFormatInfo struct {
+	Format int `json:"format"`
+	// Version is the version of the binary which wrote the format.
+	Version string `json:"version"`
+}
+
+// Migration upgrades a data directory from a format to the next.
+type Migration struct {
+	From        int
+	To          int
+	Description string
+	apply       func(dir string) error
+}
+
+// migrations are the steps from each format to the next, in order.
+var migrations = []Migration{
+	{
+		From: 0, To: 1,
+		Description: "record the format of the data directory",
+		// the layout is unchanged, the format file is written once done
+		apply: func(dir string) error { return nil },
+	},
+}
+
+// ReadDataFormat returns the format of the data directory raftDir. Nodes
+// created before formats were recorded are at format 0, new nodes at
+// DataFormat.
+func ReadDataFormat(raftDir string) (DataFormatInfo, error) {
+	b, err := ioutil.ReadFile(filepath.Join(raftDir, formatFile))
+	if os.IsNotExist(err) {
+		if IsNewNode(raftDir) {
+			return DataFormatInfo{Format: DataFormat, Version: Version}, nil
+		}
+		return DataFormatInfo{}, nil
+	}
+	if err != nil {
+		return DataFormatInfo{}, err
+	}
+	var info DataFormatInfo
+	if err := json.Unmarshal(b, &info); err != nil {
+		return DataFormatInfo{}, fmt.Errorf("%s: %s", formatFile, err.Error())
+	}
+	return info, nil
+}
+
+func writeDataFormat(raftDir string, format int) error {
+	b, err := json.Marshal(DataFormatInfo{Format: format, Version: Version})
+	if err != nil {
+		return err
+	}
+	tmp := filepath.Join(raftDir, formatFile+".tmp")
+	if err := ioutil.WriteFile(tmp, b, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filepath.Join(raftDir, formatFile))
+}
+
+// PlanMigration returns the migrations upgrading raftDir to DataFormat, none
+// if it is up to date. It returns an error wrapping ErrDowngrade if raftDir
+// was written by a newer binary.
+func PlanMigration(raftDir string) ([]Migration, error) {
+	info, err := ReadDataFormat(raftDir)
+	if err != nil {
+		return nil, err
+	}
+	if info.Format > DataFormat {
+		return nil, fmt.Errorf("%w: format %d written by %s, this binary (%s) reads up to format %d, run a newer version or restore a backup taken before the upgrade",
+			ErrDowngrade, info.Format, info.Version, Version, DataFormat)
+	}
+	var plan []Migration
+	for format := info.Format; format < DataFormat; format++ {
+		found := false
+		for _, m := range migrations {
+			if m.From == format {
+				plan = append(plan, m)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("no migration from format %d", format)
+		}
+	}
+	return plan, nil
+}
+
+// Migrate upgrades raftDir to DataFormat and returns the migrations applied.
+// The state of the node is verified before any migration, and the format is
+// recorded after each, so that an interrupted migration resumes where it
+// stopped.
+func Migrate(raftDir string) ([]Migration, error) {
+	plan, err := PlanMigration(raftDir)
+	if err != nil || len(plan) == 0 {
+		return nil, err
+	}
+	if err := CheckIntegrity(raftDir); err != nil {
+		return nil, fmt.Errorf("pre-flight check failed: %s", err.Error())
+	}
+	for i, m := range plan {
+		if err := m.apply(raftDir); err != nil {
+			return plan[:i], fmt.Errorf("migration from format %d to %d failed: %s", m.From, m.To, err.Error())
+		}
+		if err := writeDataFormat(raftDir, m.To); err != nil {
+			return plan[:i], err
+		}
+	}
+	return plan, nil
+}
+
+// checkDataFormat migrates the data directory of the store, if AutoMigrate
+// is set, or fails if it needs to be. The format of a new node is recorded.
+func (s *Store) checkDataFormat() error {
+	if IsNewNode(s.raftDir) && !pathExists(filepath.Join(s.raftDir, formatFile)) {
+		return writeDataFormat(s.raftDir, DataFormat)
+	}
+	plan, err := PlanMigration(s.raftDir)
+	if err != nil || len(plan) == 0 {
+		return err
+	}
+	if !s.AutoMigrate {
+		return fmt.Errorf("%w from format %d to %d, run casmesh migrate", ErrMigrationNeeded, plan[0].From, DataFormat)
+	}
+	applied, err := Migrate(s.raftDir)
+	for _, m := range applied {
+		s.logger.Printf("migrated data directory from format %d to %d: %s", m.From, m.To, m.Description)
+	}
+	return err
+}
@@ -0,0 +1,118 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package store
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/casbin/casbin/v2"
+)
+
+// patternCacheSize bounds the patterns compiled for a namespace, the cache
+// starts over once it is full.
+const patternCacheSize = 4096
+
+var (
+	keyMatch2Param = regexp.MustCompile(`:[^/]+`)
+	keyMatch3Param = regexp.MustCompile(`\{[^/]+\}`)
+)
+
+// patternCache holds the regular expressions compiled from the patterns the
+// matcher functions of a namespace are called with, like the paths of
+// keyMatch2, so that enforcing does not compile them again for every rule
+// and request. A cache belongs to a model of the namespace, setting another
+// model starts a new one.
+type patternCache struct {
+	mu sync.RWMutex
+	re map[string]*regexp.Regexp
+}
+
+func newPatternCache() *patternCache {
+	return &patternCache{re: make(map[string]*regexp.Regexp)}
+}
+
+// compile returns the regular expression of pattern, converted by convert
+// the first time. kind tells apart the patterns of different functions.
+func (c *patternCache) compile(kind, pattern string, convert func(string) string) (*regexp.Regexp, error) {
+	key := kind + "\x00" + pattern
+	c.mu.RLock()
+	re, ok := c.re[key]
+	c.mu.RUnlock()
+	if ok {
+		return re, nil
+	}
+	re, err := regexp.Compile(convert(pattern))
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	if len(c.re) >= patternCacheSize {
+		c.re = make(map[string]*regexp.Regexp)
+	}
+	c.re[key] = re
+	c.mu.Unlock()
+	return re, nil
+}
+
+// matchFunc returns a matcher function matching its first argument against
+// the pattern given as the second one, as Casbin's function name does.
+func (c *patternCache) matchFunc(name string, convert func(string) string) func(args ...interface{}) (interface{}, error) {
+	return func(args ...interface{}) (interface{}, error) {
+		if len(args) != 2 {
+			return false, fmt.Errorf("%s: expected 2 arguments, but got %d", name, len(args))
+		}
+		key, ok1 := args[0].(string)
+		pattern, ok2 := args[1].(string)
+		if !ok1 || !ok2 {
+			return false, fmt.Errorf("%s: expected string arguments", name)
+		}
+		re, err := c.compile(name, pattern, convert)
+		if err != nil {
+			return false, fmt.Errorf("%s: %s", name, err.Error())
+		}
+		return re.MatchString(key), nil
+	}
+}
+
+// regexPattern, keyMatch2Pattern and keyMatch3Pattern convert the patterns
+// of regexMatch, keyMatch2 and keyMatch3 to regular expressions, as Casbin
+// does.
+func regexPattern(p string) string { return p }
+
+func keyMatch2Pattern(p string) string {
+	p = strings.Replace(p, "/*", "/.*", -1)
+	return "^" + keyMatch2Param.ReplaceAllString(p, "[^/]+") + "$"
+}
+
+func keyMatch3Pattern(p string) string {
+	p = strings.Replace(p, "/*", "/.*", -1)
+	return "^" + keyMatch3Param.ReplaceAllString(p, "[^/]+") + "$"
+}
+
+// cachePatterns replaces the matcher functions of enforcer compiling a
+// regular expression with ones compiling it once. It has to be called again
+// after the model is set, as Casbin resets the functions then.
+func cachePatterns(enforcer *casbin.DistributedEnforcer) {
+	c := newPatternCache()
+	enforcer.AddFunction("regexMatch", c.matchFunc("regexMatch", regexPattern))
+	enforcer.AddFunction("keyMatch2", c.matchFunc("keyMatch2", keyMatch2Pattern))
+	enforcer.AddFunction("keyMatch3", c.matchFunc("keyMatch3", keyMatch3Pattern))
+}
@@ -0,0 +1,107 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package store
+
+import (
+	"errors"
+	"time"
+
+	"github.com/hashicorp/raft"
+)
+
+const (
+	// PromotionKey is the node metadata key tracking the promotion of nodes
+	// which joined as voters while AutoPromote is set, or to repair their
+	// state.
+	PromotionKey = "promotion"
+
+	promotionPending = "pending"
+	promotionDone    = "promoted"
+
+	promotionInterval = time.Second
+)
+
+// startPromoter promotes the pending non-voters once they caught up, while
+// the node leads. It runs without AutoPromote too, for the nodes repairing
+// their state.
+func (s *Store) startPromoter() {
+	s.promoterDone = make(chan struct{})
+	go func(done chan struct{}) {
+		t := time.NewTicker(promotionInterval)
+		defer t.Stop()
+		for {
+			select {
+			case <-t.C:
+				if s.raft.State() == raft.Leader {
+					s.promoteCaughtUp()
+				}
+			case <-done:
+				return
+			}
+		}
+	}(s.promoterDone)
+}
+
+func (s *Store) stopPromoter() {
+	if s.promoterDone != nil {
+		close(s.promoterDone)
+		s.promoterDone = nil
+	}
+}
+
+// promoteCaughtUp adds the pending non-voters at most PromoteMaxLag entries
+// behind as voters.
+func (s *Store) promoteCaughtUp() {
+	nodes, err := s.Nodes()
+	if err != nil {
+		s.logger.Printf("failed to list nodes to promote: %s", err.Error())
+		return
+	}
+	current, _ := s.leaders.get()
+	last, now := s.raft.LastIndex(), time.Now()
+	for _, n := range nodes {
+		if n.Voter || n.Metadata[PromotionKey] != promotionPending {
+			continue
+		}
+		srv := raft.Server{ID: raft.ServerID(n.ID), Address: raft.ServerAddress(n.Addr)}
+		p := s.replication.progress(srv, last, current.Since, now)
+		if !promotable(p, s.PromoteMaxLag, now) {
+			continue
+		}
+		err := s.changeMembership(n.ID, true, "", func() error {
+			s.logger.Printf("promoting node %s to voter, %d entries behind", n.ID, p.Lag)
+			return s.raft.AddVoter(srv.ID, srv.Address, 0, 0).Error()
+		})
+		if errors.Is(err, ErrMembershipStabilizing) {
+			continue
+		}
+		if err != nil {
+			s.logger.Printf("failed to promote node %s: %s", n.ID, err.Error())
+			continue
+		}
+		if err := s.setMetadata(n.ID, map[string]string{PromotionKey: promotionDone}); err != nil {
+			s.logger.Printf("failed to record promotion of node %s: %s", n.ID, err.Error())
+		}
+	}
+}
+
+// promotable reports whether a non-voter is in touch with the leader, not
+// installing a snapshot and at most maxLag entries behind.
+func promotable(p FollowerProgress, maxLag uint64, now time.Time) bool {
+	return p.Snapshot == nil && !p.LastContact.IsZero() && now.Sub(p.LastContact) < contactTimeout && p.Lag <= maxLag
+}
@@ -0,0 +1,153 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	"github.com/casbin/casbin-mesh/proto/command"
+	"github.com/golang/protobuf/proto"
+	"github.com/hashicorp/raft"
+)
+
+// ReadOnlyStatus tells whether the cluster rejects writes.
+type ReadOnlyStatus struct {
+	Enabled bool   `json:"enabled"`
+	Reason  string `json:"reason,omitempty"`
+	// Since is the unix time in nanoseconds read-only mode was enabled at.
+	Since int64 `json:"since,omitempty"`
+}
+
+// readOnlyMode holds the read-only status of the cluster. It is changed by
+// the FSM and read by the janitor and the status endpoints.
+type readOnlyMode struct {
+	mu     sync.RWMutex
+	status ReadOnlyStatus
+}
+
+func newReadOnlyMode() *readOnlyMode {
+	return &readOnlyMode{}
+}
+
+func (m *readOnlyMode) get() ReadOnlyStatus {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.status
+}
+
+func (m *readOnlyMode) set(status ReadOnlyStatus) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.status = status
+}
+
+// check returns an error wrapping ErrReadOnly if commands of type t are
+// rejected.
+func (m *readOnlyMode) check(t command.Type) error {
+	if !mutates(t) {
+		return nil
+	}
+	status := m.get()
+	if !status.Enabled {
+		return nil
+	}
+	if status.Reason == "" {
+		return ErrReadOnly
+	}
+	return fmt.Errorf("%w: %s", ErrReadOnly, status.Reason)
+}
+
+func (m *readOnlyMode) MarshalJSON() ([]byte, error) {
+	return json.Marshal(m.get())
+}
+
+func (m *readOnlyMode) UnmarshalJSON(data []byte) error {
+	var status ReadOnlyStatus
+	if err := json.Unmarshal(data, &status); err != nil {
+		return err
+	}
+	m.set(status)
+	return nil
+}
+
+// mutates tells whether commands of type t change the policies, the models
+// or the namespaces. Cluster membership changes are not included, the
+// cluster is managed while read-only.
+func mutates(t command.Type) bool {
+	switch t {
+	case command.Type_COMMAND_TYPE_CREATE_NAMESPACE,
+		command.Type_COMMAND_TYPE_SET_TEMPLATE,
+		command.Type_COMMAND_TYPE_DELETE_TEMPLATE,
+		command.Type_COMMAND_TYPE_SET_TEMPLATE_INSTANCE,
+		command.Type_COMMAND_TYPE_DELETE_TEMPLATE_INSTANCE,
+		command.Type_COMMAND_TYPE_ANNOTATE_POLICIES,
+		command.Type_COMMAND_TYPE_TAG_VERSION:
+		return true
+	}
+	return changesPolicies(t)
+}
+
+func (s *Store) applySetReadOnly(l *raft.Log, cmd *command.Command) interface{} {
+	var p command.SetReadOnlyPayload
+	if err := proto.Unmarshal(cmd.Payload, &p); err != nil {
+		return &FSMResponse{error: UnmarshalFailed}
+	}
+	current := s.readOnly.get()
+	if !p.Enabled {
+		s.readOnly.set(ReadOnlyStatus{})
+		return &FSMResponse{effected: current.Enabled}
+	}
+	since := current.Since
+	if !current.Enabled {
+		since = l.AppendedAt.UnixNano()
+	}
+	s.readOnly.set(ReadOnlyStatus{Enabled: true, Reason: p.Reason, Since: since})
+	return &FSMResponse{effected: !current.Enabled}
+}
+
+// SetReadOnly enables or disables the read-only mode of the cluster. While
+// read-only, writes fail with ErrReadOnly and enforcement and reads keep
+// being served. It returns whether the mode changed.
+func (s *Store) SetReadOnly(ctx context.Context, enabled bool, reason string) (bool, error) {
+	payload, err := proto.Marshal(&command.SetReadOnlyPayload{Enabled: enabled, Reason: reason})
+	if err != nil {
+		return false, err
+	}
+	cmd, err := proto.Marshal(&command.Command{
+		Type:    command.Type_COMMAND_TYPE_SET_READ_ONLY,
+		Payload: payload,
+	})
+	if err != nil {
+		return false, err
+	}
+	f, err := s.apply(ctx, cmd)
+	if err != nil {
+		return false, err
+	}
+	r := f.Response().(*FSMResponse)
+	return r.effected, r.error
+}
+
+// ReadOnly returns the read-only status of the cluster as applied on this
+// node.
+func (s *Store) ReadOnly() ReadOnlyStatus {
+	return s.readOnly.get()
+}
@@ -0,0 +1,147 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package store
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/casbin/casbin-mesh/proto/command"
+	"github.com/casbin/casbin/v2"
+	"github.com/golang/protobuf/proto"
+	"github.com/hashicorp/raft"
+)
+
+// replacePoliciesMeta marks the ROLLBACK_POLICIES commands whose payload is
+// the rules to replace those of the namespace with, as a PolicyVersion,
+// rather than a retained version to roll back to.
+const replacePoliciesMeta = "replace-policies"
+
+// ReplacePoliciesResponse is the response of a ROLLBACK_POLICIES command
+// replacing the rules of a namespace.
+type ReplacePoliciesResponse struct {
+	added, removed []*command.PolicyRules
+	error
+	replayMark
+}
+
+// ReplacePolicies makes policies the rules of a namespace, in a single
+// command, and returns the rules added and removed. Replacing the rules with
+// the same ones changes nothing. The role hierarchies the grouping rules
+// make are checked like those of AddPolicies.
+func (s *Store) ReplacePolicies(ctx context.Context, ns string, policies []*command.PolicyRules) (added, removed []*command.PolicyRules, err error) {
+	policies, err = normalizePolicies(policies)
+	if err != nil {
+		return nil, nil, err
+	}
+	s.roleMu.Lock()
+	defer s.roleMu.Unlock()
+	if err := s.checkReplacedGrouping(ns, policies); err != nil {
+		return nil, nil, err
+	}
+	payload, err := proto.Marshal(&command.PolicyVersion{Policies: policies})
+	if err != nil {
+		return nil, nil, err
+	}
+	cmd, err := proto.Marshal(&command.Command{
+		Type:      command.Type_COMMAND_TYPE_ROLLBACK_POLICIES,
+		Namespace: ns,
+		Payload:   payload,
+		Metadata:  map[string]string{replacePoliciesMeta: "true"},
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	f, err := s.apply(ctx, cmd)
+	if err != nil {
+		return nil, nil, err
+	}
+	switch r := f.Response().(type) {
+	case *ReplacePoliciesResponse:
+		return r.added, r.removed, r.error
+	case *FSMResponse:
+		return nil, nil, r.error
+	}
+	return nil, nil, nil
+}
+
+// PlanReplacePolicies returns the rules ReplacePolicies would add to and
+// remove from a namespace, as applied on this node.
+func (s *Store) PlanReplacePolicies(ns string, policies []*command.PolicyRules) (added, removed []*command.PolicyRules, err error) {
+	policies, err = normalizePolicies(policies)
+	if err != nil {
+		return nil, nil, err
+	}
+	e, ok := s.enforcers.Load(ns)
+	if !ok {
+		return nil, nil, NamespaceNotExist
+	}
+	enforcer := e.(*casbin.DistributedEnforcer)
+	if enforcer.GetModel() == nil {
+		return nil, nil, ModelUnsetYet
+	}
+	added, removed = DiffPolicies(currentPolicies(enforcer), policies)
+	return added, removed, nil
+}
+
+// checkReplacedGrouping checks the grouping rules replacing the rules of ns
+// with policies adds, without those it removes.
+func (s *Store) checkReplacedGrouping(ns string, policies []*command.PolicyRules) error {
+	added, removed, err := s.PlanReplacePolicies(ns, policies)
+	if err != nil {
+		return err
+	}
+	return s.checkGroupingChanges(ns, added, removed)
+}
+
+func (s *Store) applyReplacePolicies(l *raft.Log, cmd *command.Command) interface{} {
+	var p command.PolicyVersion
+	if err := proto.Unmarshal(cmd.Payload, &p); err != nil {
+		return &ReplacePoliciesResponse{error: UnmarshalFailed}
+	}
+	added, removed, err := s.replaceRules(l, cmd, p.Policies)
+	return &ReplacePoliciesResponse{added: added, removed: removed, error: err}
+}
+
+// normalizePolicies merges the rules of the same policy type and drops the
+// duplicate rules, so that they are added once.
+func normalizePolicies(policies []*command.PolicyRules) ([]*command.PolicyRules, error) {
+	var out []*command.PolicyRules
+	byType := make(map[string]*command.PolicyRules)
+	seen := make(map[string]bool)
+	for _, p := range policies {
+		if p.PType == "" || p.Sec != p.PType[:1] {
+			return nil, fmt.Errorf("invalid policy type %q of section %q", p.PType, p.Sec)
+		}
+		merged, ok := byType[p.PType]
+		if !ok {
+			merged = &command.PolicyRules{Sec: p.Sec, PType: p.PType}
+			byType[p.PType] = merged
+			out = append(out, merged)
+		}
+		for _, rule := range command.ToStringArray(p.Rules) {
+			key := ruleKey(p.Sec, p.PType, rule)
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+			merged.Rules = append(merged.Rules, &command.StringArray{S: rule})
+		}
+	}
+	return out, nil
+}
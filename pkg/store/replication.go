@@ -0,0 +1,211 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package store
+
+import (
+	"sync"
+	"time"
+
+	"github.com/hashicorp/raft"
+)
+
+const (
+	// rateSampleInterval is how often the replication rate of followers is
+	// sampled.
+	rateSampleInterval = time.Second
+	// contactTimeout is how recently the leader must have heard from a
+	// follower to count it as reachable.
+	contactTimeout = 5 * time.Second
+)
+
+// FollowerProgress is the replication state of a follower, as seen by the
+// leader.
+type FollowerProgress struct {
+	NodeID string `json:"node_id"`
+	Addr   string `json:"addr"`
+	Voter  bool   `json:"voter"`
+	// MatchIndex is the last log entry known to be replicated to the
+	// follower.
+	MatchIndex uint64 `json:"match_index"`
+	// Lag is the number of log entries the follower is behind the leader.
+	Lag         uint64    `json:"lag"`
+	LastContact time.Time `json:"last_contact"`
+	// Snapshot is the snapshot being installed on the follower, if any.
+	Snapshot *SnapshotProgress `json:"snapshot,omitempty"`
+	// CatchUp estimates how long the follower takes to catch up with the
+	// leader, negative if unknown.
+	CatchUp time.Duration `json:"catch_up"`
+}
+
+// SnapshotProgress is the transfer of a snapshot to a follower.
+type SnapshotProgress struct {
+	Size  int64     `json:"size"`
+	Sent  int64     `json:"sent"`
+	Since time.Time `json:"since"`
+}
+
+// followerState is what the leader observed of a follower.
+type followerState struct {
+	match       uint64
+	lastContact time.Time
+	// rate is the number of entries per second the follower replicates.
+	rate        float64
+	sampleIndex uint64
+	sampleT     time.Time
+	snapshot    *SnapshotProgress
+}
+
+// replicationTracker records the progress of followers, as observed by the
+// transport while the node leads.
+type replicationTracker struct {
+	mu        sync.Mutex
+	followers map[raft.ServerID]*followerState
+}
+
+func newReplicationTracker() *replicationTracker {
+	return &replicationTracker{followers: make(map[raft.ServerID]*followerState)}
+}
+
+func (t *replicationTracker) follower(id raft.ServerID) *followerState {
+	f, ok := t.followers[id]
+	if !ok {
+		f = &followerState{}
+		t.followers[id] = f
+	}
+	return f
+}
+
+// replicated records that the follower accepted req.
+func (t *replicationTracker) replicated(id raft.ServerID, req *raft.AppendEntriesRequest, at time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.follower(id).advance(req.PrevLogEntry+uint64(len(req.Entries)), at)
+}
+
+func (t *replicationTracker) snapshotStarted(id raft.ServerID, size int64, at time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.follower(id).snapshot = &SnapshotProgress{Size: size, Since: at}
+}
+
+func (t *replicationTracker) snapshotSent(id raft.ServerID, n int64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if s := t.follower(id).snapshot; s != nil {
+		s.Sent += n
+	}
+}
+
+// snapshotDone records the end of a snapshot transfer, which brings the
+// follower up to index if it succeeded.
+func (t *replicationTracker) snapshotDone(id raft.ServerID, index uint64, ok bool, at time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	f := t.follower(id)
+	f.snapshot = nil
+	if ok {
+		f.advance(index, at)
+	}
+}
+
+// progress returns the progress of srv, ignoring what was observed before
+// the node took leadership at since.
+func (t *replicationTracker) progress(srv raft.Server, last uint64, since, now time.Time) FollowerProgress {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	p := FollowerProgress{NodeID: string(srv.ID), Addr: string(srv.Address), Voter: srv.Suffrage == raft.Voter, Lag: last, CatchUp: -1}
+	f, ok := t.followers[srv.ID]
+	if !ok || f.lastContact.Before(since) {
+		return p
+	}
+	p.MatchIndex, p.LastContact = f.match, f.lastContact
+	if f.match < last {
+		p.Lag = last - f.match
+	} else {
+		p.Lag = 0
+	}
+	if f.snapshot != nil {
+		s := *f.snapshot
+		p.Snapshot = &s
+	}
+	p.CatchUp = f.catchUp(p.Lag, now)
+	return p
+}
+
+// advance moves the match index of the follower to match, sampling the rate
+// it replicates at.
+func (f *followerState) advance(match uint64, at time.Time) {
+	if match > f.match {
+		f.match = match
+	}
+	f.lastContact = at
+	if f.sampleT.IsZero() {
+		f.sampleIndex, f.sampleT = f.match, at
+		return
+	}
+	if elapsed := at.Sub(f.sampleT); elapsed >= rateSampleInterval {
+		rate := float64(f.match-f.sampleIndex) / elapsed.Seconds()
+		if f.rate == 0 {
+			f.rate = rate
+		} else {
+			f.rate = (f.rate + rate) / 2
+		}
+		f.sampleIndex, f.sampleT = f.match, at
+	}
+}
+
+// catchUp estimates how long the follower takes to replicate lag entries,
+// or to receive the rest of the snapshot in transfer.
+func (f *followerState) catchUp(lag uint64, now time.Time) time.Duration {
+	if s := f.snapshot; s != nil {
+		elapsed := now.Sub(s.Since).Seconds()
+		if s.Sent == 0 || elapsed <= 0 {
+			return -1
+		}
+		return time.Duration(float64(s.Size-s.Sent) / (float64(s.Sent) / elapsed) * float64(time.Second))
+	}
+	if lag == 0 {
+		return 0
+	}
+	if f.rate <= 0 {
+		return -1
+	}
+	return time.Duration(float64(lag) / f.rate * float64(time.Second))
+}
+
+// Replication returns the replication state of the followers. Only the
+// leader knows it.
+func (s *Store) Replication() ([]FollowerProgress, error) {
+	if s.raft.State() != raft.Leader {
+		return nil, ErrNotLeader
+	}
+	f := s.raft.GetConfiguration()
+	if err := f.Error(); err != nil {
+		return nil, err
+	}
+	current, _ := s.leaders.get()
+	last, now := s.raft.LastIndex(), time.Now()
+	out := []FollowerProgress{}
+	for _, srv := range f.Configuration().Servers {
+		if srv.ID == raft.ServerID(s.raftID) {
+			continue
+		}
+		out = append(out, s.replication.progress(srv, last, current.Since, now))
+	}
+	return out, nil
+}
@@ -0,0 +1,65 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package store
+
+import (
+	"testing"
+	"time"
+
+	"github.com/hashicorp/raft"
+)
+
+func Test_ReplicationTracker(t *testing.T) {
+	tr := newReplicationTracker()
+	srv := raft.Server{ID: "node1", Address: "localhost:4004", Suffrage: raft.Voter}
+	t0 := time.Now()
+
+	tr.replicated(srv.ID, &raft.AppendEntriesRequest{PrevLogEntry: 10, Entries: make([]*raft.Log, 5)}, t0)
+	tr.replicated(srv.ID, &raft.AppendEntriesRequest{PrevLogEntry: 15, Entries: make([]*raft.Log, 10)}, t0.Add(time.Second))
+	// Heartbeats don't move the match index back.
+	tr.replicated(srv.ID, &raft.AppendEntriesRequest{}, t0.Add(time.Second))
+
+	p := tr.progress(srv, 45, t0.Add(-time.Second), t0.Add(time.Second))
+	if p.MatchIndex != 25 || p.Lag != 20 {
+		t.Fatalf("wrong progress, exp match 25 and lag 20, got %d and %d", p.MatchIndex, p.Lag)
+	}
+	if p.CatchUp != 2*time.Second {
+		t.Fatalf("wrong catch-up estimate, exp 2s, got %s", p.CatchUp)
+	}
+
+	tr.snapshotStarted(srv.ID, 1000, t0)
+	tr.snapshotSent(srv.ID, 250)
+	p = tr.progress(srv, 45, t0.Add(-time.Second), t0.Add(time.Second))
+	if p.Snapshot == nil || p.Snapshot.Sent != 250 {
+		t.Fatalf("snapshot transfer not reported: %+v", p.Snapshot)
+	}
+	if p.CatchUp != 3*time.Second {
+		t.Fatalf("wrong snapshot catch-up estimate, exp 3s, got %s", p.CatchUp)
+	}
+	tr.snapshotDone(srv.ID, 45, true, t0.Add(2*time.Second))
+	p = tr.progress(srv, 45, t0.Add(-time.Second), t0.Add(2*time.Second))
+	if p.Snapshot != nil || p.Lag != 0 || p.CatchUp != 0 {
+		t.Fatalf("follower not caught up after snapshot: %+v", p)
+	}
+
+	// Observations from former leaderships are ignored.
+	p = tr.progress(srv, 45, t0.Add(time.Hour), t0.Add(time.Hour))
+	if p.MatchIndex != 0 || p.Lag != 45 || p.CatchUp >= 0 {
+		t.Fatalf("stale progress reported: %+v", p)
+	}
+}
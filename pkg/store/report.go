@@ -0,0 +1,59 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package store
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/casbin/casbin-mesh/pkg/errreport"
+)
+
+// reportFlushTimeout bounds how long a node about to crash waits for its
+// panic to be reported.
+const reportFlushTimeout = 5 * time.Second
+
+// reportError passes err to ReportError, if set.
+func (s *Store) reportError(err error) {
+	if s.ReportError != nil {
+		s.ReportError(err)
+	}
+}
+
+// reportFailure reports *err, if any, as a failure to do what.
+func (s *Store) reportFailure(what string, err *error) {
+	if *err != nil {
+		s.reportError(fmt.Errorf("failed to %s: %w", what, *err))
+	}
+}
+
+// reportPanic reports a panic applying the log entry at index, waits for the
+// report to be sent, then panics again: the FSM can't go on with an entry
+// half applied.
+func (s *Store) reportPanic(index uint64) {
+	if s.ReportError == nil {
+		return
+	}
+	if p := recover(); p != nil {
+		s.reportError(errreport.Recovered(fmt.Sprintf("applying log entry %d", index), p))
+		if s.FlushReports != nil && !s.FlushReports(reportFlushTimeout) {
+			s.logger.Printf("panic applying log entry %d not reported within %s", index, reportFlushTimeout)
+		}
+		panic(p)
+	}
+}
@@ -0,0 +1,212 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package store
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/casbin/casbin-mesh/proto/command"
+	"github.com/casbin/casbin/v2"
+)
+
+var (
+	// ErrRoleCycle is returned when grouping rules would make a role
+	// inherit from itself.
+	ErrRoleCycle = errors.New("role inheritance cycle")
+
+	// ErrRoleTooDeep is returned when grouping rules would make a role
+	// hierarchy deeper than MaxRoleDepth.
+	ErrRoleTooDeep = errors.New("role hierarchy too deep")
+)
+
+// DefaultMaxRoleDepth is the depth of the role hierarchies Casbin's role
+// manager follows, links beyond it are ignored when enforcing.
+const DefaultMaxRoleDepth = 10
+
+// roleGraph holds the links of a grouping policy type, from a user or role
+// to the roles it inherits from. Names are keyed with their domain, as
+// links only chain within a domain.
+type roleGraph map[string][]string
+
+func roleKey(rule []string) (from, to string) {
+	domain := strings.Join(rule[2:], ",")
+	return domain + "\x00" + rule[0], domain + "\x00" + rule[1]
+}
+
+func newRoleGraph(rules [][]string, removed map[string]bool) roleGraph {
+	g := make(roleGraph)
+	for _, rule := range rules {
+		if len(rule) < 2 || removed[strings.Join(rule, ",")] {
+			continue
+		}
+		from, to := roleKey(rule)
+		g[from] = append(g[from], to)
+	}
+	return g
+}
+
+// path returns a path from one name to another, nil if there is none.
+func (g roleGraph) path(from, to string, seen map[string]bool) []string {
+	if from == to {
+		return []string{to}
+	}
+	if seen[from] {
+		return nil
+	}
+	seen[from] = true
+	for _, next := range g[from] {
+		if p := g.path(next, to, seen); p != nil {
+			return append([]string{from}, p...)
+		}
+	}
+	return nil
+}
+
+// longest returns the longest chain of links of g starting at name.
+func (g roleGraph) longest(name string, memo map[string][]string, visiting map[string]bool) []string {
+	if p, ok := memo[name]; ok {
+		return p
+	}
+	var best []string
+	// links of cycles already in the graph are only followed once
+	if !visiting[name] {
+		visiting[name] = true
+		for _, next := range g[name] {
+			if p := g.longest(next, memo, visiting); len(p) > len(best) {
+				best = p
+			}
+		}
+		visiting[name] = false
+	}
+	p := append([]string{name}, best...)
+	memo[name] = p
+	return p
+}
+
+func (g roleGraph) reverse() roleGraph {
+	r := make(roleGraph)
+	for from, tos := range g {
+		for _, to := range tos {
+			r[to] = append(r[to], from)
+		}
+	}
+	return r
+}
+
+// formatChain names the roles of a chain of keys, and their domain.
+func formatChain(chain []string) string {
+	names := make([]string, len(chain))
+	var domain string
+	for i, key := range chain {
+		kv := strings.SplitN(key, "\x00", 2)
+		domain, names[i] = kv[0], kv[1]
+	}
+	out := strings.Join(names, " -> ")
+	if domain != "" {
+		out += " in domain " + domain
+	}
+	return out
+}
+
+// checkRoleHierarchy returns an error wrapping ErrRoleCycle or ErrRoleTooDeep,
+// naming the offending chain, if adding the grouping rules added to the
+// rules of existing, without the rules removed, makes a role inherit from
+// itself, or a chain longer than maxDepth links.
+func checkRoleHierarchy(existing, added, removed [][]string, maxDepth int) error {
+	without := make(map[string]bool, len(removed))
+	for _, rule := range removed {
+		without[strings.Join(rule, ",")] = true
+	}
+	g := newRoleGraph(append(append([][]string{}, existing...), added...), without)
+	for _, rule := range added {
+		if len(rule) < 2 {
+			continue
+		}
+		from, to := roleKey(rule)
+		if p := g.path(to, from, make(map[string]bool)); p != nil {
+			return fmt.Errorf("%w: %s", ErrRoleCycle, formatChain(append([]string{from}, p...)))
+		}
+	}
+	if maxDepth <= 0 {
+		return nil
+	}
+	rev := g.reverse()
+	down, up := make(map[string][]string), make(map[string][]string)
+	for _, rule := range added {
+		if len(rule) < 2 {
+			continue
+		}
+		from, to := roleKey(rule)
+		before := rev.longest(from, up, make(map[string]bool))
+		after := g.longest(to, down, make(map[string]bool))
+		if links := len(before) + len(after) - 1; links > maxDepth {
+			chain := make([]string, 0, links+1)
+			for i := len(before) - 1; i >= 0; i-- {
+				chain = append(chain, before[i])
+			}
+			chain = append(chain, after...)
+			return fmt.Errorf("%w: %s has %d links, over %d", ErrRoleTooDeep, formatChain(chain), links, maxDepth)
+		}
+	}
+	return nil
+}
+
+// maxRoleDepth returns the depth of role hierarchies writes may not exceed.
+func (s *Store) maxRoleDepth() int {
+	if s.MaxRoleDepth > 0 {
+		return s.MaxRoleDepth
+	}
+	return DefaultMaxRoleDepth
+}
+
+// checkGroupingRules checks that the grouping rules added to the rules of
+// pType in ns, without those removed, keep the role hierarchy acyclic and
+// within MaxRoleDepth. Rules of other sections are not checked.
+func (s *Store) checkGroupingRules(ns, sec, pType string, added, removed [][]string) error {
+	if sec != "g" || len(added) == 0 {
+		return nil
+	}
+	e, ok := s.enforcers.Load(ns)
+	if !ok {
+		return nil
+	}
+	enforcer := e.(*casbin.DistributedEnforcer)
+	if enforcer.GetModel() == nil {
+		return nil
+	}
+	return checkRoleHierarchy(enforcer.GetNamedGroupingPolicy(pType), added, removed, s.maxRoleDepth())
+}
+
+// checkGroupingChanges checks the grouping rules of added, without those of
+// removed, like checkGroupingRules, for each policy type of added.
+func (s *Store) checkGroupingChanges(ns string, added, removed []*command.PolicyRules) error {
+	for _, a := range added {
+		var without [][]string
+		for _, r := range removed {
+			if r.PType == a.PType {
+				without = append(without, command.ToStringArray(r.Rules)...)
+			}
+		}
+		if err := s.checkGroupingRules(ns, a.Sec, a.PType, command.ToStringArray(a.Rules), without); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,291 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package store
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/casbin/casbin-mesh/proto/command"
+	"github.com/golang/protobuf/proto"
+)
+
+// ScheduleChange names a policy type of a namespace holding scheduled rules
+// to activate or deactivate.
+type ScheduleChange struct {
+	Namespace string
+	Sec       string
+	PType     string
+	Activate  bool
+}
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+var (
+	locationsMu sync.Mutex
+	locations   = make(map[string]*time.Location)
+)
+
+// loadLocation caches time.LoadLocation, which reads the tz database.
+func loadLocation(name string) (*time.Location, error) {
+	locationsMu.Lock()
+	defer locationsMu.Unlock()
+	if loc, ok := locations[name]; ok {
+		return loc, nil
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, err
+	}
+	locations[name] = loc
+	return loc, nil
+}
+
+// parseHours parses "HH:MM-HH:MM" into minutes of the day.
+func parseHours(hours string) (from, to int, err error) {
+	parts := strings.Split(hours, "-")
+	if len(parts) != 2 {
+		return 0, 0, fmt.Errorf("invalid hours %q, expected HH:MM-HH:MM", hours)
+	}
+	if from, err = parseClock(parts[0]); err != nil {
+		return 0, 0, fmt.Errorf("invalid hours %q: %s", hours, err.Error())
+	}
+	if to, err = parseClock(parts[1]); err != nil {
+		return 0, 0, fmt.Errorf("invalid hours %q: %s", hours, err.Error())
+	}
+	return from, to, nil
+}
+
+func parseClock(clock string) (int, error) {
+	parts := strings.Split(strings.TrimSpace(clock), ":")
+	if len(parts) != 2 {
+		return 0, fmt.Errorf("invalid time %q", clock)
+	}
+	h, err := strconv.Atoi(parts[0])
+	if err != nil || h < 0 || h > 24 {
+		return 0, fmt.Errorf("invalid time %q", clock)
+	}
+	m, err := strconv.Atoi(parts[1])
+	if err != nil || m < 0 || m > 59 || h == 24 && m != 0 {
+		return 0, fmt.Errorf("invalid time %q", clock)
+	}
+	return h*60 + m, nil
+}
+
+// ValidateSchedule checks the days, hours and timezone of a schedule.
+func ValidateSchedule(sch *command.Schedule) error {
+	if sch.NotAfter != 0 && sch.NotAfter <= sch.NotBefore {
+		return fmt.Errorf("notAfter must be after notBefore")
+	}
+	for _, d := range sch.Days {
+		if _, ok := weekdays[strings.ToLower(d)]; !ok {
+			return fmt.Errorf("invalid day %q, expected one of mon, tue, wed, thu, fri, sat, sun", d)
+		}
+	}
+	if sch.Hours != "" {
+		if _, _, err := parseHours(sch.Hours); err != nil {
+			return err
+		}
+	}
+	if sch.Timezone != "" {
+		if _, err := loadLocation(sch.Timezone); err != nil {
+			return fmt.Errorf("invalid timezone %q: %s", sch.Timezone, err.Error())
+		}
+	}
+	return nil
+}
+
+// scheduleActive tells whether a valid schedule is active at now. Hours
+// ending before they start span midnight, and are matched against the day
+// they start on.
+func scheduleActive(sch *command.Schedule, now int64) bool {
+	if now < sch.NotBefore || sch.NotAfter != 0 && now >= sch.NotAfter {
+		return false
+	}
+	if len(sch.Days) == 0 && sch.Hours == "" {
+		return true
+	}
+	loc := time.UTC
+	if sch.Timezone != "" {
+		if l, err := loadLocation(sch.Timezone); err == nil {
+			loc = l
+		}
+	}
+	t := time.Unix(now, 0).In(loc)
+	day := t.Weekday()
+	if sch.Hours != "" {
+		from, to, _ := parseHours(sch.Hours)
+		minute := t.Hour()*60 + t.Minute()
+		switch {
+		case from <= to:
+			if minute < from || minute >= to {
+				return false
+			}
+		case minute < to:
+			// the window started the day before
+			day = (day + 6) % 7
+		case minute < from:
+			return false
+		}
+	}
+	if len(sch.Days) == 0 {
+		return true
+	}
+	for _, d := range sch.Days {
+		if weekdays[strings.ToLower(d)] == day {
+			return true
+		}
+	}
+	return false
+}
+
+// scheduled returns the scheduled rules of a policy type to activate, or to
+// deactivate, at now. Expired rules are left to the expiry.
+func (r *expiryRegistry) scheduled(ns, sec, pType string, now int64, activate bool) [][]string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	var out [][]string
+	for _, e := range r.rules[ns] {
+		if e.Sec == sec && e.PType == pType && e.changes(now) && e.Active != activate {
+			out = append(out, e.Rule)
+		}
+	}
+	sortRules(out)
+	return out
+}
+
+// changes tells whether the scheduled rule must be activated or deactivated
+// at now.
+func (e expiringRule) changes(now int64) bool {
+	if e.Schedule == nil || e.ExpireAt != 0 && e.ExpireAt <= now {
+		return false
+	}
+	return scheduleActive(e.Schedule, now) != e.Active
+}
+
+// inactive returns the scheduled rules of a policy type which are out of
+// the enforcer until their schedule activates them.
+func (r *expiryRegistry) inactive(ns, sec, pType string) [][]string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	var out [][]string
+	for _, e := range r.rules[ns] {
+		if e.Sec == sec && e.PType == pType && e.Schedule != nil && !e.Active {
+			out = append(out, e.Rule)
+		}
+	}
+	sortRules(out)
+	return out
+}
+
+func (r *expiryRegistry) setActive(ns, sec, pType string, rules [][]string, active bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, rule := range rules {
+		key := ruleKey(sec, pType, rule)
+		if e, ok := r.rules[ns][key]; ok {
+			e.Active = active
+			r.rules[ns][key] = e
+		}
+	}
+}
+
+func (r *expiryRegistry) changes(now int64) []ScheduleChange {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	seen := make(map[ScheduleChange]bool)
+	var out []ScheduleChange
+	for ns, rules := range r.rules {
+		for _, e := range rules {
+			c := ScheduleChange{Namespace: ns, Sec: e.Sec, PType: e.PType, Activate: !e.Active}
+			if e.changes(now) && !seen[c] {
+				seen[c] = true
+				out = append(out, c)
+			}
+		}
+	}
+	return out
+}
+
+// AddScheduledPolicies adds rules only active within the schedule. The rules
+// are in the enforcer while the schedule is active, SchedulePolicies adds and
+// removes them as it starts and ends. They are removed for good after the
+// notAfter of the schedule.
+func (s *Store) AddScheduledPolicies(ctx context.Context, ns string, sec string, pType string, rules [][]string, schedule *command.Schedule) ([][]string, error) {
+	if err := ValidateSchedule(schedule); err != nil {
+		return nil, err
+	}
+	return s.addPolicies(ctx, ns, &command.AddPoliciesPayload{
+		Sec:      sec,
+		PType:    pType,
+		Rules:    command.NewStringArray(rules),
+		ExpireAt: schedule.NotAfter,
+		Schedule: schedule,
+		Now:      s.now().Unix(),
+	})
+}
+
+// ScheduleChanges lists the policy types holding scheduled rules to activate
+// or deactivate at now. None are listed while the cluster is read-only.
+func (s *Store) ScheduleChanges(now time.Time) []ScheduleChange {
+	if s.readOnly.get().Enabled {
+		return nil
+	}
+	return s.expiries.changes(now.Unix())
+}
+
+// SchedulePolicies activates, or deactivates, the scheduled rules of a policy
+// type according to their schedule at now. The time is replicated along with
+// the command, so that every node changes the same rules.
+func (s *Store) SchedulePolicies(ctx context.Context, ns string, sec string, pType string, now time.Time, activate bool) ([][]string, error) {
+	payload, err := proto.Marshal(&command.SchedulePoliciesPayload{
+		Sec:      sec,
+		PType:    pType,
+		Now:      now.Unix(),
+		Activate: activate,
+	})
+	if err != nil {
+		return nil, err
+	}
+	cmd, err := proto.Marshal(&command.Command{
+		Type:      command.Type_COMMAND_TYPE_SCHEDULE_POLICIES,
+		Namespace: ns,
+		Payload:   payload,
+	})
+	if err != nil {
+		return nil, err
+	}
+	f, err := s.apply(ctx, cmd)
+	if err != nil {
+		return nil, err
+	}
+	r := f.Response().(*FSMResponse)
+	return r.effectedRules, r.error
+}
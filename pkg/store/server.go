@@ -19,8 +19,12 @@ package store
 
 // Server represents another node in the cluster.
 type Server struct {
-	ID   string `json:"id,omitempty"`
-	Addr string `json:"addr,omitempty"`
+	ID    string `json:"id,omitempty"`
+	Addr  string `json:"addr,omitempty"`
+	Voter bool   `json:"voter"`
+	// Metadata holds the key/value pairs the node registered, like its API
+	// address or its zone.
+	Metadata map[string]string `json:"metadata,omitempty"`
 }
 
 // Servers is a set of Servers.
@@ -0,0 +1,189 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/hashicorp/raft"
+
+	"github.com/casbin/casbin-mesh/proto/command"
+)
+
+// namespaceSettingsMeta marks the CREATE_NAMESPACE entries setting the
+// settings of an existing namespace, JSON encoded, instead of creating it.
+const namespaceSettingsMeta = "namespace-settings"
+
+// NamespaceSettings change how the requests of a namespace are enforced.
+type NamespaceSettings struct {
+	// RootSubjects are always allowed, whatever the rules.
+	RootSubjects []string `json:"root_subjects,omitempty"`
+	// RootClaim is a key=value claim allowing the requests whose subject is
+	// an object holding it, e.g. role=superuser. A claim holding a list
+	// allows if the list holds the value.
+	RootClaim string `json:"root_claim,omitempty"`
+	// FailClosed denies the requests failing to evaluate, e.g. with too
+	// few parameters, instead of returning the error.
+	FailClosed bool `json:"fail_closed,omitempty"`
+}
+
+// Validate checks the settings are well formed.
+func (n NamespaceSettings) Validate() error {
+	if n.RootClaim != "" {
+		if kv := strings.SplitN(n.RootClaim, "=", 2); len(kv) != 2 || kv[0] == "" {
+			return fmt.Errorf("invalid root claim %q, expected key=value", n.RootClaim)
+		}
+	}
+	return nil
+}
+
+// isRoot tells whether the subject of a request, its first parameter, is a
+// root of the namespace.
+func (n NamespaceSettings) isRoot(params []interface{}) bool {
+	if len(params) == 0 {
+		return false
+	}
+	switch sub := params[0].(type) {
+	case string:
+		for _, root := range n.RootSubjects {
+			if sub == root {
+				return true
+			}
+		}
+	case map[string]interface{}:
+		if n.RootClaim == "" {
+			return false
+		}
+		kv := strings.SplitN(n.RootClaim, "=", 2)
+		switch v := sub[kv[0]].(type) {
+		case string:
+			return v == kv[1]
+		case []interface{}:
+			for _, item := range v {
+				if s, ok := item.(string); ok && s == kv[1] {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// settingsRegistry holds the settings of the namespaces. It is changed by
+// the FSM and read when enforcing.
+type settingsRegistry struct {
+	mu         sync.RWMutex
+	namespaces map[string]NamespaceSettings
+}
+
+func newSettingsRegistry() *settingsRegistry {
+	return &settingsRegistry{namespaces: make(map[string]NamespaceSettings)}
+}
+
+func (r *settingsRegistry) get(ns string) NamespaceSettings {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.namespaces[ns]
+}
+
+// set replaces the settings of ns, empty settings remove them.
+func (r *settingsRegistry) set(ns string, settings NamespaceSettings) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(settings.RootSubjects) == 0 && settings.RootClaim == "" && !settings.FailClosed {
+		delete(r.namespaces, ns)
+		return
+	}
+	r.namespaces[ns] = settings
+}
+
+func (r *settingsRegistry) reset() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.namespaces = make(map[string]NamespaceSettings)
+}
+
+func (r *settingsRegistry) MarshalJSON() ([]byte, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return json.Marshal(r.namespaces)
+}
+
+func (r *settingsRegistry) UnmarshalJSON(data []byte) error {
+	namespaces := make(map[string]NamespaceSettings)
+	if err := json.Unmarshal(data, &namespaces); err != nil {
+		return err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.namespaces = namespaces
+	return nil
+}
+
+func (s *Store) applySetNamespaceSettings(l *raft.Log, cmd *command.Command) interface{} {
+	var settings NamespaceSettings
+	if err := json.Unmarshal([]byte(cmd.Metadata[namespaceSettingsMeta]), &settings); err != nil {
+		return &FSMResponse{error: UnmarshalFailed}
+	}
+	if _, ok := s.enforcers.Load(cmd.Namespace); !ok {
+		return &FSMResponse{error: NamespaceNotExist}
+	}
+	s.settings.set(cmd.Namespace, settings)
+	s.logger.Printf("namespace %s: root subjects set to %v, root claim to %q, fail closed to %t at index %d",
+		cmd.Namespace, settings.RootSubjects, settings.RootClaim, settings.FailClosed, l.Index)
+	return &FSMResponse{}
+}
+
+// SetNamespaceSettings replaces the settings of a namespace. Empty settings
+// remove them.
+func (s *Store) SetNamespaceSettings(ctx context.Context, ns string, settings NamespaceSettings) error {
+	if err := settings.Validate(); err != nil {
+		return err
+	}
+	b, err := json.Marshal(settings)
+	if err != nil {
+		return err
+	}
+	cmd, err := proto.Marshal(&command.Command{
+		Type:      command.Type_COMMAND_TYPE_CREATE_NAMESPACE,
+		Namespace: ns,
+		Metadata:  map[string]string{namespaceSettingsMeta: string(b)},
+	})
+	if err != nil {
+		return err
+	}
+	f, err := s.apply(ctx, cmd)
+	if err != nil {
+		return err
+	}
+	return f.Response().(*FSMResponse).error
+}
+
+// NamespaceSettings returns the settings of a namespace as applied on this
+// node.
+func (s *Store) NamespaceSettings(ns string) (NamespaceSettings, error) {
+	if _, ok := s.enforcers.Load(ns); !ok {
+		return NamespaceSettings{}, NamespaceNotExist
+	}
+	return s.settings.get(ns), nil
+}
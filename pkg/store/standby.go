@@ -0,0 +1,215 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"sync"
+
+	"github.com/casbin/casbin-mesh/proto/command"
+	"github.com/golang/protobuf/proto"
+)
+
+const (
+	// standbyIndexMeta is the command metadata key holding the index of the
+	// change of the primary cluster a command replicates.
+	standbyIndexMeta = "standby-index"
+	// standbySeedMeta marks the commands seeding a namespace from a snapshot
+	// of the primary cluster.
+	standbySeedMeta = "standby-seed"
+)
+
+// StandbyPosition tells how far a standby cluster replicated the changes of
+// its primary cluster, in indexes of the primary.
+type StandbyPosition struct {
+	// Cursor is the index the change feed of the primary resumes after.
+	Cursor uint64 `json:"cursor"`
+	// Namespaces holds the index each namespace was replicated up to.
+	Namespaces map[string]uint64 `json:"namespaces"`
+}
+
+// Resume returns the index to resume the change feed after. Seeded
+// namespaces are ahead of the cursor until the first change is replicated.
+func (p StandbyPosition) Resume() uint64 {
+	var min uint64
+	for _, idx := range p.Namespaces {
+		if min == 0 || idx < min {
+			min = idx
+		}
+	}
+	if p.Cursor > min {
+		return p.Cursor
+	}
+	return min
+}
+
+// standbyRegistry holds the replicated position of a standby cluster. It is
+// changed by the FSM and read by the replication agent.
+type standbyRegistry struct {
+	mu  sync.RWMutex
+	pos StandbyPosition
+}
+
+func newStandbyRegistry() *standbyRegistry {
+	return &standbyRegistry{pos: StandbyPosition{Namespaces: make(map[string]uint64)}}
+}
+
+func (r *standbyRegistry) get() StandbyPosition {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	p := StandbyPosition{Cursor: r.pos.Cursor, Namespaces: make(map[string]uint64, len(r.pos.Namespaces))}
+	for ns, idx := range r.pos.Namespaces {
+		p.Namespaces[ns] = idx
+	}
+	return p
+}
+
+func (r *standbyRegistry) set(p StandbyPosition) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if p.Namespaces == nil {
+		p.Namespaces = make(map[string]uint64)
+	}
+	r.pos = p
+}
+
+// check tells whether cmd replicates the primary cluster, in which case it
+// is applied while read-only, and whether it was replicated already.
+func (r *standbyRegistry) check(cmd *command.Command) (replicated, applied bool) {
+	if cmd.Metadata[standbySeedMeta] != "" {
+		return true, false
+	}
+	idx, err := strconv.ParseUint(cmd.Metadata[standbyIndexMeta], 10, 64)
+	if err != nil {
+		return false, false
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return true, idx <= r.pos.Namespaces[cmd.Namespace]
+}
+
+// record moves the position past cmd once it was applied. Seeding only moves
+// the position of its namespace.
+func (r *standbyRegistry) record(cmd *command.Command) {
+	idx, err := strconv.ParseUint(cmd.Metadata[standbyIndexMeta], 10, 64)
+	if err != nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if idx > r.pos.Namespaces[cmd.Namespace] {
+		r.pos.Namespaces[cmd.Namespace] = idx
+	}
+	if cmd.Metadata[standbySeedMeta] == "" && idx > r.pos.Cursor {
+		r.pos.Cursor = idx
+	}
+}
+
+func (r *standbyRegistry) MarshalJSON() ([]byte, error) {
+	return json.Marshal(r.get())
+}
+
+func (r *standbyRegistry) UnmarshalJSON(data []byte) error {
+	var p StandbyPosition
+	if err := json.Unmarshal(data, &p); err != nil {
+		return err
+	}
+	r.set(p)
+	return nil
+}
+
+// StandbyPosition returns how far the cluster replicated its primary.
+func (s *Store) StandbyPosition() StandbyPosition {
+	return s.standby.get()
+}
+
+// ApplyChange applies a change of the primary cluster, read from its change
+// feed. Changes are applied while the cluster is read-only, and once: a
+// change replicated already is skipped.
+func (s *Store) ApplyChange(ctx context.Context, ch ChangeEvent) error {
+	t, ok := command.Type_value[ch.Type]
+	if !ok || !feeds(command.Type(t)) {
+		return fmt.Errorf("unsupported change type %s", ch.Type)
+	}
+	md := options(ch.Options)
+	if md == nil {
+		md = make(map[string]string)
+	}
+	md[standbyIndexMeta] = strconv.FormatUint(ch.Index, 10)
+	return s.applyReplicated(ctx, command.Type(t), ch.Namespace, ch.Payload, md)
+}
+
+// SeedNamespace replaces the model and the policies of a namespace with a
+// snapshot of the primary cluster read at index, creating the namespace if
+// needed. Changes of the namespace up to index are skipped afterwards.
+func (s *Store) SeedNamespace(ctx context.Context, ns, model string, policies []*command.PolicyRules, index uint64) error {
+	type step struct {
+		t       command.Type
+		payload proto.Message
+	}
+	var steps []step
+	if _, ok := s.enforcers.Load(ns); !ok {
+		steps = append(steps, step{t: command.Type_COMMAND_TYPE_CREATE_NAMESPACE})
+	}
+	if model != "" {
+		steps = append(steps, step{command.Type_COMMAND_TYPE_SET_MODEL, &command.SetModelFromString{Text: model}})
+	}
+	steps = append(steps, step{t: command.Type_COMMAND_TYPE_CLEAR_POLICY})
+	for _, p := range policies {
+		if len(p.Rules) == 0 {
+			continue
+		}
+		steps = append(steps, step{command.Type_COMMAND_TYPE_ADD_POLICIES, &command.AddPoliciesPayload{Sec: p.Sec, PType: p.PType, Rules: p.Rules}})
+	}
+	for i, st := range steps {
+		var payload []byte
+		if st.payload != nil {
+			var err error
+			if payload, err = proto.Marshal(st.payload); err != nil {
+				return err
+			}
+		}
+		md := map[string]string{standbySeedMeta: "true"}
+		// the position of the namespace moves once it is fully seeded
+		if i == len(steps)-1 {
+			md[standbyIndexMeta] = strconv.FormatUint(index, 10)
+		}
+		if err := s.applyReplicated(ctx, st.t, ns, payload, md); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Store) applyReplicated(ctx context.Context, t command.Type, ns string, payload []byte, md map[string]string) error {
+	cmd, err := proto.Marshal(&command.Command{Type: t, Namespace: ns, Payload: payload, Metadata: md})
+	if err != nil {
+		return err
+	}
+	f, err := s.apply(ctx, cmd)
+	if err != nil {
+		return err
+	}
+	if r, ok := f.Response().(*FSMResponse); ok {
+		return r.error
+	}
+	return nil
+}
@@ -18,11 +18,13 @@
 package store
 
 import (
+	"context"
 	"errors"
 	"expvar"
 	"fmt"
 	"github.com/casbin/casbin-mesh/pkg/adapter"
 	"github.com/casbin/casbin-mesh/pkg/auth"
+	"io"
 	"log"
 	"os"
 	"path/filepath"
@@ -36,6 +38,7 @@ import (
 	"github.com/casbin/casbin-mesh/proto/command"
 
 	rlog "github.com/casbin/casbin-mesh/pkg/log"
+	"github.com/hashicorp/go-hclog"
 	"github.com/hashicorp/raft"
 )
 
@@ -55,6 +58,39 @@ var (
 	// ErrInvalidBackupFormat is returned when the requested backup format
 	// is not valid.
 	ErrInvalidBackupFormat = errors.New("invalid backup format")
+
+	// ErrNodeNotExist is returned when the requested node is not a member
+	// of the cluster.
+	ErrNodeNotExist = errors.New("node does not exist")
+
+	// ErrIndexTimeout is returned when the node does not reach the requested
+	// applied index in time.
+	ErrIndexTimeout = errors.New("timeout waiting for applied index")
+
+	// ErrReadOnly is returned when a write is sent to a cluster in
+	// read-only mode.
+	ErrReadOnly = errors.New("cluster is read-only")
+
+	// ErrStepDownCooldown is returned when the leader is asked to step down
+	// too soon after taking leadership.
+	ErrStepDownCooldown = errors.New("leader is in step-down cooldown")
+
+	// ErrQuorumAtRisk is returned when a membership change would leave
+	// the cluster without a quorum of reachable voters, or with fewer voters
+	// than MinQuorum.
+	ErrQuorumAtRisk = errors.New("membership change would break quorum")
+
+	// ErrMembershipStabilizing is returned when a membership change comes
+	// too soon after the previous one.
+	ErrMembershipStabilizing = errors.New("membership changed too recently")
+
+	// ErrUnsupportedByCluster is returned when a command is of a type some
+	// members of the cluster are too old to apply.
+	ErrUnsupportedByCluster = errors.New("command not supported by all members")
+
+	// ErrUnsupportedCommand is returned by nodes with an older FSM schema for
+	// the queries written with a newer one.
+	ErrUnsupportedCommand = errors.New("command not supported by this node")
 )
 
 const (
@@ -121,12 +157,15 @@ type Store struct {
 	rootUsername  string
 	raft          *raft.Raft // The consensus mechanism.
 	ln            Listener
-	raftTn        *raft.NetworkTransport
+	raftTn        raft.Transport
 	raftID        string // Node ID.
+	raftLogger    hclog.Logger
 
 	raftLog    raft.LogStore    // Persistent log store.
 	raftStable raft.StableStore // Persistent k-v store.
 	boltStore  *rlog.Log        // Physical store.
+	memory     *MemoryStorage   // Holds the state instead of raftDir, if set.
+	clock      func() time.Time
 
 	//onDiskCreated        bool      // On disk database actually created?
 	snapsExistOnOpen     bool      // Any snaps present when store opens?
@@ -142,11 +181,49 @@ type Store struct {
 	txMu    sync.RWMutex // Sync between snapshots and query-level transactions.
 	queryMu sync.RWMutex // Sync queries generally with other operations.
 
+	// roleMu serializes the writes of grouping rules, checked against the
+	// role hierarchy before they are applied.
+	roleMu sync.Mutex
+
 	metaMu         sync.RWMutex
 	meta           map[string]map[string]string
 	enforcers      sync.Map
 	enforcersState *adapter.BadgerStore
+	templates      *templateRegistry
+	expiries       *expiryRegistry
+	annotations    *annotationRegistry
+	labels         *labelRegistry
+	settings       *settingsRegistry
+	policySets     *policySetRegistry
+	idempotency    *idempotencyRegistry
+	versions       *versionRegistry
+	readOnly       *readOnlyMode
+	standby        *standbyRegistry
+	digests        *digestRegistry
+	leaders        *leaderTracker
+	replication    *replicationTracker
+	promoterDone   chan struct{}
+	disk           *diskWatchdog
+	snapshots      raft.SnapshotStore
+	diskDone       chan struct{}
+	cordon         *cordonState
+	cordonDone     chan struct{}
+	applyWatch     *applyWatchdog
+	applyWatchDone chan struct{}
+	clocks         *clockTracker
+	inflight       *inflightTracker
+	joinTokens     *joinTokens
+	cluster        *clusterIdentity
+	clusterIDDone  chan struct{}
+	framed         int32
+	framingDone    chan struct{}
+	membership     *membershipGuard
+	watchers       *watchHub
+	applyLatency   *latencyTracker
+	fsmWait        *latencyTracker
+	enforceLoad    *loadTracker
 	logger         *log.Logger
+	raftOutput     io.Writer
 
 	ShutdownOnRemove   bool
 	SnapshotThreshold  uint64
@@ -155,7 +232,57 @@ type Store struct {
 	HeartbeatTimeout   time.Duration
 	ElectionTimeout    time.Duration
 	ApplyTimeout       time.Duration
+	StepDownCooldown   time.Duration
 	RaftLogLevel       string
+	// SnapshotBandwidth limits the bytes per second of the snapshots
+	// streamed to followers, 0 for no limit.
+	SnapshotBandwidth int64
+	// AutoPromote joins voters as non-voters, and promotes them once they
+	// are at most PromoteMaxLag entries behind the leader.
+	AutoPromote   bool
+	PromoteMaxLag uint64
+	// MinQuorum is the number of voters removals may not go below, 0 for
+	// no minimum.
+	MinQuorum int
+	// MembershipStabilization is the time membership changes must be apart.
+	MembershipStabilization time.Duration
+	// MinFreeDisk is the free space of the data directory under which
+	// writes are refused, 0 for no minimum.
+	MinFreeDisk uint64
+	// PublishMetadata sends metadata of this node to the leader, while the
+	// node does not lead. It publishes the free space of followers, so that
+	// the leader refuses writes a quorum has no space for.
+	PublishMetadata func(md map[string]string) error
+	// ReportError receives the critical errors of the store, like a failed
+	// snapshot or a panic applying a log entry, e.g. to report them to an
+	// error tracker.
+	ReportError func(err error)
+	// FlushReports, if set, waits for up to timeout for the errors passed to
+	// ReportError to be sent, before a panic applying a log entry crashes
+	// the node.
+	FlushReports func(timeout time.Duration) bool
+	// AutoMigrate upgrades the data directory on open if it is of an older
+	// format, instead of failing.
+	AutoMigrate bool
+	// MaxRoleDepth is the number of links role hierarchies may not exceed,
+	// 0 for DefaultMaxRoleDepth.
+	MaxRoleDepth int
+	// DecisionTTL is how long clients are suggested to cache decisions, 0
+	// for DefaultDecisionTTL.
+	DecisionTTL time.Duration
+	// ApplyStallTimeout is how long the FSM may make no progress before it
+	// is deemed stalled, 0 not to watch it.
+	ApplyStallTimeout time.Duration
+	// OnApplyStall is called when the FSM is deemed stalled, e.g. to
+	// restart the node.
+	OnApplyStall func(ApplyStatus)
+	// MaxClockSkew is the clock offset between nodes over which their
+	// clocks are deemed skewed, 0 for DefaultMaxClockSkew.
+	MaxClockSkew time.Duration
+	// JoinToken is a token shared by the nodes, letting them join the
+	// cluster. Once set, or RequireJoinToken is, nodes need a join token.
+	JoinToken        string
+	RequireJoinToken bool
 
 	numTrailingLogs uint64
 }
@@ -164,6 +291,27 @@ func (s *Store) AuthType() auth.AuthType {
 	return s.authType
 }
 
+// SetRaftLogLevel changes the minimum level of Raft log messages.
+func (s *Store) SetRaftLogLevel(level string) error {
+	l := hclog.LevelFromString(level)
+	if l == hclog.NoLevel {
+		return fmt.Errorf("invalid log level %q", level)
+	}
+	s.RaftLogLevel = level
+	if s.raftLogger != nil {
+		s.raftLogger.SetLevel(l)
+	}
+	return nil
+}
+
+// UpdateCredential changes the password of an existing account.
+func (s *Store) UpdateCredential(username, password string) error {
+	if s.authCredStore == nil {
+		return auth.ErrUnsupportedAuthType
+	}
+	return s.authCredStore.Update(username, password)
+}
+
 // Check validates username and password
 func (s *Store) Check(username, password string) bool {
 	if s.authCredStore == nil {
@@ -187,14 +335,48 @@ func New(ln Listener, c *StoreConfig) *Store {
 	if logger == nil {
 		logger = log.New(os.Stderr, "[store] ", log.LstdFlags)
 	}
+	raftOutput := c.RaftLog
+	if raftOutput == nil {
+		raftOutput = os.Stderr
+	}
 
 	store := &Store{
 		ln:            ln,
+		raftTn:        c.RaftTransport,
+		memory:        c.Memory,
+		clock:         c.Clock,
 		raftDir:       c.Dir,
 		raftID:        c.ID,
 		meta:          make(map[string]map[string]string),
+		templates:     newTemplateRegistry(),
+		expiries:      newExpiryRegistry(),
+		annotations:   newAnnotationRegistry(),
+		labels:        newLabelRegistry(),
+		settings:      newSettingsRegistry(),
+		policySets:    newPolicySetRegistry(),
+		cordon:        newCordonState(),
+		applyWatch:    newApplyWatchdog(),
+		clocks:        newClockTracker(),
+		inflight:      newInflightTracker(),
+		joinTokens:    newJoinTokens(),
+		cluster:       newClusterIdentity(),
+		idempotency:   newIdempotencyRegistry(),
+		versions:      newVersionRegistry(),
+		readOnly:      newReadOnlyMode(),
+		standby:       newStandbyRegistry(),
+		digests:       newDigestRegistry(),
+		leaders:       newLeaderTracker(),
+		replication:   newReplicationTracker(),
+		membership:    &membershipGuard{},
+		watchers:      newWatchHub(),
+		disk:          newDiskWatchdog(),
+		applyLatency:  newLatencyTracker(),
+		fsmWait:       newLatencyTracker(),
+		enforceLoad:   newLoadTracker(),
 		logger:        logger,
+		raftOutput:    raftOutput,
 		ApplyTimeout:  applyTimeout,
+		AutoMigrate:   true,
 		authType:      c.AuthType,
 		authCredStore: c.CredentialsStore,
 	}
@@ -225,14 +407,24 @@ func (s *Store) Open(enableBootstrap bool) error {
 	s.openT = time.Now()
 	s.logger.Printf("opening store with node ID %s", s.raftID)
 
-	s.logger.Printf("ensuring directory at %s exists", s.raftDir)
-	err := os.MkdirAll(s.raftDir, 0755)
-	if err != nil {
-		return err
+	if s.memory == nil {
+		s.logger.Printf("ensuring directory at %s exists", s.raftDir)
+		err := os.MkdirAll(s.raftDir, 0755)
+		if err != nil {
+			return err
+		}
+		if err := s.checkDataFormat(); err != nil {
+			return err
+		}
+		if err := s.loadClusterID(); err != nil {
+			return fmt.Errorf("load cluster ID: %s", err)
+		}
 	}
 
 	// Create Raft-compatible network layer.
-	s.raftTn = raft.NewNetworkTransport(NewTransport(s.ln), connectionPoolCount, connectionTimeout, nil)
+	if s.raftTn == nil {
+		s.raftTn = raft.NewNetworkTransport(NewTransport(s.ln), connectionPoolCount, connectionTimeout, nil)
+	}
 
 	// Don't allow control over trailing logs directly, just implement a policy.
 	s.numTrailingLogs = uint64(float64(s.SnapshotThreshold) * trailingScale)
@@ -241,9 +433,9 @@ func (s *Store) Open(enableBootstrap bool) error {
 	config.LocalID = raft.ServerID(s.raftID)
 
 	// Create the snapshot store. This allows Raft to truncate the log.
-	snapshots, err := raft.NewFileSnapshotStore(s.raftDir, retainSnapshotCount, os.Stderr)
+	snapshots, err := s.openSnapshots()
 	if err != nil {
-		return fmt.Errorf("file snapshot store: %s", err)
+		return err
 	}
 	snaps, err := snapshots.List()
 	if err != nil {
@@ -251,15 +443,8 @@ func (s *Store) Open(enableBootstrap bool) error {
 	}
 	s.logger.Printf("%d pre-existing snapshots present", len(snaps))
 	s.snapsExistOnOpen = len(snaps) > 0
-	// TODO !important. stale read? restart after the node crashed
-	s.enforcersState, err = adapter.NewBadgerStore(filepath.Join(s.raftDir, stateDBPath))
-	if err != nil {
-		return fmt.Errorf("new state store: %s", err)
-	}
-	// Create the log store and stable store.
-	s.boltStore, err = rlog.NewLog(filepath.Join(s.raftDir, raftDBPath))
-	if err != nil {
-		return fmt.Errorf("new log store: %s", err)
+	if err := s.openStores(); err != nil {
+		return err
 	}
 	s.raftStable = s.boltStore
 	s.raftLog, err = raft.NewLogCache(raftLogCacheSize, s.boltStore)
@@ -275,7 +460,8 @@ func (s *Store) Open(enableBootstrap bool) error {
 		s.firstIdxOnOpen, s.lastIdxOnOpen, s.lastCommandIdxOnOpen)
 
 	// Instantiate the Raft system.
-	ra, err := raft.NewRaft(config, s, s.raftLog, s.raftStable, snapshots, s.raftTn)
+	trans := newReplicationTransport(s.raftTn, s.replication, s.SnapshotBandwidth, connectionTimeout)
+	ra, err := raft.NewRaft(config, s, s.raftLog, s.raftStable, snapshots, trans)
 	if err != nil {
 		return fmt.Errorf("new raft: %s", err)
 	}
@@ -296,6 +482,14 @@ func (s *Store) Open(enableBootstrap bool) error {
 	}
 
 	s.raft = ra
+	s.snapshots = snapshots
+	s.leaders.start(ra)
+	s.startPromoter()
+	s.startDiskWatchdog()
+	s.startCordonWatch()
+	s.startApplyWatchdog()
+	s.startClusterIDWatch()
+	s.startFramingWatch()
 
 	return nil
 }
@@ -305,6 +499,12 @@ func (s *Store) raftConfig() *raft.Config {
 	config := raft.DefaultConfig()
 	config.ShutdownOnRemove = s.ShutdownOnRemove
 	config.LogLevel = s.RaftLogLevel
+	s.raftLogger = hclog.New(&hclog.LoggerOptions{
+		Name:   "raft",
+		Level:  hclog.LevelFromString(s.RaftLogLevel),
+		Output: s.raftOutput,
+	})
+	config.Logger = s.raftLogger
 	if s.SnapshotThreshold != 0 {
 		config.SnapshotThreshold = s.SnapshotThreshold
 		config.TrailingLogs = s.numTrailingLogs
@@ -324,6 +524,42 @@ func (s *Store) raftConfig() *raft.Config {
 	return config
 }
 
+// openSnapshots returns the snapshot store of the node.
+func (s *Store) openSnapshots() (raft.SnapshotStore, error) {
+	if s.memory != nil {
+		return s.memory.snapshots, nil
+	}
+	snapshots, err := raft.NewFileSnapshotStore(s.raftDir, retainSnapshotCount, s.raftOutput)
+	if err != nil {
+		return nil, fmt.Errorf("file snapshot store: %s", err)
+	}
+	return snapshots, nil
+}
+
+// openStores opens the state store and the log store of the node.
+func (s *Store) openStores() error {
+	var err error
+	if s.memory != nil {
+		s.enforcersState, err = adapter.NewInMemoryBadgerStore()
+		if err != nil {
+			return fmt.Errorf("new state store: %s", err)
+		}
+		s.boltStore = s.memory.log
+		return nil
+	}
+	// TODO !important. stale read? restart after the node crashed
+	s.enforcersState, err = adapter.NewBadgerStore(filepath.Join(s.raftDir, stateDBPath))
+	if err != nil {
+		return fmt.Errorf("new state store: %s", err)
+	}
+	// Create the log store and stable store.
+	s.boltStore, err = rlog.NewLog(filepath.Join(s.raftDir, raftDBPath))
+	if err != nil {
+		return fmt.Errorf("new log store: %s", err)
+	}
+	return nil
+}
+
 // setLogInfo records some key indexs about the log.
 func (s *Store) setLogInfo() error {
 	var err error
@@ -342,8 +578,16 @@ func (s *Store) setLogInfo() error {
 	return nil
 }
 
-// Close closes the store. If wait is true, waits for a graceful shutdown.
+// Close closes the store: Raft is shut down first, then the log store and
+// finally the Raft transport. If wait is true, waits for a graceful shutdown.
 func (s *Store) Close(wait bool) error {
+	s.leaders.stop(s.raft)
+	s.stopPromoter()
+	s.stopDiskWatchdog()
+	s.stopCordonWatch()
+	s.stopApplyWatchdog()
+	s.stopClusterIDWatch()
+	s.stopFramingWatch()
 	f := s.raft.Shutdown()
 	if wait {
 		if e := f.(raft.Future); e.Error() != nil {
@@ -354,6 +598,12 @@ func (s *Store) Close(wait bool) error {
 	if err := s.boltStore.Close(); err != nil {
 		return err
 	}
+	if err := s.enforcersState.Close(); err != nil {
+		return err
+	}
+	if c, ok := s.raftTn.(raft.WithClose); ok {
+		return c.Close()
+	}
 	return nil
 }
 
@@ -407,6 +657,14 @@ func (s *Store) ID() string {
 	return s.raftID
 }
 
+// now returns the time of the clock of the store.
+func (s *Store) now() time.Time {
+	if s.clock != nil {
+		return s.clock()
+	}
+	return time.Now()
+}
+
 // LeaderAddr returns the address of the current leader. Returns a
 // blank string if there is no leader.
 func (s *Store) LeaderAddr() string {
@@ -440,12 +698,21 @@ func (s *Store) Nodes() ([]*Server, error) {
 
 	rs := f.Configuration().Servers
 	servers := make([]*Server, len(rs))
+	s.metaMu.RLock()
 	for i := range rs {
 		servers[i] = &Server{
-			ID:   string(rs[i].ID),
-			Addr: string(rs[i].Address),
+			ID:    string(rs[i].ID),
+			Addr:  string(rs[i].Address),
+			Voter: rs[i].Suffrage == raft.Voter,
+		}
+		if md, ok := s.meta[string(rs[i].ID)]; ok {
+			servers[i].Metadata = make(map[string]string, len(md))
+			for k, v := range md {
+				servers[i].Metadata[k] = v
+			}
 		}
 	}
+	s.metaMu.RUnlock()
 
 	sort.Sort(Servers(servers))
 	return servers, nil
@@ -491,6 +758,57 @@ func (s *Store) WaitForAppliedIndex(idx uint64, timeout time.Duration) error {
 	}
 }
 
+// apply applies cmd through Raft and waits for its result, giving up once
+// ctx is done. The command is not enqueued for longer than ApplyTimeout or
+// the deadline of ctx, whichever is sooner.
+func (s *Store) apply(ctx context.Context, cmd []byte) (raft.ApplyFuture, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if err := s.checkDiskSpace(cmd); err != nil {
+		return nil, err
+	}
+	cmd, err := s.versionCommand(cmd)
+	if err != nil {
+		return nil, err
+	}
+	cmd, idem, err := withIdempotency(ctx, cmd)
+	if err != nil {
+		return nil, err
+	}
+	start := time.Now()
+	f := s.raft.Apply(cmd, s.applyTimeout(ctx))
+	done := make(chan error, 1)
+	go func() {
+		err := f.Error()
+		if err == nil {
+			s.applyLatency.observe(time.Since(start))
+		}
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err == raft.ErrNotLeader {
+			return nil, ErrNotLeader
+		}
+		if err != nil {
+			return nil, err
+		}
+		// rejected writes are reported here, whatever response type the
+		// command has
+		if r, ok := f.Response().(*FSMResponse); ok && errors.Is(r.error, ErrReadOnly) {
+			return nil, r.error
+		}
+		recordIndex(ctx, f.Index())
+		if r, ok := f.Response().(replayable); ok && r.wasReplayed() {
+			idem.markReplayed()
+		}
+		return f, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
 // Stats returns stats for the store.
 func (s *Store) Stats() (map[string]interface{}, error) {
 	nodes, err := s.Nodes()
@@ -511,14 +829,16 @@ func (s *Store) Stats() (map[string]interface{}, error) {
 			raftStats[k] = s
 		}
 	}
-	raftStats["log_size"], err = s.logSize()
-	if err != nil {
-		return nil, err
-	}
-
-	dirSz, err := dirSize(s.raftDir)
-	if err != nil {
-		return nil, err
+	var dirSz int64
+	if s.memory == nil {
+		raftStats["log_size"], err = s.logSize()
+		if err != nil {
+			return nil, err
+		}
+		dirSz, err = dirSize(s.raftDir)
+		if err != nil {
+			return nil, err
+		}
 	}
 
 	status := map[string]interface{}{
@@ -530,26 +850,63 @@ func (s *Store) Stats() (map[string]interface{}, error) {
 			"addr":    s.LeaderAddr(),
 		},
 		"apply_timeout":      s.ApplyTimeout.String(),
+		"apply_stall":        s.ApplyStallTimeout.String(),
 		"heartbeat_timeout":  s.HeartbeatTimeout.String(),
 		"election_timeout":   s.ElectionTimeout.String(),
 		"snapshot_threshold": s.SnapshotThreshold,
 		"snapshot_interval":  s.SnapshotInterval,
+		"snapshot_bandwidth": s.SnapshotBandwidth,
+		"auto_promote":       s.AutoPromote,
+		"min_quorum":         s.MinQuorum,
+		"cluster_id":         s.ClusterID(),
+		"join_token":         s.RequireJoinToken || s.JoinToken != "",
+		"max_role_depth":     s.maxRoleDepth(),
+		"decision_ttl":       s.decisionTTL().String(),
+		"cordoned":           s.cordon.get().Cordoned,
+		"apply":              s.ApplyStatus(),
+		"clock":              s.ClockStatus(),
+		"in_flight":          s.InFlight(),
+		"stabilization":      s.MembershipStabilization.String(),
+		"version":            Version,
+		"fsm_version":        FSMVersion,
 		"trailing_logs":      s.numTrailingLogs,
 		"metadata":           s.meta,
 		"nodes":              nodes,
 		"dir":                s.raftDir,
 		"dir_size":           dirSz,
+		"read_only":          s.ReadOnly(),
+		"apply_latency":      s.ApplyLatency().String(),
+		"fsm_wait":           s.FSMWait().String(),
+		"disk":               s.DiskStatus(),
 	}
 	return status, nil
 }
 
 // Join joins a node, identified by id and located at addr, to this store.
 // The node must be ready to respond to Raft communications at that address.
+// Nodes of another cluster, per their ClusterIDKey metadata, are refused.
+// Nodes repairing their state, per their RepairKey metadata, are removed
+// from the cluster and join it again as non-voters, promoted once they
+// caught up.
 func (s *Store) Join(id, addr string, voter bool, metadata map[string]string) error {
 	s.logger.Printf("received request to join node at %s", addr)
 	if s.raft.State() != raft.Leader {
 		return ErrNotLeader
 	}
+	if err := s.CheckClusterID(metadata[ClusterIDKey]); err != nil {
+		s.logger.Printf("refusing to join node %s at %s: %s", id, addr, err.Error())
+		return err
+	}
+	repair := metadata[RepairKey] != ""
+	if repair {
+		md := make(map[string]string)
+		for k, v := range metadata {
+			if k != RepairKey {
+				md[k] = v
+			}
+		}
+		metadata = md
+	}
 
 	configFuture := s.raft.GetConfiguration()
 	if err := configFuture.Error(); err != nil {
@@ -557,36 +914,58 @@ func (s *Store) Join(id, addr string, voter bool, metadata map[string]string) er
 		return err
 	}
 
+	var replaced string
 	for _, srv := range configFuture.Configuration().Servers {
 		// If a node already exists with either the joining node's ID or address,
 		// that node may need to be removed from the config first.
 		if srv.ID == raft.ServerID(id) || srv.Address == raft.ServerAddress(addr) {
 			// However if *both* the ID and the address are the same, the no
 			// join is actually needed.
-			if srv.Address == raft.ServerAddress(addr) && srv.ID == raft.ServerID(id) {
-				s.logger.Printf("node %s at %s already member of cluster, ignoring join request", id, addr)
-				return nil
+			if srv.Address == raft.ServerAddress(addr) && srv.ID == raft.ServerID(id) && !repair {
+				s.logger.Printf("node %s at %s already member of cluster, only updating its metadata", id, addr)
+				return s.setMetadata(id, metadata)
 			}
+			replaced = id
+		}
+	}
 
-			if err := s.remove(id); err != nil {
+	if voter && (s.AutoPromote || repair) {
+		// The node votes once it caught up, so that it doesn't weigh on
+		// the quorum meanwhile, nor votes with a log it lost.
+		voter = false
+		md := map[string]string{}
+		for k, v := range metadata {
+			md[k] = v
+		}
+		md[PromotionKey] = promotionPending
+		metadata = md
+	}
+
+	err := s.changeMembership(id, voter, replaced, func() error {
+		if replaced != "" {
+			if err := s.remove(replaced); err != nil {
 				s.logger.Printf("failed to remove node: %v", err)
 				return err
 			}
 		}
-	}
 
-	var f raft.IndexFuture
-	if voter {
-		f = s.raft.AddVoter(raft.ServerID(id), raft.ServerAddress(addr), 0, 0)
-	} else {
+		var f raft.IndexFuture
+		if voter {
+			f = s.raft.AddVoter(raft.ServerID(id), raft.ServerAddress(addr), 0, 0)
+		} else {
 
-		f = s.raft.AddNonvoter(raft.ServerID(id), raft.ServerAddress(addr), 0, 0)
-	}
-	if e := f.(raft.Future); e.Error() != nil {
-		if e.Error() == raft.ErrNotLeader {
-			return ErrNotLeader
+			f = s.raft.AddNonvoter(raft.ServerID(id), raft.ServerAddress(addr), 0, 0)
 		}
-		return e.Error()
+		if e := f.(raft.Future); e.Error() != nil {
+			if e.Error() == raft.ErrNotLeader {
+				return ErrNotLeader
+			}
+			return e.Error()
+		}
+		return nil
+	})
+	if err != nil {
+		return err
 	}
 
 	if err := s.setMetadata(id, metadata); err != nil {
@@ -600,7 +979,10 @@ func (s *Store) Join(id, addr string, voter bool, metadata map[string]string) er
 // Remove removes a node from the store, specified by ID.
 func (s *Store) Remove(id string) error {
 	s.logger.Printf("received request to remove node %s", id)
-	if err := s.remove(id); err != nil {
+	if s.raft.State() != raft.Leader {
+		return ErrNotLeader
+	}
+	if err := s.changeMembership("", false, id, func() error { return s.remove(id) }); err != nil {
 		s.logger.Printf("failed to remove node %s: %s", id, err.Error())
 		return err
 	}
@@ -651,6 +1033,46 @@ func (s *Store) remove(id string) error {
 	return nil
 }
 
+// TransferLeadership hands leadership over to the node with the given ID, or
+// to the most up-to-date voter if id is empty.
+func (s *Store) TransferLeadership(id string) error {
+	s.logger.Printf("received request to transfer leadership to node %q", id)
+	if s.raft.State() != raft.Leader {
+		return ErrNotLeader
+	}
+
+	var f raft.Future
+	if id == "" {
+		f = s.raft.LeadershipTransfer()
+	} else {
+		configFuture := s.raft.GetConfiguration()
+		if err := configFuture.Error(); err != nil {
+			return err
+		}
+		var target *raft.Server
+		for _, srv := range configFuture.Configuration().Servers {
+			if srv.ID == raft.ServerID(id) {
+				target = &srv
+				break
+			}
+		}
+		if target == nil {
+			return ErrNodeNotExist
+		}
+		f = s.raft.LeadershipTransferToServer(target.ID, target.Address)
+	}
+	if err := f.Error(); err != nil {
+		if err == raft.ErrNotLeader {
+			return ErrNotLeader
+		}
+		s.logger.Printf("failed to transfer leadership: %s", err.Error())
+		return err
+	}
+
+	s.logger.Printf("leadership transferred from node %s", s.raftID)
+	return nil
+}
+
 // Metadata returns the value for a given key, for a given node ID.
 func (s *Store) Metadata(id, key string) string {
 	s.metaMu.RLock()
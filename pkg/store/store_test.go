@@ -18,17 +18,30 @@
 package store
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
+	"math"
 	"net"
+	"net/url"
 	"os"
 	"path/filepath"
 	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	rlog "github.com/casbin/casbin-mesh/pkg/log"
 	"github.com/casbin/casbin-mesh/proto/command"
+	"github.com/casbin/casbin/v2"
+	"github.com/casbin/casbin/v2/util"
+	"github.com/golang/protobuf/proto"
+	"github.com/hashicorp/raft"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -79,6 +92,23 @@ func Test_SingleNodeCreateNS(t *testing.T) {
 	assert.Equal(t, nil, err)
 }
 
+func Test_SingleNodeCanceledContext(t *testing.T) {
+	s := mustNewStore()
+	defer os.RemoveAll(s.Path())
+
+	if err := s.Open(true); err != nil {
+		t.Fatalf("failed to open single-node store: %s", err.Error())
+	}
+	defer s.Close(true)
+	s.WaitForLeader(10 * time.Second)
+	ctx, cancel := context.WithCancel(context.TODO())
+	cancel()
+	err := s.CreateNamespace(ctx, "default")
+	assert.Equal(t, context.Canceled, err)
+	_, err = s.ListNamespace(ctx)
+	assert.Equal(t, context.Canceled, err)
+}
+
 func Test_SingleNodeSetModel(t *testing.T) {
 	s := mustNewStore()
 	defer os.RemoveAll(s.Path())
@@ -158,75 +188,344 @@ func Test_SingleNodeEnforce(t *testing.T) {
 	}
 }
 
-func Test_MultiNodeJoinRemove(t *testing.T) {
-	s0 := mustNewStore()
-	defer os.RemoveAll(s0.Path())
-	if err := s0.Open(true); err != nil {
-		t.Fatalf("failed to open node for multi-node test: %s", err.Error())
+func Test_SingleNodeNamespaceSnapshot(t *testing.T) {
+	s := mustNewStore()
+	defer os.RemoveAll(s.Path())
+	if err := s.Open(true); err != nil {
+		t.Fatalf("failed to open single-node store: %s", err.Error())
 	}
-	defer s0.Close(true)
-	s0.WaitForLeader(10 * time.Second)
+	defer s.Close(true)
+	s.WaitForLeader(10 * time.Second)
+	err := s.CreateNamespace(context.TODO(), "default")
+	assert.Equal(t, nil, err)
+	err = s.SetModelFromString(context.TODO(), "default", modelText)
+	assert.Equal(t, nil, err)
+	_, err = s.AddPolicies(context.TODO(), "default", "p", "p", [][]string{
+		{"alice", "data1", "read"},
+	})
+	assert.Equal(t, nil, err)
+	_, err = s.AddPolicies(context.TODO(), "default", "g", "g", [][]string{
+		{"alice", "data2_admin"},
+	})
+	assert.Equal(t, nil, err)
 
-	s1 := mustNewStore()
-	defer os.RemoveAll(s1.Path())
-	if err := s1.Open(false); err != nil {
-		t.Fatalf("failed to open node for multi-node test: %s", err.Error())
+	model, policies, index, err := s.NamespaceSnapshot(context.TODO(), "default")
+	assert.Equal(t, nil, err)
+	assert.NotEqual(t, "", model)
+	assert.NotEqual(t, uint64(0), index)
+	rules := make(map[string][][]string)
+	for _, p := range policies {
+		rules[p.Sec+"/"+p.PType] = command.ToStringArray(p.Rules)
 	}
-	defer s1.Close(true)
+	assert.Equal(t, [][]string{{"alice", "data1", "read"}}, rules["p/p"])
+	assert.Equal(t, [][]string{{"alice", "data2_admin"}}, rules["g/g"])
 
-	// Get sorted list of cluster nodes.
-	storeNodes := []string{s0.ID(), s1.ID()}
-	sort.StringSlice(storeNodes).Sort()
+	_, _, _, err = s.NamespaceSnapshot(context.TODO(), "missing")
+	assert.Equal(t, NamespaceNotExist, err)
+}
 
-	// Join the second node to the first.
-	if err := s0.Join(s1.ID(), s1.Addr(), true, nil); err != nil {
-		t.Fatalf("failed to join to node at %s: %s", s0.Addr(), err.Error())
+func Test_SingleNodeTemplates(t *testing.T) {
+	s := mustNewStore()
+	defer os.RemoveAll(s.Path())
+	if err := s.Open(true); err != nil {
+		t.Fatalf("failed to open single-node store: %s", err.Error())
 	}
+	defer s.Close(true)
+	s.WaitForLeader(10 * time.Second)
+	err := s.CreateNamespace(context.TODO(), "default")
+	assert.Equal(t, nil, err)
+	err = s.SetModelFromString(context.TODO(), "default", modelText)
+	assert.Equal(t, nil, err)
 
-	s1.WaitForLeader(10 * time.Second)
+	tpl := &command.Template{Name: "project", Params: []string{"project"}, Policies: []*command.PolicyRules{
+		{Sec: "p", PType: "p", Rules: command.NewStringArray([][]string{{"${project}-admin", "${project}", "write"}})},
+	}}
+	version, err := s.SetTemplate(context.TODO(), tpl)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, uint64(1), version)
+	version, err = s.SetTemplate(context.TODO(), tpl)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, uint64(2), version)
+
+	err = s.SetTemplateInstance(context.TODO(), &command.TemplateInstance{Namespace: "missing", Name: "alpha", Template: "project"})
+	assert.Equal(t, NamespaceNotExist, err)
+	err = s.SetTemplateInstance(context.TODO(), &command.TemplateInstance{Namespace: "default", Name: "alpha", Template: "missing"})
+	assert.Equal(t, TemplateNotExist, err)
+	err = s.SetTemplateInstance(context.TODO(), &command.TemplateInstance{Namespace: "default", Name: "alpha", Template: "project",
+		Version: 2, Vars: map[string]string{"project": "alpha"}})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, TemplateInUse, s.DeleteTemplate(context.TODO(), "project"))
 
-	// Check leader state on follower.
-	if got, exp := s1.LeaderAddr(), s0.Addr(); got != exp {
-		t.Fatalf("wrong leader address returned, got: %s, exp %s", got, exp)
+	// Templates and instances survive a snapshot.
+	f, err := s.Snapshot()
+	if err != nil {
+		t.Fatalf("failed to snapshot node: %s", err.Error())
 	}
-	id, err := s1.LeaderID()
+	snapDir := mustTempDir()
+	defer os.RemoveAll(snapDir)
+	snapFile, err := os.Create(filepath.Join(snapDir, "snapshot"))
 	if err != nil {
-		t.Fatalf("failed to retrieve leader ID: %s", err.Error())
+		t.Fatalf("failed to create snapshot file: %s", err.Error())
 	}
-	if got, exp := id, s0.raftID; got != exp {
-		t.Fatalf("wrong leader ID returned, got: %s, exp %s", got, exp)
+	if err := f.Persist(&mockSnapshotSink{snapFile}); err != nil {
+		t.Fatalf("failed to persist snapshot to disk: %s", err.Error())
 	}
-
-	nodes, err := s0.Nodes()
+	snapFile, err = os.Open(filepath.Join(snapDir, "snapshot"))
 	if err != nil {
-		t.Fatalf("failed to get nodes: %s", err.Error())
+		t.Fatalf("failed to open snapshot file: %s", err.Error())
 	}
-
-	if len(nodes) != len(storeNodes) {
-		t.Fatalf("size of cluster is not correct")
+	if err := s.Restore(snapFile); err != nil {
+		t.Fatalf("failed to restore snapshot from disk: %s", err.Error())
 	}
-	if storeNodes[0] != nodes[0].ID || storeNodes[1] != nodes[1].ID {
-		t.Fatalf("cluster does not have correct nodes")
+
+	templates, instances, err := s.ListTemplates(context.TODO())
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 1, len(templates))
+	assert.Equal(t, uint64(2), templates[0].Version)
+	assert.Equal(t, 1, len(instances))
+	assert.Equal(t, "alpha", instances[0].Vars["project"])
+
+	assert.Equal(t, nil, s.DeleteTemplateInstance(context.TODO(), "default", "alpha"))
+	assert.Equal(t, TemplateInstanceNotExist, s.DeleteTemplateInstance(context.TODO(), "default", "alpha"))
+	assert.Equal(t, nil, s.DeleteTemplate(context.TODO(), "project"))
+	assert.Equal(t, TemplateNotExist, s.DeleteTemplate(context.TODO(), "project"))
+}
+
+func Test_SingleNodeExpirePolicies(t *testing.T) {
+	s := mustNewStore()
+	defer os.RemoveAll(s.Path())
+	if err := s.Open(true); err != nil {
+		t.Fatalf("failed to open single-node store: %s", err.Error())
 	}
+	defer s.Close(true)
+	s.WaitForLeader(10 * time.Second)
+	err := s.CreateNamespace(context.TODO(), "default")
+	assert.Equal(t, nil, err)
+	err = s.SetModelFromString(context.TODO(), "default", modelText)
+	assert.Equal(t, nil, err)
 
-	// Remove a node.
-	if err := s0.Remove(s1.ID()); err != nil {
-		t.Fatalf("failed to remove %s from cluster: %s", s1.ID(), err.Error())
+	now := time.Unix(1000, 0)
+	_, err = s.AddPolicies(context.TODO(), "default", "p", "p", [][]string{{"alice", "data1", "read"}})
+	assert.Equal(t, nil, err)
+	_, err = s.AddExpiringPolicies(context.TODO(), "default", "p", "p", [][]string{{"bob", "data2", "write"}}, now.Add(time.Hour))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 0, len(s.DuePolicies(now)))
+
+	// expired rules are left out before they are removed
+	ok, err := s.Enforce(context.TODO(), "default", 0, 0, "bob", "data2", "write")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, false, ok)
+	// adding a permanent rule again with an expiry keeps it permanent
+	_, err = s.AddExpiringPolicies(context.TODO(), "default", "p", "p", [][]string{{"alice", "data1", "read"}}, now)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 0, len(s.DuePolicies(now)))
+
+	due := s.DuePolicies(now.Add(time.Hour))
+	assert.Equal(t, []DuePolicies{{Namespace: "default", Sec: "p", PType: "p"}}, due)
+	rules, err := s.ExpirePolicies(context.TODO(), "default", "p", "p", now)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 0, len(rules))
+	rules, err = s.ExpirePolicies(context.TODO(), "default", "p", "p", now.Add(time.Hour))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, [][]string{{"bob", "data2", "write"}}, rules)
+	assert.Equal(t, 0, len(s.DuePolicies(now.Add(time.Hour))))
+
+	ok, err = s.Enforce(context.TODO(), "default", 0, 0, "bob", "data2", "write")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, false, ok)
+	ok, err = s.Enforce(context.TODO(), "default", 0, 0, "alice", "data1", "read")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, true, ok)
+
+	// Re-adding a rule without expiry makes it permanent.
+	_, err = s.AddExpiringPolicies(context.TODO(), "default", "p", "p", [][]string{{"bob", "data2", "write"}}, now)
+	assert.Equal(t, nil, err)
+	_, err = s.AddPolicies(context.TODO(), "default", "p", "p", [][]string{{"bob", "data2", "write"}})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 0, len(s.DuePolicies(now)))
+}
+
+func Test_SingleNodeSchedulePolicies(t *testing.T) {
+	s := mustNewStore()
+	defer os.RemoveAll(s.Path())
+	if err := s.Open(true); err != nil {
+		t.Fatalf("failed to open single-node store: %s", err.Error())
 	}
+	defer s.Close(true)
+	s.WaitForLeader(10 * time.Second)
+	err := s.CreateNamespace(context.TODO(), "default")
+	assert.Equal(t, nil, err)
+	err = s.SetModelFromString(context.TODO(), "default", modelText)
+	assert.Equal(t, nil, err)
 
-	nodes, err = s0.Nodes()
-	if err != nil {
-		t.Fatalf("failed to get nodes post remove: %s", err.Error())
+	_, err = s.AddScheduledPolicies(context.TODO(), "default", "p", "p", [][]string{{"bob", "data2", "write"}},
+		&command.Schedule{Hours: "9-17"})
+	assert.NotEqual(t, nil, err)
+
+	now := time.Now()
+	window := &command.Schedule{NotBefore: now.Add(time.Hour).Unix(), NotAfter: now.Add(2 * time.Hour).Unix()}
+	rules, err := s.AddScheduledPolicies(context.TODO(), "default", "p", "p", [][]string{{"bob", "data2", "write"}}, window)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 0, len(rules))
+	ok, err := s.Enforce(context.TODO(), "default", 0, 0, "bob", "data2", "write")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, false, ok)
+	assert.Equal(t, 0, len(s.ScheduleChanges(now)))
+
+	at := now.Add(90 * time.Minute)
+	changes := s.ScheduleChanges(at)
+	assert.Equal(t, []ScheduleChange{{Namespace: "default", Sec: "p", PType: "p", Activate: true}}, changes)
+	rules, err = s.SchedulePolicies(context.TODO(), "default", "p", "p", at, true)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, [][]string{{"bob", "data2", "write"}}, rules)
+	ok, err = s.Enforce(context.TODO(), "default", 0, 0, "bob", "data2", "write")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, true, ok)
+	assert.Equal(t, 0, len(s.ScheduleChanges(at)))
+
+	// The rule is removed for good once the window is over.
+	end := now.Add(2 * time.Hour)
+	assert.Equal(t, 0, len(s.ScheduleChanges(end)))
+	rules, err = s.ExpirePolicies(context.TODO(), "default", "p", "p", end)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, [][]string{{"bob", "data2", "write"}}, rules)
+	ok, err = s.Enforce(context.TODO(), "default", 0, 0, "bob", "data2", "write")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, false, ok)
+
+	// Removing an inactive rule forgets its schedule.
+	_, err = s.AddScheduledPolicies(context.TODO(), "default", "p", "p", [][]string{{"bob", "data2", "write"}}, window)
+	assert.Equal(t, nil, err)
+	_, err = s.RemovePolicies(context.TODO(), "default", "p", "p", [][]string{{"bob", "data2", "write"}})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 0, len(s.ScheduleChanges(at)))
+}
+
+func Test_SingleNodeAnnotations(t *testing.T) {
+	s := mustNewStore()
+	defer os.RemoveAll(s.Path())
+	if err := s.Open(true); err != nil {
+		t.Fatalf("failed to open single-node store: %s", err.Error())
 	}
-	if len(nodes) != 1 {
-		t.Fatalf("size of cluster is not correct post remove")
+	defer s.Close(true)
+	s.WaitForLeader(10 * time.Second)
+	err := s.CreateNamespace(context.TODO(), "default")
+	assert.Equal(t, nil, err)
+	err = s.SetModelFromString(context.TODO(), "default", modelText)
+	assert.Equal(t, nil, err)
+
+	_, err = s.AddPolicies(context.TODO(), "default", "p", "p", [][]string{{"alice", "data1", "read"}, {"bob", "data2", "write"}})
+	assert.Equal(t, nil, err)
+	annotation := &command.Annotation{Owner: "team-a", Ticket: "OPS-1", Labels: map[string]string{"env": "prod"}}
+	rules, err := s.AnnotatePolicies(context.TODO(), "default", "p", "p",
+		[][]string{{"alice", "data1", "read"}, {"carol", "data3", "read"}}, annotation)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, [][]string{{"alice", "data1", "read"}}, rules)
+
+	annotations, err := s.ListAnnotations(context.TODO(), "default")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 1, len(annotations))
+	assert.Equal(t, []string{"alice", "data1", "read"}, annotations[0].Rule)
+	assert.Equal(t, "team-a", annotations[0].Annotation.Owner)
+	assert.Equal(t, "prod", annotations[0].Annotation.Labels["env"])
+
+	// Updating a rule keeps its annotation.
+	_, err = s.UpdatePolicies(context.TODO(), "default", "p", "p",
+		[][]string{{"alice", "data1", "write"}}, [][]string{{"alice", "data1", "read"}})
+	assert.Equal(t, nil, err)
+	annotations, err = s.ListAnnotations(context.TODO(), "default")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 1, len(annotations))
+	assert.Equal(t, []string{"alice", "data1", "write"}, annotations[0].Rule)
+
+	_, err = s.RemovePolicies(context.TODO(), "default", "p", "p", [][]string{{"alice", "data1", "write"}})
+	assert.Equal(t, nil, err)
+	annotations, err = s.ListAnnotations(context.TODO(), "default")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 0, len(annotations))
+
+	_, err = s.ListAnnotations(context.TODO(), "missing")
+	assert.Equal(t, NamespaceNotExist, err)
+}
+
+func Test_SingleNodePagePolicies(t *testing.T) {
+	s := mustNewStore()
+	defer os.RemoveAll(s.Path())
+	if err := s.Open(true); err != nil {
+		t.Fatalf("failed to open single-node store: %s", err.Error())
 	}
-	if s0.ID() != nodes[0].ID {
-		t.Fatalf("cluster does not have correct nodes post remove")
+	defer s.Close(true)
+	s.WaitForLeader(10 * time.Second)
+	err := s.CreateNamespace(context.TODO(), "default")
+	assert.Equal(t, nil, err)
+	err = s.SetModelFromString(context.TODO(), "default", modelText)
+	assert.Equal(t, nil, err)
+
+	_, err = s.AddPolicies(context.TODO(), "default", "p", "p", [][]string{{"alice", "data1", "read"}, {"bob", "data2", "write"}, {"carol", "data3", "read"}})
+	assert.Equal(t, nil, err)
+	_, err = s.AddPolicies(context.TODO(), "default", "g", "g", [][]string{{"alice", "admin"}})
+	assert.Equal(t, nil, err)
+
+	lines, next, err := s.PagePolicies(context.TODO(), "default", "p", "", 2)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, [][]string{{"p", "alice", "data1", "read"}, {"p", "bob", "data2", "write"}}, lines)
+	assert.NotEqual(t, "", next)
+	lines, next, err = s.PagePolicies(context.TODO(), "default", "p", next, 2)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, [][]string{{"p", "carol", "data3", "read"}}, lines)
+	assert.Equal(t, "", next)
+
+	lines, _, err = s.PagePolicies(context.TODO(), "default", "g", "", 2)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, [][]string{{"g", "alice", "admin"}}, lines)
+
+	_, _, err = s.PagePolicies(context.TODO(), "missing", "p", "", 2)
+	assert.Equal(t, NamespaceNotExist, err)
+}
+
+func Test_ScheduleActive(t *testing.T) {
+	// Monday 2021-03-01 22:30 UTC
+	monday := time.Date(2021, 3, 1, 22, 30, 0, 0, time.UTC).Unix()
+	tests := []struct {
+		schedule *command.Schedule
+		now      int64
+		active   bool
+	}{
+		{&command.Schedule{}, monday, true},
+		{&command.Schedule{NotBefore: monday + 1}, monday, false},
+		{&command.Schedule{NotAfter: monday}, monday, false},
+		{&command.Schedule{Days: []string{"Mon"}}, monday, true},
+		{&command.Schedule{Days: []string{"tue"}}, monday, false},
+		{&command.Schedule{Hours: "09:00-17:00"}, monday, false},
+		{&command.Schedule{Hours: "22:00-06:00", Days: []string{"mon"}}, monday, true},
+		// 01:30 on tuesday is within the window started on monday
+		{&command.Schedule{Hours: "22:00-06:00", Days: []string{"mon"}}, monday + 3*3600, true},
+		{&command.Schedule{Hours: "22:00-06:00", Days: []string{"tue"}}, monday + 3*3600, false},
+		// 07:30 on tuesday in Tokyo
+		{&command.Schedule{Hours: "07:00-08:00", Days: []string{"tue"}, Timezone: "Asia/Tokyo"}, monday, true},
+	}
+	for i, tt := range tests {
+		if err := ValidateSchedule(tt.schedule); err != nil {
+			t.Fatalf("test %d: invalid schedule: %s", i, err.Error())
+		}
+		if got := scheduleActive(tt.schedule, tt.now); got != tt.active {
+			t.Fatalf("test %d: got active %v, exp %v", i, got, tt.active)
+		}
+	}
+	for _, sch := range []*command.Schedule{
+		{Days: []string{"monday"}},
+		{Hours: "17:00"},
+		{Hours: "25:00-26:00"},
+		{Timezone: "Mars/Olympus"},
+		{NotBefore: 2, NotAfter: 1},
+	} {
+		if err := ValidateSchedule(sch); err == nil {
+			t.Fatalf("expected error for schedule %v", sch)
+		}
 	}
 }
 
-func Test_MultiNodeJoinNonVoterRemove(t *testing.T) {
+func Test_MultiNodeJoinRemove(t *testing.T) {
 	s0 := mustNewStore()
 	defer os.RemoveAll(s0.Path())
 	if err := s0.Open(true); err != nil {
@@ -247,7 +546,7 @@ func Test_MultiNodeJoinNonVoterRemove(t *testing.T) {
 	sort.StringSlice(storeNodes).Sort()
 
 	// Join the second node to the first.
-	if err := s0.Join(s1.ID(), s1.Addr(), false, nil); err != nil {
+	if err := s0.Join(s1.ID(), s1.Addr(), true, nil); err != nil {
 		t.Fatalf("failed to join to node at %s: %s", s0.Addr(), err.Error())
 	}
 
@@ -277,7 +576,7 @@ func Test_MultiNodeJoinNonVoterRemove(t *testing.T) {
 		t.Fatalf("cluster does not have correct nodes")
 	}
 
-	// Remove the non-voter.
+	// Remove a node.
 	if err := s0.Remove(s1.ID()); err != nil {
 		t.Fatalf("failed to remove %s from cluster: %s", s1.ID(), err.Error())
 	}
@@ -294,7 +593,7 @@ func Test_MultiNodeJoinNonVoterRemove(t *testing.T) {
 	}
 }
 
-func Test_MultiNodeEnforce(t *testing.T) {
+func Test_MultiNodeTransferLeadership(t *testing.T) {
 	s0 := mustNewStore()
 	defer os.RemoveAll(s0.Path())
 	if err := s0.Open(true); err != nil {
@@ -310,67 +609,30 @@ func Test_MultiNodeEnforce(t *testing.T) {
 	}
 	defer s1.Close(true)
 
-	s2 := mustNewStore()
-	defer os.RemoveAll(s2.Path())
-	if err := s2.Open(false); err != nil {
-		t.Fatalf("failed to open node for multi-node test: %s", err.Error())
-	}
-	defer s2.Close(true)
-
-	// Join the second node to the first as a voting node.
 	if err := s0.Join(s1.ID(), s1.Addr(), true, nil); err != nil {
 		t.Fatalf("failed to join to node at %s: %s", s0.Addr(), err.Error())
 	}
+	s1.WaitForLeader(10 * time.Second)
 
-	// Join the third node to the first as a non-voting node.
-	if err := s0.Join(s2.ID(), s2.Addr(), false, nil); err != nil {
-		t.Fatalf("failed to join to node at %s: %s", s0.Addr(), err.Error())
+	if err := s1.TransferLeadership(s0.ID()); err != ErrNotLeader {
+		t.Fatalf("expected ErrNotLeader from follower, got %v", err)
 	}
-
-	err := s0.CreateNamespace(context.TODO(), "default")
-	assert.Equal(t, nil, err)
-	err = s0.SetModelFromString(context.TODO(), "default", modelText)
-	assert.Equal(t, nil, err)
-
-	// Wait until the 4 log entries have been applied to the voting follower,
-	// and then query.
-	if err := s1.WaitForAppliedIndex(4, 5*time.Second); err != nil {
-		t.Fatalf("error waiting for follower to apply index: %s:", err.Error())
+	if err := s0.TransferLeadership("unknown"); err != ErrNodeNotExist {
+		t.Fatalf("expected ErrNodeNotExist, got %v", err)
 	}
-	_, err = s0.AddPolicies(context.TODO(), "default", "p", "p", [][]string{
-		{"alice", "data1", "read"},
-		{"bob", "data2", "write"},
-		{"data2_admin", "data2", "read"},
-		{"data2_admin", "data2", "write"},
-	})
-	assert.Equal(t, nil, err)
-	_, err = s0.AddPolicies(context.TODO(), "default", "g", "g", [][]string{
-		{"alice", "data2_admin"},
-	})
-	assert.Equal(t, nil, err)
-
-	// Wait until the 2 log entries have been applied to the voting follower,
-	// and then query.
-	if err := s1.WaitForAppliedIndex(6, 5*time.Second); err != nil {
-		t.Fatalf("error waiting for follower to apply index: %s:", err.Error())
+	if err := s0.TransferLeadership(s1.ID()); err != nil {
+		t.Fatalf("failed to transfer leadership: %s", err.Error())
 	}
 
-	_, err = s1.Enforce(context.TODO(), "default", command.EnforcePayload_QUERY_REQUEST_LEVEL_WEAK, 0, "alice", "data1", "read")
-	if err == nil {
-		t.Fatalf("successfully queried non-leader node")
-	}
-	_, err = s1.Enforce(context.TODO(), "default", command.EnforcePayload_QUERY_REQUEST_LEVEL_STRONG, 0, "alice", "data1", "read")
-	if err == nil {
-		t.Fatalf("successfully queried non-leader node [strong]")
+	if _, err := s1.WaitForLeader(10 * time.Second); err != nil {
+		t.Fatalf("no leader after transfer: %s", err.Error())
 	}
-	r3, err := s1.Enforce(context.TODO(), "default", command.EnforcePayload_QUERY_REQUEST_LEVEL_NONE, 0, "alice", "data1", "read")
-	if err != nil {
-		t.Fatalf("failed to query follower node: %s", err.Error())
+	if !s1.IsLeader() {
+		t.Fatalf("leadership not transferred to %s", s1.ID())
 	}
-	assert.Equal(t, r3, true)
 }
 
-func Test_MultiNodeExecuteEnforceFreshness(t *testing.T) {
+func Test_MultiNodeStepDown(t *testing.T) {
 	s0 := mustNewStore()
 	defer os.RemoveAll(s0.Path())
 	if err := s0.Open(true); err != nil {
@@ -386,7 +648,365 @@ func Test_MultiNodeExecuteEnforceFreshness(t *testing.T) {
 	}
 	defer s1.Close(true)
 
-	s2 := mustNewStore()
+	if err := s0.Join(s1.ID(), s1.Addr(), true, nil); err != nil {
+		t.Fatalf("failed to join to node at %s: %s", s0.Addr(), err.Error())
+	}
+	s1.WaitForLeader(10 * time.Second)
+
+	info, err := s1.Leader()
+	if err != nil {
+		t.Fatalf("failed to get leader: %s", err.Error())
+	}
+	if info.NodeID != s0.ID() || info.Since.IsZero() || info.Term == 0 {
+		t.Fatalf("unexpected leader %+v", info)
+	}
+
+	s0.StepDownCooldown = time.Hour
+	if err := s0.StepDown(""); !errors.Is(err, ErrStepDownCooldown) {
+		t.Fatalf("expected ErrStepDownCooldown, got %v", err)
+	}
+	if err := s1.StepDown(""); err != ErrNotLeader {
+		t.Fatalf("expected ErrNotLeader from follower, got %v", err)
+	}
+	s0.StepDownCooldown = 0
+	if err := s0.StepDown(""); err != nil {
+		t.Fatalf("failed to step down: %s", err.Error())
+	}
+	if _, err := s1.WaitForLeader(10 * time.Second); err != nil {
+		t.Fatalf("no leader after step-down: %s", err.Error())
+	}
+	if !s1.IsLeader() {
+		t.Fatalf("leadership not transferred to %s", s1.ID())
+	}
+
+	// the observer is asynchronous
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if info, err = s1.Leader(); err != nil {
+			t.Fatalf("failed to get leader: %s", err.Error())
+		}
+		if info.NodeID == s1.ID() || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if info.NodeID != s1.ID() {
+		t.Fatalf("expected leader %s, got %+v", s1.ID(), info)
+	}
+	if len(info.History) == 0 || info.History[len(info.History)-1].NodeID != s0.ID() || info.History[0].Until.IsZero() {
+		t.Fatalf("unexpected leader history %+v", info.History)
+	}
+}
+
+func Test_MultiNodeCordon(t *testing.T) {
+	s0 := mustNewStore()
+	defer os.RemoveAll(s0.Path())
+	if err := s0.Open(true); err != nil {
+		t.Fatalf("failed to open node for multi-node test: %s", err.Error())
+	}
+	defer s0.Close(true)
+	s0.WaitForLeader(10 * time.Second)
+
+	s1 := mustNewStore()
+	defer os.RemoveAll(s1.Path())
+	if err := s1.Open(false); err != nil {
+		t.Fatalf("failed to open node for multi-node test: %s", err.Error())
+	}
+	defer s1.Close(true)
+	if err := s0.Join(s1.ID(), s1.Addr(), true, nil); err != nil {
+		t.Fatalf("failed to join to node at %s: %s", s0.Addr(), err.Error())
+	}
+	s1.WaitForLeader(10 * time.Second)
+
+	assert.Equal(t, nil, s0.CheckCordon())
+	s0.Cordon("kernel patch")
+	assert.True(t, errors.Is(s0.CheckCordon(), ErrCordoned))
+	assert.Equal(t, "kernel patch", s0.CordonStatus().Reason)
+	if _, err := s1.WaitForLeader(10 * time.Second); err != nil {
+		t.Fatalf("no leader after cordon: %s", err.Error())
+	}
+	if !s1.IsLeader() {
+		t.Fatalf("leadership not handed over to %s", s1.ID())
+	}
+
+	// the cordoned node still replicates
+	assert.Equal(t, nil, s1.CreateNamespace(context.TODO(), "default"))
+	assert.Equal(t, nil, s0.WaitForAppliedIndex(s1.raft.AppliedIndex(), 5*time.Second))
+	_, err := s0.NamespaceLabels("default")
+	assert.Equal(t, nil, err)
+
+	s0.Uncordon()
+	assert.Equal(t, nil, s0.CheckCordon())
+	assert.Equal(t, CordonStatus{}, s0.CordonStatus())
+}
+
+func Test_InFlightDrain(t *testing.T) {
+	s := mustNewStore()
+	defer os.RemoveAll(s.Path())
+	assert.True(t, s.InFlight().Drained())
+	assert.Equal(t, nil, s.AwaitDrain(context.TODO()))
+
+	doneEnforce := s.BeginRequest(EnforceRequest)
+	doneWrite := s.BeginRequest(WriteRequest)
+	time.Sleep(10 * time.Millisecond)
+	status := s.InFlight()
+	assert.Equal(t, 1, status.Enforce.Count)
+	assert.Equal(t, 1, status.Write.Count)
+	assert.True(t, status.Write.OldestAge >= 10*time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.TODO(), 20*time.Millisecond)
+	defer cancel()
+	assert.Equal(t, context.DeadlineExceeded, s.AwaitDrain(ctx))
+
+	doneEnforce()
+	doneEnforce()
+	assert.Equal(t, 1, s.InFlight().Write.Count)
+	go doneWrite()
+	ctx, cancel = context.WithTimeout(context.TODO(), 5*time.Second)
+	defer cancel()
+	assert.Equal(t, nil, s.AwaitDrain(ctx))
+	assert.Equal(t, InFlightStatus{}, s.InFlight())
+}
+
+func Test_ApplyWatchdog(t *testing.T) {
+	w := newApplyWatchdog()
+	now := time.Now()
+	timeout := 10 * time.Second
+
+	// caught up
+	status, changed := w.check(now.Add(time.Minute), 5, 5, timeout)
+	assert.False(t, status.Stalled)
+	assert.False(t, changed)
+
+	// committed entries waiting without progress
+	w.check(now.Add(time.Minute), 8, 5, timeout)
+	status, changed = w.check(now.Add(time.Minute+5*time.Second), 9, 5, timeout)
+	assert.False(t, status.Stalled)
+	status, changed = w.check(now.Add(time.Minute+11*time.Second), 9, 5, timeout)
+	assert.True(t, status.Stalled)
+	assert.True(t, changed)
+	assert.Equal(t, now.Add(time.Minute).UnixNano(), status.Since)
+	status, changed = w.check(now.Add(time.Minute+12*time.Second), 9, 6, timeout)
+	assert.False(t, status.Stalled)
+	assert.True(t, changed)
+
+	// an entry applied for too long
+	w.begin(7)
+	w.started = now
+	status, _ = w.check(now.Add(11*time.Second), 7, 7, timeout)
+	assert.True(t, status.Stalled)
+	assert.Equal(t, uint64(7), status.Entry)
+	w.end()
+	status, _ = w.check(now.Add(12*time.Second), 7, 7, timeout)
+	assert.False(t, status.Stalled)
+	assert.Equal(t, status, w.get())
+}
+
+func Test_ObserveClock(t *testing.T) {
+	s := mustNewStore()
+	defer os.RemoveAll(s.Path())
+	s.MaxClockSkew = time.Second
+	now := time.Now()
+
+	// 1.5s ahead, but the comparison took 2s: not certainly skewed
+	s.ObserveClock("node1", now, now.Add(2*time.Second), now.Add(2500*time.Millisecond))
+	status := s.ClockStatus()
+	assert.False(t, status.Skewed)
+	assert.Equal(t, time.Second, status.MaxSkew)
+	assert.Equal(t, 1, len(status.Peers))
+	assert.Equal(t, 1500*time.Millisecond, status.Peers[0].Offset)
+
+	s.ObserveClock("node2", now, now.Add(10*time.Millisecond), now.Add(-3*time.Second))
+	status = s.ClockStatus()
+	assert.True(t, status.Skewed)
+	assert.Equal(t, "node2", status.Peers[1].NodeID)
+	assert.True(t, status.Peers[1].Skewed)
+
+	s.ObserveClock("node2", now, now.Add(10*time.Millisecond), now.Add(5*time.Millisecond))
+	assert.False(t, s.ClockStatus().Skewed)
+
+	// the clock of this node is not compared with itself
+	s.ObserveClock(s.ID(), now, now, now.Add(time.Hour))
+	assert.Equal(t, 2, len(s.ClockStatus().Peers))
+}
+
+func Test_ReportError(t *testing.T) {
+	s := mustNewStore()
+	defer os.RemoveAll(s.Path())
+	var reported []error
+	s.ReportError = func(err error) {
+		reported = append(reported, err)
+	}
+	err := s.Restore(ioutil.NopCloser(bytes.NewReader(make([]byte, 16))))
+	if err == nil {
+		t.Fatalf("expected a corrupt snapshot refused")
+	}
+	if len(reported) != 1 || !errors.Is(reported[0], err) {
+		t.Fatalf("expected the failed restore reported, got %v", reported)
+	}
+}
+
+func Test_ReportPanicFlushed(t *testing.T) {
+	s := mustNewStore()
+	defer os.RemoveAll(s.Path())
+	var events []string
+	s.ReportError = func(err error) {
+		events = append(events, "report")
+	}
+	s.FlushReports = func(timeout time.Duration) bool {
+		events = append(events, "flush")
+		return true
+	}
+	func() {
+		defer func() {
+			if p := recover(); p != "nil map" {
+				t.Fatalf("expected the panic raised again, got %v", p)
+			}
+		}()
+		defer s.reportPanic(7)
+		panic("nil map")
+	}()
+	assert.Equal(t, []string{"report", "flush"}, events)
+}
+
+func Test_MultiNodeMetadata(t *testing.T) {
+	s0 := mustNewStore()
+	defer os.RemoveAll(s0.Path())
+	if err := s0.Open(true); err != nil {
+		t.Fatalf("failed to open node for multi-node test: %s", err.Error())
+	}
+	defer s0.Close(true)
+	s0.WaitForLeader(10 * time.Second)
+	if err := s0.SetMetadata(map[string]string{"zone": "a"}); err != nil {
+		t.Fatalf("failed to set metadata: %s", err.Error())
+	}
+
+	s1 := mustNewStore()
+	defer os.RemoveAll(s1.Path())
+	if err := s1.Open(false); err != nil {
+		t.Fatalf("failed to open node for multi-node test: %s", err.Error())
+	}
+	defer s1.Close(true)
+	if err := s0.Join(s1.ID(), s1.Addr(), false, map[string]string{"zone": "b", "rack": "r1"}); err != nil {
+		t.Fatalf("failed to join to node at %s: %s", s0.Addr(), err.Error())
+	}
+	s1.WaitForLeader(10 * time.Second)
+	// joining again updates the metadata
+	if err := s0.Join(s1.ID(), s1.Addr(), false, map[string]string{"zone": "c"}); err != nil {
+		t.Fatalf("failed to join again: %s", err.Error())
+	}
+	if err := s0.SetNodeMetadata(s1.ID(), map[string]string{"version": "v1"}); err != nil {
+		t.Fatalf("failed to set node metadata: %s", err.Error())
+	}
+	if err := s0.SetNodeMetadata("unknown", map[string]string{"zone": "d"}); err != ErrNodeNotExist {
+		t.Fatalf("expected ErrNodeNotExist, got %v", err)
+	}
+	if err := s1.SetNodeMetadata(s1.ID(), map[string]string{"zone": "d"}); err != ErrNotLeader {
+		t.Fatalf("expected ErrNotLeader from follower, got %v", err)
+	}
+	if err := s1.WaitForAppliedIndex(s0.raft.AppliedIndex(), 5*time.Second); err != nil {
+		t.Fatalf("follower did not catch up: %s", err.Error())
+	}
+
+	nodes, err := s1.Nodes()
+	if err != nil {
+		t.Fatalf("failed to get nodes: %s", err.Error())
+	}
+	byID := make(map[string]*Server)
+	for _, n := range nodes {
+		byID[n.ID] = n
+	}
+	assert.Equal(t, true, byID[s0.ID()].Voter)
+	assert.Equal(t, map[string]string{"zone": "a"}, byID[s0.ID()].Metadata)
+	assert.Equal(t, false, byID[s1.ID()].Voter)
+	assert.Equal(t, map[string]string{"zone": "c", "rack": "r1", "version": "v1"}, byID[s1.ID()].Metadata)
+}
+
+func Test_MultiNodeJoinNonVoterRemove(t *testing.T) {
+	s0 := mustNewStore()
+	defer os.RemoveAll(s0.Path())
+	if err := s0.Open(true); err != nil {
+		t.Fatalf("failed to open node for multi-node test: %s", err.Error())
+	}
+	defer s0.Close(true)
+	s0.WaitForLeader(10 * time.Second)
+
+	s1 := mustNewStore()
+	defer os.RemoveAll(s1.Path())
+	if err := s1.Open(false); err != nil {
+		t.Fatalf("failed to open node for multi-node test: %s", err.Error())
+	}
+	defer s1.Close(true)
+
+	// Get sorted list of cluster nodes.
+	storeNodes := []string{s0.ID(), s1.ID()}
+	sort.StringSlice(storeNodes).Sort()
+
+	// Join the second node to the first.
+	if err := s0.Join(s1.ID(), s1.Addr(), false, nil); err != nil {
+		t.Fatalf("failed to join to node at %s: %s", s0.Addr(), err.Error())
+	}
+
+	s1.WaitForLeader(10 * time.Second)
+
+	// Check leader state on follower.
+	if got, exp := s1.LeaderAddr(), s0.Addr(); got != exp {
+		t.Fatalf("wrong leader address returned, got: %s, exp %s", got, exp)
+	}
+	id, err := s1.LeaderID()
+	if err != nil {
+		t.Fatalf("failed to retrieve leader ID: %s", err.Error())
+	}
+	if got, exp := id, s0.raftID; got != exp {
+		t.Fatalf("wrong leader ID returned, got: %s, exp %s", got, exp)
+	}
+
+	nodes, err := s0.Nodes()
+	if err != nil {
+		t.Fatalf("failed to get nodes: %s", err.Error())
+	}
+
+	if len(nodes) != len(storeNodes) {
+		t.Fatalf("size of cluster is not correct")
+	}
+	if storeNodes[0] != nodes[0].ID || storeNodes[1] != nodes[1].ID {
+		t.Fatalf("cluster does not have correct nodes")
+	}
+
+	// Remove the non-voter.
+	if err := s0.Remove(s1.ID()); err != nil {
+		t.Fatalf("failed to remove %s from cluster: %s", s1.ID(), err.Error())
+	}
+
+	nodes, err = s0.Nodes()
+	if err != nil {
+		t.Fatalf("failed to get nodes post remove: %s", err.Error())
+	}
+	if len(nodes) != 1 {
+		t.Fatalf("size of cluster is not correct post remove")
+	}
+	if s0.ID() != nodes[0].ID {
+		t.Fatalf("cluster does not have correct nodes post remove")
+	}
+}
+
+func Test_MultiNodeEnforce(t *testing.T) {
+	s0 := mustNewStore()
+	defer os.RemoveAll(s0.Path())
+	if err := s0.Open(true); err != nil {
+		t.Fatalf("failed to open node for multi-node test: %s", err.Error())
+	}
+	defer s0.Close(true)
+	s0.WaitForLeader(10 * time.Second)
+
+	s1 := mustNewStore()
+	defer os.RemoveAll(s1.Path())
+	if err := s1.Open(false); err != nil {
+		t.Fatalf("failed to open node for multi-node test: %s", err.Error())
+	}
+	defer s1.Close(true)
+
+	s2 := mustNewStore()
 	defer os.RemoveAll(s2.Path())
 	if err := s2.Open(false); err != nil {
 		t.Fatalf("failed to open node for multi-node test: %s", err.Error())
@@ -408,6 +1028,11 @@ func Test_MultiNodeExecuteEnforceFreshness(t *testing.T) {
 	err = s0.SetModelFromString(context.TODO(), "default", modelText)
 	assert.Equal(t, nil, err)
 
+	// Wait until the 4 log entries have been applied to the voting follower,
+	// and then query.
+	if err := s1.WaitForAppliedIndex(4, 5*time.Second); err != nil {
+		t.Fatalf("error waiting for follower to apply index: %s:", err.Error())
+	}
 	_, err = s0.AddPolicies(context.TODO(), "default", "p", "p", [][]string{
 		{"alice", "data1", "read"},
 		{"bob", "data2", "write"},
@@ -420,49 +1045,120 @@ func Test_MultiNodeExecuteEnforceFreshness(t *testing.T) {
 	})
 	assert.Equal(t, nil, err)
 
-	// Wait until the 6 log entries have been applied to the voting follower,
+	// Wait until the 2 log entries have been applied to the voting follower,
 	// and then query.
 	if err := s1.WaitForAppliedIndex(6, 5*time.Second); err != nil {
 		t.Fatalf("error waiting for follower to apply index: %s:", err.Error())
 	}
-	r, err := s0.Enforce(context.TODO(), "default", command.EnforcePayload_QUERY_REQUEST_LEVEL_NONE, 0, "alice", "data1", "read")
+
+	_, err = s1.Enforce(context.TODO(), "default", command.EnforcePayload_QUERY_REQUEST_LEVEL_WEAK, 0, "alice", "data1", "read")
+	if err == nil {
+		t.Fatalf("successfully queried non-leader node")
+	}
+	_, err = s1.Enforce(context.TODO(), "default", command.EnforcePayload_QUERY_REQUEST_LEVEL_STRONG, 0, "alice", "data1", "read")
+	if err == nil {
+		t.Fatalf("successfully queried non-leader node [strong]")
+	}
+	r3, err := s1.Enforce(context.TODO(), "default", command.EnforcePayload_QUERY_REQUEST_LEVEL_NONE, 0, "alice", "data1", "read")
 	if err != nil {
-		t.Fatalf("failed to query leader node: %s", err.Error())
+		t.Fatalf("failed to query follower node: %s", err.Error())
 	}
-	assert.Equal(t, true, r)
+	assert.Equal(t, r3, true)
+}
 
-	// Wait until the 7 log entries have been applied to the follower,
-	// and then query.
-	if err := s1.WaitForAppliedIndex(7, 5*time.Second); err != nil {
-		t.Fatalf("error waiting for follower to apply index: %s:", err.Error())
+func Test_MultiNodeExecuteEnforceFreshness(t *testing.T) {
+	s0 := mustNewStore()
+	defer os.RemoveAll(s0.Path())
+	if err := s0.Open(true); err != nil {
+		t.Fatalf("failed to open node for multi-node test: %s", err.Error())
 	}
+	defer s0.Close(true)
+	s0.WaitForLeader(10 * time.Second)
 
-	r2, err := s0.Enforce(context.TODO(), "default", command.EnforcePayload_QUERY_REQUEST_LEVEL_WEAK, int64(time.Nanosecond), "alice", "data1", "read")
-	if err != nil {
-		t.Fatalf("failed to query leader node: %s", err.Error())
+	s1 := mustNewStore()
+	defer os.RemoveAll(s1.Path())
+	if err := s1.Open(false); err != nil {
+		t.Fatalf("failed to open node for multi-node test: %s", err.Error())
 	}
-	assert.Equal(t, true, r2)
+	defer s1.Close(true)
 
-	r3, err := s0.Enforce(context.TODO(), "default", command.EnforcePayload_QUERY_REQUEST_LEVEL_STRONG, int64(time.Nanosecond), "alice", "data1", "read")
-	if err != nil {
-		t.Fatalf("failed to query leader node: %s", err.Error())
+	s2 := mustNewStore()
+	defer os.RemoveAll(s2.Path())
+	if err := s2.Open(false); err != nil {
+		t.Fatalf("failed to open node for multi-node test: %s", err.Error())
 	}
-	assert.Equal(t, true, r3)
+	defer s2.Close(true)
 
-	// kill leader
-	s0.Close(true)
+	// Join the second node to the first as a voting node.
+	if err := s0.Join(s1.ID(), s1.Addr(), true, nil); err != nil {
+		t.Fatalf("failed to join to node at %s: %s", s0.Addr(), err.Error())
+	}
 
-	// "None" consistency queries should still work.
-	r4, err := s1.Enforce(context.TODO(), "default", command.EnforcePayload_QUERY_REQUEST_LEVEL_NONE, 0, "alice", "data1", "read")
-	if err != nil {
-		t.Fatalf("failed to query follower node: %s", err.Error())
+	// Join the third node to the first as a non-voting node.
+	if err := s0.Join(s2.ID(), s2.Addr(), false, nil); err != nil {
+		t.Fatalf("failed to join to node at %s: %s", s0.Addr(), err.Error())
 	}
-	assert.Equal(t, true, r4)
 
-	time.Sleep(time.Second)
+	err := s0.CreateNamespace(context.TODO(), "default")
+	assert.Equal(t, nil, err)
+	err = s0.SetModelFromString(context.TODO(), "default", modelText)
+	assert.Equal(t, nil, err)
 
-	// "None" consistency queries with 1 nanosecond freshness should fail, because at least
-	// one nanosecond *should* have passed since leader died (surely!).
+	_, err = s0.AddPolicies(context.TODO(), "default", "p", "p", [][]string{
+		{"alice", "data1", "read"},
+		{"bob", "data2", "write"},
+		{"data2_admin", "data2", "read"},
+		{"data2_admin", "data2", "write"},
+	})
+	assert.Equal(t, nil, err)
+	_, err = s0.AddPolicies(context.TODO(), "default", "g", "g", [][]string{
+		{"alice", "data2_admin"},
+	})
+	assert.Equal(t, nil, err)
+
+	// Wait until the 6 log entries have been applied to the voting follower,
+	// and then query.
+	if err := s1.WaitForAppliedIndex(6, 5*time.Second); err != nil {
+		t.Fatalf("error waiting for follower to apply index: %s:", err.Error())
+	}
+	r, err := s0.Enforce(context.TODO(), "default", command.EnforcePayload_QUERY_REQUEST_LEVEL_NONE, 0, "alice", "data1", "read")
+	if err != nil {
+		t.Fatalf("failed to query leader node: %s", err.Error())
+	}
+	assert.Equal(t, true, r)
+
+	// Wait until the 7 log entries have been applied to the follower,
+	// and then query.
+	if err := s1.WaitForAppliedIndex(7, 5*time.Second); err != nil {
+		t.Fatalf("error waiting for follower to apply index: %s:", err.Error())
+	}
+
+	r2, err := s0.Enforce(context.TODO(), "default", command.EnforcePayload_QUERY_REQUEST_LEVEL_WEAK, int64(time.Nanosecond), "alice", "data1", "read")
+	if err != nil {
+		t.Fatalf("failed to query leader node: %s", err.Error())
+	}
+	assert.Equal(t, true, r2)
+
+	r3, err := s0.Enforce(context.TODO(), "default", command.EnforcePayload_QUERY_REQUEST_LEVEL_STRONG, int64(time.Nanosecond), "alice", "data1", "read")
+	if err != nil {
+		t.Fatalf("failed to query leader node: %s", err.Error())
+	}
+	assert.Equal(t, true, r3)
+
+	// kill leader
+	s0.Close(true)
+
+	// "None" consistency queries should still work.
+	r4, err := s1.Enforce(context.TODO(), "default", command.EnforcePayload_QUERY_REQUEST_LEVEL_NONE, 0, "alice", "data1", "read")
+	if err != nil {
+		t.Fatalf("failed to query follower node: %s", err.Error())
+	}
+	assert.Equal(t, true, r4)
+
+	time.Sleep(time.Second)
+
+	// "None" consistency queries with 1 nanosecond freshness should fail, because at least
+	// one nanosecond *should* have passed since leader died (surely!).
 	_, err = s1.Enforce(context.TODO(), "default", command.EnforcePayload_QUERY_REQUEST_LEVEL_NONE, int64(time.Nanosecond), "alice", "data1", "read")
 	if err == nil {
 		t.Fatalf("freshness violating query didn't return an error")
@@ -489,219 +1185,2050 @@ func Test_MultiNodeExecuteEnforceFreshness(t *testing.T) {
 
 }
 
-func Test_SingleNodeSnapshotOnDisk(t *testing.T) {
+func Test_SingleNodeSnapshotOnDisk(t *testing.T) {
+	s := mustNewStore()
+	defer os.RemoveAll(s.Path())
+
+	if err := s.Open(true); err != nil {
+		t.Fatalf("failed to open single-node store: %s", err.Error())
+	}
+	defer s.Close(true)
+	s.WaitForLeader(10 * time.Second)
+
+	err := s.CreateNamespace(context.TODO(), "default")
+	assert.Equal(t, nil, err)
+	err = s.SetModelFromString(context.TODO(), "default", modelText)
+	assert.Equal(t, nil, err)
+	_, err = s.AddPolicies(context.TODO(), "default", "p", "p", [][]string{
+		{"alice", "data1", "read"},
+		{"bob", "data2", "write"},
+		{"data2_admin", "data2", "read"},
+		{"data2_admin", "data2", "write"},
+	})
+	assert.Equal(t, nil, err)
+	_, err = s.AddPolicies(context.TODO(), "default", "g", "g", [][]string{
+		{"alice", "data2_admin"},
+	})
+	assert.Equal(t, nil, err)
+
+	// Snap the node and write to disk.
+	f, err := s.Snapshot()
+	if err != nil {
+		t.Fatalf("failed to snapshot node: %s", err.Error())
+	}
+
+	snapDir := mustTempDir()
+	defer os.RemoveAll(snapDir)
+	snapFile, err := os.Create(filepath.Join(snapDir, "snapshot"))
+	if err != nil {
+		t.Fatalf("failed to create snapshot file: %s", err.Error())
+	}
+	sink := &mockSnapshotSink{snapFile}
+	if err := f.Persist(sink); err != nil {
+		t.Fatalf("failed to persist snapshot to disk: %s", err.Error())
+	}
+
+	// Check restoration.
+	snapFile, err = os.Open(filepath.Join(snapDir, "snapshot"))
+	if err != nil {
+		t.Fatalf("failed to open snapshot file: %s", err.Error())
+	}
+	if err := s.Restore(snapFile); err != nil {
+		t.Fatalf("failed to restore snapshot from disk: %s", err.Error())
+	}
+
+	for _, set := range RBAC_TEST_SETS {
+		fmt.Println(set.input)
+		r, err := s.Enforce(context.TODO(), "default", command.EnforcePayload_QUERY_REQUEST_LEVEL_NONE, 0, set.input...)
+		assert.Equal(t, nil, err)
+		assert.Equal(t, set.expect, r)
+	}
+}
+
+func Test_IsLeader(t *testing.T) {
+	s := mustNewStore()
+	defer os.RemoveAll(s.Path())
+
+	if err := s.Open(true); err != nil {
+		t.Fatalf("failed to open single-node store: %s", err.Error())
+	}
+	defer s.Close(true)
+	s.WaitForLeader(10 * time.Second)
+
+	if !s.IsLeader() {
+		t.Fatalf("single node is not leader!")
+	}
+}
+
+func Test_State(t *testing.T) {
+	s := mustNewStore()
+	defer os.RemoveAll(s.Path())
+
+	if err := s.Open(true); err != nil {
+		t.Fatalf("failed to open single-node store: %s", err.Error())
+	}
+	defer s.Close(true)
+	s.WaitForLeader(10 * time.Second)
+
+	state := s.State()
+	if state != Leader {
+		t.Fatalf("single node returned incorrect state (not Leader): %v", s)
+	}
+}
+
+type mockSnapshotSink struct {
+	*os.File
+}
+
+func (m *mockSnapshotSink) ID() string {
+	return "1"
+}
+
+func (m *mockSnapshotSink) Cancel() error {
+	return nil
+}
+
+type EnforceData struct {
+	input  []interface{}
+	expect bool
+}
+
+var (
+	RBAC_TEST_SETS = []EnforceData{
+		{
+			[]interface{}{"alice", "data1", "read"}, true,
+		},
+		{
+			[]interface{}{"alice", "data1", "write"}, false,
+		},
+		{
+			[]interface{}{"alice", "data2", "read"}, true,
+		},
+		{
+			[]interface{}{"alice", "data2", "write"}, true,
+		},
+		{
+			[]interface{}{"bob", "data1", "read"}, false,
+		},
+		{
+			[]interface{}{"bob", "data1", "write"}, false,
+		},
+		{
+			[]interface{}{"bob", "data2", "read"}, false,
+		},
+		{
+			[]interface{}{"bob", "data2", "write"}, true,
+		},
+	}
+)
+var (
+	modelText = `
+[request_definition]
+r = sub, obj, act
+
+[policy_definition]
+p = sub, obj, act
+
+[role_definition]
+g = _, _
+
+[policy_effect]
+e = some(where (p.eft == allow))
+
+[matchers]
+m = g(r.sub, p.sub) && r.obj == p.obj && r.act == p.act
+`
+	incorrectModelText = `
+[request_definition
+r = sub, obj, act
+
+[policy_definition]
+p = sub, obj, act
+
+[role_definition]
+g = _, _
+
+[policy_effect]
+e = some(where (p.eft == allow))
+
+[matchers]
+m = g(r.sub, p.sub) && r.obj == p.obj && r.act == p.act
+`
+)
+
+type mockListener struct {
+	ln net.Listener
+}
+
+func (m *mockListener) Dial(addr string, timeout time.Duration) (net.Conn, error) {
+	return net.DialTimeout("tcp", addr, timeout)
+}
+
+func (m *mockListener) Accept() (net.Conn, error) { return m.ln.Accept() }
+
+func (m *mockListener) Close() error { return m.ln.Close() }
+
+func (m *mockListener) Addr() net.Addr { return m.ln.Addr() }
+
+func mustMockLister(addr string) Listener {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		panic("failed to create new listner")
+	}
+	return &mockListener{ln}
+}
+
+func mustTempDir() string {
+	var err error
+	path, err := ioutil.TempDir("", "casbin-mesh-test-")
+	if err != nil {
+		panic("failed to create temp dir")
+	}
+	return path
+}
+
+func mustNewStoreAtPath(path string) *Store {
+	s := New(mustMockLister("localhost:0"), &StoreConfig{
+		Dir: path,
+		ID:  path, // Could be any unique string.
+	})
+	if s == nil {
+		panic("failed to create new store")
+	}
+	return s
+}
+
+func mustNewStore() *Store {
+	return mustNewStoreAtPath(mustTempDir())
+}
+
+func Test_SingleNodeIndexRecorder(t *testing.T) {
+	s := mustNewStore()
+	defer os.RemoveAll(s.Path())
+	if err := s.Open(true); err != nil {
+		t.Fatalf("failed to open single-node store: %s", err.Error())
+	}
+	defer s.Close(true)
+	s.WaitForLeader(10 * time.Second)
+	err := s.CreateNamespace(context.TODO(), "default")
+	assert.Equal(t, nil, err)
+	err = s.SetModelFromString(context.TODO(), "default", modelText)
+	assert.Equal(t, nil, err)
+
+	ctx, r := WithIndexRecorder(context.TODO())
+	assert.Equal(t, uint64(0), r.Index())
+	_, err = s.AddPolicies(ctx, "default", "p", "p", [][]string{{"alice", "data1", "read"}})
+	assert.Equal(t, nil, err)
+	first := r.Index()
+	assert.NotEqual(t, uint64(0), first)
+	_, err = s.AddPolicies(ctx, "default", "p", "p", [][]string{{"bob", "data2", "write"}})
+	assert.Equal(t, nil, err)
+	assert.Greater(t, r.Index(), first)
+
+	assert.Equal(t, nil, s.WaitForIndex(context.TODO(), r.Index()))
+	ctx, cancel := context.WithTimeout(context.TODO(), 50*time.Millisecond)
+	defer cancel()
+	assert.Equal(t, context.DeadlineExceeded, s.WaitForIndex(ctx, r.Index()+100))
+}
+
+func Test_SingleNodeIdempotency(t *testing.T) {
+	s := mustNewStore()
+	defer os.RemoveAll(s.Path())
+	if err := s.Open(true); err != nil {
+		t.Fatalf("failed to open single-node store: %s", err.Error())
+	}
+	defer s.Close(true)
+	s.WaitForLeader(10 * time.Second)
+	err := s.CreateNamespace(context.TODO(), "default")
+	assert.Equal(t, nil, err)
+	err = s.SetModelFromString(context.TODO(), "default", modelText)
+	assert.Equal(t, nil, err)
+
+	ctx := WithIdempotencyKey(context.TODO(), "k1")
+	rules, err := s.AddPolicies(ctx, "default", "p", "p", [][]string{{"alice", "data1", "read"}})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, [][]string{{"alice", "data1", "read"}}, rules)
+	assert.False(t, Replayed(ctx))
+	_, err = s.RemovePolicies(context.TODO(), "default", "p", "p", [][]string{{"alice", "data1", "read"}})
+	assert.Equal(t, nil, err)
+
+	// a retry gets the original result and is not applied again
+	ctx = WithIdempotencyKey(context.TODO(), "k1")
+	rules, err = s.AddPolicies(ctx, "default", "p", "p", [][]string{{"alice", "data1", "read"}})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, [][]string{{"alice", "data1", "read"}}, rules)
+	assert.True(t, Replayed(ctx))
+	policies, err := s.ListPolicies(context.TODO(), "default", "", 0, 0, false)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 0, len(policies))
+
+	ctx = WithIdempotencyKey(context.TODO(), "k1")
+	_, err = s.AddPolicies(ctx, "default", "p", "p", [][]string{{"bob", "data2", "write"}})
+	assert.Equal(t, ErrIdempotencyKeyReused, err)
+}
+
+func Test_SingleNodeIdempotencyResponses(t *testing.T) {
+	s := mustNewStore()
+	defer os.RemoveAll(s.Path())
+	if err := s.Open(true); err != nil {
+		t.Fatalf("failed to open single-node store: %s", err.Error())
+	}
+	defer s.Close(true)
+	s.WaitForLeader(10 * time.Second)
+	err := s.CreateNamespace(context.TODO(), "default")
+	assert.Equal(t, nil, err)
+	err = s.SetModelFromString(context.TODO(), "default", modelText)
+	assert.Equal(t, nil, err)
+
+	// reads in a request sent with a key are not deduplicated
+	ctx := WithIdempotencyKey(context.TODO(), "k1")
+	_, err = s.AddPolicies(ctx, "default", "p", "p", [][]string{{"alice", "data1", "read"}})
+	assert.Equal(t, nil, err)
+	policies, err := s.ListPolicies(ctx, "default", "", 0, 0, false)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 1, len(policies))
+
+	// a retry gets the response of the command's own type
+	ctx = WithIdempotencyKey(context.TODO(), "k2")
+	v, err := s.TagVersion(ctx, "default", "v1", 0)
+	assert.Equal(t, nil, err)
+	assert.False(t, Replayed(ctx))
+	ctx = WithIdempotencyKey(context.TODO(), "k2")
+	replayed, err := s.TagVersion(ctx, "default", "v1", 0)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, v, replayed)
+	assert.True(t, Replayed(ctx))
+
+	// so does a key reused by another kind of command
+	ctx = WithIdempotencyKey(context.TODO(), "k2")
+	_, _, err = s.ReplacePolicies(ctx, "default", nil)
+	assert.Equal(t, ErrIdempotencyKeyReused, err)
+
+	ctx = WithIdempotencyKey(context.TODO(), "k3")
+	renamed, err := s.RenameSubject(ctx, "default", "alice", "bob", false)
+	assert.Equal(t, nil, err)
+	ctx = WithIdempotencyKey(context.TODO(), "k3")
+	again, err := s.RenameSubject(ctx, "default", "alice", "bob", false)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, renamed, again)
+	assert.True(t, Replayed(ctx))
+	// the variants of a command differ by their metadata only
+	ctx = WithIdempotencyKey(context.TODO(), "k3")
+	_, err = s.RenameSubject(ctx, "default", "alice", "carol", false)
+	assert.Equal(t, ErrIdempotencyKeyReused, err)
+}
+
+func Test_IdempotencyRegistryPrune(t *testing.T) {
+	r := newIdempotencyRegistry()
+	now := time.Now().UnixNano()
+	r.add(&idempotentResult{Key: "old", Time: now - int64(IdempotencyWindow) - 1})
+	r.add(&idempotentResult{Key: "new", Time: now})
+	assert.Equal(t, 1, len(r.Results))
+	_, ok := r.byKey["old"]
+	assert.False(t, ok)
+
+	for i := 0; i < idempotencyMaxKeys; i++ {
+		r.add(&idempotentResult{Key: strconv.Itoa(i), Time: now})
+	}
+	assert.Equal(t, idempotencyMaxKeys, len(r.Results))
+	_, ok = r.byKey["new"]
+	assert.False(t, ok)
+
+	r.byKey = nil
+	r.rebuild()
+	assert.Equal(t, idempotencyMaxKeys, len(r.byKey))
+}
+
+func Test_SingleNodeVersions(t *testing.T) {
+	s := mustNewStore()
+	defer os.RemoveAll(s.Path())
+	if err := s.Open(true); err != nil {
+		t.Fatalf("failed to open single-node store: %s", err.Error())
+	}
+	defer s.Close(true)
+	s.WaitForLeader(10 * time.Second)
+	err := s.CreateNamespace(context.TODO(), "default")
+	assert.Equal(t, nil, err)
+	err = s.SetModelFromString(context.TODO(), "default", modelText)
+	assert.Equal(t, nil, err)
+
+	_, err = s.AddPolicies(context.TODO(), "default", "p", "p", [][]string{{"alice", "data1", "read"}})
+	assert.Equal(t, nil, err)
+	tagged, err := s.TagVersion(context.TODO(), "default", "v1", 0)
+	assert.Equal(t, nil, err)
+	_, err = s.AddPolicies(context.TODO(), "default", "p", "p", [][]string{{"bob", "data2", "write"}})
+	assert.Equal(t, nil, err)
+	_, err = s.AddPolicies(context.TODO(), "default", "g", "g", [][]string{{"carol", "admin"}})
+	assert.Equal(t, nil, err)
+	// no-op changes don't add versions
+	_, err = s.AddPolicies(context.TODO(), "default", "p", "p", [][]string{{"bob", "data2", "write"}})
+	assert.Equal(t, nil, err)
+
+	// setting the model recorded the empty version
+	versions, err := s.ListVersions(context.TODO(), "default")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 4, len(versions))
+	assert.Equal(t, 0, len(versions[0].Policies))
+	assert.Equal(t, tagged, versions[1].Version)
+	assert.Equal(t, "v1", versions[1].Tag)
+
+	added, removed := DiffPolicies(versions[1].Policies, versions[3].Policies)
+	assert.Equal(t, 0, len(removed))
+	assert.Equal(t, 2, len(added))
+
+	_, err = s.RollbackPolicies(context.TODO(), "default", 0, "v2")
+	assert.Equal(t, ErrVersionNotExist, err)
+	effected, err := s.RollbackPolicies(context.TODO(), "default", 0, "v1")
+	assert.Equal(t, nil, err)
+	assert.True(t, effected)
+	policies, err := s.ListPolicies(context.TODO(), "default", "", 0, 0, false)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 1, len(policies))
+
+	// the rollback is a version as well
+	versions, err = s.ListVersions(context.TODO(), "default")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 5, len(versions))
+	assert.True(t, samePolicies(versions[1].Policies, versions[4].Policies))
+}
+
+func Test_SingleNodeVersionsAcrossNamespaces(t *testing.T) {
+	s := mustNewStore()
+	defer os.RemoveAll(s.Path())
+	if err := s.Open(true); err != nil {
+		t.Fatalf("failed to open single-node store: %s", err.Error())
+	}
+	defer s.Close(true)
+	s.WaitForLeader(10 * time.Second)
+	ctx := context.TODO()
+	for _, ns := range []string{"billing", "search", "archive"} {
+		assert.Equal(t, nil, s.CreateNamespace(ctx, ns))
+		assert.Equal(t, nil, s.SetModelFromString(ctx, ns, modelText))
+		_, err := s.AddPolicies(ctx, ns, "p", "p", [][]string{{"alice", ns, "read"}, {"bob", ns, "read"}})
+		assert.Equal(t, nil, err)
+	}
+	latest := func(ns string) *command.PolicyVersion {
+		versions, err := s.ListVersions(ctx, ns)
+		assert.Equal(t, nil, err)
+		return versions[len(versions)-1]
+	}
+
+	// Every namespace a change touches gets a version of it.
+	_, err := s.RenameSubject(ctx, "billing", "alice", "carol", true)
+	assert.Equal(t, nil, err)
+	for _, ns := range []string{"billing", "search", "archive"} {
+		assert.True(t, samePolicies(latest(ns).Policies, []*command.PolicyRules{{Sec: "p", PType: "p",
+			Rules: command.NewStringArray([][]string{{"carol", ns, "read"}, {"bob", ns, "read"}})}}), ns)
+	}
+	_, err = s.RemoveSubject(ctx, "search", "bob", true)
+	assert.Equal(t, nil, err)
+	for _, ns := range []string{"billing", "search", "archive"} {
+		assert.True(t, samePolicies(latest(ns).Policies, []*command.PolicyRules{{Sec: "p", PType: "p",
+			Rules: command.NewStringArray([][]string{{"carol", ns, "read"}})}}), ns)
+	}
+	_, err = s.CopyPolicies(ctx, "archive", "billing", nil, true)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 0, len(latest("archive").Policies))
+	assert.Equal(t, 2, len(latest("billing").Policies[0].Rules))
+
+	// Older versions are kept as differences, and rebuilt when read.
+	versions, err := s.ListVersions(ctx, "archive")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 5, len(versions))
+	assert.True(t, samePolicies(versions[1].Policies, []*command.PolicyRules{{Sec: "p", PType: "p",
+		Rules: command.NewStringArray([][]string{{"alice", "archive", "read"}, {"bob", "archive", "read"}})}}))
+	_, err = s.RollbackPolicies(ctx, "archive", versions[2].Version, "")
+	assert.Equal(t, nil, err)
+	assert.True(t, samePolicies(latest("archive").Policies, versions[2].Policies))
+}
+
+func Test_VersionRegistry(t *testing.T) {
+	rules := func(subs ...string) []*command.PolicyRules {
+		var rs [][]string
+		for _, sub := range subs {
+			rs = append(rs, []string{sub, "data", "read"})
+		}
+		return []*command.PolicyRules{{Sec: "p", PType: "p", Rules: command.NewStringArray(rs)}}
+	}
+	r := newVersionRegistry()
+	var subs []string
+	for i := 0; i < versionHistorySize+5; i++ {
+		subs = append(subs, fmt.Sprintf("user%d", i))
+		r.record("default", uint64(i+1), 0, rules(subs...))
+		if i == 2 {
+			r.tag("default", 3, "v3")
+		}
+	}
+	versions := r.list("default")
+	assert.Equal(t, versionHistorySize, len(versions))
+	// The tagged version is kept, the oldest untagged ones are evicted.
+	assert.Equal(t, "v3", versions[0].Tag)
+	assert.True(t, samePolicies(rules(subs[:3]...), versions[0].Policies))
+	for i, v := range versions[1:] {
+		assert.Equal(t, uint64(i+7), v.Version)
+		assert.True(t, samePolicies(rules(subs[:i+7]...), v.Policies))
+	}
+	assert.True(t, samePolicies(rules(subs[:3]...), r.find("default", 0, "v3").Policies))
+
+	// Snapshots hold the rules of every version.
+	b, err := json.Marshal(r)
+	assert.Equal(t, nil, err)
+	restored := newVersionRegistry()
+	assert.Equal(t, nil, json.Unmarshal(b, restored))
+	for i, v := range restored.list("default") {
+		assert.Equal(t, versions[i].Version, v.Version)
+		assert.True(t, samePolicies(versions[i].Policies, v.Policies))
+	}
+}
+
+func Test_SingleNodeReplacePolicies(t *testing.T) {
+	s := mustNewStore()
+	defer os.RemoveAll(s.Path())
+	if err := s.Open(true); err != nil {
+		t.Fatalf("failed to open single-node store: %s", err.Error())
+	}
+	defer s.Close(true)
+	s.WaitForLeader(10 * time.Second)
+	assert.Equal(t, nil, s.CreateNamespace(context.TODO(), "default"))
+	assert.Equal(t, nil, s.SetModelFromString(context.TODO(), "default", modelText))
+	_, err := s.AddPolicies(context.TODO(), "default", "p", "p", [][]string{{"alice", "data1", "read"}, {"bob", "data2", "write"}})
+	assert.Equal(t, nil, err)
+
+	desired := []*command.PolicyRules{
+		{Sec: "p", PType: "p", Rules: command.NewStringArray([][]string{{"alice", "data1", "read"}, {"carol", "data1", "read"}, {"carol", "data1", "read"}})},
+		{Sec: "g", PType: "g", Rules: command.NewStringArray([][]string{{"carol", "admin"}})},
+	}
+	added, removed, err := s.PlanReplacePolicies("default", desired)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 2, len(added))
+	assert.Equal(t, 1, len(removed))
+	policies, err := s.ListPolicies(context.TODO(), "default", "", 0, 0, false)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 2, len(policies))
+
+	added, removed, err = s.ReplacePolicies(context.TODO(), "default", desired)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 2, len(added))
+	assert.Equal(t, [][]string{{"bob", "data2", "write"}}, command.ToStringArray(removed[0].Rules))
+	policies, err = s.ListPolicies(context.TODO(), "default", "", 0, 0, false)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 3, len(policies))
+
+	// replacing with the same rules changes nothing
+	added, removed, err = s.ReplacePolicies(context.TODO(), "default", desired)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 0, len(added)+len(removed))
+
+	_, _, err = s.ReplacePolicies(context.TODO(), "default", []*command.PolicyRules{{Sec: "p", PType: "p2", Rules: command.NewStringArray([][]string{{"dave"}})}})
+	assert.NotEqual(t, nil, err)
+	_, _, err = s.ReplacePolicies(context.TODO(), "missing", desired)
+	assert.Equal(t, NamespaceNotExist, err)
+}
+
+func Test_LoadTracker(t *testing.T) {
+	tr := newLoadTracker()
+	for i := 0; i < 99; i++ {
+		tr.observe(time.Millisecond)
+	}
+	tr.observe(time.Second)
+	tr.roll(tr.start.Add(loadWindow))
+	assert.Equal(t, 10.0, tr.rate)
+	assert.Equal(t, 1600*time.Microsecond, tr.p99)
+
+	for i := 0; i < 1000; i++ {
+		tr.observe(time.Millisecond)
+	}
+	for i := 0; i < 20; i++ {
+		tr.observe(time.Second)
+	}
+	tr.roll(tr.start.Add(loadWindow))
+	assert.Equal(t, 102.0, tr.rate)
+	assert.Equal(t, 1638400*time.Microsecond, tr.p99)
+
+	// an idle window reports no load
+	tr.roll(tr.start.Add(3 * loadWindow))
+	assert.Equal(t, 0.0, tr.rate)
+	assert.Equal(t, time.Duration(0), tr.p99)
+}
+
+func Test_VersionRegistryEviction(t *testing.T) {
+	r := newVersionRegistry()
+	rules := func(i int) []*command.PolicyRules {
+		return []*command.PolicyRules{{Sec: "p", PType: "p", Rules: command.NewStringArray([][]string{{strconv.Itoa(i)}})}}
+	}
+	r.record("default", 1, 0, rules(1))
+	r.tag("default", 1, "first")
+	for i := 2; i <= versionHistorySize+1; i++ {
+		r.record("default", uint64(i), 0, rules(i))
+	}
+	versions := r.list("default")
+	assert.Equal(t, versionHistorySize, len(versions))
+	assert.Equal(t, "first", versions[0].Tag)
+	assert.Equal(t, uint64(3), versions[1].Version)
+}
+
+func Test_SingleNodeEnforceWithContext(t *testing.T) {
+	s := mustNewStore()
+	defer os.RemoveAll(s.Path())
+	if err := s.Open(true); err != nil {
+		t.Fatalf("failed to open single-node store: %s", err.Error())
+	}
+	defer s.Close(true)
+	s.WaitForLeader(10 * time.Second)
+	err := s.CreateNamespace(context.TODO(), "default")
+	assert.Equal(t, nil, err)
+	err = s.SetModelFromString(context.TODO(), "default", `
+[request_definition]
+r = sub, obj, act
+r2 = sub, obj
+
+[policy_definition]
+p = sub, obj, act
+p2 = sub, obj, eft
+
+[role_definition]
+g = _, _
+
+[policy_effect]
+e = some(where (p.eft == allow))
+e2 = some(where (p.eft == allow)) && !some(where (p.eft == deny))
+
+[matchers]
+m = g(r.sub, p.sub) && r.obj == p.obj && r.act == p.act
+m2 = g(r2.sub, p2.sub) && r2.obj == p2.obj
+`)
+	assert.Equal(t, nil, err)
+	_, err = s.AddPolicies(context.TODO(), "default", "p", "p", [][]string{{"alice", "data1", "read"}})
+	assert.Equal(t, nil, err)
+	_, err = s.AddPolicies(context.TODO(), "default", "p", "p2", [][]string{{"admin", "data2", "allow"}, {"bob", "data2", "deny"}})
+	assert.Equal(t, nil, err)
+	_, err = s.AddPolicies(context.TODO(), "default", "g", "g", [][]string{{"alice", "admin"}, {"bob", "admin"}})
+	assert.Equal(t, nil, err)
+
+	ec := &command.EnforceContext{RType: "r2", PType: "p2", EType: "e2", MType: "m2"}
+	for _, level := range []command.EnforcePayload_Level{command.EnforcePayload_QUERY_REQUEST_LEVEL_NONE, command.EnforcePayload_QUERY_REQUEST_LEVEL_STRONG} {
+		ok, err := s.EnforceWithContext(context.TODO(), "default", level, 0, ec, "alice", "data2")
+		assert.Equal(t, nil, err)
+		assert.True(t, ok)
+		ok, err = s.EnforceWithContext(context.TODO(), "default", level, 0, ec, "bob", "data2")
+		assert.Equal(t, nil, err)
+		assert.False(t, ok)
+		ok, err = s.EnforceWithContext(context.TODO(), "default", level, 0, nil, "alice", "data1", "read")
+		assert.Equal(t, nil, err)
+		assert.True(t, ok)
+	}
+
+	_, err = s.EnforceWithContext(context.TODO(), "default", command.EnforcePayload_QUERY_REQUEST_LEVEL_NONE, 0,
+		&command.EnforceContext{PType: "p3"}, "alice", "data1", "read")
+	assert.NotEqual(t, nil, err)
+}
+
+func Test_SingleNodeReadOnly(t *testing.T) {
+	s := mustNewStore()
+	defer os.RemoveAll(s.Path())
+	if err := s.Open(true); err != nil {
+		t.Fatalf("failed to open single-node store: %s", err.Error())
+	}
+	defer s.Close(true)
+	s.WaitForLeader(10 * time.Second)
+	err := s.CreateNamespace(context.TODO(), "default")
+	assert.Equal(t, nil, err)
+	err = s.SetModelFromString(context.TODO(), "default", modelText)
+	assert.Equal(t, nil, err)
+	_, err = s.AddPolicies(context.TODO(), "default", "p", "p", [][]string{{"alice", "data1", "read"}})
+	assert.Equal(t, nil, err)
+
+	changed, err := s.SetReadOnly(context.TODO(), true, "backup")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, true, changed)
+	status := s.ReadOnly()
+	assert.Equal(t, true, status.Enabled)
+	assert.Equal(t, "backup", status.Reason)
+	assert.NotEqual(t, int64(0), status.Since)
+
+	// writes are rejected, whatever their response type
+	_, err = s.AddPolicies(context.TODO(), "default", "p", "p", [][]string{{"bob", "data2", "write"}})
+	assert.True(t, errors.Is(err, ErrReadOnly))
+	assert.Equal(t, "cluster is read-only: backup", err.Error())
+	_, err = s.TagVersion(context.TODO(), "default", "v1", 0)
+	assert.True(t, errors.Is(err, ErrReadOnly))
+	err = s.CreateNamespace(context.TODO(), "other")
+	assert.True(t, errors.Is(err, ErrReadOnly))
+
+	// reads and enforcement keep being served
+	ok, err := s.Enforce(context.TODO(), "default", command.EnforcePayload_QUERY_REQUEST_LEVEL_STRONG, 0, "alice", "data1", "read")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, true, ok)
+	policies, err := s.ListPolicies(context.TODO(), "default", "", 0, 0, false)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 1, len(policies))
+
+	// enabling again keeps the original time
+	changed, err = s.SetReadOnly(context.TODO(), true, "migration")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, false, changed)
+	assert.Equal(t, status.Since, s.ReadOnly().Since)
+	assert.Equal(t, "migration", s.ReadOnly().Reason)
+
+	changed, err = s.SetReadOnly(context.TODO(), false, "")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, true, changed)
+	assert.Equal(t, ReadOnlyStatus{}, s.ReadOnly())
+	_, err = s.AddPolicies(context.TODO(), "default", "p", "p", [][]string{{"bob", "data2", "write"}})
+	assert.Equal(t, nil, err)
+}
+
+func Test_MultiNodeAutoPromote(t *testing.T) {
+	s0 := mustNewStore()
+	defer os.RemoveAll(s0.Path())
+	s0.AutoPromote = true
+	if err := s0.Open(true); err != nil {
+		t.Fatalf("failed to open node for multi-node test: %s", err.Error())
+	}
+	defer s0.Close(true)
+	s0.WaitForLeader(10 * time.Second)
+
+	s1 := mustNewStore()
+	defer os.RemoveAll(s1.Path())
+	if err := s1.Open(false); err != nil {
+		t.Fatalf("failed to open node for multi-node test: %s", err.Error())
+	}
+	defer s1.Close(true)
+
+	if err := s0.Join(s1.ID(), s1.Addr(), true, nil); err != nil {
+		t.Fatalf("failed to join to node at %s: %s", s0.Addr(), err.Error())
+	}
+	node := func() *Server {
+		nodes, err := s0.Nodes()
+		if err != nil {
+			t.Fatalf("failed to get nodes: %s", err.Error())
+		}
+		for _, n := range nodes {
+			if n.ID == s1.ID() {
+				return n
+			}
+		}
+		t.Fatalf("node %s not in the cluster", s1.ID())
+		return nil
+	}
+
+	// The node joins as a non-voter, pending promotion.
+	if n := node(); n.Voter || n.Metadata[PromotionKey] != promotionPending {
+		t.Fatalf("node joined as voter %v, promotion %q", n.Voter, n.Metadata[PromotionKey])
+	}
+	for deadline := time.Now().Add(10 * time.Second); ; {
+		if n := node(); n.Voter && n.Metadata[PromotionKey] == promotionDone {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("node not promoted once caught up")
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+func Test_MultiNodeJoinToken(t *testing.T) {
+	s0 := mustNewStore()
+	defer os.RemoveAll(s0.Path())
+	s0.JoinToken = "shared"
+	if err := s0.Open(true); err != nil {
+		t.Fatalf("failed to open node for multi-node test: %s", err.Error())
+	}
+	defer s0.Close(true)
+	s0.WaitForLeader(10 * time.Second)
+
+	stores := make([]*Store, 2)
+	for i := range stores {
+		stores[i] = mustNewStore()
+		defer os.RemoveAll(stores[i].Path())
+		if err := stores[i].Open(false); err != nil {
+			t.Fatalf("failed to open node for multi-node test: %s", err.Error())
+		}
+		defer stores[i].Close(true)
+	}
+	s1, s2 := stores[0], stores[1]
+
+	assert.Equal(t, ErrJoinToken, s0.JoinWithToken(s1.ID(), s1.Addr(), false, nil, ""))
+	assert.Equal(t, ErrJoinToken, s0.JoinWithToken(s1.ID(), s1.Addr(), false, nil, "guess"))
+	expired, err := s0.CreateJoinToken(time.Nanosecond)
+	assert.NoError(t, err)
+	time.Sleep(time.Millisecond)
+	assert.Equal(t, ErrJoinToken, s0.JoinWithToken(s1.ID(), s1.Addr(), false, nil, expired.Token))
+
+	token, err := s0.CreateJoinToken(0)
+	assert.NoError(t, err)
+	assert.True(t, token.Expires.After(time.Now().Add(DefaultJoinTokenTTL-time.Minute)))
+	assert.NoError(t, s0.JoinWithToken(s1.ID(), s1.Addr(), false, nil, token.Token))
+	// Members joining again unchanged need no token, changing their
+	// metadata does.
+	assert.NoError(t, s0.JoinWithToken(s1.ID(), s1.Addr(), false, nil, ""))
+	assert.Equal(t, ErrJoinToken, s0.JoinWithToken(s1.ID(), s1.Addr(), false, map[string]string{"zone": "a"}, ""))
+	assert.NoError(t, s0.JoinWithToken(s1.ID(), s1.Addr(), false, map[string]string{"zone": "a"}, "shared"))
+	assert.NoError(t, s0.JoinWithToken(s1.ID(), s1.Addr(), false, map[string]string{"zone": "a"}, ""))
+	// Tokens are single-use.
+	assert.Equal(t, ErrJoinToken, s0.JoinWithToken(s2.ID(), s2.Addr(), false, nil, token.Token))
+	assert.NoError(t, s0.JoinWithToken(s2.ID(), s2.Addr(), false, nil, "shared"))
+
+	s1.WaitForLeader(10 * time.Second)
+	_, err = s1.CreateJoinToken(0)
+	assert.Equal(t, ErrNotLeader, err)
+}
+
+func Test_MultiNodeMembershipGuards(t *testing.T) {
+	s0 := mustNewStore()
+	defer os.RemoveAll(s0.Path())
+	s0.MembershipStabilization = time.Hour
+	s0.MinQuorum = 3
+	if err := s0.Open(true); err != nil {
+		t.Fatalf("failed to open node for multi-node test: %s", err.Error())
+	}
+	defer s0.Close(true)
+	s0.WaitForLeader(10 * time.Second)
+
+	var stores []*Store
+	for i := 0; i < 2; i++ {
+		s := mustNewStore()
+		defer os.RemoveAll(s.Path())
+		if err := s.Open(false); err != nil {
+			t.Fatalf("failed to open node for multi-node test: %s", err.Error())
+		}
+		defer s.Close(true)
+		stores = append(stores, s)
+	}
+	s1, s2 := stores[0], stores[1]
+
+	if err := s0.Join(s1.ID(), s1.Addr(), true, nil); err != nil {
+		t.Fatalf("failed to join to node at %s: %s", s0.Addr(), err.Error())
+	}
+	if err := s0.Join(s2.ID(), s2.Addr(), true, nil); !errors.Is(err, ErrMembershipStabilizing) {
+		t.Fatalf("membership change not refused during stabilization: %v", err)
+	}
+	s0.MembershipStabilization = 0
+	if err := s0.Join(s2.ID(), s2.Addr(), true, nil); err != nil {
+		t.Fatalf("failed to join to node at %s: %s", s0.Addr(), err.Error())
+	}
+
+	if err := s0.Remove(s2.ID()); !errors.Is(err, ErrQuorumAtRisk) {
+		t.Fatalf("removal below the minimum quorum not refused: %v", err)
+	}
+	s0.MinQuorum = 0
+	if err := s0.Remove(s2.ID()); err != nil {
+		t.Fatalf("failed to remove %s from cluster: %s", s2.ID(), err.Error())
+	}
+}
+
+func Test_MultiNodeVersionGating(t *testing.T) {
+	s0 := mustNewStore()
+	defer os.RemoveAll(s0.Path())
+	if err := s0.Open(true); err != nil {
+		t.Fatalf("failed to open node for multi-node test: %s", err.Error())
+	}
+	defer s0.Close(true)
+	s0.WaitForLeader(10 * time.Second)
+
+	s1 := mustNewStore()
+	defer os.RemoveAll(s1.Path())
+	if err := s1.Open(false); err != nil {
+		t.Fatalf("failed to open node for multi-node test: %s", err.Error())
+	}
+	defer s1.Close(true)
+
+	// The new node runs a binary supporting the first FSM version only.
+	if err := s0.Join(s1.ID(), s1.Addr(), true, map[string]string{FSMVersionKey: "1"}); err != nil {
+		t.Fatalf("failed to join to node at %s: %s", s0.Addr(), err.Error())
+	}
+	if v, err := s0.ClusterFSMVersion(); err != nil || v != 1 {
+		t.Fatalf("cluster FSM version is %d (%v), expected 1", v, err)
+	}
+	if err := s0.CreateNamespace(context.TODO(), "default"); err != nil {
+		t.Fatalf("failed to create namespace: %s", err.Error())
+	}
+	if _, err := s0.SetReadOnly(context.TODO(), true, ""); !errors.Is(err, ErrUnsupportedByCluster) {
+		t.Fatalf("newer command applied before all nodes support it: %v", err)
+	}
+
+	// Once upgraded, the node records its new FSM version.
+	if err := s0.SetNodeMetadata(s1.ID(), map[string]string{FSMVersionKey: strconv.Itoa(FSMVersion)}); err != nil {
+		t.Fatalf("failed to set node metadata: %s", err.Error())
+	}
+	if _, err := s0.SetReadOnly(context.TODO(), true, ""); err != nil {
+		t.Fatalf("failed to apply newer command once all nodes support it: %s", err.Error())
+	}
+
+	// Unframed Raft connections are let in until every member frames them.
+	f := s0.raft.GetConfiguration()
+	if err := f.Error(); err != nil {
+		t.Fatalf("failed to get configuration: %s", err.Error())
+	}
+	servers := f.Configuration().Servers
+	if s0.clusterFramesRaft(servers) {
+		t.Fatalf("cluster frames its raft connections before all nodes do")
+	}
+	if err := s0.SetNodeMetadata(s1.ID(), map[string]string{FSMVersionKey: strconv.Itoa(FSMVersion), RaftFramingKey: "true"}); err != nil {
+		t.Fatalf("failed to set node metadata: %s", err.Error())
+	}
+	if !s0.clusterFramesRaft(servers) {
+		t.Fatalf("cluster does not frame its raft connections once all nodes do")
+	}
+}
+
+func Test_CommandVersionVariants(t *testing.T) {
+	for _, c := range []struct {
+		typ command.Type
+		md  map[string]string
+		v   int
+	}{
+		{command.Type_COMMAND_TYPE_ADD_POLICIES, nil, 1},
+		{command.Type_COMMAND_TYPE_ADD_POLICIES, map[string]string{standbyIndexMeta: "7"}, 4},
+		{command.Type_COMMAND_TYPE_ADD_POLICIES, map[string]string{standbySeedMeta: "true"}, 4},
+		{command.Type_COMMAND_TYPE_NOOP, map[string]string{digestMeta: "true"}, 4},
+	} {
+		cmd := &command.Command{Type: c.typ, Metadata: c.md}
+		if v := commandVersion(cmd); v != c.v {
+			t.Fatalf("expected FSM version %d for %s %v, got %d", c.v, c.typ, c.md, v)
+		}
+	}
+}
+
+func Test_SingleNodeCommandSchema(t *testing.T) {
+	s := mustNewStore()
+	defer os.RemoveAll(s.Path())
+	if err := s.Open(true); err != nil {
+		t.Fatalf("failed to open single-node store: %s", err.Error())
+	}
+	defer s.Close(true)
+	s.WaitForLeader(10 * time.Second)
+
+	// Commands of the first version are written as they are.
+	legacy, _ := proto.Marshal(&command.Command{Type: command.Type_COMMAND_TYPE_CREATE_NAMESPACE, Namespace: "default"})
+	if b, err := s.versionCommand(legacy); err != nil || string(b) != string(legacy) {
+		t.Fatalf("first version command changed: %v", err)
+	}
+	newer, _ := proto.Marshal(&command.Command{Type: command.Type_COMMAND_TYPE_LIST_VERSIONS, Namespace: "default"})
+	b, err := s.versionCommand(newer)
+	if err != nil {
+		t.Fatalf("failed to version command: %s", err.Error())
+	}
+	var c command.Command
+	if err := proto.Unmarshal(b, &c); err != nil {
+		t.Fatalf("failed to decode command: %s", err.Error())
+	}
+	if c.Metadata[schemaVersionMeta] != "2" || c.Metadata[schemaIgnorableMeta] != "true" {
+		t.Fatalf("command not stamped with its schema version: %v", c.Metadata)
+	}
+
+	// Variants selected by metadata are stamped with their own version.
+	for _, variant := range []*command.Command{
+		{Type: command.Type_COMMAND_TYPE_REMOVE_POLICIES, Namespace: "default", Metadata: map[string]string{removeSubjectMeta: "alice"}},
+		{Type: command.Type_COMMAND_TYPE_UPDATE_POLICIES, Namespace: "default", Metadata: map[string]string{renameSubjectMeta: "alice", renameSubjectToMeta: "bob"}},
+		{Type: command.Type_COMMAND_TYPE_ADD_POLICIES, Namespace: "default", Metadata: map[string]string{copyFromMeta: "other"}},
+		{Type: command.Type_COMMAND_TYPE_CREATE_NAMESPACE, Namespace: "default", Metadata: map[string]string{namespaceLabelsMeta: "{}"}},
+		{Type: command.Type_COMMAND_TYPE_ROLLBACK_POLICIES, Namespace: "default", Metadata: map[string]string{replacePoliciesMeta: "true"}},
+	} {
+		b, _ := proto.Marshal(variant)
+		if b, err = s.versionCommand(b); err != nil {
+			t.Fatalf("failed to version command: %s", err.Error())
+		}
+		var v command.Command
+		if err := proto.Unmarshal(b, &v); err != nil {
+			t.Fatalf("failed to decode command: %s", err.Error())
+		}
+		if v.Metadata[schemaVersionMeta] != "3" {
+			t.Fatalf("variant not stamped with its schema version: %v", v.Metadata)
+		}
+	}
+
+	// Queries from a newer schema are refused.
+	c.Metadata[schemaVersionMeta] = strconv.Itoa(FSMVersion + 1)
+	b, _ = proto.Marshal(&c)
+	if r, ok := s.Apply(&raft.Log{Index: 100, Data: b}).(*FSMResponse); !ok || !errors.Is(r.error, ErrUnsupportedCommand) {
+		t.Fatalf("query from a newer schema not refused")
+	}
+
+	// Changes from a newer schema stop the node rather than diverge.
+	delete(c.Metadata, schemaIgnorableMeta)
+	b, _ = proto.Marshal(&c)
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Fatalf("change from a newer schema applied")
+			}
+		}()
+		s.Apply(&raft.Log{Index: 101, Data: b})
+	}()
+}
+
+func Test_SingleNodeChanges(t *testing.T) {
+	s := mustNewStore()
+	defer os.RemoveAll(s.Path())
+	if err := s.Open(true); err != nil {
+		t.Fatalf("failed to open single-node store: %s", err.Error())
+	}
+	defer s.Close(true)
+	s.WaitForLeader(10 * time.Second)
+	for _, ns := range []string{"a", "b"} {
+		assert.Equal(t, nil, s.CreateNamespace(context.TODO(), ns))
+		assert.Equal(t, nil, s.SetModelFromString(context.TODO(), ns, modelText))
+		_, err := s.AddPolicies(context.TODO(), ns, "p", "p", [][]string{{"alice", "data1", "read"}})
+		assert.Equal(t, nil, err)
+	}
+	// queries are left out of the feed
+	_, err := s.ListNamespace(context.TODO())
+	assert.Equal(t, nil, err)
+
+	feed, err := s.Changes(nil, 0, 0)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 6, len(feed.Changes))
+	assert.Equal(t, s.raft.AppliedIndex(), feed.Next)
+	for i := 1; i < len(feed.Changes); i++ {
+		assert.True(t, feed.Changes[i].Index > feed.Changes[i-1].Index)
+	}
+
+	// namespaces are selected by patterns
+	feed, err = s.Changes([]string{"x", "a*"}, 0, 0)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 3, len(feed.Changes))
+	assert.Equal(t, "a", feed.Changes[0].Namespace)
+	_, err = s.Changes([]string{"["}, 0, 0)
+	assert.NotEqual(t, nil, err)
+
+	feed, err = s.Changes([]string{"b"}, 0, 2)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 2, len(feed.Changes))
+	assert.Equal(t, "COMMAND_TYPE_CREATE_NAMESPACE", feed.Changes[0].Type)
+	assert.Equal(t, "COMMAND_TYPE_SET_MODEL", feed.Changes[1].Type)
+
+	// resuming returns the remaining changes
+	feed, err = s.Changes([]string{"b"}, feed.Next, 0)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 1, len(feed.Changes))
+	assert.Equal(t, "COMMAND_TYPE_ADD_POLICIES", feed.Changes[0].Type)
+	var p command.AddPoliciesPayload
+	assert.Equal(t, nil, proto.Unmarshal(feed.Changes[0].Payload, &p))
+	assert.Equal(t, [][]string{{"alice", "data1", "read"}}, command.ToStringArray(p.Rules))
+
+	feed, err = s.Changes([]string{"b"}, feed.Next, 0)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 0, len(feed.Changes))
+
+	// changes of every namespace are split per selected namespace
+	since := feed.Next
+	_, err = s.RemoveSubject(context.TODO(), "a", "alice", true)
+	assert.Equal(t, nil, err)
+	feed, err = s.Changes([]string{"b"}, since, 0)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 1, len(feed.Changes))
+	assert.Equal(t, "b", feed.Changes[0].Namespace)
+	assert.Equal(t, map[string]string{removeSubjectMeta: "alice"}, feed.Changes[0].Options)
+
+	// copies across the filter are refused, after the changes before them
+	since = feed.Next
+	_, err = s.AddPolicies(context.TODO(), "b", "p", "p", [][]string{{"bob", "data1", "read"}})
+	assert.Equal(t, nil, err)
+	_, err = s.CopyPolicies(context.TODO(), "a", "b", nil, false)
+	assert.Equal(t, nil, err)
+	feed, err = s.Changes([]string{"b"}, since, 0)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 1, len(feed.Changes))
+	_, err = s.Changes([]string{"b"}, feed.Next, 0)
+	assert.True(t, errors.Is(err, ErrCrossNamespaceChange))
+	feed, err = s.Changes([]string{"a", "b"}, feed.Next, 0)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 1, len(feed.Changes))
+}
+
+func Test_SingleNodeStandby(t *testing.T) {
+	s := mustNewStore()
+	defer os.RemoveAll(s.Path())
+	if err := s.Open(true); err != nil {
+		t.Fatalf("failed to open single-node store: %s", err.Error())
+	}
+	defer s.Close(true)
+	s.WaitForLeader(10 * time.Second)
+	_, err := s.SetReadOnly(context.TODO(), true, "standby")
+	assert.Equal(t, nil, err)
+
+	// changes of the primary are applied while read-only, other writes are
+	// rejected
+	assert.Equal(t, nil, s.ApplyChange(context.TODO(), ChangeEvent{Index: 3, Namespace: "a", Type: "COMMAND_TYPE_CREATE_NAMESPACE"}))
+	assert.True(t, errors.Is(s.CreateNamespace(context.TODO(), "b"), ErrReadOnly))
+	model, _ := proto.Marshal(&command.SetModelFromString{Text: modelText})
+	assert.Equal(t, nil, s.ApplyChange(context.TODO(), ChangeEvent{Index: 4, Namespace: "a", Type: "COMMAND_TYPE_SET_MODEL", Payload: model}))
+	rules, _ := proto.Marshal(&command.AddPoliciesPayload{Sec: "p", PType: "p", Rules: command.NewStringArray([][]string{{"alice", "data1", "read"}})})
+	assert.Equal(t, nil, s.ApplyChange(context.TODO(), ChangeEvent{Index: 6, Namespace: "a", Type: "COMMAND_TYPE_ADD_POLICIES", Payload: rules}))
+	// a change replicated already is skipped
+	assert.Equal(t, nil, s.ApplyChange(context.TODO(), ChangeEvent{Index: 3, Namespace: "a", Type: "COMMAND_TYPE_CREATE_NAMESPACE"}))
+	assert.Equal(t, StandbyPosition{Cursor: 6, Namespaces: map[string]uint64{"a": 6}}, s.StandbyPosition())
+
+	// seeding replaces the policies of the namespace
+	policies := []*command.PolicyRules{{Sec: "p", PType: "p", Rules: command.NewStringArray([][]string{{"bob", "data2", "write"}})}}
+	assert.Equal(t, nil, s.SeedNamespace(context.TODO(), "a", modelText, policies, 42))
+	_, snapshot, _, err := s.NamespaceSnapshot(context.TODO(), "a")
+	assert.Equal(t, nil, err)
+	for _, p := range snapshot {
+		if p.Sec == "p" {
+			assert.Equal(t, [][]string{{"bob", "data2", "write"}}, command.ToStringArray(p.Rules))
+		}
+	}
+	assert.Equal(t, uint64(42), s.StandbyPosition().Resume())
+}
+
+func Test_SingleNodeStateDigest(t *testing.T) {
+	digest := func(rules [][]string) StateDigest {
+		s := mustNewStore()
+		defer os.RemoveAll(s.Path())
+		if err := s.Open(true); err != nil {
+			t.Fatalf("failed to open single-node store: %s", err.Error())
+		}
+		defer s.Close(true)
+		s.WaitForLeader(10 * time.Second)
+		assert.Equal(t, nil, s.CreateNamespace(context.TODO(), "default"))
+		assert.Equal(t, nil, s.SetModelFromString(context.TODO(), "default", modelText))
+		for _, r := range rules {
+			_, err := s.AddPolicies(context.TODO(), "default", "p", "p", [][]string{r})
+			assert.Equal(t, nil, err)
+		}
+		index, err := s.RecordDigest(context.TODO())
+		assert.Equal(t, nil, err)
+		d, ok := s.StateDigest(index)
+		assert.True(t, ok)
+		assert.Equal(t, index, d.Index)
+		_, ok = s.StateDigest(index + 1)
+		assert.False(t, ok)
+		return d
+	}
+	a := digest([][]string{{"alice", "data1", "read"}, {"bob", "data2", "write"}})
+	b := digest([][]string{{"bob", "data2", "write"}, {"alice", "data1", "read"}})
+	c := digest([][]string{{"alice", "data1", "read"}})
+	assert.Equal(t, 1, len(a.Namespaces))
+	// the order policies were added in doesn't matter
+	assert.Equal(t, a.Digest, b.Digest)
+	assert.NotEqual(t, a.Digest, c.Digest)
+	assert.NotEqual(t, a.Namespaces["default"], c.Namespaces["default"])
+}
+
+func Test_SingleNodeExplain(t *testing.T) {
+	s := mustNewStore()
+	defer os.RemoveAll(s.Path())
+	if err := s.Open(true); err != nil {
+		t.Fatalf("failed to open single-node store: %s", err.Error())
+	}
+	defer s.Close(true)
+	s.WaitForLeader(10 * time.Second)
+	assert.Equal(t, nil, s.CreateNamespace(context.TODO(), "default"))
+	assert.Equal(t, nil, s.SetModelFromString(context.TODO(), "default", modelText))
+	_, err := s.AddPolicies(context.TODO(), "default", "p", "p", [][]string{{"admin", "data1", "read"}, {"bob", "data2", "write"}})
+	assert.Equal(t, nil, err)
+	_, err = s.AddPolicies(context.TODO(), "default", "g", "g", [][]string{{"alice", "staff"}, {"staff", "admin"}})
+	assert.Equal(t, nil, err)
+
+	x, err := s.Explain("default", nil, "alice", "data1", "read")
+	assert.Equal(t, nil, err)
+	assert.True(t, x.Allowed)
+	assert.Equal(t, []string{"admin", "data1", "read"}, x.DecidingRule)
+	assert.Equal(t, 1, x.Allows)
+	assert.Equal(t, 2, len(x.Rules))
+	assert.True(t, x.Rules[0].Matched)
+	assert.Equal(t, []string{"alice", "staff", "admin"}, x.Rules[0].RoleChain)
+	assert.False(t, x.Rules[1].Matched)
+
+	x, err = s.Explain("default", nil, "alice", "data2", "write")
+	assert.Equal(t, nil, err)
+	assert.False(t, x.Allowed)
+	assert.Equal(t, 0, x.Allows)
+
+	_, err = s.Explain("missing", nil, "alice", "data1", "read")
+	assert.Equal(t, NamespaceNotExist, err)
+}
+
+func Test_SingleNodeRemoveSubject(t *testing.T) {
+	s := mustNewStore()
+	defer os.RemoveAll(s.Path())
+	if err := s.Open(true); err != nil {
+		t.Fatalf("failed to open single-node store: %s", err.Error())
+	}
+	defer s.Close(true)
+	s.WaitForLeader(10 * time.Second)
+	for _, ns := range []string{"a", "b"} {
+		assert.Equal(t, nil, s.CreateNamespace(context.TODO(), ns))
+		assert.Equal(t, nil, s.SetModelFromString(context.TODO(), ns, modelText))
+		_, err := s.AddPolicies(context.TODO(), ns, "p", "p", [][]string{{"alice", "data1", "read"}, {"bob", "data2", "write"}})
+		assert.Equal(t, nil, err)
+		_, err = s.AddPolicies(context.TODO(), ns, "g", "g", [][]string{{"alice", "admin"}, {"bob", "admin"}})
+		assert.Equal(t, nil, err)
+	}
+
+	removed, err := s.RemoveSubject(context.TODO(), "a", "alice", false)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, []RemovedRules{
+		{Namespace: "a", Sec: "p", PType: "p", Rules: [][]string{{"alice", "data1", "read"}}},
+		{Namespace: "a", Sec: "g", PType: "g", Rules: [][]string{{"alice", "admin"}}},
+	}, removed)
+	ok, err := s.Enforce(context.TODO(), "a", command.EnforcePayload_QUERY_REQUEST_LEVEL_NONE, 0, "alice", "data1", "read")
+	assert.Equal(t, nil, err)
+	assert.False(t, ok)
+	ok, err = s.Enforce(context.TODO(), "b", command.EnforcePayload_QUERY_REQUEST_LEVEL_NONE, 0, "alice", "data1", "read")
+	assert.Equal(t, nil, err)
+	assert.True(t, ok)
+
+	// roles are removed along with their members
+	removed, err = s.RemoveSubject(context.TODO(), "a", "admin", true)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, []RemovedRules{
+		{Namespace: "a", Sec: "g", PType: "g", Rules: [][]string{{"bob", "admin"}}},
+		{Namespace: "b", Sec: "g", PType: "g", Rules: [][]string{{"alice", "admin"}, {"bob", "admin"}}},
+	}, removed)
+
+	_, err = s.RemoveSubject(context.TODO(), "missing", "alice", false)
+	assert.Equal(t, NamespaceNotExist, err)
+}
+
+func Test_SingleNodeRenameSubject(t *testing.T) {
+	s := mustNewStore()
+	defer os.RemoveAll(s.Path())
+	if err := s.Open(true); err != nil {
+		t.Fatalf("failed to open single-node store: %s", err.Error())
+	}
+	defer s.Close(true)
+	s.WaitForLeader(10 * time.Second)
+	assert.Equal(t, nil, s.CreateNamespace(context.TODO(), "default"))
+	assert.Equal(t, nil, s.SetModelFromString(context.TODO(), "default", modelText))
+	_, err := s.AddPolicies(context.TODO(), "default", "p", "p", [][]string{{"alice", "data1", "read"}, {"bob", "data2", "write"}, {"alice@old", "data2", "write"}, {"alice", "data2", "write"}})
+	assert.Equal(t, nil, err)
+	_, err = s.AddPolicies(context.TODO(), "default", "g", "g", [][]string{{"alice@old", "admin"}})
+	assert.Equal(t, nil, err)
+	_, err = s.AnnotatePolicies(context.TODO(), "default", "p", "p", [][]string{{"alice@old", "data2", "write"}}, &command.Annotation{Owner: "team"})
+	assert.Equal(t, nil, err)
+
+	renamed, err := s.RenameSubject(context.TODO(), "default", "alice@old", "alice@new", false)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, []RenamedRules{
+		{Namespace: "default", Sec: "p", PType: "p", OldRules: [][]string{{"alice@old", "data2", "write"}}, NewRules: [][]string{{"alice@new", "data2", "write"}}},
+		{Namespace: "default", Sec: "g", PType: "g", OldRules: [][]string{{"alice@old", "admin"}}, NewRules: [][]string{{"alice@new", "admin"}}},
+	}, renamed)
+	ok, err := s.Enforce(context.TODO(), "default", command.EnforcePayload_QUERY_REQUEST_LEVEL_NONE, 0, "alice@new", "data2", "write")
+	assert.Equal(t, nil, err)
+	assert.True(t, ok)
+	ok, err = s.Enforce(context.TODO(), "default", command.EnforcePayload_QUERY_REQUEST_LEVEL_NONE, 0, "alice@old", "data2", "write")
+	assert.Equal(t, nil, err)
+	assert.False(t, ok)
+	annotations, err := s.ListAnnotations(context.TODO(), "default")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 1, len(annotations))
+	assert.Equal(t, []string{"alice@new", "data2", "write"}, annotations[0].Rule)
+
+	// renaming onto existing rules merges them
+	_, err = s.RenameSubject(context.TODO(), "default", "alice", "bob", false)
+	assert.Equal(t, nil, err)
+	_, policies, _, err := s.NamespaceSnapshot(context.TODO(), "default")
+	assert.Equal(t, nil, err)
+	for _, p := range policies {
+		if p.PType == "p" {
+			assert.Equal(t, [][]string{{"bob", "data1", "read"}, {"bob", "data2", "write"}, {"alice@new", "data2", "write"}}, command.ToStringArray(p.Rules))
+		}
+	}
+
+	_, err = s.RenameSubject(context.TODO(), "default", "bob", "bob", false)
+	assert.Equal(t, ErrInvalidRename, err)
+}
+
+func Test_SingleNodeCopyPolicies(t *testing.T) {
+	s := mustNewStore()
+	defer os.RemoveAll(s.Path())
+	if err := s.Open(true); err != nil {
+		t.Fatalf("failed to open single-node store: %s", err.Error())
+	}
+	defer s.Close(true)
+	s.WaitForLeader(10 * time.Second)
+	for _, ns := range []string{"monolith", "billing", "search"} {
+		assert.Equal(t, nil, s.CreateNamespace(context.TODO(), ns))
+		assert.Equal(t, nil, s.SetModelFromString(context.TODO(), ns, modelText))
+	}
+	_, err := s.AddPolicies(context.TODO(), "monolith", "p", "p", [][]string{{"alice", "invoices", "read"}, {"bob", "index", "write"}, {"carol", "invoices", "write"}})
+	assert.Equal(t, nil, err)
+
+	copied, err := s.CopyPolicies(context.TODO(), "monolith", "billing", url.Values{"v1": {"invoices"}}, false)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, []CopiedRules{{Sec: "p", PType: "p", Rules: [][]string{{"alice", "invoices", "read"}, {"carol", "invoices", "write"}}}}, copied)
+	ok, err := s.Enforce(context.TODO(), "billing", command.EnforcePayload_QUERY_REQUEST_LEVEL_NONE, 0, "alice", "invoices", "read")
+	assert.Equal(t, nil, err)
+	assert.True(t, ok)
+	ok, err = s.Enforce(context.TODO(), "monolith", command.EnforcePayload_QUERY_REQUEST_LEVEL_NONE, 0, "alice", "invoices", "read")
+	assert.Equal(t, nil, err)
+	assert.True(t, ok)
+
+	_, err = s.CopyPolicies(context.TODO(), "monolith", "search", url.Values{"v1": {"index"}}, true)
+	assert.Equal(t, nil, err)
+	ok, err = s.Enforce(context.TODO(), "search", command.EnforcePayload_QUERY_REQUEST_LEVEL_NONE, 0, "bob", "index", "write")
+	assert.Equal(t, nil, err)
+	assert.True(t, ok)
+	ok, err = s.Enforce(context.TODO(), "monolith", command.EnforcePayload_QUERY_REQUEST_LEVEL_NONE, 0, "bob", "index", "write")
+	assert.Equal(t, nil, err)
+	assert.False(t, ok)
+
+	// The change feed carries what the copy is made of, for standby
+	// clusters to replicate it.
+	feed, err := s.Changes([]string{"monolith", "search"}, 0, 0)
+	assert.Equal(t, nil, err)
+	last := feed.Changes[len(feed.Changes)-1]
+	assert.Equal(t, map[string]string{copyFromMeta: "monolith", copyFilterMeta: "v1=index", copyMoveMeta: "true"}, last.Options)
+	// A feed without the source can't replicate the copy.
+	feed, err = s.Changes([]string{"search"}, 0, 0)
+	assert.Equal(t, nil, err)
+	_, err = s.Changes([]string{"search"}, feed.Next, 0)
+	assert.True(t, errors.Is(err, ErrCrossNamespaceChange))
+
+	_, err = s.CopyPolicies(context.TODO(), "monolith", "monolith", nil, false)
+	assert.Equal(t, ErrCopySameNamespace, err)
+	_, err = s.CopyPolicies(context.TODO(), "monolith", "missing", nil, false)
+	assert.Equal(t, NamespaceNotExist, err)
+}
+
+func Test_SingleNodeNamespaceLabels(t *testing.T) {
+	s := mustNewStore()
+	defer os.RemoveAll(s.Path())
+	if err := s.Open(true); err != nil {
+		t.Fatalf("failed to open single-node store: %s", err.Error())
+	}
+	defer s.Close(true)
+	s.WaitForLeader(10 * time.Second)
+	for _, ns := range []string{"billing", "search", "staging"} {
+		assert.Equal(t, nil, s.CreateNamespace(context.TODO(), ns))
+	}
+	assert.Equal(t, nil, s.SetNamespaceLabels(context.TODO(), "billing", map[string]string{"env": "prod", "team": "payments"}))
+	assert.Equal(t, nil, s.SetNamespaceLabels(context.TODO(), "search", map[string]string{"env": "prod"}))
+	assert.Equal(t, nil, s.SetNamespaceLabels(context.TODO(), "staging", map[string]string{"env": "staging"}))
+	assert.Equal(t, NamespaceNotExist, s.SetNamespaceLabels(context.TODO(), "unknown", map[string]string{"env": "prod"}))
+
+	labels, err := s.NamespaceLabels("billing")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, map[string]string{"env": "prod", "team": "payments"}, labels)
+	matched, err := s.MatchNamespaces("env=prod")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, []string{"billing", "search"}, matched)
+	matched, err = s.MatchNamespaces("env=prod,!team")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, []string{"search"}, matched)
+	_, err = s.MatchNamespaces("")
+	assert.NotNil(t, err)
+
+	// Empty labels remove them.
+	assert.Equal(t, nil, s.SetNamespaceLabels(context.TODO(), "billing", nil))
+	matched, err = s.MatchNamespaces("env")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, []string{"search", "staging"}, matched)
+}
+
+func Test_SingleNodeRootSubjects(t *testing.T) {
+	s := mustNewStore()
+	defer os.RemoveAll(s.Path())
+	if err := s.Open(true); err != nil {
+		t.Fatalf("failed to open single-node store: %s", err.Error())
+	}
+	defer s.Close(true)
+	s.WaitForLeader(10 * time.Second)
+	assert.Equal(t, nil, s.CreateNamespace(context.TODO(), "default"))
+	assert.Equal(t, nil, s.SetModelFromString(context.TODO(), "default", modelText))
+	_, err := s.AddPolicies(context.TODO(), "default", "p", "p", [][]string{{"alice", "data1", "read"}})
+	assert.Equal(t, nil, err)
+
+	settings := NamespaceSettings{RootSubjects: []string{"root"}, RootClaim: "role=superuser"}
+	assert.Equal(t, nil, s.SetNamespaceSettings(context.TODO(), "default", settings))
+	assert.NotNil(t, s.SetNamespaceSettings(context.TODO(), "default", NamespaceSettings{RootClaim: "superuser"}))
+	assert.Equal(t, NamespaceNotExist, s.SetNamespaceSettings(context.TODO(), "unknown", settings))
+	got, err := s.NamespaceSettings("default")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, settings, got)
+
+	for _, c := range []struct {
+		sub    interface{}
+		expect bool
+	}{
+		{"root", true},
+		{"bob", false},
+		{map[string]interface{}{"role": "superuser"}, true},
+		{map[string]interface{}{"role": []interface{}{"staff", "superuser"}}, true},
+	} {
+		ok, err := s.Enforce(context.TODO(), "default", command.EnforcePayload_QUERY_REQUEST_LEVEL_NONE, 0, c.sub, "data2", "write")
+		assert.Equal(t, nil, err)
+		assert.Equal(t, c.expect, ok, "%v", c.sub)
+	}
+	x, err := s.Explain("default", nil, "root", "data2", "write")
+	assert.Equal(t, nil, err)
+	assert.True(t, x.Allowed)
+	assert.True(t, x.Root)
+
+	// The settings are saved in snapshots.
+	f, err := s.Snapshot()
+	assert.Equal(t, nil, err)
+	snapDir := mustTempDir()
+	defer os.RemoveAll(snapDir)
+	snapFile, err := os.Create(filepath.Join(snapDir, "snapshot"))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, nil, f.Persist(&mockSnapshotSink{snapFile}))
+	assert.Equal(t, nil, s.SetNamespaceSettings(context.TODO(), "default", NamespaceSettings{}))
+	ok, err := s.Enforce(context.TODO(), "default", command.EnforcePayload_QUERY_REQUEST_LEVEL_NONE, 0, "root", "data2", "write")
+	assert.Equal(t, nil, err)
+	assert.False(t, ok)
+	snapFile, err = os.Open(filepath.Join(snapDir, "snapshot"))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, nil, s.Restore(snapFile))
+	got, err = s.NamespaceSettings("default")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, settings, got)
+}
+
+func Test_SingleNodeEffects(t *testing.T) {
+	s := mustNewStore()
+	defer os.RemoveAll(s.Path())
+	if err := s.Open(true); err != nil {
+		t.Fatalf("failed to open single-node store: %s", err.Error())
+	}
+	defer s.Close(true)
+	s.WaitForLeader(10 * time.Second)
+	assert.Equal(t, nil, s.CreateNamespace(context.TODO(), "default"))
+	_, err := s.NamespaceEffects("default")
+	assert.Equal(t, ModelUnsetYet, err)
+
+	unsupported := strings.Replace(modelText, "some(where (p.eft == allow))", "some(where (p.eft == permit))", 1)
+	assert.True(t, errors.Is(s.SetModelFromString(context.TODO(), "default", unsupported), ErrUnsupportedEffect))
+	assert.Equal(t, nil, s.SetModelFromString(context.TODO(), "default", modelText))
+	effects, err := s.NamespaceEffects("default")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, []Effect{{Key: "e", Expr: "some(where (p_eft == allow))", Default: "deny"}}, effects)
+
+	// Requests failing to evaluate are denied once the namespace fails
+	// closed.
+	_, err = s.Enforce(context.TODO(), "default", command.EnforcePayload_QUERY_REQUEST_LEVEL_NONE, 0, "alice", "data1")
+	assert.NotNil(t, err)
+	assert.Equal(t, nil, s.SetNamespaceSettings(context.TODO(), "default", NamespaceSettings{FailClosed: true}))
+	for _, level := range []command.EnforcePayload_Level{command.EnforcePayload_QUERY_REQUEST_LEVEL_NONE, command.EnforcePayload_QUERY_REQUEST_LEVEL_STRONG} {
+		ok, err := s.Enforce(context.TODO(), "default", level, 0, "alice", "data1")
+		assert.Equal(t, nil, err)
+		assert.False(t, ok)
+	}
+}
+
+func Test_SingleNodeCacheHints(t *testing.T) {
+	s := mustNewStore()
+	defer os.RemoveAll(s.Path())
+	if err := s.Open(true); err != nil {
+		t.Fatalf("failed to open single-node store: %s", err.Error())
+	}
+	defer s.Close(true)
+	s.WaitForLeader(10 * time.Second)
+	_, err := s.CacheHints("default")
+	assert.Equal(t, NamespaceNotExist, err)
+	assert.Equal(t, nil, s.CreateNamespace(context.TODO(), "default"))
+	assert.Equal(t, nil, s.SetModelFromString(context.TODO(), "default", modelText))
+	h, err := s.CacheHints("default")
+	assert.Equal(t, nil, err)
+	assert.NotEqual(t, uint64(0), h.Version)
+	assert.Equal(t, DefaultDecisionTTL, h.TTL)
+
+	// Reads keep the version, changes bump it.
+	_, err = s.Enforce(context.TODO(), "default", command.EnforcePayload_QUERY_REQUEST_LEVEL_STRONG, 0, "alice", "data1", "read")
+	assert.Equal(t, nil, err)
+	same, _ := s.CacheHints("default")
+	assert.Equal(t, h.Version, same.Version)
+	_, err = s.AddPolicies(context.TODO(), "default", "p", "p", [][]string{{"alice", "data1", "read"}})
+	assert.Equal(t, nil, err)
+	changed, _ := s.CacheHints("default")
+	assert.True(t, changed.Version > h.Version)
+
+	// The TTL ends when a rule expires.
+	_, err = s.AddExpiringPolicies(context.TODO(), "default", "p", "p", [][]string{{"bob", "data2", "write"}}, time.Now().Add(3*time.Second))
+	assert.Equal(t, nil, err)
+	expiring, _ := s.CacheHints("default")
+	assert.True(t, expiring.TTL > 0 && expiring.TTL <= 3*time.Second, expiring.TTL.String())
+
+	// Versions are saved in snapshots.
+	f, err := s.Snapshot()
+	assert.Equal(t, nil, err)
+	snapDir := mustTempDir()
+	defer os.RemoveAll(snapDir)
+	snapFile, err := os.Create(filepath.Join(snapDir, "snapshot"))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, nil, f.Persist(&mockSnapshotSink{snapFile}))
+	_, err = s.AddPolicies(context.TODO(), "default", "p", "p", [][]string{{"carol", "data1", "read"}})
+	assert.Equal(t, nil, err)
+	snapFile, err = os.Open(filepath.Join(snapDir, "snapshot"))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, nil, s.Restore(snapFile))
+	restored, _ := s.CacheHints("default")
+	assert.Equal(t, expiring.Version, restored.Version)
+}
+
+func Test_SingleNodePolicySetVersions(t *testing.T) {
+	s := mustNewStore()
+	defer os.RemoveAll(s.Path())
+	if err := s.Open(true); err != nil {
+		t.Fatalf("failed to open single-node store: %s", err.Error())
+	}
+	defer s.Close(true)
+	s.WaitForLeader(10 * time.Second)
+	for _, ns := range []string{"edge-a", "edge-b", "core"} {
+		assert.Equal(t, nil, s.CreateNamespace(context.TODO(), ns))
+	}
+	all, err := s.PolicySetVersions(nil)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 3, len(all))
+	edges, err := s.PolicySetVersions([]string{"edge-*", "unknown"})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, map[string]uint64{"edge-a": all["edge-a"], "edge-b": all["edge-b"]}, edges)
+	_, err = s.PolicySetVersions([]string{"["})
+	assert.NotNil(t, err)
+
+	// Moving rules versions both namespaces.
+	assert.Equal(t, nil, s.SetModelFromString(context.TODO(), "edge-a", modelText))
+	assert.Equal(t, nil, s.SetModelFromString(context.TODO(), "edge-b", modelText))
+	_, err = s.AddPolicies(context.TODO(), "edge-a", "p", "p", [][]string{{"alice", "data1", "read"}})
+	assert.Equal(t, nil, err)
+	_, err = s.CopyPolicies(context.TODO(), "edge-a", "edge-b", nil, true)
+	assert.Equal(t, nil, err)
+	moved, err := s.PolicySetVersions([]string{"edge-*"})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, moved["edge-a"], moved["edge-b"])
+	assert.True(t, moved["edge-a"] > edges["edge-a"])
+}
+
+func Test_SingleNodeIntegrity(t *testing.T) {
+	s := mustNewStore()
+	defer os.RemoveAll(s.Path())
+	assert.Equal(t, nil, CheckIntegrity(s.Path()))
+	if err := s.Open(true); err != nil {
+		t.Fatalf("failed to open single-node store: %s", err.Error())
+	}
+	s.WaitForLeader(10 * time.Second)
+	assert.Equal(t, nil, s.CreateNamespace(context.TODO(), "default"))
+	assert.Equal(t, nil, s.raft.Snapshot().Error())
+	assert.Equal(t, nil, s.Close(true))
+	assert.Equal(t, nil, CheckIntegrity(s.Path()))
+
+	// Corrupt the snapshot.
+	snaps, err := filepath.Glob(filepath.Join(s.Path(), snapshotsDir, "*", "state.bin"))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 1, len(snaps))
+	b, err := ioutil.ReadFile(snaps[0])
+	assert.Equal(t, nil, err)
+	b[len(b)-1] ^= 0xff
+	assert.Equal(t, nil, ioutil.WriteFile(snaps[0], b, 0644))
+	err = CheckIntegrity(s.Path())
+	var ie *IntegrityError
+	assert.True(t, errors.As(err, &ie))
+
+	// The only voter has no leader to get the state again from.
+	_, err = Quarantine(s.Path())
+	assert.Equal(t, ErrNoOtherVoter, err)
+	assert.True(t, errors.As(CheckIntegrity(s.Path()), &ie))
+}
+
+func Test_MultiNodeQuarantine(t *testing.T) {
+	s0 := mustNewStore()
+	defer os.RemoveAll(s0.Path())
+	if err := s0.Open(true); err != nil {
+		t.Fatalf("failed to open node for multi-node test: %s", err.Error())
+	}
+	defer s0.Close(true)
+	s0.WaitForLeader(10 * time.Second)
+
+	s1 := mustNewStore()
+	defer os.RemoveAll(s1.Path())
+	if err := s1.Open(false); err != nil {
+		t.Fatalf("failed to open node for multi-node test: %s", err.Error())
+	}
+	if err := s0.Join(s1.ID(), s1.Addr(), true, nil); err != nil {
+		t.Fatalf("failed to join to node at %s: %s", s0.Addr(), err.Error())
+	}
+	s1.WaitForLeader(10 * time.Second)
+	assert.Equal(t, nil, s0.CreateNamespace(context.TODO(), "default"))
+	assert.Equal(t, nil, s1.WaitForAppliedIndex(s0.raft.AppliedIndex(), 5*time.Second))
+	term, err := s1.boltStore.GetUint64([]byte("CurrentTerm"))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, nil, s1.Close(true))
+
+	dir, err := Quarantine(s1.Path())
+	assert.Equal(t, nil, err)
+	assert.True(t, pathExists(filepath.Join(dir, raftDBPath)))
+	assert.True(t, pathExists(filepath.Join(dir, stateDBPath)))
+	// The log is emptied, the term is kept.
+	assert.False(t, IsNewNode(s1.Path()))
+	assert.Equal(t, nil, CheckIntegrity(s1.Path()))
+	l, err := rlog.NewLog(filepath.Join(s1.Path(), raftDBPath))
+	assert.Equal(t, nil, err)
+	_, last, err := l.Indexes()
+	assert.Equal(t, nil, err)
+	assert.Equal(t, uint64(0), last)
+	got, err := l.GetUint64([]byte("CurrentTerm"))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, term, got)
+	assert.Equal(t, nil, l.Close())
+
+	// The node must not vote with its empty log, the leader removes it and
+	// adds it back as a non-voter before it opens.
+	assert.True(t, RepairPending(s1.Path()))
+	s1 = mustNewStoreAtPath(s1.Path())
+	addr := s1.ln.Addr().String()
+	assert.Equal(t, nil, s0.Join(s1.ID(), addr, true, map[string]string{RepairKey: "true"}))
+	nodes, err := s0.Nodes()
+	assert.Equal(t, nil, err)
+	for _, n := range nodes {
+		if n.ID == s1.ID() {
+			assert.False(t, n.Voter)
+			assert.Equal(t, addr, n.Addr)
+			assert.Equal(t, promotionPending, n.Metadata[PromotionKey])
+			assert.Equal(t, "", n.Metadata[RepairKey])
+		}
+	}
+	assert.Equal(t, nil, FinishRepair(s1.Path()))
+	assert.False(t, RepairPending(s1.Path()))
+
+	// It votes again once it caught up.
+	if err := s1.Open(false); err != nil {
+		t.Fatalf("failed to open repaired node: %s", err.Error())
+	}
+	defer s1.Close(true)
+	assert.Equal(t, nil, s1.WaitForAppliedIndex(s0.raft.AppliedIndex(), 5*time.Second))
+	promoted := false
+	for i := 0; i < 100 && !promoted; i++ {
+		time.Sleep(100 * time.Millisecond)
+		nodes, err := s0.Nodes()
+		assert.Equal(t, nil, err)
+		for _, n := range nodes {
+			promoted = promoted || n.ID == s1.ID() && n.Voter
+		}
+	}
+	assert.True(t, promoted, "repaired node not promoted")
+}
+
+func Test_SingleNodeMigrate(t *testing.T) {
 	s := mustNewStore()
 	defer os.RemoveAll(s.Path())
-
 	if err := s.Open(true); err != nil {
 		t.Fatalf("failed to open single-node store: %s", err.Error())
 	}
-	defer s.Close(true)
 	s.WaitForLeader(10 * time.Second)
-
-	err := s.CreateNamespace(context.TODO(), "default")
+	assert.Equal(t, nil, s.CreateNamespace(context.TODO(), "default"))
+	assert.Equal(t, nil, s.Close(true))
+	info, err := ReadDataFormat(s.Path())
 	assert.Equal(t, nil, err)
-	err = s.SetModelFromString(context.TODO(), "default", modelText)
+	assert.Equal(t, DataFormat, info.Format)
+
+	// A node created before formats were recorded.
+	assert.Equal(t, nil, os.Remove(filepath.Join(s.Path(), formatFile)))
+	plan, err := PlanMigration(s.Path())
 	assert.Equal(t, nil, err)
-	_, err = s.AddPolicies(context.TODO(), "default", "p", "p", [][]string{
-		{"alice", "data1", "read"},
-		{"bob", "data2", "write"},
-		{"data2_admin", "data2", "read"},
-		{"data2_admin", "data2", "write"},
-	})
+	assert.Equal(t, DataFormat, len(plan))
+	s = mustNewStoreAtPath(s.Path())
+	s.AutoMigrate = false
+	err = s.Open(true)
+	assert.True(t, errors.Is(err, ErrMigrationNeeded))
+	applied, err := Migrate(s.Path())
 	assert.Equal(t, nil, err)
-	_, err = s.AddPolicies(context.TODO(), "default", "g", "g", [][]string{
-		{"alice", "data2_admin"},
-	})
+	assert.Equal(t, len(plan), len(applied))
+	plan, err = PlanMigration(s.Path())
 	assert.Equal(t, nil, err)
-
-	// Snap the node and write to disk.
-	f, err := s.Snapshot()
-	if err != nil {
-		t.Fatalf("failed to snapshot node: %s", err.Error())
-	}
-
-	snapDir := mustTempDir()
-	defer os.RemoveAll(snapDir)
-	snapFile, err := os.Create(filepath.Join(snapDir, "snapshot"))
-	if err != nil {
-		t.Fatalf("failed to create snapshot file: %s", err.Error())
-	}
-	sink := &mockSnapshotSink{snapFile}
-	if err := f.Persist(sink); err != nil {
-		t.Fatalf("failed to persist snapshot to disk: %s", err.Error())
-	}
-
-	// Check restoration.
-	snapFile, err = os.Open(filepath.Join(snapDir, "snapshot"))
-	if err != nil {
-		t.Fatalf("failed to open snapshot file: %s", err.Error())
-	}
-	if err := s.Restore(snapFile); err != nil {
-		t.Fatalf("failed to restore snapshot from disk: %s", err.Error())
-	}
-
-	for _, set := range RBAC_TEST_SETS {
-		fmt.Println(set.input)
-		r, err := s.Enforce(context.TODO(), "default", command.EnforcePayload_QUERY_REQUEST_LEVEL_NONE, 0, set.input...)
-		assert.Equal(t, nil, err)
-		assert.Equal(t, set.expect, r)
-	}
+	assert.Equal(t, 0, len(plan))
+
+	// A node written by a newer version.
+	assert.Equal(t, nil, writeDataFormat(s.Path(), DataFormat+1))
+	_, err = PlanMigration(s.Path())
+	assert.True(t, errors.Is(err, ErrDowngrade))
+	s = mustNewStoreAtPath(s.Path())
+	assert.True(t, errors.Is(s.Open(true), ErrDowngrade))
 }
 
-func Test_IsLeader(t *testing.T) {
+func Test_SingleNodeDiskSpaceLow(t *testing.T) {
 	s := mustNewStore()
 	defer os.RemoveAll(s.Path())
-
+	s.MinFreeDisk = math.MaxUint64
 	if err := s.Open(true); err != nil {
 		t.Fatalf("failed to open single-node store: %s", err.Error())
 	}
 	defer s.Close(true)
 	s.WaitForLeader(10 * time.Second)
 
-	if !s.IsLeader() {
-		t.Fatalf("single node is not leader!")
+	status := s.DiskStatus()
+	assert.True(t, status.Low)
+	assert.NotEqual(t, int64(0), status.Since)
+	err := s.CreateNamespace(context.TODO(), "default")
+	assert.True(t, errors.Is(err, ErrReadOnly))
+
+	// Writes are accepted again once space is freed.
+	s.MinFreeDisk = 1
+	s.checkDisk()
+	assert.False(t, s.DiskStatus().Low)
+	assert.Equal(t, nil, s.CreateNamespace(context.TODO(), "default"))
+}
+
+func Test_MultiNodeDiskSpaceLow(t *testing.T) {
+	s0 := mustNewStore()
+	defer os.RemoveAll(s0.Path())
+	if err := s0.Open(true); err != nil {
+		t.Fatalf("failed to open node for multi-node test: %s", err.Error())
+	}
+	defer s0.Close(true)
+	s0.WaitForLeader(10 * time.Second)
+
+	s1 := mustNewStore()
+	defer os.RemoveAll(s1.Path())
+	s1.PublishMetadata = func(md map[string]string) error {
+		return s0.SetNodeMetadata(s1.ID(), md)
+	}
+	if err := s1.Open(false); err != nil {
+		t.Fatalf("failed to open node for multi-node test: %s", err.Error())
+	}
+	defer s1.Close(true)
+	if err := s0.Join(s1.ID(), s1.Addr(), true, nil); err != nil {
+		t.Fatalf("failed to join to node at %s: %s", s0.Addr(), err.Error())
 	}
+	s1.WaitForLeader(10 * time.Second)
+
+	// The follower is low on space, the leader alone is no quorum.
+	s1.MinFreeDisk = math.MaxUint64
+	s1.checkDisk()
+	assert.Equal(t, "true", s0.Metadata(s1.ID(), DiskLowKey))
+	err := s0.CreateNamespace(context.TODO(), "default")
+	assert.True(t, errors.Is(err, ErrReadOnly))
+	assert.Equal(t, uint64(1), s0.DiskStatus().Refused)
+
+	s1.MinFreeDisk = 1
+	s1.checkDisk()
+	assert.Equal(t, "false", s0.Metadata(s1.ID(), DiskLowKey))
+	assert.Equal(t, nil, s0.CreateNamespace(context.TODO(), "default"))
 }
 
-func Test_State(t *testing.T) {
+func Test_SingleNodeVerifySnapshot(t *testing.T) {
 	s := mustNewStore()
 	defer os.RemoveAll(s.Path())
-
 	if err := s.Open(true); err != nil {
 		t.Fatalf("failed to open single-node store: %s", err.Error())
 	}
 	defer s.Close(true)
 	s.WaitForLeader(10 * time.Second)
 
-	state := s.State()
-	if state != Leader {
-		t.Fatalf("single node returned incorrect state (not Leader): %v", s)
-	}
-}
-
-type mockSnapshotSink struct {
-	*os.File
-}
+	_, err := s.VerifyLatestSnapshot()
+	assert.Equal(t, ErrNoSnapshot, err)
 
-func (m *mockSnapshotSink) ID() string {
-	return "1"
-}
+	assert.Equal(t, nil, s.CreateNamespace(context.TODO(), "default"))
+	assert.Equal(t, nil, s.SetModelFromString(context.TODO(), "default", modelText))
+	_, err = s.AddPolicies(context.TODO(), "default", "p", "p", [][]string{{"alice", "data1", "read"}, {"bob", "data2", "write"}})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, nil, s.raft.Snapshot().Error())
 
-func (m *mockSnapshotSink) Cancel() error {
-	return nil
+	v, err := s.VerifyLatestSnapshot()
+	assert.Equal(t, nil, err)
+	assert.True(t, v.OK, v.Error)
+	assert.Equal(t, 1, v.Namespaces)
+	assert.Equal(t, 2, v.Policies)
+	assert.NotEqual(t, "", v.Recorded)
+	assert.Equal(t, v.Recorded, v.Digest)
+
+	v = VerifySnapshot(bytes.NewReader([]byte("not a snapshot")))
+	assert.False(t, v.OK)
 }
 
-type EnforceData struct {
-	input  []interface{}
-	expect bool
+func Test_PatternCache(t *testing.T) {
+	c := newPatternCache()
+	funcs := map[string]struct {
+		cached func(args ...interface{}) (interface{}, error)
+		casbin func(key1, key2 string) bool
+	}{
+		"keyMatch2":  {c.matchFunc("keyMatch2", keyMatch2Pattern), util.KeyMatch2},
+		"keyMatch3":  {c.matchFunc("keyMatch3", keyMatch3Pattern), util.KeyMatch3},
+		"regexMatch": {c.matchFunc("regexMatch", regexPattern), util.RegexMatch},
+	}
+	cases := [][2]string{
+		{"/foo/bar", "/foo/*"},
+		{"/foo", "/foo/bar/*"},
+		{"/resource1", "/:resource"},
+		{"/resource1", "/{resource}"},
+		{"/alice/data/1", "/:user/data/{id}"},
+		{"/alice/data", "/:user/data/:id"},
+		{"GET", "GET|POST"},
+		{"DELETE", "^(GET|POST)$"},
+	}
+	for name, f := range funcs {
+		for _, k := range cases {
+			for i := 0; i < 2; i++ {
+				got, err := f.cached(k[0], k[1])
+				assert.Equal(t, nil, err)
+				assert.Equal(t, f.casbin(k[0], k[1]), got, "%s(%q, %q)", name, k[0], k[1])
+			}
+		}
+	}
+	// the patterns compiled once are reused
+	assert.Equal(t, 3*len(cases), len(c.re))
+
+	_, err := funcs["regexMatch"].cached("a", "(")
+	assert.NotEqual(t, nil, err)
+	_, err = funcs["keyMatch2"].cached("a")
+	assert.NotEqual(t, nil, err)
 }
 
-var (
-	RBAC_TEST_SETS = []EnforceData{
-		{
-			[]interface{}{"alice", "data1", "read"}, true,
-		},
-		{
-			[]interface{}{"alice", "data1", "write"}, false,
-		},
-		{
-			[]interface{}{"alice", "data2", "read"}, true,
-		},
-		{
-			[]interface{}{"alice", "data2", "write"}, true,
-		},
-		{
-			[]interface{}{"bob", "data1", "read"}, false,
-		},
-		{
-			[]interface{}{"bob", "data1", "write"}, false,
-		},
-		{
-			[]interface{}{"bob", "data2", "read"}, false,
-		},
-		{
-			[]interface{}{"bob", "data2", "write"}, true,
-		},
+func Test_SingleNodeEnforcePatterns(t *testing.T) {
+	s := mustNewStore()
+	defer os.RemoveAll(s.Path())
+	if err := s.Open(true); err != nil {
+		t.Fatalf("failed to open single-node store: %s", err.Error())
 	}
-)
-var (
-	modelText = `
+	defer s.Close(true)
+	s.WaitForLeader(10 * time.Second)
+	ctx := context.TODO()
+	assert.Equal(t, nil, s.CreateNamespace(ctx, "api"))
+	assert.Equal(t, nil, s.SetModelFromString(ctx, "api", `
 [request_definition]
 r = sub, obj, act
 
 [policy_definition]
 p = sub, obj, act
 
-[role_definition]
-g = _, _
-
 [policy_effect]
 e = some(where (p.eft == allow))
 
 [matchers]
-m = g(r.sub, p.sub) && r.obj == p.obj && r.act == p.act
-`
-	incorrectModelText = `
-[request_definition
-r = sub, obj, act
-
-[policy_definition]
-p = sub, obj, act
-
-[role_definition]
-g = _, _
+m = r.sub == p.sub && keyMatch2(r.obj, p.obj) && regexMatch(r.act, p.act)
+`))
+	_, err := s.AddPolicies(ctx, "api", "p", "p", [][]string{
+		{"alice", "/users/:id", "GET"},
+		{"alice", "/orders/*", "(GET)|(POST)"},
+	})
+	assert.Equal(t, nil, err)
+	for _, c := range []struct {
+		obj, act string
+		exp      bool
+	}{
+		{"/users/1", "GET", true},
+		{"/users/1/roles", "GET", false},
+		{"/users/1", "DELETE", false},
+		{"/orders/2/items", "POST", true},
+		{"/orders", "GET", false},
+	} {
+		for i := 0; i < 2; i++ {
+			ok, err := s.Enforce(ctx, "api", 0, 0, "alice", c.obj, c.act)
+			assert.Equal(t, nil, err)
+			assert.Equal(t, c.exp, ok, "%s %s", c.act, c.obj)
+		}
+	}
+}
 
-[policy_effect]
-e = some(where (p.eft == allow))
+func Test_RoleHierarchy(t *testing.T) {
+	existing := [][]string{{"alice", "dev"}, {"dev", "staff"}, {"bob", "admin", "t1"}}
+
+	err := checkRoleHierarchy(existing, [][]string{{"staff", "alice"}}, nil, 10)
+	assert.True(t, errors.Is(err, ErrRoleCycle))
+	assert.Contains(t, err.Error(), "staff -> alice -> dev -> staff")
+	err = checkRoleHierarchy(existing, [][]string{{"admin", "admin", "t1"}}, nil, 10)
+	assert.True(t, errors.Is(err, ErrRoleCycle))
+	assert.Contains(t, err.Error(), "in domain t1")
+	// links only chain within a domain
+	assert.Equal(t, nil, checkRoleHierarchy(existing, [][]string{{"admin", "bob", "t2"}}, nil, 10))
+	// a link removed by an update breaks the cycle
+	assert.Equal(t, nil, checkRoleHierarchy(existing, [][]string{{"staff", "alice"}}, [][]string{{"alice", "dev"}}, 10))
+
+	assert.Equal(t, nil, checkRoleHierarchy(existing, [][]string{{"staff", "root"}}, nil, 3))
+	err = checkRoleHierarchy(existing, [][]string{{"staff", "root"}, {"root", "top"}}, nil, 3)
+	assert.True(t, errors.Is(err, ErrRoleTooDeep))
+	assert.Contains(t, err.Error(), "alice -> dev -> staff -> root -> top has 4 links, over 3")
+	// a link joining two chains counts both
+	err = checkRoleHierarchy([][]string{{"a", "b"}, {"c", "d"}}, [][]string{{"b", "c"}}, nil, 2)
+	assert.True(t, errors.Is(err, ErrRoleTooDeep))
+	assert.Contains(t, err.Error(), "a -> b -> c -> d")
+}
 
-[matchers]
-m = g(r.sub, p.sub) && r.obj == p.obj && r.act == p.act
-`
-)
+func Test_SingleNodeRoleHierarchy(t *testing.T) {
+	s := mustNewStore()
+	defer os.RemoveAll(s.Path())
+	if err := s.Open(true); err != nil {
+		t.Fatalf("failed to open single-node store: %s", err.Error())
+	}
+	defer s.Close(true)
+	s.WaitForLeader(10 * time.Second)
+	s.MaxRoleDepth = 2
+	ctx := context.TODO()
+	assert.Equal(t, nil, s.CreateNamespace(ctx, "default"))
+	assert.Equal(t, nil, s.SetModelFromString(ctx, "default", modelText))
+	_, err := s.AddPolicies(ctx, "default", "g", "g", [][]string{{"alice", "dev"}, {"dev", "staff"}, {"bob", "dev"}})
+	assert.Equal(t, nil, err)
 
-type mockListener struct {
-	ln net.Listener
-}
+	_, err = s.AddPolicies(ctx, "default", "g", "g", [][]string{{"staff", "alice"}})
+	assert.True(t, errors.Is(err, ErrRoleCycle))
+	_, err = s.AddPolicies(ctx, "default", "g", "g", [][]string{{"staff", "root"}})
+	assert.True(t, errors.Is(err, ErrRoleTooDeep))
+	_, err = s.UpdatePolicies(ctx, "default", "g", "g", [][]string{{"staff", "root"}}, [][]string{{"bob", "dev"}})
+	assert.True(t, errors.Is(err, ErrRoleTooDeep))
+	ok, err := s.UpdatePolicies(ctx, "default", "g", "g", [][]string{{"dev", "root"}}, [][]string{{"dev", "staff"}})
+	assert.Equal(t, nil, err)
+	assert.True(t, ok)
+	// policies are not checked
+	_, err = s.AddPolicies(ctx, "default", "p", "p", [][]string{{"staff", "alice", "read"}})
+	assert.Equal(t, nil, err)
 
-func (m *mockListener) Dial(addr string, timeout time.Duration) (net.Conn, error) {
-	return net.DialTimeout("tcp", addr, timeout)
+	// neither are rules replaced, copied, renamed or rolled back to
+	_, _, err = s.ReplacePolicies(ctx, "default", []*command.PolicyRules{{Sec: "g", PType: "g",
+		Rules: command.NewStringArray([][]string{{"alice", "dev"}, {"dev", "alice"}})}})
+	assert.True(t, errors.Is(err, ErrRoleCycle))
+	assert.Equal(t, nil, s.CreateNamespace(ctx, "other"))
+	assert.Equal(t, nil, s.SetModelFromString(ctx, "other", modelText))
+	_, err = s.AddPolicies(ctx, "other", "g", "g", [][]string{{"dev", "alice"}})
+	assert.Equal(t, nil, err)
+	_, err = s.CopyPolicies(ctx, "default", "other", url.Values{"v0": {"alice"}}, false)
+	assert.True(t, errors.Is(err, ErrRoleCycle))
+	_, err = s.CopyPolicies(ctx, "default", "other", url.Values{"v0": {"bob"}}, false)
+	assert.Equal(t, nil, err)
+	_, err = s.RenameSubject(ctx, "default", "bob", "root", false)
+	assert.True(t, errors.Is(err, ErrRoleCycle))
+	_, err = s.TagVersion(ctx, "default", "deep", 0)
+	assert.Equal(t, nil, err)
+	_, err = s.RemovePolicies(ctx, "default", "g", "g", [][]string{{"dev", "root"}})
+	assert.Equal(t, nil, err)
+	s.MaxRoleDepth = 1
+	_, err = s.RollbackPolicies(ctx, "default", 0, "deep")
+	assert.True(t, errors.Is(err, ErrRoleTooDeep))
+	e, _ := s.enforcers.Load("default")
+	assert.Equal(t, [][]string{{"alice", "dev"}, {"bob", "dev"}}, e.(*casbin.DistributedEnforcer).GetGroupingPolicy())
 }
 
-func (m *mockListener) Accept() (net.Conn, error) { return m.ln.Accept() }
-
-func (m *mockListener) Close() error { return m.ln.Close() }
+func Test_SingleNodeTimeBoundRoles(t *testing.T) {
+	s := mustNewStore()
+	defer os.RemoveAll(s.Path())
+	if err := s.Open(true); err != nil {
+		t.Fatalf("failed to open single-node store: %s", err.Error())
+	}
+	defer s.Close(true)
+	s.WaitForLeader(10 * time.Second)
+	ctx := context.TODO()
+	assert.Equal(t, nil, s.CreateNamespace(ctx, "default"))
+	assert.Equal(t, nil, s.SetModelFromString(ctx, "default", modelText))
+	_, err := s.AddPolicies(ctx, "default", "p", "p", [][]string{{"admin", "data1", "read"}})
+	assert.Equal(t, nil, err)
 
-func (m *mockListener) Addr() net.Addr { return m.ln.Addr() }
+	now := time.Now()
+	_, err = s.AddExpiringPolicies(ctx, "default", "g", "g", [][]string{{"alice", "admin"}}, now.Add(time.Hour))
+	assert.Equal(t, nil, err)
+	_, err = s.AddExpiringPolicies(ctx, "default", "g", "g", [][]string{{"bob", "admin"}}, now.Add(-time.Second))
+	assert.Equal(t, nil, err)
+	window := &command.Schedule{NotBefore: now.Add(time.Hour).Unix(), NotAfter: now.Add(2 * time.Hour).Unix()}
+	_, err = s.AddScheduledPolicies(ctx, "default", "g", "g", [][]string{{"carol", "admin"}}, window)
+	assert.Equal(t, nil, err)
 
-func mustMockLister(addr string) Listener {
-	ln, err := net.Listen("tcp", addr)
-	if err != nil {
-		panic("failed to create new listner")
+	// links are left out once expired, even before they are removed, and
+	// until their window starts
+	for user, exp := range map[string]bool{"alice": true, "bob": false, "carol": false} {
+		ok, err := s.Enforce(ctx, "default", 0, 0, user, "data1", "read")
+		assert.Equal(t, nil, err)
+		assert.Equal(t, exp, ok, user)
 	}
-	return &mockListener{ln}
+	rules, err := s.SchedulePolicies(ctx, "default", "g", "g", now.Add(90*time.Minute), true)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, [][]string{{"carol", "admin"}}, rules)
+	ok, err := s.Enforce(ctx, "default", 0, 0, "carol", "data1", "read")
+	assert.Equal(t, nil, err)
+	assert.True(t, ok)
 }
 
-func mustTempDir() string {
-	var err error
-	path, err := ioutil.TempDir("", "casbin-mesh-test-")
-	if err != nil {
-		panic("failed to create temp dir")
+func Test_MultiNodeClusterID(t *testing.T) {
+	s0 := mustNewStore()
+	defer os.RemoveAll(s0.Path())
+	if err := s0.Open(true); err != nil {
+		t.Fatalf("failed to open node for multi-node test: %s", err.Error())
 	}
-	return path
+	defer s0.Close(true)
+	s0.WaitForLeader(10 * time.Second)
+	id := mustWaitClusterID(t, s0)
+	b, err := ioutil.ReadFile(filepath.Join(s0.Path(), clusterIDFile))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, id, strings.TrimSpace(string(b)))
+	assert.Equal(t, nil, s0.CheckClusterID(""))
+	assert.Equal(t, nil, s0.CheckClusterID(id))
+
+	s1 := mustNewStore()
+	defer os.RemoveAll(s1.Path())
+	if err := s1.Open(false); err != nil {
+		t.Fatalf("failed to open node for multi-node test: %s", err.Error())
+	}
+	defer s1.Close(true)
+	err = s0.Join(s1.ID(), s1.Addr(), true, map[string]string{ClusterIDKey: "other"})
+	assert.True(t, errors.Is(err, ErrClusterMismatch), "%v", err)
+	assert.Equal(t, nil, s0.Join(s1.ID(), s1.Addr(), true, nil))
+	s1.WaitForLeader(10 * time.Second)
+	assert.Equal(t, id, mustWaitClusterID(t, s1))
+	// members which don't know cluster IDs would stop on the entry
+	assert.Equal(t, nil, s0.SetNodeMetadata(s1.ID(), map[string]string{FSMVersionKey: "4"}))
+	err = s0.proposeClusterID()
+	assert.True(t, errors.Is(err, ErrUnsupportedByCluster), "%v", err)
+	assert.Equal(t, id, s0.ClusterID())
+
+	// Snapshots of another cluster are refused.
+	s2 := mustNewStore()
+	defer os.RemoveAll(s2.Path())
+	if err := s2.Open(true); err != nil {
+		t.Fatalf("failed to open node for multi-node test: %s", err.Error())
+	}
+	defer s2.Close(true)
+	s2.WaitForLeader(10 * time.Second)
+	assert.NotEqual(t, id, mustWaitClusterID(t, s2))
+	f, err := s2.Snapshot()
+	assert.Equal(t, nil, err)
+	snapDir := mustTempDir()
+	defer os.RemoveAll(snapDir)
+	snapFile, err := os.Create(filepath.Join(snapDir, "snapshot"))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, nil, f.Persist(&mockSnapshotSink{snapFile}))
+	snapFile, err = os.Open(filepath.Join(snapDir, "snapshot"))
+	assert.Equal(t, nil, err)
+	err = s0.Restore(snapFile)
+	assert.True(t, errors.Is(err, ErrClusterMismatch), "%v", err)
+	assert.Equal(t, id, s0.ClusterID())
 }
 
-func mustNewStoreAtPath(path string) *Store {
-	s := New(mustMockLister("localhost:0"), &StoreConfig{
-		Dir: path,
-		ID:  path, // Could be any unique string.
-	})
-	if s == nil {
-		panic("failed to create new store")
+func mustWaitClusterID(t *testing.T, s *Store) string {
+	for i := 0; i < 100; i++ {
+		if id := s.ClusterID(); id != "" {
+			return id
+		}
+		time.Sleep(100 * time.Millisecond)
 	}
-	return s
+	t.Fatalf("node %s did not learn its cluster ID", s.ID())
+	return ""
 }
 
-func mustNewStore() *Store {
-	return mustNewStoreAtPath(mustTempDir())
+func Test_SingleNodeMemoryStorage(t *testing.T) {
+	memory := NewMemoryStorage()
+	now := time.Now()
+	clock := now.UnixNano()
+	open := func(bootstrap bool) *Store {
+		_, tn := raft.NewInmemTransport("node0")
+		s := New(nil, &StoreConfig{ID: "node0", RaftTransport: tn, Memory: memory, Clock: func() time.Time { return time.Unix(0, atomic.LoadInt64(&clock)) }})
+		if err := s.Open(bootstrap); err != nil {
+			t.Fatalf("failed to open store in memory: %s", err.Error())
+		}
+		if _, err := s.WaitForLeader(10 * time.Second); err != nil {
+			t.Fatalf("no leader: %s", err.Error())
+		}
+		return s
+	}
+	s := open(true)
+	assert.Equal(t, "", s.Path())
+	err := s.CreateNamespace(context.TODO(), "default")
+	assert.Equal(t, nil, err)
+	err = s.SetModelFromString(context.TODO(), "default", modelText)
+	assert.Equal(t, nil, err)
+	_, err = s.AddExpiringPolicies(context.TODO(), "default", "p", "p", [][]string{{"alice", "data1", "read"}}, now.Add(time.Hour))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, nil, s.Close(true))
+
+	// the state is rebuilt from the log held in memory
+	s = open(false)
+	defer s.Close(true)
+	assert.Equal(t, nil, s.WaitForApplied(10*time.Second))
+	ok, err := s.Enforce(context.TODO(), "default", command.EnforcePayload_QUERY_REQUEST_LEVEL_NONE, 0, "alice", "data1", "read")
+	assert.Equal(t, nil, err)
+	assert.True(t, ok)
+
+	// rules expire against the clock of the store
+	atomic.AddInt64(&clock, int64(2*time.Hour))
+	ok, err = s.Enforce(context.TODO(), "default", command.EnforcePayload_QUERY_REQUEST_LEVEL_NONE, 0, "alice", "data1", "read")
+	assert.Equal(t, nil, err)
+	assert.False(t, ok)
 }
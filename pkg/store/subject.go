@@ -0,0 +1,410 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package store
+
+import (
+	"context"
+	"errors"
+	"sort"
+
+	"github.com/casbin/casbin/v2"
+	"github.com/casbin/casbin/v2/model"
+	"github.com/golang/protobuf/proto"
+	"github.com/hashicorp/raft"
+
+	"github.com/casbin/casbin-mesh/proto/command"
+)
+
+const (
+	// removeSubjectMeta marks the REMOVE_POLICIES entries removing the rules
+	// referencing a subject, instead of the rules of their payload.
+	removeSubjectMeta = "remove-subject"
+	// removeSubjectAllMeta removes the subject from every namespace.
+	removeSubjectAllMeta = "remove-subject-all"
+	// renameSubjectMeta and renameSubjectToMeta mark the UPDATE_POLICIES
+	// entries renaming a subject in the rules referencing it, instead of
+	// updating the rules of their payload.
+	renameSubjectMeta   = "rename-subject"
+	renameSubjectToMeta = "rename-subject-to"
+	// renameSubjectAllMeta renames the subject in every namespace.
+	renameSubjectAllMeta = "rename-subject-all"
+)
+
+var (
+	// ErrInvalidRename is returned when renaming a subject to itself or from
+	// or to an empty name.
+	ErrInvalidRename = errors.New("invalid rename, the names must be set and differ")
+)
+
+// RemovedRules are the rules of a policy type removed from a namespace.
+type RemovedRules struct {
+	Namespace string     `json:"ns"`
+	Sec       string     `json:"sec"`
+	PType     string     `json:"ptype"`
+	Rules     [][]string `json:"rules"`
+}
+
+// RemoveSubjectResponse is the response of a REMOVE_POLICIES command
+// removing a subject.
+type RemoveSubjectResponse struct {
+	removed []RemovedRules
+	error
+	replayMark
+}
+
+// RenamedRules are the rules of a policy type renamed in a namespace.
+// Renamed rules already present under the new name are merged into them.
+type RenamedRules struct {
+	Namespace string     `json:"ns"`
+	Sec       string     `json:"sec"`
+	PType     string     `json:"ptype"`
+	OldRules  [][]string `json:"oldRules"`
+	NewRules  [][]string `json:"newRules"`
+}
+
+// RenameSubjectResponse is the response of an UPDATE_POLICIES command
+// renaming a subject.
+type RenameSubjectResponse struct {
+	renamed []RenamedRules
+	error
+	replayMark
+}
+
+// RemoveSubject removes the rules of p whose subject is sub and the rules of
+// g whose member or role is sub, from ns or from every namespace if all. The
+// rules are removed by a single log entry, so all of them or none are.
+func (s *Store) RemoveSubject(ctx context.Context, ns, sub string, all bool) ([]RemovedRules, error) {
+	md := map[string]string{removeSubjectMeta: sub}
+	if all {
+		md[removeSubjectAllMeta] = "true"
+	}
+	cmd, err := proto.Marshal(&command.Command{
+		Type:      command.Type_COMMAND_TYPE_REMOVE_POLICIES,
+		Namespace: ns,
+		Metadata:  md,
+	})
+	if err != nil {
+		return nil, err
+	}
+	f, err := s.apply(ctx, cmd)
+	if err != nil {
+		return nil, err
+	}
+	switch r := f.Response().(type) {
+	case *RemoveSubjectResponse:
+		return r.removed, r.error
+	case *FSMResponse:
+		return nil, r.error
+	}
+	return nil, nil
+}
+
+// RenameSubject renames from to to in the rules of p whose subject is from
+// and in the rules of g whose member or role is from, in ns or in every
+// namespace if all. Renamed rules keep their position, so their priority,
+// and their expiry, schedule and annotation. The rules are renamed by a
+// single log entry, so all of them or none are.
+func (s *Store) RenameSubject(ctx context.Context, ns, from, to string, all bool) ([]RenamedRules, error) {
+	if from == "" || to == "" || from == to {
+		return nil, ErrInvalidRename
+	}
+	s.roleMu.Lock()
+	defer s.roleMu.Unlock()
+	if err := s.checkRenamedGrouping(ns, from, to, all); err != nil {
+		return nil, err
+	}
+	md := map[string]string{renameSubjectMeta: from, renameSubjectToMeta: to}
+	if all {
+		md[renameSubjectAllMeta] = "true"
+	}
+	cmd, err := proto.Marshal(&command.Command{
+		Type:      command.Type_COMMAND_TYPE_UPDATE_POLICIES,
+		Namespace: ns,
+		Metadata:  md,
+	})
+	if err != nil {
+		return nil, err
+	}
+	f, err := s.apply(ctx, cmd)
+	if err != nil {
+		return nil, err
+	}
+	switch r := f.Response().(type) {
+	case *RenameSubjectResponse:
+		return r.renamed, r.error
+	case *FSMResponse:
+		return nil, r.error
+	}
+	return nil, nil
+}
+
+// checkRenamedGrouping checks the grouping rules renaming from to to makes,
+// in place of the rules referencing from, like those of AddPolicies.
+func (s *Store) checkRenamedGrouping(ns, from, to string, all bool) error {
+	namespaces := []string{ns}
+	if all {
+		namespaces = nil
+		s.enforcers.Range(func(key, value interface{}) bool {
+			namespaces = append(namespaces, key.(string))
+			return true
+		})
+	}
+	for _, ns := range namespaces {
+		e, ok := s.enforcers.Load(ns)
+		if !ok {
+			continue
+		}
+		enforcer := e.(*casbin.DistributedEnforcer)
+		m := enforcer.GetModel()
+		if m == nil {
+			continue
+		}
+		fields := subjectFields("g", "", nil)
+		for _, pType := range policyTypes(m, "g") {
+			var oldRules, newRules [][]string
+			for _, rule := range enforcer.GetNamedGroupingPolicy(pType) {
+				if referencesSubject(fields, rule, from) {
+					oldRules, newRules = append(oldRules, rule), append(newRules, renameRule(fields, rule, from, to))
+				}
+			}
+			if err := s.checkGroupingRules(ns, "g", pType, newRules, oldRules); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// subjectFields returns the indexes of the fields of the rules of a policy
+// type holding subjects: the sub token, or the first field, in p, the member
+// and the role in g.
+func subjectFields(sec, pType string, tokens []string) []int {
+	if sec == "g" {
+		return []int{0, 1}
+	}
+	for i, token := range tokens {
+		if token == pType+"_sub" {
+			return []int{i}
+		}
+	}
+	return []int{0}
+}
+
+func referencesSubject(fields []int, rule []string, sub string) bool {
+	for _, i := range fields {
+		if i < len(rule) && rule[i] == sub {
+			return true
+		}
+	}
+	return false
+}
+
+// subjectNamespaces returns the namespaces a subject command applies to:
+// cmd.Namespace, or every namespace in order if all.
+func (s *Store) subjectNamespaces(cmd *command.Command, all bool) ([]string, error) {
+	if !all {
+		if _, ok := s.enforcers.Load(cmd.Namespace); !ok {
+			return nil, NamespaceNotExist
+		}
+		return []string{cmd.Namespace}, nil
+	}
+	var namespaces []string
+	s.enforcers.Range(func(key, value interface{}) bool {
+		namespaces = append(namespaces, key.(string))
+		return true
+	})
+	sort.Strings(namespaces)
+	return namespaces, nil
+}
+
+// subjectRules returns the rules of a policy type of ns referencing sub.
+// Inactive scheduled rules are included, as they would be added back later.
+func (s *Store) subjectRules(ns, sec, pType string, ast *model.Assertion, sub string) [][]string {
+	fields := subjectFields(sec, pType, ast.Tokens)
+	var rules [][]string
+	for _, rule := range append(append([][]string(nil), ast.Policy...), s.expiries.inactive(ns, sec, pType)...) {
+		if referencesSubject(fields, rule, sub) {
+			rules = append(rules, rule)
+		}
+	}
+	return rules
+}
+
+// policyTypes returns the policy types of section sec of m, in order.
+func policyTypes(m model.Model, sec string) []string {
+	pTypes := make([]string, 0, len(m[sec]))
+	for pType := range m[sec] {
+		pTypes = append(pTypes, pType)
+	}
+	sort.Strings(pTypes)
+	return pTypes
+}
+
+// applyRemoveSubject removes the rules referencing a subject. The versions
+// of cmd.Namespace are recorded by Apply, those of the other namespaces
+// here.
+func (s *Store) applyRemoveSubject(l *raft.Log, cmd *command.Command) interface{} {
+	sub := cmd.Metadata[removeSubjectMeta]
+	namespaces, err := s.subjectNamespaces(cmd, cmd.Metadata[removeSubjectAllMeta] != "")
+	if err != nil {
+		return &FSMResponse{error: err}
+	}
+
+	var removed []RemovedRules
+	for _, ns := range namespaces {
+		e, ok := s.enforcers.Load(ns)
+		if !ok {
+			continue
+		}
+		enforcer := e.(*casbin.DistributedEnforcer)
+		m := enforcer.GetModel()
+		if m == nil {
+			continue
+		}
+		var found []RemovedRules
+		for _, sec := range []string{"p", "g"} {
+			for _, pType := range policyTypes(m, sec) {
+				if rules := s.subjectRules(ns, sec, pType, m[sec][pType], sub); len(rules) > 0 {
+					found = append(found, RemovedRules{Namespace: ns, Sec: sec, PType: pType, Rules: rules})
+				}
+			}
+		}
+		for _, r := range found {
+			effected, err := enforcer.RemovePoliciesSelf(persist, r.Sec, r.PType, r.Rules)
+			if err != nil {
+				return &RemoveSubjectResponse{removed: removed, error: err}
+			}
+			s.expiries.drop(ns, r.Sec, r.PType, r.Rules)
+			s.annotations.drop(ns, r.Sec, r.PType, r.Rules)
+			if len(effected) > 0 {
+				s.watchers.publish(&command.WatchEvent{Index: l.Index, Namespace: ns, Type: cmd.Type,
+					Sec: r.Sec, PType: r.PType, Rules: command.NewStringArray(effected)})
+			}
+			removed = append(removed, r)
+		}
+		if len(found) > 0 && ns != cmd.Namespace {
+			s.recordVersion(l, ns)
+		}
+	}
+	return &RemoveSubjectResponse{removed: removed}
+}
+
+// renameRule returns rule with from renamed to to in fields.
+func renameRule(fields []int, rule []string, from, to string) []string {
+	renamed := append([]string(nil), rule...)
+	for _, i := range fields {
+		if i < len(renamed) && renamed[i] == from {
+			renamed[i] = to
+		}
+	}
+	return renamed
+}
+
+// applyRenameSubject renames a subject in the rules referencing it. Rules
+// are updated in place, unless the renamed rule is already present, in which
+// case the rule is removed. The versions of cmd.Namespace are recorded by
+// Apply, those of the other namespaces here.
+func (s *Store) applyRenameSubject(l *raft.Log, cmd *command.Command) interface{} {
+	from, to := cmd.Metadata[renameSubjectMeta], cmd.Metadata[renameSubjectToMeta]
+	namespaces, err := s.subjectNamespaces(cmd, cmd.Metadata[renameSubjectAllMeta] != "")
+	if err != nil {
+		return &FSMResponse{error: err}
+	}
+
+	var renamed []RenamedRules
+	for _, ns := range namespaces {
+		e, ok := s.enforcers.Load(ns)
+		if !ok {
+			continue
+		}
+		enforcer := e.(*casbin.DistributedEnforcer)
+		m := enforcer.GetModel()
+		if m == nil {
+			continue
+		}
+		changed := false
+		for _, sec := range []string{"p", "g"} {
+			for _, pType := range policyTypes(m, sec) {
+				ast := m[sec][pType]
+				rules := s.subjectRules(ns, sec, pType, ast, from)
+				if len(rules) == 0 {
+					continue
+				}
+				fields := subjectFields(sec, pType, ast.Tokens)
+				present := make(map[string]bool, len(ast.Policy))
+				for _, rule := range ast.Policy {
+					present[ruleKey(sec, pType, rule)] = true
+				}
+				inactive := make(map[string]bool)
+				for _, rule := range s.expiries.inactive(ns, sec, pType) {
+					inactive[ruleKey(sec, pType, rule)] = true
+				}
+
+				r := RenamedRules{Namespace: ns, Sec: sec, PType: pType}
+				var oldRules, newRules, merged, moved, movedTo [][]string
+				for _, rule := range rules {
+					newRule := renameRule(fields, rule, from, to)
+					r.OldRules, r.NewRules = append(r.OldRules, rule), append(r.NewRules, newRule)
+					key := ruleKey(sec, pType, newRule)
+					switch {
+					case inactive[ruleKey(sec, pType, rule)]:
+						moved, movedTo = append(moved, rule), append(movedTo, newRule)
+					case present[key]:
+						merged = append(merged, rule)
+					default:
+						oldRules, newRules = append(oldRules, rule), append(newRules, newRule)
+						present[key] = true
+					}
+				}
+				if len(oldRules) > 0 {
+					ok, err := enforcer.UpdatePoliciesSelf(persist, sec, pType, oldRules, newRules)
+					if err != nil {
+						return &RenameSubjectResponse{renamed: renamed, error: err}
+					}
+					if ok {
+						s.expiries.move(ns, sec, pType, oldRules, newRules)
+						s.annotations.move(ns, sec, pType, oldRules, newRules)
+						s.watchers.publish(&command.WatchEvent{Index: l.Index, Namespace: ns, Type: cmd.Type,
+							Sec: sec, PType: pType, Rules: command.NewStringArray(newRules), OldRules: command.NewStringArray(oldRules)})
+					}
+				}
+				if len(merged) > 0 {
+					effected, err := enforcer.RemovePoliciesSelf(persist, sec, pType, merged)
+					if err != nil {
+						return &RenameSubjectResponse{renamed: renamed, error: err}
+					}
+					s.expiries.drop(ns, sec, pType, merged)
+					s.annotations.drop(ns, sec, pType, merged)
+					if len(effected) > 0 {
+						s.watchers.publish(&command.WatchEvent{Index: l.Index, Namespace: ns, Type: command.Type_COMMAND_TYPE_REMOVE_POLICIES,
+							Sec: sec, PType: pType, Rules: command.NewStringArray(effected)})
+					}
+				}
+				// inactive scheduled rules are only in the registries
+				s.expiries.move(ns, sec, pType, moved, movedTo)
+				s.annotations.move(ns, sec, pType, moved, movedTo)
+				renamed = append(renamed, r)
+				changed = true
+			}
+		}
+		if changed && ns != cmd.Namespace {
+			s.recordVersion(l, ns)
+		}
+	}
+	return &RenameSubjectResponse{renamed: renamed}
+}
@@ -0,0 +1,260 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package store
+
+import (
+	"context"
+	"errors"
+	"sort"
+
+	"github.com/casbin/casbin-mesh/proto/command"
+	"github.com/golang/protobuf/proto"
+)
+
+var (
+	// TemplateNotExist template not exist
+	TemplateNotExist = errors.New("template not exist")
+	// TemplateInUse template still has instances
+	TemplateInUse = errors.New("template has instances")
+	// TemplateInstanceNotExist template instance not exist
+	TemplateInstanceNotExist = errors.New("template instance not exist")
+)
+
+// templateRegistry holds the policy templates and the provenance of their
+// instances. It is only accessed by the FSM.
+type templateRegistry struct {
+	Templates map[string]*command.Template `json:"templates"`
+	// Instances are keyed by namespace and instance name.
+	Instances map[string]map[string]*command.TemplateInstance `json:"instances"`
+}
+
+func newTemplateRegistry() *templateRegistry {
+	return &templateRegistry{
+		Templates: make(map[string]*command.Template),
+		Instances: make(map[string]map[string]*command.TemplateInstance),
+	}
+}
+
+func (r *templateRegistry) hasInstances(template string) bool {
+	for _, instances := range r.Instances {
+		for _, inst := range instances {
+			if inst.Template == template {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (r *templateRegistry) list() ([]*command.Template, []*command.TemplateInstance) {
+	var templates []*command.Template
+	for _, t := range r.Templates {
+		templates = append(templates, proto.Clone(t).(*command.Template))
+	}
+	sort.Slice(templates, func(i, j int) bool { return templates[i].Name < templates[j].Name })
+	var instances []*command.TemplateInstance
+	for _, byName := range r.Instances {
+		for _, inst := range byName {
+			instances = append(instances, proto.Clone(inst).(*command.TemplateInstance))
+		}
+	}
+	sort.Slice(instances, func(i, j int) bool {
+		if instances[i].Namespace != instances[j].Namespace {
+			return instances[i].Namespace < instances[j].Namespace
+		}
+		return instances[i].Name < instances[j].Name
+	})
+	return templates, instances
+}
+
+type SetTemplateResponse struct {
+	version uint64
+	error
+	replayMark
+}
+
+type ListTemplatesResponse struct {
+	templates []*command.Template
+	instances []*command.TemplateInstance
+	error
+}
+
+func (s *Store) applyTemplateCommand(cmd *command.Command) interface{} {
+	switch cmd.Type {
+	case command.Type_COMMAND_TYPE_SET_TEMPLATE:
+		var t command.Template
+		if err := proto.Unmarshal(cmd.Payload, &t); err != nil {
+			return &SetTemplateResponse{error: UnmarshalFailed}
+		}
+		t.Version = 1
+		if old, ok := s.templates.Templates[t.Name]; ok {
+			t.Version = old.Version + 1
+		}
+		s.templates.Templates[t.Name] = &t
+		return &SetTemplateResponse{version: t.Version}
+	case command.Type_COMMAND_TYPE_DELETE_TEMPLATE:
+		var t command.Template
+		if err := proto.Unmarshal(cmd.Payload, &t); err != nil {
+			return &FSMResponse{error: UnmarshalFailed}
+		}
+		if _, ok := s.templates.Templates[t.Name]; !ok {
+			return &FSMResponse{error: TemplateNotExist}
+		}
+		if s.templates.hasInstances(t.Name) {
+			return &FSMResponse{error: TemplateInUse}
+		}
+		delete(s.templates.Templates, t.Name)
+		return &FSMResponse{}
+	case command.Type_COMMAND_TYPE_LIST_TEMPLATES:
+		templates, instances := s.templates.list()
+		return &ListTemplatesResponse{templates: templates, instances: instances}
+	case command.Type_COMMAND_TYPE_SET_TEMPLATE_INSTANCE:
+		var inst command.TemplateInstance
+		if err := proto.Unmarshal(cmd.Payload, &inst); err != nil {
+			return &FSMResponse{error: UnmarshalFailed}
+		}
+		if _, ok := s.enforcers.Load(cmd.Namespace); !ok {
+			return &FSMResponse{error: NamespaceNotExist}
+		}
+		if _, ok := s.templates.Templates[inst.Template]; !ok {
+			return &FSMResponse{error: TemplateNotExist}
+		}
+		inst.Namespace = cmd.Namespace
+		if _, ok := s.templates.Instances[cmd.Namespace]; !ok {
+			s.templates.Instances[cmd.Namespace] = make(map[string]*command.TemplateInstance)
+		}
+		s.templates.Instances[cmd.Namespace][inst.Name] = &inst
+		return &FSMResponse{}
+	case command.Type_COMMAND_TYPE_DELETE_TEMPLATE_INSTANCE:
+		var inst command.TemplateInstance
+		if err := proto.Unmarshal(cmd.Payload, &inst); err != nil {
+			return &FSMResponse{error: UnmarshalFailed}
+		}
+		if _, ok := s.templates.Instances[cmd.Namespace][inst.Name]; !ok {
+			return &FSMResponse{error: TemplateInstanceNotExist}
+		}
+		delete(s.templates.Instances[cmd.Namespace], inst.Name)
+		if len(s.templates.Instances[cmd.Namespace]) == 0 {
+			delete(s.templates.Instances, cmd.Namespace)
+		}
+		return &FSMResponse{}
+	}
+	return nil
+}
+
+// SetTemplate registers a policy template, or replaces the template of the
+// same name, and returns its new version.
+func (s *Store) SetTemplate(ctx context.Context, t *command.Template) (uint64, error) {
+	payload, err := proto.Marshal(t)
+	if err != nil {
+		return 0, err
+	}
+	cmd, err := proto.Marshal(&command.Command{
+		Type:    command.Type_COMMAND_TYPE_SET_TEMPLATE,
+		Payload: payload,
+	})
+	if err != nil {
+		return 0, err
+	}
+	f, err := s.apply(ctx, cmd)
+	if err != nil {
+		return 0, err
+	}
+	r := f.Response().(*SetTemplateResponse)
+	return r.version, r.error
+}
+
+// DeleteTemplate removes a policy template, it must not have instances.
+func (s *Store) DeleteTemplate(ctx context.Context, name string) error {
+	payload, err := proto.Marshal(&command.Template{Name: name})
+	if err != nil {
+		return err
+	}
+	cmd, err := proto.Marshal(&command.Command{
+		Type:    command.Type_COMMAND_TYPE_DELETE_TEMPLATE,
+		Payload: payload,
+	})
+	if err != nil {
+		return err
+	}
+	f, err := s.apply(ctx, cmd)
+	if err != nil {
+		return err
+	}
+	r := f.Response().(*FSMResponse)
+	return r.error
+}
+
+// ListTemplates returns the policy templates and their instances.
+func (s *Store) ListTemplates(ctx context.Context) ([]*command.Template, []*command.TemplateInstance, error) {
+	cmd, err := proto.Marshal(&command.Command{Type: command.Type_COMMAND_TYPE_LIST_TEMPLATES})
+	if err != nil {
+		return nil, nil, err
+	}
+	f, err := s.apply(ctx, cmd)
+	if err != nil {
+		return nil, nil, err
+	}
+	r := f.Response().(*ListTemplatesResponse)
+	return r.templates, r.instances, r.error
+}
+
+// SetTemplateInstance records which template, version and variables the
+// policies of an instance were rendered from. It doesn't change the policies.
+func (s *Store) SetTemplateInstance(ctx context.Context, inst *command.TemplateInstance) error {
+	payload, err := proto.Marshal(inst)
+	if err != nil {
+		return err
+	}
+	cmd, err := proto.Marshal(&command.Command{
+		Type:      command.Type_COMMAND_TYPE_SET_TEMPLATE_INSTANCE,
+		Namespace: inst.Namespace,
+		Payload:   payload,
+	})
+	if err != nil {
+		return err
+	}
+	f, err := s.apply(ctx, cmd)
+	if err != nil {
+		return err
+	}
+	r := f.Response().(*FSMResponse)
+	return r.error
+}
+
+// DeleteTemplateInstance forgets an instance. It doesn't change the policies.
+func (s *Store) DeleteTemplateInstance(ctx context.Context, ns, name string) error {
+	payload, err := proto.Marshal(&command.TemplateInstance{Name: name})
+	if err != nil {
+		return err
+	}
+	cmd, err := proto.Marshal(&command.Command{
+		Type:      command.Type_COMMAND_TYPE_DELETE_TEMPLATE_INSTANCE,
+		Namespace: ns,
+		Payload:   payload,
+	})
+	if err != nil {
+		return err
+	}
+	f, err := s.apply(ctx, cmd)
+	if err != nil {
+		return err
+	}
+	r := f.Response().(*FSMResponse)
+	return r.error
+}
@@ -0,0 +1,44 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package store
+
+import (
+	"context"
+	"time"
+)
+
+type applyTimeoutKey struct{}
+
+// WithApplyTimeout returns a copy of ctx overriding the ApplyTimeout of the
+// store for the commands applied on behalf of the request.
+func WithApplyTimeout(ctx context.Context, d time.Duration) context.Context {
+	return context.WithValue(ctx, applyTimeoutKey{}, d)
+}
+
+// applyTimeout returns the timeout of the commands applied with ctx, bounded
+// by its deadline.
+func (s *Store) applyTimeout(ctx context.Context) time.Duration {
+	timeout := s.ApplyTimeout
+	if d, ok := ctx.Value(applyTimeoutKey{}).(time.Duration); ok && d > 0 {
+		timeout = d
+	}
+	if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < timeout {
+		timeout = time.Until(deadline)
+	}
+	return timeout
+}
@@ -18,7 +18,9 @@
 package store
 
 import (
+	"io"
 	"net"
+	"sync"
 	"time"
 
 	"github.com/hashicorp/raft"
@@ -61,3 +63,162 @@ func (t *Transport) Close() error {
 func (t *Transport) Addr() net.Addr {
 	return t.ln.Addr()
 }
+
+// replicationTransport is the Raft transport of the store. It records the
+// progress of followers, and streams snapshots to them at no more than
+// bandwidth bytes per second, if set, so that installing them doesn't starve
+// the other traffic of the link.
+type replicationTransport struct {
+	raft.Transport
+	progress  *replicationTracker
+	bandwidth int64
+}
+
+// newReplicationTransport returns t recording the progress of followers in
+// progress and throttling snapshots to bandwidth bytes per second, 0 for no
+// limit. The deadline of snapshot transfers is scaled to the bandwidth.
+func newReplicationTransport(t raft.Transport, progress *replicationTracker, bandwidth int64, timeout time.Duration) *replicationTransport {
+	// InstallSnapshot allows timeout per TimeoutScale bytes, keep twice the
+	// time transferring them takes.
+	if nt, ok := t.(*raft.NetworkTransport); ok {
+		if scale := int(float64(bandwidth) * timeout.Seconds() / 2); bandwidth > 0 && scale < nt.TimeoutScale {
+			if scale < 1 {
+				scale = 1
+			}
+			nt.TimeoutScale = scale
+		}
+	}
+	return &replicationTransport{Transport: t, progress: progress, bandwidth: bandwidth}
+}
+
+// Close closes the underlying transport.
+func (t *replicationTransport) Close() error {
+	if c, ok := t.Transport.(raft.WithClose); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+// AppendEntries sends entries to a follower.
+func (t *replicationTransport) AppendEntries(id raft.ServerID, target raft.ServerAddress, args *raft.AppendEntriesRequest,
+	resp *raft.AppendEntriesResponse) error {
+	err := t.Transport.AppendEntries(id, target, args, resp)
+	if err == nil && resp.Success {
+		t.progress.replicated(id, args, time.Now())
+	}
+	return err
+}
+
+// AppendEntriesPipeline returns a pipeline sending entries to a follower.
+func (t *replicationTransport) AppendEntriesPipeline(id raft.ServerID, target raft.ServerAddress) (raft.AppendPipeline, error) {
+	p, err := t.Transport.AppendEntriesPipeline(id, target)
+	if err != nil {
+		return nil, err
+	}
+	return newTrackedPipeline(p, id, t.progress), nil
+}
+
+// InstallSnapshot streams a snapshot to a follower.
+func (t *replicationTransport) InstallSnapshot(id raft.ServerID, target raft.ServerAddress, args *raft.InstallSnapshotRequest,
+	resp *raft.InstallSnapshotResponse, data io.Reader) error {
+	if t.bandwidth > 0 {
+		data = newThrottledReader(data, t.bandwidth)
+	}
+	t.progress.snapshotStarted(id, args.Size, time.Now())
+	err := t.Transport.InstallSnapshot(id, target, args, resp, &progressReader{r: data, id: id, progress: t.progress})
+	t.progress.snapshotDone(id, args.LastLogIndex, err == nil && resp.Success, time.Now())
+	return err
+}
+
+// trackedPipeline records the entries a pipeline replicated to a follower.
+type trackedPipeline struct {
+	raft.AppendPipeline
+	id       raft.ServerID
+	progress *replicationTracker
+	consumer chan raft.AppendFuture
+	once     sync.Once
+	done     chan struct{}
+}
+
+func newTrackedPipeline(p raft.AppendPipeline, id raft.ServerID, progress *replicationTracker) *trackedPipeline {
+	tp := &trackedPipeline{
+		AppendPipeline: p,
+		id:             id,
+		progress:       progress,
+		consumer:       make(chan raft.AppendFuture, 128),
+		done:           make(chan struct{}),
+	}
+	go tp.forward()
+	return tp
+}
+
+// forward records the futures completed by the pipeline, and hands them
+// over to Raft.
+func (p *trackedPipeline) forward() {
+	in := p.AppendPipeline.Consumer()
+	for {
+		select {
+		case f := <-in:
+			if f.Error() == nil && f.Response().Success {
+				p.progress.replicated(p.id, f.Request(), time.Now())
+			}
+			select {
+			case p.consumer <- f:
+			case <-p.done:
+				return
+			}
+		case <-p.done:
+			return
+		}
+	}
+}
+
+// Consumer returns the futures completed by the pipeline.
+func (p *trackedPipeline) Consumer() <-chan raft.AppendFuture {
+	return p.consumer
+}
+
+// Close closes the pipeline.
+func (p *trackedPipeline) Close() error {
+	p.once.Do(func() { close(p.done) })
+	return p.AppendPipeline.Close()
+}
+
+// progressReader records the bytes of a snapshot sent to a follower.
+type progressReader struct {
+	r        io.Reader
+	id       raft.ServerID
+	progress *replicationTracker
+}
+
+func (r *progressReader) Read(b []byte) (int, error) {
+	n, err := r.r.Read(b)
+	r.progress.snapshotSent(r.id, int64(n))
+	return n, err
+}
+
+// throttledReader reads at no more than bandwidth bytes per second.
+type throttledReader struct {
+	r         io.Reader
+	bandwidth int64
+	start     time.Time
+	n         int64
+}
+
+func newThrottledReader(r io.Reader, bandwidth int64) *throttledReader {
+	return &throttledReader{r: r, bandwidth: bandwidth, start: time.Now()}
+}
+
+func (t *throttledReader) Read(b []byte) (int, error) {
+	// Read at most a second worth of data at once to keep the rate smooth.
+	if int64(len(b)) > t.bandwidth {
+		b = b[:t.bandwidth]
+	}
+	n, err := t.r.Read(b)
+	t.n += int64(n)
+	due := time.Duration(float64(t.n) / float64(t.bandwidth) * float64(time.Second))
+	if wait := due - time.Since(t.start); wait > 0 {
+		time.Sleep(wait)
+	}
+	return n, err
+}
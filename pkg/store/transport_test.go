@@ -0,0 +1,40 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package store
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+	"time"
+)
+
+func Test_ThrottledReader(t *testing.T) {
+	data := make([]byte, 3000)
+	start := time.Now()
+	b, err := ioutil.ReadAll(newThrottledReader(bytes.NewReader(data), 10000))
+	if err != nil {
+		t.Fatalf("failed to read: %s", err.Error())
+	}
+	if len(b) != len(data) {
+		t.Fatalf("read %d bytes, exp %d", len(b), len(data))
+	}
+	if d := time.Since(start); d < 250*time.Millisecond {
+		t.Fatalf("read 3000 bytes at 10000 B/s in %s", d)
+	}
+}
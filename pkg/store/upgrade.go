@@ -0,0 +1,263 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package store
+
+import (
+	"fmt"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/casbin/casbin-mesh/proto/command"
+	"github.com/golang/protobuf/proto"
+	"github.com/hashicorp/raft"
+)
+
+const (
+	// VersionKey is the node metadata key holding the version of the
+	// binary the node runs.
+	VersionKey = "version"
+	// FSMVersionKey is the node metadata key holding the FSM schema version
+	// the node supports.
+	FSMVersionKey = "fsm_version"
+	// RaftFramingKey is the node metadata key set by the nodes framing their
+	// Raft connections.
+	RaftFramingKey = "raft_framing"
+
+	// framingInterval is how often the members framing their Raft
+	// connections are checked.
+	framingInterval = 5 * time.Second
+
+	// FSMVersion is the schema version of the log entries this binary
+	// applies. Bump it along with commandVersions when adding a command type,
+	// or along with metadataVersions when adding a variant of one.
+	FSMVersion = 5
+
+	// schemaVersionMeta is the command metadata key holding the schema
+	// version of the command, missing for the first version.
+	schemaVersionMeta = "schema-version"
+	// schemaIgnorableMeta marks the commands nodes with an older schema may
+	// skip.
+	schemaIgnorableMeta = "schema-ignorable"
+)
+
+// Version is the version of the binary, set at build time with
+// -ldflags "-X github.com/casbin/casbin-mesh/pkg/store.Version=...".
+var Version = "dev"
+
+// commandVersions maps the command types to the FSM version their encoding
+// was introduced or last changed in, types left out date back to version 1.
+// Adding a field to the payload of a type only needs a new version if older
+// nodes would apply the command wrongly by ignoring it.
+var commandVersions = map[command.Type]int{
+	command.Type_COMMAND_TYPE_SET_TEMPLATE:             2,
+	command.Type_COMMAND_TYPE_DELETE_TEMPLATE:          2,
+	command.Type_COMMAND_TYPE_LIST_TEMPLATES:           2,
+	command.Type_COMMAND_TYPE_SET_TEMPLATE_INSTANCE:    2,
+	command.Type_COMMAND_TYPE_DELETE_TEMPLATE_INSTANCE: 2,
+	command.Type_COMMAND_TYPE_EXPIRE_POLICIES:          2,
+	command.Type_COMMAND_TYPE_SCHEDULE_POLICIES:        2,
+	command.Type_COMMAND_TYPE_ANNOTATE_POLICIES:        2,
+	command.Type_COMMAND_TYPE_LIST_ANNOTATIONS:         2,
+	command.Type_COMMAND_TYPE_PAGE_POLICIES:            2,
+	command.Type_COMMAND_TYPE_TAG_VERSION:              2,
+	command.Type_COMMAND_TYPE_ROLLBACK_POLICIES:        2,
+	command.Type_COMMAND_TYPE_LIST_VERSIONS:            2,
+	command.Type_COMMAND_TYPE_SET_READ_ONLY:            2,
+}
+
+// metadataVersions maps the metadata keys selecting a variant of a command
+// type to the FSM version the variant was introduced in. Older nodes ignore
+// the metadata and would apply the variants as the plain type.
+var metadataVersions = map[string]int{
+	removeSubjectMeta:     3,
+	renameSubjectMeta:     3,
+	copyFromMeta:          3,
+	namespaceLabelsMeta:   3,
+	replacePoliciesMeta:   3,
+	namespaceSettingsMeta: 4,
+	standbyIndexMeta:      4,
+	standbySeedMeta:       4,
+	digestMeta:            4,
+	clusterIDMeta:         5,
+}
+
+// commandVersion returns the FSM version needed to apply cmd.
+func commandVersion(cmd *command.Command) int {
+	v, ok := commandVersions[cmd.Type]
+	if !ok {
+		v = 1
+	}
+	for k, mv := range metadataVersions {
+		if cmd.Metadata[k] != "" && mv > v {
+			v = mv
+		}
+	}
+	return v
+}
+
+// MetadataFSMVersion returns the FSM version recorded in the metadata md of a
+// node. Nodes which did not record one predate versioning and support
+// version 1.
+func MetadataFSMVersion(md map[string]string) int {
+	v, err := strconv.Atoi(md[FSMVersionKey])
+	if err != nil || v < 1 {
+		return 1
+	}
+	return v
+}
+
+// ClusterFSMVersion returns the lowest FSM version supported by the members
+// of the cluster, which is the newest schema all of them can apply. The node
+// itself supports FSMVersion whatever its metadata says.
+func (s *Store) ClusterFSMVersion() (int, error) {
+	f := s.raft.GetConfiguration()
+	if err := f.Error(); err != nil {
+		return 0, err
+	}
+	return s.clusterFSMVersion(f.Configuration().Servers), nil
+}
+
+func (s *Store) clusterFSMVersion(servers []raft.Server) int {
+	s.metaMu.RLock()
+	defer s.metaMu.RUnlock()
+	min := FSMVersion
+	for _, srv := range servers {
+		if string(srv.ID) == s.raftID {
+			continue
+		}
+		if v := MetadataFSMVersion(s.meta[string(srv.ID)]); v < min {
+			min = v
+		}
+	}
+	return min
+}
+
+// ClusterFramesRaft reports whether every member of the cluster frames its
+// Raft connections, as told by RaftFramingKey in their metadata. It is false
+// until the members are first checked, shortly after the store opens.
+func (s *Store) ClusterFramesRaft() bool {
+	return atomic.LoadInt32(&s.framed) == 1
+}
+
+func (s *Store) clusterFramesRaft(servers []raft.Server) bool {
+	s.metaMu.RLock()
+	defer s.metaMu.RUnlock()
+	for _, srv := range servers {
+		if string(srv.ID) != s.raftID && s.meta[string(srv.ID)][RaftFramingKey] == "" {
+			return false
+		}
+	}
+	return len(servers) > 0
+}
+
+// startFramingWatch checks the members framing their Raft connections,
+// periodically as nodes join and get upgraded.
+func (s *Store) startFramingWatch() {
+	s.framingDone = make(chan struct{})
+	go func(done chan struct{}) {
+		t := time.NewTicker(framingInterval)
+		defer t.Stop()
+		for {
+			framed := int32(0)
+			if f := s.raft.GetConfiguration(); f.Error() == nil && s.clusterFramesRaft(f.Configuration().Servers) {
+				framed = 1
+			}
+			atomic.StoreInt32(&s.framed, framed)
+			select {
+			case <-t.C:
+			case <-done:
+				return
+			}
+		}
+	}(s.framingDone)
+}
+
+func (s *Store) stopFramingWatch() {
+	if s.framingDone != nil {
+		close(s.framingDone)
+		s.framingDone = nil
+	}
+}
+
+// versionCommand stamps cmd with the schema version its type or variant was
+// introduced in, and returns an error wrapping ErrUnsupportedByCluster if some member of
+// the cluster cannot apply it yet. Commands of the first version are left as
+// they are, so that nodes predating versioning decode them the same.
+func (s *Store) versionCommand(cmd []byte) ([]byte, error) {
+	var c command.Command
+	if err := proto.Unmarshal(cmd, &c); err != nil {
+		return nil, err
+	}
+	v := commandVersion(&c)
+	if v == 1 {
+		return cmd, nil
+	}
+	min, err := s.ClusterFSMVersion()
+	if err != nil {
+		return nil, err
+	}
+	if v > min {
+		return nil, fmt.Errorf("%w, %s needs FSM version %d and some members only support %d", ErrUnsupportedByCluster, c.Type, v, min)
+	}
+	if c.Metadata == nil {
+		c.Metadata = make(map[string]string)
+	}
+	c.Metadata[schemaVersionMeta] = strconv.Itoa(v)
+	// Variants of queries, like the no-op entries digesting the state,
+	// have an effect older nodes must not skip.
+	if queries(c.Type) && v == commandVersions[c.Type] {
+		c.Metadata[schemaIgnorableMeta] = "true"
+	}
+	return proto.Marshal(&c)
+}
+
+// checkSchema decides how an entry is applied depending on the schema version
+// it was written with. Entries of a known version are decoded as usual,
+// protobuf skipping the fields added since. Entries of a newer version are
+// refused if they only read state, and stop the node otherwise: applying them
+// partially would make its state diverge from the rest of the cluster, so the
+// node has to be upgraded again instead.
+func (s *Store) checkSchema(l *raft.Log, cmd *command.Command) error {
+	v, err := strconv.Atoi(cmd.Metadata[schemaVersionMeta])
+	if err != nil || v <= FSMVersion {
+		return nil
+	}
+	if cmd.Metadata[schemaIgnorableMeta] == "true" {
+		return fmt.Errorf("%w, entry %d needs FSM version %d", ErrUnsupportedCommand, l.Index, v)
+	}
+	panic(fmt.Sprintf("log entry %d needs FSM version %d and this node supports %d, upgrade it", l.Index, v, FSMVersion))
+}
+
+// queries reports whether commands of type t leave the state as it is, so
+// that nodes which don't know them may skip them.
+func queries(t command.Type) bool {
+	switch t {
+	case command.Type_COMMAND_TYPE_NOOP,
+		command.Type_COMMAND_TYPE_ENFORCE_REQUEST,
+		command.Type_COMMAND_TYPE_LIST_NAMESPACES,
+		command.Type_COMMAND_TYPE_PRINT_MODEL,
+		command.Type_COMMAND_TYPE_LIST_POLICIES,
+		command.Type_COMMAND_TYPE_LIST_TEMPLATES,
+		command.Type_COMMAND_TYPE_LIST_ANNOTATIONS,
+		command.Type_COMMAND_TYPE_PAGE_POLICIES,
+		command.Type_COMMAND_TYPE_LIST_VERSIONS:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,166 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package store
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"time"
+
+	"github.com/casbin/casbin-mesh/pkg/adapter"
+	"github.com/casbin/casbin/v2"
+	"github.com/dgraph-io/badger/v3"
+)
+
+// ErrNoSnapshot is returned when verifying the latest snapshot of a node
+// which has none.
+var ErrNoSnapshot = errors.New("no snapshot")
+
+// SnapshotVerification is the result of restoring a snapshot into a scratch
+// FSM.
+type SnapshotVerification struct {
+	// ID, Index and Term identify the snapshots of the node.
+	ID    string `json:"id,omitempty"`
+	Index uint64 `json:"index,omitempty"`
+	Term  uint64 `json:"term,omitempty"`
+	Size  int64  `json:"size"`
+	// Namespaces and Policies count what the snapshot restored.
+	Namespaces int `json:"namespaces"`
+	Policies   int `json:"policies"`
+	// Digest hashes the restored state, Recorded is the digest recorded
+	// when the snapshot was taken, empty for snapshots taken before
+	// digests were recorded.
+	Digest   string `json:"digest"`
+	Recorded string `json:"recorded,omitempty"`
+	// OK is set if the snapshot restored, to its recorded digest if any.
+	OK       bool   `json:"ok"`
+	Error    string `json:"error,omitempty"`
+	Duration string `json:"duration"`
+}
+
+// VerifyLatestSnapshot verifies the latest snapshot of this node, its
+// checksum included.
+func (s *Store) VerifyLatestSnapshot() (*SnapshotVerification, error) {
+	metas, err := s.snapshots.List()
+	if err != nil {
+		return nil, err
+	}
+	if len(metas) == 0 {
+		return nil, ErrNoSnapshot
+	}
+	meta, rc, err := s.snapshots.Open(metas[0].ID)
+	if err != nil {
+		return &SnapshotVerification{ID: metas[0].ID, Index: metas[0].Index, Term: metas[0].Term, Error: err.Error()}, nil
+	}
+	defer rc.Close()
+	v := VerifySnapshot(rc)
+	v.ID, v.Index, v.Term = meta.ID, meta.Index, meta.Term
+	return v, nil
+}
+
+// VerifySnapshot restores the snapshot read from r, as persisted by the
+// FSM, into a scratch FSM in memory, and checks it restores to the digest
+// recorded when it was taken.
+func VerifySnapshot(r io.Reader) *SnapshotVerification {
+	start := time.Now()
+	v := &SnapshotVerification{}
+	err := func() error {
+		b, err := ioutil.ReadAll(r)
+		if err != nil {
+			return err
+		}
+		v.Size = int64(len(b))
+		if len(b) < len(SnapshotHdr()) || !bytes.Equal(b[:2], SnapshotHdr()[:2]) {
+			return errors.New("not a snapshot")
+		}
+		var data persistData
+		if err := json.Unmarshal(b[len(SnapshotHdr()):], &data); err != nil {
+			return err
+		}
+		scratch, err := newScratchStore()
+		if err != nil {
+			return err
+		}
+		defer scratch.enforcersState.Close()
+		if err := scratch.Restore(ioutil.NopCloser(bytes.NewReader(b))); err != nil {
+			return fmt.Errorf("failed to restore: %s", err.Error())
+		}
+		d := scratch.digestState(0)
+		v.Digest, v.Namespaces = d.Digest, len(d.Namespaces)
+		scratch.enforcers.Range(func(key, value interface{}) bool {
+			if e, ok := value.(*casbin.DistributedEnforcer); ok && e.GetModel() != nil {
+				for _, sec := range []string{"p", "g"} {
+					for _, ast := range e.GetModel()[sec] {
+						v.Policies += len(ast.Policy)
+					}
+				}
+			}
+			return true
+		})
+		if data.Digest == nil {
+			return nil
+		}
+		var recorded StateDigest
+		if err := json.Unmarshal(data.Digest, &recorded); err != nil {
+			return err
+		}
+		v.Recorded = recorded.Digest
+		if v.Digest != v.Recorded {
+			return errors.New("restored state does not match the recorded digest")
+		}
+		return nil
+	}()
+	if err != nil {
+		v.Error = err.Error()
+	}
+	v.OK = err == nil
+	v.Duration = time.Since(start).String()
+	return v
+}
+
+// newScratchStore returns a Store without Raft, its state in memory, to
+// restore snapshots into.
+func newScratchStore() (*Store, error) {
+	opts := badger.DefaultOptions("").WithInMemory(true).WithLogger(nil)
+	state, err := adapter.New(adapter.Options{BadgerOptions: &opts, NoSync: true})
+	if err != nil {
+		return nil, err
+	}
+	return &Store{
+		meta:           make(map[string]map[string]string),
+		enforcersState: state,
+		templates:      newTemplateRegistry(),
+		expiries:       newExpiryRegistry(),
+		annotations:    newAnnotationRegistry(),
+		labels:         newLabelRegistry(),
+		settings:       newSettingsRegistry(),
+		policySets:     newPolicySetRegistry(),
+		idempotency:    newIdempotencyRegistry(),
+		versions:       newVersionRegistry(),
+		readOnly:       newReadOnlyMode(),
+		standby:        newStandbyRegistry(),
+		watchers:       newWatchHub(),
+		cluster:        newClusterIdentity(),
+		logger:         log.New(ioutil.Discard, "", 0),
+	}, nil
+}
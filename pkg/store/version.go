@@ -0,0 +1,511 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/casbin/casbin-mesh/proto/command"
+	"github.com/casbin/casbin/v2"
+	"github.com/golang/protobuf/proto"
+	"github.com/hashicorp/raft"
+)
+
+// ErrVersionNotExist is returned when the requested version of the rules of a
+// namespace is not retained.
+var ErrVersionNotExist = errors.New("version does not exist")
+
+// versionHistorySize bounds the number of versions retained per namespace.
+// Untagged versions are evicted first.
+const versionHistorySize = 20
+
+// versionRegistry retains the recent versions of the rules of each namespace.
+// It is only accessed by the FSM. The latest version of a namespace holds
+// its rules, the older ones only the difference with the version after them,
+// so that a change of a few rules does not retain another copy of them all.
+type versionRegistry struct {
+	// versions are keyed by namespace, oldest first.
+	versions map[string][]*storedVersion
+}
+
+// storedVersion is a retained version of the rules of a namespace. Policies
+// are set on the latest version, the older ones have the rules the version
+// after them added and removed instead.
+type storedVersion struct {
+	version  uint64
+	time     int64
+	tag      string
+	policies []*command.PolicyRules
+	added    []*command.PolicyRules
+	removed  []*command.PolicyRules
+}
+
+func newVersionRegistry() *versionRegistry {
+	return &versionRegistry{versions: make(map[string][]*storedVersion)}
+}
+
+// record adds a version unless the rules are those of the latest one. It
+// returns the latest version.
+func (r *versionRegistry) record(ns string, index uint64, t int64, policies []*command.PolicyRules) *command.PolicyVersion {
+	versions := r.versions[ns]
+	n := len(versions)
+	if n > 0 && samePolicies(versions[n-1].policies, policies) {
+		return versions[n-1].materialize(versions[n-1].policies)
+	}
+	if n > 0 {
+		last := versions[n-1]
+		last.added, last.removed = DiffPolicies(last.policies, policies)
+		last.policies = nil
+	}
+	v := &storedVersion{version: index, time: t, policies: policies}
+	versions = append(versions, v)
+	r.versions[ns] = versions
+	if len(versions) > versionHistorySize {
+		evict := 0
+		for i, old := range versions {
+			if old.tag == "" {
+				evict = i
+				break
+			}
+		}
+		r.evict(ns, evict)
+	}
+	return v.materialize(policies)
+}
+
+// evict removes the i-th version of ns, the version before it then holds the
+// difference with the version after it.
+func (r *versionRegistry) evict(ns string, i int) {
+	versions := r.versions[ns]
+	if i > 0 {
+		prev := r.policies(ns, i-1)
+		if i == len(versions)-1 {
+			versions[i-1].policies, versions[i-1].added, versions[i-1].removed = prev, nil, nil
+		} else {
+			versions[i-1].added, versions[i-1].removed = DiffPolicies(prev, r.policies(ns, i+1))
+		}
+	}
+	r.versions[ns] = append(versions[:i], versions[i+1:]...)
+}
+
+// policies returns the rules of the i-th version of ns, undoing the changes
+// of the versions after it from the latest one.
+func (r *versionRegistry) policies(ns string, i int) []*command.PolicyRules {
+	versions := r.versions[ns]
+	policies := versions[len(versions)-1].policies
+	for j := len(versions) - 2; j >= i; j-- {
+		policies = undo(policies, versions[j])
+	}
+	return policies
+}
+
+// undo returns the rules before the change of the version after v, given
+// the rules after it.
+func undo(policies []*command.PolicyRules, v *storedVersion) []*command.PolicyRules {
+	kept := subtract(policies, v.added)
+	byType := make(map[string]*command.PolicyRules, len(kept))
+	for _, p := range kept {
+		byType[p.Sec+"/"+p.PType] = p
+	}
+	for _, p := range v.removed {
+		if q, ok := byType[p.Sec+"/"+p.PType]; ok {
+			q.Rules = append(q.Rules[:len(q.Rules):len(q.Rules)], p.Rules...)
+			continue
+		}
+		q := &command.PolicyRules{Sec: p.Sec, PType: p.PType, Rules: p.Rules}
+		byType[p.Sec+"/"+p.PType] = q
+		kept = append(kept, q)
+	}
+	sortPolicies(kept)
+	return kept
+}
+
+func (v *storedVersion) materialize(policies []*command.PolicyRules) *command.PolicyVersion {
+	return &command.PolicyVersion{Version: v.version, Time: v.time, Tag: v.tag, Policies: policies}
+}
+
+// find returns the version with the number or the tag.
+func (r *versionRegistry) find(ns string, version uint64, tag string) *command.PolicyVersion {
+	for i, v := range r.versions[ns] {
+		if (tag != "" && v.tag == tag) || (tag == "" && v.version == version) {
+			return v.materialize(r.policies(ns, i))
+		}
+	}
+	return nil
+}
+
+// tag moves the tag to version v and returns it.
+func (r *versionRegistry) tag(ns string, v uint64, tag string) *command.PolicyVersion {
+	var tagged *command.PolicyVersion
+	for i, old := range r.versions[ns] {
+		switch {
+		case old.version == v:
+			old.tag = tag
+			tagged = old.materialize(r.policies(ns, i))
+		case old.tag == tag:
+			old.tag = ""
+		}
+	}
+	return tagged
+}
+
+// list returns the versions of ns, oldest first.
+func (r *versionRegistry) list(ns string) []*command.PolicyVersion {
+	versions := r.versions[ns]
+	out := make([]*command.PolicyVersion, len(versions))
+	var policies []*command.PolicyRules
+	for i := len(versions) - 1; i >= 0; i-- {
+		if i == len(versions)-1 {
+			policies = versions[i].policies
+		} else {
+			policies = undo(policies, versions[i])
+		}
+		out[i] = versions[i].materialize(policies)
+	}
+	return out
+}
+
+// registryJSON is the encoding of a versionRegistry in snapshots, which
+// holds the rules of every version.
+type registryJSON struct {
+	Versions map[string][]*command.PolicyVersion `json:"versions"`
+}
+
+func (r *versionRegistry) MarshalJSON() ([]byte, error) {
+	out := registryJSON{Versions: make(map[string][]*command.PolicyVersion, len(r.versions))}
+	for ns := range r.versions {
+		out.Versions[ns] = r.list(ns)
+	}
+	return json.Marshal(out)
+}
+
+func (r *versionRegistry) UnmarshalJSON(b []byte) error {
+	var in registryJSON
+	if err := json.Unmarshal(b, &in); err != nil {
+		return err
+	}
+	r.versions = make(map[string][]*storedVersion, len(in.Versions))
+	for ns, versions := range in.Versions {
+		stored := make([]*storedVersion, len(versions))
+		for i, v := range versions {
+			stored[i] = &storedVersion{version: v.Version, time: v.Time, tag: v.Tag}
+			if i == len(versions)-1 {
+				stored[i].policies = v.Policies
+			} else {
+				stored[i].added, stored[i].removed = DiffPolicies(v.Policies, versions[i+1].Policies)
+			}
+		}
+		r.versions[ns] = stored
+	}
+	return nil
+}
+
+// currentPolicies returns the rules of the enforcer ordered by sec and pType.
+func currentPolicies(enforcer *casbin.DistributedEnforcer) []*command.PolicyRules {
+	var policies []*command.PolicyRules
+	m := enforcer.GetModel()
+	for _, sec := range []string{"p", "g"} {
+		for pType, ast := range m[sec] {
+			if len(ast.Policy) == 0 {
+				continue
+			}
+			rules := make([][]string, len(ast.Policy))
+			copy(rules, ast.Policy)
+			policies = append(policies, &command.PolicyRules{Sec: sec, PType: pType, Rules: command.NewStringArray(rules)})
+		}
+	}
+	sortPolicies(policies)
+	return policies
+}
+
+// sortPolicies orders policies by sec and pType.
+func sortPolicies(policies []*command.PolicyRules) {
+	sort.Slice(policies, func(i, j int) bool {
+		if policies[i].Sec != policies[j].Sec {
+			return policies[i].Sec < policies[j].Sec
+		}
+		return policies[i].PType < policies[j].PType
+	})
+}
+
+func ruleSet(policies []*command.PolicyRules) map[string][]string {
+	set := make(map[string][]string)
+	for _, p := range policies {
+		for _, rule := range command.ToStringArray(p.Rules) {
+			set[ruleKey(p.Sec, p.PType, rule)] = rule
+		}
+	}
+	return set
+}
+
+func samePolicies(a, b []*command.PolicyRules) bool {
+	as, bs := ruleSet(a), ruleSet(b)
+	if len(as) != len(bs) {
+		return false
+	}
+	for k := range as {
+		if _, ok := bs[k]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// DiffPolicies returns the rules of to that are not in from, and those of
+// from that are not in to.
+func DiffPolicies(from, to []*command.PolicyRules) (added, removed []*command.PolicyRules) {
+	return subtract(to, from), subtract(from, to)
+}
+
+// subtract returns the rules of a that are not in b.
+func subtract(a, b []*command.PolicyRules) []*command.PolicyRules {
+	bs := ruleSet(b)
+	var out []*command.PolicyRules
+	for _, p := range a {
+		var rules [][]string
+		for _, rule := range command.ToStringArray(p.Rules) {
+			if _, ok := bs[ruleKey(p.Sec, p.PType, rule)]; !ok {
+				rules = append(rules, rule)
+			}
+		}
+		if len(rules) > 0 {
+			out = append(out, &command.PolicyRules{Sec: p.Sec, PType: p.PType, Rules: command.NewStringArray(rules)})
+		}
+	}
+	return out
+}
+
+// changesPolicies tells whether commands of type t may change the rules of a
+// namespace.
+func changesPolicies(t command.Type) bool {
+	switch t {
+	case command.Type_COMMAND_TYPE_SET_MODEL,
+		command.Type_COMMAND_TYPE_ADD_POLICIES,
+		command.Type_COMMAND_TYPE_UPDATE_POLICIES,
+		command.Type_COMMAND_TYPE_REMOVE_POLICIES,
+		command.Type_COMMAND_TYPE_REMOVE_FILTERED_POLICY,
+		command.Type_COMMAND_TYPE_CLEAR_POLICY,
+		command.Type_COMMAND_TYPE_EXPIRE_POLICIES,
+		command.Type_COMMAND_TYPE_SCHEDULE_POLICIES,
+		command.Type_COMMAND_TYPE_ROLLBACK_POLICIES:
+		return true
+	}
+	return false
+}
+
+// recordVersion records the rules of the namespace after the change in l.
+func (s *Store) recordVersion(l *raft.Log, ns string) *command.PolicyVersion {
+	e, ok := s.enforcers.Load(ns)
+	if !ok {
+		return nil
+	}
+	enforcer := e.(*casbin.DistributedEnforcer)
+	if enforcer.GetModel() == nil {
+		return nil
+	}
+	var t int64
+	if !l.AppendedAt.IsZero() {
+		t = l.AppendedAt.UnixNano()
+	}
+	return s.versions.record(ns, l.Index, t, currentPolicies(enforcer))
+}
+
+// applyRollback replaces the rules of the namespace by those of a version.
+// Only the difference is applied, so the rules that are kept keep their
+// expiry, schedule and annotation.
+func (s *Store) applyRollback(l *raft.Log, cmd *command.Command) interface{} {
+	if cmd.Metadata[replacePoliciesMeta] != "" {
+		return s.applyReplacePolicies(l, cmd)
+	}
+	var p command.RollbackPoliciesPayload
+	if err := proto.Unmarshal(cmd.Payload, &p); err != nil {
+		return &FSMResponse{error: UnmarshalFailed}
+	}
+	if _, ok := s.enforcers.Load(cmd.Namespace); !ok {
+		return &FSMResponse{error: NamespaceNotExist}
+	}
+	v := s.versions.find(cmd.Namespace, p.Version, p.Tag)
+	if v == nil {
+		return &FSMResponse{error: ErrVersionNotExist}
+	}
+	added, removed, err := s.replaceRules(l, cmd, v.Policies)
+	return &FSMResponse{effected: len(added) > 0 || len(removed) > 0, error: err}
+}
+
+// replaceRules makes target the rules of cmd.Namespace, and returns the rules
+// added and removed. Nothing changes if a rule of target has a policy type
+// the model does not define.
+func (s *Store) replaceRules(l *raft.Log, cmd *command.Command, target []*command.PolicyRules) (added, removed []*command.PolicyRules, err error) {
+	e, ok := s.enforcers.Load(cmd.Namespace)
+	if !ok {
+		return nil, nil, NamespaceNotExist
+	}
+	enforcer := e.(*casbin.DistributedEnforcer)
+	m := enforcer.GetModel()
+	if m == nil {
+		return nil, nil, ModelUnsetYet
+	}
+	added, removed = DiffPolicies(currentPolicies(enforcer), target)
+	for _, rules := range added {
+		if _, ok := m[rules.Sec][rules.PType]; !ok {
+			return nil, nil, fmt.Errorf("policy type %s not defined in the model", rules.PType)
+		}
+	}
+	for _, rules := range removed {
+		if _, err := enforcer.RemovePoliciesSelf(persist, rules.Sec, rules.PType, command.ToStringArray(rules.Rules)); err != nil {
+			return nil, nil, err
+		}
+		s.expiries.drop(cmd.Namespace, rules.Sec, rules.PType, command.ToStringArray(rules.Rules))
+		s.annotations.drop(cmd.Namespace, rules.Sec, rules.PType, command.ToStringArray(rules.Rules))
+	}
+	for _, rules := range added {
+		if _, err := enforcer.AddPoliciesSelf(persist, rules.Sec, rules.PType, command.ToStringArray(rules.Rules)); err != nil {
+			return nil, nil, err
+		}
+	}
+	if len(added) > 0 || len(removed) > 0 {
+		s.watchers.publish(&command.WatchEvent{Index: l.Index, Namespace: cmd.Namespace, Type: cmd.Type})
+	}
+	return added, removed, nil
+}
+
+// TagVersionResponse is the response of a TAG_VERSION command.
+type TagVersionResponse struct {
+	version uint64
+	error
+	replayMark
+}
+
+// applyTag tags a version, tagging the current rules records a version of
+// them if needed.
+func (s *Store) applyTag(l *raft.Log, cmd *command.Command) interface{} {
+	var p command.TagVersionPayload
+	if err := proto.Unmarshal(cmd.Payload, &p); err != nil {
+		return &TagVersionResponse{error: UnmarshalFailed}
+	}
+	if _, ok := s.enforcers.Load(cmd.Namespace); !ok {
+		return &TagVersionResponse{error: NamespaceNotExist}
+	}
+	var v *command.PolicyVersion
+	if p.Version == 0 {
+		v = s.recordVersion(l, cmd.Namespace)
+		if v == nil {
+			return &TagVersionResponse{error: ModelUnsetYet}
+		}
+	} else if v = s.versions.find(cmd.Namespace, p.Version, ""); v == nil {
+		return &TagVersionResponse{error: ErrVersionNotExist}
+	}
+	s.versions.tag(cmd.Namespace, v.Version, p.Tag)
+	return &TagVersionResponse{version: v.Version}
+}
+
+// ListVersionsResponse is the response of a LIST_VERSIONS command.
+type ListVersionsResponse struct {
+	versions []*command.PolicyVersion
+	error
+}
+
+// TagVersion tags a retained version of the rules of a namespace, or the
+// current rules if version is 0. The tag is moved if it is already in use. It
+// returns the tagged version.
+func (s *Store) TagVersion(ctx context.Context, ns string, tag string, version uint64) (uint64, error) {
+	payload, err := proto.Marshal(&command.TagVersionPayload{Tag: tag, Version: version})
+	if err != nil {
+		return 0, err
+	}
+	cmd, err := proto.Marshal(&command.Command{
+		Type:      command.Type_COMMAND_TYPE_TAG_VERSION,
+		Namespace: ns,
+		Payload:   payload,
+	})
+	if err != nil {
+		return 0, err
+	}
+	f, err := s.apply(ctx, cmd)
+	if err != nil {
+		return 0, err
+	}
+	r := f.Response().(*TagVersionResponse)
+	return r.version, r.error
+}
+
+// RollbackPolicies replaces the rules of a namespace by those of the version
+// with the number or, if set, the tag. It tells whether rules changed.
+func (s *Store) RollbackPolicies(ctx context.Context, ns string, version uint64, tag string) (bool, error) {
+	s.roleMu.Lock()
+	defer s.roleMu.Unlock()
+	if err := s.checkRollbackGrouping(ctx, ns, version, tag); err != nil {
+		return false, err
+	}
+	payload, err := proto.Marshal(&command.RollbackPoliciesPayload{Version: version, Tag: tag})
+	if err != nil {
+		return false, err
+	}
+	cmd, err := proto.Marshal(&command.Command{
+		Type:      command.Type_COMMAND_TYPE_ROLLBACK_POLICIES,
+		Namespace: ns,
+		Payload:   payload,
+	})
+	if err != nil {
+		return false, err
+	}
+	f, err := s.apply(ctx, cmd)
+	if err != nil {
+		return false, err
+	}
+	r := f.Response().(*FSMResponse)
+	return r.effected, r.error
+}
+
+// checkRollbackGrouping checks the grouping rules rolling ns back to a
+// retained version adds, without those it removes. The versions are read
+// through the log, an unknown version is left to the rollback to report.
+func (s *Store) checkRollbackGrouping(ctx context.Context, ns string, version uint64, tag string) error {
+	versions, err := s.ListVersions(ctx, ns)
+	if err != nil {
+		return err
+	}
+	for _, v := range versions {
+		if (tag != "" && v.Tag == tag) || (tag == "" && v.Version == version) {
+			return s.checkReplacedGrouping(ns, v.Policies)
+		}
+	}
+	return nil
+}
+
+// ListVersions returns the retained versions of the rules of a namespace,
+// oldest first.
+func (s *Store) ListVersions(ctx context.Context, ns string) ([]*command.PolicyVersion, error) {
+	cmd, err := proto.Marshal(&command.Command{
+		Type:      command.Type_COMMAND_TYPE_LIST_VERSIONS,
+		Namespace: ns,
+	})
+	if err != nil {
+		return nil, err
+	}
+	f, err := s.apply(ctx, cmd)
+	if err != nil {
+		return nil, err
+	}
+	r := f.Response().(*ListVersionsResponse)
+	return r.versions, r.error
+}
@@ -0,0 +1,185 @@
+// Copyright 2022 The casbin-mesh Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package store
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"github.com/casbin/casbin-mesh/proto/command"
+)
+
+var (
+	// ErrWatchCompacted is returned when a watcher asks to resume from an
+	// index that is no longer retained.
+	ErrWatchCompacted = errors.New("watch index compacted")
+
+	// ErrWatchLagging is returned when a watcher does not keep up with the
+	// rate of changes and has been disconnected.
+	ErrWatchLagging = errors.New("watcher is lagging behind")
+)
+
+const (
+	watchHistorySize = 4096
+	watchBufferSize  = 256
+)
+
+// watchHub fans out applied changes to watchers and retains a bounded
+// history of recent changes, so that watchers can resume after a reconnect.
+type watchHub struct {
+	mu      sync.RWMutex
+	history []*command.WatchEvent
+	// floor is the highest index for which changes are no longer retained.
+	floor uint64
+	// primed is set once the floor is known.
+	primed bool
+	subs   map[chan *command.WatchEvent]struct{}
+}
+
+func newWatchHub() *watchHub {
+	return &watchHub{subs: make(map[chan *command.WatchEvent]struct{})}
+}
+
+// observe is called for every applied log entry, so that the hub knows from
+// which index on it has a complete view of the changes.
+func (h *watchHub) observe(index uint64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if !h.primed {
+		h.floor = index - 1
+		h.primed = true
+	}
+}
+
+// publish records the event and delivers it to all watchers. Watchers whose
+// buffer is full are disconnected.
+func (h *watchHub) publish(ev *command.WatchEvent) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if len(h.history) == watchHistorySize {
+		h.floor = h.history[0].Index
+		h.history = h.history[1:]
+	}
+	h.history = append(h.history, ev)
+	for ch := range h.subs {
+		select {
+		case ch <- ev:
+		default:
+			delete(h.subs, ch)
+			close(ch)
+		}
+	}
+}
+
+// reset drops the retained history, it is called when the state is replaced
+// by a snapshot.
+func (h *watchHub) reset() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.history = nil
+	h.primed = false
+	h.disconnectLocked()
+}
+
+// disconnect ends all watches, watchers are expected to resume elsewhere.
+func (h *watchHub) disconnect() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.disconnectLocked()
+}
+
+func (h *watchHub) disconnectLocked() {
+	for ch := range h.subs {
+		delete(h.subs, ch)
+		close(ch)
+	}
+}
+
+// subscribe registers a watcher and returns the retained events after index.
+func (h *watchHub) subscribe(index uint64) (chan *command.WatchEvent, []*command.WatchEvent, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if index != 0 && (!h.primed || index < h.floor) {
+		return nil, nil, ErrWatchCompacted
+	}
+	var backlog []*command.WatchEvent
+	if index != 0 {
+		for _, ev := range h.history {
+			if ev.Index > index {
+				backlog = append(backlog, ev)
+			}
+		}
+	}
+	ch := make(chan *command.WatchEvent, watchBufferSize)
+	h.subs[ch] = struct{}{}
+	return ch, backlog, nil
+}
+
+func (h *watchHub) unsubscribe(ch chan *command.WatchEvent) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, ok := h.subs[ch]; ok {
+		delete(h.subs, ch)
+		close(ch)
+	}
+}
+
+// CloseWatches ends all running watches, so that watchers reconnect to
+// another node while this one shuts down.
+func (s *Store) CloseWatches() {
+	s.watchers.disconnect()
+}
+
+// Watch streams the changes of a namespace to fn, until ctx is done or fn
+// returns an error. Changes applied after index are replayed first, an index
+// of 0 only streams new changes. An empty namespace watches all namespaces.
+func (s *Store) Watch(ctx context.Context, ns string, index uint64, fn func(*command.WatchEvent) error) error {
+	ch, backlog, err := s.watchers.subscribe(index)
+	if err != nil {
+		return err
+	}
+	defer s.watchers.unsubscribe(ch)
+
+	last := index
+	send := func(ev *command.WatchEvent) error {
+		if ev.Index <= last {
+			return nil
+		}
+		last = ev.Index
+		if ns != "" && ev.Namespace != ns {
+			return nil
+		}
+		return fn(ev)
+	}
+	for _, ev := range backlog {
+		if err := send(ev); err != nil {
+			return err
+		}
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case ev, ok := <-ch:
+			if !ok {
+				return ErrWatchLagging
+			}
+			if err := send(ev); err != nil {
+				return err
+			}
+		}
+	}
+}
@@ -0,0 +1,60 @@
+// Copyright 2022 The casbin-mesh Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package store
+
+import (
+	"testing"
+
+	"github.com/casbin/casbin-mesh/proto/command"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_WatchHubResume(t *testing.T) {
+	h := newWatchHub()
+	for i := uint64(5); i < 10; i++ {
+		h.observe(i)
+		h.publish(&command.WatchEvent{Index: i, Namespace: "default"})
+	}
+
+	ch, backlog, err := h.subscribe(7)
+	assert.NoError(t, err)
+	assert.Len(t, backlog, 2)
+	assert.Equal(t, uint64(8), backlog[0].Index)
+	assert.Equal(t, uint64(9), backlog[1].Index)
+
+	h.observe(10)
+	h.publish(&command.WatchEvent{Index: 10, Namespace: "default"})
+	ev := <-ch
+	assert.Equal(t, uint64(10), ev.Index)
+	h.unsubscribe(ch)
+
+	// Changes before the first observed index are unknown.
+	_, _, err = h.subscribe(3)
+	assert.Equal(t, ErrWatchCompacted, err)
+}
+
+func Test_WatchHubReset(t *testing.T) {
+	h := newWatchHub()
+	h.observe(1)
+	h.publish(&command.WatchEvent{Index: 1})
+	ch, _, err := h.subscribe(0)
+	assert.NoError(t, err)
+
+	h.reset()
+	_, ok := <-ch
+	assert.False(t, ok)
+	_, _, err = h.subscribe(1)
+	assert.Equal(t, ErrWatchCompacted, err)
+}
@@ -0,0 +1,165 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package store
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// DefaultApplyStallTimeout is how long the FSM may make no progress before
+// it is deemed stalled.
+const DefaultApplyStallTimeout = 30 * time.Second
+
+// applyCheckInterval is how often the progress of the FSM is checked.
+const applyCheckInterval = time.Second
+
+const (
+	applyStalled   = "apply_stalled"
+	numApplyStalls = "num_apply_stalls"
+)
+
+// ApplyStatus tells whether the FSM keeps up with the committed entries.
+type ApplyStatus struct {
+	// Stalled is set while an entry has been applied for longer than
+	// ApplyStallTimeout, or committed entries have been waiting for as long
+	// without any being applied.
+	Stalled bool `json:"stalled"`
+	// Since is the unix time in nanoseconds the FSM made progress last.
+	Since int64 `json:"since,omitempty"`
+	// Entry is the index of the entry being applied, 0 if none.
+	Entry     uint64 `json:"entry,omitempty"`
+	Applied   uint64 `json:"applied"`
+	Committed uint64 `json:"committed"`
+}
+
+// applyWatchdog tracks the progress of the FSM.
+type applyWatchdog struct {
+	mu sync.Mutex
+	// current is the entry being applied since started.
+	current uint64
+	started time.Time
+	// applied is the last applied index seen, at progressed.
+	applied    uint64
+	progressed time.Time
+	status     ApplyStatus
+}
+
+func newApplyWatchdog() *applyWatchdog {
+	return &applyWatchdog{progressed: time.Now()}
+}
+
+func (w *applyWatchdog) begin(index uint64) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.current, w.started = index, time.Now()
+}
+
+func (w *applyWatchdog) end() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.current = 0
+}
+
+// check updates the status with the committed and applied indexes at now,
+// and tells whether Stalled changed.
+func (w *applyWatchdog) check(now time.Time, committed, applied uint64, timeout time.Duration) (ApplyStatus, bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if applied != w.applied || committed <= applied {
+		w.applied, w.progressed = applied, now
+	}
+	status := ApplyStatus{Applied: applied, Committed: committed, Entry: w.current}
+	switch {
+	case w.current != 0 && now.Sub(w.started) > timeout:
+		status.Stalled, status.Since = true, w.started.UnixNano()
+	case now.Sub(w.progressed) > timeout:
+		status.Stalled, status.Since = true, w.progressed.UnixNano()
+	}
+	changed := status.Stalled != w.status.Stalled
+	w.status = status
+	return status, changed
+}
+
+func (w *applyWatchdog) get() ApplyStatus {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.status
+}
+
+// ApplyStatus returns whether the FSM keeps up with the committed entries,
+// as of the last check.
+func (s *Store) ApplyStatus() ApplyStatus {
+	return s.applyWatch.get()
+}
+
+func (s *Store) checkApply() {
+	raftStats := s.raft.Stats()
+	committed, _ := strconv.ParseUint(raftStats["commit_index"], 10, 64)
+	applied, _ := strconv.ParseUint(raftStats["applied_index"], 10, 64)
+	status, changed := s.applyWatch.check(time.Now(), committed, applied, s.ApplyStallTimeout)
+	if !changed {
+		return
+	}
+	if !status.Stalled {
+		stats.Set(applyStalled, intVar(0))
+		s.logger.Printf("FSM applying entries again, at index %d", status.Applied)
+		return
+	}
+	stats.Set(applyStalled, intVar(1))
+	stats.Add(numApplyStalls, 1)
+	err := fmt.Errorf("FSM stalled for more than %s at index %d, %d entries committed", s.ApplyStallTimeout, status.Applied, status.Committed)
+	if status.Entry != 0 {
+		err = fmt.Errorf("FSM stalled applying entry %d for more than %s", status.Entry, s.ApplyStallTimeout)
+	}
+	s.logger.Printf("ALERT: %s", err.Error())
+	s.reportError(err)
+	if s.OnApplyStall != nil {
+		s.OnApplyStall(status)
+	}
+}
+
+// startApplyWatchdog checks the progress of the FSM every
+// applyCheckInterval, if ApplyStallTimeout is set.
+func (s *Store) startApplyWatchdog() {
+	if s.ApplyStallTimeout <= 0 {
+		return
+	}
+	s.applyWatchDone = make(chan struct{})
+	go func(done chan struct{}) {
+		t := time.NewTicker(applyCheckInterval)
+		defer t.Stop()
+		for {
+			select {
+			case <-t.C:
+				s.checkApply()
+			case <-done:
+				return
+			}
+		}
+	}(s.applyWatchDone)
+}
+
+func (s *Store) stopApplyWatchdog() {
+	if s.applyWatchDone != nil {
+		close(s.applyWatchDone)
+		s.applyWatchDone = nil
+	}
+}
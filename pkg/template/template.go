@@ -0,0 +1,314 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+// Package template instantiates parameterized policy sets. A template is a
+// set of rules referring to variables as ${param}, an instance renders it with
+// its variables into a namespace and records where its rules came from, so
+// that it can be upgraded when the template changes.
+package template
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/casbin/casbin-mesh/proto/command"
+)
+
+var paramRe = regexp.MustCompile(`\$\{([^}]*)\}`)
+
+// Validate checks that the template is named and that its rules only refer to
+// declared params.
+func Validate(t *command.Template) error {
+	if t.Name == "" {
+		return fmt.Errorf("template has no name")
+	}
+	declared := make(map[string]bool, len(t.Params))
+	for _, p := range t.Params {
+		if p == "" || strings.ContainsAny(p, "${}") {
+			return fmt.Errorf("invalid param %q", p)
+		}
+		declared[p] = true
+	}
+	for _, p := range t.Policies {
+		if p.Sec == "" || p.PType == "" {
+			return fmt.Errorf("policies of template %s need a sec and a ptype", t.Name)
+		}
+		for _, rule := range command.ToStringArray(p.Rules) {
+			for _, field := range rule {
+				for _, m := range paramRe.FindAllStringSubmatch(field, -1) {
+					if !declared[m[1]] {
+						return fmt.Errorf("rule %v refers to undeclared param %q", rule, m[1])
+					}
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// Render substitutes vars in the rules of t. Every param must be given a
+// value, and no other variable may be given.
+func Render(t *command.Template, vars map[string]string) ([]*command.PolicyRules, error) {
+	declared := make(map[string]bool, len(t.Params))
+	for _, p := range t.Params {
+		if _, ok := vars[p]; !ok {
+			return nil, fmt.Errorf("missing value for param %q of template %s", p, t.Name)
+		}
+		declared[p] = true
+	}
+	for k := range vars {
+		if !declared[k] {
+			return nil, fmt.Errorf("template %s has no param %q", t.Name, k)
+		}
+	}
+	var out []*command.PolicyRules
+	for _, p := range t.Policies {
+		rules := command.ToStringArray(p.Rules)
+		rendered := make([][]string, len(rules))
+		for i, rule := range rules {
+			rendered[i] = make([]string, len(rule))
+			for j, field := range rule {
+				rendered[i][j] = paramRe.ReplaceAllStringFunc(field, func(m string) string {
+					return vars[m[2:len(m)-1]]
+				})
+			}
+		}
+		out = append(out, &command.PolicyRules{Sec: p.Sec, PType: p.PType, Rules: command.NewStringArray(rendered)})
+	}
+	return out, nil
+}
+
+// Target is the subset of core.Core the Manager needs. Rendered rules are
+// added through it, so their role hierarchies are checked like any other.
+type Target interface {
+	NamespaceSnapshot(ctx context.Context, ns string) (string, []*command.PolicyRules, uint64, error)
+	AddPolicies(ctx context.Context, ns string, sec string, pType string, rules [][]string) ([][]string, error)
+	RemovePolicies(ctx context.Context, ns string, sec string, pType string, rules [][]string) ([][]string, error)
+	ListTemplates(ctx context.Context) ([]*command.Template, []*command.TemplateInstance, error)
+	SetTemplateInstance(ctx context.Context, inst *command.TemplateInstance) error
+	DeleteTemplateInstance(ctx context.Context, ns, name string) error
+}
+
+// Manager instantiates, upgrades and removes template instances. Rules are
+// added before the instance is recorded, so an interrupted change is
+// completed by repeating it.
+type Manager struct {
+	target Target
+}
+
+// New returns a Manager changing the policies of target.
+func New(target Target) *Manager {
+	return &Manager{target: target}
+}
+
+// Instantiate renders the template into ns as the instance name. An existing
+// instance of the same template is re-rendered with vars, at the latest
+// version of the template.
+func (m *Manager) Instantiate(ctx context.Context, ns, name, template string, vars map[string]string) (*command.TemplateInstance, error) {
+	if name == "" {
+		return nil, fmt.Errorf("instance has no name")
+	}
+	templates, instances, err := m.target.ListTemplates(ctx)
+	if err != nil {
+		return nil, err
+	}
+	t := findTemplate(templates, template)
+	if t == nil {
+		return nil, fmt.Errorf("template %s not exist", template)
+	}
+	if old := findInstance(instances, ns, name); old != nil && old.Template != template {
+		return nil, fmt.Errorf("instance %s of %s is an instance of template %s", name, ns, old.Template)
+	}
+	if vars == nil {
+		vars = map[string]string{}
+	}
+	return m.render(ctx, t, instances, &command.TemplateInstance{Namespace: ns, Name: name, Template: template, Vars: vars})
+}
+
+// Upgrade re-renders the instances of the template rendered from an older
+// version, with their variables. It returns the upgraded instances, up to the
+// first failure.
+func (m *Manager) Upgrade(ctx context.Context, template string) ([]*command.TemplateInstance, error) {
+	templates, instances, err := m.target.ListTemplates(ctx)
+	if err != nil {
+		return nil, err
+	}
+	t := findTemplate(templates, template)
+	if t == nil {
+		return nil, fmt.Errorf("template %s not exist", template)
+	}
+	var upgraded []*command.TemplateInstance
+	for i, old := range instances {
+		if old.Template != template || old.Version == t.Version {
+			continue
+		}
+		inst, err := m.render(ctx, t, instances, &command.TemplateInstance{Namespace: old.Namespace, Name: old.Name,
+			Template: template, Vars: old.Vars})
+		if err != nil {
+			return upgraded, fmt.Errorf("failed to upgrade %s of %s: %s", old.Name, old.Namespace, err.Error())
+		}
+		// later instances of the namespace must keep the upgraded rules
+		instances[i] = inst
+		upgraded = append(upgraded, inst)
+	}
+	return upgraded, nil
+}
+
+// Remove removes the rules of an instance, except those other instances of
+// the namespace also render, and forgets the instance.
+func (m *Manager) Remove(ctx context.Context, ns, name string) error {
+	_, instances, err := m.target.ListTemplates(ctx)
+	if err != nil {
+		return err
+	}
+	old := findInstance(instances, ns, name)
+	if old == nil {
+		return fmt.Errorf("instance %s of %s not exist", name, ns)
+	}
+	if err := m.sync(ctx, ns, old.Policies, nil, others(instances, ns, name)); err != nil {
+		return err
+	}
+	return m.target.DeleteTemplateInstance(ctx, ns, name)
+}
+
+func (m *Manager) render(ctx context.Context, t *command.Template, instances []*command.TemplateInstance, inst *command.TemplateInstance) (*command.TemplateInstance, error) {
+	policies, err := Render(t, inst.Vars)
+	if err != nil {
+		return nil, err
+	}
+	var oldPolicies []*command.PolicyRules
+	if old := findInstance(instances, inst.Namespace, inst.Name); old != nil {
+		oldPolicies = old.Policies
+	}
+	if err := m.sync(ctx, inst.Namespace, oldPolicies, policies, others(instances, inst.Namespace, inst.Name)); err != nil {
+		return nil, err
+	}
+	inst.Version = t.Version
+	inst.Policies = policies
+	if err := m.target.SetTemplateInstance(ctx, inst); err != nil {
+		return nil, err
+	}
+	return inst, nil
+}
+
+// sync moves ns from the rules of an instance to its new rules. Old rules
+// rendered by other instances are kept.
+func (m *Manager) sync(ctx context.Context, ns string, old, rendered []*command.PolicyRules, others []*command.TemplateInstance) error {
+	_, current, _, err := m.target.NamespaceSnapshot(ctx, ns)
+	if err != nil {
+		return err
+	}
+	present := ruleSet(current)
+	keep := ruleSet(rendered)
+	for _, inst := range others {
+		for k, r := range ruleSet(inst.Policies) {
+			keep[k] = r
+		}
+	}
+
+	remove := make(map[policyKey][][]string)
+	for k, r := range ruleSet(old) {
+		if _, ok := keep[k]; ok {
+			continue
+		}
+		if _, ok := present[k]; ok {
+			remove[r.key] = append(remove[r.key], r.rule)
+		}
+	}
+	add := make(map[policyKey][][]string)
+	for k, r := range ruleSet(rendered) {
+		if _, ok := present[k]; !ok {
+			add[r.key] = append(add[r.key], r.rule)
+		}
+	}
+	for _, key := range sortedKeys(remove) {
+		if _, err := m.target.RemovePolicies(ctx, ns, key.sec, key.pType, remove[key]); err != nil {
+			return err
+		}
+	}
+	for _, key := range sortedKeys(add) {
+		if _, err := m.target.AddPolicies(ctx, ns, key.sec, key.pType, add[key]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type policyKey struct {
+	sec, pType string
+}
+
+type keyedRule struct {
+	key  policyKey
+	rule []string
+}
+
+func ruleSet(policies []*command.PolicyRules) map[string]keyedRule {
+	set := make(map[string]keyedRule)
+	for _, p := range policies {
+		key := policyKey{p.Sec, p.PType}
+		for _, rule := range command.ToStringArray(p.Rules) {
+			set[p.Sec+"\x00"+p.PType+"\x00"+strings.Join(rule, "\x00")] = keyedRule{key, rule}
+		}
+	}
+	return set
+}
+
+func sortedKeys(m map[policyKey][][]string) []policyKey {
+	keys := make([]policyKey, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].sec != keys[j].sec {
+			return keys[i].sec < keys[j].sec
+		}
+		return keys[i].pType < keys[j].pType
+	})
+	return keys
+}
+
+func findTemplate(templates []*command.Template, name string) *command.Template {
+	for _, t := range templates {
+		if t.Name == name {
+			return t
+		}
+	}
+	return nil
+}
+
+func findInstance(instances []*command.TemplateInstance, ns, name string) *command.TemplateInstance {
+	for _, inst := range instances {
+		if inst.Namespace == ns && inst.Name == name {
+			return inst
+		}
+	}
+	return nil
+}
+
+func others(instances []*command.TemplateInstance, ns, name string) []*command.TemplateInstance {
+	var out []*command.TemplateInstance
+	for _, inst := range instances {
+		if inst.Namespace == ns && inst.Name != name {
+			out = append(out, inst)
+		}
+	}
+	return out
+}
@@ -0,0 +1,185 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package template
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/casbin/casbin-mesh/proto/command"
+)
+
+// fakeTarget keeps the policies of a single namespace, keyed by sec/ptype.
+type fakeTarget struct {
+	policies  map[string][][]string
+	templates []*command.Template
+	instances []*command.TemplateInstance
+}
+
+func newFakeTarget(templates ...*command.Template) *fakeTarget {
+	return &fakeTarget{policies: make(map[string][][]string), templates: templates}
+}
+
+func (f *fakeTarget) NamespaceSnapshot(ctx context.Context, ns string) (string, []*command.PolicyRules, uint64, error) {
+	var out []*command.PolicyRules
+	for k, rules := range f.policies {
+		sec, pType := k[:strings.Index(k, "/")], k[strings.Index(k, "/")+1:]
+		out = append(out, &command.PolicyRules{Sec: sec, PType: pType, Rules: command.NewStringArray(rules)})
+	}
+	return "", out, 1, nil
+}
+
+func (f *fakeTarget) AddPolicies(ctx context.Context, ns string, sec string, pType string, rules [][]string) ([][]string, error) {
+	f.policies[sec+"/"+pType] = append(f.policies[sec+"/"+pType], rules...)
+	return rules, nil
+}
+
+func (f *fakeTarget) RemovePolicies(ctx context.Context, ns string, sec string, pType string, rules [][]string) ([][]string, error) {
+	var kept [][]string
+	for _, r := range f.policies[sec+"/"+pType] {
+		removed := false
+		for _, rm := range rules {
+			if reflect.DeepEqual(r, rm) {
+				removed = true
+			}
+		}
+		if !removed {
+			kept = append(kept, r)
+		}
+	}
+	f.policies[sec+"/"+pType] = kept
+	return rules, nil
+}
+
+func (f *fakeTarget) ListTemplates(ctx context.Context) ([]*command.Template, []*command.TemplateInstance, error) {
+	return f.templates, append([]*command.TemplateInstance(nil), f.instances...), nil
+}
+
+func (f *fakeTarget) SetTemplateInstance(ctx context.Context, inst *command.TemplateInstance) error {
+	for i, old := range f.instances {
+		if old.Namespace == inst.Namespace && old.Name == inst.Name {
+			f.instances[i] = inst
+			return nil
+		}
+	}
+	f.instances = append(f.instances, inst)
+	return nil
+}
+
+func (f *fakeTarget) DeleteTemplateInstance(ctx context.Context, ns, name string) error {
+	for i, old := range f.instances {
+		if old.Namespace == ns && old.Name == name {
+			f.instances = append(f.instances[:i], f.instances[i+1:]...)
+			return nil
+		}
+	}
+	return nil
+}
+
+func (f *fakeTarget) rules(key string) []string {
+	var out []string
+	for _, r := range f.policies[key] {
+		out = append(out, strings.Join(r, ","))
+	}
+	sort.Strings(out)
+	return out
+}
+
+func projectTemplate(version uint64, rules ...[]string) *command.Template {
+	return &command.Template{Name: "project", Version: version, Params: []string{"project"},
+		Policies: []*command.PolicyRules{{Sec: "p", PType: "p", Rules: command.NewStringArray(rules)}}}
+}
+
+func Test_ValidateAndRender(t *testing.T) {
+	tpl := projectTemplate(1, []string{"${project}-admin", "/projects/${project}/*", "write"})
+	if err := Validate(tpl); err != nil {
+		t.Fatalf("failed to validate template: %s", err.Error())
+	}
+	policies, err := Render(tpl, map[string]string{"project": "alpha"})
+	if err != nil {
+		t.Fatalf("failed to render template: %s", err.Error())
+	}
+	if got := command.ToStringArray(policies[0].Rules); !reflect.DeepEqual(got, [][]string{{"alpha-admin", "/projects/alpha/*", "write"}}) {
+		t.Fatalf("wrong rendered rules %v", got)
+	}
+	if _, err := Render(tpl, nil); err == nil {
+		t.Fatalf("expected error for missing param")
+	}
+	if _, err := Render(tpl, map[string]string{"project": "alpha", "tenant": "acme"}); err == nil {
+		t.Fatalf("expected error for unknown param")
+	}
+	if err := Validate(projectTemplate(1, []string{"${tenant}", "data", "read"})); err == nil {
+		t.Fatalf("expected error for undeclared param")
+	}
+}
+
+func Test_InstantiateUpgradeRemove(t *testing.T) {
+	f := newFakeTarget(projectTemplate(1,
+		[]string{"${project}-admin", "${project}", "write"},
+		[]string{"${project}-viewer", "${project}", "read"},
+		[]string{"auditor", "logs", "read"},
+	))
+	m := New(f)
+	ctx := context.Background()
+	if _, err := m.Instantiate(ctx, "acme", "alpha", "project", map[string]string{"project": "alpha"}); err != nil {
+		t.Fatalf("failed to instantiate: %s", err.Error())
+	}
+	if _, err := m.Instantiate(ctx, "acme", "beta", "project", map[string]string{"project": "beta"}); err != nil {
+		t.Fatalf("failed to instantiate: %s", err.Error())
+	}
+	want := []string{"alpha-admin,alpha,write", "alpha-viewer,alpha,read", "auditor,logs,read",
+		"beta-admin,beta,write", "beta-viewer,beta,read"}
+	if got := f.rules("p/p"); !reflect.DeepEqual(got, want) {
+		t.Fatalf("wrong rules after instantiation %v", got)
+	}
+
+	// The new version drops the viewer role, only its rules are removed.
+	f.templates[0] = projectTemplate(2,
+		[]string{"${project}-admin", "${project}", "write"},
+		[]string{"auditor", "logs", "read"},
+	)
+	upgraded, err := m.Upgrade(ctx, "project")
+	if err != nil {
+		t.Fatalf("failed to upgrade: %s", err.Error())
+	}
+	if len(upgraded) != 2 || upgraded[0].Version != 2 {
+		t.Fatalf("wrong upgraded instances %v", upgraded)
+	}
+	want = []string{"alpha-admin,alpha,write", "auditor,logs,read", "beta-admin,beta,write"}
+	if got := f.rules("p/p"); !reflect.DeepEqual(got, want) {
+		t.Fatalf("wrong rules after upgrade %v", got)
+	}
+	if upgraded, _ = m.Upgrade(ctx, "project"); len(upgraded) != 0 {
+		t.Fatalf("up to date instances were upgraded again")
+	}
+
+	// Rules shared with beta are kept.
+	if err := m.Remove(ctx, "acme", "alpha"); err != nil {
+		t.Fatalf("failed to remove instance: %s", err.Error())
+	}
+	want = []string{"auditor,logs,read", "beta-admin,beta,write"}
+	if got := f.rules("p/p"); !reflect.DeepEqual(got, want) {
+		t.Fatalf("wrong rules after removal %v", got)
+	}
+	if len(f.instances) != 1 || f.instances[0].Name != "beta" {
+		t.Fatalf("wrong instances after removal %v", f.instances)
+	}
+}
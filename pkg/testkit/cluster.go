@@ -0,0 +1,257 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package testkit
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/casbin/casbin-mesh/pkg/core"
+	"github.com/casbin/casbin-mesh/pkg/store"
+	"github.com/casbin/casbin-mesh/proto/command"
+)
+
+const (
+	// Raft timeouts are those of the in-memory clusters of Raft's own tests,
+	// so that elections and failovers take tens of milliseconds. Raft times
+	// out on the real clock, delivery is what the Network controls and the
+	// time rules are decided against is what the Clock controls.
+	heartbeatTimeout   = 50 * time.Millisecond
+	electionTimeout    = 50 * time.Millisecond
+	leaderLeaseTimeout = 50 * time.Millisecond
+
+	// DefaultTimeout bounds the waits of the Cluster helpers.
+	DefaultTimeout = 10 * time.Second
+)
+
+// Node is a node of a Cluster.
+type Node struct {
+	ID   string
+	Addr string
+	// Store and Core are replaced when the node restarts.
+	Store *store.Store
+	Core  core.Core
+
+	memory  *store.MemoryStorage
+	running bool
+}
+
+// Cluster is a cluster of nodes running in process, holding their state in
+// memory and connected by a Network.
+type Cluster struct {
+	t     testing.TB
+	Net   *Network
+	Clock *Clock
+	Nodes []*Node
+
+	mu sync.Mutex
+}
+
+// NewCluster starts a cluster of n voters, and waits for all of them to join
+// it. The cluster is closed when the test ends.
+func NewCluster(t testing.TB, n int) *Cluster {
+	t.Helper()
+	c := &Cluster{t: t, Net: NewNetwork(), Clock: NewClock(time.Now())}
+	t.Cleanup(c.Close)
+	for i := 0; i < n; i++ {
+		id := fmt.Sprintf("node%d", i)
+		node := &Node{ID: id, Addr: id, memory: store.NewMemoryStorage()}
+		c.Nodes = append(c.Nodes, node)
+		if err := c.open(node, i == 0); err != nil {
+			t.Fatalf("failed to start %s: %s", id, err.Error())
+		}
+		if i == 0 {
+			if _, err := c.WaitForLeader(DefaultTimeout); err != nil {
+				t.Fatalf("first node did not become leader: %s", err.Error())
+			}
+			continue
+		}
+		leader, err := c.WaitForLeader(DefaultTimeout)
+		if err != nil {
+			t.Fatalf("no leader to join %s to: %s", id, err.Error())
+		}
+		if err := leader.Store.Join(id, id, true, nil); err != nil {
+			t.Fatalf("failed to join %s: %s", id, err.Error())
+		}
+	}
+	if err := c.WaitForConvergence(DefaultTimeout); err != nil {
+		t.Fatalf("cluster did not converge: %s", err.Error())
+	}
+	return c
+}
+
+// open starts the store of node on its state.
+func (c *Cluster) open(node *Node, bootstrap bool) error {
+	tn, err := c.Net.Transport(node.Addr)
+	if err != nil {
+		return err
+	}
+	s := store.New(nil, &store.StoreConfig{
+		ID:            node.ID,
+		Logger:        log.New(ioutil.Discard, "", 0),
+		RaftTransport: tn,
+		Memory:        node.memory,
+		Clock:         c.Clock.Now,
+	})
+	s.RaftLogLevel = "ERROR"
+	s.HeartbeatTimeout = heartbeatTimeout
+	s.ElectionTimeout = electionTimeout
+	s.LeaderLeaseTimeout = leaderLeaseTimeout
+	if err := s.Open(bootstrap); err != nil {
+		tn.Close()
+		return err
+	}
+	node.Store, node.Core, node.running = s, core.New(s), true
+	return nil
+}
+
+// Stop stops node i, which keeps its state for Restart.
+func (c *Cluster) Stop(i int) {
+	c.t.Helper()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	node := c.Nodes[i]
+	if !node.running {
+		return
+	}
+	node.running = false
+	if err := node.Store.Close(true); err != nil {
+		c.t.Fatalf("failed to stop %s: %s", node.ID, err.Error())
+	}
+}
+
+// Restart starts node i again after Stop, from its state.
+func (c *Cluster) Restart(i int) {
+	c.t.Helper()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	node := c.Nodes[i]
+	if node.running {
+		return
+	}
+	if err := c.open(node, false); err != nil {
+		c.t.Fatalf("failed to restart %s: %s", node.ID, err.Error())
+	}
+}
+
+// Close stops all the nodes.
+func (c *Cluster) Close() {
+	c.Net.Heal()
+	for i := range c.Nodes {
+		c.Stop(i)
+	}
+}
+
+// running returns the running nodes.
+func (c *Cluster) running() []*Node {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var nodes []*Node
+	for _, n := range c.Nodes {
+		if n.running {
+			nodes = append(nodes, n)
+		}
+	}
+	return nodes
+}
+
+// Leader returns the leader, failing the test if there is none within
+// DefaultTimeout.
+func (c *Cluster) Leader() *Node {
+	c.t.Helper()
+	l, err := c.WaitForLeader(DefaultTimeout)
+	if err != nil {
+		c.t.Fatalf("no leader: %s", err.Error())
+	}
+	return l
+}
+
+// WaitForLeader waits for a running node to be leader, and returns it.
+func (c *Cluster) WaitForLeader(timeout time.Duration) (*Node, error) {
+	var leader *Node
+	err := c.waitFor(timeout, func() bool {
+		for _, n := range c.running() {
+			if n.Store.IsLeader() {
+				leader = n
+				return true
+			}
+		}
+		return false
+	})
+	if err != nil {
+		return nil, fmt.Errorf("no leader elected: %w", err)
+	}
+	return leader, nil
+}
+
+// WaitForConvergence waits for the running nodes to apply all the entries the
+// leader applied.
+func (c *Cluster) WaitForConvergence(timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	leader, err := c.WaitForLeader(timeout)
+	if err != nil {
+		return err
+	}
+	idx := leader.Store.AppliedIndex()
+	for _, n := range c.running() {
+		err := c.waitFor(time.Until(deadline), func() bool {
+			return n.Store.AppliedIndex() >= idx
+		})
+		if err != nil {
+			return fmt.Errorf("%s did not apply index %d: %w", n.ID, idx, err)
+		}
+	}
+	return nil
+}
+
+// RequireConverged waits for the cluster to converge, and then for every
+// running node to decide params in namespace ns as exp from its own state.
+// Raft counts entries applied once they are handed to the FSM, a node may
+// still be applying them when the cluster converges.
+func (c *Cluster) RequireConverged(ns string, exp bool, params ...interface{}) {
+	c.t.Helper()
+	if err := c.WaitForConvergence(DefaultTimeout); err != nil {
+		c.t.Fatalf("cluster did not converge: %s", err.Error())
+	}
+	for _, n := range c.running() {
+		var ok bool
+		var err error
+		c.waitFor(DefaultTimeout, func() bool {
+			ok, err = n.Core.Enforce(context.Background(), ns, int32(command.EnforcePayload_QUERY_REQUEST_LEVEL_NONE), 0, params...)
+			return err == nil && ok == exp
+		})
+		if err != nil {
+			c.t.Fatalf("%s failed to enforce %v: %s", n.ID, params, err.Error())
+		}
+		if ok != exp {
+			c.t.Fatalf("%s decided %v for %v, exp %v", n.ID, ok, params, exp)
+		}
+	}
+}
+
+// waitFor waits for cond to hold, checking it whenever the network delivers
+// an RPC, or timeout expires. Nodes elect themselves on their own, cond is
+// also checked every heartbeat timeout.
+func (c *Cluster) waitFor(timeout time.Duration, cond func() bool) error {
+	return c.Net.wait(timeout, heartbeatTimeout, cond)
+}
@@ -0,0 +1,99 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package testkit
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/casbin/casbin-mesh/pkg/preset"
+)
+
+func TestClusterFailover(t *testing.T) {
+	ctx := context.Background()
+	c := NewCluster(t, 3)
+	rbac, err := preset.Get("rbac")
+	if err != nil {
+		t.Fatalf("failed to get RBAC preset: %s", err.Error())
+	}
+	leader := c.Leader()
+	if err := leader.Core.CreateNamespace(ctx, "default"); err != nil {
+		t.Fatalf("failed to create namespace: %s", err.Error())
+	}
+	if err := leader.Core.SetModelFromString(ctx, "default", rbac.Text); err != nil {
+		t.Fatalf("failed to set model: %s", err.Error())
+	}
+	if _, err := leader.Core.AddPolicies(ctx, "default", "p", "p", [][]string{{"alice", "data1", "read"}}); err != nil {
+		t.Fatalf("failed to add policy: %s", err.Error())
+	}
+	c.RequireConverged("default", true, "alice", "data1", "read")
+
+	// The majority elects a new leader once the leader is isolated.
+	c.Net.Isolate(leader.Addr)
+	var next *Node
+	err = c.waitFor(DefaultTimeout, func() bool {
+		for _, n := range c.running() {
+			if n != leader && n.Store.IsLeader() {
+				next = n
+				return true
+			}
+		}
+		return false
+	})
+	if err != nil {
+		t.Fatalf("no leader elected in the majority: %s", err.Error())
+	}
+	if _, err := next.Core.AddPolicies(ctx, "default", "p", "p", [][]string{{"bob", "data1", "read"}}); err != nil {
+		t.Fatalf("failed to add policy in the majority: %s", err.Error())
+	}
+
+	// The old leader catches up once the partition heals.
+	c.Net.Heal()
+	c.RequireConverged("default", true, "bob", "data1", "read")
+
+	// A restarted node recovers its state.
+	c.Stop(1)
+	c.Restart(1)
+	c.RequireConverged("default", true, "bob", "data1", "read")
+}
+
+func TestClusterClock(t *testing.T) {
+	ctx := context.Background()
+	c := NewCluster(t, 3)
+	rbac, err := preset.Get("rbac")
+	if err != nil {
+		t.Fatalf("failed to get RBAC preset: %s", err.Error())
+	}
+	leader := c.Leader()
+	if err := leader.Core.CreateNamespace(ctx, "default"); err != nil {
+		t.Fatalf("failed to create namespace: %s", err.Error())
+	}
+	if err := leader.Core.SetModelFromString(ctx, "default", rbac.Text); err != nil {
+		t.Fatalf("failed to set model: %s", err.Error())
+	}
+	expireAt := c.Clock.Now().Add(time.Hour)
+	if _, err := leader.Core.AddExpiringPolicies(ctx, "default", "p", "p", [][]string{{"alice", "data1", "read"}}, expireAt); err != nil {
+		t.Fatalf("failed to add expiring policy: %s", err.Error())
+	}
+	c.RequireConverged("default", true, "alice", "data1", "read")
+
+	// Every node expires the rule as soon as the clock passes its expiry.
+	c.Clock.Advance(2 * time.Hour)
+	c.RequireConverged("default", false, "alice", "data1", "read")
+}
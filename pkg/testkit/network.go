@@ -0,0 +1,285 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package testkit
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/raft"
+)
+
+// ErrPartitioned is returned when sending an RPC across a cut link.
+var ErrPartitioned = errors.New("link cut by the network")
+
+// link is the direction of a connection between two addresses.
+type link struct {
+	from, to string
+}
+
+// Network connects the Raft transports of nodes in memory. Every link, the
+// direction from one address to another, can be cut, delayed or held, so
+// that tests decide how and when RPCs and their responses are delivered.
+type Network struct {
+	mu         sync.Mutex
+	cond       *sync.Cond
+	transports map[string]*Transport
+	cut        map[link]bool
+	delay      map[link]time.Duration
+	held       map[link]bool
+	// version is bumped whenever an RPC is delivered or a link changes,
+	// for wait to wake on.
+	version uint64
+}
+
+// NewNetwork returns a Network whose links all deliver at once.
+func NewNetwork() *Network {
+	n := &Network{
+		transports: make(map[string]*Transport),
+		cut:        make(map[link]bool),
+		delay:      make(map[link]time.Duration),
+		held:       make(map[link]bool),
+	}
+	n.cond = sync.NewCond(&n.mu)
+	return n
+}
+
+// Transport returns a Raft transport of addr connected to the transports of
+// all the other addresses, which a store can use as its RaftTransport.
+func (n *Network) Transport(addr string) (*Transport, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if _, ok := n.transports[addr]; ok {
+		return nil, fmt.Errorf("address %s already in use", addr)
+	}
+	_, inmem := raft.NewInmemTransport(raft.ServerAddress(addr))
+	t := &Transport{InmemTransport: inmem, network: n, addr: addr}
+	for a, peer := range n.transports {
+		t.InmemTransport.Connect(raft.ServerAddress(a), peer.InmemTransport)
+		peer.InmemTransport.Connect(raft.ServerAddress(addr), t.InmemTransport)
+	}
+	n.transports[addr] = t
+	n.changed()
+	return t, nil
+}
+
+// remove disconnects the transport of addr from the others, and frees addr.
+func (n *Network) remove(t *Transport) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.transports[t.addr] != t {
+		return
+	}
+	delete(n.transports, t.addr)
+	for _, peer := range n.transports {
+		peer.InmemTransport.Disconnect(raft.ServerAddress(t.addr))
+	}
+	t.InmemTransport.DisconnectAll()
+	n.changed()
+}
+
+// Partition cuts the links between the addresses of a and those of b, in
+// both directions.
+func (n *Network) Partition(a, b []string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	for _, x := range a {
+		for _, y := range b {
+			n.cut[link{x, y}] = true
+			n.cut[link{y, x}] = true
+		}
+	}
+	n.changed()
+}
+
+// Isolate partitions addr from all the other connected addresses.
+func (n *Network) Isolate(addr string) {
+	n.mu.Lock()
+	var others []string
+	for a := range n.transports {
+		if a != addr {
+			others = append(others, a)
+		}
+	}
+	n.mu.Unlock()
+	n.Partition([]string{addr}, others)
+}
+
+// Heal restores all the links: partitions end, delays are removed and held
+// RPCs are delivered.
+func (n *Network) Heal() {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.cut = make(map[link]bool)
+	n.delay = make(map[link]time.Duration)
+	n.held = make(map[link]bool)
+	n.changed()
+}
+
+// Delay delays the RPCs and responses sent from one address to another by d.
+func (n *Network) Delay(from, to string, d time.Duration) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.delay[link{from, to}] = d
+}
+
+// Hold holds the RPCs and responses sent from one address to another until
+// Release is called.
+func (n *Network) Hold(from, to string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.held[link{from, to}] = true
+}
+
+// Release delivers the RPCs and responses held from one address to another,
+// and lets the next ones through.
+func (n *Network) Release(from, to string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	delete(n.held, link{from, to})
+	n.changed()
+}
+
+// changed wakes the deliveries and waits blocked on the network. n.mu must
+// be held.
+func (n *Network) changed() {
+	n.version++
+	n.cond.Broadcast()
+}
+
+// deliver blocks while the messages of l are held, then waits for their
+// delay. It fails if l is cut.
+func (n *Network) deliver(l link) error {
+	n.mu.Lock()
+	for n.held[l] && !n.cut[l] {
+		n.cond.Wait()
+	}
+	cut, d := n.cut[l], n.delay[l]
+	n.mu.Unlock()
+	if cut {
+		return ErrPartitioned
+	}
+	if d > 0 {
+		time.Sleep(d)
+	}
+	return nil
+}
+
+// delivered records that an RPC went through, waking the waits.
+func (n *Network) delivered() {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.changed()
+}
+
+// wait blocks until cond holds or timeout expires. cond is checked again
+// whenever the network delivers an RPC or changes, and at least every
+// interval, for the changes the nodes make on their own like electing
+// themselves.
+func (n *Network) wait(timeout, interval time.Duration, cond func() bool) error {
+	deadline := time.Now().Add(timeout)
+	for !cond() {
+		left := time.Until(deadline)
+		if left <= 0 {
+			return fmt.Errorf("timeout expired")
+		}
+		if interval > left {
+			interval = left
+		}
+		n.mu.Lock()
+		v := n.version
+		tmr := time.AfterFunc(interval, func() {
+			n.mu.Lock()
+			defer n.mu.Unlock()
+			n.changed()
+		})
+		for n.version == v {
+			n.cond.Wait()
+		}
+		n.mu.Unlock()
+		tmr.Stop()
+	}
+	return nil
+}
+
+// Transport is the Raft transport of an address of a Network. It sends every
+// RPC and its response through the links of the network. Pipelining is not
+// supported, so that each AppendEntries is gated on its own.
+type Transport struct {
+	*raft.InmemTransport
+	network *Network
+	addr    string
+	once    sync.Once
+}
+
+// send delivers an RPC to target through the network, and its response back.
+func (t *Transport) send(target raft.ServerAddress, rpc func() error) error {
+	if err := t.network.deliver(link{t.addr, string(target)}); err != nil {
+		return err
+	}
+	if err := rpc(); err != nil {
+		return err
+	}
+	if err := t.network.deliver(link{string(target), t.addr}); err != nil {
+		return err
+	}
+	t.network.delivered()
+	return nil
+}
+
+// AppendEntriesPipeline is not supported, Raft falls back to AppendEntries.
+func (t *Transport) AppendEntriesPipeline(id raft.ServerID, target raft.ServerAddress) (raft.AppendPipeline, error) {
+	return nil, raft.ErrPipelineReplicationNotSupported
+}
+
+// AppendEntries sends entries to target.
+func (t *Transport) AppendEntries(id raft.ServerID, target raft.ServerAddress, args *raft.AppendEntriesRequest, resp *raft.AppendEntriesResponse) error {
+	return t.send(target, func() error {
+		return t.InmemTransport.AppendEntries(id, target, args, resp)
+	})
+}
+
+// RequestVote asks target for its vote.
+func (t *Transport) RequestVote(id raft.ServerID, target raft.ServerAddress, args *raft.RequestVoteRequest, resp *raft.RequestVoteResponse) error {
+	return t.send(target, func() error {
+		return t.InmemTransport.RequestVote(id, target, args, resp)
+	})
+}
+
+// InstallSnapshot sends a snapshot to target.
+func (t *Transport) InstallSnapshot(id raft.ServerID, target raft.ServerAddress, args *raft.InstallSnapshotRequest, resp *raft.InstallSnapshotResponse, data io.Reader) error {
+	return t.send(target, func() error {
+		return t.InmemTransport.InstallSnapshot(id, target, args, resp, data)
+	})
+}
+
+// TimeoutNow asks target to start an election at once.
+func (t *Transport) TimeoutNow(id raft.ServerID, target raft.ServerAddress, args *raft.TimeoutNowRequest, resp *raft.TimeoutNowResponse) error {
+	return t.send(target, func() error {
+		return t.InmemTransport.TimeoutNow(id, target, args, resp)
+	})
+}
+
+// Close disconnects the transport from the network, and frees its address.
+func (t *Transport) Close() error {
+	t.once.Do(func() { t.network.remove(t) })
+	return nil
+}
@@ -0,0 +1,136 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package testkit
+
+import (
+	"testing"
+	"time"
+
+	"github.com/hashicorp/raft"
+)
+
+func mustTransport(t *testing.T, n *Network, addr string) *Transport {
+	tn, err := n.Transport(addr)
+	if err != nil {
+		t.Fatalf("failed to connect %s: %s", addr, err.Error())
+	}
+	// answer every RPC, like a node granting its vote
+	go func() {
+		for rpc := range tn.Consumer() {
+			rpc.Respond(&raft.RequestVoteResponse{Granted: true}, nil)
+		}
+	}()
+	return tn
+}
+
+// vote asks b for its vote from a.
+func vote(a, b *Transport) error {
+	var resp raft.RequestVoteResponse
+	return a.RequestVote(raft.ServerID(b.addr), raft.ServerAddress(b.addr), &raft.RequestVoteRequest{}, &resp)
+}
+
+func TestNetwork(t *testing.T) {
+	n := NewNetwork()
+	a, b := mustTransport(t, n, "a"), mustTransport(t, n, "b")
+	defer a.Close()
+	if err := vote(a, b); err != nil {
+		t.Fatalf("failed to reach b: %s", err.Error())
+	}
+	if _, err := n.Transport("b"); err == nil {
+		t.Fatalf("address connected twice")
+	}
+	if _, err := a.AppendEntriesPipeline("b", "b"); err != raft.ErrPipelineReplicationNotSupported {
+		t.Fatalf("expected pipelining unsupported, got %v", err)
+	}
+
+	n.Partition([]string{"a"}, []string{"b"})
+	if err := vote(a, b); err != ErrPartitioned {
+		t.Fatalf("expected partition, got %v", err)
+	}
+	if err := vote(b, a); err != ErrPartitioned {
+		t.Fatalf("expected symmetric partition, got %v", err)
+	}
+	n.Heal()
+	if err := vote(a, b); err != nil {
+		t.Fatalf("failed to reach b after healing: %s", err.Error())
+	}
+
+	// responses go through the link back
+	n.Delay("b", "a", 50*time.Millisecond)
+	start := time.Now()
+	if err := vote(a, b); err != nil {
+		t.Fatalf("failed to reach b with delay: %s", err.Error())
+	}
+	if time.Since(start) < 50*time.Millisecond {
+		t.Fatalf("response not delayed")
+	}
+	n.Heal()
+
+	n.Hold("a", "b")
+	done := make(chan error, 1)
+	go func() { done <- vote(a, b) }()
+	select {
+	case err := <-done:
+		t.Fatalf("held RPC delivered: %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	n.Release("a", "b")
+	if err := <-done; err != nil {
+		t.Fatalf("released RPC not delivered: %s", err.Error())
+	}
+
+	b.Close()
+	if err := vote(a, b); err == nil {
+		t.Fatalf("closed transport reached")
+	}
+	if _, err := n.Transport("b"); err != nil {
+		t.Fatalf("failed to connect b again: %s", err.Error())
+	}
+}
+
+func TestNetworkWait(t *testing.T) {
+	n := NewNetwork()
+	a, b := mustTransport(t, n, "a"), mustTransport(t, n, "b")
+	defer a.Close()
+	defer b.Close()
+
+	// the wait wakes on the delivery, long before its interval
+	voted := make(chan struct{})
+	go func() {
+		vote(a, b)
+		close(voted)
+	}()
+	start := time.Now()
+	err := n.wait(time.Second, time.Hour, func() bool {
+		select {
+		case <-voted:
+			return true
+		default:
+			return false
+		}
+	})
+	if err != nil {
+		t.Fatalf("failed to wait for the vote: %s", err.Error())
+	}
+	if time.Since(start) > 500*time.Millisecond {
+		t.Fatalf("wait did not wake on the delivery")
+	}
+	if err := n.wait(50*time.Millisecond, 10*time.Millisecond, func() bool { return false }); err == nil {
+		t.Fatalf("expected the wait to time out")
+	}
+}
@@ -0,0 +1,61 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package tcp
+
+import (
+	"crypto/tls"
+	"net/http"
+
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// ACME obtains and renews the certificates of the API from an ACME CA, such
+// as Let's Encrypt, caching them on disk. It answers TLS-ALPN-01 challenges
+// on the API listener, and HTTP-01 challenges through HTTPHandler.
+type ACME struct {
+	m *autocert.Manager
+}
+
+// NewACME returns an ACME for domains, registering email with the CA at the
+// directory URL, Let's Encrypt if empty. Certificates and the account key
+// are cached in dir.
+func NewACME(domains []string, email, directory, dir string) *ACME {
+	m := &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		Cache:      autocert.DirCache(dir),
+		HostPolicy: autocert.HostWhitelist(domains...),
+		Email:      email,
+	}
+	if directory != "" {
+		m.Client = &acme.Client{DirectoryURL: directory}
+	}
+	return &ACME{m: m}
+}
+
+// TLSConfig returns a server TLS config serving the certificates obtained
+// from the CA, and answering TLS-ALPN-01 challenges.
+func (a *ACME) TLSConfig() *tls.Config {
+	return a.m.TLSConfig()
+}
+
+// HTTPHandler answers HTTP-01 challenges, redirecting other requests to
+// HTTPS.
+func (a *ACME) HTTPHandler() http.Handler {
+	return a.m.HTTPHandler(nil)
+}
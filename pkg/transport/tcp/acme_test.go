@@ -0,0 +1,91 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package tcp
+
+import (
+	"crypto/tls"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"golang.org/x/crypto/acme"
+)
+
+func TestACME_TLSConfig(t *testing.T) {
+	a := NewACME([]string{"mesh.example.com"}, "ops@example.com", "", t.TempDir())
+	cfg := a.TLSConfig()
+	found := false
+	for _, proto := range cfg.NextProtos {
+		found = found || proto == acme.ALPNProto
+	}
+	if !found {
+		t.Fatalf("expected %s in the protocols, got %v", acme.ALPNProto, cfg.NextProtos)
+	}
+	// certificates are only requested for the domains given
+	if _, err := cfg.GetCertificate(&tls.ClientHelloInfo{ServerName: "other.example.com"}); err == nil {
+		t.Fatalf("expected no certificate for another domain")
+	}
+}
+
+func TestACME_HTTPHandler(t *testing.T) {
+	h := NewACME([]string{"mesh.example.com"}, "", "", t.TempDir()).HTTPHandler()
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "http://mesh.example.com/enforce", nil))
+	if loc := w.Header().Get("Location"); w.Code != http.StatusFound || loc != "https://mesh.example.com/enforce" {
+		t.Fatalf("expected a redirect to https, got %d %q", w.Code, loc)
+	}
+
+	// no challenge is pending for the token
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "http://mesh.example.com/.well-known/acme-challenge/token", nil))
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("expected an unknown challenge not found, got %d", w.Code)
+	}
+}
+
+func TestListener_ACMEChallenge(t *testing.T) {
+	api := selfSignedConfig(t, "api")
+	api.NextProtos = []string{"http/1.1", acme.ALPNProto}
+	base, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %s", err.Error())
+	}
+	defer base.Close()
+	l := NewListener(base, nil, api)
+	addr := base.Addr().String()
+
+	ln := l.API(l)
+	go func() {
+		// TLS-ALPN-01 challenge connections are not served by the API.
+		if c, err := tls.Dial("tcp", addr, &tls.Config{InsecureSkipVerify: true, NextProtos: []string{acme.ALPNProto}}); err == nil {
+			_, _ = c.Write([]byte{'G'})
+		}
+		if c, err := tls.Dial("tcp", addr, &tls.Config{InsecureSkipVerify: true, NextProtos: []string{"http/1.1"}}); err == nil {
+			_, _ = c.Write([]byte{'G'})
+		}
+	}()
+	c, err := ln.Accept()
+	if err != nil {
+		t.Fatalf("failed to accept: %s", err.Error())
+	}
+	if state := ConnectionState(c); state == nil || state.NegotiatedProtocol != "http/1.1" {
+		t.Fatalf("api listener accepted an ACME challenge connection")
+	}
+}
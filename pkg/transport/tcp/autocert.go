@@ -0,0 +1,171 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package tcp
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	caValidity   = 10 * 365 * 24 * time.Hour
+	certValidity = 365 * 24 * time.Hour
+	// certRenewal is how long before it expires the node certificate is
+	// replaced at startup.
+	certRenewal = 30 * 24 * time.Hour
+)
+
+// AutoCerts are the files of a CA and of a node certificate it signed,
+// generated by EnsureAutoCerts.
+type AutoCerts struct {
+	CAFile   string
+	CertFile string
+	KeyFile  string
+}
+
+// EnsureAutoCerts returns the CA and node certificate in dir, generating
+// them if needed. The CA is kept once generated, so that it can be copied to
+// the other nodes of the cluster before they first start. The node
+// certificate is generated again if it is about to expire, was not signed by
+// the CA or does not cover all of hosts.
+func EnsureAutoCerts(dir string, hosts []string) (*AutoCerts, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	a := &AutoCerts{
+		CAFile:   filepath.Join(dir, "ca.pem"),
+		CertFile: filepath.Join(dir, "node.pem"),
+		KeyFile:  filepath.Join(dir, "node-key.pem"),
+	}
+	caKeyFile := filepath.Join(dir, "ca-key.pem")
+
+	ca, err := tls.LoadX509KeyPair(a.CAFile, caKeyFile)
+	if os.IsNotExist(err) {
+		ca, err = generateCert(nil, "casbin-mesh CA", nil, caValidity)
+		if err == nil {
+			err = writeCert(ca, a.CAFile, caKeyFile)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	if ca.Leaf, err = x509.ParseCertificate(ca.Certificate[0]); err != nil {
+		return nil, err
+	}
+
+	if cert, err := tls.LoadX509KeyPair(a.CertFile, a.KeyFile); err == nil && validCert(cert, ca.Leaf, hosts) {
+		return a, nil
+	}
+	cert, err := generateCert(&ca, "casbin-mesh node", hosts, certValidity)
+	if err != nil {
+		return nil, err
+	}
+	if err := writeCert(cert, a.CertFile, a.KeyFile); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// validCert reports whether cert was signed by ca, covers hosts and does not
+// need to be renewed yet.
+func validCert(cert tls.Certificate, ca *x509.Certificate, hosts []string) bool {
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil || time.Now().Add(certRenewal).After(leaf.NotAfter) {
+		return false
+	}
+	if leaf.CheckSignatureFrom(ca) != nil {
+		return false
+	}
+	for _, h := range hosts {
+		if leaf.VerifyHostname(h) != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// generateCert returns a certificate for hosts signed by parent, or a
+// self-signed CA if parent is nil.
+func generateCert(parent *tls.Certificate, cn string, hosts []string, validity time.Duration) (tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(validity),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+	}
+	for _, h := range hosts {
+		if ip := net.ParseIP(h); ip != nil {
+			tmpl.IPAddresses = append(tmpl.IPAddresses, ip)
+		} else {
+			tmpl.DNSNames = append(tmpl.DNSNames, h)
+		}
+	}
+	signer, signerKey := tmpl, interface{}(key)
+	if parent == nil {
+		tmpl.IsCA = true
+		tmpl.BasicConstraintsValid = true
+		tmpl.KeyUsage |= x509.KeyUsageCertSign
+	} else {
+		tmpl.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth}
+		signer, signerKey = parent.Leaf, parent.PrivateKey
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	cert := tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+	cert.Leaf, err = x509.ParseCertificate(der)
+	return cert, err
+}
+
+// writeCert writes the certificate and the private key of cert as PEM.
+func writeCert(cert tls.Certificate, certFile, keyFile string) error {
+	key, ok := cert.PrivateKey.(*ecdsa.PrivateKey)
+	if !ok {
+		return errors.New("unsupported private key")
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Certificate[0]}), 0644)
+}
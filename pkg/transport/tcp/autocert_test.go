@@ -0,0 +1,78 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package tcp
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"testing"
+)
+
+func loadCert(t *testing.T, file string) *x509.Certificate {
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		t.Fatalf("failed to read %s: %s", file, err.Error())
+	}
+	block, _ := pem.Decode(b)
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		t.Fatalf("failed to parse %s: %s", file, err.Error())
+	}
+	return cert
+}
+
+func TestEnsureAutoCerts(t *testing.T) {
+	dir := t.TempDir()
+	a, err := EnsureAutoCerts(dir, []string{"localhost", "127.0.0.1"})
+	if err != nil {
+		t.Fatalf("failed to generate certificates: %s", err.Error())
+	}
+	ca, node := loadCert(t, a.CAFile), loadCert(t, a.CertFile)
+	pool := x509.NewCertPool()
+	pool.AddCert(ca)
+	for _, host := range []string{"localhost", "127.0.0.1"} {
+		if _, err := node.Verify(x509.VerifyOptions{DNSName: host, Roots: pool, KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageAny}}); err != nil {
+			t.Fatalf("node certificate not valid for %s: %s", host, err.Error())
+		}
+	}
+
+	// Certificates are kept across restarts.
+	if _, err := EnsureAutoCerts(dir, []string{"localhost"}); err != nil {
+		t.Fatalf("failed to load certificates: %s", err.Error())
+	}
+	if !bytes.Equal(loadCert(t, a.CertFile).Raw, node.Raw) {
+		t.Fatalf("node certificate generated again")
+	}
+
+	// A new address gets a new node certificate from the same CA.
+	if _, err := EnsureAutoCerts(dir, []string{"localhost", "10.0.0.1"}); err != nil {
+		t.Fatalf("failed to generate certificates: %s", err.Error())
+	}
+	renewed := loadCert(t, a.CertFile)
+	if bytes.Equal(renewed.Raw, node.Raw) {
+		t.Fatalf("node certificate not generated for a new address")
+	}
+	if !bytes.Equal(loadCert(t, a.CAFile).Raw, ca.Raw) {
+		t.Fatalf("CA generated again")
+	}
+	if err := renewed.CheckSignatureFrom(ca); err != nil {
+		t.Fatalf("node certificate not signed by the CA: %s", err.Error())
+	}
+}
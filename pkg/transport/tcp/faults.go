@@ -0,0 +1,238 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package tcp
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrFaultDropped is returned by connections a fault dropped.
+	ErrFaultDropped = errors.New("connection dropped by fault injection")
+
+	// ErrFaultPartitioned is returned when dialing or using a connection to
+	// a peer partitioned by fault injection.
+	ErrFaultPartitioned = errors.New("peer partitioned by fault injection")
+)
+
+// Faults injects network failures into the connections of a Transport, so
+// that the cluster can be tested under them. Dropping fails a fraction of
+// the dials and writes, which the peers see as broken connections. Latency
+// delays every write. Partitioning a peer fails the connections to it, and
+// those accepted from its host.
+type Faults struct {
+	mu          sync.RWMutex
+	drop        float64
+	latency     time.Duration
+	partitioned map[string]bool
+	rand        *rand.Rand
+}
+
+// NewFaults returns Faults injecting no failure.
+func NewFaults() *Faults {
+	return &Faults{
+		partitioned: make(map[string]bool),
+		rand:        rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+}
+
+// ParseFaults returns the Faults of spec, a comma-separated list of
+// drop=<fraction>, latency=<duration> and partition=<peer>[|<peer>...],
+// e.g. drop=0.05,latency=20ms,partition=node2:4002.
+func ParseFaults(spec string) (*Faults, error) {
+	f := NewFaults()
+	return f, f.Set(spec)
+}
+
+// Set replaces the faults by those of spec, see ParseFaults.
+func (f *Faults) Set(spec string) error {
+	var drop float64
+	var latency time.Duration
+	partitioned := make(map[string]bool)
+	for _, kv := range strings.Split(spec, ",") {
+		kv = strings.TrimSpace(kv)
+		if kv == "" {
+			continue
+		}
+		i := strings.Index(kv, "=")
+		if i < 0 {
+			return fmt.Errorf("invalid fault %q, must be key=value", kv)
+		}
+		k, v := kv[:i], kv[i+1:]
+		var err error
+		switch k {
+		case "drop":
+			drop, err = strconv.ParseFloat(v, 64)
+			if err == nil && (drop < 0 || drop > 1) {
+				err = errors.New("must be between 0 and 1")
+			}
+		case "latency":
+			latency, err = time.ParseDuration(v)
+		case "partition":
+			for _, p := range strings.Split(v, "|") {
+				if p != "" {
+					partitioned[p] = true
+				}
+			}
+		default:
+			err = errors.New("unknown fault")
+		}
+		if err != nil {
+			return fmt.Errorf("invalid fault %q: %s", kv, err)
+		}
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.drop, f.latency, f.partitioned = drop, latency, partitioned
+	return nil
+}
+
+// SetDrop sets the fraction of the dials and writes failed.
+func (f *Faults) SetDrop(fraction float64) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.drop = fraction
+}
+
+// SetLatency sets the delay added to every write.
+func (f *Faults) SetLatency(d time.Duration) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.latency = d
+}
+
+// Partition cuts the node off from peers, given by their Raft addresses.
+func (f *Faults) Partition(peers ...string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, p := range peers {
+		f.partitioned[p] = true
+	}
+}
+
+// Heal ends all partitions.
+func (f *Faults) Heal() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.partitioned = make(map[string]bool)
+}
+
+// String returns the faults in the format of ParseFaults.
+func (f *Faults) String() string {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	var parts []string
+	if f.drop > 0 {
+		parts = append(parts, "drop="+strconv.FormatFloat(f.drop, 'f', -1, 64))
+	}
+	if f.latency > 0 {
+		parts = append(parts, "latency="+f.latency.String())
+	}
+	if len(f.partitioned) > 0 {
+		peers := make([]string, 0, len(f.partitioned))
+		for p := range f.partitioned {
+			peers = append(peers, p)
+		}
+		sort.Strings(peers)
+		parts = append(parts, "partition="+strings.Join(peers, "|"))
+	}
+	return strings.Join(parts, ",")
+}
+
+// dropped rolls whether the next dial or write fails.
+func (f *Faults) dropped() bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.drop > 0 && f.rand.Float64() < f.drop
+}
+
+// cut reports whether the connection to peer, or accepted from the host of
+// peer, is partitioned.
+func (f *Faults) cut(peer string, accepted bool) bool {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	if !accepted {
+		return f.partitioned[peer]
+	}
+	host, _, err := net.SplitHostPort(peer)
+	if err != nil {
+		return false
+	}
+	for p := range f.partitioned {
+		if h, _, err := net.SplitHostPort(p); err == nil && h == host {
+			return true
+		}
+	}
+	return false
+}
+
+func (f *Faults) delay() time.Duration {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return f.latency
+}
+
+// dial returns the error injected into a dial to peer, if any.
+func (f *Faults) dial(peer string) error {
+	if f.cut(peer, false) {
+		return ErrFaultPartitioned
+	}
+	if f.dropped() {
+		return ErrFaultDropped
+	}
+	return nil
+}
+
+// faultConn is a connection Faults are injected into.
+type faultConn struct {
+	net.Conn
+	faults   *Faults
+	peer     string
+	accepted bool
+}
+
+func (c *faultConn) Read(b []byte) (int, error) {
+	if c.faults.cut(c.peer, c.accepted) {
+		c.Conn.Close()
+		return 0, ErrFaultPartitioned
+	}
+	return c.Conn.Read(b)
+}
+
+func (c *faultConn) Write(b []byte) (int, error) {
+	if d := c.faults.delay(); d > 0 {
+		time.Sleep(d)
+	}
+	if c.faults.cut(c.peer, c.accepted) {
+		c.Conn.Close()
+		return 0, ErrFaultPartitioned
+	}
+	if c.faults.dropped() {
+		c.Conn.Close()
+		return 0, ErrFaultDropped
+	}
+	return c.Conn.Write(b)
+}
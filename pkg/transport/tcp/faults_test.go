@@ -0,0 +1,112 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package tcp
+
+import (
+	"io"
+	"testing"
+	"time"
+)
+
+func TestParseFaults(t *testing.T) {
+	f, err := ParseFaults("drop=0.25, latency=20ms,partition=node2:4002|node1:4002")
+	if err != nil {
+		t.Fatalf("failed to parse faults: %s", err.Error())
+	}
+	if exp, got := "drop=0.25,latency=20ms,partition=node1:4002|node2:4002", f.String(); exp != got {
+		t.Fatalf("wrong faults, exp %s, got %s", exp, got)
+	}
+	if err := f.Set(""); err != nil || f.String() != "" {
+		t.Fatalf("faults not cleared: %v, %s", err, f.String())
+	}
+	for _, spec := range []string{"drop=2", "latency=soon", "jitter=1ms", "partition"} {
+		if _, err := ParseFaults(spec); err == nil {
+			t.Fatalf("invalid faults %q accepted", spec)
+		}
+	}
+}
+
+func TestTransportFaults(t *testing.T) {
+	server := NewTransport()
+	if err := server.Open("localhost:0"); err != nil {
+		t.Fatalf("failed to open transport: %s", err.Error())
+	}
+	defer server.Close()
+	addr := server.ln.Addr().String()
+	go func() {
+		for {
+			c, err := server.Accept()
+			if err != nil {
+				return
+			}
+			go io.Copy(c, c)
+		}
+	}()
+
+	faults := NewFaults()
+	client := NewTransport()
+	client.SetFaults(faults)
+	echo := func() error {
+		c, err := client.Dial(addr, time.Second)
+		if err != nil {
+			return err
+		}
+		defer c.Close()
+		if _, err := c.Write([]byte{1}); err != nil {
+			return err
+		}
+		_, err = c.Read(make([]byte, 1))
+		return err
+	}
+	if err := echo(); err != nil {
+		t.Fatalf("failed without faults: %s", err.Error())
+	}
+
+	faults.SetLatency(50 * time.Millisecond)
+	start := time.Now()
+	if err := echo(); err != nil {
+		t.Fatalf("failed with latency: %s", err.Error())
+	}
+	if time.Since(start) < 50*time.Millisecond {
+		t.Fatalf("latency not injected")
+	}
+	faults.SetLatency(0)
+
+	faults.SetDrop(1)
+	if err := echo(); err != ErrFaultDropped {
+		t.Fatalf("expected dropped connection, got %v", err)
+	}
+	faults.SetDrop(0)
+
+	c, err := client.Dial(addr, time.Second)
+	if err != nil {
+		t.Fatalf("failed to dial: %s", err.Error())
+	}
+	defer c.Close()
+	faults.Partition(addr)
+	if err := echo(); err != ErrFaultPartitioned {
+		t.Fatalf("expected partitioned peer, got %v", err)
+	}
+	if _, err := c.Write([]byte{1}); err != ErrFaultPartitioned {
+		t.Fatalf("expected open connection to be cut, got %v", err)
+	}
+	faults.Heal()
+	if err := echo(); err != nil {
+		t.Fatalf("failed after healing: %s", err.Error())
+	}
+}
@@ -0,0 +1,279 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package tcp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"sync"
+	"syscall"
+)
+
+const (
+	// frameMagic starts every Raft connection, followed by frameVersion. Its
+	// first byte is neither a TLS record nor the start of an HTTP request,
+	// which lets the multiplexer tell Raft connections apart.
+	frameMagic = "\xcaCM"
+
+	// frameVersion is the version of the framing spoken by this node.
+	frameVersion = 1
+
+	// frameHeaderSize is the size of the length prefixing every frame.
+	frameHeaderSize = 4
+
+	// DefaultMaxMessageSize is the default size above which the Raft
+	// messages, and the frames, sent by peers are refused.
+	DefaultMaxMessageSize = 8 << 20
+
+	// MinMaxMessageSize is the smallest max message size that may be set.
+	MinMaxMessageSize = 64 << 10
+)
+
+var (
+	// ErrBadMagic is returned when a peer does not start its connection
+	// with the Raft frame magic.
+	ErrBadMagic = errors.New("connection does not start with the raft frame magic")
+
+	// ErrFrameTooLarge is returned when a peer announces a frame larger than
+	// the max message size.
+	ErrFrameTooLarge = errors.New("frame exceeds the max message size")
+)
+
+// preamble is sent first by the node opening a connection.
+var preamble = append([]byte(frameMagic), frameVersion)
+
+// framedConn splits the stream of a Raft connection into frames prefixed by
+// their length, so that frames larger than max are refused before anything
+// is read from them, and refuses the Raft messages larger than max however
+// they are split. Before the first frame, the node dialing the connection
+// sends the preamble and both nodes exchange their Hello, see handshake.
+//
+// While unframed returns true, connections of nodes older than the framing
+// are accepted, and connections to them opened again unframed, so that a
+// cluster may be upgraded one node at a time. Their messages are capped all
+// the same.
+type framedConn struct {
+	net.Conn
+	r        io.Reader
+	max      int
+	local    Hello
+	required []string
+	accepted bool
+	unframed func() bool
+	// redial opens the connection again, to fall back to an unframed one.
+	redial func() (net.Conn, error)
+	legacy bool
+	msgs   *msgScanner
+
+	hsMu   sync.Mutex
+	hsDone bool
+	hsErr  error
+	peer   Hello
+	// sendMax is the size frames are sent at most, the smaller of the max
+	// message sizes of both nodes.
+	sendMax int
+
+	// Owned by the reader.
+	remaining int
+	rerr      error
+
+	wmu sync.Mutex
+}
+
+func newFramedConn(c net.Conn, max int, id Identity, accepted bool, unframed func() bool) *framedConn {
+	if max <= 0 {
+		max = DefaultMaxMessageSize
+	}
+	return &framedConn{Conn: c, r: c, max: max, local: id.hello(max), required: id.Required, accepted: accepted,
+		unframed: unframed, msgs: newMsgScanner(max, accepted)}
+}
+
+// allowsUnframed reports whether unframed connections are accepted.
+func (c *framedConn) allowsUnframed() bool {
+	return c.unframed != nil && c.unframed()
+}
+
+// readPreamble reads and checks the preamble sent by the peer. A peer sending
+// a Raft RPC right away is an older node, whose connection goes on unframed
+// if allowed.
+func (c *framedConn) readPreamble() error {
+	b := make([]byte, len(preamble))
+	if _, err := io.ReadFull(c.Conn, b[:1]); err != nil {
+		return err
+	}
+	if b[0] != frameMagic[0] {
+		if !c.allowsUnframed() {
+			return ErrBadMagic
+		}
+		c.legacy = true
+		c.r = io.MultiReader(bytes.NewReader(b[:1]), c.Conn)
+		return nil
+	}
+	if _, err := io.ReadFull(c.Conn, b[1:]); err != nil {
+		return err
+	}
+	if string(b[:len(frameMagic)]) != frameMagic {
+		return ErrBadMagic
+	}
+	if v := b[len(frameMagic)]; v != frameVersion {
+		return fmt.Errorf("unsupported raft frame version %d, expected %d", v, frameVersion)
+	}
+	return nil
+}
+
+// readHeader reads the header of the next frame, and returns its size.
+func (c *framedConn) readHeader(max int) (int, error) {
+	var hdr [frameHeaderSize]byte
+	if _, err := io.ReadFull(c.r, hdr[:]); err != nil {
+		return 0, err
+	}
+	n := binary.BigEndian.Uint32(hdr[:])
+	if uint64(n) > uint64(max) {
+		return 0, fmt.Errorf("%w: %d bytes, max %d", ErrFrameTooLarge, n, max)
+	}
+	return int(n), nil
+}
+
+// writeFrames writes b in frames of at most max bytes, after prefix.
+func (c *framedConn) writeFrames(prefix, b []byte, max int) (int, error) {
+	written := 0
+	for len(b) > 0 || prefix != nil {
+		chunk := b
+		if len(chunk) > max {
+			chunk = chunk[:max]
+		}
+		var bufs net.Buffers
+		if prefix != nil {
+			bufs = append(bufs, prefix)
+			prefix = nil
+		}
+		hdr := make([]byte, frameHeaderSize)
+		binary.BigEndian.PutUint32(hdr, uint32(len(chunk)))
+		bufs = append(bufs, hdr, chunk)
+		if _, err := bufs.WriteTo(c.Conn); err != nil {
+			return written, err
+		}
+		written += len(chunk)
+		b = b[len(chunk):]
+	}
+	return written, nil
+}
+
+// handshake runs the handshake once, closing the connection if it fails.
+func (c *framedConn) handshake() error {
+	c.hsMu.Lock()
+	defer c.hsMu.Unlock()
+	if c.hsDone {
+		return c.hsErr
+	}
+	c.hsDone = true
+	if c.hsErr = c.exchange(); c.hsErr != nil {
+		if c.hsErr != io.EOF {
+			log.Printf("closing raft connection with %s: %s", c.RemoteAddr(), c.hsErr.Error())
+		}
+		_ = c.Conn.Close()
+	}
+	return c.hsErr
+}
+
+func (c *framedConn) Read(b []byte) (int, error) {
+	if err := c.handshake(); err != nil {
+		return 0, err
+	}
+	if c.rerr != nil {
+		return 0, c.rerr
+	}
+	if c.legacy {
+		n, err := c.r.Read(b)
+		return c.scan(b[:n], err)
+	}
+	for c.remaining == 0 {
+		n, err := c.readHeader(c.max)
+		if err != nil {
+			if errors.Is(err, ErrFrameTooLarge) {
+				c.refuse(err)
+			}
+			return 0, err
+		}
+		c.remaining = n
+	}
+	if len(b) > c.remaining {
+		b = b[:c.remaining]
+	}
+	n, err := c.r.Read(b)
+	c.remaining -= n
+	return c.scan(b[:n], err)
+}
+
+// scan follows the bytes b just read, and refuses them if they make a
+// message too large.
+func (c *framedConn) scan(b []byte, err error) (int, error) {
+	if serr := c.msgs.scan(b); serr != nil {
+		c.refuse(serr)
+		return 0, serr
+	}
+	return len(b), err
+}
+
+// refuse closes the connection, failing the reads from now on with err.
+func (c *framedConn) refuse(err error) {
+	log.Printf("closing raft connection with %s: %s", c.RemoteAddr(), err.Error())
+	c.rerr = err
+	_ = c.Conn.Close()
+}
+
+// Write sends b in frames no larger than the max message sizes of both
+// nodes.
+func (c *framedConn) Write(b []byte) (int, error) {
+	if err := c.handshake(); err != nil {
+		return 0, err
+	}
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	if c.legacy {
+		return c.Conn.Write(b)
+	}
+	if len(b) == 0 {
+		return 0, nil
+	}
+	return c.writeFrames(nil, b, c.sendMax)
+}
+
+// fallback opens the connection again unframed, after the peer closed it
+// without answering the handshake, as nodes older than the framing do.
+func (c *framedConn) fallback() error {
+	nc, err := c.redial()
+	if err != nil {
+		return err
+	}
+	log.Printf("raft peer %s does not frame its connections, falling back to an unframed connection", c.RemoteAddr())
+	_ = c.Conn.Close()
+	c.Conn, c.r, c.legacy = nc, nc, true
+	return nil
+}
+
+// closedEarly reports whether err tells that the peer closed the connection
+// before sending anything.
+func closedEarly(err error) bool {
+	return err == io.EOF || errors.Is(err, syscall.ECONNRESET)
+}
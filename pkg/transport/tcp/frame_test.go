@@ -0,0 +1,269 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package tcp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// openEcho opens a transport echoing back what its connections read, and
+// sending their read errors to errs.
+func openEcho(t *testing.T, errs chan<- error) *Transport {
+	server := NewTransport()
+	if err := server.SetMaxMessageSize(MinMaxMessageSize); err != nil {
+		t.Fatalf("failed to set max message size: %s", err.Error())
+	}
+	if err := server.Open("localhost:0"); err != nil {
+		t.Fatalf("failed to open transport: %s", err.Error())
+	}
+	go func() {
+		for {
+			c, err := server.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				_, err := io.Copy(c, c)
+				errs <- err
+			}()
+		}
+	}()
+	return server
+}
+
+func TestTransportFraming(t *testing.T) {
+	errs := make(chan error, 4)
+	server := openEcho(t, errs)
+	defer server.Close()
+	addr := server.ln.Addr().String()
+
+	client := NewTransport()
+	if err := client.SetMaxMessageSize(MinMaxMessageSize); err != nil {
+		t.Fatalf("failed to set max message size: %s", err.Error())
+	}
+	c, err := client.Dial(addr, time.Second)
+	if err != nil {
+		t.Fatalf("failed to dial: %s", err.Error())
+	}
+	defer c.Close()
+	// Larger than a frame, the message is split.
+	msg := bytes.Repeat([]byte("casbin"), MinMaxMessageSize/2)
+	go func() { _, _ = c.Write(msg) }()
+	got := make([]byte, len(msg))
+	if _, err := io.ReadFull(c, got); err != nil {
+		t.Fatalf("failed to read echo: %s", err.Error())
+	}
+	if !bytes.Equal(got, msg) {
+		t.Fatalf("echo does not match the message")
+	}
+
+	if err := client.SetMaxMessageSize(1024); err == nil {
+		t.Fatalf("max message size below the minimum accepted")
+	}
+}
+
+func TestTransportFraming_Refused(t *testing.T) {
+	errs := make(chan error, 4)
+	server := openEcho(t, errs)
+	defer server.Close()
+	addr := server.ln.Addr().String()
+
+	send := func(b []byte) error {
+		c, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatalf("failed to dial: %s", err.Error())
+		}
+		defer c.Close()
+		if _, err := c.Write(b); err != nil {
+			t.Fatalf("failed to write: %s", err.Error())
+		}
+		select {
+		case err := <-errs:
+			return err
+		case <-time.After(5 * time.Second):
+			t.Fatalf("connection not closed")
+		}
+		return nil
+	}
+
+	// Raft RPCs not framed, as sent by older nodes.
+	if err := send([]byte{1, 2, 3, 4, 5}); !errors.Is(err, ErrBadMagic) {
+		t.Fatalf("expected bad magic, got %v", err)
+	}
+	if err := send([]byte(frameMagic + "\x09")); err == nil {
+		t.Fatalf("unsupported version accepted")
+	}
+	hdr := make([]byte, frameHeaderSize)
+	binary.BigEndian.PutUint32(hdr, 1<<31)
+	if err := send(append(append([]byte{}, preamble...), hdr...)); !errors.Is(err, ErrFrameTooLarge) {
+		t.Fatalf("expected frame too large, got %v", err)
+	}
+}
+
+func TestTransportFraming_MessageTooLarge(t *testing.T) {
+	errs := make(chan error, 4)
+	server := openEcho(t, errs)
+	defer server.Close()
+
+	c, err := NewTransport().Dial(server.ln.Addr().String(), time.Second)
+	if err != nil {
+		t.Fatalf("failed to dial: %s", err.Error())
+	}
+	defer c.Close()
+	// An AppendEntries request with a binary of the max message size, sent
+	// in frames smaller than it.
+	hdr := []byte{0, 0x81, 0xa4, 'D', 'a', 't', 'a', 0xc6, 0, 0, 0, 0}
+	binary.BigEndian.PutUint32(hdr[8:], MinMaxMessageSize)
+	if _, err := c.Write(hdr); err != nil {
+		t.Fatalf("failed to write: %s", err.Error())
+	}
+	select {
+	case err := <-errs:
+		if !errors.Is(err, ErrMessageTooLarge) {
+			t.Fatalf("expected message too large, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("connection not closed")
+	}
+}
+
+// openLegacy opens a listener echoing what the connections starting with a
+// Raft RPC type read, and closing the others, like nodes older than the
+// framing.
+func openLegacy(t *testing.T) net.Listener {
+	ln, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %s", err.Error())
+	}
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer c.Close()
+				b := make([]byte, 1)
+				if _, err := io.ReadFull(c, b); err != nil || b[0] > 3 {
+					return
+				}
+				if _, err := c.Write(b); err != nil {
+					return
+				}
+				_, _ = io.Copy(c, c)
+			}()
+		}
+	}()
+	return ln
+}
+
+func TestTransportUnframed(t *testing.T) {
+	errs := make(chan error, 4)
+	server := openEcho(t, errs)
+	defer server.Close()
+	var allowed int32 = 1
+	server.SetUnframed(func() bool { return atomic.LoadInt32(&allowed) == 1 })
+	addr := server.ln.Addr().String()
+
+	// An older node sends its RPCs as they are.
+	msg := []byte{1, 0x80}
+	legacy := func() error {
+		c, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatalf("failed to dial: %s", err.Error())
+		}
+		defer c.Close()
+		if _, err := c.Write(msg); err != nil {
+			return err
+		}
+		got := make([]byte, len(msg))
+		if _, err := io.ReadFull(c, got); err != nil {
+			return err
+		}
+		if !bytes.Equal(got, msg) {
+			t.Fatalf("echo does not match the message")
+		}
+		return nil
+	}
+	if err := legacy(); err != nil {
+		t.Fatalf("unframed connection refused: %s", err.Error())
+	}
+	// Once every member frames its connections, they are refused.
+	atomic.StoreInt32(&allowed, 0)
+	if err := legacy(); err == nil {
+		t.Fatalf("unframed connection accepted")
+	}
+	err := <-errs
+	for err == nil {
+		err = <-errs
+	}
+	if !errors.Is(err, ErrBadMagic) {
+		t.Fatalf("expected bad magic, got %v", err)
+	}
+
+	// Upgraded nodes open their connections to older ones again unframed.
+	old := openLegacy(t)
+	defer old.Close()
+	dial := func(unframed bool) (net.Conn, error) {
+		client := NewTransport()
+		client.SetUnframed(func() bool { return unframed })
+		c, err := client.Dial(old.Addr().String(), time.Second)
+		if err != nil {
+			t.Fatalf("failed to dial: %s", err.Error())
+		}
+		if _, err := c.Write(msg); err != nil {
+			c.Close()
+			return nil, err
+		}
+		return c, nil
+	}
+	c, err := dial(true)
+	if err != nil {
+		t.Fatalf("failed to fall back to an unframed connection: %s", err.Error())
+	}
+	defer c.Close()
+	got := make([]byte, len(msg))
+	if _, err := io.ReadFull(c, got); err != nil || !bytes.Equal(got, msg) {
+		t.Fatalf("echo does not match the message: %v", err)
+	}
+	if _, err := dial(false); err == nil {
+		t.Fatalf("fell back to an unframed connection while not allowed")
+	}
+}
+
+func TestRaftRPCMatcher(t *testing.T) {
+	match := RaftRPCMatcher()
+	// the unframed RPCs of older nodes start with their type
+	for _, b := range []string{string(preamble), "\x00", "\x01"} {
+		if !match(bytes.NewReader([]byte(b))) {
+			t.Fatalf("%q not matched as raft", b)
+		}
+	}
+	for _, b := range []string{"GET / HTTP/1.1", "PRI * HTTP/2.0", "\x16\x03\x01", "\x05"} {
+		if match(bytes.NewReader([]byte(b))) {
+			t.Fatalf("%q matched as raft", b)
+		}
+	}
+}
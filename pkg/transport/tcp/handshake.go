@@ -0,0 +1,201 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package tcp
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+)
+
+const (
+	// ProtocolVersion is the version of the protocol spoken by this node
+	// over Raft connections.
+	ProtocolVersion = 1
+
+	// MinProtocolVersion is the oldest protocol version of the peers this
+	// node accepts connections with.
+	MinProtocolVersion = 1
+
+	// maxHelloSize bounds the size of the Hello of peers.
+	maxHelloSize = 64 << 10
+)
+
+// ErrHandshake is returned when the handshake with a peer fails, because
+// either node refuses the other.
+var ErrHandshake = errors.New("raft handshake failed")
+
+// Identity is what this node tells its peers about itself when it connects
+// to them, and requires from them.
+type Identity struct {
+	NodeID string
+	// ClusterID returns the ID of the cluster of the node, empty while
+	// unknown. Nodes of different clusters refuse each other.
+	ClusterID func() string
+	// Features are the optional features supported by the node, and
+	// Required those it refuses peers without.
+	Features []string
+	Required []string
+}
+
+// hello returns the Hello of the node refusing frames over max.
+func (id Identity) hello(max int) Hello {
+	h := Hello{NodeID: id.NodeID, Protocol: ProtocolVersion, MinProtocol: MinProtocolVersion,
+		MaxMessageSize: max, Features: id.Features}
+	if id.ClusterID != nil {
+		h.ClusterID = id.ClusterID()
+	}
+	return h
+}
+
+// Hello is the first frame nodes exchange over Raft connections, the node
+// dialing the connection sending its own first. A node refusing the other
+// answers with Error set, and closes the connection.
+type Hello struct {
+	NodeID         string   `json:"node_id"`
+	ClusterID      string   `json:"cluster_id,omitempty"`
+	Protocol       int      `json:"protocol"`
+	MinProtocol    int      `json:"min_protocol"`
+	MaxMessageSize int      `json:"max_message_size"`
+	Features       []string `json:"features,omitempty"`
+	Error          string   `json:"error,omitempty"`
+}
+
+// check returns why h may not connect to a node saying local and requiring
+// the features of required, nil if it may.
+func (h Hello) check(local Hello, required []string) error {
+	if h.Protocol < local.MinProtocol {
+		return fmt.Errorf("node %s speaks protocol %d, older than the oldest supported %d", h.NodeID, h.Protocol, local.MinProtocol)
+	}
+	if h.MinProtocol > local.Protocol {
+		return fmt.Errorf("node %s needs protocol %d, newer than the supported %d", h.NodeID, h.MinProtocol, local.Protocol)
+	}
+	if h.ClusterID != "" && local.ClusterID != "" && h.ClusterID != local.ClusterID {
+		return fmt.Errorf("node %s is a member of cluster %s, not %s", h.NodeID, h.ClusterID, local.ClusterID)
+	}
+	if h.MaxMessageSize < MinMaxMessageSize {
+		return fmt.Errorf("node %s has a max message size of %d, below %d", h.NodeID, h.MaxMessageSize, MinMaxMessageSize)
+	}
+	var missing []string
+	for _, f := range required {
+		if !h.supports(f) {
+			missing = append(missing, f)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("node %s does not support %s", h.NodeID, strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+func (h Hello) supports(feature string) bool {
+	for _, f := range h.Features {
+		if f == feature {
+			return true
+		}
+	}
+	return false
+}
+
+// readHello reads the Hello of the peer.
+func (c *framedConn) readHello() (Hello, error) {
+	var h Hello
+	n, err := c.readHeader(maxHelloSize)
+	if err != nil {
+		return h, err
+	}
+	b := make([]byte, n)
+	if _, err := io.ReadFull(c.r, b); err != nil {
+		return h, err
+	}
+	if err := json.Unmarshal(b, &h); err != nil {
+		return h, fmt.Errorf("invalid hello: %s", err.Error())
+	}
+	return h, nil
+}
+
+// writeHello sends h after prefix.
+func (c *framedConn) writeHello(prefix []byte, h Hello) error {
+	b, err := json.Marshal(h)
+	if err != nil {
+		return err
+	}
+	_, err = c.writeFrames(prefix, b, maxHelloSize)
+	return err
+}
+
+// exchange runs the handshake, which tells the peers apart and checks that
+// they may talk to each other.
+func (c *framedConn) exchange() error {
+	if !c.accepted {
+		if err := c.writeHello(preamble, c.local); err != nil {
+			return err
+		}
+	} else if err := c.readPreamble(); err != nil {
+		return err
+	}
+	if c.legacy {
+		return nil
+	}
+	peer, err := c.readHello()
+	if err != nil {
+		if !c.accepted && closedEarly(err) && c.allowsUnframed() {
+			return c.fallback()
+		}
+		return err
+	}
+	if peer.Error != "" {
+		return fmt.Errorf("%w: refused by node %s: %s", ErrHandshake, peer.NodeID, peer.Error)
+	}
+	if err := peer.check(c.local, c.required); err != nil {
+		if c.accepted {
+			refusal := c.local
+			refusal.Error = err.Error()
+			_ = c.writeHello(nil, refusal)
+		}
+		return fmt.Errorf("%w: %s", ErrHandshake, err.Error())
+	}
+	if c.accepted {
+		if err := c.writeHello(nil, c.local); err != nil {
+			return err
+		}
+	}
+	c.peer = peer
+	c.sendMax = c.max
+	if peer.MaxMessageSize < c.sendMax {
+		c.sendMax = peer.MaxMessageSize
+	}
+	return nil
+}
+
+// peerHello returns the Hello of the peer of c, once the handshake is done.
+func peerHello(c net.Conn) (Hello, bool) {
+	if fc, ok := c.(*faultConn); ok {
+		c = fc.Conn
+	}
+	fc, ok := c.(*framedConn)
+	if !ok {
+		return Hello{}, false
+	}
+	fc.hsMu.Lock()
+	defer fc.hsMu.Unlock()
+	return fc.peer, fc.hsDone && fc.hsErr == nil
+}
@@ -0,0 +1,108 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package tcp
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHandshake(t *testing.T) {
+	errs := make(chan error, 4)
+	server := openEcho(t, errs)
+	defer server.Close()
+	server.SetIdentity(Identity{NodeID: "node0", ClusterID: func() string { return "c1" }, Features: []string{"a", "b"}, Required: []string{"a"}})
+	addr := server.ln.Addr().String()
+
+	dial := func(id Identity) (Hello, error) {
+		client := NewTransport()
+		client.SetIdentity(id)
+		c, err := client.Dial(addr, time.Second)
+		if err != nil {
+			t.Fatalf("failed to dial: %s", err.Error())
+		}
+		defer c.Close()
+		if _, err := c.Write([]byte{1}); err != nil {
+			return Hello{}, err
+		}
+		if _, err := io.ReadFull(c, make([]byte, 1)); err != nil {
+			return Hello{}, err
+		}
+		peer, _ := peerHello(c)
+		if max := c.(*framedConn).sendMax; max != MinMaxMessageSize {
+			t.Fatalf("frames not sent at the smaller max message size, got %d", max)
+		}
+		return peer, nil
+	}
+
+	peer, err := dial(Identity{NodeID: "node1", Features: []string{"a"}})
+	if err != nil {
+		t.Fatalf("handshake failed: %s", err.Error())
+	}
+	if peer.NodeID != "node0" || peer.ClusterID != "c1" || peer.Protocol != ProtocolVersion || !peer.supports("b") {
+		t.Fatalf("wrong peer hello %+v", peer)
+	}
+	// Nodes not knowing their cluster yet are let through.
+	if _, err := dial(Identity{NodeID: "node1", ClusterID: func() string { return "" }, Features: []string{"a"}}); err != nil {
+		t.Fatalf("handshake failed: %s", err.Error())
+	}
+
+	for _, tt := range []struct {
+		id     Identity
+		reason string
+	}{
+		{Identity{NodeID: "node1", ClusterID: func() string { return "c2" }, Features: []string{"a"}}, "member of cluster c2, not c1"},
+		{Identity{NodeID: "node1"}, "does not support a"},
+	} {
+		_, err := dial(tt.id)
+		if !errors.Is(err, ErrHandshake) || !strings.Contains(err.Error(), tt.reason) {
+			t.Fatalf("expected the handshake refused because %s, got %v", tt.reason, err)
+		}
+		// Connections closed by the client end without error.
+		err = <-errs
+		for err == nil {
+			err = <-errs
+		}
+		if !errors.Is(err, ErrHandshake) {
+			t.Fatalf("expected the server to refuse the handshake, got %v", err)
+		}
+	}
+}
+
+func TestHelloCheck(t *testing.T) {
+	local := Identity{NodeID: "node0"}.hello(DefaultMaxMessageSize)
+	peer := local
+	peer.NodeID = "node1"
+	if err := peer.check(local, nil); err != nil {
+		t.Fatalf("compatible peer refused: %s", err.Error())
+	}
+	old := peer
+	old.Protocol = MinProtocolVersion - 1
+	newer := peer
+	newer.MinProtocol = ProtocolVersion + 1
+	small := peer
+	small.MaxMessageSize = 1
+	for _, h := range []Hello{old, newer, small} {
+		if err := h.check(local, nil); err == nil {
+			t.Fatalf("incompatible peer %+v accepted", h)
+		}
+	}
+}
@@ -0,0 +1,221 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package tcp
+
+import (
+	"bufio"
+	"crypto/tls"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"sync"
+
+	"github.com/soheilhy/cmux"
+	"golang.org/x/crypto/acme"
+)
+
+// RaftProto is the ALPN protocol negotiated by Raft connections. It lets a
+// port shared by Raft and the API present each of them its own certificate
+// and client verification policy.
+const RaftProto = "casbin-mesh-raft"
+
+// recordTypeHandshake is the first byte of a TLS connection.
+const recordTypeHandshake = 0x16
+
+var errTLSDisabled = errors.New("tls is disabled")
+
+// Listener serves TLS and plaintext connections on the same port. TLS
+// connections negotiating RaftProto are terminated with the Raft config, the
+// others with the API config. A nil config leaves the corresponding surface
+// in plaintext.
+type Listener struct {
+	net.Listener
+	raft *tls.Config
+	api  *tls.Config
+	// shared is set when Raft and the API use the same config, in which case
+	// nodes not announcing RaftProto are still let through to Raft.
+	shared bool
+	tls    *tls.Config
+}
+
+// NewListener returns a Listener accepting the connections of ln.
+func NewListener(ln net.Listener, raft, api *tls.Config) *Listener {
+	l := &Listener{Listener: ln, api: api, shared: raft != nil && raft == api}
+	if raft != nil {
+		l.raft = raft.Clone()
+		l.raft.NextProtos = []string{RaftProto}
+	}
+	l.tls = &tls.Config{GetConfigForClient: l.configForClient}
+	return l
+}
+
+// configForClient picks the config of the surface the client connects to.
+func (l *Listener) configForClient(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+	for _, proto := range hello.SupportedProtos {
+		if proto == RaftProto && l.raft != nil {
+			return l.raft, nil
+		}
+	}
+	if l.api == nil {
+		return nil, errTLSDisabled
+	}
+	return l.api, nil
+}
+
+// Accept waits for the next connection. Whether it is TLS is only decided
+// once it is read from.
+func (l *Listener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &conn{Conn: c, config: l.tls}, nil
+}
+
+// Raft returns a listener accepting the connections of ln, demultiplexed
+// from l, that may speak Raft.
+func (l *Listener) Raft(ln net.Listener) net.Listener {
+	return &filterListener{Listener: ln, allow: func(state *tls.ConnectionState) bool {
+		if state == nil {
+			return l.raft == nil
+		}
+		return state.NegotiatedProtocol == RaftProto || l.shared
+	}}
+}
+
+// API returns a listener accepting the connections of ln, demultiplexed
+// from l, that may use the API.
+func (l *Listener) API(ln net.Listener) net.Listener {
+	return &filterListener{Listener: ln, allow: func(state *tls.ConnectionState) bool {
+		if state == nil {
+			return l.api == nil
+		}
+		// TLS-ALPN-01 challenges are done once the handshake completes.
+		return state.NegotiatedProtocol != RaftProto && state.NegotiatedProtocol != acme.ALPNProto
+	}}
+}
+
+// conn is a connection which turns into a TLS connection if the client
+// starts a TLS handshake.
+type conn struct {
+	net.Conn
+	config *tls.Config
+
+	once sync.Once
+	r    *bufio.Reader
+	tls  *tls.Conn
+}
+
+// detect peeks at the first byte sent by the client.
+func (c *conn) detect() {
+	c.once.Do(func() {
+		c.r = bufio.NewReader(c.Conn)
+		if b, err := c.r.Peek(1); err == nil && b[0] == recordTypeHandshake {
+			c.tls = tls.Server(&bufferedConn{Conn: c.Conn, r: c.r}, c.config)
+		}
+	})
+}
+
+func (c *conn) Read(b []byte) (int, error) {
+	c.detect()
+	if c.tls != nil {
+		return c.tls.Read(b)
+	}
+	return c.r.Read(b)
+}
+
+func (c *conn) Write(b []byte) (int, error) {
+	c.detect()
+	if c.tls != nil {
+		return c.tls.Write(b)
+	}
+	return c.Conn.Write(b)
+}
+
+// ConnectionState returns the state of the TLS connection, completing the
+// handshake if needed, nil if the connection is plaintext.
+func (c *conn) ConnectionState() *tls.ConnectionState {
+	c.detect()
+	if c.tls == nil {
+		return nil
+	}
+	_ = c.tls.Handshake()
+	state := c.tls.ConnectionState()
+	return &state
+}
+
+// bufferedConn reads from a connection through r.
+type bufferedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c *bufferedConn) Read(b []byte) (int, error) {
+	return c.r.Read(b)
+}
+
+// ConnectionState returns the TLS state of c, unwrapping the connections of
+// the multiplexer, or nil if c is plaintext.
+func ConnectionState(c net.Conn) *tls.ConnectionState {
+	if mc, ok := c.(*cmux.MuxConn); ok {
+		c = mc.Conn
+	}
+	if tc, ok := c.(*conn); ok {
+		return tc.ConnectionState()
+	}
+	return nil
+}
+
+// filterListener closes the connections it is not allowed to accept.
+type filterListener struct {
+	net.Listener
+	allow func(state *tls.ConnectionState) bool
+}
+
+func (l *filterListener) Accept() (net.Conn, error) {
+	for {
+		c, err := l.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+		if l.allow(ConnectionState(c)) {
+			return c, nil
+		}
+		log.Printf("refusing connection from %s, not allowed on this surface", c.RemoteAddr())
+		_ = c.Close()
+	}
+}
+
+// RaftRPCMatcher matches the connections of Raft, which start with the frame
+// magic, or with the type of the RPC for nodes older than the framing.
+func RaftRPCMatcher() cmux.Matcher {
+	return func(r io.Reader) bool {
+		br := bufio.NewReader(&io.LimitedReader{R: r, N: 1})
+		byt, err := br.ReadByte()
+		if err != nil {
+			log.Printf("Raft RPC Unmatched incoming: %s\n", err)
+			return false
+		}
+		switch byt {
+		case frameMagic[0], 0, 1, 2, 3:
+			return true
+		}
+		return false
+	}
+}
@@ -0,0 +1,150 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package tcp
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+)
+
+func selfSignedConfig(t *testing.T, cn string) *tls.Config {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %s", err.Error())
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Minute),
+		NotAfter:     time.Now().Add(time.Hour),
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("failed to create certificate: %s", err.Error())
+	}
+	return &tls.Config{Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}}}
+}
+
+// accept returns the next connection of ln the client sends b on.
+func accept(t *testing.T, ln net.Listener, dial func() (net.Conn, error), b byte) (net.Conn, *tls.ConnectionState) {
+	go func() {
+		c, err := dial()
+		if err != nil {
+			return
+		}
+		_, _ = c.Write([]byte{b})
+	}()
+	c, err := ln.Accept()
+	if err != nil {
+		t.Fatalf("failed to accept: %s", err.Error())
+	}
+	buf := make([]byte, 1)
+	if _, err := c.Read(buf); err != nil || buf[0] != b {
+		t.Fatalf("failed to read from connection: %v", err)
+	}
+	return c, ConnectionState(c)
+}
+
+func TestListener_SeparateRaftAndAPI(t *testing.T) {
+	raft, api := selfSignedConfig(t, "raft"), selfSignedConfig(t, "api")
+	base, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %s", err.Error())
+	}
+	defer base.Close()
+	l := NewListener(base, raft, api)
+	addr := base.Addr().String()
+
+	dialRaft := func() (net.Conn, error) {
+		return NewTLSTransport("", "", true).Dial(addr, time.Second)
+	}
+	_, state := accept(t, l, dialRaft, frameMagic[0])
+	if state == nil || state.NegotiatedProtocol != RaftProto {
+		t.Fatalf("raft connection did not negotiate %s", RaftProto)
+	}
+
+	dialAPI := func() (net.Conn, error) {
+		return tls.Dial("tcp", addr, &tls.Config{InsecureSkipVerify: true})
+	}
+	_, state = accept(t, l, dialAPI, 'G')
+	if state == nil || state.NegotiatedProtocol == RaftProto {
+		t.Fatalf("api connection negotiated %s", RaftProto)
+	}
+
+	raftOnly := l.Raft(l)
+	go func() {
+		// API connections are refused by Raft.
+		if c, err := dialAPI(); err == nil {
+			_, _ = c.Write([]byte{1})
+		}
+		if c, err := dialRaft(); err == nil {
+			_, _ = c.Write([]byte{1})
+		}
+	}()
+	c, err := raftOnly.Accept()
+	if err != nil {
+		t.Fatalf("failed to accept: %s", err.Error())
+	}
+	if state := ConnectionState(c); state == nil || state.NegotiatedProtocol != RaftProto {
+		t.Fatalf("raft listener accepted an api connection")
+	}
+}
+
+func TestListener_PlaintextRaft(t *testing.T) {
+	base, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %s", err.Error())
+	}
+	defer base.Close()
+	l := NewListener(base, nil, selfSignedConfig(t, "api"))
+	addr := base.Addr().String()
+
+	_, state := accept(t, l.Raft(l), func() (net.Conn, error) {
+		return net.Dial("tcp", addr)
+	}, 1)
+	if state != nil {
+		t.Fatalf("plaintext connection reported as tls")
+	}
+
+	api := l.API(l)
+	go func() {
+		// Plaintext API connections are refused once the API uses TLS.
+		if c, err := net.Dial("tcp", addr); err == nil {
+			_, _ = c.Write([]byte{'G'})
+		}
+		if c, err := tls.Dial("tcp", addr, &tls.Config{InsecureSkipVerify: true}); err == nil {
+			_, _ = c.Write([]byte{'G'})
+		}
+	}()
+	c, err := api.Accept()
+	if err != nil {
+		t.Fatalf("failed to accept: %s", err.Error())
+	}
+	if ConnectionState(c) == nil {
+		t.Fatalf("api listener accepted a plaintext connection")
+	}
+}
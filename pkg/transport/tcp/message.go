@@ -0,0 +1,296 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package tcp
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// rpcInstallSnapshot is the type of the Raft RPC whose request is followed by
+// the data of the snapshot.
+const rpcInstallSnapshot = 2
+
+// ErrMessageTooLarge is returned when a peer sends a Raft message larger than
+// the max message size.
+var ErrMessageTooLarge = errors.New("raft message exceeds the max message size")
+
+// msgScanner follows the Raft messages read from a connection, so that a
+// message larger than max is refused before Raft decodes it, whatever the
+// frames it is split in. Requests are their type and a msgpack object,
+// responses an error and a msgpack object. The data of the snapshots
+// following InstallSnapshot requests is streamed to disk and not counted.
+type msgScanner struct {
+	max      int
+	requests bool
+
+	started bool
+	rpcType byte
+	size    int64
+	// objects are the top-level objects left in the message.
+	objects int
+	// items are the items left in the open arrays and maps, innermost last,
+	// maps counting their keys and values.
+	items []int64
+	maps  []bool
+	hdr   []byte
+	need  int
+	skip  int64
+
+	// The Size of InstallSnapshot requests, a key of their top-level map.
+	key       []byte
+	inKey     bool
+	lastKey   string
+	inValue   bool
+	snapshot  int64
+	remaining int64
+}
+
+func newMsgScanner(max int, requests bool) *msgScanner {
+	return &msgScanner{max: max, requests: requests}
+}
+
+// scan follows b, the next bytes of the connection, and returns an error
+// wrapping ErrMessageTooLarge if a message, or an array, map, string or
+// binary it announces, exceeds max.
+func (s *msgScanner) scan(b []byte) error {
+	for len(b) > 0 {
+		switch {
+		case s.remaining > 0:
+			n := minLen(s.remaining, b)
+			s.remaining -= n
+			b = b[n:]
+		case !s.started:
+			s.started, s.size, s.objects, s.snapshot = true, 0, 2, 0
+			if s.requests {
+				s.rpcType, s.size, s.objects = b[0], 1, 1
+				b = b[1:]
+			}
+		case s.skip > 0:
+			n := minLen(s.skip, b)
+			if s.inKey {
+				s.key = append(s.key, b[:n]...)
+			}
+			s.skip -= n
+			b = b[n:]
+			if s.skip == 0 {
+				s.end()
+			}
+		default:
+			if s.need == 0 {
+				s.begin(b[0])
+				if s.need < 0 {
+					return fmt.Errorf("invalid raft message: unknown msgpack type 0x%x", b[0])
+				}
+			}
+			n := s.need
+			if n > len(b) {
+				n = len(b)
+			}
+			s.hdr = append(s.hdr, b[:n]...)
+			s.need -= n
+			s.size += int64(n)
+			b = b[n:]
+			if s.need == 0 {
+				if err := s.object(); err != nil {
+					return err
+				}
+			}
+		}
+		if s.size > int64(s.max) {
+			return fmt.Errorf("%w: %d bytes, over %d", ErrMessageTooLarge, s.size, s.max)
+		}
+	}
+	return nil
+}
+
+func minLen(n int64, b []byte) int64 {
+	if n > int64(len(b)) {
+		return int64(len(b))
+	}
+	return n
+}
+
+// begin starts the object whose header starts with c.
+func (s *msgScanner) begin(c byte) {
+	s.hdr = s.hdr[:0]
+	s.need = headerSize(c)
+	top := len(s.items) == 1 && s.maps[0]
+	s.inKey = top && s.items[0]%2 == 0
+	s.inValue = top && s.items[0]%2 == 1
+	if s.inKey {
+		s.key, s.lastKey = s.key[:0], ""
+	}
+}
+
+// object handles the complete header of an object.
+func (s *msgScanner) object() error {
+	c := s.hdr[0]
+	n, kind := headerLength(s.hdr)
+	switch kind {
+	case kindRaw:
+		if s.size+n > int64(s.max) {
+			return fmt.Errorf("%w: at least %d bytes, over %d", ErrMessageTooLarge, s.size+n, s.max)
+		}
+		s.size += n
+		if s.inKey && n > 64 {
+			s.inKey = false
+		}
+		if s.skip = n; n == 0 {
+			s.end()
+		}
+	case kindArray, kindMap:
+		if kind == kindMap {
+			n *= 2
+		}
+		if s.size+n > int64(s.max) {
+			return fmt.Errorf("%w: at least %d bytes, over %d", ErrMessageTooLarge, s.size+n, s.max)
+		}
+		if n == 0 {
+			s.end()
+			return nil
+		}
+		s.items = append(s.items, n)
+		s.maps = append(s.maps, kind == kindMap)
+	default:
+		if s.inValue && s.lastKey == "Size" {
+			s.snapshot = intValue(c, s.hdr[1:])
+		}
+		s.end()
+	}
+	return nil
+}
+
+// end completes the current object, and the arrays, maps and message it
+// completes.
+func (s *msgScanner) end() {
+	if s.inKey {
+		s.lastKey, s.inKey = string(s.key), false
+	}
+	for len(s.items) > 0 {
+		last := len(s.items) - 1
+		if s.items[last]--; s.items[last] > 0 {
+			return
+		}
+		s.items, s.maps = s.items[:last], s.maps[:last]
+	}
+	if s.objects--; s.objects > 0 {
+		return
+	}
+	s.started = false
+	if s.requests && s.rpcType == rpcInstallSnapshot && s.snapshot > 0 {
+		s.remaining = s.snapshot
+	}
+}
+
+const (
+	kindScalar = iota
+	kindRaw
+	kindArray
+	kindMap
+)
+
+// headerSize returns the size of the header of the msgpack objects starting
+// with c, -1 if c starts none.
+func headerSize(c byte) int {
+	switch {
+	case c <= 0xbf, c >= 0xe0:
+		return 1
+	}
+	switch c {
+	case 0xc0, 0xc2, 0xc3:
+		return 1
+	case 0xc4, 0xcc, 0xd0, 0xd4, 0xd5, 0xd6, 0xd7, 0xd8, 0xd9:
+		return 2
+	case 0xc5, 0xc7, 0xcd, 0xd1, 0xda, 0xdc, 0xde:
+		return 3
+	case 0xc8:
+		return 4
+	case 0xc6, 0xca, 0xce, 0xd2, 0xdb, 0xdd, 0xdf:
+		return 5
+	case 0xc9:
+		return 6
+	case 0xcb, 0xcf, 0xd3:
+		return 9
+	}
+	return -1
+}
+
+// headerLength returns the kind of the object of header h, and the size of
+// its payload or its number of items.
+func headerLength(h []byte) (int64, int) {
+	c := h[0]
+	switch {
+	case c >= 0x80 && c <= 0x8f:
+		return int64(c & 0x0f), kindMap
+	case c >= 0x90 && c <= 0x9f:
+		return int64(c & 0x0f), kindArray
+	case c >= 0xa0 && c <= 0xbf:
+		return int64(c & 0x1f), kindRaw
+	}
+	switch c {
+	case 0xc4, 0xc7, 0xd9:
+		return int64(h[1]), kindRaw
+	case 0xc5, 0xc8, 0xda:
+		return int64(binary.BigEndian.Uint16(h[1:])), kindRaw
+	case 0xc6, 0xc9, 0xdb:
+		return int64(binary.BigEndian.Uint32(h[1:])), kindRaw
+	case 0xd4, 0xd5, 0xd6, 0xd7, 0xd8:
+		return 1 << (c - 0xd4), kindRaw
+	case 0xdc:
+		return int64(binary.BigEndian.Uint16(h[1:])), kindArray
+	case 0xdd:
+		return int64(binary.BigEndian.Uint32(h[1:])), kindArray
+	case 0xde:
+		return int64(binary.BigEndian.Uint16(h[1:])), kindMap
+	case 0xdf:
+		return int64(binary.BigEndian.Uint32(h[1:])), kindMap
+	}
+	return 0, kindScalar
+}
+
+// intValue returns the value of the integer of type c, encoded in b, 0 if c
+// is not an integer type.
+func intValue(c byte, b []byte) int64 {
+	switch {
+	case c <= 0x7f:
+		return int64(c)
+	case c >= 0xe0:
+		return int64(int8(c))
+	}
+	switch c {
+	case 0xcc:
+		return int64(b[0])
+	case 0xcd:
+		return int64(binary.BigEndian.Uint16(b))
+	case 0xce:
+		return int64(binary.BigEndian.Uint32(b))
+	case 0xcf:
+		return int64(binary.BigEndian.Uint64(b))
+	case 0xd0:
+		return int64(int8(b[0]))
+	case 0xd1:
+		return int64(int16(binary.BigEndian.Uint16(b)))
+	case 0xd2:
+		return int64(int32(binary.BigEndian.Uint32(b)))
+	case 0xd3:
+		return int64(binary.BigEndian.Uint64(b))
+	}
+	return 0
+}
@@ -0,0 +1,99 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package tcp
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/raft"
+)
+
+// raftLayer is the stream layer of a Raft network transport over a
+// Transport.
+type raftLayer struct {
+	*Transport
+}
+
+func (l raftLayer) Dial(addr raft.ServerAddress, timeout time.Duration) (net.Conn, error) {
+	return l.Transport.Dial(string(addr), timeout)
+}
+
+func (l raftLayer) Addr() net.Addr {
+	return l.ln.Addr()
+}
+
+func TestTransportRaftMessages(t *testing.T) {
+	server := NewTransport()
+	if err := server.SetMaxMessageSize(MinMaxMessageSize); err != nil {
+		t.Fatalf("failed to set max message size: %s", err.Error())
+	}
+	if err := server.Open("localhost:0"); err != nil {
+		t.Fatalf("failed to open transport: %s", err.Error())
+	}
+	st := raft.NewNetworkTransport(raftLayer{server}, 1, 5*time.Second, ioutil.Discard)
+	defer st.Close()
+	go func() {
+		for rpc := range st.Consumer() {
+			switch rpc.Command.(type) {
+			case *raft.AppendEntriesRequest:
+				rpc.Respond(&raft.AppendEntriesResponse{Success: true}, nil)
+			case *raft.InstallSnapshotRequest:
+				_, err := io.Copy(ioutil.Discard, rpc.Reader)
+				rpc.Respond(&raft.InstallSnapshotResponse{Success: true}, err)
+			}
+		}
+	}()
+
+	client := NewTransport()
+	if err := client.Open("localhost:0"); err != nil {
+		t.Fatalf("failed to open transport: %s", err.Error())
+	}
+	ct := raft.NewNetworkTransport(raftLayer{client}, 1, 5*time.Second, ioutil.Discard)
+	defer ct.Close()
+	target := raft.ServerAddress(server.ln.Addr().String())
+
+	appendEntries := func(size int) error {
+		entry := &raft.Log{Index: 1, Term: 1, Type: raft.LogCommand, Data: make([]byte, size), AppendedAt: time.Now()}
+		var resp raft.AppendEntriesResponse
+		return ct.AppendEntries("node0", target, &raft.AppendEntriesRequest{Term: 1,
+			Entries: []*raft.Log{entry, entry}}, &resp)
+	}
+	if err := appendEntries(MinMaxMessageSize / 4); err != nil {
+		t.Fatalf("failed to append entries: %s", err.Error())
+	}
+	// Snapshots are streamed to disk after their request, their data is not
+	// capped.
+	data := bytes.Repeat([]byte{0xc6}, 4*MinMaxMessageSize)
+	var resp raft.InstallSnapshotResponse
+	if err := ct.InstallSnapshot("node0", target, &raft.InstallSnapshotRequest{Term: 1, Size: int64(len(data))},
+		&resp, bytes.NewReader(data)); err != nil || !resp.Success {
+		t.Fatalf("failed to install snapshot: %v", err)
+	}
+	// A message split into frames is capped as a whole.
+	if err := appendEntries(MinMaxMessageSize / 2); err == nil {
+		t.Fatalf("message over the max message size accepted")
+	}
+	if err := appendEntries(MinMaxMessageSize / 4); err != nil {
+		t.Fatalf("failed to append entries: %s", err.Error())
+	}
+}
@@ -18,12 +18,18 @@
 package tcp
 
 import (
+	"context"
 	"crypto/tls"
+	"crypto/x509"
+	"fmt"
 	"log"
 	"net"
 	"time"
 )
 
+// unframedDialTimeout bounds the dial opening a connection again unframed.
+const unframedDialTimeout = 10 * time.Second
+
 type Addr struct {
 	Hostname string
 }
@@ -41,11 +47,16 @@ type Transport struct {
 	ln      net.Listener
 	advAddr Addr
 
-	certFile        string // Path to local X.509 cert.
-	certKey         string // Path to corresponding X.509 key.
-	remoteEncrypted bool   // Remote nodes use encrypted communication.
-	skipVerify      bool   // Skip verification of remote node certs.
-	srcIP           string // The specified source IP is optional
+	certFile        string         // Path to local X.509 cert.
+	certKey         string         // Path to corresponding X.509 key.
+	remoteEncrypted bool           // Remote nodes use encrypted communication.
+	skipVerify      bool           // Skip verification of remote node certs.
+	srcIP           string         // The specified source IP is optional
+	rootCAs         *x509.CertPool // Verifies remote node certs, system roots if nil.
+	faults          *Faults        // Injected into connections, none if nil.
+	maxMessageSize  int            // Size above which frames are refused.
+	identity        Identity       // Told to peers in the handshake.
+	unframed        func() bool    // Whether unframed connections are allowed.
 }
 
 // NewTransport returns an initialized unencrypted Transport.
@@ -68,6 +79,41 @@ func NewTransportFromListener(ln net.Listener, remoteEncrypted bool, skipVerify
 	return &Transport{ln: ln, remoteEncrypted: remoteEncrypted, skipVerify: skipVerify, advAddr: Addr{Hostname: addr}}
 }
 
+// SetRootCAs sets the CAs verifying the certificates of remote nodes.
+func (t *Transport) SetRootCAs(pool *x509.CertPool) {
+	t.rootCAs = pool
+}
+
+// SetFaults injects faults into the connections opened and accepted from
+// now on.
+func (t *Transport) SetFaults(f *Faults) {
+	t.faults = f
+}
+
+// SetMaxMessageSize sets the size above which the frames sent by peers are
+// refused, DefaultMaxMessageSize if 0. Frames are sent no larger than it.
+func (t *Transport) SetMaxMessageSize(n int) error {
+	if n != 0 && n < MinMaxMessageSize {
+		return fmt.Errorf("max message size %d is below %d bytes", n, MinMaxMessageSize)
+	}
+	t.maxMessageSize = n
+	return nil
+}
+
+// SetIdentity sets what the node tells its peers about itself, and requires
+// from them, in the handshake of the connections opened and accepted from
+// now on.
+func (t *Transport) SetIdentity(id Identity) {
+	t.identity = id
+}
+
+// SetUnframed makes the connections opened and accepted from now on accept,
+// and fall back to, the unframed connections of nodes older than the framing
+// while f returns true, until every member of the cluster frames them.
+func (t *Transport) SetUnframed(f func() bool) {
+	t.unframed = f
+}
+
 // Open opens the transport, binding to the supplied address.
 func (t *Transport) Open(addr string) error {
 	ln, err := net.Listen("tcp", addr)
@@ -88,29 +134,57 @@ func (t *Transport) Open(addr string) error {
 
 // Dial opens a network connection.
 func (t *Transport) Dial(addr string, timeout time.Duration) (net.Conn, error) {
-	var dialer *net.Dialer
-	dialer = &net.Dialer{Timeout: timeout}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return t.DialContext(ctx, addr)
+}
+
+// DialContext opens a network connection, giving up once ctx is done.
+func (t *Transport) DialContext(ctx context.Context, addr string) (net.Conn, error) {
+	if t.faults != nil {
+		if err := t.faults.dial(addr); err != nil {
+			return nil, err
+		}
+	}
+	c, err := t.dialContext(ctx, addr)
+	if err != nil {
+		return nil, err
+	}
+	fc := newFramedConn(c, t.maxMessageSize, t.identity, false, t.unframed)
+	fc.redial = func() (net.Conn, error) {
+		ctx, cancel := context.WithTimeout(context.Background(), unframedDialTimeout)
+		defer cancel()
+		return t.dialContext(ctx, addr)
+	}
+	var conn net.Conn = fc
+	if t.faults != nil {
+		conn = &faultConn{Conn: conn, faults: t.faults, peer: addr}
+	}
+	return conn, nil
+}
+
+func (t *Transport) dialContext(ctx context.Context, addr string) (net.Conn, error) {
+	dialer := &net.Dialer{}
 	if t.srcIP != "" {
-		netAddr := &net.TCPAddr{
+		dialer.LocalAddr = &net.TCPAddr{
 			IP:   net.ParseIP(t.srcIP),
 			Port: 0,
 		}
-		dialer = &net.Dialer{Timeout: timeout, LocalAddr: netAddr}
 	}
 
-	var err error
-	var conn net.Conn
 	if t.remoteEncrypted {
-		conf := &tls.Config{
-			InsecureSkipVerify: t.skipVerify,
+		tlsDialer := &tls.Dialer{
+			NetDialer: dialer,
+			Config: &tls.Config{
+				InsecureSkipVerify: t.skipVerify,
+				RootCAs:            t.rootCAs,
+				NextProtos:         []string{RaftProto},
+			},
 		}
 		log.Println("doing a TLS dial")
-		conn, err = tls.DialWithDialer(dialer, "tcp", addr, conf)
-	} else {
-		conn, err = dialer.Dial("tcp", addr)
+		return tlsDialer.DialContext(ctx, "tcp", addr)
 	}
-
-	return conn, err
+	return dialer.DialContext(ctx, "tcp", addr)
 }
 
 // Accept waits for the next connection.
@@ -118,8 +192,13 @@ func (t *Transport) Accept() (net.Conn, error) {
 	c, err := t.ln.Accept()
 	if err != nil {
 		log.Println("error accepting: ", err.Error())
+		return c, err
+	}
+	c = newFramedConn(c, t.maxMessageSize, t.identity, true, t.unframed)
+	if t.faults != nil {
+		c = &faultConn{Conn: c, faults: t.faults, peer: c.RemoteAddr().String(), accepted: true}
 	}
-	return c, err
+	return c, nil
 }
 
 // Close closes the transport
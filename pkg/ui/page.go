@@ -0,0 +1,258 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package ui
+
+// indexHTML is the dashboard. It is kept free of backquotes to fit a raw
+// string literal.
+const indexHTML = `<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>casbin-mesh</title>
+<style>
+body { font-family: system-ui, sans-serif; margin: 0; color: #222; background: #f6f7f9; }
+header { background: #1f2937; color: #fff; padding: 10px 20px; display: flex; gap: 20px; align-items: center; }
+header h1 { font-size: 18px; margin: 0; }
+nav button { background: none; border: 0; color: #cbd5e1; font-size: 14px; cursor: pointer; padding: 6px 10px; }
+nav button.active { color: #fff; border-bottom: 2px solid #60a5fa; }
+main { padding: 20px; }
+section { display: none; }
+section.active { display: block; }
+table { border-collapse: collapse; width: 100%; background: #fff; margin-bottom: 16px; }
+th, td { text-align: left; padding: 6px 10px; border-bottom: 1px solid #e5e7eb; font-size: 13px; }
+th { background: #f1f5f9; }
+input, select, textarea { font: inherit; padding: 4px 6px; }
+textarea { width: 100%; box-sizing: border-box; }
+button.action { padding: 5px 12px; cursor: pointer; }
+.row { display: flex; gap: 8px; align-items: center; margin-bottom: 12px; flex-wrap: wrap; }
+.ok { color: #15803d; font-weight: bold; }
+.bad { color: #b91c1c; font-weight: bold; }
+.muted { color: #6b7280; font-size: 13px; }
+pre { background: #fff; padding: 10px; overflow: auto; font-size: 12px; }
+#error { color: #b91c1c; margin-bottom: 12px; }
+</style>
+</head>
+<body>
+<header>
+<h1>casbin-mesh</h1>
+<nav>
+<button data-tab="cluster" class="active">Cluster</button>
+<button data-tab="policies">Policies</button>
+<button data-tab="enforce">Enforce</button>
+</nav>
+</header>
+<main>
+<div id="error"></div>
+
+<section id="cluster" class="active">
+<div class="row"><button class="action" id="refresh-cluster">Refresh</button><span class="muted" id="cluster-summary"></span></div>
+<table><thead><tr><th>ID</th><th>Address</th><th>Role</th><th>Version</th><th>Zone</th></tr></thead><tbody id="nodes"></tbody></table>
+<table><thead><tr><th>Follower</th><th>Match index</th><th>Lag</th></tr></thead><tbody id="replication"></tbody></table>
+<h3>Node statistics</h3>
+<pre id="stats"></pre>
+</section>
+
+<section id="policies">
+<div class="row">
+<label>Namespace <select id="namespace"></select></label>
+<label>Type <input id="ptype" size="4" placeholder="any"></label>
+<label>Contains <input id="contains" placeholder="alice"></label>
+<button class="action" id="search">Search</button>
+<span class="muted" id="total"></span>
+</div>
+<table><thead><tr><th>Type</th><th>Rule</th><th>Annotation</th></tr></thead><tbody id="results"></tbody></table>
+<div class="row"><button class="action" id="more" hidden>More</button></div>
+<h3>Model</h3>
+<pre id="model"></pre>
+</section>
+
+<section id="enforce">
+<div class="row"><label>Namespace <select id="enforce-namespace"></select></label></div>
+<p class="muted">The request, as a JSON array of its values, e.g. ["alice", "data1", "read"].</p>
+<textarea id="params" rows="3">["alice", "data1", "read"]</textarea>
+<div class="row">
+<button class="action" id="try">Enforce</button>
+<button class="action" id="explain">Explain</button>
+<span id="decision"></span>
+</div>
+<pre id="explanation" hidden></pre>
+</section>
+</main>
+<script>
+(function () {
+  "use strict";
+  var $ = function (id) { return document.getElementById(id); };
+
+  function showError(err) { $("error").textContent = err ? String(err) : ""; }
+
+  function api(method, path, body) {
+    var init = { method: method, credentials: "same-origin", headers: {} };
+    if (body !== undefined) {
+      init.headers["Content-Type"] = "application/json";
+      init.body = JSON.stringify(body);
+    }
+    return fetch(path, init).then(function (r) {
+      return r.text().then(function (text) {
+        if (!r.ok) { throw new Error(method + " " + path + ": " + r.status + " " + text); }
+        return text ? JSON.parse(text) : null;
+      });
+    });
+  }
+
+  function cell(tr, value, cls) {
+    var td = document.createElement("td");
+    td.textContent = value === undefined || value === null ? "" : String(value);
+    if (cls) { td.className = cls; }
+    tr.appendChild(td);
+  }
+
+  function clear(el) { while (el.firstChild) { el.removeChild(el.firstChild); } }
+
+  function loadCluster() {
+    showError();
+    api("GET", "/cluster/status").then(function (st) {
+      var tbody = $("nodes"), leader = "";
+      clear(tbody);
+      st.nodes.forEach(function (n) {
+        var tr = document.createElement("tr");
+        cell(tr, n.id);
+        cell(tr, n.addr);
+        cell(tr, n.leader ? "leader" : (n.voter ? "voter" : "non-voter"), n.leader ? "ok" : "");
+        cell(tr, n.metadata.version || "unknown");
+        cell(tr, n.metadata.zone || "");
+        tbody.appendChild(tr);
+        if (n.leader) { leader = n.id; }
+      });
+      $("cluster-summary").textContent = st.nodes.length + " nodes, " +
+        (leader ? "leader " + leader : "no leader") + ", answered by " + st.node_id;
+    }).catch(showError);
+    api("GET", "/cluster/replication").then(function (r) {
+      var tbody = $("replication");
+      clear(tbody);
+      r.followers.forEach(function (f) {
+        var tr = document.createElement("tr");
+        cell(tr, f.node_id);
+        cell(tr, f.match_index);
+        cell(tr, f.lag, f.lag > 0 ? "bad" : "ok");
+        tbody.appendChild(tr);
+      });
+    }).catch(function () { clear($("replication")); });
+    api("GET", "/stats").then(function (stats) {
+      $("stats").textContent = JSON.stringify(stats, null, 2);
+    }).catch(showError);
+  }
+
+  function loadNamespaces() {
+    var all = [];
+    function page(cursor) {
+      var path = "/namespaces?limit=1000" + (cursor ? "&cursor=" + encodeURIComponent(cursor) : "");
+      return api("GET", path).then(function (r) {
+        all = all.concat(r.namespaces || []);
+        return r.nextCursor ? page(r.nextCursor) : all;
+      });
+    }
+    return page("").then(function (namespaces) {
+      ["namespace", "enforce-namespace"].forEach(function (id) {
+        var sel = $(id), current = sel.value;
+        clear(sel);
+        namespaces.forEach(function (ns) {
+          var opt = document.createElement("option");
+          opt.value = opt.textContent = ns;
+          sel.appendChild(opt);
+        });
+        if (current) { sel.value = current; }
+      });
+    }).catch(showError);
+  }
+
+  var cursor = "";
+  function search(more) {
+    showError();
+    var ns = $("namespace").value;
+    if (!ns) { return; }
+    if (!more) { cursor = ""; clear($("results")); }
+    var q = "?limit=100";
+    if ($("ptype").value) { q += "&ptype=" + encodeURIComponent($("ptype").value); }
+    if ($("contains").value) { q += "&q=" + encodeURIComponent($("contains").value); }
+    if (cursor) { q += "&cursor=" + encodeURIComponent(cursor); }
+    var base = "/namespaces/" + encodeURIComponent(ns);
+    api("GET", base + "/policies/search" + q).then(function (r) {
+      var tbody = $("results");
+      r.policies.forEach(function (p) {
+        var tr = document.createElement("tr");
+        cell(tr, p.ptype);
+        cell(tr, p.rule.join(", "));
+        cell(tr, p.annotation ? [p.annotation.description, p.annotation.owner].filter(Boolean).join(" / ") : "");
+        tbody.appendChild(tr);
+      });
+      $("total").textContent = r.total + " rules";
+      cursor = r.nextCursor || "";
+      $("more").hidden = !cursor;
+    }).catch(showError);
+    if (!more) {
+      api("GET", base + "/model").then(function (m) {
+        $("model").textContent = m.text;
+      }).catch(function () { $("model").textContent = "no model set"; });
+    }
+  }
+
+  function enforce(explain) {
+    showError();
+    var params;
+    try {
+      params = JSON.parse($("params").value);
+      if (!Array.isArray(params)) { throw new Error("the request must be a JSON array"); }
+    } catch (e) {
+      showError(e);
+      return;
+    }
+    var body = { ns: $("enforce-namespace").value, params: params };
+    var decision = $("decision"), out = $("explanation");
+    api("POST", explain ? "/explain" : "/enforce", body).then(function (r) {
+      var allowed = explain ? r.allowed : r.ok;
+      decision.textContent = allowed ? "allowed" : "denied";
+      decision.className = allowed ? "ok" : "bad";
+      out.hidden = !explain;
+      out.textContent = explain ? JSON.stringify(r, null, 2) : "";
+    }).catch(showError);
+  }
+
+  Array.prototype.forEach.call(document.querySelectorAll("nav button"), function (b) {
+    b.addEventListener("click", function () {
+      Array.prototype.forEach.call(document.querySelectorAll("nav button, section"), function (el) {
+        el.classList.remove("active");
+      });
+      b.classList.add("active");
+      $(b.getAttribute("data-tab")).classList.add("active");
+      if (b.getAttribute("data-tab") === "cluster") { loadCluster(); } else { loadNamespaces(); }
+    });
+  });
+  $("refresh-cluster").addEventListener("click", loadCluster);
+  $("search").addEventListener("click", function () { search(false); });
+  $("more").addEventListener("click", function () { search(true); });
+  $("try").addEventListener("click", function () { enforce(false); });
+  $("explain").addEventListener("click", function () { enforce(true); });
+
+  loadCluster();
+  loadNamespaces();
+})();
+</script>
+</body>
+</html>
+`
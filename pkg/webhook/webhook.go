@@ -0,0 +1,367 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+// Package webhook notifies external systems of the changes applied to
+// namespaces, by POSTing signed JSON payloads to configured URLs.
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/casbin/casbin-mesh/pkg/store"
+	"github.com/casbin/casbin-mesh/proto/command"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// SignatureHeader carries the hex HMAC-SHA256 of the body, keyed by the
+	// secret of the hook and prefixed by "sha256=".
+	SignatureHeader = "X-Casbin-Mesh-Signature"
+	// EventHeader carries the type of the change.
+	EventHeader = "X-Casbin-Mesh-Event"
+	// DeliveryHeader carries the Raft index of the change, which receivers
+	// can use to discard duplicate deliveries.
+	DeliveryHeader = "X-Casbin-Mesh-Delivery"
+
+	queueSize  = 1024
+	watchRetry = time.Second
+)
+
+// Hook is an URL notified of the changes of some or all namespaces.
+type Hook struct {
+	URL string `yaml:"url"`
+	// Namespaces restricts the hook to these namespaces, all namespaces are
+	// notified if empty.
+	Namespaces []string `yaml:"namespaces"`
+	// Secret signs the payloads, they are unsigned if empty.
+	Secret string `yaml:"secret"`
+}
+
+func (h *Hook) matches(ns string) bool {
+	if len(h.Namespaces) == 0 {
+		return true
+	}
+	for _, n := range h.Namespaces {
+		if n == ns {
+			return true
+		}
+	}
+	return false
+}
+
+// Config is the webhook configuration file.
+type Config struct {
+	Hooks []Hook `yaml:"hooks"`
+	// Retries is the number of retries of a failed delivery.
+	Retries int `yaml:"retries"`
+	// Backoff is the delay before the first retry, doubling on each retry.
+	Backoff string `yaml:"backoff"`
+	// Timeout bounds each delivery attempt.
+	Timeout string `yaml:"timeout"`
+	// DeadLetter is a file deliveries are appended to, as JSON lines, once
+	// all retries failed. They are only logged if empty.
+	DeadLetter string `yaml:"dead-letter"`
+}
+
+// LoadConfig reads the webhook configuration from a YAML file.
+func LoadConfig(path string) (*Config, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	cfg := &Config{Retries: 5, Backoff: "1s", Timeout: "10s"}
+	dec := yaml.NewDecoder(f)
+	dec.KnownFields(true)
+	if err := dec.Decode(cfg); err != nil && err != io.EOF {
+		return nil, fmt.Errorf("failed to parse %s: %s", path, err.Error())
+	}
+	for i, h := range cfg.Hooks {
+		if h.URL == "" {
+			return nil, fmt.Errorf("hook %d has no url", i)
+		}
+	}
+	return cfg, nil
+}
+
+// Event is the payload POSTed to the hooks.
+type Event struct {
+	Index     uint64     `json:"index"`
+	Namespace string     `json:"namespace"`
+	Type      string     `json:"type"`
+	Sec       string     `json:"sec,omitempty"`
+	PType     string     `json:"ptype,omitempty"`
+	Rules     [][]string `json:"rules,omitempty"`
+	OldRules  [][]string `json:"old_rules,omitempty"`
+	Model     string     `json:"model,omitempty"`
+	Time      time.Time  `json:"time"`
+}
+
+// NewEvent converts a change to its payload.
+func NewEvent(ev *command.WatchEvent) Event {
+	return Event{
+		Index:     ev.GetIndex(),
+		Namespace: ev.GetNamespace(),
+		Type:      ev.GetType().String(),
+		Sec:       ev.GetSec(),
+		PType:     ev.GetPType(),
+		Rules:     command.ToStringArray(ev.GetRules()),
+		OldRules:  command.ToStringArray(ev.GetOldRules()),
+		Model:     ev.GetModel(),
+		Time:      time.Now().UTC(),
+	}
+}
+
+// Sign returns the signature of body for secret, as sent in SignatureHeader.
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Source is the subset of core.Core the Dispatcher needs.
+type Source interface {
+	Watch(ctx context.Context, ns string, index uint64, fn func(*command.WatchEvent) error) error
+	IsLeader(ctx context.Context) bool
+}
+
+type delivery struct {
+	hook  *Hook
+	event Event
+	body  []byte
+}
+
+// Dispatcher delivers the changes to the hooks. Every node applies the same
+// changes, so only the leader delivers them, each hook in order.
+type Dispatcher struct {
+	src        Source
+	hooks      []Hook
+	retries    int
+	backoff    time.Duration
+	client     *http.Client
+	queues     []chan delivery
+	deadLetter io.WriteCloser
+	deadMu     sync.Mutex
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// New returns a Dispatcher delivering the changes of src as configured.
+func New(cfg *Config, src Source) (*Dispatcher, error) {
+	backoff, err := time.ParseDuration(cfg.Backoff)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse backoff %s: %s", cfg.Backoff, err.Error())
+	}
+	timeout, err := time.ParseDuration(cfg.Timeout)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse timeout %s: %s", cfg.Timeout, err.Error())
+	}
+	d := &Dispatcher{
+		src:     src,
+		hooks:   cfg.Hooks,
+		retries: cfg.Retries,
+		backoff: backoff,
+		client:  &http.Client{Timeout: timeout},
+	}
+	if cfg.DeadLetter != "" {
+		if d.deadLetter, err = os.OpenFile(cfg.DeadLetter, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600); err != nil {
+			return nil, err
+		}
+	}
+	return d, nil
+}
+
+// Start starts watching the changes and delivering them.
+func (d *Dispatcher) Start() {
+	d.ctx, d.cancel = context.WithCancel(context.Background())
+	d.queues = make([]chan delivery, len(d.hooks))
+	for i := range d.queues {
+		d.queues[i] = make(chan delivery, queueSize)
+		d.wg.Add(1)
+		go d.deliver(d.queues[i])
+	}
+	d.wg.Add(1)
+	go d.watch()
+}
+
+// Close stops the Dispatcher, waiting until ctx is done for the deliveries
+// in progress. Queued deliveries are dead-lettered.
+func (d *Dispatcher) Close(ctx context.Context) {
+	d.cancel()
+	done := make(chan struct{})
+	go func() {
+		d.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		log.Println("failed to finish webhook deliveries:", ctx.Err().Error())
+	}
+	d.deadMu.Lock()
+	defer d.deadMu.Unlock()
+	if d.deadLetter != nil {
+		d.deadLetter.Close()
+		d.deadLetter = nil
+	}
+}
+
+func (d *Dispatcher) watch() {
+	defer d.wg.Done()
+	var index uint64
+	for {
+		err := d.src.Watch(d.ctx, "", index, func(ev *command.WatchEvent) error {
+			index = ev.GetIndex()
+			d.dispatch(ev)
+			return nil
+		})
+		// Watches also end while the node shuts down, give Close a chance
+		// to stop the Dispatcher before resuming.
+		select {
+		case <-d.ctx.Done():
+			return
+		case <-time.After(watchRetry):
+		}
+		if err == store.ErrWatchCompacted {
+			log.Println("webhook changes were missed, resuming from the latest change")
+			index = 0
+		} else {
+			log.Printf("webhook watch ended: %s, resuming", err.Error())
+		}
+	}
+}
+
+func (d *Dispatcher) dispatch(ev *command.WatchEvent) {
+	if !d.src.IsLeader(d.ctx) {
+		return
+	}
+	event := NewEvent(ev)
+	body, err := json.Marshal(event)
+	if err != nil {
+		log.Printf("failed to encode webhook event %d: %s", event.Index, err.Error())
+		return
+	}
+	for i := range d.hooks {
+		hook := &d.hooks[i]
+		if !hook.matches(event.Namespace) {
+			continue
+		}
+		dl := delivery{hook: hook, event: event, body: body}
+		select {
+		case d.queues[i] <- dl:
+		default:
+			d.dead(dl, "delivery queue is full")
+		}
+	}
+}
+
+func (d *Dispatcher) deliver(queue chan delivery) {
+	defer d.wg.Done()
+	for {
+		select {
+		case <-d.ctx.Done():
+			for {
+				select {
+				case dl := <-queue:
+					d.dead(dl, "node shut down")
+				default:
+					return
+				}
+			}
+		case dl := <-queue:
+			d.send(dl)
+		}
+	}
+}
+
+// send delivers dl, retrying with an exponential backoff.
+func (d *Dispatcher) send(dl delivery) {
+	backoff := d.backoff
+	var err error
+	for attempt := 0; ; attempt++ {
+		if err = d.post(dl); err == nil {
+			return
+		}
+		if attempt == d.retries {
+			break
+		}
+		select {
+		case <-d.ctx.Done():
+			d.dead(dl, err.Error())
+			return
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+	d.dead(dl, err.Error())
+}
+
+func (d *Dispatcher) post(dl delivery) error {
+	req, err := http.NewRequest(http.MethodPost, dl.hook.URL, bytes.NewReader(dl.body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(EventHeader, dl.event.Type)
+	req.Header.Set(DeliveryHeader, fmt.Sprint(dl.event.Index))
+	if dl.hook.Secret != "" {
+		req.Header.Set(SignatureHeader, Sign(dl.hook.Secret, dl.body))
+	}
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}
+
+// dead logs an undeliverable change and appends it to the dead-letter file.
+func (d *Dispatcher) dead(dl delivery, reason string) {
+	log.Printf("failed to deliver change %d of %s to %s: %s", dl.event.Index, dl.event.Namespace, dl.hook.URL, reason)
+	d.deadMu.Lock()
+	defer d.deadMu.Unlock()
+	if d.deadLetter == nil {
+		return
+	}
+	line, _ := json.Marshal(struct {
+		URL   string `json:"url"`
+		Error string `json:"error"`
+		Event Event  `json:"event"`
+	}{dl.hook.URL, reason, dl.event})
+	if _, err := d.deadLetter.Write(append(line, '\n')); err != nil {
+		log.Printf("failed to write dead letter: %s", err.Error())
+	}
+}
@@ -0,0 +1,196 @@
+// Licensed to the Apache Software Foundation (ASF) under one
+// or more contributor license agreements.  See the NOTICE file
+// distributed with this work for additional information
+// regarding copyright ownership.  The ASF licenses this file
+// to you under the Apache License, Version 2.0 (the
+// "License"); you may not use this file except in compliance
+// with the License.  You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/casbin/casbin-mesh/proto/command"
+)
+
+type fakeSource struct {
+	events chan *command.WatchEvent
+	leader bool
+}
+
+func (f *fakeSource) Watch(ctx context.Context, ns string, index uint64, fn func(*command.WatchEvent) error) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case ev := <-f.events:
+			if err := fn(ev); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func (f *fakeSource) IsLeader(ctx context.Context) bool {
+	return f.leader
+}
+
+func addEvent(index uint64, ns string) *command.WatchEvent {
+	return &command.WatchEvent{Index: index, Namespace: ns, Type: command.Type_COMMAND_TYPE_ADD_POLICIES,
+		Sec: "p", PType: "p", Rules: command.NewStringArray([][]string{{"alice", "data1", "read"}})}
+}
+
+func Test_WebhookDelivery(t *testing.T) {
+	received := make(chan Event, 1)
+	var calls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The first attempt fails and is retried.
+		if atomic.AddInt32(&calls, 1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		body, _ := ioutil.ReadAll(r.Body)
+		if r.Header.Get(SignatureHeader) != Sign("s3cret", body) {
+			t.Errorf("wrong signature %s", r.Header.Get(SignatureHeader))
+		}
+		if r.Header.Get(DeliveryHeader) != "7" {
+			t.Errorf("wrong delivery id %s", r.Header.Get(DeliveryHeader))
+		}
+		var ev Event
+		if err := json.Unmarshal(body, &ev); err != nil {
+			t.Errorf("failed to decode event: %s", err.Error())
+		}
+		received <- ev
+	}))
+	defer srv.Close()
+
+	src := &fakeSource{events: make(chan *command.WatchEvent, 2), leader: true}
+	d, err := New(&Config{Hooks: []Hook{{URL: srv.URL, Namespaces: []string{"orders"}, Secret: "s3cret"}},
+		Retries: 2, Backoff: "10ms", Timeout: "1s"}, src)
+	if err != nil {
+		t.Fatalf("failed to create dispatcher: %s", err.Error())
+	}
+	d.Start()
+	defer d.Close(context.Background())
+
+	// Changes of other namespaces are filtered out.
+	src.events <- addEvent(6, "billing")
+	src.events <- addEvent(7, "orders")
+	select {
+	case ev := <-received:
+		if ev.Index != 7 || ev.Namespace != "orders" || ev.Type != "COMMAND_TYPE_ADD_POLICIES" || len(ev.Rules) != 1 {
+			t.Fatalf("wrong event delivered: %+v", ev)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("event was not delivered")
+	}
+	if n := atomic.LoadInt32(&calls); n != 2 {
+		t.Fatalf("expected 2 attempts, got %d", n)
+	}
+}
+
+func Test_WebhookDeadLetter(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer srv.Close()
+
+	dir, err := ioutil.TempDir("", "casmesh-webhook-")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %s", err.Error())
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "dead.jsonl")
+
+	src := &fakeSource{events: make(chan *command.WatchEvent, 1), leader: true}
+	d, err := New(&Config{Hooks: []Hook{{URL: srv.URL}}, Retries: 1, Backoff: "10ms", Timeout: "1s", DeadLetter: path}, src)
+	if err != nil {
+		t.Fatalf("failed to create dispatcher: %s", err.Error())
+	}
+	d.Start()
+	src.events <- addEvent(3, "default")
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		b, _ := ioutil.ReadFile(path)
+		if strings.Contains(string(b), `"index":3`) {
+			if !strings.Contains(string(b), "500") {
+				t.Fatalf("dead letter doesn't record the error: %s", b)
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("change was not dead-lettered")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	d.Close(context.Background())
+}
+
+func Test_WebhookFollowerDoesNotDeliver(t *testing.T) {
+	var calls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+	}))
+	defer srv.Close()
+
+	src := &fakeSource{events: make(chan *command.WatchEvent, 1)}
+	d, err := New(&Config{Hooks: []Hook{{URL: srv.URL}}, Backoff: "10ms", Timeout: "1s"}, src)
+	if err != nil {
+		t.Fatalf("failed to create dispatcher: %s", err.Error())
+	}
+	d.Start()
+	src.events <- addEvent(1, "default")
+	time.Sleep(100 * time.Millisecond)
+	d.Close(context.Background())
+	if n := atomic.LoadInt32(&calls); n != 0 {
+		t.Fatalf("follower delivered %d changes", n)
+	}
+}
+
+func Test_LoadConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "casmesh-webhook-")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %s", err.Error())
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "webhooks.yaml")
+
+	ioutil.WriteFile(path, []byte("retries: 3\nhooks:\n  - url: http://cache/invalidate\n    namespaces: [orders]\n"), 0600)
+	cfg, err := LoadConfig(path)
+	if err != nil {
+		t.Fatalf("failed to load config: %s", err.Error())
+	}
+	if cfg.Retries != 3 || cfg.Backoff != "1s" || len(cfg.Hooks) != 1 || cfg.Hooks[0].Namespaces[0] != "orders" {
+		t.Fatalf("wrong config: %+v", cfg)
+	}
+
+	ioutil.WriteFile(path, []byte("hooks:\n  - namespaces: [orders]\n"), 0600)
+	if _, err := LoadConfig(path); err == nil {
+		t.Fatalf("expected error for hook without url")
+	}
+	ioutil.WriteFile(path, []byte("hook:\n  - url: http://cache\n"), 0600)
+	if _, err := LoadConfig(path); err == nil {
+		t.Fatalf("expected error for unknown key")
+	}
+}
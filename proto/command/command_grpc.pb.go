@@ -24,6 +24,8 @@ type CasbinMeshClient interface {
 	ListPolicies(ctx context.Context, in *ListPoliciesRequest, opts ...grpc.CallOption) (*ListPoliciesResponse, error)
 	Request(ctx context.Context, in *Command, opts ...grpc.CallOption) (*Response, error)
 	Enforce(ctx context.Context, in *EnforceRequest, opts ...grpc.CallOption) (*EnforceResponse, error)
+	Watch(ctx context.Context, in *WatchRequest, opts ...grpc.CallOption) (CasbinMesh_WatchClient, error)
+	Snapshot(ctx context.Context, in *SnapshotRequest, opts ...grpc.CallOption) (*SnapshotResponse, error)
 }
 
 type casbinMeshClient struct {
@@ -88,6 +90,47 @@ func (c *casbinMeshClient) Enforce(ctx context.Context, in *EnforceRequest, opts
 	return out, nil
 }
 
+func (c *casbinMeshClient) Watch(ctx context.Context, in *WatchRequest, opts ...grpc.CallOption) (CasbinMesh_WatchClient, error) {
+	stream, err := c.cc.NewStream(ctx, &CasbinMesh_ServiceDesc.Streams[0], "/command.CasbinMesh/Watch", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &casbinMeshWatchClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type CasbinMesh_WatchClient interface {
+	Recv() (*WatchEvent, error)
+	grpc.ClientStream
+}
+
+type casbinMeshWatchClient struct {
+	grpc.ClientStream
+}
+
+func (x *casbinMeshWatchClient) Recv() (*WatchEvent, error) {
+	m := new(WatchEvent)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *casbinMeshClient) Snapshot(ctx context.Context, in *SnapshotRequest, opts ...grpc.CallOption) (*SnapshotResponse, error) {
+	out := new(SnapshotResponse)
+	err := c.cc.Invoke(ctx, "/command.CasbinMesh/Snapshot", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // CasbinMeshServer is the server API for CasbinMesh service.
 // All implementations must embed UnimplementedCasbinMeshServer
 // for forward compatibility
@@ -98,6 +141,8 @@ type CasbinMeshServer interface {
 	ListPolicies(context.Context, *ListPoliciesRequest) (*ListPoliciesResponse, error)
 	Request(context.Context, *Command) (*Response, error)
 	Enforce(context.Context, *EnforceRequest) (*EnforceResponse, error)
+	Watch(*WatchRequest, CasbinMesh_WatchServer) error
+	Snapshot(context.Context, *SnapshotRequest) (*SnapshotResponse, error)
 	mustEmbedUnimplementedCasbinMeshServer()
 }
 
@@ -123,6 +168,12 @@ func (UnimplementedCasbinMeshServer) Request(context.Context, *Command) (*Respon
 func (UnimplementedCasbinMeshServer) Enforce(context.Context, *EnforceRequest) (*EnforceResponse, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method Enforce not implemented")
 }
+func (UnimplementedCasbinMeshServer) Watch(*WatchRequest, CasbinMesh_WatchServer) error {
+	return status.Errorf(codes.Unimplemented, "method Watch not implemented")
+}
+func (UnimplementedCasbinMeshServer) Snapshot(context.Context, *SnapshotRequest) (*SnapshotResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Snapshot not implemented")
+}
 func (UnimplementedCasbinMeshServer) mustEmbedUnimplementedCasbinMeshServer() {}
 
 // UnsafeCasbinMeshServer may be embedded to opt out of forward compatibility for this service.
@@ -244,6 +295,45 @@ func _CasbinMesh_Enforce_Handler(srv interface{}, ctx context.Context, dec func(
 	return interceptor(ctx, in, info, handler)
 }
 
+func _CasbinMesh_Watch_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(WatchRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(CasbinMeshServer).Watch(m, &casbinMeshWatchServer{stream})
+}
+
+type CasbinMesh_WatchServer interface {
+	Send(*WatchEvent) error
+	grpc.ServerStream
+}
+
+type casbinMeshWatchServer struct {
+	grpc.ServerStream
+}
+
+func (x *casbinMeshWatchServer) Send(m *WatchEvent) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _CasbinMesh_Snapshot_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SnapshotRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CasbinMeshServer).Snapshot(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/command.CasbinMesh/Snapshot",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CasbinMeshServer).Snapshot(ctx, req.(*SnapshotRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 // CasbinMesh_ServiceDesc is the grpc.ServiceDesc for CasbinMesh service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
@@ -275,7 +365,17 @@ var CasbinMesh_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "Enforce",
 			Handler:    _CasbinMesh_Enforce_Handler,
 		},
+		{
+			MethodName: "Snapshot",
+			Handler:    _CasbinMesh_Snapshot_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "Watch",
+			Handler:       _CasbinMesh_Watch_Handler,
+			ServerStreams: true,
+		},
 	},
-	Streams:  []grpc.StreamDesc{},
 	Metadata: "command.proto",
 }